@@ -2,19 +2,19 @@ package cmd
 
 import (
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 
-	"github.com/neocortical/got/blob"
 	"github.com/neocortical/got/index"
-	"github.com/neocortical/got/object"
+	"github.com/neocortical/got/pathspec"
 	"github.com/neocortical/got/repository"
+	"github.com/neocortical/got/workspace"
 	"github.com/spf13/cobra"
 )
 
 var addCmd = &cobra.Command{
-	Use:   "add [file1, file2, ...]",
+	Use:   "add <pathspec>...",
 	Short: "Add files/directories to the index.",
 	RunE:  executeAdd,
 }
@@ -26,51 +26,47 @@ func executeAdd(cmd *cobra.Command, args []string) (err error) {
 	db := repo.Database()
 	idx := repo.Index()
 
+	if len(args) == 0 {
+		fmt.Fprintln(stderr, "Nothing specified, nothing added.")
+		return nil
+	}
+
+	ps, err := parsePathspec(args)
+	if err != nil {
+		return err
+	}
+
+	jobs, err := hashJobs(repo)
+	if err != nil {
+		return err
+	}
+
 	err = idx.LoadForUpdate()
 	if err != nil {
 		return fmt.Errorf("error loading index: %w", err)
 	}
 
-	for _, filename := range args {
-		fullPath := toAbsolutePath(filename)
-		fileInfo, err := os.Stat(fullPath)
-		if os.IsNotExist(err) {
-			idx.Rollback()
-			return fmt.Errorf("pathspec '%s' did not match any files", filename)
-		}
-		if err != nil {
-			idx.Rollback()
-			return fmt.Errorf("unexpected error adding file: %w", err)
-		}
+	files, err := matchWorkspaceFiles(ps)
+	if err != nil {
+		idx.Rollback()
+		return err
+	}
+	if unmatched := ps.Unmatched(); len(unmatched) > 0 {
+		idx.Rollback()
+		return fmt.Errorf("pathspec '%s' did not match any files", unmatched[0])
+	}
 
-		if fileInfo.IsDir() {
-			filepath.Walk(fullPath, func(path string, info os.FileInfo, err error) error {
-				if err != nil {
-					return err
-				}
-
-				if info.IsDir() {
-					if info.Name() == repository.GitDir {
-						return filepath.SkipDir
-					}
-					return nil
-				}
-
-				err = addToIndex(db, idx, path, info)
-				if err != nil {
-					idx.Rollback()
-					return fmt.Errorf("error adding file '%s' to index: %w", path, err)
-				}
-
-				return nil
-			})
-		} else {
-			err = addToIndex(db, idx, filename, fileInfo)
-			if err != nil {
-				idx.Rollback()
-				return fmt.Errorf("error adding file '%s' to index: %w", filename, err)
-			}
+	err = workspace.HashFiles(db, files, jobs, func(result workspace.HashResult) error {
+		if result.Err != nil {
+			return fmt.Errorf("error adding file '%s' to index: %w", toRelativePath(result.Path), result.Err)
 		}
+
+		idx.Add(index.NewEntry(toRelativePath(result.Path), result.OID, result.Info))
+		return nil
+	})
+	if err != nil {
+		idx.Rollback()
+		return err
 	}
 
 	err = idx.WriteUpdates()
@@ -82,22 +78,35 @@ func executeAdd(cmd *cobra.Command, args []string) (err error) {
 	return nil
 }
 
-func addToIndex(db object.Database, idx index.Index, filename string, info os.FileInfo) (err error) {
-	data, err := ioutil.ReadFile(toAbsolutePath(filename))
-	if err != nil {
-		idx.Rollback()
-		return fmt.Errorf("error reading file '%s': %w", filename, err)
-	}
+// matchWorkspaceFiles lists the workspace files selected by a pathspec, only
+// walking the parts of the workspace the pathspec is confined to.
+func matchWorkspaceFiles(ps *pathspec.Pathspec) (result []workspace.File, err error) {
+	seen := map[string]bool{}
+
+	for _, root := range ps.Roots() {
+		fullPath := toAbsolutePath(root)
+		if _, err := os.Stat(fullPath); os.IsNotExist(err) {
+			continue
+		}
 
-	b := blob.New(data)
+		files, err := workspace.ListFiles(fullPath)
+		if err != nil {
+			return nil, fmt.Errorf("error listing files in '%s': %w", root, err)
+		}
 
-	oid, err := db.Store(b)
-	if err != nil {
-		idx.Rollback()
-		return fmt.Errorf("error storing blob '%s': %w", filename, err)
+		for _, f := range files {
+			relativePath := filepath.ToSlash(toRelativePath(f.Path))
+			if seen[relativePath] || !ps.Match(relativePath, false) {
+				continue
+			}
+			seen[relativePath] = true
+			result = append(result, f)
+		}
 	}
 
-	idx.Add(index.NewEntry(toRelativePath(filename), oid, info))
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Path < result[j].Path
+	})
 
-	return nil
+	return
 }
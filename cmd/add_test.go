@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/neocortical/got/repository"
+)
+
+func TestAddWithParallelHashing(t *testing.T) {
+	_, errbuf := setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+
+	initOrDie(t)
+	writeFile(t, ".git/config", "[index]\n\tthreads = 4\n")
+
+	var expected []string
+	for i := 0; i < 50; i++ {
+		filename := fmt.Sprintf("dir%d/file%02d.txt", i%5, i)
+		writeFile(t, filename, filename)
+		expected = append(expected, filename)
+	}
+
+	err := executeAdd(addCmd, []string{"."})
+	if err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	if errbuf.Len() > 0 {
+		t.Errorf("expected no error output but got: %s", errbuf.String())
+	}
+
+	idx := repository.NewRepo(wd).Index()
+	if err = idx.Load(); err != nil {
+		t.Fatalf("error loading index: %v", err)
+	}
+
+	entries := idx.Entries()
+	if len(entries) != len(expected) {
+		t.Fatalf("expected %d entries but got %d", len(expected), len(entries))
+	}
+	for _, e := range entries {
+		if e.OID() == "" {
+			t.Errorf("expected an OID for %s", e.Path())
+		}
+	}
+}
+
+func TestHashJobs(t *testing.T) {
+	var tests = []struct {
+		value    string
+		expected int
+		err      bool
+	}{
+		{"", 0, false},
+		{"true", 0, false},
+		{"false", 1, false},
+		{"1", 1, false},
+		{"8", 8, false},
+		{"-2", 0, true},
+		{"lots", 0, true},
+	}
+
+	for i, test := range tests {
+		setUpTestWorkspace(t, nil)
+		initOrDie(t)
+		if test.value != "" {
+			writeFile(t, ".git/config", "[index]\n\tthreads = ", test.value, "\n")
+		}
+
+		actual, err := hashJobs(repository.NewRepo(wd))
+		if (err != nil) != test.err {
+			t.Errorf("test %d failed: unexpected error result: %v", i, err)
+		}
+		if actual != test.expected {
+			t.Errorf("test %d failed: expected %d but got %d", i, test.expected, actual)
+		}
+		tearDownTestWorkspace()
+	}
+}
+
+func TestAddWithPathspecMagic(t *testing.T) {
+	setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+
+	initOrDie(t)
+	writeFile(t, "main.c", "main")
+	writeFile(t, "lib/util.c", "util")
+	writeFile(t, "lib/util.h", "header")
+	writeFile(t, "lib/gen/table.c", "generated")
+	writeFile(t, "README", "readme")
+
+	err := executeAdd(addCmd, []string{"*.c", ":!lib/gen"})
+	if err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+
+	idx := repository.NewRepo(wd).Index()
+	if err = idx.Load(); err != nil {
+		t.Fatalf("error loading index: %v", err)
+	}
+
+	var actual []string
+	for _, e := range idx.Entries() {
+		actual = append(actual, e.Path())
+	}
+
+	expected := []string{"lib/util.c", "main.c"}
+	if fmt.Sprint(actual) != fmt.Sprint(expected) {
+		t.Errorf("expected entries %v but got %v", expected, actual)
+	}
+}
+
+func TestAddFromSubdirectory(t *testing.T) {
+	setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+
+	initOrDie(t)
+	writeFile(t, "top.c", "top")
+	writeFile(t, "lib/util.c", "util")
+	writeFile(t, "lib/util.h", "header")
+	writeFile(t, "lib/gen/table.c", "generated")
+
+	root := wd
+	SetWd(filepath.Join(root, "lib"))
+	if wd != root || prefix != "lib" {
+		t.Fatalf("expected to run from %s in lib but got %s in '%s'", root, wd, prefix)
+	}
+
+	err := executeAdd(addCmd, []string{"*.c", ":!gen", ":/top.c"})
+	if err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+
+	expected := map[string]string{"lib/util.c": blobOID("util"), "top.c": blobOID("top")}
+	if actual := indexFiles(t); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected entries %v but got %v", expected, actual)
+	}
+}
+
+func TestAddUnmatchedPathspec(t *testing.T) {
+	setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+
+	initOrDie(t)
+	writeFile(t, "file.txt", "content")
+
+	err := executeAdd(addCmd, []string{"file.txt", "missing*.txt"})
+	if err == nil || err.Error() != "pathspec 'missing*.txt' did not match any files" {
+		t.Errorf("expected an unmatched pathspec error but got: %v", err)
+	}
+
+	idx := repository.NewRepo(wd).Index()
+	if err = idx.Load(); err != nil {
+		t.Fatalf("error loading index: %v", err)
+	}
+	if len(idx.Entries()) != 0 {
+		t.Error("expected nothing to be added when a pathspec fails to match")
+	}
+}
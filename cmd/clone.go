@@ -0,0 +1,704 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/neocortical/got/fsck"
+	"github.com/neocortical/got/hook"
+	"github.com/neocortical/got/object"
+	"github.com/neocortical/got/objectformat"
+	"github.com/neocortical/got/ref"
+	"github.com/neocortical/got/remote"
+	"github.com/neocortical/got/repository"
+	"github.com/neocortical/got/revision"
+	"github.com/neocortical/got/workspace"
+	"github.com/spf13/cobra"
+)
+
+const (
+	originRemote   = "origin"
+	remotesPrefix  = "refs/remotes/"
+	tagsRefsPrefix = "refs/tags/"
+)
+
+var (
+	cloneCmd = &cobra.Command{
+		Use:   "clone [--bare] [--branch <name>] [--depth <depth>] [--shallow-since <date>] [--filter <filter-spec>] [--no-checkout] [--no-hardlinks] [--shared] [--reference <repository>] <repository> [<directory>]",
+		Short: "Clone a repository on the local filesystem into a new directory.",
+		Args:  cobra.RangeArgs(1, 2),
+		RunE:  executeClone,
+	}
+	cloneBare         bool
+	cloneBranch       string
+	cloneDepth        int
+	cloneShallowSince string
+	cloneFilterSpec   string
+	cloneNoCheckout   bool
+	cloneNoHardlinks  bool
+	cloneShared       bool
+	cloneReferences   []string
+
+	// the history --depth and --shallow-since limit a clone to, and the
+	// --filter it's made with
+	cloneShallow remote.ShallowOptions
+	cloneFilter  *remote.Filter
+)
+
+func init() {
+	cloneCmd.Flags().BoolVar(&cloneBare, "bare", false, "Make a bare repository, holding the source's branches as they are")
+	cloneCmd.Flags().StringVarP(&cloneBranch, "branch", "b", "", "Check out this branch instead of the one the source's HEAD points to, or detach HEAD at this tag")
+	cloneCmd.Flags().IntVar(&cloneDepth, "depth", 0, "Copy only this many commits of the history of the branch checked out")
+	cloneCmd.Flags().StringVar(&cloneShallowSince, "shallow-since", "", "Copy only the history of the branch checked out made after this date")
+	cloneCmd.Flags().StringVar(&cloneFilterSpec, "filter", "", "Make a partial clone, leaving out the objects this filter-spec picks until they're needed")
+	cloneCmd.Flags().BoolVarP(&cloneNoCheckout, "no-checkout", "n", false, "Don't check out HEAD")
+	cloneCmd.Flags().BoolVar(&cloneNoHardlinks, "no-hardlinks", false, "Copy object files instead of hardlinking them")
+	cloneCmd.Flags().BoolVarP(&cloneShared, "shared", "s", false, "Borrow the source repository's objects through alternates instead of copying them")
+	cloneCmd.Flags().StringArrayVar(&cloneReferences, "reference", nil, "Borrow objects from another local repository through alternates, copying only what it lacks")
+}
+
+// cloneSource is a repository being cloned, opened through its git
+// directory.
+type cloneSource struct {
+	url    string
+	repo   *repository.Repo
+	format *objectformat.Format
+}
+
+// cloneHead is what a clone's HEAD starts out as.
+type cloneHead struct {
+	// ref is the source ref the clone follows: a branch for HEAD to point to,
+	// or a tag to detach HEAD at. It's empty if the source's HEAD is detached.
+	ref string
+	// oid is the ref's value, empty for an unborn branch, and commit is what
+	// it peels to.
+	oid    string
+	commit string
+}
+
+func (h cloneHead) branch() string {
+	if strings.HasPrefix(h.ref, ref.HeadsPrefix) {
+		return h.ref
+	}
+
+	return ""
+}
+
+// findGitDir returns the git directory of the repository at p, which may be
+// a workspace or a git directory itself.
+func findGitDir(p string) (string, error) {
+	for _, dir := range []string{filepath.Join(p, repository.GitDir), p} {
+		if _, err := os.Stat(filepath.Join(dir, "HEAD")); err != nil {
+			continue
+		}
+		if stat, err := os.Stat(filepath.Join(dir, "objects")); err == nil && stat.IsDir() {
+			return dir, nil
+		}
+	}
+
+	return "", fmt.Errorf("repository '%s' does not exist", p)
+}
+
+func openCloneSource(p string) (*cloneSource, error) {
+	gitDir, err := findGitDir(p)
+	if err != nil {
+		return nil, err
+	}
+
+	repo := repository.NewBareRepo(gitDir)
+	cfg, err := repo.Config()
+	if err != nil {
+		return nil, err
+	}
+	format, err := repository.ConfigObjectFormat(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cloneSource{
+		url:    p,
+		repo:   repo,
+		format: format,
+	}, nil
+}
+
+// resolveCloneHead finds the source ref named by --branch, a branch or else
+// a tag, or the branch the source's HEAD points to.
+func resolveCloneHead(source *cloneSource) (result cloneHead, err error) {
+	refs := source.repo.Refs()
+
+	if cloneBranch == "" {
+		if result.ref, err = refs.ReadSymbolicRef("HEAD"); err == nil {
+			result.oid, err = refs.ReadHead()
+		}
+		if err != nil {
+			return result, fmt.Errorf("error reading HEAD of '%s': %w", source.url, err)
+		}
+	} else {
+		for _, name := range []string{ref.HeadsPrefix + cloneBranch, tagsRefsPrefix + cloneBranch} {
+			oid, err := refs.ReadRef(name)
+			if err != nil {
+				return result, fmt.Errorf("error reading %s of '%s': %w", name, source.url, err)
+			}
+			if oid != "" {
+				result.ref, result.oid = name, oid
+				break
+			}
+		}
+		if result.oid == "" {
+			return result, fmt.Errorf("Remote branch %s not found in upstream %s", cloneBranch, originRemote)
+		}
+	}
+
+	if result.oid != "" {
+		result.commit, err = peel(source.repo.Database(), source.format, result.oid)
+	}
+
+	return result, err
+}
+
+// readLinks reads an object and returns its type and the objects it refers
+// to.
+func readLinks(db object.Database, f *objectformat.Format, oid string) (string, []fsck.Link, error) {
+	obj, err := db.Read(oid)
+	if err != nil {
+		return "", nil, fmt.Errorf("error reading object %s: %w", oid, err)
+	}
+
+	links, _ := fsck.CheckObject(f, obj.Type(), obj.Serialize())
+	return obj.Type(), links, nil
+}
+
+// peelTags follows tags to the object they point at, returning the chain of
+// tags along with it.
+func peelTags(db object.Database, f *objectformat.Format, oid string) (target string, tags []string, err error) {
+	for {
+		objectType, links, err := readLinks(db, f, oid)
+		if err != nil {
+			return "", nil, err
+		}
+		if objectType != "tag" {
+			return oid, tags, nil
+		}
+		if len(links) == 0 {
+			return "", nil, fmt.Errorf("bad tag %s", oid)
+		}
+
+		tags = append(tags, oid)
+		oid = links[0].OID
+	}
+}
+
+// peel follows tags to the object they point at.
+func peel(db object.Database, f *objectformat.Format, oid string) (string, error) {
+	target, _, err := peelTags(db, f, oid)
+	return target, err
+}
+
+// defaultCloneDir names a clone after its source, without any .git suffix.
+func defaultCloneDir(source string) string {
+	source = strings.TrimSuffix(filepath.Clean(source), string(filepath.Separator)+repository.GitDir)
+	return strings.TrimSuffix(filepath.Base(source), ".git")
+}
+
+func executeClone(cmd *cobra.Command, args []string) (err error) {
+	if cloneDepth < 0 {
+		return fmt.Errorf("depth %d is not a positive number", cloneDepth)
+	}
+	cloneShallow = remote.ShallowOptions{Depth: cloneDepth}
+	if cloneShallowSince != "" {
+		if cloneShallow.Since, err = revision.ParseDate(cloneShallowSince, time.Now()); err != nil {
+			return err
+		}
+	}
+	cloneFilter = nil
+	if cloneFilterSpec != "" {
+		if cloneFilter, err = remote.ParseFilter(cloneFilterSpec); err != nil {
+			return err
+		}
+	}
+	if cloneShared {
+		switch {
+		case cloneDepth > 0:
+			return fmt.Errorf("--depth and --shared cannot be used together")
+		case cloneShallowSince != "":
+			return fmt.Errorf("--shallow-since and --shared cannot be used together")
+		case cloneFilter != nil:
+			return fmt.Errorf("--filter and --shared cannot be used together")
+		}
+	}
+
+	// the source is a URL, as for fetches, though only a local one
+	sourcePath := remote.LocalPath(args[0], fromCurrentDir("."))
+	source, err := openCloneSource(sourcePath)
+	if err != nil {
+		return err
+	}
+	head, err := resolveCloneHead(source)
+	if err != nil {
+		return err
+	}
+
+	dirArg := defaultCloneDir(sourcePath)
+	if cloneBare {
+		dirArg += ".git"
+	}
+	if len(args) > 1 {
+		dirArg = args[1]
+	}
+	dir := fromCurrentDir(dirArg)
+	if entries, err := ioutil.ReadDir(dir); err == nil && len(entries) > 0 {
+		return fmt.Errorf("destination path '%s' already exists and is not an empty directory", dirArg)
+	}
+
+	if cloneBare {
+		fmt.Fprintf(stderr, "Cloning into bare repository '%s'...\n", dirArg)
+	} else {
+		fmt.Fprintf(stderr, "Cloning into '%s'...\n", dirArg)
+	}
+
+	var repo *repository.Repo
+	if cloneBare {
+		repo, err = repository.InitBare(dir, source.format)
+	} else {
+		repo, err = repository.Init(dir, source.format)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = cloneObjects(repo, source, head); err != nil {
+		return err
+	}
+	if err = cloneRefs(repo, source, head); err != nil {
+		return err
+	}
+
+	if head.oid == "" {
+		fmt.Fprintln(stderr, "warning: You appear to have cloned an empty repository.")
+		return nil
+	}
+	if cloneBare || cloneNoCheckout {
+		return nil
+	}
+	if err = checkoutHead(repo, head.commit); err != nil {
+		return err
+	}
+
+	// a clone checks out a branch from nothing
+	return hook.Run(repo, hook.PostCheckout, hook.Options{
+		Args:   []string{repo.ObjectFormat().ZeroOID(), head.commit, "1"},
+		Output: stderr,
+	})
+}
+
+// cloneObjects gives the new repository the source's objects: by borrowing
+// them with --shared, or else by copying them, leaving out any that a
+// --reference repository has. With --depth or --shallow-since, only the
+// history checked out is copied, and with --filter, only what the filter
+// keeps.
+func cloneObjects(repo *repository.Repo, source *cloneSource, head cloneHead) error {
+	var alternates []string
+	var references []object.Database
+
+	for _, reference := range cloneReferences {
+		reference = fromCurrentDir(reference)
+		gitDir, err := findGitDir(reference)
+		if err != nil {
+			return fmt.Errorf("reference %w", err)
+		}
+
+		objectsDir := filepath.Join(gitDir, "objects")
+		db := object.NewDatabase(objectsDir)
+		db.SetObjectFormat(source.format)
+		alternates = append(alternates, objectsDir)
+		references = append(references, db)
+	}
+
+	skip := func(oid string) bool {
+		for _, db := range references {
+			if db.Has(oid) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch {
+	case cloneShared:
+		alternates = append([]string{source.repo.ObjectsDir()}, alternates...)
+	case !cloneShallow.Limited():
+		// whatever the source borrows, the clone must too
+		sourceAlternates, err := object.ReadAlternates(source.repo.ObjectsDir())
+		if err != nil {
+			return err
+		}
+		alternates = append(alternates, sourceAlternates...)
+	}
+
+	// before the database is opened, so that it reads through them, and
+	// fetches what the filter leaves out
+	if len(alternates) > 0 {
+		if err := object.WriteAlternates(repo.ObjectsDir(), alternates); err != nil {
+			return err
+		}
+	}
+	if cloneFilter != nil {
+		if err := setUpPartialClone(repo); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case cloneShared:
+		return nil
+	case cloneShallow.Limited(), cloneFilter != nil:
+		return copyHistory(repo, source, head, skip)
+	}
+
+	return copyObjects(source.repo.ObjectsDir(), repo.ObjectsDir(), skip)
+}
+
+// setUpPartialClone configures a clone made with --filter to fetch the
+// objects the filter leaves out from origin when they're needed.
+func setUpPartialClone(repo *repository.Repo) error {
+	cfg, err := repo.Config()
+	if err != nil {
+		return err
+	}
+
+	remoteKey := "remote." + originRemote
+	for _, setting := range [][2]string{
+		{"core.repositoryformatversion", "1"},
+		{"extensions.partialclone", originRemote},
+		{remoteKey + ".promisor", "true"},
+		{remoteKey + ".partialclonefilter", cloneFilter.String()},
+	} {
+		if err = cfg.Set(setting[0], setting[1]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// copyHistory copies the objects reachable from the source's refs one by
+// one. With --depth or --shallow-since, only those of the history leading up
+// to the clone's HEAD, and of the tags pointing into it, are copied, and the
+// oldest commits are recorded as the clone's shallow boundary. With
+// --filter, the objects the filter leaves out are recorded as promised
+// instead.
+func copyHistory(repo *repository.Repo, source *cloneSource, head cloneHead, skip func(oid string) bool) error {
+	var tips []string
+	if cloneShallow.Limited() {
+		if head.commit == "" {
+			return nil
+		}
+		tips = append(tips, head.commit)
+	} else {
+		refs, err := source.repo.Refs().List("refs/")
+		if err != nil {
+			return fmt.Errorf("error reading refs of '%s': %w", source.url, err)
+		}
+		for _, r := range refs {
+			tips = append(tips, r.OID)
+		}
+	}
+
+	objects, shallow, err := remote.HistoryObjects(source.repo, tips, cloneShallow, func(string) bool { return false })
+	if err != nil {
+		return err
+	}
+
+	copied := map[string]bool{}
+	for _, oid := range objects {
+		copied[oid] = true
+	}
+
+	db := source.repo.Database()
+	tags, err := source.repo.Refs().List(tagsRefsPrefix)
+	if err != nil {
+		return fmt.Errorf("error reading refs of '%s': %w", source.url, err)
+	}
+	for _, tag := range tags {
+		target, chain, err := peelTags(db, source.format, tag.OID)
+		if err != nil {
+			return err
+		}
+		if !copied[target] {
+			continue
+		}
+		for _, oid := range chain {
+			if !copied[oid] {
+				copied[oid] = true
+				objects = append(objects, oid)
+			}
+		}
+	}
+
+	var omitted []string
+	if cloneFilter != nil {
+		if objects, omitted, err = remote.FilterObjects(source.repo, objects, tips, cloneFilter); err != nil {
+			return err
+		}
+	}
+
+	for _, oid := range objects {
+		if skip(oid) {
+			continue
+		}
+		obj, err := db.Read(oid)
+		if err != nil {
+			return fmt.Errorf("error reading object %s: %w", oid, err)
+		}
+		if _, err = repo.Database().Store(obj); err != nil {
+			return fmt.Errorf("error copying object %s: %w", oid, err)
+		}
+	}
+
+	if err = repo.AddPromisorObjects(omitted); err != nil {
+		return err
+	}
+
+	return repo.SetShallow(shallow)
+}
+
+// copyObjects copies loose objects, except those skip returns true for, and
+// packs from one object directory to another, hardlinking them unless
+// --no-hardlinks is given.
+func copyObjects(from string, to string, skip func(oid string) bool) error {
+	fanouts, err := ioutil.ReadDir(from)
+	if err != nil {
+		return fmt.Errorf("error reading objects: %w", err)
+	}
+
+	for _, fanout := range fanouts {
+		if !fanout.IsDir() || len(fanout.Name()) != 2 {
+			continue
+		}
+
+		files, err := ioutil.ReadDir(filepath.Join(from, fanout.Name()))
+		if err != nil {
+			return fmt.Errorf("error reading objects: %w", err)
+		}
+		for _, file := range files {
+			if skip(fanout.Name() + file.Name()) {
+				continue
+			}
+			if err = linkFile(filepath.Join(from, fanout.Name(), file.Name()), filepath.Join(to, fanout.Name(), file.Name())); err != nil {
+				return err
+			}
+		}
+	}
+
+	packs, err := filepath.Glob(filepath.Join(from, object.PackDir, "pack-*"))
+	if err != nil {
+		return err
+	}
+	for _, p := range packs {
+		if err = linkFile(p, filepath.Join(to, object.PackDir, filepath.Base(p))); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// linkFile hardlinks an object file, falling back to copying it when the
+// link fails, as it does across filesystems.
+func linkFile(from string, to string) error {
+	if !cloneNoHardlinks {
+		if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+			return fmt.Errorf("error copying objects: %w", err)
+		}
+		if err := os.Link(from, to); err == nil {
+			return nil
+		}
+	}
+
+	return copyFile(from, to)
+}
+
+func copyFile(from string, to string) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return fmt.Errorf("error copying objects: %w", err)
+	}
+	defer in.Close()
+
+	if err = os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return fmt.Errorf("error copying objects: %w", err)
+	}
+	out, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0444)
+	if err != nil {
+		return fmt.Errorf("error copying objects: %w", err)
+	}
+
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("error copying objects: %w", err)
+	}
+
+	return nil
+}
+
+// cloneRefs sets up the origin remote, copies the source's branches to
+// remote-tracking refs and its tags as they are, and points HEAD at the
+// branch followed, creating it locally. Bare clones copy branches as they are
+// instead, and with --depth or --shallow-since only the branch followed and
+// the tags copied along with it are.
+func cloneRefs(repo *repository.Repo, source *cloneSource, head cloneHead) error {
+	cfg, err := repo.Config()
+	if err != nil {
+		return err
+	}
+	remoteKey := "remote." + originRemote
+	if err = cfg.Set(remoteKey+".url", source.url); err != nil {
+		return err
+	}
+	if !cloneBare {
+		if err = cfg.Set(remoteKey+".fetch", cloneFetchRefspec(head)); err != nil {
+			return err
+		}
+	}
+
+	refs, err := source.repo.Refs().List("refs/")
+	if err != nil {
+		return fmt.Errorf("error reading refs of '%s': %w", source.url, err)
+	}
+
+	message := "clone: from " + source.url
+	committer := committerIdentity()
+	t := repo.Refs().Transaction(committer)
+	for _, r := range refs {
+		name := cloneRefName(r.Name, head)
+		if name == "" || (cloneShallow.Limited() && !repo.Database().Has(r.OID)) {
+			continue
+		}
+		if err = t.Create(name, r.OID, message); err != nil {
+			t.Abort()
+			return err
+		}
+	}
+	if err = t.Commit(); err != nil {
+		return fmt.Errorf("error writing refs: %w", err)
+	}
+
+	branch := head.branch()
+	if branch == "" {
+		if head.commit == "" {
+			return nil
+		}
+		t = repo.Refs().Transaction(committer)
+		if err = t.Add(ref.RefUpdate{Name: "HEAD", NewOID: head.commit, Message: message, NoDeref: true}); err != nil {
+			return err
+		}
+		return t.Commit()
+	}
+
+	if err = repo.Refs().WriteSymbolicRef("HEAD", branch, committer, ""); err != nil {
+		return err
+	}
+	if head.oid == "" || cloneBare {
+		return nil
+	}
+
+	if err = writeRemoteHead(repo, source, head); err != nil {
+		return err
+	}
+	if err = repo.Refs().UpdateHead(head.oid, committer, message); err != nil {
+		return err
+	}
+
+	branchKey := "branch." + strings.TrimPrefix(branch, ref.HeadsPrefix)
+	if err = cfg.Set(branchKey+".remote", originRemote); err != nil {
+		return err
+	}
+
+	return cfg.Set(branchKey+".merge", branch)
+}
+
+// cloneFetchRefspec returns the refs fetching from origin updates: every
+// branch, or in a shallow clone just the one followed.
+func cloneFetchRefspec(head cloneHead) string {
+	if cloneShallow.Limited() && head.ref != "" {
+		return fmt.Sprintf("+%s:%s", head.ref, cloneRefName(head.ref, head))
+	}
+
+	return fmt.Sprintf("+%s*:%s%s/*", ref.HeadsPrefix, remotesPrefix, originRemote)
+}
+
+// cloneRefName returns the name a source ref is copied to, or an empty string
+// if it isn't copied.
+func cloneRefName(name string, head cloneHead) string {
+	switch {
+	case strings.HasPrefix(name, ref.HeadsPrefix):
+		if cloneShallow.Limited() && name != head.ref {
+			return ""
+		}
+		if cloneBare {
+			return name
+		}
+		return remotesPrefix + originRemote + "/" + strings.TrimPrefix(name, ref.HeadsPrefix)
+	case strings.HasPrefix(name, tagsRefsPrefix):
+		return name
+	}
+
+	return ""
+}
+
+// writeRemoteHead points origin's HEAD at the remote-tracking ref of the
+// branch the source's HEAD points to, if that was copied.
+func writeRemoteHead(repo *repository.Repo, source *cloneSource, head cloneHead) error {
+	target, err := source.repo.Refs().ReadSymbolicRef("HEAD")
+	if err != nil {
+		return fmt.Errorf("error reading HEAD of '%s': %w", source.url, err)
+	}
+	if !strings.HasPrefix(target, ref.HeadsPrefix) {
+		return nil
+	}
+
+	tracking := cloneRefName(target, head)
+	if tracking == "" {
+		return nil
+	}
+	if oid, err := repo.Refs().ReadRef(tracking); err != nil || oid == "" {
+		return err
+	}
+
+	return repo.Refs().WriteSymbolicRef(remotesPrefix+originRemote+"/HEAD", tracking, committerIdentity(), "")
+}
+
+// checkoutHead fills the workspace and index from the commit HEAD points to.
+func checkoutHead(repo *repository.Repo, head string) error {
+	obj, err := repo.Database().Read(head)
+	if err != nil {
+		return fmt.Errorf("error reading commit %s: %w", head, err)
+	}
+	commit, err := ref.DeserializeCommit(obj.Serialize())
+	if err != nil {
+		return fmt.Errorf("error parsing commit %s: %w", head, err)
+	}
+
+	idx := repo.Index()
+	if err = idx.LoadForUpdate(); err != nil {
+		return fmt.Errorf("error loading index: %w", err)
+	}
+	if err = workspace.Checkout(repo.Database(), repo.ObjectFormat(), commit.TreeOID, repo.WorkspaceDir(), idx); err != nil {
+		idx.Rollback()
+		return err
+	}
+	if err = idx.WriteUpdates(); err != nil {
+		return fmt.Errorf("error writing index: %w", err)
+	}
+
+	return nil
+}
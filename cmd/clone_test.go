@@ -0,0 +1,511 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/neocortical/got/blob"
+	"github.com/neocortical/got/fsck"
+	"github.com/neocortical/got/object"
+	"github.com/neocortical/got/objectformat"
+	"github.com/neocortical/got/repository"
+)
+
+func resetCloneFlags() {
+	cloneBare = false
+	cloneBranch = ""
+	cloneDepth = 0
+	cloneShallowSince = ""
+	cloneFilterSpec = ""
+	cloneNoCheckout = false
+	cloneNoHardlinks = false
+	cloneShared = false
+	cloneReferences = nil
+}
+
+func countLooseObjects(t *testing.T, objectsDir string) int {
+	matches, err := filepath.Glob(filepath.Join(objectsDir, "??", "*"))
+	if err != nil {
+		t.Fatalf("error listing objects: %v", err)
+	}
+	return len(matches)
+}
+
+func checkClone(t *testing.T, dir string, head string) *repository.Repo {
+	repo := repository.NewRepo(dir)
+
+	oid, err := repo.Refs().ReadHead()
+	if err != nil || oid != head {
+		t.Fatalf("expected HEAD %s but got: %s (%v)", head, oid, err)
+	}
+	for _, name := range []string{"refs/heads/master", "refs/remotes/origin/master", "refs/remotes/origin/HEAD"} {
+		if oid, _ := repo.Refs().ReadRef(name); oid != head {
+			t.Errorf("expected %s to be %s but got: '%s'", name, head, oid)
+		}
+	}
+
+	cfg, err := repo.Config()
+	if err != nil {
+		t.Fatalf("error reading config: %v", err)
+	}
+	if value, _ := cfg.Get("branch.master.remote"); value != "origin" {
+		t.Errorf("expected branch.master.remote origin but got: '%s'", value)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "foo", "bar.txt"))
+	if err != nil || string(data) != "two" {
+		t.Errorf("expected foo/bar.txt to be checked out but got: '%s' (%v)", data, err)
+	}
+
+	report, err := fsck.Check(repo, fsck.Options{})
+	if err != nil {
+		t.Fatalf("error checking clone: %v", err)
+	}
+	if !report.OK() || len(report.Dangling) > 0 {
+		t.Errorf("expected a clean clone but got: %+v", report)
+	}
+
+	return repo
+}
+
+func TestClone(t *testing.T) {
+	_, errbuf := setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+
+	initOrDie(t)
+	writeFile(t, "foo.txt", "one")
+	writeFile(t, "foo/bar.txt", "two")
+	commitOrDie(t, "first")
+	head, _ := repository.NewRepo(wd).Refs().ReadHead()
+
+	err := executeClone(cloneCmd, []string{wd, "copy"})
+	if err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	if errbuf.String() != "Cloning into 'copy'...\n" {
+		t.Errorf("unexpected output: '%s'", errbuf.String())
+	}
+
+	dir := filepath.Join(wd, "copy")
+	checkClone(t, dir, head)
+	if n := countLooseObjects(t, filepath.Join(dir, repository.GitDir, "objects")); n != 5 {
+		t.Errorf("expected 5 copied objects but got %d", n)
+	}
+
+	// objects are hardlinked
+	sourceObject := filepath.Join(wd, repository.GitDir, "objects", head[:2], head[2:])
+	cloneObject := filepath.Join(dir, repository.GitDir, "objects", head[:2], head[2:])
+	sourceInfo, _ := os.Stat(sourceObject)
+	cloneInfo, err := os.Stat(cloneObject)
+	if err != nil || !os.SameFile(sourceInfo, cloneInfo) {
+		t.Errorf("expected %s to be hardlinked (%v)", head, err)
+	}
+
+	err = executeClone(cloneCmd, []string{wd, "copy"})
+	if err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("expected an error cloning into a non-empty directory but got: %v", err)
+	}
+}
+
+func TestCloneFileURL(t *testing.T) {
+	setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+
+	initOrDie(t)
+	writeFile(t, "foo/bar.txt", "two")
+	commitOrDie(t, "first")
+	head, _ := repository.NewRepo(wd).Refs().ReadHead()
+
+	if err := executeClone(cloneCmd, []string{"file://" + filepath.ToSlash(wd), "copy"}); err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	checkClone(t, filepath.Join(wd, "copy"), head)
+}
+
+func TestCloneShared(t *testing.T) {
+	setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+	defer resetCloneFlags()
+
+	initOrDie(t)
+	writeFile(t, "foo/bar.txt", "two")
+	commitOrDie(t, "first")
+	head, _ := repository.NewRepo(wd).Refs().ReadHead()
+
+	cloneShared = true
+	err := executeClone(cloneCmd, []string{wd, "shared"})
+	if err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+
+	dir := filepath.Join(wd, "shared")
+	objectsDir := filepath.Join(dir, repository.GitDir, "objects")
+	alternates, err := object.ReadAlternates(objectsDir)
+	if err != nil || len(alternates) != 1 || alternates[0] != filepath.Join(wd, repository.GitDir, "objects") {
+		t.Errorf("expected alternates to point at the source but got: %v (%v)", alternates, err)
+	}
+	if n := countLooseObjects(t, objectsDir); n != 0 {
+		t.Errorf("expected no copied objects but got %d", n)
+	}
+
+	checkClone(t, dir, head)
+}
+
+func TestCloneReference(t *testing.T) {
+	setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+	defer resetCloneFlags()
+
+	initOrDie(t)
+	writeFile(t, "foo/bar.txt", "two")
+	commitOrDie(t, "first")
+
+	// the reference has the first commit only
+	resetCloneFlags()
+	if err := executeClone(cloneCmd, []string{wd, "reference"}); err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	if err := os.RemoveAll(filepath.Join(wd, "reference", "foo")); err != nil {
+		t.Fatalf("error cleaning up: %v", err)
+	}
+
+	writeFile(t, "foo.txt", "one")
+	commitOrDie(t, "second")
+	head, _ := repository.NewRepo(wd).Refs().ReadHead()
+
+	cloneReferences = []string{"reference"}
+	if err := executeClone(cloneCmd, []string{wd, filepath.Join(os.TempDir(), filepath.Base(wd)+"-clone")}); err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	dir := filepath.Join(os.TempDir(), filepath.Base(wd)+"-clone")
+	defer os.RemoveAll(dir)
+
+	// the second commit, its tree and foo.txt
+	if n := countLooseObjects(t, filepath.Join(dir, repository.GitDir, "objects")); n != 3 {
+		t.Errorf("expected 3 copied objects but got %d", n)
+	}
+
+	checkClone(t, dir, head)
+}
+
+func TestCloneNoHardlinks(t *testing.T) {
+	setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+	defer resetCloneFlags()
+
+	initOrDie(t)
+	writeFile(t, "foo/bar.txt", "two")
+	commitOrDie(t, "first")
+	head, _ := repository.NewRepo(wd).Refs().ReadHead()
+
+	cloneNoHardlinks = true
+	if err := executeClone(cloneCmd, []string{wd, "copy"}); err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+
+	sourceInfo, _ := os.Stat(filepath.Join(wd, repository.GitDir, "objects", head[:2], head[2:]))
+	cloneInfo, err := os.Stat(filepath.Join(wd, "copy", repository.GitDir, "objects", head[:2], head[2:]))
+	if err != nil || os.SameFile(sourceInfo, cloneInfo) {
+		t.Errorf("expected %s to be copied (%v)", head, err)
+	}
+
+	checkClone(t, filepath.Join(wd, "copy"), head)
+}
+
+func TestCloneBare(t *testing.T) {
+	_, errbuf := setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+	defer resetCloneFlags()
+
+	initOrDie(t)
+	writeFile(t, "foo/bar.txt", "two")
+	commitOrDie(t, "first")
+	head, _ := repository.NewRepo(wd).Refs().ReadHead()
+	if err := executeUpdateRef(updateRefCmd, []string{"refs/heads/topic", head}); err != nil {
+		t.Fatalf("error creating branch: %v", err)
+	}
+
+	cloneBare = true
+	if err := executeClone(cloneCmd, []string{wd}); err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	name := filepath.Base(wd) + ".git"
+	if errbuf.String() != "Cloning into bare repository '"+name+"'...\n" {
+		t.Errorf("unexpected output: '%s'", errbuf.String())
+	}
+
+	dir := filepath.Join(wd, name)
+	if _, err := os.Stat(filepath.Join(dir, "HEAD")); err != nil {
+		t.Fatalf("expected a bare repository in %s: %v", dir, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "foo")); !os.IsNotExist(err) {
+		t.Errorf("expected no checkout in a bare clone (%v)", err)
+	}
+
+	repo := repository.NewBareRepo(dir)
+	for _, name := range []string{"HEAD", "refs/heads/master", "refs/heads/topic"} {
+		if oid, _ := repo.Refs().ReadRef(name); oid != head {
+			t.Errorf("expected %s to be %s but got: '%s'", name, head, oid)
+		}
+	}
+	if oid, _ := repo.Refs().ReadRef("refs/remotes/origin/master"); oid != "" {
+		t.Errorf("expected no remote-tracking refs but got: %s", oid)
+	}
+
+	cfg, err := repo.Config()
+	if err != nil {
+		t.Fatalf("error reading config: %v", err)
+	}
+	if value, _ := cfg.Get("core.bare"); value != "true" {
+		t.Errorf("expected core.bare true but got: '%s'", value)
+	}
+	if _, exists := cfg.Get("remote.origin.fetch"); exists {
+		t.Errorf("expected no fetch refspec in a bare clone")
+	}
+
+	report, err := fsck.Check(repo, fsck.Options{})
+	if err != nil || !report.OK() {
+		t.Errorf("expected a clean clone but got: %+v (%v)", report, err)
+	}
+}
+
+func TestCloneBranch(t *testing.T) {
+	setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+	defer resetCloneFlags()
+
+	initOrDie(t)
+	writeFile(t, "foo/bar.txt", "two")
+	commitOrDie(t, "first")
+	first, _ := repository.NewRepo(wd).Refs().ReadHead()
+	if err := executeUpdateRef(updateRefCmd, []string{"refs/heads/topic", first}); err != nil {
+		t.Fatalf("error creating branch: %v", err)
+	}
+	if err := executeUpdateRef(updateRefCmd, []string{"refs/tags/v1", first}); err != nil {
+		t.Fatalf("error creating tag: %v", err)
+	}
+	writeFile(t, "foo.txt", "one")
+	commitOrDie(t, "second")
+
+	cloneBranch = "topic"
+	if err := executeClone(cloneCmd, []string{wd, "topic"}); err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	repo := repository.NewRepo(filepath.Join(wd, "topic"))
+	if target, _ := repo.Refs().ReadSymbolicRef("HEAD"); target != "refs/heads/topic" {
+		t.Errorf("expected HEAD to point to topic but got: '%s'", target)
+	}
+	if oid, _ := repo.Refs().ReadRef("refs/heads/topic"); oid != first {
+		t.Errorf("expected topic to be %s but got: '%s'", first, oid)
+	}
+	if target, _ := repo.Refs().ReadSymbolicRef("refs/remotes/origin/HEAD"); target != "refs/remotes/origin/master" {
+		t.Errorf("expected origin/HEAD to follow the source's HEAD but got: '%s'", target)
+	}
+	if _, err := os.Stat(filepath.Join(wd, "topic", "foo.txt")); !os.IsNotExist(err) {
+		t.Errorf("expected foo.txt not to be checked out (%v)", err)
+	}
+
+	cloneBranch = "v1"
+	if err := executeClone(cloneCmd, []string{wd, "tag"}); err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	repo = repository.NewRepo(filepath.Join(wd, "tag"))
+	if target, _ := repo.Refs().ReadSymbolicRef("HEAD"); target != "" {
+		t.Errorf("expected a detached HEAD but got: '%s'", target)
+	}
+	if oid, _ := repo.Refs().ReadHead(); oid != first {
+		t.Errorf("expected HEAD to be %s but got: '%s'", first, oid)
+	}
+
+	cloneBranch = "nonexistent"
+	err := executeClone(cloneCmd, []string{wd, "nonexistent"})
+	if err == nil || err.Error() != "Remote branch nonexistent not found in upstream origin" {
+		t.Errorf("expected an error for a missing branch but got: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(wd, "nonexistent")); !os.IsNotExist(err) {
+		t.Errorf("expected no clone to be created (%v)", err)
+	}
+}
+
+func TestCloneNoCheckout(t *testing.T) {
+	setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+	defer resetCloneFlags()
+
+	initOrDie(t)
+	writeFile(t, "foo/bar.txt", "two")
+	commitOrDie(t, "first")
+	head, _ := repository.NewRepo(wd).Refs().ReadHead()
+
+	cloneNoCheckout = true
+	if err := executeClone(cloneCmd, []string{wd, "copy"}); err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+
+	dir := filepath.Join(wd, "copy")
+	if oid, _ := repository.NewRepo(dir).Refs().ReadHead(); oid != head {
+		t.Errorf("expected HEAD to be %s but got: '%s'", head, oid)
+	}
+	for _, name := range []string{"foo", filepath.Join(repository.GitDir, "index")} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("expected no %s (%v)", name, err)
+		}
+	}
+}
+
+func TestCloneDepth(t *testing.T) {
+	setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+	defer resetCloneFlags()
+
+	initOrDie(t)
+	writeFile(t, "1.txt", "one")
+	commitOrDie(t, "first")
+	first, _ := repository.NewRepo(wd).Refs().ReadHead()
+	if err := executeUpdateRef(updateRefCmd, []string{"refs/tags/old", first}); err != nil {
+		t.Fatalf("error creating tag: %v", err)
+	}
+	writeFile(t, "2.txt", "two")
+	commitOrDie(t, "second")
+	second, _ := repository.NewRepo(wd).Refs().ReadHead()
+	if err := executeUpdateRef(updateRefCmd, []string{"refs/heads/topic", second}); err != nil {
+		t.Fatalf("error creating branch: %v", err)
+	}
+	writeFile(t, "foo/bar.txt", "two")
+	commitOrDie(t, "third")
+	head, _ := repository.NewRepo(wd).Refs().ReadHead()
+	if err := executeUpdateRef(updateRefCmd, []string{"refs/tags/new", head}); err != nil {
+		t.Fatalf("error creating tag: %v", err)
+	}
+
+	cloneDepth = 2
+	if err := executeClone(cloneCmd, []string{wd, "shallow"}); err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+
+	dir := filepath.Join(wd, "shallow")
+	repo := checkClone(t, dir, head)
+
+	shallow, err := repo.Shallow()
+	if err != nil || len(shallow) != 1 || !shallow[second] {
+		t.Errorf("expected %s to be the shallow boundary but got: %v (%v)", second, shallow, err)
+	}
+	if repo.Database().Has(first) {
+		t.Errorf("expected %s to be left out", first)
+	}
+	for name, expected := range map[string]string{
+		"refs/tags/new":             head,
+		"refs/tags/old":             "",
+		"refs/remotes/origin/topic": "",
+	} {
+		if oid, _ := repo.Refs().ReadRef(name); oid != expected {
+			t.Errorf("expected %s to be '%s' but got: '%s'", name, expected, oid)
+		}
+	}
+
+	cfg, _ := repo.Config()
+	if value, _ := cfg.Get("remote.origin.fetch"); value != "+refs/heads/master:refs/remotes/origin/master" {
+		t.Errorf("unexpected fetch refspec: '%s'", value)
+	}
+
+	resetCloneFlags()
+	cloneDepth = -1
+	if err := executeClone(cloneCmd, []string{wd, "negative"}); err == nil {
+		t.Errorf("expected an error for a negative depth")
+	}
+}
+
+func TestCloneShallowSince(t *testing.T) {
+	setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+	defer resetCloneFlags()
+
+	initOrDie(t)
+	writeFile(t, "foo.txt", "one")
+	commitOrDie(t, "first")
+	writeFile(t, "foo/bar.txt", "two")
+	commitOrDie(t, "second")
+	head, _ := repository.NewRepo(wd).Refs().ReadHead()
+
+	cloneShallowSince = "1 hour ago"
+	if err := executeClone(cloneCmd, []string{wd, "recent"}); err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	repo := checkClone(t, filepath.Join(wd, "recent"), head)
+	if shallow, err := repo.Shallow(); err != nil || len(shallow) != 0 {
+		t.Errorf("expected every commit to be recent enough but got: %v (%v)", shallow, err)
+	}
+	cfg, _ := repo.Config()
+	if value, _ := cfg.Get("remote.origin.fetch"); value != "+refs/heads/master:refs/remotes/origin/master" {
+		t.Errorf("unexpected fetch refspec: '%s'", value)
+	}
+
+	cloneShallowSince = "2999-01-01"
+	if err := executeClone(cloneCmd, []string{wd, "future"}); err == nil || !strings.Contains(err.Error(), "no commits selected") {
+		t.Errorf("expected an error for a date after every commit but got: %v", err)
+	}
+
+	cloneShallowSince = "not a date"
+	if err := executeClone(cloneCmd, []string{wd, "bad"}); err == nil {
+		t.Error("expected an error for a bad date")
+	}
+}
+
+func TestCloneFilter(t *testing.T) {
+	setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+	defer resetCloneFlags()
+
+	initOrDie(t)
+	writeFile(t, "foo.txt", "one")
+	writeFile(t, "foo/bar.txt", "two")
+	commitOrDie(t, "first")
+	head, _ := repository.NewRepo(wd).Refs().ReadHead()
+	blobs := map[string]bool{}
+	for _, content := range []string{"one", "two"} {
+		blobs[object.HashObject(objectformat.SHA1, blob.New([]byte(content)))] = true
+	}
+
+	cloneFilterSpec = "blob:none"
+	cloneNoCheckout = true
+	if err := executeClone(cloneCmd, []string{wd, "partial"}); err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+
+	dir := filepath.Join(wd, "partial")
+	repo := repository.NewRepo(dir)
+	promised, err := repo.PromisorObjects()
+	if err != nil || !reflect.DeepEqual(promised, blobs) {
+		t.Errorf("expected the blobs to be promised but got: %v (%v)", promised, err)
+	}
+	// the commit and two trees
+	if n := countLooseObjects(t, repo.ObjectsDir()); n != 3 {
+		t.Errorf("expected 3 objects but got %d", n)
+	}
+	cfg, _ := repo.Config()
+	for key, expected := range map[string]string{
+		"extensions.partialclone":          "origin",
+		"remote.origin.promisor":           "true",
+		"remote.origin.partialclonefilter": "blob:none",
+	} {
+		if value, _ := cfg.Get(key); value != expected {
+			t.Errorf("expected %s to be '%s' but got: '%s'", key, expected, value)
+		}
+	}
+
+	// checking out fetches the blobs
+	if err = checkoutHead(repo, head); err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	checkClone(t, dir, head)
+
+	resetCloneFlags()
+	cloneFilterSpec = "tree:0"
+	if err = executeClone(cloneCmd, []string{wd, "bad"}); err == nil {
+		t.Error("expected an error for an unsupported filter")
+	}
+}
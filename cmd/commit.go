@@ -1,35 +1,95 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/neocortical/got/hook"
+	"github.com/neocortical/got/index"
+	"github.com/neocortical/got/object"
+	"github.com/neocortical/got/pathspec"
 	"github.com/neocortical/got/ref"
 	"github.com/neocortical/got/repository"
+	"github.com/neocortical/got/revision"
 	"github.com/neocortical/got/tree"
+	"github.com/neocortical/got/workspace"
 	"github.com/spf13/cobra"
 )
 
 const (
-	EnvAuthorName  = "GIT_AUTHOR_NAME"
-	EnvAuthorEmail = "GIT_AUTHOR_EMAIL"
+	EnvAuthorName     = "GIT_AUTHOR_NAME"
+	EnvAuthorEmail    = "GIT_AUTHOR_EMAIL"
+	EnvCommitterName  = "GIT_COMMITTER_NAME"
+	EnvCommitterEmail = "GIT_COMMITTER_EMAIL"
+
+	commitEditMsgFile = "COMMIT_EDITMSG"
+
+	cleanupDefault    = "default"
+	cleanupStrip      = "strip"
+	cleanupWhitespace = "whitespace"
+	cleanupVerbatim   = "verbatim"
+	cleanupScissors   = "scissors"
+
+	// scissorsLine marks where the part of the message the scissors cleanup
+	// mode keeps ends
+	scissorsLine = "# ------------------------ >8 ------------------------"
 )
 
 var (
 	commitCmd = &cobra.Command{
-		Use:   "commit",
+		Use:   "commit [-a] [-m <msg> | -F <file>] [--amend] [--no-edit] [-n] [--allow-empty] [--cleanup <mode>] [--author <author>] [--date <date>] [--] [<pathspec>...]",
 		Short: "Commit staged changes to the repository.",
 		RunE:  executeCommit,
 	}
-	commitMessage string
+	commitAll        bool
+	commitMessage    string
+	commitFile       string
+	commitAmend      bool
+	commitNoEdit     bool
+	commitNoVerify   bool
+	commitAllowEmpty bool
+	commitCleanup    string
+	commitAuthor     string
+	commitDate       string
+
+	authorArgRegexp = regexp.MustCompile(`^(.*\S)\s*<(.*)>$`)
 )
 
 func init() {
+	commitCmd.Flags().BoolVarP(&commitAll, "all", "a", false, "Stage modified and deleted tracked files before committing")
 	commitCmd.Flags().StringVarP(&commitMessage, "message", "m", "", "Commit message")
+	commitCmd.Flags().StringVarP(&commitFile, "file", "F", "", "Take the commit message from this file, or - for standard input")
+	commitCmd.Flags().BoolVar(&commitAmend, "amend", false, "Replace the commit at HEAD, keeping its parents, author and, unless another is given, message")
+	commitCmd.Flags().BoolVar(&commitNoEdit, "no-edit", false, "Use the message as it is, without opening an editor")
+	commitCmd.Flags().BoolVarP(&commitNoVerify, "no-verify", "n", false, "Bypass the pre-commit and commit-msg hooks")
+	commitCmd.Flags().BoolVar(&commitAllowEmpty, "allow-empty", false, "Allow a commit that doesn't change the tree of its parent")
+	commitCmd.Flags().StringVar(&commitCleanup, "cleanup", "", "How to clean up the message: strip, whitespace, verbatim, scissors or default")
+	commitCmd.Flags().StringVar(&commitAuthor, "author", "", "Override the author, given as 'Name <email>'")
+	commitCmd.Flags().StringVar(&commitDate, "date", "", "Override the author date")
 }
 
 func executeCommit(cmd *cobra.Command, args []string) (err error) {
+	haveMessage := commitMessage != "" || cmd.Flags().Changed("message")
+	if haveMessage && commitFile != "" {
+		return errors.New("options '-m' and '-F' cannot be used together")
+	}
+	if commitAll && len(args) > 0 {
+		return fmt.Errorf("paths '%s ...' with -a does not make sense", args[0])
+	}
+	author, err := commitAuthorIdentity()
+	if err != nil {
+		return err
+	}
+
 	workspaceDir := wd
 
 	repo := repository.NewRepo(workspaceDir)
@@ -37,50 +97,627 @@ func executeCommit(cmd *cobra.Command, args []string) (err error) {
 	idx := repo.Index()
 	refs := repo.Refs()
 
-	err = idx.Load()
+	cleanup, err := commitCleanupMode(repo)
+	if err != nil {
+		return err
+	}
+
+	err = idx.LoadForUpdate()
 	if err != nil {
 		return fmt.Errorf("error loading index: %w", err)
 	}
+	defer func() {
+		if err != nil && idx != nil {
+			idx.Rollback()
+		}
+	}()
 
-	t, err := tree.BuildFromIndex(idx.Entries())
+	head, err := refs.ReadHead()
 	if err != nil {
-		return fmt.Errorf("error building tree: %w", err)
+		return fmt.Errorf("error reading head: %w", err)
 	}
 
-	err = t.Traverse(func(tr *tree.Tree) (oid string, err error) {
-		oid, err = db.Store(tr)
-		return
-	})
+	// commits with -a or paths are made from a temporary index, leaving the
+	// index locked until they're made, while the index of any other commit
+	// is written first, as the hooks may change it
+	commitIdx, indexFile := idx, repo.IndexFile()
+	if commitAll || len(args) > 0 {
+		ps, err := parsePathspec(args)
+		if err != nil {
+			return err
+		}
+		indexFile = filepath.Join(repo.Dir(), fmt.Sprintf("next-index-%d", os.Getpid()))
+		defer os.Remove(indexFile)
+		if commitIdx, err = partialCommitIndex(repo, idx, ps, head, indexFile); err != nil {
+			return err
+		}
+	} else {
+		if _, err = storeIndexTree(db, idx); err != nil {
+			return err
+		}
+		if err = idx.WriteUpdates(); err != nil {
+			return fmt.Errorf("error committing the index: %w", err)
+		}
+		idx = nil
+	}
+
+	edit := !commitNoEdit && !haveMessage && commitFile == ""
+	hookOpts := hook.Options{Env: []string{"GIT_INDEX_FILE=" + indexFile}, Output: stderr}
+	if !edit {
+		hookOpts.Env = append(hookOpts.Env, "GIT_EDITOR=:")
+	}
+
+	// the pre-commit hook may change the index the commit is made from
+	preCommit := ""
+	if !commitNoVerify {
+		if preCommit, err = hook.Find(repo, hook.PreCommit); err != nil {
+			return err
+		}
+	}
+	if preCommit != "" {
+		if err = hook.Run(repo, hook.PreCommit, hookOpts); err != nil {
+			return err
+		}
+		if commitIdx, err = loadIndexFile(repo, indexFile); err != nil {
+			return err
+		}
+	}
+
+	treeOID, err := storeIndexTree(db, commitIdx)
 	if err != nil {
-		return fmt.Errorf("error storing tree: %w", err)
+		return err
 	}
 
-	parentCommit, err := refs.ReadHead()
+	// an amended commit takes the place of HEAD, with its parents and author
+	commit := ref.Commit{TreeOID: treeOID, Author: author}
+	var message string
+	if commitAmend {
+		if head == "" {
+			return errors.New("You have nothing to amend.")
+		}
+		amended, err := readCommit(db, head)
+		if err != nil {
+			return err
+		}
+		commit.Parents = amended.Parents
+		message = amended.Message
+		if commitAuthor == "" {
+			commit.Author.Name, commit.Author.Email = amended.Author.Name, amended.Author.Email
+		}
+		if commitDate == "" {
+			commit.Author.Time = amended.Author.Time
+		}
+	} else if head != "" {
+		commit.Parents = []string{head}
+	}
+
+	parentTree := ""
+	if parent := commit.Parent(); parent != "" {
+		parentCommit, err := readCommit(db, parent)
+		if err != nil {
+			return err
+		}
+		parentTree = parentCommit.TreeOID
+	}
+	unchanged := treeOID == parentTree || (parentTree == "" && len(commitIdx.Entries()) == 0)
+	if unchanged && !commitAllowEmpty && len(commit.Parents) < 2 {
+		if commitAmend {
+			return errors.New("amending would leave the commit empty; use --allow-empty to amend it anyway")
+		}
+		return errors.New("nothing to commit")
+	}
+
+	// prepare-commit-msg is told where the message came from
+	msgFile := filepath.Join(repo.Dir(), commitEditMsgFile)
+	hookOpts.Args = []string{msgFile}
+	switch {
+	case haveMessage:
+		message = commitMessage
+		if !strings.HasSuffix(message, "\n") {
+			message += "\n"
+		}
+		hookOpts.Args = append(hookOpts.Args, "message")
+	case commitFile != "":
+		if message, err = readCommitFile(); err != nil {
+			return err
+		}
+		hookOpts.Args = append(hookOpts.Args, "message")
+	case commitAmend:
+		hookOpts.Args = append(hookOpts.Args, "commit", head)
+	}
+	if cleanup == cleanupDefault {
+		cleanup = cleanupWhitespace
+		if edit {
+			cleanup = cleanupStrip
+		}
+	}
+	if cleanup == cleanupScissors && !edit {
+		cleanup = cleanupWhitespace
+	}
+
+	if edit {
+		status, err := commitStatus(repo, commitIdx, parentTree)
+		if err != nil {
+			return err
+		}
+		message = commitTemplate(message, cleanup, status)
+	}
+	if err = writeCommitEditMsg(repo, message); err != nil {
+		return err
+	}
+	if err = hook.Run(repo, hook.PrepareCommitMsg, hookOpts); err != nil {
+		return err
+	}
+	if edit {
+		if err = runEditor(repo, msgFile); err != nil {
+			return err
+		}
+	}
+	if !commitNoVerify {
+		hookOpts.Args = []string{msgFile}
+		if err = hook.Run(repo, hook.CommitMsg, hookOpts); err != nil {
+			return err
+		}
+	}
+
+	data, err := ioutil.ReadFile(msgFile)
 	if err != nil {
-		return fmt.Errorf("error reading head: %w", err)
+		return fmt.Errorf("error reading %s: %w", commitEditMsgFile, err)
+	}
+	commit.Message = cleanupMessage(string(data), cleanup)
+	if strings.TrimSpace(commit.Message) == "" {
+		return errors.New("Aborting commit due to empty commit message.")
 	}
 
-	commit := ref.NewCommit(parentCommit, t.OID(), ref.Author{Name: getenv(EnvAuthorName), Email: getenv(EnvAuthorEmail), Time: time.Now()}, commitMessage)
+	commit.Committer = committerIdentity()
 	commitOID, err := db.Store(commit)
 	if err != nil {
 		return fmt.Errorf("error storing commit: %w", err)
 	}
 
-	err = refs.UpdateHead(commitOID)
+	reflogMessage := "commit: " + truncateCommitMessage(commit.Message)
+	if commitAmend {
+		reflogMessage = "commit (amend): " + truncateCommitMessage(commit.Message)
+	} else if head == "" {
+		reflogMessage = "commit (initial): " + truncateCommitMessage(commit.Message)
+	}
+
+	err = refs.UpdateHead(commitOID, commit.Committer, reflogMessage)
 	if err != nil {
 		return fmt.Errorf("error storing commit SHA at HEAD: %w", err)
 	}
 
+	if idx != nil {
+		if err = idx.WriteUpdates(); err != nil {
+			return fmt.Errorf("error committing the index: %w", err)
+		}
+	}
+
+	hookOpts.Args = nil
+	hookOpts.Env = []string{"GIT_INDEX_FILE=" + repo.IndexFile()}
+	hook.Run(repo, hook.PostCommit, hookOpts)
+
+	parentCommit := commit.Parent()
 	if parentCommit == "" {
 		parentCommit = "(root-commit)"
 	}
 
-	messageStub := truncateCommitMessage(commitMessage)
+	messageStub := truncateCommitMessage(commit.Message)
 	fmt.Fprintf(stdout, "[%s %s] %s\n", parentCommit, commitOID, messageStub)
 
 	return nil
 }
 
+// commitAuthorIdentity returns the author of a new commit: the author from
+// the environment, unless --author gives another, at the time now, unless
+// --date gives another.
+func commitAuthorIdentity() (ref.Author, error) {
+	now := time.Now()
+	author := ref.Author{Name: getenv(EnvAuthorName), Email: getenv(EnvAuthorEmail), Time: now}
+
+	if commitAuthor != "" {
+		m := authorArgRegexp.FindStringSubmatch(commitAuthor)
+		if m == nil {
+			return author, fmt.Errorf("--author '%s' is not 'Name <email>'", commitAuthor)
+		}
+		author.Name, author.Email = m[1], m[2]
+	}
+	if commitDate != "" {
+		date, err := revision.ParseDate(commitDate, now)
+		if err != nil {
+			return author, fmt.Errorf("invalid date format: %s", commitDate)
+		}
+		author.Time = date
+	}
+
+	return author, nil
+}
+
+// commitCleanupMode returns the --cleanup mode, or commit.cleanup when it's
+// not given.
+func commitCleanupMode(repo *repository.Repo) (string, error) {
+	mode := commitCleanup
+	if mode == "" {
+		cfg, err := repo.Config()
+		if err != nil {
+			return "", err
+		}
+		if mode, _ = cfg.Get("commit.cleanup"); mode == "" {
+			mode = cleanupDefault
+		}
+	}
+
+	switch mode {
+	case cleanupDefault, cleanupStrip, cleanupWhitespace, cleanupVerbatim, cleanupScissors:
+		return mode, nil
+	}
+
+	return "", fmt.Errorf("Invalid cleanup mode %s", mode)
+}
+
+// stageTrackedChanges updates the tracked files a pathspec selects in the
+// index from the workspace: modified files are hashed and staged, and deleted
+// ones removed. Untracked files are left alone.
+func stageTrackedChanges(repo *repository.Repo, idx index.Index, ps *pathspec.Pathspec) error {
+	modified, _, err := workspaceChanges(repo, idx, ps)
+	if err != nil {
+		return err
+	}
+
+	return stageWorkspaceChanges(repo, idx, modified)
+}
+
+// stageWorkspaceChanges stages the changes workspaceChanges found in idx,
+// which may be a copy of the index they were found in.
+func stageWorkspaceChanges(repo *repository.Repo, idx index.Index, modified map[string]int) error {
+	var paths []string
+	for p := range modified {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	var files []workspace.File
+	for _, p := range paths {
+		if modified[p]&statusWorkspaceDeleted != 0 {
+			idx.Remove(p)
+			continue
+		}
+		info, err := os.Stat(toAbsolutePath(p))
+		if err != nil {
+			return fmt.Errorf("error reading '%s': %w", p, err)
+		}
+		files = append(files, workspace.File{Path: toAbsolutePath(p), Info: info})
+	}
+
+	jobs, err := hashJobs(repo)
+	if err != nil {
+		return err
+	}
+
+	return workspace.HashFiles(repo.Database(), files, jobs, func(result workspace.HashResult) error {
+		if result.Err != nil {
+			return fmt.Errorf("error adding file '%s' to index: %w", toRelativePath(result.Path), result.Err)
+		}
+
+		idx.Add(index.NewEntry(toRelativePath(result.Path), result.OID, result.Info))
+		return nil
+	})
+}
+
+// partialCommitIndex stages the tracked files a partial commit names in the
+// index, then writes the index the commit is made from to filename: the
+// tree at HEAD, with just those files taken from the index.
+func partialCommitIndex(repo *repository.Repo, idx index.Index, ps *pathspec.Pathspec, head string, filename string) (index.Index, error) {
+	files := map[string]tree.Node{}
+	if head != "" {
+		commit, err := readCommit(repo.Database(), head)
+		if err != nil {
+			return nil, err
+		}
+		if err = readTreeFiles(repo, commit.TreeOID, "", files); err != nil {
+			return nil, err
+		}
+	}
+
+	// only files known to the index or HEAD can be named
+	named := map[string]bool{}
+	for p := range files {
+		named[p] = ps.Match(p, false)
+	}
+	for _, entry := range idx.Entries() {
+		named[entry.Path()] = ps.Match(entry.Path(), false)
+	}
+	if unmatched := ps.Unmatched(); len(unmatched) > 0 {
+		return nil, fmt.Errorf("pathspec '%s' did not match any file(s) known to got", unmatched[0])
+	}
+
+	if err := stageTrackedChanges(repo, idx, ps); err != nil {
+		return nil, err
+	}
+
+	partial := index.NewIndex(filename)
+	partial.SetObjectFormat(repo.ObjectFormat())
+	if err := partial.LoadForUpdate(); err != nil {
+		return nil, fmt.Errorf("error creating temporary index: %w", err)
+	}
+
+	for p, node := range files {
+		if !named[p] {
+			partial.Add(index.NewTreeEntry(p, node.OID(), node.ModeString() == "100755"))
+		}
+	}
+	for _, entry := range idx.Entries() {
+		if named[entry.Path()] {
+			// the indexes mustn't share entries, which writing them changes
+			e := *entry
+			partial.Add(&e)
+		}
+	}
+
+	if err := partial.WriteUpdates(); err != nil {
+		partial.Rollback()
+		return nil, fmt.Errorf("error writing temporary index: %w", err)
+	}
+
+	return partial, nil
+}
+
+func readCommit(db object.Database, oid string) (ref.Commit, error) {
+	commit, err := object.ReadDecoded(db, oid, func(obj object.Storable) (interface{}, error) {
+		return ref.DeserializeCommit(obj.Serialize())
+	})
+	if err != nil {
+		return ref.Commit{}, fmt.Errorf("error reading commit %s: %w", oid, err)
+	}
+
+	return commit.(ref.Commit), nil
+}
+
+// readCommitFile reads the message -F names, from standard input for "-".
+func readCommitFile() (string, error) {
+	var data []byte
+	var err error
+	if commitFile == "-" {
+		data, err = ioutil.ReadAll(stdin)
+	} else {
+		data, err = ioutil.ReadFile(fromCurrentDir(commitFile))
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not read log file '%s': %w", commitFile, err)
+	}
+
+	return string(data), nil
+}
+
+// writeCommitEditMsg writes the message of the commit being made to
+// COMMIT_EDITMSG, where the hooks and the editor work on it.
+func writeCommitEditMsg(repo *repository.Repo, message string) error {
+	filename := filepath.Join(repo.Dir(), commitEditMsgFile)
+	if err := ioutil.WriteFile(filename, []byte(message), 0644); err != nil {
+		return fmt.Errorf("error writing %s: %w", commitEditMsgFile, err)
+	}
+
+	return nil
+}
+
+// commitTemplate returns what the editor is opened on: the message so far,
+// and comments explaining what the commit holds.
+func commitTemplate(message string, cleanup string, status []string) string {
+	var buf strings.Builder
+	buf.WriteString(message)
+	if message != "" && !strings.HasSuffix(message, "\n") {
+		buf.WriteString("\n")
+	}
+	buf.WriteString("\n")
+
+	switch cleanup {
+	case cleanupScissors:
+		buf.WriteString(scissorsLine + "\n")
+		buf.WriteString("# Do not modify or remove the line above.\n")
+		buf.WriteString("# Everything below it will be ignored.\n")
+	case cleanupStrip:
+		buf.WriteString("# Please enter the commit message for your changes. Lines starting\n")
+		buf.WriteString("# with '#' will be ignored, and an empty message aborts the commit.\n")
+	default:
+		buf.WriteString("# Please enter the commit message for your changes. Lines starting\n")
+		buf.WriteString("# with '#' will be kept; you may remove them yourself if you want to.\n")
+		buf.WriteString("# An empty message aborts the commit.\n")
+	}
+	buf.WriteString("#\n")
+	for _, line := range status {
+		buf.WriteString("#" + line + "\n")
+	}
+
+	return buf.String()
+}
+
+// loadIndexFile reads the index at filename afresh.
+func loadIndexFile(repo *repository.Repo, filename string) (index.Index, error) {
+	idx := index.NewIndex(filename)
+	idx.SetObjectFormat(repo.ObjectFormat())
+	if err := idx.Load(); err != nil {
+		return nil, fmt.Errorf("error loading index: %w", err)
+	}
+
+	return idx, nil
+}
+
+// runEditor runs the user's editor on a file, the way git does: through
+// the shell, so that the editor can carry arguments of its own. The editor
+// is GIT_EDITOR, core.editor, VISUAL or EDITOR, in that order, or else vi.
+func runEditor(repo *repository.Repo, filename string) error {
+	editor := getenv("GIT_EDITOR")
+	if editor == "" {
+		cfg, err := repo.Config()
+		if err != nil {
+			return err
+		}
+		editor, _ = cfg.Get("core.editor")
+	}
+	for _, name := range []string{"VISUAL", "EDITOR"} {
+		if editor == "" {
+			editor = getenv(name)
+		}
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+
+	cmd := exec.Command("sh", "-c", editor+` "$@"`, editor, filename)
+	cmd.Dir = wd
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("there was a problem with the editor '%s': %w", editor, err)
+	}
+
+	return nil
+}
+
+// commitStatus describes, for the commit message template, the branch being
+// committed to, how the index differs from the parent's tree, and how the
+// workspace differs from the index.
+func commitStatus(repo *repository.Repo, idx index.Index, parentTree string) ([]string, error) {
+	var result []string
+
+	branch, err := repo.Refs().ReadSymbolicRef("HEAD")
+	if err != nil {
+		return nil, fmt.Errorf("error reading HEAD: %w", err)
+	}
+	if strings.HasPrefix(branch, ref.HeadsPrefix) {
+		result = append(result, " On branch "+strings.TrimPrefix(branch, ref.HeadsPrefix))
+	} else {
+		result = append(result, " Not currently on any branch.")
+	}
+	if parentTree == "" {
+		result = append(result, "", " Initial commit")
+	}
+
+	before := map[string]tree.Node{}
+	if parentTree != "" {
+		if err = readTreeFiles(repo, parentTree, "", before); err != nil {
+			return nil, err
+		}
+	}
+
+	changes := map[string]string{}
+	for _, entry := range idx.Entries() {
+		node, existed := before[entry.Path()]
+		delete(before, entry.Path())
+		switch {
+		case !existed:
+			changes[entry.Path()] = "new file:   "
+		case node.OID() != entry.OID() || node.ModeString() != entry.ModeString():
+			changes[entry.Path()] = "modified:   "
+		}
+	}
+	for p := range before {
+		changes[p] = "deleted:    "
+	}
+
+	var paths []string
+	for p := range changes {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	if len(paths) > 0 {
+		result = append(result, "", " Changes to be committed:")
+	}
+	for _, p := range paths {
+		result = append(result, "\t"+changes[p]+p)
+	}
+
+	ps, err := parsePathspec(nil)
+	if err != nil {
+		return nil, err
+	}
+	modified, untracked, err := workspaceChanges(repo, idx, ps)
+	if err != nil {
+		return nil, err
+	}
+
+	paths = paths[:0]
+	for p := range modified {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	if len(paths) > 0 {
+		result = append(result, "", " Changes not staged for commit:")
+	}
+	for _, p := range paths {
+		change := "modified:   "
+		if modified[p]&statusWorkspaceDeleted != 0 {
+			change = "deleted:    "
+		}
+		result = append(result, "\t"+change+filepath.ToSlash(p))
+	}
+
+	if len(untracked) > 0 {
+		result = append(result, "", " Untracked files:")
+	}
+	for _, p := range untracked {
+		result = append(result, "\t"+filepath.ToSlash(p))
+	}
+
+	return append(result, ""), nil
+}
+
+// readTreeFiles adds the files in a tree and its subtrees to files, by path.
+func readTreeFiles(repo *repository.Repo, treeOID string, prefix string, files map[string]tree.Node) error {
+	t, err := revision.ReadTree(repo, treeOID)
+	if err != nil {
+		return err
+	}
+
+	for _, node := range t.Entries() {
+		name := path.Join(prefix, node.Name())
+		if _, isTree := node.(*tree.Tree); isTree {
+			if err = readTreeFiles(repo, node.OID(), name, files); err != nil {
+				return err
+			}
+			continue
+		}
+		files[name] = node
+	}
+
+	return nil
+}
+
+// cleanupMessage tidies a commit message as a --cleanup mode says:
+// whitespace drops trailing whitespace and leading, trailing and repeated
+// blank lines, strip drops comment lines too, scissors drops everything from
+// the scissors line on as well, and verbatim leaves the message alone.
+func cleanupMessage(message string, mode string) string {
+	if mode == cleanupVerbatim {
+		return message
+	}
+
+	var lines []string
+	blank := false
+	for _, line := range strings.Split(message, "\n") {
+		if mode == cleanupScissors && line == scissorsLine {
+			break
+		}
+		if mode == cleanupStrip && strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" {
+			blank = len(lines) > 0
+			continue
+		}
+		if blank {
+			lines = append(lines, "")
+			blank = false
+		}
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
 func truncateCommitMessage(msg string) string {
 	newlineIdx := strings.Index(msg, "\n")
 	if newlineIdx == -1 {
@@ -0,0 +1,510 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/neocortical/got/blob"
+	"github.com/neocortical/got/object"
+	"github.com/neocortical/got/objectformat"
+	"github.com/neocortical/got/ref"
+	"github.com/neocortical/got/repository"
+	"github.com/neocortical/got/tree"
+)
+
+func resetCommitFlags() {
+	commitAll = false
+	commitMessage = ""
+	commitFile = ""
+	commitAmend = false
+	commitNoEdit = false
+	commitNoVerify = false
+	commitAllowEmpty = false
+	commitCleanup = ""
+	commitAuthor = ""
+	commitDate = ""
+}
+
+func readHeadCommit(t *testing.T) ref.Commit {
+	repo := repository.NewRepo(wd)
+	head, err := repo.Refs().ReadHead()
+	if err != nil {
+		t.Fatalf("error reading HEAD: %v", err)
+	}
+	commit, err := readCommit(repo.Database(), head)
+	if err != nil {
+		t.Fatalf("error reading HEAD: %v", err)
+	}
+	return commit
+}
+
+func TestCommitEditor(t *testing.T) {
+	setUpTestWorkspace(t, map[string]string{
+		EnvAuthorName:  "Nathan Smith",
+		EnvAuthorEmail: "nathan@neocortical.net",
+		"GIT_EDITOR":   `cp "$1" "$1.template" && printf '\n  edited  \n# a comment\n\n\nbody\n\n' >`,
+	})
+	defer tearDownTestWorkspace()
+	resetCommitFlags()
+	defer resetCommitFlags()
+
+	initOrDie(t)
+	writeFile(t, "foo.txt", "one")
+	if err := executeAdd(addCmd, []string{"."}); err != nil {
+		t.Fatalf("error adding: %v", err)
+	}
+	if err := executeCommit(commitCmd, nil); err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+
+	if commit := readHeadCommit(t); commit.Message != "  edited\n\nbody" {
+		t.Errorf("expected the message to be cleaned up but got: '%s'", commit.Message)
+	}
+	template, err := ioutil.ReadFile(filepath.Join(wd, repository.GitDir, commitEditMsgFile+".template"))
+	if err != nil {
+		t.Fatalf("error reading template: %v", err)
+	}
+	for _, expected := range []string{"# with '#' will be ignored", "# On branch master\n", "# Initial commit\n", "#\tnew file:   foo.txt\n"} {
+		if !strings.Contains(string(template), expected) {
+			t.Errorf("expected the template to contain '%s' but got:\n%s", expected, template)
+		}
+	}
+
+	writeFile(t, "foo.txt", "two")
+	writeFile(t, "bar.txt", "three")
+	executeAdd(addCmd, []string{"."})
+	writeFile(t, "foo.txt", "four")
+	writeFile(t, "new/baz.txt", "five")
+	commitCleanup = "verbatim"
+	if err = executeCommit(commitCmd, nil); err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	if commit := readHeadCommit(t); commit.Message != "\n  edited  \n# a comment\n\n\nbody\n\n" {
+		t.Errorf("expected the message to be kept verbatim but got: '%s'", commit.Message)
+	}
+	template, _ = ioutil.ReadFile(filepath.Join(wd, repository.GitDir, commitEditMsgFile+".template"))
+	for _, expected := range []string{"# with '#' will be kept", "#\tnew file:   bar.txt\n#\tmodified:   foo.txt\n",
+		"#\n# Changes not staged for commit:\n#\tmodified:   foo.txt\n#\n# Untracked files:\n#\tnew/\n"} {
+		if !strings.Contains(string(template), expected) {
+			t.Errorf("expected the template to contain '%s' but got:\n%s", expected, template)
+		}
+	}
+}
+
+func TestCommitEmptyMessage(t *testing.T) {
+	setUpTestWorkspace(t, map[string]string{
+		EnvAuthorName:  "Nathan Smith",
+		EnvAuthorEmail: "nathan@neocortical.net",
+		"GIT_EDITOR":   "true",
+	})
+	defer tearDownTestWorkspace()
+	resetCommitFlags()
+	defer resetCommitFlags()
+
+	initOrDie(t)
+	writeFile(t, "foo.txt", "one")
+	executeAdd(addCmd, []string{"."})
+
+	err := executeCommit(commitCmd, nil)
+	if err == nil || !strings.Contains(err.Error(), "empty commit message") {
+		t.Errorf("expected the commit to be aborted but got: %v", err)
+	}
+	if head, _ := repository.NewRepo(wd).Refs().ReadHead(); head != "" {
+		t.Errorf("expected no commit but got: %s", head)
+	}
+
+	commitMessage = "  \n\n"
+	if err = executeCommit(commitCmd, nil); err == nil {
+		t.Error("expected a blank -m message to be refused")
+	}
+}
+
+func TestCommitFile(t *testing.T) {
+	setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+	resetCommitFlags()
+	defer resetCommitFlags()
+
+	initOrDie(t)
+	writeFile(t, "foo.txt", "one")
+	writeFile(t, "message.txt", "\n\nsubject   \n# not a comment\n")
+	executeAdd(addCmd, []string{"foo.txt"})
+
+	commitFile = "message.txt"
+	if err := executeCommit(commitCmd, nil); err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	if commit := readHeadCommit(t); commit.Message != "subject\n# not a comment" {
+		t.Errorf("expected only whitespace to be cleaned up but got: '%s'", commit.Message)
+	}
+	data, _ := ioutil.ReadFile(filepath.Join(wd, repository.GitDir, commitEditMsgFile))
+	if string(data) != "\n\nsubject   \n# not a comment\n" {
+		t.Errorf("expected the message in %s but got: '%s'", commitEditMsgFile, data)
+	}
+
+	commitMessage = "both"
+	if err := executeCommit(commitCmd, nil); err == nil {
+		t.Error("expected an error for -m with -F")
+	}
+
+	resetCommitFlags()
+	commitFile = "missing.txt"
+	if err := executeCommit(commitCmd, nil); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
+
+func TestCommitAllowEmpty(t *testing.T) {
+	setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+	resetCommitFlags()
+	defer resetCommitFlags()
+
+	initOrDie(t)
+	commitMessage = "nothing"
+	if err := executeCommit(commitCmd, nil); err == nil {
+		t.Error("expected an error for an empty initial commit")
+	}
+
+	writeFile(t, "foo.txt", "one")
+	commitOrDie(t, "first")
+	first, _ := repository.NewRepo(wd).Refs().ReadHead()
+
+	commitMessage = "again"
+	if err := executeCommit(commitCmd, nil); err == nil || !strings.Contains(err.Error(), "nothing to commit") {
+		t.Errorf("expected an error for an unchanged tree but got: %v", err)
+	}
+
+	commitAllowEmpty = true
+	if err := executeCommit(commitCmd, nil); err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	if commit := readHeadCommit(t); commit.Parent() != first || commit.Message != "again" {
+		t.Errorf("expected an empty commit on %s but got: %+v", first, commit)
+	}
+}
+
+func TestCommitAmend(t *testing.T) {
+	setUpTestWorkspace(t, map[string]string{
+		EnvAuthorName:     "Nathan Smith",
+		EnvAuthorEmail:    "nathan@neocortical.net",
+		EnvCommitterName:  "Com Mitter",
+		EnvCommitterEmail: "committer@example.com",
+		"GIT_EDITOR":      "false",
+	})
+	defer tearDownTestWorkspace()
+	resetCommitFlags()
+	defer resetCommitFlags()
+
+	initOrDie(t)
+	commitAmend = true
+	commitMessage = "nothing yet"
+	if err := executeCommit(commitCmd, nil); err == nil {
+		t.Error("expected an error amending without HEAD")
+	}
+	resetCommitFlags()
+
+	writeFile(t, "foo.txt", "one")
+	commitOrDie(t, "first")
+	first, _ := repository.NewRepo(wd).Refs().ReadHead()
+	writeFile(t, "bar.txt", "two")
+	commitAuthor = "A U Thor <author@example.com>"
+	commitDate = "@1600000000"
+	commitOrDie(t, "second\n\nwith a body")
+	resetCommitFlags()
+
+	// the editor fails, so the message must be reused without it
+	writeFile(t, "bar.txt", "three")
+	executeAdd(addCmd, []string{"."})
+	commitAmend = true
+	commitNoEdit = true
+	if err := executeCommit(commitCmd, nil); err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	commit := readHeadCommit(t)
+	if commit.Parent() != first || len(commit.Parents) != 1 || commit.Message != "second\n\nwith a body" {
+		t.Errorf("expected the parents and message to be kept but got: %+v", commit)
+	}
+	if commit.Author.Name != "A U Thor" || commit.Author.Email != "author@example.com" || commit.Author.Time.Unix() != 1600000000 {
+		t.Errorf("expected the author to be kept but got: %+v", commit.Author)
+	}
+	if commit.Committer.Name != "Com Mitter" || commit.Committer.Time.Unix() == 1600000000 {
+		t.Errorf("expected a new committer but got: %+v", commit.Committer)
+	}
+
+	commitMessage = "second, reworded"
+	commitDate = "@1700000000"
+	if err := executeCommit(commitCmd, nil); err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	commit = readHeadCommit(t)
+	if commit.Parent() != first || commit.Message != "second, reworded" || commit.Author.Time.Unix() != 1700000000 {
+		t.Errorf("expected a reworded commit but got: %+v", commit)
+	}
+
+	entries, err := repository.NewRepo(wd).Refs().Reflog("HEAD")
+	if err != nil || len(entries) != 4 || entries[3].Message != "commit (amend): second, reworded" {
+		t.Errorf("expected an amend reflog entry but got: %+v (%v)", entries, err)
+	}
+
+	// amending back to the parent's tree would leave nothing
+	idx := repository.NewRepo(wd).Index()
+	if err = idx.LoadForUpdate(); err != nil {
+		t.Fatalf("error loading index: %v", err)
+	}
+	idx.Remove("bar.txt")
+	if err = idx.WriteUpdates(); err != nil {
+		t.Fatalf("error writing index: %v", err)
+	}
+	commitMessage = "empty"
+	if err = executeCommit(commitCmd, nil); err == nil {
+		t.Error("expected an error for an amend leaving the commit empty")
+	}
+
+	resetCommitFlags()
+	commitAuthor = "nobody"
+	commitMessage = "bad author"
+	if err = executeCommit(commitCmd, nil); err == nil {
+		t.Error("expected an error for a bad --author")
+	}
+}
+
+func blobOID(data string) string {
+	return object.HashObject(objectformat.Default, blob.New([]byte(data)))
+}
+
+// headFiles returns the blob IDs of the files in the tree at HEAD, by path.
+func headFiles(t *testing.T) map[string]string {
+	repo := repository.NewRepo(wd)
+	nodes := map[string]tree.Node{}
+	if err := readTreeFiles(repo, readHeadCommit(t).TreeOID, "", nodes); err != nil {
+		t.Fatalf("error reading HEAD's tree: %v", err)
+	}
+
+	result := map[string]string{}
+	for p, node := range nodes {
+		result[p] = node.OID()
+	}
+	return result
+}
+
+// indexFiles returns the blob IDs of the files in the index, by path.
+func indexFiles(t *testing.T) map[string]string {
+	idx := repository.NewRepo(wd).Index()
+	if err := idx.Load(); err != nil {
+		t.Fatalf("error loading index: %v", err)
+	}
+
+	result := map[string]string{}
+	for _, entry := range idx.Entries() {
+		result[entry.Path()] = entry.OID()
+	}
+	return result
+}
+
+func TestCommitAll(t *testing.T) {
+	setUpTestWorkspace(t, map[string]string{
+		EnvAuthorName:  "Nathan Smith",
+		EnvAuthorEmail: "nathan@neocortical.net",
+		"GIT_EDITOR":   "true",
+	})
+	defer tearDownTestWorkspace()
+	resetCommitFlags()
+	defer resetCommitFlags()
+
+	initOrDie(t)
+	writeFile(t, "foo.txt", "one")
+	writeFile(t, "bar.txt", "two")
+	commitOrDie(t, "first")
+	resetCommitFlags()
+
+	writeFile(t, "foo.txt", "changed")
+	os.Remove(filepath.Join(wd, "bar.txt"))
+	writeFile(t, "new.txt", "untracked")
+	before := indexFiles(t)
+
+	// an aborted commit leaves the index as it was
+	commitAll = true
+	if err := executeCommit(commitCmd, nil); err == nil {
+		t.Fatal("expected an empty message to abort the commit")
+	}
+	if after := indexFiles(t); !reflect.DeepEqual(after, before) {
+		t.Errorf("expected the index to be unchanged but got: %v", after)
+	}
+
+	commitMessage = "second"
+	if err := executeCommit(commitCmd, []string{"foo.txt"}); err == nil {
+		t.Error("expected an error for -a with paths")
+	}
+	if err := executeCommit(commitCmd, nil); err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+
+	files := headFiles(t)
+	foo := blobOID("changed")
+	if len(files) != 1 || files["foo.txt"] != foo {
+		t.Errorf("expected only the modified foo.txt to be committed but got: %v", files)
+	}
+	if index := indexFiles(t); !reflect.DeepEqual(index, files) {
+		t.Errorf("expected the index to match HEAD but got: %v", index)
+	}
+
+	if err := executeCommit(commitCmd, nil); err == nil || !strings.Contains(err.Error(), "nothing to commit") {
+		t.Errorf("expected nothing to commit but got: %v", err)
+	}
+}
+
+func TestCommitPaths(t *testing.T) {
+	setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+	resetCommitFlags()
+	defer resetCommitFlags()
+
+	initOrDie(t)
+	writeFile(t, "foo.txt", "one")
+	writeFile(t, "bar.txt", "two")
+	writeFile(t, "dir/baz.txt", "three")
+	commitOrDie(t, "first")
+	resetCommitFlags()
+
+	// foo.txt is staged, but only the workspace's bar.txt and dir are committed
+	writeFile(t, "foo.txt", "staged")
+	executeAdd(addCmd, []string{"foo.txt"})
+	writeFile(t, "bar.txt", "changed")
+	os.Remove(filepath.Join(wd, "dir", "baz.txt"))
+	writeFile(t, "untracked.txt", "four")
+
+	commitMessage = "partial"
+	if err := executeCommit(commitCmd, []string{"untracked.txt"}); err == nil || !strings.Contains(err.Error(), "did not match") {
+		t.Errorf("expected an error for an untracked path but got: %v", err)
+	}
+	if err := executeCommit(commitCmd, []string{"bar.txt", "dir"}); err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+
+	expected := map[string]string{"foo.txt": blobOID("one"), "bar.txt": blobOID("changed")}
+	if files := headFiles(t); !reflect.DeepEqual(files, expected) {
+		t.Errorf("expected HEAD to have %v but got: %v", expected, files)
+	}
+	expected["foo.txt"] = blobOID("staged")
+	if files := indexFiles(t); !reflect.DeepEqual(files, expected) {
+		t.Errorf("expected the index to have %v but got: %v", expected, files)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(wd, repository.GitDir, "next-index*")); len(matches) > 0 {
+		t.Errorf("expected the temporary index to be removed but found: %v", matches)
+	}
+
+	// the staged foo.txt is still there to commit
+	commitMessage = "rest"
+	if err := executeCommit(commitCmd, nil); err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	if files := headFiles(t); !reflect.DeepEqual(files, expected) {
+		t.Errorf("expected HEAD to have %v but got: %v", expected, files)
+	}
+}
+
+func writeHook(t *testing.T, name string, script string) {
+	filename := filepath.Join(wd, repository.GitDir, "hooks", name)
+	os.MkdirAll(filepath.Dir(filename), 0755)
+	if err := ioutil.WriteFile(filename, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatalf("error writing hook %s: %v", name, err)
+	}
+}
+
+func readHookOutput(t *testing.T, name string) string {
+	data, err := ioutil.ReadFile(filepath.Join(wd, repository.GitDir, name+".out"))
+	if err != nil {
+		t.Fatalf("expected %s to have run but got: %v", name, err)
+	}
+	os.Remove(filepath.Join(wd, repository.GitDir, name+".out"))
+	return string(data)
+}
+
+func TestCommitHooks(t *testing.T) {
+	setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+	resetCommitFlags()
+	defer resetCommitFlags()
+
+	initOrDie(t)
+	gitDir := filepath.Join(wd, repository.GitDir)
+	writeHook(t, "pre-commit", `echo "$GIT_INDEX_FILE $GIT_EDITOR" > "$GIT_DIR/pre-commit.out"; test ! -e block`)
+	writeHook(t, "prepare-commit-msg", `echo "$@" > "$GIT_DIR/prepare-commit-msg.out"`)
+	writeHook(t, "commit-msg", `printf '\nSigned-off-by: Hook\n' >> "$1"`)
+	writeHook(t, "post-commit", `touch "$GIT_DIR/post-commit.out"; exit 1`)
+
+	writeFile(t, "block", "the pre-commit hook refuses commits while this exists")
+	commitMessage = "first"
+	executeAdd(addCmd, []string{"."})
+	if err := executeCommit(commitCmd, nil); err == nil || !strings.Contains(err.Error(), "pre-commit") {
+		t.Fatalf("expected the pre-commit hook to stop the commit but got: %v", err)
+	}
+	if head, _ := repository.NewRepo(wd).Refs().ReadHead(); head != "" {
+		t.Errorf("expected no commit but got: %s", head)
+	}
+	if out := readHookOutput(t, "pre-commit"); out != filepath.Join(gitDir, "index")+" :\n" {
+		t.Errorf("expected pre-commit to see the index and no editor but got: %q", out)
+	}
+
+	commitNoVerify = true
+	if err := executeCommit(commitCmd, nil); err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	if commit := readHeadCommit(t); commit.Message != "first" {
+		t.Errorf("expected commit-msg to be bypassed but got: %q", commit.Message)
+	}
+	if out := readHookOutput(t, "prepare-commit-msg"); out != filepath.Join(gitDir, commitEditMsgFile)+" message\n" {
+		t.Errorf("unexpected prepare-commit-msg arguments: %q", out)
+	}
+	readHookOutput(t, "post-commit")
+	first, _ := repository.NewRepo(wd).Refs().ReadHead()
+
+	resetCommitFlags()
+	os.Remove(filepath.Join(wd, "block"))
+	writeFile(t, "foo.txt", "one")
+	executeAdd(addCmd, []string{"."})
+	writeFile(t, "foo.txt", "two")
+	commitMessage = "second"
+	if err := executeCommit(commitCmd, []string{"foo.txt"}); err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	if commit := readHeadCommit(t); commit.Message != "second\n\nSigned-off-by: Hook" {
+		t.Errorf("expected commit-msg to sign the message off but got: %q", commit.Message)
+	}
+	if out := readHookOutput(t, "pre-commit"); !strings.HasPrefix(out, filepath.Join(gitDir, "next-index-")) {
+		t.Errorf("expected pre-commit to see the temporary index but got: %q", out)
+	}
+	second, _ := repository.NewRepo(wd).Refs().ReadHead()
+
+	resetCommitFlags()
+	commitAmend = true
+	commitNoEdit = true
+	if err := executeCommit(commitCmd, nil); err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	if out := readHookOutput(t, "prepare-commit-msg"); out != filepath.Join(gitDir, commitEditMsgFile)+" commit "+second+"\n" {
+		t.Errorf("unexpected prepare-commit-msg arguments: %q", out)
+	}
+	if commit := readHeadCommit(t); commit.Parent() != first {
+		t.Errorf("expected the amended commit on %s but got: %+v", first, commit)
+	}
+}
+
+func TestCleanupMessage(t *testing.T) {
+	message := "\n\n  subject \t\n\n\n# comment\nbody\n" + scissorsLine + "\ndiff\n\n"
+	for mode, expected := range map[string]string{
+		cleanupVerbatim:   message,
+		cleanupWhitespace: "  subject\n\n# comment\nbody\n" + scissorsLine + "\ndiff",
+		cleanupStrip:      "  subject\n\nbody\ndiff",
+		cleanupScissors:   "  subject\n\n# comment\nbody",
+	} {
+		if actual := cleanupMessage(message, mode); actual != expected {
+			t.Errorf("%s: expected '%s' but got: '%s'", mode, expected, actual)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"testing"
+
+	"github.com/neocortical/got/faults"
+	"github.com/neocortical/got/fsck"
+	"github.com/neocortical/got/repository"
+)
+
+const (
+	envCrashDir   = "GOT_TEST_CRASH_DIR"
+	envCrashPoint = "GOT_TEST_CRASH_POINT"
+
+	// crashExitCode tells the parent the child stopped at its fault point
+	// rather than failing.
+	crashExitCode = 86
+
+	maxCrashPoints = 1000
+)
+
+// crashWorkload runs a series of commands that together pass through every
+// kind of write the repository does: objects, the index, loose and packed
+// refs, and reflogs.
+func crashWorkload() error {
+	if err := executeAdd(addCmd, []string{"."}); err != nil {
+		return fmt.Errorf("add: %w", err)
+	}
+
+	// the commit may already have been made before a crash
+	commitMessage = "second"
+	commitAllowEmpty = true
+	defer func() { commitAllowEmpty = false }()
+	if err := executeCommit(commitCmd, nil); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+
+	if err := executeUpdateRef(updateRefCmd, []string{"refs/tags/v1", "HEAD"}); err != nil {
+		return fmt.Errorf("update-ref: %w", err)
+	}
+
+	packRefsAll = true
+	defer func() { packRefsAll = false }()
+	if err := executePackRefs(packRefsCmd, nil); err != nil {
+		return fmt.Errorf("pack-refs: %w", err)
+	}
+
+	if err := executeUpdateRef(updateRefCmd, []string{"refs/heads/topic", "HEAD"}); err != nil {
+		return fmt.Errorf("update-ref: %w", err)
+	}
+
+	return nil
+}
+
+// TestCrashHelper is run in a subprocess by TestCrashRecovery. It runs the
+// workload and exits abruptly on reaching the given fault point. Whatever it
+// wrote stays in the page cache, so this checks that every change is atomic
+// against the process being interrupted, not that it survives a power loss:
+// that depends on fsync, which nothing here can observe.
+func TestCrashHelper(t *testing.T) {
+	dir := os.Getenv(envCrashDir)
+	if dir == "" {
+		t.Skip("only run as a subprocess of TestCrashRecovery")
+	}
+	crashPoint, err := strconv.Atoi(os.Getenv(envCrashPoint))
+	if err != nil {
+		t.Fatalf("bad crash point: %v", err)
+	}
+
+	stdout, stderr = ioutil.Discard, ioutil.Discard
+	getenv = func(key string) string {
+		return map[string]string{EnvAuthorName: "Nathan Smith", EnvAuthorEmail: "nathan@neocortical.net"}[key]
+	}
+	wd = dir
+
+	reached := 0
+	faults.SetHook(func(point string) {
+		reached++
+		if reached == crashPoint {
+			os.Exit(crashExitCode)
+		}
+	})
+
+	if err = crashWorkload(); err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+}
+
+func TestCrashRecovery(t *testing.T) {
+	if testing.Short() {
+		t.Skip("crash recovery runs the workload once per fault point")
+	}
+
+	t.Run("loose", func(t *testing.T) {
+		crashRecovery(t, "[core]\n\tfsync = all\n")
+	})
+	t.Run("kv", func(t *testing.T) {
+		crashRecovery(t, "[core]\n\tfsync = all\n[storage]\n\tobjects = kv\n")
+	})
+}
+
+// crashRecovery interrupts the workload at each fault point in turn in a
+// repository with the given config.
+func crashRecovery(t *testing.T, config string) {
+	for crashPoint := 1; ; crashPoint++ {
+		if crashPoint > maxCrashPoints {
+			t.Fatalf("workload still crashing after %d fault points", maxCrashPoints)
+		}
+
+		_, _ = setUpTestWorkspace(t, nil)
+		initOrDie(t)
+		writeFile(t, path.Join(repository.GitDir, "config"), config)
+		writeFile(t, "1.txt", "one")
+		commitOrDie(t, "first")
+		writeFile(t, "2.txt", "two")
+		writeFile(t, "dir/3.txt", "three")
+
+		child := exec.Command(os.Args[0], "-test.run=^TestCrashHelper$")
+		child.Env = append(os.Environ(), envCrashDir+"="+wd, envCrashPoint+"="+strconv.Itoa(crashPoint))
+		output, err := child.CombinedOutput()
+
+		var exitErr *exec.ExitError
+		if err == nil {
+			// the workload finished before reaching the fault point, so
+			// every point has been tried
+			verifyRepositoryOrDie(t, crashPoint)
+			tearDownTestWorkspace()
+			return
+		}
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() != crashExitCode {
+			t.Fatalf("crash point %d: workload failed: %v\n%s", crashPoint, err, output)
+		}
+
+		verifyRepositoryOrDie(t, crashPoint)
+
+		// the crashed process's locks are stale, and once they're gone the
+		// workload must be able to run to completion
+		locksPrune = true
+		err = executeLocks(locksCmd, nil)
+		locksPrune = false
+		if err != nil {
+			t.Fatalf("crash point %d: error pruning locks: %v", crashPoint, err)
+		}
+		if err = crashWorkload(); err != nil {
+			t.Fatalf("crash point %d: expected the workload to succeed after recovery but got: %v", crashPoint, err)
+		}
+		verifyRepositoryOrDie(t, crashPoint)
+
+		tearDownTestWorkspace()
+	}
+}
+
+// verifyRepositoryOrDie checks that fsck finds no errors: every object is
+// intact, and everything reachable from HEAD, refs, reflogs and the index is
+// present. Leftover temporary files are only warnings.
+func verifyRepositoryOrDie(t *testing.T, crashPoint int) {
+	report, err := fsck.Check(repository.NewRepo(wd), fsck.Options{})
+	if err != nil {
+		t.Fatalf("crash point %d: error checking repository: %v", crashPoint, err)
+	}
+	if !report.OK() {
+		t.Fatalf("crash point %d: repository is corrupt: %v (missing %v)", crashPoint, report.Errors, report.Missing)
+	}
+}
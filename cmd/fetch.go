@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/neocortical/got/refspec"
+	"github.com/neocortical/got/remote"
+	"github.com/neocortical/got/repository"
+	"github.com/spf13/cobra"
+)
+
+var (
+	fetchCmd = &cobra.Command{
+		Use:   "fetch [--force] [<repository> [<refspec>...]]",
+		Short: "Download objects and refs from another repository.",
+		RunE:  executeFetch,
+	}
+	fetchForce      bool
+	fetchUploadPack string
+)
+
+func init() {
+	fetchCmd.Flags().BoolVarP(&fetchForce, "force", "f", false, "Update local refs even if the updates aren't fast-forwards")
+	fetchCmd.Flags().StringVar(&fetchUploadPack, "upload-pack", "", "Fetch through this command, run with the remote repository's path, such as 'git-upload-pack'")
+}
+
+func executeFetch(cmd *cobra.Command, args []string) error {
+	repo := repository.NewRepo(wd)
+
+	var name string
+	var specs []string
+	if len(args) > 0 {
+		name, specs = args[0], args[1:]
+	}
+	rem, err := openRemote(repo, name, "remote")
+	if err != nil {
+		return err
+	}
+
+	opts := remote.FetchOptions{
+		Force:      fetchForce,
+		Committer:  committerIdentity(),
+		Message:    strings.TrimSpace("fetch " + strings.Join(args, " ")),
+		UploadPack: fetchUploadPack,
+		Progress:   stderr,
+	}
+	for _, arg := range specs {
+		spec, err := refspec.ParseFetch(arg)
+		if err != nil {
+			return err
+		}
+		opts.Refspecs = append(opts.Refspecs, spec)
+	}
+
+	updates, err := remote.Fetch(repo, rem, wd, opts)
+	if err != nil {
+		return err
+	}
+
+	// like git, line up the arrows of all but the longest names
+	width := 10
+	for _, u := range updates {
+		if u.Status != remote.StatusUpToDate && len(shortRefName(u.Src)) > width {
+			width = len(shortRefName(u.Src))
+		}
+	}
+
+	failed := false
+	header := false
+	for _, u := range updates {
+		if u.Status == remote.StatusUpToDate {
+			continue
+		}
+		if !header {
+			fmt.Fprintf(stderr, "From %s\n", rem.URL)
+			header = true
+		}
+
+		flag, summary, suffix := formatRefUpdate(u, false)
+		dst := shortRefName(u.Dst)
+		if u.Dst == "" {
+			dst = "FETCH_HEAD"
+		}
+		if suffix != "" {
+			suffix = "  (" + suffix + ")"
+		}
+		fmt.Fprintf(stderr, " %c %-17s %-*s -> %s%s\n", flag, summary, width, shortRefName(u.Src), dst, suffix)
+		failed = failed || !u.OK()
+	}
+
+	if failed {
+		return errors.New("some local refs could not be updated")
+	}
+
+	return nil
+}
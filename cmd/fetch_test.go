@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/neocortical/got/repository"
+)
+
+func resetFetchFlags() {
+	fetchForce = false
+}
+
+func TestFetch(t *testing.T) {
+	_, errbuf := setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+	defer resetFetchFlags()
+
+	initOrDie(t)
+	writeFile(t, "foo.txt", "one")
+	commitOrDie(t, "first")
+	source := repository.NewRepo(wd)
+	first, _ := source.Refs().ReadHead()
+	dir := cloneOrDie(t, "copy")
+
+	writeFile(t, "foo.txt", "two")
+	commitOrDie(t, "second")
+	second, _ := source.Refs().ReadHead()
+	if err := executeUpdateRef(updateRefCmd, []string{"refs/tags/v1", second}); err != nil {
+		t.Fatalf("error creating tag: %v", err)
+	}
+
+	inDir(dir, func() {
+		errbuf.Reset()
+		if err := executeFetch(fetchCmd, nil); err != nil {
+			t.Fatalf("expected no errors but got: %v", err)
+		}
+		expected := "From " + filepath.Dir(dir) + "\n" +
+			"   " + first[:7] + ".." + second[:7] + "  master     -> origin/master\n" +
+			" * [new tag]         v1         -> v1\n"
+		if errbuf.String() != expected {
+			t.Errorf("expected:\n%s\nbut got:\n%s", expected, errbuf.String())
+		}
+
+		repo := repository.NewRepo(wd)
+		if oid, _ := repo.Refs().ReadRef("refs/remotes/origin/master"); oid != second {
+			t.Errorf("expected origin/master to be %s but got: '%s'", second, oid)
+		}
+		if !repo.Database().Has(second) {
+			t.Errorf("expected %s to be fetched", second)
+		}
+		data, _ := ioutil.ReadFile(filepath.Join(repo.Dir(), "FETCH_HEAD"))
+		if !strings.HasPrefix(string(data), second+"\t\tbranch 'master' of ") {
+			t.Errorf("unexpected FETCH_HEAD: '%s'", data)
+		}
+
+		// nothing new
+		errbuf.Reset()
+		if err := executeFetch(fetchCmd, []string{"origin"}); err != nil {
+			t.Fatalf("expected no errors but got: %v", err)
+		}
+		if errbuf.String() != "" {
+			t.Errorf("unexpected output: '%s'", errbuf.String())
+		}
+
+		// explicit refspecs aren't forced
+		errbuf.Reset()
+		if err := executeFetch(fetchCmd, []string{"origin", "master:refs/heads/old"}); err != nil {
+			t.Fatalf("expected no errors but got: %v", err)
+		}
+		if oid, _ := repo.Refs().ReadRef("refs/heads/old"); oid != second {
+			t.Errorf("expected refs/heads/old to be %s but got: '%s'", second, oid)
+		}
+		if err := executeFetch(fetchCmd, []string{"origin", "master:refs/heads/master"}); err == nil {
+			t.Errorf("expected an error fetching into the current branch")
+		}
+	})
+
+	if err := executeUpdateRef(updateRefCmd, []string{"refs/heads/master", first}); err != nil {
+		t.Fatalf("error resetting master: %v", err)
+	}
+
+	inDir(dir, func() {
+		errbuf.Reset()
+		err := executeFetch(fetchCmd, []string{"origin", "master:refs/heads/old"})
+		if err == nil {
+			t.Errorf("expected an error for a non-fast-forward fetch")
+		}
+		if !strings.Contains(errbuf.String(), " ! [rejected]        master     -> old  (non-fast-forward)\n") {
+			t.Errorf("unexpected output: '%s'", errbuf.String())
+		}
+
+		errbuf.Reset()
+		fetchForce = true
+		if err = executeFetch(fetchCmd, []string{"origin", "master:refs/heads/old"}); err != nil {
+			t.Fatalf("expected no errors but got: %v", err)
+		}
+		if !strings.Contains(errbuf.String(), " + "+second[:7]+"..."+first[:7]+" master     -> old  (forced update)\n") {
+			t.Errorf("unexpected output: '%s'", errbuf.String())
+		}
+
+		// the default refspec is forced
+		fetchForce = false
+		if err = executeFetch(fetchCmd, nil); err != nil {
+			t.Fatalf("expected no errors but got: %v", err)
+		}
+		if oid, _ := repository.NewRepo(wd).Refs().ReadRef("refs/remotes/origin/master"); oid != first {
+			t.Errorf("expected origin/master to be %s but got: '%s'", first, oid)
+		}
+	})
+}
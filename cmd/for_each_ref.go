@@ -0,0 +1,484 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/neocortical/got/ref"
+	"github.com/neocortical/got/repository"
+	"github.com/neocortical/got/revision"
+	"github.com/neocortical/got/wildmatch"
+	"github.com/spf13/cobra"
+)
+
+const (
+	defaultForEachRefFormat = "%(objectname) %(objecttype)\t%(refname)"
+	defaultDateLayout       = "Mon Jan 2 15:04:05 2006 -0700"
+)
+
+var (
+	forEachRefCmd = &cobra.Command{
+		Use:   "for-each-ref [--format=<format>] [--sort=<key>...] [--count=<n>] [<pattern>...]",
+		Short: "Print information about each ref.",
+		RunE:  executeForEachRef,
+	}
+	forEachRefFormat string
+	forEachRefSort   []string
+	forEachRefCount  int
+
+	dateLayouts = map[string]string{
+		"":           defaultDateLayout,
+		"default":    defaultDateLayout,
+		"iso":        "2006-01-02 15:04:05 -0700",
+		"iso8601":    "2006-01-02 15:04:05 -0700",
+		"iso-strict": "2006-01-02T15:04:05-07:00",
+		"rfc":        "Mon, 2 Jan 2006 15:04:05 -0700",
+		"rfc2822":    "Mon, 2 Jan 2006 15:04:05 -0700",
+		"short":      "2006-01-02",
+	}
+)
+
+func init() {
+	forEachRefCmd.Flags().StringVar(&forEachRefFormat, "format", defaultForEachRefFormat, "Format to print each ref with, using %(fieldname) atoms")
+	forEachRefCmd.Flags().StringArrayVar(&forEachRefSort, "sort", nil, "Field to sort on, prefixed with - for descending order; the last key given is the primary one")
+	forEachRefCmd.Flags().IntVar(&forEachRefCount, "count", 0, "Stop after showing this many refs")
+}
+
+func executeForEachRef(cmd *cobra.Command, args []string) (err error) {
+	repo := repository.NewRepo(wd)
+
+	all, err := repo.Refs().List("refs/")
+	if err != nil {
+		return err
+	}
+
+	var matched []ref.Reference
+	for _, r := range all {
+		if forEachRefMatch(r.Name, args) {
+			matched = append(matched, r)
+		}
+	}
+
+	f, err := newRefFormatter(repo)
+	if err != nil {
+		return err
+	}
+
+	keys := forEachRefSort
+	if len(keys) == 0 {
+		keys = []string{"refname"}
+	}
+	if err = f.sort(matched, keys); err != nil {
+		return err
+	}
+
+	if forEachRefCount > 0 && len(matched) > forEachRefCount {
+		matched = matched[:forEachRefCount]
+	}
+
+	for _, r := range matched {
+		line, err := f.format(forEachRefFormat, r)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(stdout, line)
+	}
+
+	return nil
+}
+
+// forEachRefMatch reports whether a ref is selected by the patterns, which are
+// either prefixes ending at a component boundary or wildcard patterns.
+func forEachRefMatch(name string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	for _, pattern := range patterns {
+		if wildmatch.HasWildcards(pattern) {
+			if wildmatch.Match(pattern, name, wildmatch.PathName) {
+				return true
+			}
+			continue
+		}
+
+		if name == pattern || strings.HasPrefix(name, strings.TrimSuffix(pattern, "/")+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// formatObject holds the parts of an object that format atoms refer to.
+type formatObject struct {
+	typ     string
+	size    int
+	headers map[string][]string
+	message string
+}
+
+type refFormatter struct {
+	repo    *repository.Repo
+	head    string
+	objects map[string]*formatObject
+}
+
+func newRefFormatter(repo *repository.Repo) (*refFormatter, error) {
+	head, err := repo.Refs().ReadSymbolicRef("HEAD")
+	if err != nil {
+		return nil, err
+	}
+
+	return &refFormatter{repo: repo, head: head, objects: map[string]*formatObject{}}, nil
+}
+
+func (f *refFormatter) object(oid string) (*formatObject, error) {
+	if obj, ok := f.objects[oid]; ok {
+		return obj, nil
+	}
+
+	stored, err := f.repo.Database().Read(oid)
+	if err != nil {
+		return nil, fmt.Errorf("missing object %s: %w", oid, err)
+	}
+
+	data := stored.Serialize()
+	obj := &formatObject{typ: stored.Type(), size: len(data), headers: map[string][]string{}}
+
+	if obj.typ == ref.TypeCommit || obj.typ == "tag" {
+		text := string(data)
+		header := text
+		if end := strings.Index(text, "\n\n"); end != -1 {
+			header, obj.message = text[:end], text[end+2:]
+		}
+		for _, line := range strings.Split(header, "\n") {
+			if space := strings.Index(line, " "); space != -1 {
+				obj.headers[line[:space]] = append(obj.headers[line[:space]], line[space+1:])
+			}
+		}
+	}
+
+	f.objects[oid] = obj
+	return obj, nil
+}
+
+// format expands the %(atom), %% and %xx placeholders of a format string.
+func (f *refFormatter) format(format string, r ref.Reference) (string, error) {
+	var out strings.Builder
+
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c != '%' || i+1 == len(format) {
+			out.WriteByte(c)
+			continue
+		}
+
+		switch {
+		case format[i+1] == '%':
+			out.WriteByte('%')
+			i++
+		case format[i+1] == '(':
+			end := strings.IndexByte(format[i:], ')')
+			if end == -1 {
+				return "", fmt.Errorf("malformed format string %s", format[i:])
+			}
+			value, err := f.atom(r, format[i+2:i+end])
+			if err != nil {
+				return "", err
+			}
+			out.WriteString(value)
+			i += end
+		case i+2 < len(format):
+			b, err := strconv.ParseUint(format[i+1:i+3], 16, 8)
+			if err != nil {
+				out.WriteByte(c)
+				continue
+			}
+			out.WriteByte(byte(b))
+			i += 2
+		default:
+			out.WriteByte(c)
+		}
+	}
+
+	return out.String(), nil
+}
+
+func (f *refFormatter) atom(r ref.Reference, atom string) (value string, err error) {
+	oid := r.OID
+	if strings.HasPrefix(atom, "*") {
+		// the starred form describes the object a tag points to
+		atom = atom[1:]
+		oid = r.Peeled
+		if oid == "" {
+			if oid, err = revision.PeelTags(f.repo, r.OID); err != nil {
+				return "", err
+			}
+		}
+		if oid == "" {
+			return "", nil
+		}
+	}
+
+	name, modifier := atom, ""
+	if colon := strings.Index(atom, ":"); colon != -1 {
+		name, modifier = atom[:colon], atom[colon+1:]
+	}
+
+	switch name {
+	case "refname":
+		return f.refName(r.Name, modifier)
+	case "symref":
+		if r.Target == "" {
+			return "", nil
+		}
+		return f.refName(r.Target, modifier)
+	case "HEAD":
+		if r.Name == f.head {
+			return "*", nil
+		}
+		return " ", nil
+	case "upstream", "push", "color", "align", "end":
+		// remote tracking and presentation atoms have nothing to show yet
+		return "", nil
+	case "objectname":
+		switch {
+		case modifier == "":
+			return oid, nil
+		case modifier == "short":
+			return abbreviate(oid), nil
+		case strings.HasPrefix(modifier, "short="):
+			n, err := strconv.Atoi(modifier[len("short="):])
+			if err != nil || n < 4 {
+				n = 4
+			}
+			if n > len(oid) {
+				n = len(oid)
+			}
+			return oid[:n], nil
+		}
+		return "", fmt.Errorf("unrecognized %%(objectname) argument: %s", modifier)
+	}
+
+	obj, err := f.object(oid)
+	if err != nil {
+		return "", err
+	}
+
+	switch name {
+	case "objecttype":
+		return obj.typ, nil
+	case "objectsize":
+		return strconv.Itoa(obj.size), nil
+	case "tree", "parent", "object", "type", "tag":
+		return strings.Join(obj.headers[name], " "), nil
+	case "subject", "body", "contents":
+		return messageAtom(obj.message, name, modifier)
+	case "creator", "creatordate":
+		name = strings.Replace(name, "creator", "committer", 1)
+		if obj.typ == "tag" {
+			name = strings.Replace(name, "committer", "tagger", 1)
+		}
+	}
+
+	for _, role := range []string{"author", "committer", "tagger"} {
+		if strings.HasPrefix(name, role) {
+			return identityAtom(obj.headers[role], strings.TrimPrefix(name, role), modifier)
+		}
+	}
+
+	return "", fmt.Errorf("unknown field name: %s", name)
+}
+
+// refName applies the :short, :lstrip=N (or :strip=N) and :rstrip=N
+// modifiers to a ref name.
+func (f *refFormatter) refName(name string, modifier string) (string, error) {
+	switch {
+	case modifier == "":
+		return name, nil
+	case modifier == "short":
+		return revision.ShortenRef(f.repo, name), nil
+	}
+
+	var n int
+	var left bool
+	var err error
+	switch {
+	case strings.HasPrefix(modifier, "strip="):
+		n, err = strconv.Atoi(modifier[len("strip="):])
+		left = true
+	case strings.HasPrefix(modifier, "lstrip="):
+		n, err = strconv.Atoi(modifier[len("lstrip="):])
+		left = true
+	case strings.HasPrefix(modifier, "rstrip="):
+		n, err = strconv.Atoi(modifier[len("rstrip="):])
+	default:
+		return "", fmt.Errorf("unrecognized %%(refname) argument: %s", modifier)
+	}
+	if err != nil {
+		return "", fmt.Errorf("unrecognized %%(refname) argument: %s", modifier)
+	}
+
+	components := strings.Split(name, "/")
+	if n < 0 {
+		// negative counts say how many components to keep
+		n = len(components) + n
+		if n < 0 {
+			n = 0
+		}
+	}
+	if n > len(components) {
+		n = len(components)
+	}
+
+	if left {
+		return strings.Join(components[n:], "/"), nil
+	}
+	return strings.Join(components[:len(components)-n], "/"), nil
+}
+
+// identityAtom formats an author, committer or tagger header, or its name,
+// email or date part.
+func identityAtom(values []string, part string, modifier string) (string, error) {
+	if len(values) == 0 {
+		return "", nil
+	}
+	if part == "" {
+		return values[0], nil
+	}
+
+	who, err := ref.ParseAuthor(values[0])
+	if err != nil {
+		return "", err
+	}
+
+	switch part {
+	case "name":
+		return who.Name, nil
+	case "email":
+		switch modifier {
+		case "":
+			return "<" + who.Email + ">", nil
+		case "trim":
+			return who.Email, nil
+		case "localpart":
+			return strings.SplitN(who.Email, "@", 2)[0], nil
+		}
+		return "", fmt.Errorf("unrecognized email argument: %s", modifier)
+	case "date":
+		switch modifier {
+		case "unix":
+			return strconv.FormatInt(who.Time.Unix(), 10), nil
+		case "raw":
+			return fmt.Sprintf("%d %s", who.Time.Unix(), who.Time.Format("-0700")), nil
+		}
+		layout, ok := dateLayouts[modifier]
+		if !ok {
+			return "", fmt.Errorf("unknown date format: %s", modifier)
+		}
+		return who.Time.Format(layout), nil
+	}
+
+	return "", fmt.Errorf("unknown field name: %s", part)
+}
+
+// messageAtom formats the subject (the first paragraph, on one line), the
+// body (the rest) or the whole message of a commit or tag.
+func messageAtom(message string, name string, modifier string) (string, error) {
+	if name == "contents" && modifier != "" {
+		name, modifier = modifier, ""
+	}
+	if modifier != "" {
+		return "", fmt.Errorf("unrecognized %%(%s) argument: %s", name, modifier)
+	}
+
+	subject, body := message, ""
+	if end := strings.Index(message, "\n\n"); end != -1 {
+		subject, body = message[:end], strings.TrimLeft(message[end+2:], "\n")
+	}
+
+	switch name {
+	case "contents":
+		return message, nil
+	case "subject":
+		return strings.Join(strings.Fields(subject), " "), nil
+	case "body":
+		return body, nil
+	}
+
+	return "", fmt.Errorf("unrecognized %%(contents) argument: %s", name)
+}
+
+// sort orders refs by the given keys, the last of which is the primary one.
+// Sizes and dates compare numerically.
+func (f *refFormatter) sort(refs []ref.Reference, keys []string) (err error) {
+	type sortValue struct {
+		text    string
+		number  int64
+		numeric bool
+	}
+
+	values := make([][]sortValue, len(refs))
+	for i, r := range refs {
+		for _, key := range keys {
+			atom := strings.TrimPrefix(key, "-")
+			numeric := atom == "objectsize" || strings.HasSuffix(atom, "date")
+			if strings.HasSuffix(atom, "date") {
+				atom += ":unix"
+			}
+
+			text, err := f.atom(r, atom)
+			if err != nil {
+				return err
+			}
+
+			v := sortValue{text: text, numeric: numeric}
+			if numeric {
+				v.number, _ = strconv.ParseInt(text, 10, 64)
+			}
+			values[i] = append(values[i], v)
+		}
+	}
+
+	indices := make([]int, len(refs))
+	for i := range indices {
+		indices[i] = i
+	}
+
+	sort.SliceStable(indices, func(a, b int) bool {
+		for k := len(keys) - 1; k >= 0; k-- {
+			va, vb := values[indices[a]][k], values[indices[b]][k]
+
+			cmp := strings.Compare(va.text, vb.text)
+			if va.numeric {
+				cmp = 0
+				if va.number < vb.number {
+					cmp = -1
+				} else if va.number > vb.number {
+					cmp = 1
+				}
+			}
+			if cmp == 0 {
+				continue
+			}
+
+			if strings.HasPrefix(keys[k], "-") {
+				return cmp > 0
+			}
+			return cmp < 0
+		}
+
+		return refs[indices[a]].Name < refs[indices[b]].Name
+	})
+
+	sorted := make([]ref.Reference, len(refs))
+	for i, index := range indices {
+		sorted[i] = refs[index]
+	}
+	copy(refs, sorted)
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestForEachRef(t *testing.T) {
+	outbuf, _ := setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+	defer func() { forEachRefFormat, forEachRefSort, forEachRefCount = defaultForEachRefFormat, nil, 0 }()
+
+	initOrDie(t)
+	writeFile(t, "1.txt", "one")
+	commitOrDie(t, "first line\nsecond line\n\nthe body")
+	head := readRefOrDie(t, "HEAD")
+	for _, name := range []string{"refs/heads/a/b", "refs/tags/v1"} {
+		if err := executeUpdateRef(updateRefCmd, []string{name, head}); err != nil {
+			t.Fatalf("error creating %s: %v", name, err)
+		}
+	}
+
+	var tests = []struct {
+		format   string
+		sort     []string
+		count    int
+		patterns []string
+		expected string
+	}{
+		{defaultForEachRefFormat, nil, 0, []string{"refs/heads"},
+			fmt.Sprintf("%[1]s commit\trefs/heads/a/b\n%[1]s commit\trefs/heads/master\n", head)},
+		{"%(refname:short) %(refname:lstrip=-1) %(refname:rstrip=1)%(HEAD)", []string{"-refname"}, 2, nil,
+			"v1 v1 refs/tags \nmaster master refs/heads*\n"},
+		{"%(subject)|%(body)|%(authorname) %(authoremail:trim)%%%0a", nil, 1, []string{"refs/tags/*"},
+			"first line second line|the body\n|Nathan Smith nathan@neocortical.net%\n\n"},
+		{"%(objectname:short=10) %(*objectname)", nil, 0, []string{"refs/tags/v*"}, head[:10] + " \n"},
+	}
+
+	for i, test := range tests {
+		forEachRefFormat, forEachRefSort, forEachRefCount = test.format, test.sort, test.count
+		outbuf.Reset()
+
+		err := executeForEachRef(forEachRefCmd, test.patterns)
+		if err != nil {
+			t.Errorf("test %d: expected no errors but got: %v", i, err)
+		}
+		if outbuf.String() != test.expected {
+			t.Errorf("test %d: expected output '%s' but got '%s'", i, test.expected, outbuf.String())
+		}
+	}
+
+	forEachRefFormat = "%(bogus)"
+	if err := executeForEachRef(forEachRefCmd, nil); err == nil {
+		t.Error("expected an error for an unknown atom")
+	}
+}
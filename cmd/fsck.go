@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/neocortical/got/fsck"
+	"github.com/neocortical/got/repository"
+	"github.com/spf13/cobra"
+)
+
+var (
+	fsckCmd = &cobra.Command{
+		Use:   "fsck [--unreachable] [--lost-found] [--no-reflogs]",
+		Short: "Verify the connectivity and validity of the objects in the repository.",
+		Args:  cobra.NoArgs,
+		RunE:  executeFsck,
+	}
+	fsckUnreachable bool
+	fsckLostFound   bool
+	fsckNoReflogs   bool
+)
+
+func init() {
+	fsckCmd.Flags().BoolVar(&fsckUnreachable, "unreachable", false, "Print all unreachable objects, not just dangling ones")
+	fsckCmd.Flags().BoolVar(&fsckLostFound, "lost-found", false, "Write dangling objects into .git/lost-found (implies --no-reflogs)")
+	fsckCmd.Flags().BoolVar(&fsckNoReflogs, "no-reflogs", false, "Don't consider commits referenced only by reflogs to be reachable")
+}
+
+func executeFsck(cmd *cobra.Command, args []string) (err error) {
+	repo := repository.NewRepo(wd)
+
+	report, err := fsck.Check(repo, fsck.Options{NoReflogs: fsckNoReflogs || fsckLostFound})
+	if err != nil {
+		return err
+	}
+
+	for _, message := range report.Warnings {
+		fmt.Fprintln(stderr, message)
+	}
+	for _, message := range report.Errors {
+		fmt.Fprintln(stderr, message)
+	}
+	for _, obj := range report.Missing {
+		fmt.Fprintf(stdout, "missing %s %s\n", obj.Type, obj.OID)
+	}
+
+	if fsckUnreachable {
+		for _, obj := range report.Unreachable {
+			fmt.Fprintf(stdout, "unreachable %s %s\n", obj.Type, obj.OID)
+		}
+	} else {
+		for _, obj := range report.Dangling {
+			fmt.Fprintf(stdout, "dangling %s %s\n", obj.Type, obj.OID)
+		}
+	}
+
+	if fsckLostFound {
+		if err = fsck.WriteLostFound(repo, report.Dangling); err != nil {
+			return err
+		}
+	}
+
+	if !report.OK() {
+		return silentFailure(cmd)
+	}
+
+	return nil
+}
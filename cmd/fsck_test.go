@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/neocortical/got/blob"
+	"github.com/neocortical/got/repository"
+)
+
+func resetFsckFlags() {
+	fsckUnreachable = false
+	fsckLostFound = false
+	fsckNoReflogs = false
+}
+
+func TestFsckCleanRepository(t *testing.T) {
+	outbuf, errbuf := setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+
+	initOrDie(t)
+	writeFile(t, "foo.txt", "one")
+	writeFile(t, "foo/bar.txt", "two")
+	commitOrDie(t, "first")
+	outbuf.Reset()
+
+	err := executeFsck(fsckCmd, nil)
+	if err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	if outbuf.Len() != 0 || errbuf.Len() != 0 {
+		t.Errorf("expected no output but got: '%s' '%s'", outbuf.String(), errbuf.String())
+	}
+}
+
+func TestFsckDanglingObjects(t *testing.T) {
+	outbuf, _ := setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+	defer resetFsckFlags()
+
+	initOrDie(t)
+	writeFile(t, "1.txt", "one")
+	commitOrDie(t, "first")
+	outbuf.Reset()
+
+	oid, err := repository.NewRepo(wd).Database().Store(blob.New([]byte("lost\n")))
+	if err != nil {
+		t.Fatalf("error storing blob: %v", err)
+	}
+
+	err = executeFsck(fsckCmd, nil)
+	if err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	expected := "dangling blob " + oid + "\n"
+	if outbuf.String() != expected {
+		t.Errorf("expected output '%s' but got: '%s'", expected, outbuf.String())
+	}
+
+	outbuf.Reset()
+	fsckUnreachable = true
+	err = executeFsck(fsckCmd, nil)
+	if err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	expected = "unreachable blob " + oid + "\n"
+	if outbuf.String() != expected {
+		t.Errorf("expected output '%s' but got: '%s'", expected, outbuf.String())
+	}
+
+	outbuf.Reset()
+	fsckUnreachable = false
+	fsckLostFound = true
+	err = executeFsck(fsckCmd, nil)
+	if err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	data, err := ioutil.ReadFile(path.Join(wd, repository.GitDir, "lost-found", "other", oid))
+	if err != nil || string(data) != "lost\n" {
+		t.Errorf("expected the blob's contents in lost-found but got '%s' (%v)", data, err)
+	}
+}
+
+func TestFsckCorruptObject(t *testing.T) {
+	outbuf, errbuf := setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+
+	initOrDie(t)
+	writeFile(t, "1.txt", "one")
+	commitOrDie(t, "first")
+	outbuf.Reset()
+
+	// 1.txt's blob
+	oid := "43dd47ea691c90a5fa7827892c70241913351963"
+	objectPath := path.Join(wd, repository.GitDir, "objects", oid[:2], oid[2:])
+	os.Chmod(objectPath, 0644)
+	if err := os.Truncate(objectPath, 10); err != nil {
+		t.Fatalf("error truncating object: %v", err)
+	}
+
+	err := executeFsck(fsckCmd, nil)
+	if err != errSilentFailure {
+		t.Fatalf("expected fsck to fail but got: %v", err)
+	}
+	if !strings.Contains(errbuf.String(), "error: "+oid+": object corrupt or missing") {
+		t.Errorf("expected the object to be reported as corrupt but got: '%s'", errbuf.String())
+	}
+	if outbuf.String() != "missing blob "+oid+"\n" {
+		t.Errorf("expected the object to be reported as missing but got: '%s'", outbuf.String())
+	}
+}
@@ -4,24 +4,37 @@ import (
 	"fmt"
 	"path/filepath"
 
+	"github.com/neocortical/got/objectformat"
 	"github.com/neocortical/got/repository"
 	"github.com/spf13/cobra"
 )
 
-var initCmd = &cobra.Command{
-	Use:   "init [path]",
-	Short: "Initialize a new repository.",
-	Args:  cobra.MaximumNArgs(1),
-	RunE:  executeInit,
+var (
+	initCmd = &cobra.Command{
+		Use:   "init [--object-format=<format>] [path]",
+		Short: "Initialize a new repository.",
+		Args:  cobra.MaximumNArgs(1),
+		RunE:  executeInit,
+	}
+	initObjectFormat string
+)
+
+func init() {
+	initCmd.Flags().StringVar(&initObjectFormat, "object-format", objectformat.Default.String(), "The hash objects are named by: sha1 or sha256")
 }
 
 func executeInit(cmd *cobra.Command, args []string) error {
+	format, err := objectformat.Parse(initObjectFormat)
+	if err != nil {
+		return err
+	}
+
 	basePath, err := getInitPath(args)
 	if err != nil {
 		return fmt.Errorf("error parsing workspace directory: %w", err)
 	}
 
-	repo, err := repository.Init(basePath)
+	repo, err := repository.Init(basePath, format)
 	if err != nil {
 		return err
 	}
@@ -31,7 +44,7 @@ func executeInit(cmd *cobra.Command, args []string) error {
 }
 
 func getInitPath(args []string) (path string, err error) {
-	path = wd
+	path = fromCurrentDir("")
 
 	if len(args) > 0 {
 		path = args[0]
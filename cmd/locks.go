@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/neocortical/got/lock"
+	"github.com/neocortical/got/repository"
+	"github.com/spf13/cobra"
+)
+
+var (
+	locksCmd = &cobra.Command{
+		Use:   "locks [--prune]",
+		Short: "List lock files in the repository and whether they're stale.",
+		Args:  cobra.NoArgs,
+		RunE:  executeLocks,
+	}
+	locksPrune bool
+)
+
+func init() {
+	locksCmd.Flags().BoolVar(&locksPrune, "prune", false, "Remove locks left behind by processes that are no longer running")
+}
+
+func executeLocks(cmd *cobra.Command, args []string) (err error) {
+	gitDir := repository.NewRepo(wd).Dir()
+
+	lockfiles, orphans, err := findLockfiles(gitDir)
+	if err != nil {
+		return err
+	}
+
+	for _, lockfile := range lockfiles {
+		info, err := lock.Inspect(lockfile)
+		if os.IsNotExist(err) {
+			// released since we looked
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		rel, _ := filepath.Rel(gitDir, lockfile)
+		if locksPrune && info.State == lock.StateStale {
+			if err = lock.RemoveStale(lockfile); err != nil {
+				return err
+			}
+			fmt.Fprintf(stdout, "removed %s (%s)\n", rel, info.Reason)
+			continue
+		}
+
+		fmt.Fprintf(stdout, "%s: %s (%s)\n", rel, info.State, info.Reason)
+	}
+
+	// owner files left behind without their lock carry no information
+	if locksPrune {
+		for _, orphan := range orphans {
+			os.Remove(orphan)
+		}
+	}
+
+	return nil
+}
+
+// findLockfiles returns the lock files under the git directory, and owner
+// files whose lock no longer exists. The object database is skipped, as it
+// doesn't use locks.
+func findLockfiles(gitDir string) (lockfiles []string, orphans []string, err error) {
+	objectsDir := filepath.Join(gitDir, "objects")
+
+	err = filepath.Walk(gitDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			// loose objects are never locked, and there can be a lot of them
+			if filepath.Dir(p) == objectsDir && len(info.Name()) == 2 {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		switch {
+		case strings.HasSuffix(p, ".lock"):
+			lockfiles = append(lockfiles, p)
+		case strings.HasSuffix(p, ".lock"+lock.OwnerSuffix):
+			if _, err := os.Stat(strings.TrimSuffix(p, lock.OwnerSuffix)); os.IsNotExist(err) {
+				orphans = append(orphans, p)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("error searching for locks: %w", err)
+	}
+
+	return lockfiles, orphans, nil
+}
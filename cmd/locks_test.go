@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"testing"
+
+	"github.com/neocortical/got/lock"
+	"github.com/neocortical/got/repository"
+)
+
+func TestLocksPrunesOnlyStaleLocks(t *testing.T) {
+	outbuf, _ := setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+	defer func() { locksPrune = false }()
+
+	initOrDie(t)
+	outbuf.Reset()
+
+	gitDir := path.Join(wd, repository.GitDir)
+	hostname, _ := os.Hostname()
+	stale := path.Join(gitDir, "index.lock")
+	ioutil.WriteFile(stale, nil, 0644)
+	ioutil.WriteFile(stale+lock.OwnerSuffix, []byte(fmt.Sprintf("pid %d\nhost %s\n", 1<<30, hostname)), 0644)
+
+	held := lock.NewLockfile(path.Join(gitDir, "HEAD"))
+	if err := held.Acquire(); err != nil {
+		t.Fatalf("error taking lock: %v", err)
+	}
+	defer held.Rollback()
+
+	err := executeLocks(locksCmd, nil)
+	if err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	expected := regexp.MustCompile(`^HEAD.lock: held \(held by running process [0-9]+\)\nindex.lock: stale \(process [0-9]+ on .* is no longer running\)\n$`)
+	if !expected.Match(outbuf.Bytes()) {
+		t.Errorf("expected output '%s' but got: '%s'", expected.String(), outbuf.String())
+	}
+
+	locksPrune = true
+	outbuf.Reset()
+	err = executeLocks(locksCmd, nil)
+	if err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	if _, err = os.Stat(stale); !os.IsNotExist(err) {
+		t.Error("expected the stale lock to be removed")
+	}
+	if _, err = os.Stat(path.Join(gitDir, "HEAD.lock")); err != nil {
+		t.Error("expected the held lock to be left alone")
+	}
+}
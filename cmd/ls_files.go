@@ -0,0 +1,230 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/neocortical/got/blob"
+	"github.com/neocortical/got/ignore"
+	"github.com/neocortical/got/index"
+	"github.com/neocortical/got/object"
+	"github.com/neocortical/got/objectformat"
+	"github.com/neocortical/got/pathspec"
+	"github.com/neocortical/got/repository"
+	"github.com/neocortical/got/workspace"
+	"github.com/spf13/cobra"
+)
+
+var (
+	lsFilesCmd = &cobra.Command{
+		Use:   "ls-files [options] [pathspec...]",
+		Short: "Show information about files in the index and the working tree.",
+		RunE:  executeLsFiles,
+	}
+	lsFilesCached          bool
+	lsFilesDeleted         bool
+	lsFilesModified        bool
+	lsFilesOthers          bool
+	lsFilesIgnored         bool
+	lsFilesStage           bool
+	lsFilesNullTerm        bool
+	lsFilesExcludeStandard bool
+	lsFilesExclude         []string
+	lsFilesExcludeFrom     []string
+)
+
+func init() {
+	lsFilesCmd.Flags().BoolVarP(&lsFilesCached, "cached", "c", false, "Show cached files (the default)")
+	lsFilesCmd.Flags().BoolVarP(&lsFilesDeleted, "deleted", "d", false, "Show deleted files")
+	lsFilesCmd.Flags().BoolVarP(&lsFilesModified, "modified", "m", false, "Show modified files")
+	lsFilesCmd.Flags().BoolVarP(&lsFilesOthers, "others", "o", false, "Show untracked files")
+	lsFilesCmd.Flags().BoolVarP(&lsFilesIgnored, "ignored", "i", false, "Show only ignored files")
+	lsFilesCmd.Flags().BoolVarP(&lsFilesStage, "stage", "s", false, "Show the mode, object name and stage of each file")
+	lsFilesCmd.Flags().BoolVarP(&lsFilesNullTerm, "null", "z", false, "Terminate entries with NUL bytes")
+	lsFilesCmd.Flags().BoolVar(&lsFilesExcludeStandard, "exclude-standard", false, "Use the standard ignore files")
+	lsFilesCmd.Flags().StringArrayVarP(&lsFilesExclude, "exclude", "x", nil, "Skip untracked files matching a pattern")
+	lsFilesCmd.Flags().StringArrayVarP(&lsFilesExcludeFrom, "exclude-from", "X", nil, "Read exclude patterns from a file")
+}
+
+func executeLsFiles(cmd *cobra.Command, args []string) (err error) {
+	repo := repository.NewRepo(wd)
+	idx := repo.Index()
+
+	// listing cached files is the default
+	cached := lsFilesCached || !(lsFilesDeleted || lsFilesModified || lsFilesOthers || lsFilesStage)
+
+	excludes, err := lsFilesExcludes(repo)
+	if err != nil {
+		return err
+	}
+	if lsFilesIgnored && excludes == nil {
+		return errors.New("ls-files -i must be used with --exclude, --exclude-from or --exclude-standard")
+	}
+
+	err = idx.Load()
+	if err != nil {
+		return fmt.Errorf("error loading index: %w", err)
+	}
+
+	statOptions, err := repo.StatOptions()
+	if err != nil {
+		return err
+	}
+	idx.SetStatOptions(statOptions)
+
+	// like git, list only the current directory unless told otherwise
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+	ps, err := parsePathspec(args)
+	if err != nil {
+		return err
+	}
+
+	if lsFilesOthers {
+		err = showOtherFiles(idx, excludes, ps)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !(cached || lsFilesStage || lsFilesDeleted || lsFilesModified) {
+		return nil
+	}
+
+	for _, e := range idx.AllEntries() {
+		if !ps.Match(e.Path(), false) {
+			continue
+		}
+		if lsFilesIgnored && !excludes.Ignored(e.Path(), false) {
+			continue
+		}
+
+		if cached || lsFilesStage {
+			printIndexEntry(e)
+		}
+
+		if !(lsFilesDeleted || lsFilesModified) {
+			continue
+		}
+
+		info, err := os.Stat(toAbsolutePath(e.Path()))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error reading '%s': %w", e.Path(), err)
+		}
+		deleted := err != nil
+
+		if deleted && lsFilesDeleted {
+			printIndexEntry(e)
+		}
+
+		if lsFilesModified {
+			modified := deleted
+			if !deleted {
+				modified, err = isWorkspaceFileModified(repo.ObjectFormat(), idx, e, info)
+				if err != nil {
+					return err
+				}
+			}
+			if modified {
+				printIndexEntry(e)
+			}
+		}
+	}
+
+	return nil
+}
+
+// lsFilesExcludes builds the ignore rules from the exclude options, returning
+// nil if none were given.
+func lsFilesExcludes(repo *repository.Repo) (result *ignore.Matcher, err error) {
+	if !lsFilesExcludeStandard && len(lsFilesExclude) == 0 && len(lsFilesExcludeFrom) == 0 {
+		return nil, nil
+	}
+
+	result = ignore.New()
+	if lsFilesExcludeStandard {
+		cfg, err := repo.Config()
+		if err != nil {
+			return nil, err
+		}
+		excludesFile, _ := cfg.Get("core.excludesfile")
+
+		result, err = ignore.LoadStandard(wd, excludesFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading ignore files: %w", err)
+		}
+	}
+
+	for _, filename := range lsFilesExcludeFrom {
+		data, err := ioutil.ReadFile(fromCurrentDir(filename))
+		if err != nil {
+			return nil, fmt.Errorf("cannot open '%s': %w", filename, err)
+		}
+		result.AddPatterns("", data)
+	}
+
+	result.AddPatterns("", []byte(strings.Join(lsFilesExclude, "\n")))
+	return result, nil
+}
+
+func showOtherFiles(idx index.Index, excludes *ignore.Matcher, ps *pathspec.Pathspec) error {
+	files, err := workspace.ListFiles(wd)
+	if err != nil {
+		return fmt.Errorf("error walking workspace: %w", err)
+	}
+
+	for _, f := range files {
+		relativePath := filepath.ToSlash(toRelativePath(f.Path))
+		if idx.IsTracked(relativePath) || !ps.Match(relativePath, false) {
+			continue
+		}
+
+		ignored := excludes != nil && excludes.Ignored(relativePath, false)
+		if ignored != lsFilesIgnored {
+			continue
+		}
+
+		fmt.Fprintf(stdout, "%s%s", toCurrentDirPath(relativePath), lsFilesTerminator())
+	}
+
+	return nil
+}
+
+func printIndexEntry(e *index.Entry) {
+	if lsFilesStage {
+		fmt.Fprintf(stdout, "%s %s %d\t%s%s", e.ModeString(), e.OID(), e.Stage(), toCurrentDirPath(e.Path()), lsFilesTerminator())
+	} else {
+		fmt.Fprintf(stdout, "%s%s", toCurrentDirPath(e.Path()), lsFilesTerminator())
+	}
+}
+
+func lsFilesTerminator() string {
+	if lsFilesNullTerm {
+		return "\x00"
+	}
+	return "\n"
+}
+
+// isWorkspaceFileModified compares a tracked file to its index entry, hashing
+// its content only when the stat data is inconclusive.
+func isWorkspaceFileModified(f *objectformat.Format, idx index.Index, e *index.Entry, info os.FileInfo) (bool, error) {
+	statsModified, contentUncertain := idx.IsMetadataModified(e.Path(), info)
+	if statsModified {
+		return true, nil
+	}
+	if !contentUncertain {
+		return false, nil
+	}
+
+	data, err := ioutil.ReadFile(toAbsolutePath(e.Path()))
+	if err != nil {
+		return false, fmt.Errorf("error reading file '%s': %w", e.Path(), err)
+	}
+
+	return object.HashObject(f, blob.New(data)) != e.OID(), nil
+}
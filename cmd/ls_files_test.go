@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func resetLsFilesFlags() {
+	lsFilesCached, lsFilesDeleted, lsFilesModified, lsFilesOthers = false, false, false, false
+	lsFilesIgnored, lsFilesStage, lsFilesNullTerm, lsFilesExcludeStandard = false, false, false, false
+	lsFilesExclude, lsFilesExcludeFrom = nil, nil
+}
+
+func TestLsFilesStage(t *testing.T) {
+	outbuf, errbuf := setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+	defer resetLsFilesFlags()
+
+	setupStatusChangedFixtureOrDie(t)
+	outbuf.Reset()
+
+	lsFilesStage = true
+	err := executeLsFiles(lsFilesCmd, nil)
+	if err != nil {
+		t.Errorf("expected no errors but got: %v", err)
+	}
+	if errbuf.Len() > 0 {
+		t.Errorf("expected no error output but got: %s", errbuf.String())
+	}
+	expected := "100644 43dd47ea691c90a5fa7827892c70241913351963 0\t1.txt\n" +
+		"100644 64c5e5885a4b06010b3a0c20edb7900dd0311025 0\ta/2.txt\n" +
+		"100644 1d19714ffbc272ba0da6eb419d66123c20527174 0\ta/b/3.txt\n"
+
+	if outbuf.String() != expected {
+		t.Errorf("expected output \n%s\n but got: \n%s\n", expected, outbuf.String())
+	}
+}
+
+func TestLsFilesModes(t *testing.T) {
+	var tests = []struct {
+		setFlags func()
+		args     []string
+		expected string
+	}{
+		{func() {}, nil, "1.txt\na/2.txt\na/b/3.txt\n"},
+		{func() {}, []string{"a/b"}, "a/b/3.txt\n"},
+		{func() { lsFilesDeleted = true }, nil, "a/2.txt\n"},
+		{func() { lsFilesModified = true }, nil, "1.txt\na/2.txt\n"},
+		{func() { lsFilesOthers = true }, nil, ".gitignore\nbuild.log\nnew.txt\n"},
+		{func() { lsFilesOthers, lsFilesExcludeStandard = true, true }, nil, ".gitignore\nnew.txt\n"},
+		{func() { lsFilesOthers, lsFilesIgnored, lsFilesExcludeStandard = true, true, true }, nil, "build.log\n"},
+		{func() { lsFilesOthers, lsFilesExclude = true, []string{"*.txt"} }, nil, ".gitignore\nbuild.log\n"},
+		{func() { lsFilesCached, lsFilesIgnored, lsFilesExclude = true, true, []string{"a/"} }, nil, "a/2.txt\na/b/3.txt\n"},
+		{func() { lsFilesCached, lsFilesNullTerm = true, true }, []string{"1.txt"}, "1.txt\x00"},
+	}
+
+	for i, test := range tests {
+		outbuf, _ := setUpTestWorkspace(t, nil)
+		setupStatusChangedFixtureOrDie(t)
+		writeFile(t, "1.txt", "changed")
+		deleteFile(t, "a/2.txt")
+		writeFile(t, "new.txt", "new")
+		writeFile(t, "build.log", "log")
+		writeFile(t, ".gitignore", "*.log\n")
+		outbuf.Reset()
+
+		test.setFlags()
+		err := executeLsFiles(lsFilesCmd, test.args)
+		resetLsFilesFlags()
+
+		if err != nil {
+			t.Errorf("test %d failed: expected no errors but got: %v", i, err)
+		}
+		if outbuf.String() != test.expected {
+			t.Errorf("test %d failed: expected output \n%q\n but got: \n%q\n", i, test.expected, outbuf.String())
+		}
+
+		tearDownTestWorkspace()
+	}
+}
+
+func TestLsFilesDefaultKeepsFlags(t *testing.T) {
+	outbuf, _ := setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+	defer resetLsFilesFlags()
+
+	setupStatusChangedFixtureOrDie(t)
+	writeFile(t, "new.txt", "new")
+
+	// listing cached files by default doesn't carry over to the next run
+	if err := executeLsFiles(lsFilesCmd, nil); err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	outbuf.Reset()
+	lsFilesOthers = true
+	if err := executeLsFiles(lsFilesCmd, nil); err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	if outbuf.String() != "new.txt\n" {
+		t.Errorf("expected only untracked files but got: %q", outbuf.String())
+	}
+}
+
+func TestLsFilesIgnoredRequiresExcludes(t *testing.T) {
+	setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+	defer resetLsFilesFlags()
+
+	initOrDie(t)
+	lsFilesOthers, lsFilesIgnored = true, true
+	err := executeLsFiles(lsFilesCmd, nil)
+	if err == nil {
+		t.Error("expected an error when --ignored is used without exclude patterns")
+	}
+}
+
+func TestLsFilesFromSubdirectory(t *testing.T) {
+	var tests = []struct {
+		others   bool
+		args     []string
+		expected string
+	}{
+		{false, nil, "2.txt\nb/3.txt\n"},
+		{false, []string{":!b"}, "2.txt\n"},
+		{false, []string{":/1.txt", "b"}, "../1.txt\nb/3.txt\n"},
+		{true, nil, "new.txt\n"},
+	}
+
+	outbuf, _ := setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+	defer resetLsFilesFlags()
+
+	setupStatusChangedFixtureOrDie(t)
+	writeFile(t, "new.txt", "new")
+	writeFile(t, "a/new.txt", "new")
+	SetWd(filepath.Join(wd, "a"))
+
+	for i, test := range tests {
+		outbuf.Reset()
+		lsFilesOthers = test.others
+
+		err := executeLsFiles(lsFilesCmd, test.args)
+		if err != nil {
+			t.Errorf("test %d failed: expected no errors but got: %v", i, err)
+		}
+		if outbuf.String() != test.expected {
+			t.Errorf("test %d failed: expected output \n%s\n but got: \n%s\n", i, test.expected, outbuf.String())
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/neocortical/got/repository"
+	"github.com/neocortical/got/revision"
+	"github.com/neocortical/got/tree"
+	"github.com/spf13/cobra"
+)
+
+const (
+	modeTree    = 040000
+	modeGitlink = 0160000
+)
+
+var (
+	lsTreeCmd = &cobra.Command{
+		Use:   "ls-tree [-r] [-t] [-l] [--name-only] <tree-ish> [path...]",
+		Short: "List the contents of a tree object.",
+		Args:  cobra.MinimumNArgs(1),
+		RunE:  executeLsTree,
+	}
+	lsTreeRecurse   bool
+	lsTreeShowTrees bool
+	lsTreeLong      bool
+	lsTreeNameOnly  bool
+	lsTreeNullTerm  bool
+)
+
+func init() {
+	lsTreeCmd.Flags().BoolVarP(&lsTreeRecurse, "recursive", "r", false, "Recurse into subtrees")
+	lsTreeCmd.Flags().BoolVarP(&lsTreeShowTrees, "show-trees", "t", false, "Show tree entries even when recursing into them")
+	lsTreeCmd.Flags().BoolVarP(&lsTreeLong, "long", "l", false, "Show the size of blob entries")
+	lsTreeCmd.Flags().BoolVar(&lsTreeNameOnly, "name-only", false, "List only filenames")
+	lsTreeCmd.Flags().BoolVarP(&lsTreeNullTerm, "null", "z", false, "Terminate entries with NUL bytes")
+}
+
+func executeLsTree(cmd *cobra.Command, args []string) (err error) {
+	repo := repository.NewRepo(wd)
+
+	oid, err := revision.Resolve(repo, args[0])
+	if err != nil {
+		return fmt.Errorf("not a valid object name %s", args[0])
+	}
+
+	treeOID, err := revision.PeelToTree(repo, oid)
+	if err != nil {
+		return fmt.Errorf("not a tree object: %w", err)
+	}
+
+	var paths []string
+	for _, p := range args[1:] {
+		p = toRelativePath(p)
+		if p == "." || p == "./" {
+			// the whole tree is selected
+			paths = nil
+			break
+		}
+		paths = append(paths, p)
+	}
+
+	return listTree(repo, treeOID, "", paths)
+}
+
+func listTree(repo *repository.Repo, oid string, prefix string, paths []string) (err error) {
+	t, err := revision.ReadTree(repo, oid)
+	if err != nil {
+		return err
+	}
+
+	for _, node := range t.Entries() {
+		nodePath := path.Join(prefix, node.Name())
+		mode, _ := strconv.ParseUint(node.ModeString(), 8, 32)
+		isTree := mode == modeTree
+
+		show, recurse := lsTreeMatch(nodePath, isTree, paths)
+		if recurse && lsTreeShowTrees {
+			show = true
+		}
+
+		if show {
+			err = printTreeNode(repo, node, uint32(mode), nodePath)
+			if err != nil {
+				return
+			}
+		}
+
+		if recurse {
+			err = listTree(repo, node.OID(), nodePath, paths)
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	return nil
+}
+
+// lsTreeMatch decides whether a tree entry is listed and whether the subtree
+// it names is descended into. Paths select an entry and everything beneath
+// it; a trailing slash selects only the contents of a directory.
+func lsTreeMatch(nodePath string, isTree bool, paths []string) (show, recurse bool) {
+	if len(paths) == 0 {
+		return !(isTree && lsTreeRecurse), isTree && lsTreeRecurse
+	}
+
+	for _, p := range paths {
+		contentsOnly := strings.HasSuffix(p, "/")
+		p = strings.TrimRight(p, "/")
+
+		switch {
+		case nodePath == p && isTree && contentsOnly:
+			recurse = true
+		case nodePath == p || strings.HasPrefix(nodePath, p+"/"):
+			if isTree && lsTreeRecurse {
+				recurse = true
+			} else {
+				show = true
+			}
+		case isTree && strings.HasPrefix(p, nodePath+"/"):
+			recurse = true
+		}
+	}
+
+	return
+}
+
+func printTreeNode(repo *repository.Repo, node tree.Node, mode uint32, nodePath string) error {
+	terminator := "\n"
+	if lsTreeNullTerm {
+		terminator = "\x00"
+	}
+
+	if lsTreeNameOnly {
+		fmt.Fprintf(stdout, "%s%s", nodePath, terminator)
+		return nil
+	}
+
+	objectType := "blob"
+	switch mode {
+	case modeTree:
+		objectType = "tree"
+	case modeGitlink:
+		objectType = "commit"
+	}
+
+	if !lsTreeLong {
+		fmt.Fprintf(stdout, "%06o %s %s\t%s%s", mode, objectType, node.OID(), nodePath, terminator)
+		return nil
+	}
+
+	size := "-"
+	if objectType == "blob" {
+		obj, err := repo.Database().Read(node.OID())
+		if err != nil {
+			return fmt.Errorf("error reading blob %s: %w", node.OID(), err)
+		}
+		size = strconv.Itoa(len(obj.Serialize()))
+	}
+
+	fmt.Fprintf(stdout, "%06o %s %s %7s\t%s%s", mode, objectType, node.OID(), size, nodePath, terminator)
+	return nil
+}
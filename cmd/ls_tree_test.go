@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"testing"
+)
+
+func TestLsTree(t *testing.T) {
+	var tests = []struct {
+		args      []string
+		recurse   bool
+		showTrees bool
+		long      bool
+		nameOnly  bool
+		expected  string
+	}{
+		{[]string{"HEAD"}, false, false, false, false, "100644 blob 43dd47ea691c90a5fa7827892c70241913351963\t1.txt\n" +
+			"040000 tree 202bc192d34beb85d0301ec8c8940cd0252cc48a\ta\n"},
+		{[]string{"HEAD"}, true, true, true, false, "100644 blob 43dd47ea691c90a5fa7827892c70241913351963       3\t1.txt\n" +
+			"040000 tree 202bc192d34beb85d0301ec8c8940cd0252cc48a       -\ta\n" +
+			"100644 blob 64c5e5885a4b06010b3a0c20edb7900dd0311025       3\ta/2.txt\n" +
+			"040000 tree d864f7793fd2952c217c27d3780442f8943c8663       -\ta/b\n" +
+			"100644 blob 1d19714ffbc272ba0da6eb419d66123c20527174       5\ta/b/3.txt\n"},
+		{[]string{"HEAD", "a/b"}, false, false, false, false, "040000 tree d864f7793fd2952c217c27d3780442f8943c8663\ta/b\n"},
+		{[]string{"HEAD", "a/"}, false, false, false, false, "100644 blob 64c5e5885a4b06010b3a0c20edb7900dd0311025\ta/2.txt\n" +
+			"040000 tree d864f7793fd2952c217c27d3780442f8943c8663\ta/b\n"},
+		{[]string{"HEAD^{tree}", "a"}, true, false, false, true, "a/2.txt\na/b/3.txt\n"},
+		{[]string{"202bc192d34beb85d0301ec8c8940cd0252cc48a"}, false, false, false, true, "2.txt\nb\n"},
+	}
+
+	for i, test := range tests {
+		outbuf, _ := setUpTestWorkspace(t, nil)
+		setupStatusChangedFixtureOrDie(t)
+		outbuf.Reset()
+
+		lsTreeRecurse, lsTreeShowTrees, lsTreeLong, lsTreeNameOnly = test.recurse, test.showTrees, test.long, test.nameOnly
+		err := executeLsTree(lsTreeCmd, test.args)
+		lsTreeRecurse, lsTreeShowTrees, lsTreeLong, lsTreeNameOnly = false, false, false, false
+
+		if err != nil {
+			t.Errorf("test %d failed: expected no errors but got: %v", i, err)
+		}
+		if outbuf.String() != test.expected {
+			t.Errorf("test %d failed: expected output \n%s\n but got: \n%s\n", i, test.expected, outbuf.String())
+		}
+
+		tearDownTestWorkspace()
+	}
+}
+
+func TestLsTreeInvalidObject(t *testing.T) {
+	setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+
+	initOrDie(t)
+	err := executeLsTree(lsTreeCmd, []string{"HEAD"})
+	if err == nil {
+		t.Error("expected an error listing HEAD in an empty repository")
+	}
+}
@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"github.com/neocortical/got/repository"
+	"github.com/neocortical/got/revision"
+	"github.com/spf13/cobra"
+)
+
+var (
+	packRefsCmd = &cobra.Command{
+		Use:   "pack-refs [--all] [--no-prune]",
+		Short: "Pack refs into the packed-refs file.",
+		Args:  cobra.NoArgs,
+		RunE:  executePackRefs,
+	}
+	packRefsAll     bool
+	packRefsNoPrune bool
+)
+
+func init() {
+	packRefsCmd.Flags().BoolVar(&packRefsAll, "all", false, "Pack all refs, not just tags and refs that are already packed")
+	packRefsCmd.Flags().BoolVar(&packRefsNoPrune, "no-prune", false, "Keep the loose copies of packed refs")
+}
+
+func executePackRefs(cmd *cobra.Command, args []string) error {
+	repo := repository.NewRepo(wd)
+
+	return repo.Refs().Pack(packRefsAll, !packRefsNoPrune, func(oid string) (string, error) {
+		return revision.PeelTags(repo, oid)
+	})
+}
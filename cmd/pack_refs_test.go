@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"testing"
+
+	"github.com/neocortical/got/repository"
+)
+
+func TestPackRefs(t *testing.T) {
+	_, _ = setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+
+	initOrDie(t)
+	writeFile(t, "1.txt", "one")
+	commitOrDie(t, "first")
+
+	defer func() { packRefsAll = false }()
+	packRefsAll = true
+	err := executePackRefs(packRefsCmd, nil)
+	if err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+
+	data, err := ioutil.ReadFile(path.Join(wd, repository.GitDir, "packed-refs"))
+	if err != nil {
+		t.Fatalf("error reading packed-refs: %v", err)
+	}
+	expected := regexp.MustCompile("^# pack-refs with: peeled fully-peeled sorted \n[0-9a-f]{40} refs/heads/master\n$")
+	if !expected.Match(data) {
+		t.Errorf("expected packed-refs '%s' but got: '%s'", expected.String(), data)
+	}
+
+	if _, err = os.Stat(path.Join(wd, repository.GitDir, "refs/heads/master")); !os.IsNotExist(err) {
+		t.Error("expected the loose ref to be pruned")
+	}
+
+	writeFile(t, "2.txt", "two")
+	commitOrDie(t, "second")
+	err = executeLsTree(lsTreeCmd, []string{"master@{1}"})
+	if err != nil {
+		t.Errorf("expected packed refs to resolve but got: %v", err)
+	}
+}
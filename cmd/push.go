@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/neocortical/got/refspec"
+	"github.com/neocortical/got/remote"
+	"github.com/neocortical/got/repository"
+	"github.com/neocortical/got/revision"
+	"github.com/spf13/cobra"
+)
+
+// leaseAllRefs is the value of --force-with-lease given without one.
+const leaseAllRefs = "*"
+
+var (
+	pushCmd = &cobra.Command{
+		Use:   "push [--force] [--force-with-lease[=<ref>[:<expect>]]] [--no-verify] [<repository> [<refspec>...]]",
+		Short: "Update another repository's refs, sending the objects they need.",
+		RunE:  executePush,
+	}
+	pushForce       bool
+	pushLeases      []string
+	pushReceivePack string
+	pushNoVerify    bool
+)
+
+func init() {
+	pushCmd.Flags().BoolVarP(&pushForce, "force", "f", false, "Update remote refs even if the updates aren't fast-forwards")
+	pushCmd.Flags().StringArrayVar(&pushLeases, "force-with-lease", nil, "Force updates only while the remote ref has the expected value, by default that of its remote-tracking ref")
+	pushCmd.Flags().Lookup("force-with-lease").NoOptDefVal = leaseAllRefs
+	pushCmd.Flags().BoolVar(&pushNoVerify, "no-verify", false, "Bypass the pre-push hook")
+	pushCmd.Flags().StringVar(&pushReceivePack, "receive-pack", "", "Push through this command, run with the remote repository's path, such as 'git-receive-pack'")
+}
+
+// parseLease parses a --force-with-lease value: <ref> expects the value of
+// the ref's remote-tracking ref, <ref>:<expect> the object <expect> names,
+// and <ref>: for the ref not to exist.
+func parseLease(repo *repository.Repo, value string) (remote.Lease, error) {
+	if value == leaseAllRefs {
+		return remote.Lease{UseTracking: true}, nil
+	}
+
+	colon := strings.Index(value, ":")
+	if colon == -1 {
+		return remote.Lease{Ref: value, UseTracking: true}, nil
+	}
+
+	lease := remote.Lease{Ref: value[:colon]}
+	if expect := value[colon+1:]; expect != "" {
+		oid, err := revision.Resolve(repo, expect)
+		if err != nil {
+			return lease, fmt.Errorf("cannot parse expected object name '%s'", expect)
+		}
+		lease.Expected = oid
+	}
+
+	return lease, nil
+}
+
+func executePush(cmd *cobra.Command, args []string) error {
+	repo := repository.NewRepo(wd)
+
+	var name string
+	var specs []string
+	if len(args) > 0 {
+		name, specs = args[0], args[1:]
+	}
+	rem, err := openRemote(repo, name, "pushremote")
+	if err != nil {
+		return err
+	}
+
+	opts := remote.PushOptions{
+		Force:       pushForce,
+		Committer:   committerIdentity(),
+		ReceivePack: pushReceivePack,
+		Progress:    stderr,
+		NoVerify:    pushNoVerify,
+	}
+	for _, arg := range specs {
+		spec, err := refspec.ParsePush(arg)
+		if err != nil {
+			return err
+		}
+		opts.Refspecs = append(opts.Refspecs, spec)
+	}
+	for _, value := range pushLeases {
+		lease, err := parseLease(repo, value)
+		if err != nil {
+			return err
+		}
+		opts.Leases = append(opts.Leases, lease)
+	}
+
+	updates, err := remote.Push(repo, rem, wd, opts)
+	if err != nil {
+		return err
+	}
+
+	url := rem.URL
+	if rem.PushURL != "" {
+		url = rem.PushURL
+	}
+
+	failed := false
+	header := false
+	for _, u := range updates {
+		if u.Status == remote.StatusUpToDate {
+			continue
+		}
+		if !header {
+			fmt.Fprintf(stderr, "To %s\n", url)
+			header = true
+		}
+
+		flag, summary, suffix := formatRefUpdate(u, true)
+		refs := shortRefName(u.Src) + " -> " + shortRefName(u.Dst)
+		if u.Status == remote.StatusDeleted {
+			refs = shortRefName(u.Dst)
+		}
+		if suffix != "" {
+			suffix = " (" + suffix + ")"
+		}
+		fmt.Fprintf(stderr, " %c %-17s %s%s\n", flag, summary, refs, suffix)
+		failed = failed || !u.OK()
+	}
+	if !header {
+		fmt.Fprintln(stderr, "Everything up-to-date")
+	}
+
+	if failed {
+		return fmt.Errorf("failed to push some refs to '%s'", url)
+	}
+
+	return nil
+}
@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/neocortical/got/ref"
+	"github.com/neocortical/got/repository"
+)
+
+func resetPushFlags() {
+	pushForce = false
+	pushLeases = nil
+}
+
+func TestPush(t *testing.T) {
+	_, errbuf := setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+	defer resetPushFlags()
+
+	initOrDie(t)
+	writeFile(t, "foo.txt", "one")
+	commitOrDie(t, "first")
+	repo := repository.NewRepo(wd)
+	first, _ := repo.Refs().ReadHead()
+
+	cloneBare = true
+	cloneOrDie(t, "bare.git")
+	bare := repository.NewBareRepo(filepath.Join(wd, "bare.git"))
+	if err := executeRemoteAdd(remoteAddCmd, []string{"origin", "bare.git"}); err != nil {
+		t.Fatalf("error adding remote: %v", err)
+	}
+	if err := executeFetch(fetchCmd, nil); err != nil {
+		t.Fatalf("error fetching: %v", err)
+	}
+
+	errbuf.Reset()
+	if err := executePush(pushCmd, nil); err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	if errbuf.String() != "Everything up-to-date\n" {
+		t.Errorf("unexpected output: '%s'", errbuf.String())
+	}
+
+	writeFile(t, "foo.txt", "two")
+	commitOrDie(t, "second")
+	second, _ := repo.Refs().ReadHead()
+
+	errbuf.Reset()
+	if err := executePush(pushCmd, []string{"origin", "master", "HEAD:refs/heads/topic"}); err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	expected := "To bare.git\n" +
+		"   " + first[:7] + ".." + second[:7] + "  master -> master\n" +
+		" * [new branch]      master -> topic\n"
+	if errbuf.String() != expected {
+		t.Errorf("expected:\n%s\nbut got:\n%s", expected, errbuf.String())
+	}
+	for _, name := range []string{"refs/heads/master", "refs/heads/topic"} {
+		if oid, _ := bare.Refs().ReadRef(name); oid != second {
+			t.Errorf("expected remote %s to be %s but got: '%s'", name, second, oid)
+		}
+	}
+	if !bare.Database().Has(second) {
+		t.Errorf("expected %s to be pushed", second)
+	}
+	if oid, _ := repo.Refs().ReadRef("refs/remotes/origin/master"); oid != second {
+		t.Errorf("expected origin/master to be %s but got: '%s'", second, oid)
+	}
+
+	if err := executeUpdateRef(updateRefCmd, []string{"refs/heads/master", first}); err != nil {
+		t.Fatalf("error resetting master: %v", err)
+	}
+
+	errbuf.Reset()
+	err := executePush(pushCmd, nil)
+	if err == nil || err.Error() != "failed to push some refs to 'bare.git'" {
+		t.Errorf("expected a non-fast-forward push to fail but got: %v", err)
+	}
+	if !strings.Contains(errbuf.String(), " ! [rejected]        master -> master (non-fast-forward)\n") {
+		t.Errorf("unexpected output: '%s'", errbuf.String())
+	}
+
+	// the lease holds while the remote ref is where origin/master says
+	errbuf.Reset()
+	pushLeases = []string{leaseAllRefs}
+	if err = executePush(pushCmd, nil); err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	if !strings.Contains(errbuf.String(), " + "+second[:7]+"..."+first[:7]+" master -> master (forced update)\n") {
+		t.Errorf("unexpected output: '%s'", errbuf.String())
+	}
+	if oid, _ := bare.Refs().ReadRef("refs/heads/master"); oid != first {
+		t.Errorf("expected remote master to be %s but got: '%s'", first, oid)
+	}
+
+	// and breaks once someone else moves it
+	t2 := bare.Refs().Transaction(ref.Author{})
+	if err = t2.Update("refs/heads/topic", first, second, "elsewhere"); err == nil {
+		err = t2.Commit()
+	}
+	if err != nil {
+		t.Fatalf("error updating remote topic: %v", err)
+	}
+	errbuf.Reset()
+	pushLeases = []string{"topic"}
+	if err = executePush(pushCmd, []string{"origin", "+" + second + ":refs/heads/topic"}); err == nil {
+		t.Errorf("expected a stale lease to fail the push")
+	}
+	if !strings.Contains(errbuf.String(), "(stale info)") {
+		t.Errorf("unexpected output: '%s'", errbuf.String())
+	}
+
+	errbuf.Reset()
+	pushLeases = []string{"topic:" + first}
+	if err = executePush(pushCmd, []string{"origin", ":topic"}); err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	if errbuf.String() != "To bare.git\n - [deleted]         topic\n" {
+		t.Errorf("unexpected output: '%s'", errbuf.String())
+	}
+	if oid, _ := bare.Refs().ReadRef("refs/heads/topic"); oid != "" {
+		t.Errorf("expected remote topic to be deleted but got: '%s'", oid)
+	}
+}
+
+func TestPushToCheckedOutBranch(t *testing.T) {
+	_, errbuf := setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+
+	initOrDie(t)
+	writeFile(t, "foo.txt", "one")
+	commitOrDie(t, "first")
+	dir := cloneOrDie(t, "copy")
+
+	var second string
+	inDir(dir, func() {
+		writeFile(t, "foo.txt", "two")
+		commitOrDie(t, "second")
+		second, _ = repository.NewRepo(wd).Refs().ReadHead()
+
+		errbuf.Reset()
+		if err := executePush(pushCmd, nil); err == nil {
+			t.Errorf("expected an error pushing to a checked out branch")
+		}
+		if !strings.Contains(errbuf.String(), " ! [remote rejected] master -> master (branch is currently checked out)\n") {
+			t.Errorf("unexpected output: '%s'", errbuf.String())
+		}
+
+		if err := executePush(pushCmd, []string{"origin", "master:other"}); err != nil {
+			t.Fatalf("expected no errors but got: %v", err)
+		}
+	})
+
+	if oid, _ := repository.NewRepo(wd).Refs().ReadRef("refs/heads/other"); oid != second {
+		t.Errorf("expected other to be %s but got: '%s'", second, oid)
+	}
+}
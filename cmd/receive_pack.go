@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"github.com/neocortical/got/remote"
+	"github.com/spf13/cobra"
+)
+
+var (
+	receivePackCmd = &cobra.Command{
+		Use:   "receive-pack <directory>",
+		Short: "Receive pushes into a repository over stdin and stdout.",
+		Args:  cobra.ExactArgs(1),
+		RunE:  executeReceivePack,
+	}
+	receivePackStatelessRPC  bool
+	receivePackAdvertiseRefs bool
+)
+
+func init() {
+	receivePackCmd.Flags().BoolVar(&receivePackStatelessRPC, "stateless-rpc", false, "Read commands without advertising refs")
+	receivePackCmd.Flags().BoolVar(&receivePackAdvertiseRefs, "advertise-refs", false, "Only advertise refs")
+}
+
+func executeReceivePack(cmd *cobra.Command, args []string) error {
+	repo, err := remote.OpenRepository(args[0], wd)
+	if err != nil {
+		return err
+	}
+
+	return remote.ReceivePack(repo, stdin, stdout, remote.ReceivePackOptions{
+		Committer:     committerIdentity(),
+		AdvertiseRefs: receivePackAdvertiseRefs,
+		StatelessRPC:  receivePackStatelessRPC,
+		Stderr:        stderr,
+	})
+}
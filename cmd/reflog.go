@@ -0,0 +1,310 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/neocortical/got/ref"
+	"github.com/neocortical/got/repository"
+	"github.com/neocortical/got/revision"
+	"github.com/spf13/cobra"
+)
+
+const (
+	abbrevLength = 7
+
+	defaultReflogExpire            = "90.days.ago"
+	defaultReflogExpireUnreachable = "30.days.ago"
+)
+
+var (
+	reflogCmd = &cobra.Command{
+		Use:   "reflog [show] [<ref>]",
+		Short: "Show and manage the record of ref updates.",
+		Args:  cobra.MaximumNArgs(1),
+		RunE:  executeReflogShow,
+	}
+	reflogShowCmd = &cobra.Command{
+		Use:   "show [<ref>]",
+		Short: "Show the reflog of a ref (HEAD by default).",
+		Args:  cobra.MaximumNArgs(1),
+		RunE:  executeReflogShow,
+	}
+	reflogExpireCmd = &cobra.Command{
+		Use:   "expire [--expire=<time>] [--expire-unreachable=<time>] [--all] [-n] [<ref>...]",
+		Short: "Prune old reflog entries.",
+		RunE:  executeReflogExpire,
+	}
+	reflogDeleteCmd = &cobra.Command{
+		Use:   "delete [-n] <ref>@{<n>}...",
+		Short: "Delete individual reflog entries.",
+		Args:  cobra.MinimumNArgs(1),
+		RunE:  executeReflogDelete,
+	}
+	reflogExpireTime            string
+	reflogExpireUnreachableTime string
+	reflogExpireAll             bool
+	reflogDryRun                bool
+)
+
+func init() {
+	reflogCmd.AddCommand(reflogShowCmd)
+	reflogCmd.AddCommand(reflogExpireCmd)
+	reflogCmd.AddCommand(reflogDeleteCmd)
+
+	reflogExpireCmd.Flags().StringVar(&reflogExpireTime, "expire", "", "Prune entries older than this (default gc.reflogExpire, or 90 days)")
+	reflogExpireCmd.Flags().StringVar(&reflogExpireUnreachableTime, "expire-unreachable", "", "Prune entries older than this that are no longer reachable from the ref (default gc.reflogExpireUnreachable, or 30 days)")
+	reflogExpireCmd.Flags().BoolVar(&reflogExpireAll, "all", false, "Process the reflogs of all refs")
+	reflogExpireCmd.Flags().BoolVarP(&reflogDryRun, "dry-run", "n", false, "Don't actually prune any entries")
+	reflogDeleteCmd.Flags().BoolVarP(&reflogDryRun, "dry-run", "n", false, "Don't actually delete any entries")
+}
+
+func executeReflogShow(cmd *cobra.Command, args []string) (err error) {
+	repo := repository.NewRepo(wd)
+
+	name := "HEAD"
+	if len(args) > 0 {
+		name = args[0]
+	}
+
+	refName := revision.ExpandRef(repo, name)
+	if refName == "" {
+		return fmt.Errorf("ambiguous argument '%s': unknown revision or path not in the working tree", name)
+	}
+
+	entries, err := repo.Refs().Reflog(refName)
+	if err != nil {
+		return err
+	}
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		fmt.Fprintf(stdout, "%s %s@{%d}: %s\n", abbreviate(entries[i].NewOID), name, len(entries)-1-i, entries[i].Message)
+	}
+
+	return nil
+}
+
+func abbreviate(oid string) string {
+	if len(oid) <= abbrevLength {
+		return oid
+	}
+
+	return oid[:abbrevLength]
+}
+
+// reflogExpiry decides whether a reflog entry is old enough to prune.
+type reflogExpiry struct {
+	all   bool
+	never bool
+	time  time.Time
+}
+
+func parseReflogExpiry(value string, now time.Time) (result reflogExpiry, err error) {
+	switch value {
+	case "all":
+		result.all = true
+	case "never", "false":
+		result.never = true
+	default:
+		result.time, err = revision.ParseDate(value, now)
+	}
+
+	return
+}
+
+func (e reflogExpiry) expires(t time.Time) bool {
+	return e.all || (!e.never && t.Before(e.time))
+}
+
+// reflogExpiryOption returns the expiry for a flag, falling back to a config
+// setting and then to git's default.
+func reflogExpiryOption(repo *repository.Repo, flag string, key string, fallback string) (reflogExpiry, error) {
+	value := flag
+	if value == "" {
+		cfg, err := repo.Config()
+		if err != nil {
+			return reflogExpiry{}, err
+		}
+
+		var exists bool
+		if value, exists = cfg.Get(key); !exists {
+			value = fallback
+		}
+	}
+
+	expiry, err := parseReflogExpiry(value, time.Now())
+	if err != nil {
+		return expiry, fmt.Errorf("malformed expiration date '%s'", value)
+	}
+
+	return expiry, nil
+}
+
+func executeReflogExpire(cmd *cobra.Command, args []string) (err error) {
+	repo := repository.NewRepo(wd)
+
+	expire, err := reflogExpiryOption(repo, reflogExpireTime, "gc.reflogexpire", defaultReflogExpire)
+	if err != nil {
+		return err
+	}
+	expireUnreachable, err := reflogExpiryOption(repo, reflogExpireUnreachableTime, "gc.reflogexpireunreachable", defaultReflogExpireUnreachable)
+	if err != nil {
+		return err
+	}
+
+	var refNames []string
+	if reflogExpireAll {
+		refNames, err = repo.Refs().ReflogNames()
+		if err != nil {
+			return err
+		}
+	}
+	for _, arg := range args {
+		refName := revision.ExpandRef(repo, arg)
+		if refName == "" {
+			return fmt.Errorf("reflog could not be found: '%s'", arg)
+		}
+		refNames = append(refNames, refName)
+	}
+
+	for _, refName := range refNames {
+		err = expireReflog(repo, refName, expire, expireUnreachable)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func expireReflog(repo *repository.Repo, refName string, expire, expireUnreachable reflogExpiry) (err error) {
+	refs := repo.Refs()
+
+	entries, err := refs.Reflog(refName)
+	if err != nil {
+		return err
+	}
+
+	tip, err := refs.ReadRef(refName)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", refName, err)
+	}
+
+	var reachable map[string]bool
+	var kept []ref.ReflogEntry
+	for _, entry := range entries {
+		if expire.expires(entry.Committer.Time) {
+			continue
+		}
+
+		if expireUnreachable.expires(entry.Committer.Time) {
+			if reachable == nil {
+				reachable = reachableCommits(repo, tip)
+			}
+			if !reachable[entry.NewOID] {
+				continue
+			}
+		}
+
+		kept = append(kept, entry)
+	}
+
+	if len(kept) == len(entries) {
+		return nil
+	}
+	if reflogDryRun {
+		fmt.Fprintf(stdout, "would prune %d entries from the reflog of %s\n", len(entries)-len(kept), refName)
+		return nil
+	}
+
+	return refs.WriteReflog(refName, kept)
+}
+
+// reachableCommits returns the set of commits in the history of tip. Missing
+// or unreadable commits end the walk along their line of history.
+func reachableCommits(repo *repository.Repo, tip string) map[string]bool {
+	db := repo.Database()
+	result := map[string]bool{}
+
+	for oid := tip; oid != "" && !result[oid]; {
+		obj, err := db.Read(oid)
+		if err != nil || obj.Type() != ref.TypeCommit {
+			break
+		}
+		result[oid] = true
+
+		commit, err := ref.DeserializeCommit(obj.Serialize())
+		if err != nil {
+			break
+		}
+		oid = commit.Parent()
+	}
+
+	return result
+}
+
+func executeReflogDelete(cmd *cobra.Command, args []string) (err error) {
+	repo := repository.NewRepo(wd)
+	refs := repo.Refs()
+
+	// group the selectors by reflog so each log is rewritten once
+	deletions := map[string][]int{}
+	var refNames []string
+	for _, arg := range args {
+		name, selector, ok := revision.SplitReflogSelector(arg)
+		if !ok {
+			return fmt.Errorf("not a reflog: %s", arg)
+		}
+
+		n, err := strconv.Atoi(selector)
+		if err != nil || n < 0 {
+			return fmt.Errorf("not a reflog entry: %s", arg)
+		}
+
+		refName, err := revision.ReflogRefName(repo, name)
+		if err != nil {
+			return fmt.Errorf("reflog could not be found: '%s'", arg)
+		}
+
+		if _, seen := deletions[refName]; !seen {
+			refNames = append(refNames, refName)
+		}
+		deletions[refName] = append(deletions[refName], n)
+	}
+
+	for _, refName := range refNames {
+		entries, err := refs.Reflog(refName)
+		if err != nil {
+			return err
+		}
+
+		// selectors count back from the newest entry
+		deleted := map[int]bool{}
+		for _, n := range deletions[refName] {
+			if n >= len(entries) {
+				return fmt.Errorf("log for '%s' only has %d entries", refName, len(entries))
+			}
+			deleted[len(entries)-1-n] = true
+		}
+
+		var kept []ref.ReflogEntry
+		for i, entry := range entries {
+			if !deleted[i] {
+				kept = append(kept, entry)
+			}
+		}
+
+		if reflogDryRun {
+			fmt.Fprintf(stdout, "would delete %d entries from the reflog of %s\n", len(entries)-len(kept), refName)
+			continue
+		}
+
+		err = refs.WriteReflog(refName, kept)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
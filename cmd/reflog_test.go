@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/neocortical/got/ref"
+	"github.com/neocortical/got/repository"
+)
+
+func commitOrDie(t *testing.T, message string) {
+	err := executeAdd(addCmd, []string{"."})
+	if err != nil {
+		t.Fatalf("expected no errors during add but got: %v", err)
+	}
+
+	commitMessage = message
+	err = executeCommit(commitCmd, nil)
+	if err != nil {
+		t.Fatalf("expected no errors during commit but got: %v", err)
+	}
+}
+
+func TestReflogShow(t *testing.T) {
+	outbuf, _ := setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+
+	initOrDie(t)
+	writeFile(t, "1.txt", "one")
+	commitOrDie(t, "first")
+	writeFile(t, "2.txt", "two")
+	commitOrDie(t, "second\n\nwith a body")
+
+	for _, name := range []string{"HEAD", "master"} {
+		outbuf.Reset()
+		err := executeReflogShow(reflogShowCmd, []string{name})
+		if err != nil {
+			t.Fatalf("expected no errors but got: %v", err)
+		}
+
+		expected := regexp.MustCompile(`^[0-9a-f]{7} ` + name + `@\{0\}: commit: second\n[0-9a-f]{7} ` + name + `@\{1\}: commit \(initial\): first\n$`)
+		if !expected.Match(outbuf.Bytes()) {
+			t.Errorf("expected output '%s' but got: '%s'", expected.String(), outbuf.String())
+		}
+	}
+
+	outbuf.Reset()
+	err := executeLsTree(lsTreeCmd, []string{"master@{1}"})
+	if err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	expected := "100644 blob 43dd47ea691c90a5fa7827892c70241913351963\t1.txt\n"
+	if outbuf.String() != expected {
+		t.Errorf("expected output '%s' but got: '%s'", expected, outbuf.String())
+	}
+
+	err = executeLsTree(lsTreeCmd, []string{"HEAD@{2}"})
+	if err == nil {
+		t.Error("expected an error for an entry past the end of the reflog")
+	}
+}
+
+func TestReflogDelete(t *testing.T) {
+	_, _ = setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+
+	initOrDie(t)
+	writeFile(t, "1.txt", "one")
+	commitOrDie(t, "first")
+	writeFile(t, "2.txt", "two")
+	commitOrDie(t, "second")
+	writeFile(t, "3.txt", "three")
+	commitOrDie(t, "third")
+
+	err := executeReflogDelete(reflogDeleteCmd, []string{"HEAD@{1}", "HEAD@{2}"})
+	if err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+
+	refs := repository.NewRepo(wd).Refs()
+	entries, _ := refs.Reflog("HEAD")
+	if len(entries) != 1 || entries[0].Message != "commit: third" {
+		t.Errorf("unexpected entries left in the reflog: %+v", entries)
+	}
+	entries, _ = refs.Reflog("refs/heads/master")
+	if len(entries) != 3 {
+		t.Errorf("expected the branch reflog to be untouched but it has %d entries", len(entries))
+	}
+
+	err = executeReflogDelete(reflogDeleteCmd, []string{"HEAD@{1}"})
+	if err == nil {
+		t.Error("expected an error deleting an entry past the end of the reflog")
+	}
+	err = executeReflogDelete(reflogDeleteCmd, []string{"HEAD"})
+	if err == nil {
+		t.Error("expected an error deleting without a selector")
+	}
+}
+
+func TestReflogExpire(t *testing.T) {
+	_, _ = setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+
+	initOrDie(t)
+	writeFile(t, "1.txt", "one")
+	commitOrDie(t, "first")
+	writeFile(t, "2.txt", "two")
+	commitOrDie(t, "second")
+
+	refs := repository.NewRepo(wd).Refs()
+	entries, _ := refs.Reflog("HEAD")
+	now := time.Now()
+	unreachable := ref.ReflogEntry{
+		OldOID:    entries[1].NewOID,
+		NewOID:    "43dd47ea691c90a5fa7827892c70241913351963",
+		Committer: ref.Author{Name: "a", Email: "b", Time: now.AddDate(0, 0, -40)},
+		Message:   "unreachable",
+	}
+	entries[0].Committer.Time = now.AddDate(0, 0, -100)
+	entries[1].Committer.Time = now.AddDate(0, 0, -40)
+	err := refs.WriteReflog("HEAD", []ref.ReflogEntry{entries[0], entries[1], unreachable})
+	if err != nil {
+		t.Fatalf("error writing reflog: %v", err)
+	}
+
+	defer func() { reflogExpireAll = false }()
+	reflogExpireAll = true
+	err = executeReflogExpire(reflogExpireCmd, nil)
+	if err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+
+	actual, _ := refs.Reflog("HEAD")
+	if len(actual) != 1 || actual[0].Message != "commit: second" {
+		t.Errorf("expected only the recent reachable entry to survive but got: %+v", actual)
+	}
+	actual, _ = refs.Reflog("refs/heads/master")
+	if len(actual) != 2 {
+		t.Errorf("expected recent branch entries to survive but got %d", len(actual))
+	}
+
+	defer func() { reflogExpireTime = "" }()
+	reflogExpireTime = "all"
+	err = executeReflogExpire(reflogExpireCmd, nil)
+	if err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	actual, _ = refs.Reflog("refs/heads/master")
+	if len(actual) != 0 || !refs.HasReflog("refs/heads/master") {
+		t.Errorf("expected an empty reflog but got: %+v", actual)
+	}
+}
@@ -0,0 +1,190 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/neocortical/got/ref"
+	"github.com/neocortical/got/remote"
+	"github.com/neocortical/got/repository"
+	"github.com/spf13/cobra"
+)
+
+var (
+	remoteCmd = &cobra.Command{
+		Use:   "remote [-v]",
+		Short: "Manage the repositories fetched from and pushed to.",
+		Args:  cobra.NoArgs,
+		RunE:  executeRemote,
+	}
+	remoteAddCmd = &cobra.Command{
+		Use:   "add <name> <url>",
+		Short: "Add a remote, fetching every branch into refs/remotes/<name>/.",
+		Args:  cobra.ExactArgs(2),
+		RunE:  executeRemoteAdd,
+	}
+	remoteRemoveCmd = &cobra.Command{
+		Use:     "remove <name>",
+		Aliases: []string{"rm"},
+		Short:   "Remove a remote, with its remote-tracking refs.",
+		Args:    cobra.ExactArgs(1),
+		RunE:    executeRemoteRemove,
+	}
+	remoteRenameCmd = &cobra.Command{
+		Use:   "rename <old> <new>",
+		Short: "Rename a remote, with its remote-tracking refs.",
+		Args:  cobra.ExactArgs(2),
+		RunE:  executeRemoteRename,
+	}
+	remoteVerbose bool
+)
+
+func init() {
+	remoteCmd.AddCommand(remoteAddCmd)
+	remoteCmd.AddCommand(remoteRemoveCmd)
+	remoteCmd.AddCommand(remoteRenameCmd)
+
+	remoteCmd.Flags().BoolVarP(&remoteVerbose, "verbose", "v", false, "Show the URL of each remote")
+}
+
+func executeRemote(cmd *cobra.Command, args []string) error {
+	cfg, err := repository.NewRepo(wd).Config()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range remote.List(cfg) {
+		if !remoteVerbose {
+			fmt.Fprintln(stdout, name)
+			continue
+		}
+
+		rem, err := remote.Get(cfg, name)
+		if err != nil {
+			return err
+		}
+		pushURL := rem.URL
+		if rem.PushURL != "" {
+			pushURL = rem.PushURL
+		}
+		fmt.Fprintf(stdout, "%s\t%s (fetch)\n", name, rem.URL)
+		fmt.Fprintf(stdout, "%s\t%s (push)\n", name, pushURL)
+	}
+
+	return nil
+}
+
+func executeRemoteAdd(cmd *cobra.Command, args []string) error {
+	cfg, err := repository.NewRepo(wd).Config()
+	if err != nil {
+		return err
+	}
+
+	return remote.Add(cfg, args[0], args[1])
+}
+
+func executeRemoteRemove(cmd *cobra.Command, args []string) error {
+	err := remote.Remove(repository.NewRepo(wd), args[0], committerIdentity())
+	if errors.Is(err, remote.ErrNoSuchRemote) {
+		return fmt.Errorf("No such remote: '%s'", args[0])
+	}
+
+	return err
+}
+
+func executeRemoteRename(cmd *cobra.Command, args []string) error {
+	err := remote.Rename(repository.NewRepo(wd), args[0], args[1], committerIdentity())
+	if errors.Is(err, remote.ErrNoSuchRemote) {
+		return fmt.Errorf("No such remote: '%s'", args[0])
+	}
+
+	return err
+}
+
+// openRemote finds the remote a fetch or push names, which may be a URL
+// rather than a configured remote. Without a name, it's the remote the
+// current branch follows, or origin.
+func openRemote(repo *repository.Repo, name string, key string) (*remote.Remote, error) {
+	cfg, err := repo.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	if name == "" {
+		name = originRemote
+		if branch, _ := repo.Refs().ReadSymbolicRef("HEAD"); strings.HasPrefix(branch, ref.HeadsPrefix) {
+			prefix := "branch." + strings.TrimPrefix(branch, ref.HeadsPrefix) + "."
+			if value, exists := cfg.Get(prefix + key); exists {
+				name = value
+			} else if value, exists := cfg.Get(prefix + "remote"); exists {
+				name = value
+			}
+		}
+		if _, err = remote.Get(cfg, name); err != nil {
+			return nil, errors.New("No remote repository specified. Please, specify either a URL or a remote name from which new revisions should be fetched.")
+		}
+	}
+
+	rem, err := remote.Get(cfg, name)
+	if errors.Is(err, remote.ErrNoSuchRemote) {
+		return &remote.Remote{URL: name}, nil
+	}
+
+	return rem, err
+}
+
+// shortRefName abbreviates a ref name for fetch and push summaries.
+func shortRefName(name string) string {
+	for _, prefix := range []string{ref.HeadsPrefix, remote.TagsPrefix, remote.RemotesPrefix} {
+		if strings.HasPrefix(name, prefix) {
+			return strings.TrimPrefix(name, prefix)
+		}
+	}
+
+	return name
+}
+
+// formatRefUpdate describes a ref update the way git's fetch and push
+// summaries do: a flag, then what happened, then the refs involved. The
+// reason for a rejection or forced update follows in parentheses.
+func formatRefUpdate(u remote.Update, push bool) (flag byte, summary string, suffix string) {
+	flag = ' '
+	switch u.Status {
+	case remote.StatusNew:
+		flag = '*'
+		kind := u.Kind()
+		if push && kind == "ref" {
+			kind = "reference"
+		}
+		summary = "[new " + kind + "]"
+		if u.Dst == "" {
+			summary = "branch"
+			if kind == "tag" {
+				summary = kind
+			}
+		}
+	case remote.StatusFastForward:
+		summary = u.OldOID[:abbrevLength] + ".." + u.NewOID[:abbrevLength]
+	case remote.StatusForced:
+		flag = '+'
+		summary = u.OldOID[:abbrevLength] + "..." + u.NewOID[:abbrevLength]
+		suffix = "forced update"
+	case remote.StatusDeleted:
+		flag = '-'
+		summary = "[deleted]"
+	case remote.StatusRejected:
+		flag = '!'
+		summary = "[rejected]"
+		suffix = u.Reason
+	case remote.StatusRemoteRejected:
+		flag = '!'
+		summary = "[remote rejected]"
+		suffix = u.Reason
+	case remote.StatusUpToDate:
+		flag = '='
+		summary = "[up to date]"
+	}
+
+	return
+}
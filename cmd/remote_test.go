@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/neocortical/got/repository"
+)
+
+func resetRemoteFlags() {
+	remoteVerbose = false
+}
+
+// cloneOrDie clones the workspace into a directory under it, and returns the
+// directory.
+func cloneOrDie(t *testing.T, name string) string {
+	defer resetCloneFlags()
+
+	if err := executeClone(cloneCmd, []string{wd, name}); err != nil {
+		t.Fatalf("expected no errors cloning but got: %v", err)
+	}
+	return filepath.Join(wd, name)
+}
+
+// inDir runs f with dir as the workspace.
+func inDir(dir string, f func()) {
+	saved := wd
+	wd = dir
+	defer func() { wd = saved }()
+	f()
+}
+
+func TestRemote(t *testing.T) {
+	outbuf, _ := setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+	defer resetRemoteFlags()
+
+	initOrDie(t)
+	writeFile(t, "foo.txt", "one")
+	commitOrDie(t, "first")
+	head, _ := repository.NewRepo(wd).Refs().ReadHead()
+	dir := cloneOrDie(t, "copy")
+
+	inDir(dir, func() {
+		if err := executeRemoteAdd(remoteAddCmd, []string{"upstream", "../other"}); err != nil {
+			t.Fatalf("expected no errors but got: %v", err)
+		}
+		if err := executeRemoteAdd(remoteAddCmd, []string{"upstream", "../other"}); err == nil {
+			t.Errorf("expected an error adding an existing remote")
+		}
+
+		outbuf.Reset()
+		remoteVerbose = true
+		if err := executeRemote(remoteCmd, nil); err != nil {
+			t.Fatalf("expected no errors but got: %v", err)
+		}
+		source := filepath.Dir(wd)
+		expected := "origin\t" + source + " (fetch)\norigin\t" + source + " (push)\n" +
+			"upstream\t../other (fetch)\nupstream\t../other (push)\n"
+		if outbuf.String() != expected {
+			t.Errorf("expected:\n%s\nbut got:\n%s", expected, outbuf.String())
+		}
+
+		if err := executeRemoteRename(remoteRenameCmd, []string{"origin", "source"}); err != nil {
+			t.Fatalf("expected no errors but got: %v", err)
+		}
+		repo := repository.NewRepo(wd)
+		if oid, _ := repo.Refs().ReadRef("refs/remotes/source/master"); oid != head {
+			t.Errorf("expected refs/remotes/source/master to be %s but got: '%s'", head, oid)
+		}
+		if oid, _ := repo.Refs().ReadRef("refs/remotes/origin/master"); oid != "" {
+			t.Errorf("expected refs/remotes/origin/master to be gone but got: '%s'", oid)
+		}
+		cfg, _ := repo.Config()
+		if value, _ := cfg.Get("branch.master.remote"); value != "source" {
+			t.Errorf("expected branch.master.remote source but got: '%s'", value)
+		}
+		if value, _ := cfg.Get("remote.source.fetch"); value != "+refs/heads/*:refs/remotes/source/*" {
+			t.Errorf("expected the fetch refspec to be renamed but got: '%s'", value)
+		}
+
+		if err := executeRemoteRemove(remoteRemoveCmd, []string{"source"}); err != nil {
+			t.Fatalf("expected no errors but got: %v", err)
+		}
+		if oid, _ := repo.Refs().ReadRef("refs/remotes/source/master"); oid != "" {
+			t.Errorf("expected refs/remotes/source/master to be gone but got: '%s'", oid)
+		}
+		if err := executeRemoteRemove(remoteRemoveCmd, []string{"source"}); err == nil || err.Error() != "No such remote: 'source'" {
+			t.Errorf("expected an error removing a missing remote but got: %v", err)
+		}
+
+		outbuf.Reset()
+		remoteVerbose = false
+		if err := executeRemote(remoteCmd, nil); err != nil {
+			t.Fatalf("expected no errors but got: %v", err)
+		}
+		if outbuf.String() != "upstream\n" {
+			t.Errorf("unexpected output: '%s'", outbuf.String())
+		}
+	})
+}
@@ -1,10 +1,14 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 
+	"github.com/neocortical/got/lock"
+	"github.com/neocortical/got/repository"
 	"github.com/spf13/cobra"
 )
 
@@ -13,19 +17,72 @@ var (
 		Use:   "got",
 		Short: "A VCS.",
 		Long:  `got is a clone of git, which is a little-known version control system.`,
+
+		PersistentPreRunE: checkRepository,
 	}
 
+	stdin  io.Reader
 	stdout io.Writer
 	stderr io.Writer
 	getenv func(string) string
 	wd     string
+	// prefix is the directory got runs in relative to wd, when that's a
+	// subdirectory of the workspace.
+	prefix string
 )
 
+// errSilentFailure makes got exit unsuccessfully without printing anything,
+// for commands that report results through their exit status.
+var errSilentFailure = errors.New("command failed")
+
+func silentFailure(cmd *cobra.Command) error {
+	cmd.SilenceErrors = true
+	cmd.SilenceUsage = true
+	return errSilentFailure
+}
+
 func init() {
 	rootCmd.AddCommand(initCmd)
 	rootCmd.AddCommand(addCmd)
 	rootCmd.AddCommand(commitCmd)
 	rootCmd.AddCommand(statusCmd)
+	rootCmd.AddCommand(writeTreeCmd)
+	rootCmd.AddCommand(lsFilesCmd)
+	rootCmd.AddCommand(lsTreeCmd)
+	rootCmd.AddCommand(reflogCmd)
+	rootCmd.AddCommand(packRefsCmd)
+	rootCmd.AddCommand(updateRefCmd)
+	rootCmd.AddCommand(symbolicRefCmd)
+	rootCmd.AddCommand(showRefCmd)
+	rootCmd.AddCommand(forEachRefCmd)
+	rootCmd.AddCommand(locksCmd)
+	rootCmd.AddCommand(fsckCmd)
+	rootCmd.AddCommand(cloneCmd)
+	rootCmd.AddCommand(remoteCmd)
+	rootCmd.AddCommand(fetchCmd)
+	rootCmd.AddCommand(pushCmd)
+	rootCmd.AddCommand(uploadPackCmd)
+	rootCmd.AddCommand(receivePackCmd)
+	rootCmd.AddCommand(serveCmd)
+	rootCmd.AddCommand(stashCmd)
+}
+
+// checkRepository refuses to run in a repository got can't read properly,
+// rather than misreading it. Clones and servers don't use the repository
+// they're run in.
+func checkRepository(cmd *cobra.Command, args []string) error {
+	if cmd == cloneCmd || cmd == serveCmd || cmd == uploadPackCmd || cmd == receivePackCmd {
+		return nil
+	}
+	if info, err := os.Stat(filepath.Join(wd, repository.GitDir)); err != nil || !info.IsDir() {
+		return nil
+	}
+
+	return repository.NewRepo(wd).CheckFormat()
+}
+
+func SetStdin(r io.Reader) {
+	stdin = r
 }
 
 func SetStdout(w io.Writer) {
@@ -34,18 +91,28 @@ func SetStdout(w io.Writer) {
 
 func SetStderr(w io.Writer) {
 	stderr = w
+	repository.SetStderr(w)
 }
 
 func Setenv(f func(string) string) {
 	getenv = f
+	repository.SetGetenv(f)
 }
 
+// SetWd sets the directory got runs in. Inside a workspace, commands run from
+// its root, with paths given as arguments still relative to dir.
 func SetWd(dir string) {
-	wd = dir
+	wd, prefix = findWorkspace(dir)
 }
 
 func Execute() {
 	err := rootCmd.Execute()
+	if err != nil {
+		lock.RollbackAll()
+	}
+	if errors.Is(err, errSilentFailure) {
+		os.Exit(1)
+	}
 	if err != nil {
 		fmt.Fprintln(stderr, fmt.Sprintf("Fatal: %v", err))
 		os.Exit(1)
@@ -7,7 +7,11 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/neocortical/got/remote"
+	"github.com/neocortical/got/repository"
 )
 
 func setUpTestWorkspace(t *testing.T, env map[string]string) (outbuf, errbuf *bytes.Buffer) {
@@ -43,7 +47,7 @@ func tearDownTestWorkspace() {
 	if err != nil {
 		fmt.Println("error deleting temp workspace:", err)
 	}
-	wd = ""
+	wd, prefix = "", ""
 }
 
 func writeFile(t *testing.T, filename string, data ...string) {
@@ -83,3 +87,51 @@ func deleteFile(t *testing.T, filename string) {
 		t.Fatalf("error deleting file: %v", err)
 	}
 }
+
+func TestRefuseUnknownObjectFormat(t *testing.T) {
+	setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+
+	initOrDie(t)
+	if err := checkRepository(statusCmd, nil); err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+
+	cfg, _ := repository.NewRepo(wd).Config()
+	cfg.Set("core.repositoryformatversion", "1")
+	cfg.Set("extensions.objectformat", "md5")
+
+	err := checkRepository(statusCmd, nil)
+	if err == nil || !strings.Contains(err.Error(), "unknown object format 'md5'") {
+		t.Errorf("expected an error for an unknown object format but got: %v", err)
+	}
+	if _, err = remote.OpenRepository(wd, ""); err == nil {
+		t.Errorf("expected an error opening a repository with an unknown object format")
+	}
+	if err = checkRepository(cloneCmd, nil); err != nil {
+		t.Errorf("expected clone not to check the repository it runs in but got: %v", err)
+	}
+}
+
+func TestWarnUnknownFsyncComponent(t *testing.T) {
+	_, errbuf := setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+	repository.SetStderr(errbuf)
+	defer repository.SetStderr(os.Stderr)
+
+	initOrDie(t)
+	cfg, _ := repository.NewRepo(wd).Config()
+	cfg.Set("core.fsync", "objects,bogus")
+	writeFile(t, "foo.txt", "foo")
+
+	if err := executeAdd(addCmd, []string{"foo.txt"}); err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	expected := "warning: ignoring unknown core.fsync component 'bogus'\n"
+	if errbuf.String() != expected {
+		t.Errorf("expected a single warning but got: '%s'", errbuf.String())
+	}
+	if _, ok := indexFiles(t)["foo.txt"]; !ok {
+		t.Errorf("expected foo.txt to be added")
+	}
+}
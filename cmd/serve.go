@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/neocortical/got/httpbackend"
+	"github.com/spf13/cobra"
+)
+
+var (
+	serveCmd = &cobra.Command{
+		Use:   "serve --http <address> [<directory>]",
+		Short: "Serve the repositories under a directory over smart HTTP.",
+		Args:  cobra.MaximumNArgs(1),
+		RunE:  executeServe,
+	}
+	serveHTTP        string
+	serveReceivePack bool
+)
+
+func init() {
+	serveCmd.Flags().StringVar(&serveHTTP, "http", "", "Listen for HTTP requests on this address, such as ':8080'")
+	serveCmd.Flags().BoolVar(&serveReceivePack, "enable-receive-pack", false, "Accept pushes into repositories that don't set http.receivepack")
+}
+
+func executeServe(cmd *cobra.Command, args []string) error {
+	if serveHTTP == "" {
+		return errors.New("nothing to serve: use --http <address>")
+	}
+
+	root := wd
+	if len(args) > 0 {
+		root = fromCurrentDir(args[0])
+	}
+
+	handler := &httpbackend.Handler{
+		Root:        root,
+		ReceivePack: serveReceivePack,
+		Committer:   committerIdentity(),
+		ErrorLog:    log.New(stderr, "", log.LstdFlags),
+	}
+	fmt.Fprintf(stdout, "Serving %s on %s\n", root, serveHTTP)
+
+	return http.ListenAndServe(serveHTTP, handler)
+}
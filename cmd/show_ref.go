@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/neocortical/got/ref"
+	"github.com/neocortical/got/repository"
+	"github.com/neocortical/got/revision"
+	"github.com/spf13/cobra"
+)
+
+var (
+	showRefCmd = &cobra.Command{
+		Use:   "show-ref [--head] [-d] [-s] [--heads] [--tags] [-q] [--verify] [<pattern>...]",
+		Short: "List refs and the objects they point to.",
+		RunE:  executeShowRef,
+	}
+	showRefHead   bool
+	showRefDeref  bool
+	showRefHash   bool
+	showRefHeads  bool
+	showRefTags   bool
+	showRefQuiet  bool
+	showRefVerify bool
+)
+
+func init() {
+	showRefCmd.Flags().BoolVar(&showRefHead, "head", false, "Show HEAD as well")
+	showRefCmd.Flags().BoolVarP(&showRefDeref, "dereference", "d", false, "Also show the objects annotated tags point to")
+	showRefCmd.Flags().BoolVarP(&showRefHash, "hash", "s", false, "Only show object names")
+	showRefCmd.Flags().BoolVar(&showRefHeads, "heads", false, "Only show branches")
+	showRefCmd.Flags().BoolVar(&showRefTags, "tags", false, "Only show tags")
+	showRefCmd.Flags().BoolVarP(&showRefQuiet, "quiet", "q", false, "Don't print anything, only report through the exit status")
+	showRefCmd.Flags().BoolVar(&showRefVerify, "verify", false, "Require exact ref names")
+}
+
+func executeShowRef(cmd *cobra.Command, args []string) (err error) {
+	repo := repository.NewRepo(wd)
+	refs := repo.Refs()
+
+	var matched []ref.Reference
+	if showRefVerify {
+		for _, name := range args {
+			oid := ""
+			if name == "HEAD" || strings.HasPrefix(name, "refs/") {
+				oid, err = refs.ReadRef(name)
+				if err != nil {
+					return err
+				}
+			}
+			if oid == "" {
+				if showRefQuiet {
+					return silentFailure(cmd)
+				}
+				return fmt.Errorf("'%s' - not a valid ref", name)
+			}
+			matched = append(matched, ref.Reference{Name: name, OID: oid})
+		}
+	} else {
+		if showRefHead {
+			if oid, err := refs.ReadHead(); err == nil && oid != "" {
+				matched = append(matched, ref.Reference{Name: "HEAD", OID: oid})
+			}
+		}
+
+		all, err := refs.List("refs/")
+		if err != nil {
+			return err
+		}
+		for _, r := range all {
+			if showRefMatch(r.Name, args) {
+				matched = append(matched, r)
+			}
+		}
+	}
+
+	if len(matched) == 0 {
+		return silentFailure(cmd)
+	}
+	if showRefQuiet {
+		return nil
+	}
+
+	for _, r := range matched {
+		printShowRef(r.OID, r.Name)
+
+		if !showRefDeref {
+			continue
+		}
+		peeled := r.Peeled
+		if peeled == "" {
+			if peeled, err = revision.PeelTags(repo, r.OID); err != nil {
+				return err
+			}
+		}
+		if peeled != "" {
+			printShowRef(peeled, r.Name+"^{}")
+		}
+	}
+
+	return nil
+}
+
+// showRefMatch reports whether a ref is selected by the --heads and --tags
+// filters and by the patterns, which match whole trailing components of the
+// name.
+func showRefMatch(name string, patterns []string) bool {
+	if showRefHeads || showRefTags {
+		if !(showRefHeads && strings.HasPrefix(name, ref.HeadsPrefix)) && !(showRefTags && strings.HasPrefix(name, "refs/tags/")) {
+			return false
+		}
+	}
+
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if name == pattern || strings.HasSuffix(name, "/"+pattern) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func printShowRef(oid string, name string) {
+	if showRefHash {
+		fmt.Fprintln(stdout, oid)
+	} else {
+		fmt.Fprintf(stdout, "%s %s\n", oid, name)
+	}
+}
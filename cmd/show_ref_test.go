@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestShowRef(t *testing.T) {
+	outbuf, _ := setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+	defer func() { showRefHead, showRefHeads, showRefVerify, showRefHash = false, false, false, false }()
+
+	initOrDie(t)
+	writeFile(t, "1.txt", "one")
+	commitOrDie(t, "first")
+	head := readRefOrDie(t, "HEAD")
+	for _, name := range []string{"refs/heads/feature/master", "refs/tags/v1"} {
+		if err := executeUpdateRef(updateRefCmd, []string{name, head}); err != nil {
+			t.Fatalf("error creating %s: %v", name, err)
+		}
+	}
+
+	var tests = []struct {
+		args     []string
+		head     bool
+		heads    bool
+		verify   bool
+		hash     bool
+		expected string
+	}{
+		{nil, false, false, false, false, fmt.Sprintf("%[1]s refs/heads/feature/master\n%[1]s refs/heads/master\n%[1]s refs/tags/v1\n", head)},
+		{nil, true, true, false, false, fmt.Sprintf("%[1]s HEAD\n%[1]s refs/heads/feature/master\n%[1]s refs/heads/master\n", head)},
+		{[]string{"master"}, false, false, false, true, fmt.Sprintf("%[1]s\n%[1]s\n", head)},
+		{[]string{"ter"}, false, false, false, false, ""},
+		{[]string{"refs/tags/v1"}, false, false, true, false, fmt.Sprintf("%s refs/tags/v1\n", head)},
+	}
+
+	for i, test := range tests {
+		showRefHead, showRefHeads, showRefVerify, showRefHash = test.head, test.heads, test.verify, test.hash
+		outbuf.Reset()
+
+		err := executeShowRef(showRefCmd, test.args)
+		if test.expected == "" {
+			if err != errSilentFailure {
+				t.Errorf("test %d: expected a silent failure but got: %v", i, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %d: expected no errors but got: %v", i, err)
+		}
+		if outbuf.String() != test.expected {
+			t.Errorf("test %d: expected output '%s' but got '%s'", i, test.expected, outbuf.String())
+		}
+	}
+
+	showRefVerify = true
+	err := executeShowRef(showRefCmd, []string{"v1"})
+	if err == nil || err == errSilentFailure {
+		t.Errorf("expected an error verifying an abbreviated name but got: %v", err)
+	}
+}
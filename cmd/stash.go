@@ -0,0 +1,1230 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/neocortical/got/blob"
+	"github.com/neocortical/got/diff"
+	"github.com/neocortical/got/ignore"
+	"github.com/neocortical/got/index"
+	"github.com/neocortical/got/pathspec"
+	"github.com/neocortical/got/ref"
+	"github.com/neocortical/got/repository"
+	"github.com/neocortical/got/revision"
+	"github.com/neocortical/got/tree"
+	"github.com/neocortical/got/workspace"
+	"github.com/spf13/cobra"
+)
+
+const (
+	stashRef = "refs/stash"
+
+	// the labels of the two sides of a conflict when applying a stash
+	stashOursLabel   = "Updated upstream"
+	stashTheirsLabel = "Stashed changes"
+
+	modeExecutable = "100755"
+
+	diffContext   = 3
+	diffStatWidth = 80
+)
+
+var (
+	stashCmd = &cobra.Command{
+		Use:   "stash [push [-m <message>] [-u] [--] [<pathspec>...] | list | show | apply | pop | drop | branch | clear]",
+		Short: "Shelve changes to the workspace and index, and bring them back later.",
+		Args:  cobra.NoArgs,
+		RunE:  executeStashPush,
+	}
+	stashPushCmd = &cobra.Command{
+		Use:   "push [-m <message>] [-u] [--] [<pathspec>...]",
+		Short: "Save local changes as a new stash entry and reset them to HEAD.",
+		RunE:  executeStashPush,
+	}
+	stashListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List the stash entries, newest first.",
+		Args:  cobra.NoArgs,
+		RunE:  executeStashList,
+	}
+	stashShowCmd = &cobra.Command{
+		Use:   "show [-p] [<stash>]",
+		Short: "Show the changes a stash entry records.",
+		Args:  cobra.MaximumNArgs(1),
+		RunE:  executeStashShow,
+	}
+	stashApplyCmd = &cobra.Command{
+		Use:   "apply [--index] [<stash>]",
+		Short: "Apply the changes a stash entry records to the workspace.",
+		Args:  cobra.MaximumNArgs(1),
+		RunE:  executeStashApply,
+	}
+	stashPopCmd = &cobra.Command{
+		Use:   "pop [--index] [<stash>]",
+		Short: "Apply a stash entry and drop it.",
+		Args:  cobra.MaximumNArgs(1),
+		RunE:  executeStashPop,
+	}
+	stashDropCmd = &cobra.Command{
+		Use:   "drop [<stash>]",
+		Short: "Remove a stash entry.",
+		Args:  cobra.MaximumNArgs(1),
+		RunE:  executeStashDrop,
+	}
+	stashBranchCmd = &cobra.Command{
+		Use:   "branch <branch> [<stash>]",
+		Short: "Check out a new branch at the commit a stash entry was made on, and pop it there.",
+		Args:  cobra.RangeArgs(1, 2),
+		RunE:  executeStashBranch,
+	}
+	stashClearCmd = &cobra.Command{
+		Use:   "clear",
+		Short: "Remove every stash entry.",
+		Args:  cobra.NoArgs,
+		RunE:  executeStashClear,
+	}
+	stashMessage          string
+	stashIncludeUntracked bool
+	stashPatch            bool
+	stashIndex            bool
+)
+
+func init() {
+	stashCmd.AddCommand(stashPushCmd)
+	stashCmd.AddCommand(stashListCmd)
+	stashCmd.AddCommand(stashShowCmd)
+	stashCmd.AddCommand(stashApplyCmd)
+	stashCmd.AddCommand(stashPopCmd)
+	stashCmd.AddCommand(stashDropCmd)
+	stashCmd.AddCommand(stashBranchCmd)
+	stashCmd.AddCommand(stashClearCmd)
+
+	stashCmd.Flags().StringVarP(&stashMessage, "message", "m", "", "Describe the stash entry with this message")
+	stashCmd.Flags().BoolVarP(&stashIncludeUntracked, "include-untracked", "u", false, "Stash untracked files too, and remove them")
+	stashPushCmd.Flags().StringVarP(&stashMessage, "message", "m", "", "Describe the stash entry with this message")
+	stashPushCmd.Flags().BoolVarP(&stashIncludeUntracked, "include-untracked", "u", false, "Stash untracked files too, and remove them")
+	stashShowCmd.Flags().BoolVarP(&stashPatch, "patch", "p", false, "Show the changes as a patch rather than a diffstat")
+	stashApplyCmd.Flags().BoolVar(&stashIndex, "index", false, "Restore the changes to the index as well as the workspace")
+	stashPopCmd.Flags().BoolVar(&stashIndex, "index", false, "Restore the changes to the index as well as the workspace")
+}
+
+// executeStashPush records the index and workspace as a stash entry: a
+// commit of the workspace whose parents are HEAD, a commit of the index and,
+// with -u, a commit of the untracked files, as git makes them. The stashed
+// paths are then reset to HEAD.
+func executeStashPush(cmd *cobra.Command, args []string) (err error) {
+	repo := repository.NewRepo(wd)
+	db := repo.Database()
+	idx := repo.Index()
+	refs := repo.Refs()
+
+	ps, err := parsePathspec(args)
+	if err != nil {
+		return err
+	}
+
+	if err = idx.LoadForUpdate(); err != nil {
+		return fmt.Errorf("error loading index: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			idx.Rollback()
+		}
+	}()
+
+	head, err := refs.ReadHead()
+	if err != nil {
+		return fmt.Errorf("error reading head: %w", err)
+	}
+	if head == "" {
+		return errors.New("You do not have the initial commit yet")
+	}
+	for _, entry := range idx.AllEntries() {
+		if entry.Stage() > 0 {
+			return fmt.Errorf("Cannot save the current index state: '%s' is unmerged", entry.Path())
+		}
+	}
+
+	headCommit, err := readCommit(db, head)
+	if err != nil {
+		return err
+	}
+	headFiles := map[string]tree.Node{}
+	if err = readTreeFiles(repo, headCommit.TreeOID, "", headFiles); err != nil {
+		return err
+	}
+
+	// only files known to the index or HEAD, or untracked files that are
+	// being stashed, can be named
+	selected := map[string]bool{}
+	for p := range headFiles {
+		selected[p] = ps.Match(p, false)
+	}
+	for _, entry := range idx.Entries() {
+		selected[entry.Path()] = ps.Match(entry.Path(), false)
+	}
+	var untracked []string
+	if stashIncludeUntracked {
+		if untracked, err = untrackedFiles(repo, idx, ps); err != nil {
+			return err
+		}
+	}
+	if unmatched := ps.Unmatched(); len(unmatched) > 0 {
+		return fmt.Errorf("pathspec '%s' did not match any file(s) known to got", unmatched[0])
+	}
+
+	modified, _, err := workspaceChanges(repo, idx, ps)
+	if err != nil {
+		return err
+	}
+
+	// the stashed paths are the selected ones that differ from HEAD
+	var changed []string
+	for p, isSelected := range selected {
+		node, inHead := headFiles[p]
+		entry, inIndex := idx.GetEntry(p)
+		if isSelected && (modified[p] != 0 || inHead != inIndex || (inHead && !sameFile(node, entry))) {
+			changed = append(changed, p)
+		}
+	}
+	sort.Strings(changed)
+	if len(changed) == 0 && len(untracked) == 0 {
+		fmt.Fprintln(stdout, "No local changes to save")
+		return idx.WriteUpdates()
+	}
+
+	indexTree, err := storeIndexTree(db, idx)
+	if err != nil {
+		return err
+	}
+	workspaceIdx := copyIndex(repo, idx)
+	if err = stageWorkspaceChanges(repo, workspaceIdx, modified); err != nil {
+		return err
+	}
+	workspaceTree, err := storeIndexTree(db, workspaceIdx)
+	if err != nil {
+		return err
+	}
+
+	branch := "(no branch)"
+	if target, _ := refs.ReadSymbolicRef("HEAD"); strings.HasPrefix(target, ref.HeadsPrefix) {
+		branch = strings.TrimPrefix(target, ref.HeadsPrefix)
+	}
+	subject := strings.SplitN(headCommit.Message, "\n", 2)[0]
+	description := fmt.Sprintf("%s: %s %s", branch, abbreviate(head), subject)
+
+	author := ref.Author{Name: getenv(EnvAuthorName), Email: getenv(EnvAuthorEmail), Time: time.Now()}
+	committer := committerIdentity()
+
+	indexCommit := ref.Commit{Parents: []string{head}, TreeOID: indexTree, Author: author, Committer: committer, Message: "index on " + description}
+	indexOID, err := db.Store(indexCommit)
+	if err != nil {
+		return fmt.Errorf("error storing commit: %w", err)
+	}
+	parents := []string{head, indexOID}
+
+	if len(untracked) > 0 {
+		untrackedTree, err := storeUntrackedTree(repo, untracked)
+		if err != nil {
+			return err
+		}
+		untrackedCommit := ref.Commit{TreeOID: untrackedTree, Author: author, Committer: committer, Message: "untracked files on " + description}
+		untrackedOID, err := db.Store(untrackedCommit)
+		if err != nil {
+			return fmt.Errorf("error storing commit: %w", err)
+		}
+		parents = append(parents, untrackedOID)
+	}
+
+	message := "WIP on " + description
+	if stashMessage != "" {
+		message = fmt.Sprintf("On %s: %s", branch, stashMessage)
+	}
+	stashOID, err := db.Store(ref.Commit{Parents: parents, TreeOID: workspaceTree, Author: author, Committer: committer, Message: message})
+	if err != nil {
+		return fmt.Errorf("error storing commit: %w", err)
+	}
+
+	// refs/stash is only logged once it has a reflog, and its reflog is the
+	// list of stash entries
+	if !refs.HasReflog(stashRef) {
+		if err = refs.WriteReflog(stashRef, nil); err != nil {
+			return err
+		}
+	}
+	if err = refs.UpdateRef(stashRef, stashOID, committer, message); err != nil {
+		return fmt.Errorf("error updating %s: %w", stashRef, err)
+	}
+
+	for _, p := range changed {
+		if node, inHead := headFiles[p]; inHead {
+			err = workspace.CheckoutFile(db, node, wd, p, idx)
+		} else {
+			idx.Remove(p)
+			err = workspace.RemoveFile(wd, p)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for _, p := range untracked {
+		if err = workspace.RemoveFile(wd, p); err != nil {
+			return err
+		}
+	}
+
+	if err = idx.WriteUpdates(); err != nil {
+		return fmt.Errorf("error writing index: %w", err)
+	}
+
+	fmt.Fprintf(stdout, "Saved working directory and index state %s\n", message)
+	return nil
+}
+
+func executeStashList(cmd *cobra.Command, args []string) (err error) {
+	entries, err := repository.NewRepo(wd).Refs().Reflog(stashRef)
+	if err != nil {
+		return err
+	}
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		fmt.Fprintf(stdout, "stash@{%d}: %s\n", len(entries)-1-i, entries[i].Message)
+	}
+
+	return nil
+}
+
+// executeStashShow shows the changes a stash entry made to the commit it was
+// made on, as a diffstat or, with -p, a patch.
+func executeStashShow(cmd *cobra.Command, args []string) (err error) {
+	repo := repository.NewRepo(wd)
+
+	_, stash, err := resolveStash(repo, args)
+	if err != nil {
+		return err
+	}
+	base, err := readCommit(repo.Database(), stash.Parent())
+	if err != nil {
+		return err
+	}
+
+	baseFiles, stashFiles := map[string]tree.Node{}, map[string]tree.Node{}
+	if err = readTreeFiles(repo, base.TreeOID, "", baseFiles); err != nil {
+		return err
+	}
+	if err = readTreeFiles(repo, stash.TreeOID, "", stashFiles); err != nil {
+		return err
+	}
+
+	var paths []string
+	for p, node := range baseFiles {
+		if !sameFile(node, stashFiles[p]) {
+			paths = append(paths, p)
+		}
+	}
+	for p := range stashFiles {
+		if _, inBase := baseFiles[p]; !inBase {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+
+	if stashPatch {
+		for _, p := range paths {
+			if err = writeFilePatch(repo, stdout, p, baseFiles[p], stashFiles[p]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return writeDiffStat(repo, stdout, paths, baseFiles, stashFiles)
+}
+
+func executeStashApply(cmd *cobra.Command, args []string) (err error) {
+	repo := repository.NewRepo(wd)
+
+	_, stash, err := resolveStash(repo, args)
+	if err != nil {
+		return err
+	}
+
+	conflicts, err := applyStash(repo, stash, stashIndex)
+	if err != nil {
+		return err
+	}
+	if conflicts {
+		return silentFailure(cmd)
+	}
+
+	return nil
+}
+
+func executeStashPop(cmd *cobra.Command, args []string) (err error) {
+	repo := repository.NewRepo(wd)
+
+	name, n, err := resolveStashEntry(repo, args)
+	if err != nil {
+		return err
+	}
+	_, stash, err := resolveStash(repo, args)
+	if err != nil {
+		return err
+	}
+
+	conflicts, err := applyStash(repo, stash, stashIndex)
+	if err != nil {
+		return err
+	}
+	if conflicts {
+		fmt.Fprintln(stdout, "The stash entry is kept in case you need it again.")
+		return silentFailure(cmd)
+	}
+
+	return dropStash(repo, name, n)
+}
+
+func executeStashDrop(cmd *cobra.Command, args []string) (err error) {
+	repo := repository.NewRepo(wd)
+
+	name, n, err := resolveStashEntry(repo, args)
+	if err != nil {
+		return err
+	}
+
+	return dropStash(repo, name, n)
+}
+
+// executeStashBranch checks out a new branch at the commit a stash entry was
+// made on, where the entry applies cleanly, and pops it there.
+func executeStashBranch(cmd *cobra.Command, args []string) (err error) {
+	repo := repository.NewRepo(wd)
+
+	stashArgs := args[1:]
+	_, stash, err := resolveStash(repo, stashArgs)
+	if err != nil {
+		return err
+	}
+
+	if err = checkoutNewBranch(repo, args[0], stash.Parent()); err != nil {
+		return err
+	}
+
+	conflicts, err := applyStash(repo, stash, true)
+	if err != nil {
+		return err
+	}
+	if conflicts {
+		return silentFailure(cmd)
+	}
+
+	// a stash given as a commit rather than an entry has nothing to drop
+	name, n, err := resolveStashEntry(repo, stashArgs)
+	if err != nil {
+		return nil
+	}
+
+	return dropStash(repo, name, n)
+}
+
+func executeStashClear(cmd *cobra.Command, args []string) (err error) {
+	refs := repository.NewRepo(wd).Refs()
+
+	oid, err := refs.ReadRef(stashRef)
+	if err != nil || oid == "" {
+		return err
+	}
+
+	// deleting the ref deletes its reflog too
+	t := refs.Transaction(committerIdentity())
+	if err = t.Delete(stashRef, oid, ""); err != nil {
+		return err
+	}
+
+	return t.Commit()
+}
+
+// resolveStashEntry returns the name and number of the stash entry args give,
+// which is stash@{0} if they give none. An entry may be given as just its
+// number.
+func resolveStashEntry(repo *repository.Repo, args []string) (name string, n int, err error) {
+	name = "refs/stash@{0}"
+	if len(args) > 0 {
+		name = args[0]
+		if _, err := strconv.Atoi(name); err == nil {
+			name = fmt.Sprintf("stash@{%s}", name)
+		}
+	}
+
+	entries, err := repo.Refs().Reflog(stashRef)
+	if err != nil {
+		return "", 0, err
+	}
+	if len(entries) == 0 {
+		return "", 0, errors.New("No stash entries found.")
+	}
+
+	refName, selector, ok := revision.SplitReflogSelector(name)
+	if ok {
+		n, err = strconv.Atoi(selector)
+		ok = err == nil && n >= 0 && revision.ExpandRef(repo, refName) == stashRef
+	}
+	if !ok {
+		return "", 0, fmt.Errorf("'%s' is not a stash reference", name)
+	}
+	if n >= len(entries) {
+		return "", 0, fmt.Errorf("%s is not a valid reference", name)
+	}
+
+	return name, n, nil
+}
+
+// resolveStash reads the stash commit args give, as an entry or any revision
+// naming a stash-like commit, one with a parent for the index.
+func resolveStash(repo *repository.Repo, args []string) (oid string, stash ref.Commit, err error) {
+	rev := "refs/stash@{0}"
+	if len(args) > 0 {
+		rev = args[0]
+	}
+	if _, err := strconv.Atoi(rev); err == nil || strings.Contains(rev, "@{") || len(args) == 0 {
+		name, _, err := resolveStashEntry(repo, args)
+		if err != nil {
+			return "", ref.Commit{}, err
+		}
+		rev = name
+	}
+
+	oid, err = revision.Resolve(repo, rev)
+	if err != nil {
+		return "", ref.Commit{}, fmt.Errorf("%s is not a valid reference", rev)
+	}
+	stash, err = readCommit(repo.Database(), oid)
+	if err != nil || len(stash.Parents) < 2 {
+		return "", ref.Commit{}, fmt.Errorf("'%s' is not a stash-like commit", rev)
+	}
+
+	return oid, stash, nil
+}
+
+// dropStash removes entry n from the stash reflog, pointing refs/stash at the
+// newest entry left, or deleting it with the last.
+func dropStash(repo *repository.Repo, name string, n int) error {
+	refs := repo.Refs()
+	committer := committerIdentity()
+
+	entries, err := refs.Reflog(stashRef)
+	if err != nil {
+		return err
+	}
+	dropped := len(entries) - 1 - n
+	oid := entries[dropped].NewOID
+	kept := append(entries[:dropped:dropped], entries[dropped+1:]...)
+
+	if len(kept) == 0 {
+		t := refs.Transaction(committer)
+		if err = t.Delete(stashRef, oid, ""); err != nil {
+			return err
+		}
+		if err = t.Commit(); err != nil {
+			return err
+		}
+	} else {
+		// each entry's old value is the entry before it
+		for i := range kept {
+			kept[i].OldOID = repo.ObjectFormat().ZeroOID()
+			if i > 0 {
+				kept[i].OldOID = kept[i-1].NewOID
+			}
+		}
+		if err = refs.UpdateRef(stashRef, kept[len(kept)-1].NewOID, committer, ""); err != nil {
+			return fmt.Errorf("error updating %s: %w", stashRef, err)
+		}
+		if err = refs.WriteReflog(stashRef, kept); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(stdout, "Dropped %s (%s)\n", name, oid)
+	return nil
+}
+
+// mergedFile is the result of merging the changes two sides made to a file.
+type mergedFile struct {
+	path string
+	// node is the merged file, or nil if it was deleted
+	node tree.Node
+	// conflict describes a conflict, in which case stages holds the base,
+	// ours and theirs versions and content, unless nil, the file with
+	// conflict markers
+	conflict string
+	stages   [3]tree.Node
+	content  []byte
+	// merged is set for a merge of the contents of the file
+	merged bool
+}
+
+// mergeFiles merges the changes theirs made to base into ours, which are each
+// a tree's files by path, returning the files that end up differing from
+// ours.
+func mergeFiles(repo *repository.Repo, base, ours, theirs map[string]tree.Node) (result []mergedFile, err error) {
+	paths := map[string]bool{}
+	for _, files := range []map[string]tree.Node{base, ours, theirs} {
+		for p := range files {
+			paths[p] = true
+		}
+	}
+
+	var sorted []string
+	for p := range paths {
+		sorted = append(sorted, p)
+	}
+	sort.Strings(sorted)
+
+	for _, p := range sorted {
+		b, o, t := base[p], ours[p], theirs[p]
+		switch {
+		case sameFile(o, t) || sameFile(b, t):
+			continue
+		case sameFile(b, o):
+			result = append(result, mergedFile{path: p, node: t})
+			continue
+		}
+
+		stages := [3]tree.Node{b, o, t}
+		if o == nil || t == nil {
+			survivor := o
+			if survivor == nil {
+				survivor = t
+			}
+			result = append(result, mergedFile{path: p, node: survivor, conflict: "modify/delete", stages: stages})
+			continue
+		}
+
+		file, err := mergeFileContents(repo, p, b, o, t)
+		if err != nil {
+			return nil, err
+		}
+		file.stages = stages
+		result = append(result, file)
+	}
+
+	return result, nil
+}
+
+func mergeFileContents(repo *repository.Repo, p string, b, o, t tree.Node) (mergedFile, error) {
+	db := repo.Database()
+
+	var contents [3][]string
+	for i, node := range []tree.Node{b, o, t} {
+		if node == nil {
+			continue
+		}
+		obj, err := db.Read(node.OID())
+		if err != nil {
+			return mergedFile{}, fmt.Errorf("error reading blob %s for '%s': %w", node.OID(), p, err)
+		}
+		contents[i] = diff.Lines(obj.Serialize())
+	}
+
+	// a mode only one side changed is kept
+	executable := o.ModeString() == modeExecutable
+	if b != nil && b.ModeString() == o.ModeString() {
+		executable = t.ModeString() == modeExecutable
+	}
+
+	lines, conflicts := diff.Merge3(contents[0], contents[1], contents[2], stashOursLabel, stashTheirsLabel)
+	content := []byte(strings.Join(lines, ""))
+	if conflicts > 0 {
+		kind := "content"
+		if b == nil {
+			kind = "add/add"
+		}
+		return mergedFile{path: p, node: index.NewTreeEntry(p, "", executable), conflict: kind, content: content, merged: true}, nil
+	}
+
+	oid, err := db.Store(blob.New(content))
+	if err != nil {
+		return mergedFile{}, fmt.Errorf("error storing merged '%s': %w", p, err)
+	}
+
+	return mergedFile{path: p, node: index.NewTreeEntry(p, oid, executable), merged: true}, nil
+}
+
+// applyStash merges the changes a stash made to the commit it was made on
+// into the workspace and, with restoreIndex, the changes it made to the index
+// into the index, then restores any untracked files it saved. Without
+// restoreIndex, only files the stash added are staged, unless there are
+// conflicts, which leave the merge staged with the conflicted paths
+// unmerged. It reports whether there were conflicts.
+func applyStash(repo *repository.Repo, stash ref.Commit, restoreIndex bool) (conflicts bool, err error) {
+	db := repo.Database()
+	idx := repo.Index()
+
+	baseCommit, err := readCommit(db, stash.Parents[0])
+	if err != nil {
+		return false, err
+	}
+	indexCommit, err := readCommit(db, stash.Parents[1])
+	if err != nil {
+		return false, err
+	}
+
+	if err = idx.LoadForUpdate(); err != nil {
+		return false, fmt.Errorf("error loading index: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			idx.Rollback()
+		}
+	}()
+
+	for _, entry := range idx.AllEntries() {
+		if entry.Stage() > 0 {
+			return false, errors.New("Cannot apply a stash in the middle of a merge")
+		}
+	}
+
+	ps, err := parsePathspec(nil)
+	if err != nil {
+		return false, err
+	}
+	modified, _, err := workspaceChanges(repo, idx, ps)
+	if err != nil {
+		return false, err
+	}
+
+	ours := indexNodes(idx)
+	base, theirs := map[string]tree.Node{}, map[string]tree.Node{}
+	if err = readTreeFiles(repo, baseCommit.TreeOID, "", base); err != nil {
+		return false, err
+	}
+	if err = readTreeFiles(repo, stash.TreeOID, "", theirs); err != nil {
+		return false, err
+	}
+
+	var indexResult []mergedFile
+	if restoreIndex && indexCommit.TreeOID != baseCommit.TreeOID {
+		stashedIndex := map[string]tree.Node{}
+		if err = readTreeFiles(repo, indexCommit.TreeOID, "", stashedIndex); err != nil {
+			return false, err
+		}
+		if indexResult, err = mergeFiles(repo, base, ours, stashedIndex); err != nil {
+			return false, err
+		}
+		for _, file := range indexResult {
+			if file.conflict != "" {
+				return false, errors.New("Conflicts in index. Try without --index.")
+			}
+		}
+	}
+
+	result, err := mergeFiles(repo, base, ours, theirs)
+	if err != nil {
+		return false, err
+	}
+
+	untracked := map[string]tree.Node{}
+	if len(stash.Parents) > 2 {
+		untrackedCommit, err := readCommit(db, stash.Parents[2])
+		if err != nil {
+			return false, err
+		}
+		if err = readTreeFiles(repo, untrackedCommit.TreeOID, "", untracked); err != nil {
+			return false, err
+		}
+	}
+
+	// nothing is changed unless local changes and untracked files survive
+	var overwritten []string
+	for _, file := range result {
+		if modified[file.path] != 0 {
+			overwritten = append(overwritten, file.path)
+		} else if _, tracked := ours[file.path]; !tracked && exists(file.path) {
+			return false, fmt.Errorf("The following untracked working tree files would be overwritten by merge:\n\t%s\nPlease move or remove them before you merge.", file.path)
+		}
+	}
+	if len(overwritten) > 0 {
+		return false, fmt.Errorf("Your local changes to the following files would be overwritten by merge:\n\t%s\nPlease commit your changes or stash them before you merge.", strings.Join(overwritten, "\n\t"))
+	}
+	for p := range untracked {
+		if exists(p) {
+			return false, fmt.Errorf("%s already exists, no checkout\ncould not restore untracked files from stash", p)
+		}
+	}
+
+	for _, file := range result {
+		if file.merged {
+			fmt.Fprintf(stdout, "Auto-merging %s\n", file.path)
+		}
+		if err = applyMergedFile(repo, idx, file); err != nil {
+			return false, err
+		}
+		if file.conflict != "" {
+			conflicts = true
+		}
+	}
+
+	if conflicts && restoreIndex {
+		fmt.Fprintln(stdout, "Index was not unstashed.")
+	} else if !conflicts {
+		// the index keeps its entries but for the files the stash adds or,
+		// with restoreIndex, the changes the stash made to the index
+		checkedOut := map[string]*index.Entry{}
+		for _, file := range result {
+			if entry, tracked := idx.GetEntry(file.path); tracked {
+				checkedOut[file.path] = entry
+			}
+			if entry, tracked := ours[file.path]; tracked {
+				idx.Add(entry.(*index.Entry))
+			} else if restoreIndex {
+				idx.Remove(file.path)
+			}
+		}
+		for _, file := range indexResult {
+			entry, tracked := checkedOut[file.path]
+			switch {
+			case file.node == nil:
+				idx.Remove(file.path)
+			case tracked && sameFile(entry, file.node):
+				idx.Add(entry)
+			default:
+				idx.Add(treeEntry(file.path, file.node))
+			}
+		}
+	}
+
+	// untracked files are restored without being added
+	scratch := index.NewIndex("")
+	for p, node := range untracked {
+		if err = workspace.CheckoutFile(db, node, wd, p, scratch); err != nil {
+			return false, err
+		}
+	}
+
+	if err = idx.WriteUpdates(); err != nil {
+		return false, fmt.Errorf("error writing index: %w", err)
+	}
+
+	return conflicts, nil
+}
+
+// applyMergedFile writes the result of a merge to the workspace and index.
+func applyMergedFile(repo *repository.Repo, idx index.Index, file mergedFile) (err error) {
+	switch {
+	case file.content != nil:
+		perm := os.FileMode(0644)
+		if file.node.ModeString() == modeExecutable {
+			perm = 0755
+		}
+		filename := toAbsolutePath(file.path)
+		if err = os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			return fmt.Errorf("error creating directory for '%s': %w", file.path, err)
+		}
+		if err = ioutil.WriteFile(filename, file.content, perm); err != nil {
+			return fmt.Errorf("error writing '%s': %w", file.path, err)
+		}
+	case file.node == nil:
+		idx.Remove(file.path)
+		err = workspace.RemoveFile(wd, file.path)
+	default:
+		err = workspace.CheckoutFile(repo.Database(), file.node, wd, file.path, idx)
+	}
+	if err != nil {
+		return err
+	}
+
+	if file.conflict == "" {
+		return nil
+	}
+
+	var stages [3]*index.Entry
+	for i, node := range file.stages {
+		if node != nil {
+			stages[i] = treeEntry(file.path, node)
+		}
+	}
+	idx.AddConflict(file.path, stages)
+
+	switch file.conflict {
+	case "modify/delete":
+		deleted, modified := stashOursLabel, stashTheirsLabel
+		if file.stages[1] != nil {
+			deleted, modified = modified, deleted
+		}
+		fmt.Fprintf(stdout, "CONFLICT (modify/delete): %s deleted in %s and modified in %s. Version %s of %s left in tree.\n", file.path, deleted, modified, modified, file.path)
+	default:
+		fmt.Fprintf(stdout, "CONFLICT (%s): Merge conflict in %s\n", file.conflict, file.path)
+	}
+
+	return nil
+}
+
+// checkoutNewBranch creates a branch at a commit and switches to it, updating
+// the files that differ from HEAD in the index and workspace, unless they
+// have local changes.
+func checkoutNewBranch(repo *repository.Repo, name string, oid string) (err error) {
+	db := repo.Database()
+	idx := repo.Index()
+	refs := repo.Refs()
+
+	refName := ref.HeadsPrefix + name
+	if !ref.ValidName(refName) {
+		return fmt.Errorf("'%s' is not a valid branch name", name)
+	}
+	if existing, err := refs.ReadRef(refName); err != nil || existing != "" {
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("a branch named '%s' already exists", name)
+	}
+
+	if err = idx.LoadForUpdate(); err != nil {
+		return fmt.Errorf("error loading index: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			idx.Rollback()
+		}
+	}()
+
+	head, err := refs.ReadHead()
+	if err != nil {
+		return fmt.Errorf("error reading head: %w", err)
+	}
+	current, target := map[string]tree.Node{}, map[string]tree.Node{}
+	if head != "" {
+		headCommit, err := readCommit(db, head)
+		if err != nil {
+			return err
+		}
+		if err = readTreeFiles(repo, headCommit.TreeOID, "", current); err != nil {
+			return err
+		}
+	}
+	commit, err := readCommit(db, oid)
+	if err != nil {
+		return err
+	}
+	if err = readTreeFiles(repo, commit.TreeOID, "", target); err != nil {
+		return err
+	}
+
+	ps, err := parsePathspec(nil)
+	if err != nil {
+		return err
+	}
+	modified, _, err := workspaceChanges(repo, idx, ps)
+	if err != nil {
+		return err
+	}
+
+	var changed, overwritten []string
+	for _, files := range []map[string]tree.Node{current, target} {
+		for p := range files {
+			if !sameFile(current[p], target[p]) && (files[p] == current[p] || current[p] == nil) {
+				changed = append(changed, p)
+			}
+		}
+	}
+	sort.Strings(changed)
+	for _, p := range changed {
+		entry, tracked := idx.GetEntry(p)
+		staged := tracked != (current[p] != nil) || (tracked && !sameFile(entry, current[p]))
+		if modified[p] != 0 || staged || (!tracked && exists(p)) {
+			overwritten = append(overwritten, p)
+		}
+	}
+	if len(overwritten) > 0 {
+		return fmt.Errorf("Your local changes to the following files would be overwritten by checkout:\n\t%s\nPlease commit your changes or stash them before you switch branches.", strings.Join(overwritten, "\n\t"))
+	}
+
+	for _, p := range changed {
+		if node := target[p]; node != nil {
+			err = workspace.CheckoutFile(db, node, wd, p, idx)
+		} else {
+			idx.Remove(p)
+			err = workspace.RemoveFile(wd, p)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err = idx.WriteUpdates(); err != nil {
+		return fmt.Errorf("error writing index: %w", err)
+	}
+
+	from := abbreviate(head)
+	if target, _ := refs.ReadSymbolicRef("HEAD"); target != "" {
+		from = strings.TrimPrefix(target, ref.HeadsPrefix)
+	}
+	committer := committerIdentity()
+	if err = refs.UpdateRef(refName, oid, committer, "branch: Created from "+oid); err != nil {
+		return fmt.Errorf("error creating branch '%s': %w", name, err)
+	}
+	if err = refs.WriteSymbolicRef("HEAD", refName, committer, fmt.Sprintf("checkout: moving from %s to %s", from, name)); err != nil {
+		return fmt.Errorf("error switching to branch '%s': %w", name, err)
+	}
+
+	fmt.Fprintf(stderr, "Switched to a new branch '%s'\n", name)
+	return nil
+}
+
+// untrackedFiles returns the untracked files a pathspec selects, leaving out
+// ignored ones.
+func untrackedFiles(repo *repository.Repo, idx index.Index, ps *pathspec.Pathspec) (result []string, err error) {
+	cfg, err := repo.Config()
+	if err != nil {
+		return nil, err
+	}
+	excludesFile, _ := cfg.Get("core.excludesfile")
+	excludes, err := ignore.LoadStandard(wd, excludesFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading ignore files: %w", err)
+	}
+
+	files, err := workspace.ListFiles(wd)
+	if err != nil {
+		return nil, fmt.Errorf("error walking workspace: %w", err)
+	}
+
+	for _, f := range files {
+		relativePath := filepath.ToSlash(toRelativePath(f.Path))
+		if idx.IsTracked(relativePath) || excludes.Ignored(relativePath, false) || !ps.Match(relativePath, false) {
+			continue
+		}
+		result = append(result, relativePath)
+	}
+
+	return result, nil
+}
+
+// storeUntrackedTree stores the untracked files and a tree of them.
+func storeUntrackedTree(repo *repository.Repo, paths []string) (string, error) {
+	untrackedIdx := index.NewIndex("")
+	untrackedIdx.SetObjectFormat(repo.ObjectFormat())
+
+	var files []workspace.File
+	for _, p := range paths {
+		info, err := os.Stat(toAbsolutePath(p))
+		if err != nil {
+			return "", fmt.Errorf("error reading '%s': %w", p, err)
+		}
+		files = append(files, workspace.File{Path: toAbsolutePath(p), Info: info})
+	}
+
+	jobs, err := hashJobs(repo)
+	if err != nil {
+		return "", err
+	}
+	err = workspace.HashFiles(repo.Database(), files, jobs, func(result workspace.HashResult) error {
+		if result.Err != nil {
+			return fmt.Errorf("error adding file '%s' to stash: %w", toRelativePath(result.Path), result.Err)
+		}
+
+		untrackedIdx.Add(index.NewEntry(filepath.ToSlash(toRelativePath(result.Path)), result.OID, result.Info))
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return storeIndexTree(repo.Database(), untrackedIdx)
+}
+
+// copyIndex returns an index in memory holding copies of idx's entries.
+func copyIndex(repo *repository.Repo, idx index.Index) index.Index {
+	result := index.NewIndex("")
+	result.SetObjectFormat(repo.ObjectFormat())
+	for _, entry := range idx.Entries() {
+		e := *entry
+		result.Add(&e)
+	}
+
+	return result
+}
+
+// indexNodes returns the entries of an index by path.
+func indexNodes(idx index.Index) map[string]tree.Node {
+	result := map[string]tree.Node{}
+	for _, entry := range idx.Entries() {
+		result[entry.Path()] = entry
+	}
+
+	return result
+}
+
+func treeEntry(p string, node tree.Node) *index.Entry {
+	return index.NewTreeEntry(p, node.OID(), node.ModeString() == modeExecutable)
+}
+
+// sameFile reports whether two versions of a file, either of which may be
+// missing, have the same contents and mode.
+func sameFile(a, b tree.Node) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+
+	return a.OID() == b.OID() && a.ModeString() == b.ModeString()
+}
+
+func exists(p string) bool {
+	_, err := os.Lstat(toAbsolutePath(p))
+	return err == nil
+}
+
+// writeFilePatch writes the differences between two versions of a file,
+// either of which may be missing, as git's patch format does.
+func writeFilePatch(repo *repository.Repo, w io.Writer, p string, a, b tree.Node) error {
+	zero := repo.ObjectFormat().ZeroOID()
+	fmt.Fprintf(w, "diff --git a/%s b/%s\n", p, p)
+
+	oldOID, newOID := zero, zero
+	oldName, newName := "a/"+p, "b/"+p
+	mode := ""
+	switch {
+	case a == nil:
+		fmt.Fprintf(w, "new file mode %s\n", b.ModeString())
+		newOID, oldName = b.OID(), "/dev/null"
+	case b == nil:
+		fmt.Fprintf(w, "deleted file mode %s\n", a.ModeString())
+		oldOID, newName = a.OID(), "/dev/null"
+	default:
+		oldOID, newOID = a.OID(), b.OID()
+		if a.ModeString() != b.ModeString() {
+			fmt.Fprintf(w, "old mode %s\nnew mode %s\n", a.ModeString(), b.ModeString())
+		} else {
+			mode = " " + a.ModeString()
+		}
+	}
+	if oldOID == newOID {
+		return nil
+	}
+	fmt.Fprintf(w, "index %s..%s%s\n", abbreviate(oldOID), abbreviate(newOID), mode)
+
+	oldLines, newLines, binary, err := readFileLines(repo, a, b)
+	if err != nil {
+		return err
+	}
+	if binary {
+		_, err = fmt.Fprintf(w, "Binary files %s and %s differ\n", oldName, newName)
+		return err
+	}
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", oldName, newName)
+
+	return diff.Unified(w, diff.Diff(oldLines, newLines), diffContext)
+}
+
+// readFileLines reads the lines of two versions of a file, either of which may
+// be missing, and whether either looks binary, holding a NUL byte.
+func readFileLines(repo *repository.Repo, a, b tree.Node) (aLines []string, bLines []string, binary bool, err error) {
+	var lines [2][]string
+	for i, node := range []tree.Node{a, b} {
+		if node == nil {
+			continue
+		}
+		obj, err := repo.Database().Read(node.OID())
+		if err != nil {
+			return nil, nil, false, fmt.Errorf("error reading blob %s: %w", node.OID(), err)
+		}
+		data := obj.Serialize()
+		binary = binary || bytes.IndexByte(data, 0) != -1
+		lines[i] = diff.Lines(data)
+	}
+
+	return lines[0], lines[1], binary, nil
+}
+
+// writeDiffStat writes a diffstat of the changes between two trees' files: a
+// line per path with the number of lines changed and a graph of them, scaled
+// to fit the width, then a summary.
+func writeDiffStat(repo *repository.Repo, w io.Writer, paths []string, a, b map[string]tree.Node) error {
+	type stat struct {
+		insertions, deletions int
+		binary                bool
+	}
+
+	stats := make([]stat, len(paths))
+	nameWidth, maxChanges, insertions, deletions := 0, 0, 0, 0
+	for i, p := range paths {
+		oldLines, newLines, binary, err := readFileLines(repo, a[p], b[p])
+		if err != nil {
+			return err
+		}
+		if !binary {
+			stats[i].insertions, stats[i].deletions = diff.Stat(diff.Diff(oldLines, newLines))
+		}
+		stats[i].binary = binary
+
+		insertions += stats[i].insertions
+		deletions += stats[i].deletions
+		if len(p) > nameWidth {
+			nameWidth = len(p)
+		}
+		if changes := stats[i].insertions + stats[i].deletions; changes > maxChanges {
+			maxChanges = changes
+		}
+	}
+
+	numberWidth := len(strconv.Itoa(maxChanges))
+	graphWidth := diffStatWidth - nameWidth - numberWidth - 6
+	for i, p := range paths {
+		if stats[i].binary {
+			fmt.Fprintf(w, " %-*s | Bin\n", nameWidth, p)
+			continue
+		}
+
+		added, removed := stats[i].insertions, stats[i].deletions
+		if maxChanges > graphWidth {
+			total := scaleLinear(added+removed, graphWidth, maxChanges)
+			if total < 2 && added > 0 && removed > 0 {
+				total = 2
+			}
+			if added < removed {
+				added = scaleLinear(added, graphWidth, maxChanges)
+				removed = total - added
+			} else {
+				removed = scaleLinear(removed, graphWidth, maxChanges)
+				added = total - removed
+			}
+		}
+		fmt.Fprintf(w, " %-*s | %*d %s%s\n", nameWidth, p, numberWidth, stats[i].insertions+stats[i].deletions, strings.Repeat("+", added), strings.Repeat("-", removed))
+	}
+
+	summary := fmt.Sprintf(" %d %s changed", len(paths), plural(len(paths), "file", "files"))
+	if insertions > 0 || deletions == 0 {
+		summary += fmt.Sprintf(", %d %s(+)", insertions, plural(insertions, "insertion", "insertions"))
+	}
+	if deletions > 0 || insertions == 0 {
+		summary += fmt.Sprintf(", %d %s(-)", deletions, plural(deletions, "deletion", "deletions"))
+	}
+	_, err := fmt.Fprintln(w, summary)
+	return err
+}
+
+// scaleLinear scales a count of changed lines to a graph of width columns
+// for at most max changes, keeping any change visible.
+func scaleLinear(n int, width int, max int) int {
+	if n == 0 {
+		return 0
+	}
+
+	return 1 + n*(width-1)/max
+}
+
+func plural(n int, singular string, plural string) string {
+	if n == 1 {
+		return singular
+	}
+
+	return plural
+}
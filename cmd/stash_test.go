@@ -0,0 +1,254 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/neocortical/got/repository"
+)
+
+func resetStashFlags() {
+	stashMessage = ""
+	stashIncludeUntracked = false
+	stashPatch = false
+	stashIndex = false
+}
+
+func readWorkspaceFile(t *testing.T, filename string) string {
+	data, err := ioutil.ReadFile(filepath.Join(wd, filename))
+	if err != nil {
+		t.Fatalf("error reading '%s': %v", filename, err)
+	}
+	return string(data)
+}
+
+func stashOrDie(t *testing.T, message string) {
+	stashMessage = message
+	if err := executeStashPush(stashCmd, nil); err != nil {
+		t.Fatalf("expected no errors during stash but got: %v", err)
+	}
+	stashMessage = ""
+}
+
+func TestStashPushAndPop(t *testing.T) {
+	outbuf, _ := setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+	resetCommitFlags()
+	defer resetCommitFlags()
+	resetStashFlags()
+	defer resetStashFlags()
+
+	initOrDie(t)
+	if err := executeStashPush(stashCmd, nil); err == nil || !strings.Contains(err.Error(), "initial commit") {
+		t.Errorf("expected an error without a commit but got: %v", err)
+	}
+
+	writeFile(t, "foo.txt", "one\n")
+	writeFile(t, "bar.txt", "two\n")
+	commitOrDie(t, "first")
+	committed := headFiles(t)
+
+	outbuf.Reset()
+	stashOrDie(t, "")
+	if outbuf.String() != "No local changes to save\n" {
+		t.Errorf("expected nothing to save but got: %q", outbuf.String())
+	}
+
+	writeFile(t, "foo.txt", "staged\n")
+	writeFile(t, "new.txt", "new\n")
+	if err := executeAdd(addCmd, []string{"foo.txt", "new.txt"}); err != nil {
+		t.Fatalf("expected no errors during add but got: %v", err)
+	}
+	writeFile(t, "foo.txt", "unstaged\n")
+	deleteFile(t, "bar.txt")
+	writeFile(t, "untracked.txt", "untracked\n")
+
+	outbuf.Reset()
+	stashIncludeUntracked = true
+	stashOrDie(t, "my work")
+	if expected := "Saved working directory and index state On master: my work\n"; outbuf.String() != expected {
+		t.Errorf("expected %q but got: %q", expected, outbuf.String())
+	}
+
+	// the workspace and index are back at HEAD
+	if files := indexFiles(t); !reflect.DeepEqual(files, committed) {
+		t.Errorf("expected the index to match HEAD but got: %v", files)
+	}
+	if readWorkspaceFile(t, "foo.txt") != "one\n" || readWorkspaceFile(t, "bar.txt") != "two\n" {
+		t.Error("expected the tracked files to be reset")
+	}
+	for _, name := range []string{"new.txt", "untracked.txt"} {
+		if _, err := os.Stat(filepath.Join(wd, name)); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed but got: %v", name, err)
+		}
+	}
+
+	// the entry is a merge of HEAD, the index and the untracked files
+	repo := repository.NewRepo(wd)
+	_, stash, err := resolveStash(repo, nil)
+	if err != nil {
+		t.Fatalf("error reading stash: %v", err)
+	}
+	if len(stash.Parents) != 3 || stash.Message != "On master: my work" {
+		t.Fatalf("expected a stash with 3 parents but got: %+v", stash)
+	}
+	index, err := readCommit(repo.Database(), stash.Parents[1])
+	if err != nil || !strings.HasPrefix(index.Message, "index on master: ") {
+		t.Errorf("expected the index commit but got: %+v (%v)", index, err)
+	}
+
+	outbuf.Reset()
+	if err := executeStashList(stashListCmd, nil); err != nil || outbuf.String() != "stash@{0}: On master: my work\n" {
+		t.Errorf("expected the entry to be listed but got: %q (%v)", outbuf.String(), err)
+	}
+
+	stashIndex = true
+	if err := executeStashPop(stashPopCmd, nil); err != nil {
+		t.Fatalf("expected no errors during pop but got: %v", err)
+	}
+	expected := map[string]string{"foo.txt": blobOID("staged\n"), "bar.txt": blobOID("two\n"), "new.txt": blobOID("new\n")}
+	if files := indexFiles(t); !reflect.DeepEqual(files, expected) {
+		t.Errorf("expected the index to be restored but got: %v", files)
+	}
+	if readWorkspaceFile(t, "foo.txt") != "unstaged\n" || readWorkspaceFile(t, "untracked.txt") != "untracked\n" {
+		t.Error("expected the workspace to be restored")
+	}
+	if _, err := os.Stat(filepath.Join(wd, "bar.txt")); !os.IsNotExist(err) {
+		t.Errorf("expected bar.txt to stay deleted but got: %v", err)
+	}
+
+	if oid, _ := repo.Refs().ReadRef(stashRef); oid != "" || repo.Refs().HasReflog(stashRef) {
+		t.Errorf("expected popping the last entry to delete %s", stashRef)
+	}
+}
+
+func TestStashApplyConflict(t *testing.T) {
+	outbuf, _ := setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+	resetCommitFlags()
+	defer resetCommitFlags()
+	resetStashFlags()
+	defer resetStashFlags()
+
+	initOrDie(t)
+	writeFile(t, "foo.txt", "1\n2\n3\n4\n5\n6\n7\n8\n")
+	commitOrDie(t, "first")
+
+	writeFile(t, "foo.txt", "1\ntwo\n3\n4\n5\n6\n7\n8\n")
+	stashOrDie(t, "")
+	writeFile(t, "foo.txt", "1\n2\n3\n4\n5\n6\n7\neight\n")
+	stashOrDie(t, "")
+
+	// changes to other lines merge cleanly, and stay unstaged
+	writeFile(t, "foo.txt", "1\nTWO\n3\n4\n5\n6\n7\n8\n")
+	commitOrDie(t, "second")
+	if err := executeStashApply(stashApplyCmd, nil); err != nil {
+		t.Fatalf("expected no errors during apply but got: %v", err)
+	}
+	if content := readWorkspaceFile(t, "foo.txt"); content != "1\nTWO\n3\n4\n5\n6\n7\neight\n" {
+		t.Errorf("expected the changes to be merged but got: %q", content)
+	}
+	if files, head := indexFiles(t), headFiles(t); !reflect.DeepEqual(files, head) {
+		t.Errorf("expected the index to match HEAD but got: %v", files)
+	}
+
+	// local changes aren't overwritten
+	if err := executeStashApply(stashApplyCmd, []string{"1"}); err == nil || !strings.Contains(err.Error(), "foo.txt") {
+		t.Errorf("expected local changes to stop the apply but got: %v", err)
+	}
+
+	writeFile(t, "foo.txt", "1\nTWO\n3\n4\n5\n6\n7\n8\n")
+	outbuf.Reset()
+	if err := executeStashPop(stashPopCmd, []string{"stash@{1}"}); err != errSilentFailure {
+		t.Fatalf("expected the pop to fail with conflicts but got: %v", err)
+	}
+	if !strings.Contains(outbuf.String(), "CONFLICT (content): Merge conflict in foo.txt") || !strings.Contains(outbuf.String(), "kept") {
+		t.Errorf("expected the conflict to be reported but got: %q", outbuf.String())
+	}
+	expected := "1\n<<<<<<< Updated upstream\nTWO\n=======\ntwo\n>>>>>>> Stashed changes\n3\n4\n5\n6\n7\n8\n"
+	if content := readWorkspaceFile(t, "foo.txt"); content != expected {
+		t.Errorf("expected %q but got: %q", expected, content)
+	}
+
+	idx := repository.NewRepo(wd).Index()
+	if err := idx.Load(); err != nil {
+		t.Fatalf("error loading index: %v", err)
+	}
+	stages := idx.Unmerged("foo.txt")
+	if len(stages) != 3 || stages[0].OID() != blobOID("1\n2\n3\n4\n5\n6\n7\n8\n") || stages[2].OID() != blobOID("1\ntwo\n3\n4\n5\n6\n7\n8\n") {
+		t.Errorf("expected the three versions of foo.txt to be staged but got: %v", stages)
+	}
+
+	outbuf.Reset()
+	executeStashList(stashListCmd, nil)
+	if strings.Count(outbuf.String(), "\n") != 2 {
+		t.Errorf("expected the entry to be kept but got: %q", outbuf.String())
+	}
+}
+
+func TestStashDropAndClear(t *testing.T) {
+	outbuf, _ := setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+	resetCommitFlags()
+	defer resetCommitFlags()
+	resetStashFlags()
+	defer resetStashFlags()
+
+	initOrDie(t)
+	writeFile(t, "foo.txt", "one\n")
+	commitOrDie(t, "first")
+
+	for _, message := range []string{"a", "b", "c"} {
+		writeFile(t, "foo.txt", message+"\n")
+		stashOrDie(t, message)
+	}
+
+	if err := executeStashDrop(stashDropCmd, []string{"stash@{3}"}); err == nil {
+		t.Error("expected an error dropping a missing entry")
+	}
+	if err := executeStashDrop(stashDropCmd, []string{"master@{0}"}); err == nil {
+		t.Error("expected an error dropping an entry of another reflog")
+	}
+
+	outbuf.Reset()
+	if err := executeStashDrop(stashDropCmd, []string{"1"}); err != nil {
+		t.Fatalf("expected no errors during drop but got: %v", err)
+	}
+	if !strings.HasPrefix(outbuf.String(), "Dropped stash@{1} (") {
+		t.Errorf("expected the entry to be dropped but got: %q", outbuf.String())
+	}
+	if err := executeStashDrop(stashDropCmd, nil); err != nil {
+		t.Fatalf("expected no errors during drop but got: %v", err)
+	}
+
+	outbuf.Reset()
+	executeStashList(stashListCmd, nil)
+	if outbuf.String() != "stash@{0}: On master: a\n" {
+		t.Errorf("expected one entry left but got: %q", outbuf.String())
+	}
+	repo := repository.NewRepo(wd)
+	entries, _ := repo.Refs().Reflog(stashRef)
+	if oid, _ := repo.Refs().ReadRef(stashRef); len(entries) != 1 || oid != entries[0].NewOID {
+		t.Errorf("expected %s to point at the entry left but got: %s", stashRef, oid)
+	}
+
+	outbuf.Reset()
+	stashPatch = true
+	if err := executeStashShow(stashShowCmd, nil); err != nil {
+		t.Fatalf("expected no errors during show but got: %v", err)
+	}
+	if !strings.Contains(outbuf.String(), "--- a/foo.txt\n+++ b/foo.txt\n@@ -1 +1 @@\n-one\n+a\n") {
+		t.Errorf("expected a patch but got: %q", outbuf.String())
+	}
+
+	if err := executeStashClear(stashClearCmd, nil); err != nil {
+		t.Fatalf("expected no errors during clear but got: %v", err)
+	}
+	if err := executeStashPop(stashPopCmd, nil); err == nil || err.Error() != "No stash entries found." {
+		t.Errorf("expected no entries but got: %v", err)
+	}
+}
@@ -2,18 +2,13 @@ package cmd
 
 import (
 	"fmt"
-	"io/ioutil"
-	"os"
-	"path"
 	"path/filepath"
 	"sort"
 
-	"github.com/neocortical/got/blob"
 	"github.com/neocortical/got/index"
-	"github.com/neocortical/got/object"
-	"github.com/neocortical/got/ref"
+	"github.com/neocortical/got/pathspec"
 	"github.com/neocortical/got/repository"
-	"github.com/neocortical/got/tree"
+	"github.com/neocortical/got/workspace"
 	"github.com/spf13/cobra"
 )
 
@@ -26,7 +21,7 @@ const (
 
 var (
 	statusCmd = &cobra.Command{
-		Use:   "status",
+		Use:   "status [pathspec...]",
 		Short: "View the status of the local repository.",
 		RunE:  executeStatus,
 	}
@@ -37,8 +32,11 @@ func executeStatus(cmd *cobra.Command, args []string) (err error) {
 
 	repo := repository.NewRepo(workspaceDir)
 	idx := repo.Index()
-	db := repo.Database()
-	refs := repo.Refs()
+
+	ps, err := parsePathspec(args)
+	if err != nil {
+		return err
+	}
 
 	err = idx.LoadForUpdate()
 	if err != nil {
@@ -46,155 +44,117 @@ func executeStatus(cmd *cobra.Command, args []string) (err error) {
 		return fmt.Errorf("error loading index: %w", err)
 	}
 
-	var untracked []string
-	var modified = map[string]int{}
-	var untrackedSet = map[string]struct{}{}
-	var workspaceFileset = map[string]struct{}{}
-	err = filepath.Walk(wd, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		if info.IsDir() {
-			if info.Name() == repository.GitDir {
-				return filepath.SkipDir
-			}
-			return nil
-		}
-
-		relativePath := toRelativePath(path)
-		workspaceFileset[relativePath] = struct{}{}
-
-		if !idx.IsTracked(relativePath) {
-			relativePath := idx.FirstUntrackedPath(relativePath)
-			if _, seen := untrackedSet[relativePath]; !seen {
-				untrackedSet[relativePath] = struct{}{}
-				untracked = append(untracked, relativePath)
-			}
-		} else {
-			statModified, timesModified := idx.IsMetadataModified(relativePath, info)
-			if statModified {
-				modified[path] |= statusWorkspaceModified
-			}
-
-			if !timesModified {
-				return nil
-			}
-
-			// Light modification was inconclusive. Gotta read the file and compare the content to the index
-			data, err := ioutil.ReadFile(toAbsolutePath(path))
-			if err != nil {
-				idx.Rollback()
-				return fmt.Errorf("error reading file '%s': %w", relativePath, err)
-			}
-
-			b := blob.New(data)
+	modified, untracked, err := workspaceChanges(repo, idx, ps)
+	if err != nil {
+		idx.Rollback()
+		return err
+	}
 
-			oid, err := db.Store(b)
-			if err != nil {
-				idx.Rollback()
-				return fmt.Errorf("error storing blob '%s': %w", relativePath, err)
-			}
+	var modifiedPaths []string
+	for path := range modified {
+		modifiedPaths = append(modifiedPaths, path)
+	}
+	sort.Strings(modifiedPaths)
 
-			existingEntry, _ := idx.GetEntry(relativePath)
+	for _, path := range modifiedPaths {
+		fmt.Fprintf(stdout, "%s %s\n", porcelainStatus(modified[path]), toCurrentDirPath(path))
+	}
 
-			if oid == existingEntry.OID() {
-				if timesModified {
-					idx.Add(index.NewEntry(relativePath, oid, info))
-				}
+	for _, path := range untracked {
+		fmt.Fprintln(stdout, "??", toCurrentDirPath(path))
+	}
 
-				return nil
-			}
+	err = idx.WriteUpdates()
+	return
+}
 
-			modified[relativePath] |= statusWorkspaceModified
-		}
+// workspaceChanges compares the workspace files a pathspec selects with the
+// loaded index. It returns the tracked paths that changed, as status bits,
+// and the untracked ones, collapsed to their first untracked directory.
+// Entries found unchanged by hashing get their stat data refreshed.
+func workspaceChanges(repo *repository.Repo, idx index.Index, ps *pathspec.Pathspec) (modified map[string]int, untracked []string, err error) {
+	db := repo.Database()
 
-		return nil
-	})
+	jobs, err := hashJobs(repo)
 	if err != nil {
-		idx.Rollback()
-		return fmt.Errorf("error walking workspace: %w", err)
-	}
-
-	for _, entry := range idx.Entries() {
-		// fmt.Println(entry.Name())
-		if _, stillExists := workspaceFileset[entry.Path()]; !stillExists {
-			modified[entry.Path()] |= statusWorkspaceDeleted
-		}
+		return nil, nil, err
 	}
 
-	var modifiedPaths []string
-	for path := range modified {
-		modifiedPaths = append(modifiedPaths, path)
+	statOptions, err := repo.StatOptions()
+	if err != nil {
+		return nil, nil, err
 	}
-	sort.Strings(modifiedPaths)
+	idx.SetStatOptions(statOptions)
 
-	// cache/HEAD changes
-	headCommitOID, err := refs.ReadHead()
+	files, err := workspace.ListFiles(wd)
 	if err != nil {
-		return fmt.Errorf("error reading head: %w", err)
+		return nil, nil, fmt.Errorf("error walking workspace: %w", err)
 	}
 
-	if headCommitOID != "" {
-		headCommitObj, err := db.Read(headCommitOID)
-		if err != nil {
-			return fmt.Errorf("error reading head commit from database: %w", err)
-		}
+	modified = map[string]int{}
+	var untrackedSet = map[string]struct{}{}
+	var workspaceFileset = map[string]struct{}{}
+	var toHash []workspace.File
+	for _, f := range files {
+		relativePath := toRelativePath(f.Path)
+		workspaceFileset[relativePath] = struct{}{}
 
-		headCommit, err := ref.DeserializeCommit(headCommitObj.Serialize())
-		if err != nil {
-			return fmt.Errorf("error reading/parsing head commit: %w", err)
+		if !ps.Match(filepath.ToSlash(relativePath), false) {
+			continue
 		}
 
-		err = showTree(db, headCommit.TreeOID, "")
-		if err != nil {
-			return fmt.Errorf("error showing HEAD commit tree")
+		if !idx.IsTracked(relativePath) {
+			relativePath := idx.FirstUntrackedPath(relativePath)
+			if _, seen := untrackedSet[relativePath]; !seen {
+				untrackedSet[relativePath] = struct{}{}
+				untracked = append(untracked, relativePath)
+			}
+			continue
 		}
-	}
 
-	for _, path := range modifiedPaths {
-		fmt.Fprintf(stdout, "%s %s\n", porcelainStatus(modified[path]), path)
-	}
+		statModified, contentUncertain := idx.IsMetadataModified(relativePath, f.Info)
+		if statModified {
+			modified[relativePath] |= statusWorkspaceModified
+			continue
+		}
 
-	for _, path := range untracked {
-		fmt.Fprintln(stdout, "??", path)
+		// Light modification was inconclusive. Gotta read the file and compare the content to the index
+		if contentUncertain {
+			toHash = append(toHash, f)
+		}
 	}
 
-	err = idx.WriteUpdates()
-	return
-}
+	err = workspace.HashFiles(db, toHash, jobs, func(result workspace.HashResult) error {
+		relativePath := toRelativePath(result.Path)
+		if result.Err != nil {
+			return fmt.Errorf("error hashing file '%s': %w", relativePath, result.Err)
+		}
 
-func showTree(db object.Database, rootOID string, pathPrefix string) (err error) {
-	treeObj, err := db.Read(rootOID)
-	if err != nil {
-		return fmt.Errorf("error reading/parsing head tree: %w", err)
-	}
+		existingEntry, _ := idx.GetEntry(relativePath)
+		if result.OID == existingEntry.OID() {
+			idx.Add(index.NewEntry(relativePath, result.OID, result.Info))
+			return nil
+		}
 
-	headTree, err := tree.DeserializeTree(treeObj.Serialize())
+		modified[relativePath] |= statusWorkspaceModified
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("error deserializing head tree: %w", err)
+		return nil, nil, fmt.Errorf("error walking workspace: %w", err)
 	}
 
-	for _, e := range headTree.Entries() {
-		if e.ModeString() == "40000" {
-			err = showTree(db, e.OID(), path.Join(pathPrefix, e.Name()))
-		} else {
-			fmt.Printf("%8s %s %s\n", e.ModeString(), e.OID(), path.Join(pathPrefix, e.Name()))
+	for _, entry := range idx.Entries() {
+		if !ps.Match(entry.Path(), false) {
+			continue
+		}
+		if _, stillExists := workspaceFileset[entry.Path()]; !stillExists {
+			modified[entry.Path()] |= statusWorkspaceDeleted
 		}
 	}
 
-	return nil
+	return modified, untracked, nil
 }
 
-// def show_tree(repo, oid, prefix = Pathname.new(""))
-// tree = repo.database.load(oid)
-// tree.entries.each do |name, entry| path = prefix.join(name)
-// if entry.tree?
-// show_tree(repo, entry.oid, path) else
-// mode = entry.mode.to_s(8)
-// puts "#{ mode } #{ entry.oid } #{ path }" end
-// end end
-
 func porcelainStatus(bitfield int) (result string) {
 	if bitfield&statusindexModified > 0 {
 		result = "M"
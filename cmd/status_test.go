@@ -1,7 +1,11 @@
 package cmd
 
 import (
+	"os"
+	"path"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestListUntrackedFilesInOrder(t *testing.T) {
@@ -240,3 +244,109 @@ func TestStatusReportsDeletedFilesInDeletedDirectories(t *testing.T) {
 		t.Errorf("expected output \n%s\n but got: \n%s\n", expected, outbuf.String())
 	}
 }
+
+func TestStatusDetectsRacilyCleanModifications(t *testing.T) {
+	outbuf, errbuf := setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+
+	initOrDie(t)
+	writeFile(t, ".git/config", "[core]\n\ttrustctime = false\n\tcheckStat = minimal\n")
+	writeFile(t, "racy.txt", "one")
+
+	// a modification time no earlier than the index's makes the entry racy
+	mtime := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path.Join(wd, "racy.txt"), mtime, mtime); err != nil {
+		t.Fatalf("error setting mtime: %v", err)
+	}
+
+	err := executeAdd(addCmd, []string{"racy.txt"})
+	if err != nil {
+		t.Fatalf("expected no errors during add but got: %v", err)
+	}
+
+	// same size, same mtime: only the content differs
+	writeFile(t, "racy.txt", "two")
+	if err := os.Chtimes(path.Join(wd, "racy.txt"), mtime, mtime); err != nil {
+		t.Fatalf("error setting mtime: %v", err)
+	}
+
+	outbuf.Reset()
+	err = executeStatus(statusCmd, []string{})
+	if err != nil {
+		t.Errorf("expected no errors but got: %v", err)
+	}
+	if errbuf.Len() > 0 {
+		t.Errorf("expected no error output but got: %s", errbuf.String())
+	}
+	expected := " M racy.txt\n"
+
+	if outbuf.String() != expected {
+		t.Errorf("expected output \n%s\n but got: \n%s\n", expected, outbuf.String())
+	}
+}
+
+func TestStatusRejectsInvalidCheckStat(t *testing.T) {
+	setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+
+	initOrDie(t)
+	writeFile(t, ".git/config", "[core]\n\tcheckStat = sometimes\n")
+
+	err := executeStatus(statusCmd, []string{})
+	if err == nil {
+		t.Error("expected an error for an invalid core.checkStat value")
+	}
+}
+
+func TestStatusLimitedByPathspec(t *testing.T) {
+	outbuf, errbuf := setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+
+	setupStatusChangedFixtureOrDie(t)
+	outbuf.Reset()
+
+	writeFile(t, "1.txt", "changed")
+	writeFile(t, "a/2.txt", "modified")
+	deleteFile(t, "a/b/3.txt")
+	writeFile(t, "a/new.txt", "new")
+
+	err := executeStatus(statusCmd, []string{"a", ":!a/b"})
+	if err != nil {
+		t.Errorf("expected no errors but got: %v", err)
+	}
+	if errbuf.Len() > 0 {
+		t.Errorf("expected no error output but got: %s", errbuf.String())
+	}
+	expected := " M a/2.txt\n?? a/new.txt\n"
+
+	if outbuf.String() != expected {
+		t.Errorf("expected output \n%s\n but got: \n%s\n", expected, outbuf.String())
+	}
+}
+
+func TestStatusFromSubdirectory(t *testing.T) {
+	outbuf, errbuf := setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+
+	setupStatusChangedFixtureOrDie(t)
+	outbuf.Reset()
+
+	writeFile(t, "1.txt", "changed")
+	writeFile(t, "a/2.txt", "modified")
+	deleteFile(t, "a/b/3.txt")
+	writeFile(t, "a/new.txt", "new")
+	SetWd(filepath.Join(wd, "a"))
+
+	err := executeStatus(statusCmd, nil)
+	if err != nil {
+		t.Errorf("expected no errors but got: %v", err)
+	}
+	if errbuf.Len() > 0 {
+		t.Errorf("expected no error output but got: %s", errbuf.String())
+	}
+	expected := " M ../1.txt\n M 2.txt\n D b/3.txt\n?? new.txt\n"
+
+	if outbuf.String() != expected {
+		t.Errorf("expected output \n%s\n but got: \n%s\n", expected, outbuf.String())
+	}
+}
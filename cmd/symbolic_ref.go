@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/neocortical/got/ref"
+	"github.com/neocortical/got/repository"
+	"github.com/neocortical/got/revision"
+	"github.com/spf13/cobra"
+)
+
+var (
+	symbolicRefCmd = &cobra.Command{
+		Use:   "symbolic-ref [-m <reason>] [-d] [-q] [--short] <name> [<ref>]",
+		Short: "Read, modify and delete symbolic refs.",
+		Args:  cobra.RangeArgs(1, 2),
+		RunE:  executeSymbolicRef,
+	}
+	symbolicRefMessage string
+	symbolicRefDelete  bool
+	symbolicRefQuiet   bool
+	symbolicRefShort   bool
+)
+
+func init() {
+	symbolicRefCmd.Flags().StringVarP(&symbolicRefMessage, "message", "m", "", "Reason for the update, recorded in the reflog")
+	symbolicRefCmd.Flags().BoolVarP(&symbolicRefDelete, "delete", "d", false, "Delete the symbolic ref")
+	symbolicRefCmd.Flags().BoolVarP(&symbolicRefQuiet, "quiet", "q", false, "Don't report an error if the ref isn't symbolic")
+	symbolicRefCmd.Flags().BoolVar(&symbolicRefShort, "short", false, "Shorten the ref name when reading it")
+}
+
+func executeSymbolicRef(cmd *cobra.Command, args []string) (err error) {
+	repo := repository.NewRepo(wd)
+	refs := repo.Refs()
+	name := args[0]
+
+	if len(args) == 2 {
+		if symbolicRefDelete {
+			return errors.New("-d takes a single ref name")
+		}
+		if !strings.HasPrefix(args[1], "refs/") {
+			return fmt.Errorf("refusing to point %s outside of refs/", name)
+		}
+		return refs.WriteSymbolicRef(name, args[1], committerIdentity(), symbolicRefMessage)
+	}
+
+	target, err := refs.ReadSymbolicRef(name)
+	if err != nil {
+		return err
+	}
+	if target == "" {
+		if symbolicRefQuiet {
+			return silentFailure(cmd)
+		}
+		return fmt.Errorf("ref %s is not a symbolic ref", name)
+	}
+
+	if symbolicRefDelete {
+		if name == "HEAD" {
+			return errors.New("deleting 'HEAD' is not allowed")
+		}
+
+		tx := refs.Transaction(committerIdentity())
+		if err = tx.Add(ref.RefUpdate{Name: name, NewOID: ref.ZeroOID, NoDeref: true}); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	if symbolicRefShort {
+		target = revision.ShortenRef(repo, target)
+	}
+	fmt.Fprintln(stdout, target)
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"testing"
+)
+
+func TestSymbolicRef(t *testing.T) {
+	outbuf, _ := setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+
+	initOrDie(t)
+	writeFile(t, "1.txt", "one")
+	commitOrDie(t, "first")
+	outbuf.Reset()
+
+	err := executeSymbolicRef(symbolicRefCmd, []string{"HEAD"})
+	if err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	if outbuf.String() != "refs/heads/master\n" {
+		t.Errorf("unexpected output: '%s'", outbuf.String())
+	}
+
+	err = executeSymbolicRef(symbolicRefCmd, []string{"HEAD", "refs/heads/topic"})
+	if err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+
+	defer func() { symbolicRefShort = false }()
+	symbolicRefShort = true
+	outbuf.Reset()
+	err = executeSymbolicRef(symbolicRefCmd, []string{"HEAD"})
+	if err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	if outbuf.String() != "topic\n" {
+		t.Errorf("unexpected output: '%s'", outbuf.String())
+	}
+
+	err = executeSymbolicRef(symbolicRefCmd, []string{"HEAD", "master"})
+	if err == nil {
+		t.Error("expected an error pointing HEAD outside of refs/")
+	}
+	err = executeSymbolicRef(symbolicRefCmd, []string{"refs/heads/master"})
+	if err == nil {
+		t.Error("expected an error reading a ref that isn't symbolic")
+	}
+
+	defer func() { symbolicRefDelete = false }()
+	symbolicRefDelete = true
+	err = executeSymbolicRef(symbolicRefCmd, []string{"HEAD"})
+	if err == nil {
+		t.Error("expected an error deleting HEAD")
+	}
+}
@@ -0,0 +1,278 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/neocortical/got/ref"
+	"github.com/neocortical/got/repository"
+	"github.com/neocortical/got/revision"
+	"github.com/spf13/cobra"
+)
+
+var (
+	updateRefCmd = &cobra.Command{
+		Use:   "update-ref [-m <reason>] [--no-deref] (-d <ref> [<old>] | <ref> <new> [<old>] | --stdin [-z])",
+		Short: "Safely update the object name stored in a ref.",
+		RunE:  executeUpdateRef,
+	}
+	updateRefMessage  string
+	updateRefDelete   bool
+	updateRefNoDeref  bool
+	updateRefStdin    bool
+	updateRefNullTerm bool
+)
+
+func init() {
+	updateRefCmd.Flags().StringVarP(&updateRefMessage, "message", "m", "", "Reason for the update, recorded in the reflog")
+	updateRefCmd.Flags().BoolVarP(&updateRefDelete, "delete", "d", false, "Delete the ref")
+	updateRefCmd.Flags().BoolVar(&updateRefNoDeref, "no-deref", false, "Update the ref itself rather than the ref it points to")
+	updateRefCmd.Flags().BoolVar(&updateRefStdin, "stdin", false, "Read updates from standard input and apply them in a transaction")
+	updateRefCmd.Flags().BoolVarP(&updateRefNullTerm, "null", "z", false, "Standard input uses NUL-terminated arguments")
+}
+
+func executeUpdateRef(cmd *cobra.Command, args []string) (err error) {
+	repo := repository.NewRepo(wd)
+
+	if updateRefStdin {
+		if len(args) > 0 || updateRefDelete {
+			return errors.New("--stdin cannot be combined with other arguments")
+		}
+		return updateRefsFromStdin(repo)
+	}
+	if updateRefNullTerm {
+		return errors.New("-z only makes sense with --stdin")
+	}
+
+	update := ref.RefUpdate{Message: updateRefMessage, NoDeref: updateRefNoDeref}
+	if updateRefDelete {
+		if len(args) < 1 || len(args) > 2 {
+			return errors.New("usage: got update-ref -d <ref> [<old>]")
+		}
+		update.Name, update.NewOID = args[0], ref.ZeroOID
+		if len(args) == 2 {
+			if update.OldOID, err = resolveRefValue(repo, args[1]); err != nil {
+				return err
+			}
+			if update.OldOID == ref.ZeroOID {
+				return fmt.Errorf("%s: not a valid old SHA1", args[1])
+			}
+		}
+	} else {
+		if len(args) < 2 || len(args) > 3 {
+			return errors.New("usage: got update-ref <ref> <new> [<old>]")
+		}
+		update.Name = args[0]
+		if update.NewOID, err = resolveRefValue(repo, args[1]); err != nil {
+			return err
+		}
+		if len(args) == 3 {
+			// an empty old value means the ref must not exist yet
+			update.OldOID = ref.ZeroOID
+			if args[2] != "" {
+				if update.OldOID, err = resolveRefValue(repo, args[2]); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	tx := repo.Refs().Transaction(committerIdentity())
+	if err = tx.Add(update); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// resolveRefValue turns a value given to update-ref into an object ID. The
+// all-zero ID is passed through, since it stands for a missing ref.
+func resolveRefValue(repo *repository.Repo, value string) (string, error) {
+	if value == ref.ZeroOID {
+		return value, nil
+	}
+
+	oid, err := revision.Resolve(repo, value)
+	if err != nil {
+		return "", fmt.Errorf("%s: not a valid SHA1", value)
+	}
+
+	return oid, nil
+}
+
+// updateRefsReader splits update-ref --stdin input into commands and their
+// arguments, in either the line-based or the NUL-terminated format.
+type updateRefsReader struct {
+	r        *bufio.Reader
+	nullTerm bool
+}
+
+// next returns the next command and its arguments. In NUL-terminated input
+// the command's remaining arguments are read with args.
+func (ur *updateRefsReader) next() (command string, rest string, err error) {
+	delim := byte('\n')
+	if ur.nullTerm {
+		delim = 0
+	}
+
+	line, err := ur.r.ReadString(delim)
+	if err == io.EOF && line != "" {
+		if ur.nullTerm {
+			return "", "", errors.New("unterminated -z input")
+		}
+		err = nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	line = strings.TrimSuffix(line, string(delim))
+	if space := strings.Index(line, " "); space != -1 {
+		return line[:space], line[space+1:], nil
+	}
+
+	return line, "", nil
+}
+
+// args returns a command's arguments: the values following the ref on the
+// same line, or the following NUL-terminated fields. Missing trailing values
+// are returned as empty strings.
+func (ur *updateRefsReader) args(command string, rest string, count int) (name string, values []string, err error) {
+	if !ur.nullTerm {
+		fields := strings.Split(rest, " ")
+		if rest == "" || len(fields) > count+1 {
+			return "", nil, fmt.Errorf("%s: wrong number of arguments: '%s'", command, rest)
+		}
+		for len(fields) < count+1 {
+			fields = append(fields, "")
+		}
+		return fields[0], fields[1:], nil
+	}
+
+	for i := 0; i < count; i++ {
+		value, err := ur.r.ReadString(0)
+		if err != nil {
+			return "", nil, fmt.Errorf("%s %s: missing arguments", command, rest)
+		}
+		values = append(values, strings.TrimSuffix(value, "\x00"))
+	}
+
+	return rest, values, nil
+}
+
+func updateRefsFromStdin(repo *repository.Repo) (err error) {
+	refs := repo.Refs()
+	ur := &updateRefsReader{r: bufio.NewReader(stdin), nullTerm: updateRefNullTerm}
+
+	var tx *ref.Transaction
+	var explicit, noDeref bool
+	defer func() {
+		if tx != nil && err != nil {
+			tx.Abort()
+		}
+	}()
+
+	for {
+		command, rest, err := ur.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if tx == nil {
+			tx = refs.Transaction(committerIdentity())
+		}
+
+		update := ref.RefUpdate{Message: updateRefMessage, NoDeref: noDeref || updateRefNoDeref}
+		noDeref = false
+
+		var values []string
+		switch command {
+		case "start":
+			explicit = true
+			fmt.Fprintln(stdout, "start: ok")
+			continue
+		case "prepare":
+			if err = tx.Prepare(); err != nil {
+				return err
+			}
+			fmt.Fprintln(stdout, "prepare: ok")
+			continue
+		case "commit":
+			if err = tx.Commit(); err != nil {
+				return err
+			}
+			tx, explicit = nil, false
+			fmt.Fprintln(stdout, "commit: ok")
+			continue
+		case "abort":
+			tx.Abort()
+			tx, explicit = nil, false
+			fmt.Fprintln(stdout, "abort: ok")
+			continue
+		case "option":
+			if rest != "no-deref" {
+				return fmt.Errorf("option unknown: %s", rest)
+			}
+			noDeref = true
+			continue
+		case "update":
+			update.Name, values, err = ur.args(command, rest, 2)
+			if err == nil {
+				if update.NewOID, err = resolveRefValue(repo, values[0]); err == nil && values[1] != "" {
+					update.OldOID, err = resolveRefValue(repo, values[1])
+				}
+			}
+		case "create":
+			update.Name, values, err = ur.args(command, rest, 1)
+			if err == nil {
+				update.OldOID = ref.ZeroOID
+				update.NewOID, err = resolveRefValue(repo, values[0])
+				if err == nil && update.NewOID == ref.ZeroOID {
+					err = fmt.Errorf("create %s: zero new value", update.Name)
+				}
+			}
+		case "delete":
+			update.Name, values, err = ur.args(command, rest, 1)
+			if err == nil {
+				update.NewOID = ref.ZeroOID
+				if values[0] != "" {
+					update.OldOID, err = resolveRefValue(repo, values[0])
+				}
+			}
+		case "verify":
+			update.Name, values, err = ur.args(command, rest, 1)
+			if err == nil {
+				// verifying without a value checks that the ref doesn't exist
+				update.OldOID = ref.ZeroOID
+				if values[0] != "" {
+					update.OldOID, err = resolveRefValue(repo, values[0])
+				}
+			}
+		default:
+			return fmt.Errorf("unknown command: %s", strings.TrimSpace(command+" "+rest))
+		}
+		if err != nil {
+			return err
+		}
+
+		if err = tx.Add(update); err != nil {
+			return err
+		}
+	}
+
+	if tx == nil {
+		return nil
+	}
+	if explicit {
+		tx.Abort()
+		return errors.New("transaction was not committed")
+	}
+
+	return tx.Commit()
+}
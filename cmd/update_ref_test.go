@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/neocortical/got/ref"
+	"github.com/neocortical/got/repository"
+)
+
+func readRefOrDie(t *testing.T, name string) string {
+	oid, err := repository.NewRepo(wd).Refs().ReadRef(name)
+	if err != nil {
+		t.Fatalf("error reading %s: %v", name, err)
+	}
+	return oid
+}
+
+func TestUpdateRef(t *testing.T) {
+	_, _ = setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+
+	initOrDie(t)
+	writeFile(t, "1.txt", "one")
+	commitOrDie(t, "first")
+	head := readRefOrDie(t, "HEAD")
+
+	err := executeUpdateRef(updateRefCmd, []string{"refs/heads/topic", "HEAD", ""})
+	if err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	if oid := readRefOrDie(t, "refs/heads/topic"); oid != head {
+		t.Errorf("expected topic to be created at %s but got '%s'", head, oid)
+	}
+
+	err = executeUpdateRef(updateRefCmd, []string{"refs/heads/topic", "HEAD", ""})
+	if err == nil {
+		t.Error("expected an error creating a ref that already exists")
+	}
+	err = executeUpdateRef(updateRefCmd, []string{"topic", "HEAD"})
+	if err == nil {
+		t.Error("expected an error updating a ref with a bad name")
+	}
+
+	defer func() { updateRefDelete = false }()
+	updateRefDelete = true
+	err = executeUpdateRef(updateRefCmd, []string{"refs/heads/topic", ref.ZeroOID})
+	if err == nil {
+		t.Error("expected an error deleting with a zero old value")
+	}
+	err = executeUpdateRef(updateRefCmd, []string{"refs/heads/topic", head})
+	if err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	if oid := readRefOrDie(t, "refs/heads/topic"); oid != "" {
+		t.Errorf("expected topic to be deleted but got '%s'", oid)
+	}
+}
+
+func TestUpdateRefStdin(t *testing.T) {
+	outbuf, _ := setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+	defer func() { stdin, updateRefStdin, updateRefNullTerm = nil, false, false }()
+
+	initOrDie(t)
+	writeFile(t, "1.txt", "one")
+	commitOrDie(t, "first")
+	head := readRefOrDie(t, "HEAD")
+	outbuf.Reset()
+
+	updateRefStdin = true
+	stdin = strings.NewReader("start\ncreate refs/heads/a HEAD\nupdate refs/heads/b " + head + " " + ref.ZeroOID + "\nverify refs/heads/c\nprepare\ncommit\n")
+	err := executeUpdateRef(updateRefCmd, nil)
+	if err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	if outbuf.String() != "start: ok\nprepare: ok\ncommit: ok\n" {
+		t.Errorf("unexpected output: '%s'", outbuf.String())
+	}
+	if readRefOrDie(t, "refs/heads/a") != head || readRefOrDie(t, "refs/heads/b") != head {
+		t.Error("expected both refs to be created")
+	}
+
+	// a failed check leaves every ref in the transaction alone
+	stdin = strings.NewReader("delete refs/heads/a\nverify refs/heads/b " + ref.ZeroOID + "\n")
+	err = executeUpdateRef(updateRefCmd, nil)
+	if err == nil {
+		t.Fatal("expected an error verifying a ref that exists")
+	}
+	if readRefOrDie(t, "refs/heads/a") != head {
+		t.Error("expected refs/heads/a to survive the failed transaction")
+	}
+
+	updateRefNullTerm = true
+	stdin = strings.NewReader("delete refs/heads/a\x00" + head + "\x00update refs/heads/b\x00" + head + "\x00\x00")
+	err = executeUpdateRef(updateRefCmd, nil)
+	if err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	if readRefOrDie(t, "refs/heads/a") != "" {
+		t.Error("expected refs/heads/a to be deleted")
+	}
+
+	stdin = strings.NewReader("start\x00update refs/heads/b\x00" + head + "\x00\x00")
+	err = executeUpdateRef(updateRefCmd, nil)
+	if err == nil {
+		t.Error("expected an error for a transaction that wasn't committed")
+	}
+}
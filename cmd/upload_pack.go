@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"errors"
+
+	"github.com/neocortical/got/remote"
+	"github.com/spf13/cobra"
+)
+
+var (
+	uploadPackCmd = &cobra.Command{
+		Use:   "upload-pack <directory>",
+		Short: "Serve fetches from a repository over stdin and stdout, with protocol version 2.",
+		Args:  cobra.ExactArgs(1),
+		RunE:  executeUploadPack,
+	}
+	uploadPackStatelessRPC  bool
+	uploadPackAdvertiseRefs bool
+)
+
+func init() {
+	uploadPackCmd.Flags().BoolVar(&uploadPackStatelessRPC, "stateless-rpc", false, "Serve a single exchange without advertising capabilities")
+	uploadPackCmd.Flags().BoolVar(&uploadPackAdvertiseRefs, "advertise-refs", false, "Only advertise capabilities")
+}
+
+func executeUploadPack(cmd *cobra.Command, args []string) error {
+	if !remote.ProtocolV2Requested(getenv("GIT_PROTOCOL")) {
+		return errors.New("upload-pack only speaks protocol version 2: set GIT_PROTOCOL=version=2")
+	}
+
+	repo, err := remote.OpenRepository(args[0], wd)
+	if err != nil {
+		return err
+	}
+
+	return remote.UploadPack(repo, stdin, stdout, remote.UploadPackOptions{
+		AdvertiseRefs: uploadPackAdvertiseRefs,
+		StatelessRPC:  uploadPackStatelessRPC,
+	})
+}
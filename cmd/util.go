@@ -1,11 +1,63 @@
 package cmd
 
 import (
+	"fmt"
+	"os"
 	"path"
 	"path/filepath"
 	"strings"
+	"time"
+
+	"github.com/neocortical/got/config"
+	"github.com/neocortical/got/pathspec"
+	"github.com/neocortical/got/ref"
+	"github.com/neocortical/got/repository"
 )
 
+// findWorkspace returns the root of the workspace containing dir, and dir
+// relative to it. Outside any workspace, dir is taken as the root.
+func findWorkspace(dir string) (root string, prefix string) {
+	for root = dir; ; root = filepath.Dir(root) {
+		if info, err := os.Stat(filepath.Join(root, repository.GitDir)); err == nil && info.IsDir() {
+			prefix, _ = filepath.Rel(root, dir)
+			if prefix == "." {
+				prefix = ""
+			}
+			return root, filepath.ToSlash(prefix)
+		}
+		if filepath.Dir(root) == root {
+			return dir, ""
+		}
+	}
+}
+
+// fromCurrentDir resolves a path given on the command line, which is relative
+// to the directory got runs in rather than the workspace root.
+func fromCurrentDir(p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+
+	return filepath.Join(wd, filepath.FromSlash(prefix), p)
+}
+
+// toCurrentDirPath turns a path relative to the workspace root into one
+// relative to the directory got runs in, for output. A trailing slash, as on
+// untracked directories, is kept.
+func toCurrentDirPath(p string) string {
+	if prefix == "" {
+		return p
+	}
+
+	result, _ := filepath.Rel(filepath.FromSlash(prefix), filepath.FromSlash(p))
+	result = filepath.ToSlash(result)
+	if strings.HasSuffix(p, "/") {
+		result += "/"
+	}
+
+	return result
+}
+
 func toAbsolutePath(p string) string {
 	if !isAbsolute(p) {
 		p = path.Join(wd, p)
@@ -25,3 +77,69 @@ func toRelativePath(p string) string {
 
 	return p
 }
+
+// hashJobs returns the number of workers to hash files with, from the
+// index.threads setting: true or 0 picks one per CPU,
+// false or 1 hashes serially.
+func hashJobs(repo *repository.Repo) (int, error) {
+	cfg, err := repo.Config()
+	if err != nil {
+		return 0, err
+	}
+
+	value, exists := cfg.Get("index.threads")
+	if !exists {
+		return 0, nil
+	}
+
+	if jobs, err := config.ParseInt(value); err == nil && jobs >= 0 {
+		return jobs, nil
+	}
+
+	enabled, err := config.ParseBool(value)
+	if err != nil {
+		return 0, fmt.Errorf("bad value for index.threads: '%s'", value)
+	}
+	if !enabled {
+		return 1, nil
+	}
+
+	return 0, nil
+}
+
+// parsePathspec parses pathspec arguments relative to the directory got runs
+// in, honoring the GIT_*_PATHSPECS environment variables.
+func parsePathspec(args []string) (*pathspec.Pathspec, error) {
+	envSet := func(name string) bool {
+		enabled, err := config.ParseBool(getenv(name))
+		return err == nil && enabled
+	}
+
+	opts := pathspec.Options{
+		Root:    wd,
+		Prefix:  prefix,
+		Literal: envSet("GIT_LITERAL_PATHSPECS"),
+		Glob:    envSet("GIT_GLOB_PATHSPECS"),
+		NoGlob:  envSet("GIT_NOGLOB_PATHSPECS"),
+		ICase:   envSet("GIT_ICASE_PATHSPECS"),
+	}
+	if opts.Glob && opts.NoGlob {
+		return nil, fmt.Errorf("global 'glob' and 'noglob' pathspec settings are incompatible")
+	}
+
+	return pathspec.Parse(args, opts)
+}
+
+// committerIdentity returns the identity recorded in reflogs, falling back to
+// the author when no committer is set.
+func committerIdentity() ref.Author {
+	name, email := getenv(EnvCommitterName), getenv(EnvCommitterEmail)
+	if name == "" {
+		name = getenv(EnvAuthorName)
+	}
+	if email == "" {
+		email = getenv(EnvAuthorEmail)
+	}
+
+	return ref.Author{Name: name, Email: email, Time: time.Now()}
+}
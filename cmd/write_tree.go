@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/neocortical/got/index"
+	"github.com/neocortical/got/object"
+	"github.com/neocortical/got/repository"
+	"github.com/neocortical/got/tree"
+	"github.com/spf13/cobra"
+)
+
+var writeTreeCmd = &cobra.Command{
+	Use:   "write-tree",
+	Short: "Create a tree object from the current index.",
+	Args:  cobra.NoArgs,
+	RunE:  executeWriteTree,
+}
+
+func executeWriteTree(cmd *cobra.Command, args []string) (err error) {
+	repo := repository.NewRepo(wd)
+	db := repo.Database()
+	idx := repo.Index()
+
+	err = idx.LoadForUpdate()
+	if err != nil {
+		return fmt.Errorf("error loading index: %w", err)
+	}
+
+	oid, err := storeIndexTree(db, idx)
+	if err != nil {
+		idx.Rollback()
+		return err
+	}
+
+	err = idx.WriteUpdates()
+	if err != nil {
+		idx.Rollback()
+		return fmt.Errorf("error committing the index: %w", err)
+	}
+
+	fmt.Fprintln(stdout, oid)
+	return nil
+}
+
+// storeIndexTree stores the trees described by the index, reusing the OIDs of
+// any directories whose cache tree entries are still valid.
+func storeIndexTree(db object.Database, idx index.Index) (oid string, err error) {
+	t, err := tree.BuildFromIndex(idx.Entries())
+	if err != nil {
+		return "", fmt.Errorf("error building tree: %w", err)
+	}
+
+	err = t.TraverseCached(idx.CacheTree(), func(tr *tree.Tree) (oid string, err error) {
+		oid, err = db.Store(tr)
+		return
+	})
+	if err != nil {
+		return "", fmt.Errorf("error storing tree: %w", err)
+	}
+
+	return t.OID(), nil
+}
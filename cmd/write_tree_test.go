@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/neocortical/got/repository"
+)
+
+func TestWriteTree(t *testing.T) {
+	outbuf, errbuf := setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+
+	initOrDie(t)
+	writeFile(t, "1.txt", "one")
+	writeFile(t, "a/2.txt", "two")
+	writeFile(t, "a/b/3.txt", "three")
+	writeFile(t, "c/4.txt", "four")
+
+	err := executeAdd(addCmd, []string{"."})
+	if err != nil {
+		t.Fatalf("expected no errors during add but got: %v", err)
+	}
+
+	outbuf.Reset()
+	err = executeWriteTree(writeTreeCmd, nil)
+	if err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	if errbuf.Len() > 0 {
+		t.Errorf("expected no error output but got: %s", errbuf.String())
+	}
+	expected := "92f3f207629274e31f49b082d064466beb9d56a2\n"
+	if outbuf.String() != expected {
+		t.Errorf("expected output '%s' but got: '%s'", expected, outbuf.String())
+	}
+}
+
+func TestWriteTreeReusesUnchangedSubtrees(t *testing.T) {
+	outbuf, _ := setUpTestWorkspace(t, nil)
+	defer tearDownTestWorkspace()
+
+	initOrDie(t)
+	writeFile(t, "1.txt", "one")
+	writeFile(t, "a/2.txt", "two")
+	writeFile(t, "a/b/3.txt", "three")
+	writeFile(t, "c/4.txt", "four")
+
+	err := executeAdd(addCmd, []string{"."})
+	if err != nil {
+		t.Fatalf("expected no errors during add but got: %v", err)
+	}
+	err = executeWriteTree(writeTreeCmd, nil)
+	if err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+
+	writeFile(t, "a/b/3.txt", "changed")
+	err = executeAdd(addCmd, []string{"a/b/3.txt"})
+	if err != nil {
+		t.Fatalf("expected no errors during add but got: %v", err)
+	}
+
+	idx := repository.NewRepo(wd).Index()
+	err = idx.Load()
+	if err != nil {
+		t.Fatalf("error loading index: %v", err)
+	}
+
+	ct := idx.CacheTree()
+	if ct.Valid() || ct.Subtree("a").Valid() || ct.Subtree("a").Subtree("b").Valid() {
+		t.Error("expected the cache tree to be invalidated along the changed path")
+	}
+	if c := ct.Subtree("c"); !c.Valid() || c.OID() != "556f76fbbb4108572f613df3113e821395ba629d" || c.EntryCount() != 1 {
+		t.Errorf("expected the untouched subtree to stay valid but got %+v", c)
+	}
+
+	outbuf.Reset()
+	err = executeWriteTree(writeTreeCmd, nil)
+	if err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	expected := "10f81d947874a224321b6870f5b41deaf6901b0e\n"
+	if outbuf.String() != expected {
+		t.Errorf("expected output '%s' but got: '%s'", expected, outbuf.String())
+	}
+}
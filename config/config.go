@@ -0,0 +1,318 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var errInvalidKey = errors.New("invalid config key")
+
+// Config holds the variables read from a git config file. Keys are of the form
+// section.name or section.subsection.name. Section and variable names are case
+// insensitive; subsection names are not.
+type Config struct {
+	filename  string
+	variables []variable
+}
+
+type variable struct {
+	section    string
+	subsection string
+	name       string
+	value      string
+}
+
+func (v variable) key() string {
+	if v.subsection == "" {
+		return fmt.Sprintf("%s.%s", v.section, v.name)
+	}
+	return fmt.Sprintf("%s.%s.%s", v.section, v.subsection, v.name)
+}
+
+// Load reads a config file. A missing file is treated as an empty config.
+func Load(filename string) (result *Config, err error) {
+	result = &Config{filename: filename}
+
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return result, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %w", err)
+	}
+
+	result.variables, err = parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("bad config file '%s': %w", filename, err)
+	}
+
+	return
+}
+
+func parse(data []byte) (result []variable, err error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+
+	var section, subsection string
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		// values may be continued onto the next line with a trailing backslash
+		for strings.HasSuffix(line, "\\") && !strings.HasSuffix(line, "\\\\") && scanner.Scan() {
+			line = line[:len(line)-1] + scanner.Text()
+			lineNo++
+		}
+
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		if line[0] == '[' {
+			end := strings.Index(line, "]")
+			if end == -1 {
+				return nil, fmt.Errorf("line %d: unterminated section header", lineNo)
+			}
+
+			section, subsection, err = parseSectionHeader(line[1:end])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+
+			line = strings.TrimSpace(line[end+1:])
+			if line == "" || line[0] == '#' || line[0] == ';' {
+				continue
+			}
+		}
+
+		if section == "" {
+			return nil, fmt.Errorf("line %d: variable outside of a section", lineNo)
+		}
+
+		name, value := line, "true"
+		if eq := strings.Index(line, "="); eq != -1 {
+			name = strings.TrimSpace(line[:eq])
+			value, err = parseValue(line[eq+1:])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+		}
+
+		if !isValidName(name) {
+			return nil, fmt.Errorf("line %d: invalid variable name '%s'", lineNo, name)
+		}
+
+		result = append(result, variable{section, subsection, strings.ToLower(name), value})
+	}
+
+	return result, scanner.Err()
+}
+
+func parseSectionHeader(header string) (section, subsection string, err error) {
+	header = strings.TrimSpace(header)
+
+	if quote := strings.Index(header, "\""); quote != -1 {
+		if !strings.HasSuffix(header, "\"") || quote == len(header)-1 {
+			return "", "", errors.New("invalid subsection header")
+		}
+		section = strings.TrimSpace(header[:quote])
+		subsection = strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(header[quote+1 : len(header)-1])
+	} else if dot := strings.Index(header, "."); dot != -1 {
+		// deprecated [section.subsection] syntax
+		section, subsection = header[:dot], strings.ToLower(header[dot+1:])
+	} else {
+		section = header
+	}
+
+	if !isValidName(section) {
+		return "", "", fmt.Errorf("invalid section name '%s'", section)
+	}
+
+	return strings.ToLower(section), subsection, nil
+}
+
+func parseValue(raw string) (string, error) {
+	var buf strings.Builder
+	var quoted bool
+	var pendingSpace string
+
+	for i := 0; i < len(raw); i++ {
+		c := raw[i]
+		switch {
+		case !quoted && (c == '#' || c == ';'):
+			i = len(raw)
+		case c == '"':
+			quoted = !quoted
+			buf.WriteString(pendingSpace)
+			pendingSpace = ""
+		case c == '\\':
+			i++
+			if i == len(raw) {
+				return "", errors.New("bad escape at end of value")
+			}
+			buf.WriteString(pendingSpace)
+			pendingSpace = ""
+			switch raw[i] {
+			case 'n':
+				buf.WriteByte('\n')
+			case 't':
+				buf.WriteByte('\t')
+			case 'b':
+				buf.WriteByte('\b')
+			case '\\', '"':
+				buf.WriteByte(raw[i])
+			default:
+				return "", fmt.Errorf("invalid escape sequence '\\%c'", raw[i])
+			}
+		case !quoted && (c == ' ' || c == '\t'):
+			if buf.Len() > 0 {
+				pendingSpace += string(c)
+			}
+		default:
+			buf.WriteString(pendingSpace)
+			pendingSpace = ""
+			buf.WriteByte(c)
+		}
+	}
+
+	if quoted {
+		return "", errors.New("unterminated quoted value")
+	}
+
+	return buf.String(), nil
+}
+
+func isValidName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for i, c := range name {
+		isAlpha := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+		isDigitOrDash := (c >= '0' && c <= '9') || c == '-'
+		if !isAlpha && (i == 0 || !isDigitOrDash) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func splitKey(key string) (section, subsection, name string, err error) {
+	first := strings.Index(key, ".")
+	last := strings.LastIndex(key, ".")
+	if first == -1 || last == len(key)-1 {
+		return "", "", "", errInvalidKey
+	}
+
+	section = strings.ToLower(key[:first])
+	name = strings.ToLower(key[last+1:])
+	if first != last {
+		subsection = key[first+1 : last]
+	}
+
+	return
+}
+
+// Get returns the last value set for a key.
+func (c *Config) Get(key string) (value string, exists bool) {
+	values := c.GetAll(key)
+	if len(values) == 0 {
+		return "", false
+	}
+
+	return values[len(values)-1], true
+}
+
+// GetAll returns every value set for a multi-valued key, in file order.
+func (c *Config) GetAll(key string) (result []string) {
+	section, subsection, name, err := splitKey(key)
+	if err != nil {
+		return nil
+	}
+
+	for _, v := range c.variables {
+		if v.section == section && v.subsection == subsection && v.name == name {
+			result = append(result, v.value)
+		}
+	}
+
+	return
+}
+
+// Subsections returns the names of the subsections of a section that set any
+// variables, in the order they first appear.
+func (c *Config) Subsections(section string) (result []string) {
+	section = strings.ToLower(section)
+	seen := map[string]bool{}
+
+	for _, v := range c.variables {
+		if v.section == section && v.subsection != "" && !seen[v.subsection] {
+			seen[v.subsection] = true
+			result = append(result, v.subsection)
+		}
+	}
+
+	return
+}
+
+// GetBool returns the boolean value of a key, or def if it isn't set.
+func (c *Config) GetBool(key string, def bool) (bool, error) {
+	value, exists := c.Get(key)
+	if !exists {
+		return def, nil
+	}
+
+	return ParseBool(value)
+}
+
+// GetInt returns the integer value of a key, or def if it isn't set. The k, m
+// and g suffixes scale the value by 1024, 1024^2 and 1024^3.
+func (c *Config) GetInt(key string, def int) (int, error) {
+	value, exists := c.Get(key)
+	if !exists {
+		return def, nil
+	}
+
+	result, err := ParseInt(value)
+	if err != nil {
+		return 0, fmt.Errorf("bad numeric config value '%s' for '%s': %w", value, key, err)
+	}
+
+	return result, nil
+}
+
+func ParseBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "true", "yes", "on", "1":
+		return true, nil
+	case "false", "no", "off", "0", "":
+		return false, nil
+	}
+
+	return false, fmt.Errorf("bad boolean config value '%s'", value)
+}
+
+func ParseInt(value string) (int, error) {
+	var scale = 1
+	if value != "" {
+		switch value[len(value)-1] {
+		case 'k', 'K':
+			scale = 1 << 10
+		case 'm', 'M':
+			scale = 1 << 20
+		case 'g', 'G':
+			scale = 1 << 30
+		}
+	}
+	if scale != 1 {
+		value = value[:len(value)-1]
+	}
+
+	result, err := strconv.Atoi(value)
+	return result * scale, err
+}
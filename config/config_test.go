@@ -0,0 +1,84 @@
+package config
+
+import "testing"
+
+func TestParseConfig(t *testing.T) {
+	data := []byte(`# a comment
+[core]
+	repositoryformatversion = 0
+	FileMode = true ; trailing comment
+	bare
+	editor = "vim -c \"set tw=72\""  # quoted
+[remote "origin"]
+	url = /srv/repo.git
+	fetch = +refs/heads/*:refs/remotes/origin/*
+	fetch = +refs/tags/*:refs/tags/*
+[Pack]
+	windowMemory = 10m
+`)
+
+	vars, err := parse(data)
+	if err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	c := &Config{variables: vars}
+
+	var tests = []struct {
+		key      string
+		expected string
+	}{
+		{"core.repositoryformatversion", "0"},
+		{"core.filemode", "true"},
+		{"CORE.FILEMODE", "true"},
+		{"core.bare", "true"},
+		{"core.editor", `vim -c "set tw=72"`},
+		{"remote.origin.url", "/srv/repo.git"},
+		{"remote.origin.fetch", "+refs/tags/*:refs/tags/*"},
+		{"pack.windowmemory", "10m"},
+	}
+
+	for i, test := range tests {
+		actual, exists := c.Get(test.key)
+		if !exists {
+			t.Errorf("test %d failed: expected key %s to exist", i, test.key)
+		}
+		if actual != test.expected {
+			t.Errorf("test %d failed: expected '%s' but got '%s'", i, test.expected, actual)
+		}
+	}
+
+	if _, exists := c.Get("remote.ORIGIN.url"); exists {
+		t.Error("expected subsection names to be case sensitive")
+	}
+
+	if fetch := c.GetAll("remote.origin.fetch"); len(fetch) != 2 {
+		t.Errorf("expected two fetch values but got %v", fetch)
+	}
+
+	size, err := c.GetInt("pack.windowMemory", 0)
+	if err != nil || size != 10<<20 {
+		t.Errorf("expected 10m to parse as %d but got %d (%v)", 10<<20, size, err)
+	}
+
+	bare, err := c.GetBool("core.bare", false)
+	if err != nil || !bare {
+		t.Errorf("expected core.bare to be true but got %v (%v)", bare, err)
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	var tests = []string{
+		"[core\n",
+		"name = value\n",
+		"[core]\n1name = value\n",
+		"[core]\nname = \"unterminated\n",
+		"[core]\nname = bad\\q\n",
+	}
+
+	for i, test := range tests {
+		_, err := parse([]byte(test))
+		if err == nil {
+			t.Errorf("test %d failed: expected an error parsing %q", i, test)
+		}
+	}
+}
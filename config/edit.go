@@ -0,0 +1,251 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/neocortical/got/lock"
+)
+
+// line is a logical line of a config file, which may span several physical
+// lines when continued with backslashes.
+type line struct {
+	start, end int
+
+	section    string
+	subsection string
+	// name is the variable the line sets, or "" for section headers, blank
+	// lines and comments
+	name   string
+	header bool
+}
+
+// scanLines finds the sections and variables of a config file's lines,
+// which are assumed to parse.
+func scanLines(physical []string) (result []line) {
+	var section, subsection string
+	for i := 0; i < len(physical); i++ {
+		l := line{start: i}
+		text := strings.TrimSpace(physical[i])
+		for strings.HasSuffix(text, "\\") && !strings.HasSuffix(text, "\\\\") && i+1 < len(physical) {
+			i++
+			text = text[:len(text)-1] + physical[i]
+		}
+		l.end = i
+
+		if strings.HasPrefix(text, "[") {
+			if end := strings.Index(text, "]"); end != -1 {
+				section, subsection, _ = parseSectionHeader(text[1:end])
+				l.header = true
+			}
+		} else if text != "" && text[0] != '#' && text[0] != ';' {
+			name := text
+			if eq := strings.Index(text, "="); eq != -1 {
+				name = strings.TrimSpace(text[:eq])
+			}
+			l.name = strings.ToLower(name)
+		}
+
+		l.section, l.subsection = section, subsection
+		result = append(result, l)
+	}
+
+	return
+}
+
+// edit rewrites the config file under its lock, applying fn to its lines,
+// and reloads the variables from the result.
+func (c *Config) edit(fn func(physical []string) ([]string, error)) error {
+	lf := lock.NewLockfile(c.filename)
+	if err := lf.Acquire(); err != nil {
+		return fmt.Errorf("could not lock config file: %w", err)
+	}
+
+	data, err := ioutil.ReadFile(c.filename)
+	if err != nil && !os.IsNotExist(err) {
+		lf.Rollback()
+		return fmt.Errorf("error reading config file: %w", err)
+	}
+
+	var physical []string
+	if len(data) > 0 {
+		physical = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	}
+
+	physical, err = fn(physical)
+	if err != nil {
+		lf.Rollback()
+		return err
+	}
+
+	data = []byte(strings.Join(physical, "\n") + "\n")
+	variables, err := parse(data)
+	if err != nil {
+		lf.Rollback()
+		return fmt.Errorf("bad config file '%s': %w", c.filename, err)
+	}
+
+	if err = lf.Write(data); err != nil {
+		lf.Rollback()
+		return fmt.Errorf("error writing config file: %w", err)
+	}
+	if err = lf.Commit(); err != nil {
+		return fmt.Errorf("error writing config file: %w", err)
+	}
+
+	c.variables = variables
+	return nil
+}
+
+// Set sets a key in the config file, replacing its last value if it has any.
+func (c *Config) Set(key string, value string) error {
+	section, subsection, name, err := splitKey(key)
+	if err != nil || !isValidName(name) {
+		return fmt.Errorf("%w: '%s'", errInvalidKey, key)
+	}
+
+	return c.edit(func(physical []string) ([]string, error) {
+		lines := scanLines(physical)
+		for i := len(lines) - 1; i >= 0; i-- {
+			l := lines[i]
+			if !l.header && l.section == section && l.subsection == subsection && l.name == name {
+				replaced := append([]string(nil), physical[:l.start]...)
+				replaced = append(replaced, formatVariable(name, value))
+				return append(replaced, physical[l.end+1:]...), nil
+			}
+		}
+
+		return addVariable(physical, section, subsection, name, value), nil
+	})
+}
+
+// Add adds a value for a key to the config file, keeping any it already has.
+func (c *Config) Add(key string, value string) error {
+	section, subsection, name, err := splitKey(key)
+	if err != nil || !isValidName(name) {
+		return fmt.Errorf("%w: '%s'", errInvalidKey, key)
+	}
+
+	return c.edit(func(physical []string) ([]string, error) {
+		return addVariable(physical, section, subsection, name, value), nil
+	})
+}
+
+// Unset removes every value of a key from the config file. It's not an error
+// for the key not to be set.
+func (c *Config) Unset(key string) error {
+	section, subsection, name, err := splitKey(key)
+	if err != nil || !isValidName(name) {
+		return fmt.Errorf("%w: '%s'", errInvalidKey, key)
+	}
+
+	return c.edit(func(physical []string) ([]string, error) {
+		return removeLines(physical, func(l line) bool {
+			return !l.header && l.section == section && l.subsection == subsection && l.name == name
+		}), nil
+	})
+}
+
+// RemoveSection removes a section, given as section or section.subsection,
+// with all of its variables.
+func (c *Config) RemoveSection(name string) error {
+	section, subsection := splitSectionName(name)
+
+	return c.edit(func(physical []string) ([]string, error) {
+		result := removeLines(physical, func(l line) bool {
+			return l.section == section && l.subsection == subsection
+		})
+		if len(result) == len(physical) {
+			return nil, fmt.Errorf("no such section: %s", name)
+		}
+		return result, nil
+	})
+}
+
+// RenameSection renames a section, given as section or section.subsection,
+// keeping its variables.
+func (c *Config) RenameSection(oldName string, newName string) error {
+	section, subsection := splitSectionName(oldName)
+	newSection, newSubsection := splitSectionName(newName)
+	if !isValidName(newSection) {
+		return fmt.Errorf("invalid section name: %s", newName)
+	}
+
+	return c.edit(func(physical []string) ([]string, error) {
+		result := append([]string(nil), physical...)
+		found := false
+		for _, l := range scanLines(physical) {
+			if l.header && l.section == section && l.subsection == subsection {
+				result[l.start] = formatSectionHeader(newSection, newSubsection)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no such section: %s", oldName)
+		}
+		return result, nil
+	})
+}
+
+func splitSectionName(name string) (section, subsection string) {
+	if dot := strings.Index(name, "."); dot != -1 {
+		return strings.ToLower(name[:dot]), name[dot+1:]
+	}
+
+	return strings.ToLower(name), ""
+}
+
+// removeLines drops the physical lines of every logical line matched.
+func removeLines(physical []string, match func(l line) bool) (result []string) {
+	for _, l := range scanLines(physical) {
+		if !match(l) {
+			result = append(result, physical[l.start:l.end+1]...)
+		}
+	}
+
+	return
+}
+
+// addVariable adds a variable at the end of the last block of its section,
+// or in a new section at the end of the file.
+func addVariable(physical []string, section, subsection, name, value string) []string {
+	lines := scanLines(physical)
+
+	for i := len(lines) - 1; i >= 0; i-- {
+		l := lines[i]
+		if l.section != section || l.subsection != subsection || (!l.header && l.name == "") {
+			continue
+		}
+
+		result := append([]string(nil), physical[:l.end+1]...)
+		result = append(result, formatVariable(name, value))
+		return append(result, physical[l.end+1:]...)
+	}
+
+	return append(physical, formatSectionHeader(section, subsection), formatVariable(name, value))
+}
+
+func formatSectionHeader(section, subsection string) string {
+	if subsection == "" {
+		return "[" + section + "]"
+	}
+
+	return fmt.Sprintf("[%s \"%s\"]", section, strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(subsection))
+}
+
+func formatVariable(name, value string) string {
+	return fmt.Sprintf("\t%s = %s", name, formatValue(value))
+}
+
+// formatValue escapes a value, quoting it if it has leading or trailing
+// whitespace or comment characters.
+func formatValue(value string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`, "\b", `\b`).Replace(value)
+	if value != strings.TrimSpace(value) || strings.ContainsAny(value, "#;") {
+		return `"` + escaped + `"`
+	}
+
+	return escaped
+}
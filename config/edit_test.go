@@ -0,0 +1,155 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSetAndAdd(t *testing.T) {
+	dir, err := ioutil.TempDir("", "got_test_config_*")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "config")
+	ioutil.WriteFile(filename, []byte(`# keep me
+[core]
+	bare = false
+	editor = vi
+
+[user]
+	name = Nathan
+`), 0644)
+
+	c, err := Load(filename)
+	if err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+
+	steps := []func() error{
+		func() error { return c.Set("core.editor", "vim -c \"set tw=72\"") },
+		func() error { return c.Set("core.filemode", "true") },
+		func() error { return c.Set("remote.origin.url", "/srv/repo.git") },
+		func() error { return c.Add("remote.origin.fetch", "+refs/heads/*:refs/remotes/origin/*") },
+		func() error { return c.Add("remote.origin.fetch", "+refs/tags/*:refs/tags/*") },
+		func() error { return c.Set("user.email", " padded # value") },
+	}
+	for i, step := range steps {
+		if err = step(); err != nil {
+			t.Fatalf("step %d: expected no error but got: %v", i, err)
+		}
+	}
+
+	expected := `# keep me
+[core]
+	bare = false
+	editor = vim -c \"set tw=72\"
+	filemode = true
+
+[user]
+	name = Nathan
+	email = " padded # value"
+[remote "origin"]
+	url = /srv/repo.git
+	fetch = +refs/heads/*:refs/remotes/origin/*
+	fetch = +refs/tags/*:refs/tags/*
+`
+	data, _ := ioutil.ReadFile(filename)
+	if string(data) != expected {
+		t.Errorf("expected config:\n%s\nbut got:\n%s", expected, data)
+	}
+
+	reloaded, err := Load(filename)
+	if err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	for _, c := range []*Config{c, reloaded} {
+		if value, _ := c.Get("core.editor"); value != `vim -c "set tw=72"` {
+			t.Errorf("unexpected core.editor: '%s'", value)
+		}
+		if value, _ := c.Get("user.email"); value != " padded # value" {
+			t.Errorf("unexpected user.email: '%s'", value)
+		}
+		if values := c.GetAll("remote.origin.fetch"); len(values) != 2 {
+			t.Errorf("expected two fetch refspecs but got: %v", values)
+		}
+	}
+
+	if err = c.Set("core", "x"); err == nil {
+		t.Error("expected an error for an invalid key")
+	}
+}
+
+func TestUnsetAndSections(t *testing.T) {
+	dir, err := ioutil.TempDir("", "got_test_config_*")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "config")
+	ioutil.WriteFile(filename, []byte(`[core]
+	bare = false
+[remote "origin"]
+	url = /srv/repo.git
+	fetch = +refs/heads/*:refs/remotes/origin/*
+[branch "master"]
+	remote = origin
+	merge = refs/heads/master
+[remote "upstream"]
+	url = /srv/upstream.git
+[remote "origin"]
+	# a comment in the section
+	pushurl = /srv/push.git
+`), 0644)
+
+	c, err := Load(filename)
+	if err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+
+	if names := c.Subsections("remote"); len(names) != 2 || names[0] != "origin" || names[1] != "upstream" {
+		t.Errorf("unexpected remotes: %v", names)
+	}
+
+	steps := []func() error{
+		func() error { return c.RenameSection("remote.origin", "remote.old") },
+		func() error { return c.Unset("branch.master.remote") },
+		func() error { return c.Unset("core.missing") },
+		func() error { return c.RemoveSection("remote.upstream") },
+	}
+	for i, step := range steps {
+		if err = step(); err != nil {
+			t.Fatalf("step %d: expected no error but got: %v", i, err)
+		}
+	}
+
+	expected := `[core]
+	bare = false
+[remote "old"]
+	url = /srv/repo.git
+	fetch = +refs/heads/*:refs/remotes/origin/*
+[branch "master"]
+	merge = refs/heads/master
+[remote "old"]
+	# a comment in the section
+	pushurl = /srv/push.git
+`
+	data, _ := ioutil.ReadFile(filename)
+	if string(data) != expected {
+		t.Errorf("expected config:\n%s\nbut got:\n%s", expected, data)
+	}
+	if value, _ := c.Get("remote.old.pushurl"); value != "/srv/push.git" {
+		t.Errorf("unexpected remote.old.pushurl: '%s'", value)
+	}
+
+	if err = c.RemoveSection("remote.upstream"); err == nil {
+		t.Error("expected an error removing a missing section")
+	}
+	if err = c.RenameSection("remote.upstream", "remote.other"); err == nil {
+		t.Error("expected an error renaming a missing section")
+	}
+}
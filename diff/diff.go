@@ -0,0 +1,251 @@
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Op is what an edit does with a line.
+type Op int
+
+const (
+	Equal Op = iota
+	Delete
+	Insert
+)
+
+// Edit is a line kept from a, deleted from a or inserted from b. Lines keep
+// their line endings, so a last line without one differs from the same text
+// with one, as it does for git.
+type Edit struct {
+	Op   Op
+	Line string
+}
+
+const noNewline = "\\ No newline at end of file\n"
+
+// Lines splits text into lines, each keeping its line ending.
+func Lines(data []byte) []string {
+	var result []string
+	for len(data) > 0 {
+		end := bytes.IndexByte(data, '\n') + 1
+		if end == 0 {
+			end = len(data)
+		}
+		result = append(result, string(data[:end]))
+		data = data[end:]
+	}
+
+	return result
+}
+
+// Diff returns the shortest edit script turning a into b, found with
+// Myers' algorithm. Deletions come before insertions where they're
+// interchangeable.
+func Diff(a, b []string) []Edit {
+	// skip the common prefix and suffix, which is most of the lines of a
+	// typical change
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var result []Edit
+	for _, line := range a[:prefix] {
+		result = append(result, Edit{Equal, line})
+	}
+	result = append(result, myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		result = append(result, Edit{Equal, line})
+	}
+
+	return result
+}
+
+func myers(a, b []string) []Edit {
+	n, m := len(a), len(b)
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*max+3)
+
+	// trace keeps v as it was before each round, to walk back through
+	var trace [][]int
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrack(a, b, trace, offset)
+			}
+		}
+	}
+
+	return nil
+}
+
+func backtrack(a, b []string, trace [][]int, offset int) []Edit {
+	var reversed []Edit
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+
+		var prevK int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			x, y = x-1, y-1
+			reversed = append(reversed, Edit{Equal, a[x]})
+		}
+		if d == 0 {
+			break
+		}
+		if x == prevX {
+			y--
+			reversed = append(reversed, Edit{Insert, b[y]})
+		} else {
+			x--
+			reversed = append(reversed, Edit{Delete, a[x]})
+		}
+	}
+
+	result := make([]Edit, len(reversed))
+	for i, e := range reversed {
+		result[len(reversed)-1-i] = e
+	}
+
+	return result
+}
+
+// Stat counts the lines an edit script inserts and deletes.
+func Stat(edits []Edit) (insertions int, deletions int) {
+	for _, e := range edits {
+		switch e.Op {
+		case Insert:
+			insertions++
+		case Delete:
+			deletions++
+		}
+	}
+
+	return
+}
+
+// Unified writes the hunks of a unified diff of an edit script, each with up
+// to context lines around its changes.
+func Unified(w io.Writer, edits []Edit, context int) error {
+	for i := 0; i < len(edits); {
+		for i < len(edits) && edits[i].Op == Equal {
+			i++
+		}
+		if i == len(edits) {
+			break
+		}
+
+		// a hunk runs on through unchanged lines until they're too many
+		// to share as context with the next change
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for {
+			for end < len(edits) && edits[end].Op != Equal {
+				end++
+			}
+			equal := 0
+			for end+equal < len(edits) && edits[end+equal].Op == Equal {
+				equal++
+			}
+			if end+equal == len(edits) || equal > 2*context {
+				i = end + equal
+				end += min(equal, context)
+				break
+			}
+			end += equal
+		}
+
+		if err := writeHunk(w, edits, start, end); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func min(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func writeHunk(w io.Writer, edits []Edit, start int, end int) error {
+	aStart, bStart := 0, 0
+	for _, e := range edits[:start] {
+		if e.Op != Insert {
+			aStart++
+		}
+		if e.Op != Delete {
+			bStart++
+		}
+	}
+	aLen, bLen := 0, 0
+	var body strings.Builder
+	for _, e := range edits[start:end] {
+		prefix := " "
+		switch e.Op {
+		case Delete:
+			prefix = "-"
+			aLen++
+		case Insert:
+			prefix = "+"
+			bLen++
+		default:
+			aLen++
+			bLen++
+		}
+		body.WriteString(prefix + e.Line)
+		if !strings.HasSuffix(e.Line, "\n") {
+			body.WriteString("\n" + noNewline)
+		}
+	}
+
+	_, err := fmt.Fprintf(w, "@@ -%s +%s @@\n%s", hunkRange(aStart, aLen), hunkRange(bStart, bLen), body.String())
+	return err
+}
+
+// hunkRange formats the lines a hunk covers on one side: the first line and
+// the count, left out when it's one, or the line before an empty range.
+func hunkRange(start int, length int) string {
+	switch length {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	}
+
+	return fmt.Sprintf("%d,%d", start+1, length)
+}
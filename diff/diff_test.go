@@ -0,0 +1,89 @@
+package diff
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	var tests = []struct {
+		a, b     string
+		expected string
+	}{
+		{"", "", ""},
+		{"a\nb\n", "a\nb\n", " a\n b\n"},
+		{"", "a\n", "+a\n"},
+		{"a\n", "", "-a\n"},
+		{"a\nb\nc\n", "a\nx\nc\n", " a\n-b\n+x\n c\n"},
+		{"a\nb\nc\nd\n", "b\nc\ne\nd\n", "-a\n b\n c\n+e\n d\n"},
+		{"a\nb", "a\nb\n", " a\n-b+b\n"},
+	}
+
+	for i, test := range tests {
+		var actual strings.Builder
+		for _, e := range Diff(Lines([]byte(test.a)), Lines([]byte(test.b))) {
+			actual.WriteString([]string{" ", "-", "+"}[e.Op] + e.Line)
+		}
+		if actual.String() != test.expected {
+			t.Errorf("test %d failed: Diff(%q, %q) expected %q but got %q", i, test.a, test.b, test.expected, actual.String())
+		}
+	}
+}
+
+func TestUnified(t *testing.T) {
+	a := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n"
+	var tests = []struct {
+		b        string
+		expected string
+	}{
+		{a, ""},
+		{"1\n2\n3\n4\nfive\n6\n7\n8\n9\n10\n11\n12\n", "@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n"},
+		// changes far enough apart get hunks of their own
+		{"one\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\ntwelve\n", "@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+twelve\n"},
+		// and ones closer together share one
+		{"1\n2\nthree\n4\n5\n6\n7\n8\nnine\n10\n11\n12\n", "@@ -1,12 +1,12 @@\n 1\n 2\n-3\n+three\n 4\n 5\n 6\n 7\n 8\n-9\n+nine\n 10\n 11\n 12\n"},
+		{"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12", "@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+12\n\\ No newline at end of file\n"},
+	}
+
+	for i, test := range tests {
+		var actual bytes.Buffer
+		if err := Unified(&actual, Diff(Lines([]byte(a)), Lines([]byte(test.b))), 3); err != nil {
+			t.Fatalf("test %d failed: %v", i, err)
+		}
+		if actual.String() != test.expected {
+			t.Errorf("test %d failed: expected %q but got %q", i, test.expected, actual.String())
+		}
+	}
+
+	var actual bytes.Buffer
+	Unified(&actual, Diff(nil, Lines([]byte("new\n"))), 3)
+	if expected := "@@ -0,0 +1 @@\n+new\n"; actual.String() != expected {
+		t.Errorf("expected %q for a new file but got %q", expected, actual.String())
+	}
+}
+
+func TestMerge3(t *testing.T) {
+	base := "1\n2\n3\n4\n5\n6\n7\n"
+	var tests = []struct {
+		ours, theirs string
+		expected     string
+		conflicts    int
+	}{
+		{base, base, base, 0},
+		{"one\n2\n3\n4\n5\n6\n7\n", "1\n2\n3\n4\n5\n6\nseven\n", "one\n2\n3\n4\n5\n6\nseven\n", 0},
+		{"1\n2\nthree\n4\n5\n6\n7\n", "1\n2\nthree\n4\n5\n6\n7\n", "1\n2\nthree\n4\n5\n6\n7\n", 0},
+		{"1\n2\n3\n4\n5\n6\n7\n8\n", "0\n1\n2\n3\n4\n5\n6\n7\n", "0\n1\n2\n3\n4\n5\n6\n7\n8\n", 0},
+		{"1\n2\n3\nfour\n5\n6\n7\n", "1\n2\n3\nFOUR\n5\n6\n7\n", "1\n2\n3\n<<<<<<< ours\nfour\n=======\nFOUR\n>>>>>>> theirs\n5\n6\n7\n", 1},
+		// neighbouring changes conflict, less the lines both sides agree on
+		{"1\n2\nthree\nfour\n5\n6\n7\n", "1\n2\n3\nfour\nfive\n6\n7\n", "1\n2\n<<<<<<< ours\nthree\nfour\n5\n=======\n3\nfour\nfive\n>>>>>>> theirs\n6\n7\n", 1},
+		{"1\n2\n3\n4\n5\n6\n7\nours", "1\n2\n3\n4\n5\n6\n7\ntheirs", "1\n2\n3\n4\n5\n6\n7\n<<<<<<< ours\nours\n=======\ntheirs\n>>>>>>> theirs\n", 1},
+	}
+
+	for i, test := range tests {
+		result, conflicts := Merge3(Lines([]byte(base)), Lines([]byte(test.ours)), Lines([]byte(test.theirs)), "ours", "theirs")
+		if actual := strings.Join(result, ""); actual != test.expected || conflicts != test.conflicts {
+			t.Errorf("test %d failed: expected %q with %d conflicts but got %q with %d", i, test.expected, test.conflicts, actual, conflicts)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package diff
+
+import "strings"
+
+// chunk is a change to the lines [start, end) of the base, replaced by lines.
+type chunk struct {
+	start int
+	end   int
+	lines []string
+}
+
+func chunks(edits []Edit) (result []chunk) {
+	var current *chunk
+	position := 0
+	for _, e := range edits {
+		if e.Op == Equal {
+			if current != nil {
+				result = append(result, *current)
+				current = nil
+			}
+			position++
+			continue
+		}
+
+		if current == nil {
+			current = &chunk{start: position, end: position}
+		}
+		if e.Op == Delete {
+			position++
+			current.end = position
+		} else {
+			current.lines = append(current.lines, e.Line)
+		}
+	}
+	if current != nil {
+		result = append(result, *current)
+	}
+
+	return
+}
+
+// Merge3 merges the changes ours and theirs each made to base. Where they
+// change the same or neighbouring lines differently, the result holds both
+// versions between conflict markers labelled with oursLabel and theirsLabel.
+// It returns the merged lines and the number of conflicts.
+func Merge3(base, ours, theirs []string, oursLabel string, theirsLabel string) (result []string, conflicts int) {
+	oursChunks := chunks(Diff(base, ours))
+	theirsChunks := chunks(Diff(base, theirs))
+
+	position := 0
+	for len(oursChunks) > 0 || len(theirsChunks) > 0 {
+		// take the first change, and every change on either side that
+		// overlaps or touches it, as a region of the base
+		var oursRegion, theirsRegion []chunk
+		var start, end int
+		take := func(from *[]chunk, region *[]chunk) {
+			c := (*from)[0]
+			*from = (*from)[1:]
+			if len(oursRegion)+len(theirsRegion) == 0 || c.start < start {
+				start = c.start
+			}
+			if c.end > end {
+				end = c.end
+			}
+			*region = append(*region, c)
+		}
+
+		if len(theirsChunks) == 0 || (len(oursChunks) > 0 && oursChunks[0].start <= theirsChunks[0].start) {
+			take(&oursChunks, &oursRegion)
+		} else {
+			take(&theirsChunks, &theirsRegion)
+		}
+		for {
+			if len(oursChunks) > 0 && oursChunks[0].start <= end {
+				take(&oursChunks, &oursRegion)
+			} else if len(theirsChunks) > 0 && theirsChunks[0].start <= end {
+				take(&theirsChunks, &theirsRegion)
+			} else {
+				break
+			}
+		}
+
+		result = append(result, base[position:start]...)
+		position = end
+
+		oursLines := apply(base, start, end, oursRegion)
+		theirsLines := apply(base, start, end, theirsRegion)
+		switch {
+		case len(theirsRegion) == 0:
+			result = append(result, oursLines...)
+		case len(oursRegion) == 0 || equal(oursLines, theirsLines):
+			result = append(result, theirsLines...)
+		default:
+			result = append(result, conflict(oursLines, theirsLines, oursLabel, theirsLabel)...)
+			conflicts++
+		}
+	}
+	result = append(result, base[position:]...)
+
+	return result, conflicts
+}
+
+// apply returns the lines [start, end) of base with the changes in chunks made.
+func apply(base []string, start int, end int, chunks []chunk) (result []string) {
+	position := start
+	for _, c := range chunks {
+		result = append(result, base[position:c.start]...)
+		result = append(result, c.lines...)
+		position = c.end
+	}
+
+	return append(result, base[position:end]...)
+}
+
+// conflict returns both sides of a conflict between markers, leaving out the
+// lines they begin and end with in common.
+func conflict(ours []string, theirs []string, oursLabel string, theirsLabel string) (result []string) {
+	prefix := 0
+	for prefix < len(ours) && prefix < len(theirs) && ours[prefix] == theirs[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(ours)-prefix && suffix < len(theirs)-prefix && ours[len(ours)-1-suffix] == theirs[len(theirs)-1-suffix] {
+		suffix++
+	}
+
+	result = append(result, ours[:prefix]...)
+	result = append(result, "<<<<<<< "+oursLabel+"\n")
+	result = append(result, terminated(ours[prefix:len(ours)-suffix])...)
+	result = append(result, "=======\n")
+	result = append(result, terminated(theirs[prefix:len(theirs)-suffix])...)
+	result = append(result, ">>>>>>> "+theirsLabel+"\n")
+
+	return append(result, ours[len(ours)-suffix:]...)
+}
+
+// terminated makes sure the last line ends with a newline, so a marker can
+// follow it.
+func terminated(lines []string) []string {
+	if len(lines) == 0 || strings.HasSuffix(lines[len(lines)-1], "\n") {
+		return lines
+	}
+
+	result := append([]string(nil), lines...)
+	result[len(result)-1] += "\n"
+	return result
+}
+
+func equal(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,18 @@
+// Package faults marks the points in write paths where a crash would leave
+// the repository in an intermediate state, so tests can simulate one there.
+package faults
+
+var hook func(point string)
+
+// SetHook installs a function called with the name of every fault point
+// reached. Passing nil removes it.
+func SetHook(h func(point string)) {
+	hook = h
+}
+
+// Point marks a place where the process could crash.
+func Point(name string) {
+	if hook != nil {
+		hook(name)
+	}
+}
@@ -0,0 +1,466 @@
+package fsck
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/neocortical/got/object"
+	"github.com/neocortical/got/objectformat"
+	"github.com/neocortical/got/repository"
+)
+
+const (
+	databaseDir = "objects"
+	headName    = "HEAD"
+)
+
+// Options controls which refs count as reachability roots.
+type Options struct {
+	// NoReflogs leaves reflog entries out of the roots, so objects only a
+	// reflog refers to are reported as unreachable.
+	NoReflogs bool
+}
+
+// Object is an object ID and its type.
+type Object struct {
+	OID  string
+	Type string
+}
+
+// Report is the result of checking a repository. Errors and Warnings are
+// complete messages in git's format; object lists are sorted by ID.
+type Report struct {
+	Errors   []string
+	Warnings []string
+
+	// Missing objects are referred to but not in the database.
+	Missing []Object
+	// Unreachable objects exist but can't be reached from any root.
+	Unreachable []Object
+	// Dangling objects are unreachable objects that no other unreachable
+	// object refers to: the tips of lost history.
+	Dangling []Object
+}
+
+// OK reports whether the repository has no errors. Warnings, and unreachable
+// objects, aren't errors.
+func (r *Report) OK() bool {
+	return len(r.Errors) == 0 && len(r.Missing) == 0
+}
+
+type checker struct {
+	repo   *repository.Repo
+	report *Report
+
+	types   map[string]string
+	links   map[string][]Link
+	roots   []string
+	missing map[string]string
+
+	// examined holds every object of the repository's own checked so far,
+	// intact or not
+	examined map[string]bool
+	borrowed map[string]bool
+	format   *objectformat.Format
+
+	// shallow commits' parents were left out of the repository
+	shallow map[string]bool
+	// promised objects were left out of a partial clone, to be fetched when
+	// they're needed
+	promised map[string]bool
+}
+
+// Check verifies every object in the repository, then walks everything
+// reachable from HEAD, refs, reflogs and the index.
+func Check(repo *repository.Repo, opts Options) (*Report, error) {
+	c := &checker{
+		repo:    repo,
+		report:  &Report{},
+		types:   map[string]string{},
+		links:   map[string][]Link{},
+		missing: map[string]string{},
+
+		examined: map[string]bool{},
+		borrowed: map[string]bool{},
+		format:   repo.ObjectFormat(),
+	}
+
+	shallow, err := repo.Shallow()
+	if err != nil {
+		return nil, err
+	}
+	c.shallow = shallow
+	if c.promised, err = repo.PromisorObjects(); err != nil {
+		return nil, err
+	}
+
+	objectsDir := path.Join(repo.Dir(), databaseDir)
+	if err := c.checkLooseObjects(objectsDir); err != nil {
+		return nil, err
+	}
+	c.checkPacks(objectsDir)
+	if err := c.checkDatabase(); err != nil {
+		return nil, err
+	}
+
+	if err := c.findRoots(opts); err != nil {
+		return nil, err
+	}
+	c.checkConnectivity()
+
+	return c.report, nil
+}
+
+func (c *checker) errorf(format string, args ...interface{}) {
+	c.report.Errors = append(c.report.Errors, "error: "+fmt.Sprintf(format, args...))
+}
+
+func (c *checker) checkLooseObjects(objectsDir string) error {
+	fanouts, err := ioutil.ReadDir(objectsDir)
+	if err != nil {
+		return fmt.Errorf("error reading object database: %w", err)
+	}
+
+	for _, fanout := range fanouts {
+		if !fanout.IsDir() || len(fanout.Name()) != 2 {
+			continue
+		}
+
+		files, err := ioutil.ReadDir(path.Join(objectsDir, fanout.Name()))
+		if err != nil {
+			return fmt.Errorf("error reading object database: %w", err)
+		}
+
+		for _, file := range files {
+			oid := fanout.Name() + file.Name()
+			rel := path.Join(databaseDir, fanout.Name(), file.Name())
+			if !c.format.IsOID(oid) {
+				c.report.Warnings = append(c.report.Warnings, "warning: garbage found: "+rel)
+				continue
+			}
+
+			c.examined[oid] = true
+
+			data, err := ioutil.ReadFile(path.Join(objectsDir, fanout.Name(), file.Name()))
+			if err != nil {
+				return fmt.Errorf("error reading %s: %w", rel, err)
+			}
+
+			obj, err := object.ParseLoose(data)
+			if err != nil {
+				c.errorf("%s: object corrupt or missing: %s: %v", oid, rel, err)
+				continue
+			}
+			if actual := object.HashObject(c.format, obj); actual != oid {
+				c.errorf("hash mismatch for %s (expected %s, but it hashes to %s)", rel, oid, actual)
+				continue
+			}
+
+			c.checkObject(oid, obj)
+		}
+	}
+
+	return nil
+}
+
+func (c *checker) checkPacks(objectsDir string) {
+	packs, err := object.OpenPacks(objectsDir, c.format)
+	if err != nil {
+		c.errorf("%v", err)
+		return
+	}
+
+	for _, p := range packs {
+		if err = p.Verify(); err != nil {
+			c.errorf("%v", err)
+		}
+
+		for _, oid := range p.OIDs() {
+			if _, seen := c.types[oid]; seen {
+				continue
+			}
+			c.examined[oid] = true
+
+			obj, err := p.VerifyObject(oid)
+			if err != nil {
+				c.errorf("%s: object corrupt or missing: %v", oid, err)
+				continue
+			}
+
+			c.checkObject(oid, obj)
+		}
+	}
+}
+
+// checkDatabase checks the objects the repository's own database holds
+// outside of loose files and packs, such as those in other storage backends.
+func (c *checker) checkDatabase() error {
+	db := c.repo.OwnDatabase()
+
+	oids, err := db.OIDs()
+	if err != nil {
+		return fmt.Errorf("error listing objects: %w", err)
+	}
+
+	for _, oid := range oids {
+		if c.examined[oid] {
+			continue
+		}
+		c.examined[oid] = true
+
+		obj, err := db.Read(oid)
+		if err != nil {
+			c.errorf("%s: object corrupt or missing: %v", oid, err)
+			continue
+		}
+		if actual := object.HashObject(c.format, obj); actual != oid {
+			c.errorf("hash mismatch for %s (it hashes to %s)", oid, actual)
+			continue
+		}
+
+		c.checkObject(oid, obj)
+	}
+
+	return nil
+}
+
+func (c *checker) checkObject(oid string, obj object.Storable) {
+	links, problems := CheckObject(c.format, obj.Type(), obj.Serialize())
+
+	c.types[oid] = obj.Type()
+	c.links[oid] = links
+
+	for _, p := range problems {
+		if p.Warning {
+			c.report.Warnings = append(c.report.Warnings, fmt.Sprintf("warning in %s %s: %s", obj.Type(), oid, p))
+		} else {
+			c.report.Errors = append(c.report.Errors, fmt.Sprintf("error in %s %s: %s", obj.Type(), oid, p))
+		}
+	}
+}
+
+// findRoots collects the objects named by HEAD, refs, reflogs and the index,
+// reporting any that point at missing objects.
+func (c *checker) findRoots(opts Options) error {
+	refs := c.repo.Refs()
+
+	head, err := refs.ReadHead()
+	if err != nil {
+		c.errorf("invalid HEAD: %v", err)
+	}
+	c.addRoot(headName, head)
+
+	list, err := refs.List("refs/")
+	if err != nil {
+		return fmt.Errorf("error listing refs: %w", err)
+	}
+	for _, r := range list {
+		c.addRoot(r.Name, r.OID)
+	}
+
+	if !opts.NoReflogs {
+		names, err := refs.ReflogNames()
+		if err != nil {
+			return fmt.Errorf("error listing reflogs: %w", err)
+		}
+		for _, name := range names {
+			entries, err := refs.Reflog(name)
+			if err != nil {
+				c.errorf("%s: reflog is corrupt: %v", name, err)
+				continue
+			}
+			for _, entry := range entries {
+				c.addReflogRoot(name, entry.OldOID)
+				c.addReflogRoot(name, entry.NewOID)
+			}
+		}
+	}
+
+	idx := c.repo.Index()
+	if err = idx.Load(); err != nil {
+		c.errorf("index is corrupt: %v", err)
+		return nil
+	}
+	for _, entry := range idx.AllEntries() {
+		if entry.IntentToAdd() || entry.ModeString() == modeGitlink {
+			continue
+		}
+		if !c.exists(entry.OID()) {
+			c.missing[entry.OID()] = typeBlob
+			continue
+		}
+		c.roots = append(c.roots, entry.OID())
+	}
+	if ct := idx.CacheTree(); ct != nil && ct.Valid() {
+		c.addRoot("cache-tree", ct.OID())
+	}
+
+	return nil
+}
+
+// exists reports whether an object is in the repository, borrowing it from
+// an alternate if it isn't the repository's own.
+func (c *checker) exists(oid string) bool {
+	if _, exists := c.types[oid]; exists {
+		return true
+	}
+	if c.examined[oid] {
+		// ours, but corrupt
+		return false
+	}
+
+	return c.borrow(oid)
+}
+
+// borrow reads an object from the stores the repository reads through to,
+// so that connectivity can be followed through it. Borrowed objects are
+// only checked as far as that needs: their integrity is up to the
+// repositories they belong to, and they're never reported as unreachable.
+func (c *checker) borrow(oid string) bool {
+	obj, err := c.repo.Database().Read(oid)
+	if err != nil || object.HashObject(c.format, obj) != oid {
+		return false
+	}
+
+	links, _ := CheckObject(c.format, obj.Type(), obj.Serialize())
+	c.types[oid] = obj.Type()
+	c.links[oid] = links
+	c.borrowed[oid] = true
+
+	return true
+}
+
+func (c *checker) addRoot(name string, oid string) {
+	if oid == "" {
+		return
+	}
+	if !c.exists(oid) {
+		c.errorf("%s: invalid sha1 pointer %s", name, oid)
+		return
+	}
+
+	c.roots = append(c.roots, oid)
+}
+
+func (c *checker) addReflogRoot(name string, oid string) {
+	if oid == "" || objectformat.IsZeroOID(oid) {
+		return
+	}
+	if !c.exists(oid) {
+		c.errorf("%s: invalid reflog entry %s", name, oid)
+		return
+	}
+
+	c.roots = append(c.roots, oid)
+}
+
+// checkConnectivity marks everything reachable from the roots, reporting
+// missing and mistyped objects along the way, and then sorts what's left
+// into unreachable and dangling objects.
+func (c *checker) checkConnectivity() {
+	reachable := map[string]bool{}
+
+	queue := append([]string(nil), c.roots...)
+	for len(queue) > 0 {
+		oid := queue[0]
+		queue = queue[1:]
+		if reachable[oid] {
+			continue
+		}
+		reachable[oid] = true
+
+		for _, link := range c.links[oid] {
+			if c.shallow[oid] && link.Type == typeCommit {
+				continue
+			}
+			if _, ours := c.types[link.OID]; c.promised[link.OID] && !ours {
+				// not fetched just to check it
+				continue
+			}
+
+			exists := c.exists(link.OID)
+			actualType := c.types[link.OID]
+			switch {
+			case !exists:
+				c.missing[link.OID] = link.Type
+			case actualType != link.Type:
+				c.errorf("object %s is a %s, not a %s", link.OID, actualType, link.Type)
+			default:
+				queue = append(queue, link.OID)
+			}
+		}
+	}
+
+	for oid, objectType := range c.missing {
+		c.report.Missing = append(c.report.Missing, Object{OID: oid, Type: objectType})
+	}
+	sortObjects(c.report.Missing)
+
+	// an unreachable object is dangling unless another unreachable object
+	// refers to it
+	referenced := map[string]bool{}
+	for oid := range c.types {
+		if reachable[oid] || c.borrowed[oid] {
+			continue
+		}
+		for _, link := range c.links[oid] {
+			referenced[link.OID] = true
+		}
+	}
+
+	for oid, objectType := range c.types {
+		if reachable[oid] || c.borrowed[oid] {
+			continue
+		}
+		c.report.Unreachable = append(c.report.Unreachable, Object{OID: oid, Type: objectType})
+		if !referenced[oid] {
+			c.report.Dangling = append(c.report.Dangling, Object{OID: oid, Type: objectType})
+		}
+	}
+	sortObjects(c.report.Unreachable)
+	sortObjects(c.report.Dangling)
+}
+
+func sortObjects(objects []Object) {
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].OID < objects[j].OID
+	})
+}
+
+// WriteLostFound saves dangling objects under lost-found in the git
+// directory: commits in lost-found/commit, anything else in lost-found/other.
+// Blobs are written out as their contents; other objects as their IDs.
+func WriteLostFound(repo *repository.Repo, objects []Object) error {
+	db := repo.Database()
+
+	for _, obj := range objects {
+		kind := "other"
+		if obj.Type == typeCommit {
+			kind = typeCommit
+		}
+
+		dir := filepath.Join(repo.Dir(), "lost-found", kind)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("error creating %s: %w", dir, err)
+		}
+
+		content := []byte(obj.OID + "\n")
+		if obj.Type == typeBlob {
+			stored, err := db.Read(obj.OID)
+			if err != nil {
+				return fmt.Errorf("error reading %s: %w", obj.OID, err)
+			}
+			content = stored.Serialize()
+		}
+
+		if err := ioutil.WriteFile(filepath.Join(dir, obj.OID), content, 0644); err != nil {
+			return fmt.Errorf("error writing lost-found: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,293 @@
+// Package fsck checks the integrity of a repository: that every object is
+// intact and well formed, and that everything reachable from refs, reflogs
+// and the index is present.
+package fsck
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/neocortical/got/objectformat"
+	"github.com/neocortical/got/ref"
+)
+
+const (
+	typeBlob   = "blob"
+	typeTree   = "tree"
+	typeCommit = ref.TypeCommit
+	typeTag    = "tag"
+
+	modeTree    = "40000"
+	modeGitlink = "160000"
+)
+
+var (
+	identRegexp = regexp.MustCompile(`^[^<>\n]*<[^<>\n]*> [0-9]+ [+-][0-9]{4}$`)
+
+	validModes = map[string]bool{
+		"100644":    true,
+		"100755":    true,
+		"120000":    true,
+		modeTree:    true,
+		modeGitlink: true,
+	}
+)
+
+// Problem is something wrong with a single object. IDs are git's fsck message
+// IDs, such as treeNotSorted.
+type Problem struct {
+	ID      string
+	Message string
+	Warning bool
+}
+
+func (p Problem) String() string {
+	return p.ID + ": " + p.Message
+}
+
+func problem(id string, message string) Problem {
+	return Problem{ID: id, Message: message}
+}
+
+func warning(id string, message string) Problem {
+	return Problem{ID: id, Message: message, Warning: true}
+}
+
+// Link is a reference from one object to another, of the type the
+// referencing object expects.
+type Link struct {
+	OID  string
+	Type string
+}
+
+// CheckObject checks the contents of an object of the given type and returns
+// the objects it refers to, whose IDs must be of the given format.
+func CheckObject(f *objectformat.Format, objectType string, data []byte) ([]Link, []Problem) {
+	switch objectType {
+	case typeBlob:
+		return nil, nil
+	case typeTree:
+		return CheckTree(f, data)
+	case typeCommit:
+		return CheckCommit(f, data)
+	case typeTag:
+		return CheckTag(f, data)
+	}
+
+	return nil, []Problem{problem("badObjectType", fmt.Sprintf("unknown object type '%s'", objectType))}
+}
+
+// CheckTree checks that a tree's entries are well formed, have valid modes
+// and safe names, and are sorted without duplicates.
+func CheckTree(f *objectformat.Format, data []byte) (links []Link, problems []Problem) {
+	seen := map[string]bool{}
+	var previous string
+	var badMode, zeroPadded, notSorted, duplicate bool
+	var badNames []Problem
+
+	for len(data) > 0 {
+		nul := bytes.IndexByte(data, 0)
+		space := bytes.IndexByte(data, ' ')
+		if nul == -1 || space == -1 || space > nul || len(data) < nul+1+f.Size() {
+			problems = append(problems, problem("badTree", "cannot be parsed as a tree"))
+			return links, problems
+		}
+
+		mode, name := string(data[:space]), string(data[space+1:nul])
+		oid := fmt.Sprintf("%x", data[nul+1:nul+1+f.Size()])
+		data = data[nul+1+f.Size():]
+
+		switch {
+		case strings.HasPrefix(mode, "0"):
+			zeroPadded = true
+		case !validModes[mode]:
+			badMode = true
+		}
+		badNames = append(badNames, checkTreeEntryName(name)...)
+
+		key := name
+		if strings.TrimLeft(mode, "0") == modeTree {
+			key += "/"
+		}
+		switch {
+		case seen[name]:
+			duplicate = true
+		case previous != "" && key <= previous:
+			notSorted = true
+		}
+		seen[name] = true
+		previous = key
+
+		switch strings.TrimLeft(mode, "0") {
+		case modeTree:
+			links = append(links, Link{OID: oid, Type: typeTree})
+		case modeGitlink:
+			// submodule commits live in another repository
+		default:
+			links = append(links, Link{OID: oid, Type: typeBlob})
+		}
+	}
+
+	if duplicate {
+		problems = append(problems, problem("duplicateEntries", "contains duplicate file entries"))
+	}
+	if notSorted {
+		problems = append(problems, problem("treeNotSorted", "not properly sorted"))
+	}
+	if badMode {
+		problems = append(problems, warning("badFilemode", "contains bad file modes"))
+	}
+	if zeroPadded {
+		problems = append(problems, warning("zeroPaddedFilemode", "contains zero-padded file modes"))
+	}
+
+	return links, append(problems, dedupe(badNames)...)
+}
+
+func checkTreeEntryName(name string) []Problem {
+	switch {
+	case name == "":
+		return []Problem{warning("emptyName", "contains empty pathname")}
+	case strings.Contains(name, "/"):
+		return []Problem{warning("fullPathname", "contains full pathnames")}
+	case name == ".":
+		return []Problem{warning("hasDot", "contains '.'")}
+	case name == "..":
+		return []Problem{warning("hasDotdot", "contains '..'")}
+	case strings.EqualFold(name, ".git"):
+		return []Problem{warning("hasDotgit", "contains '.git'")}
+	}
+
+	return nil
+}
+
+func dedupe(problems []Problem) (result []Problem) {
+	seen := map[string]bool{}
+	for _, p := range problems {
+		if !seen[p.ID] {
+			result = append(result, p)
+			seen[p.ID] = true
+		}
+	}
+
+	return
+}
+
+// headerScanner walks the header lines of a commit or tag.
+type headerScanner struct {
+	lines []string
+}
+
+func newHeaderScanner(data []byte) (*headerScanner, bool) {
+	end := bytes.Index(data, []byte("\n\n"))
+	if end == -1 {
+		if !bytes.HasSuffix(data, []byte("\n")) {
+			return nil, false
+		}
+		end = len(data) - 1
+	}
+
+	return &headerScanner{lines: strings.Split(string(data[:end]), "\n")}, true
+}
+
+// next returns the value of the next header if it has the given name.
+func (s *headerScanner) next(name string) (string, bool) {
+	if len(s.lines) == 0 || !strings.HasPrefix(s.lines[0], name+" ") {
+		return "", false
+	}
+
+	value := strings.TrimPrefix(s.lines[0], name+" ")
+	s.lines = s.lines[1:]
+	return value, true
+}
+
+// CheckCommit checks that a commit has its headers in order (tree, parents,
+// author, committer) with valid object IDs and identities.
+func CheckCommit(f *objectformat.Format, data []byte) (links []Link, problems []Problem) {
+	headers, ok := newHeaderScanner(data)
+	if !ok {
+		return nil, []Problem{problem("unterminatedHeader", "unterminated header")}
+	}
+
+	tree, ok := headers.next("tree")
+	if !ok {
+		return nil, []Problem{problem("missingTree", "invalid format - expected 'tree' line")}
+	}
+	if !f.IsOID(tree) {
+		return nil, []Problem{problem("badTreeSha1", "invalid 'tree' line format - bad sha1")}
+	}
+	links = append(links, Link{OID: tree, Type: typeTree})
+
+	for {
+		parent, ok := headers.next("parent")
+		if !ok {
+			break
+		}
+		if !f.IsOID(parent) {
+			return links, []Problem{problem("badParentSha1", "invalid 'parent' line format - bad sha1")}
+		}
+		links = append(links, Link{OID: parent, Type: typeCommit})
+	}
+
+	author, ok := headers.next("author")
+	if !ok {
+		return links, []Problem{problem("missingAuthor", "invalid format - expected 'author' line")}
+	}
+	if !identRegexp.MatchString(author) {
+		problems = append(problems, problem("badAuthor", fmt.Sprintf("invalid author identity '%s'", author)))
+	}
+
+	committer, ok := headers.next("committer")
+	if !ok {
+		return links, append(problems, problem("missingCommitter", "invalid format - expected 'committer' line"))
+	}
+	if !identRegexp.MatchString(committer) {
+		problems = append(problems, problem("badCommitter", fmt.Sprintf("invalid committer identity '%s'", committer)))
+	}
+
+	return links, problems
+}
+
+// CheckTag checks that an annotated tag names a valid object of a known type
+// and has a tag name.
+func CheckTag(f *objectformat.Format, data []byte) (links []Link, problems []Problem) {
+	headers, ok := newHeaderScanner(data)
+	if !ok {
+		return nil, []Problem{problem("unterminatedHeader", "unterminated header")}
+	}
+
+	object, ok := headers.next("object")
+	if !ok {
+		return nil, []Problem{problem("missingObject", "invalid format - expected 'object' line")}
+	}
+	if !f.IsOID(object) {
+		return nil, []Problem{problem("badObjectSha1", "invalid 'object' line format - bad sha1")}
+	}
+
+	objectType, ok := headers.next("type")
+	if !ok {
+		return nil, []Problem{problem("missingTypeEntry", "invalid format - expected 'type' line")}
+	}
+	switch objectType {
+	case typeBlob, typeTree, typeCommit, typeTag:
+		links = append(links, Link{OID: object, Type: objectType})
+	default:
+		return nil, []Problem{problem("badType", fmt.Sprintf("invalid 'type' value '%s'", objectType))}
+	}
+
+	name, ok := headers.next("tag")
+	if !ok {
+		return links, []Problem{problem("missingTagEntry", "invalid format - expected 'tag' line")}
+	}
+	if name == "" {
+		problems = append(problems, warning("badTagName", "invalid 'tag' name"))
+	}
+
+	if tagger, ok := headers.next("tagger"); ok && !identRegexp.MatchString(tagger) {
+		problems = append(problems, problem("badTagger", fmt.Sprintf("invalid tagger identity '%s'", tagger)))
+	}
+
+	return links, problems
+}
@@ -0,0 +1,146 @@
+package fsck
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/neocortical/got/objectformat"
+)
+
+const (
+	testOID1 = "5b8b3e5ad21e9c8e8e6fbbe9dd72b9c1e4de5b6e"
+	testOID2 = "0e31ce6a27b8c1e45b5cd8b0a2f6dc5a7b2a97e1"
+)
+
+type testTreeEntry struct {
+	mode string
+	name string
+	oid  string
+}
+
+func buildTree(entries ...testTreeEntry) []byte {
+	var buf bytes.Buffer
+	for _, e := range entries {
+		buf.WriteString(e.mode + " " + e.name + "\x00")
+		oid, _ := hex.DecodeString(e.oid)
+		buf.Write(oid)
+	}
+	return buf.Bytes()
+}
+
+func problemIDs(problems []Problem) string {
+	var ids []string
+	for _, p := range problems {
+		ids = append(ids, p.ID)
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestCheckTree(t *testing.T) {
+	tests := []struct {
+		name     string
+		entries  []testTreeEntry
+		expected string
+	}{
+		{"valid", []testTreeEntry{{"100644", "foo.txt", testOID1}, {"40000", "foo", testOID2}, {"100755", "foo0", testOID1}}, ""},
+		{"not sorted", []testTreeEntry{{"40000", "foo", testOID2}, {"100644", "foo.txt", testOID1}}, "treeNotSorted"},
+		{"duplicate", []testTreeEntry{{"100644", "a", testOID1}, {"40000", "a", testOID2}}, "duplicateEntries"},
+		{"bad mode", []testTreeEntry{{"100664", "a", testOID1}}, "badFilemode"},
+		{"zero padded", []testTreeEntry{{"040000", "a", testOID2}}, "zeroPaddedFilemode"},
+		{"dotgit", []testTreeEntry{{"40000", ".GIT", testOID2}}, "hasDotgit"},
+		{"dotdot", []testTreeEntry{{"40000", "..", testOID2}}, "hasDotdot"},
+	}
+
+	for _, test := range tests {
+		links, problems := CheckTree(objectformat.SHA1, buildTree(test.entries...))
+		if ids := problemIDs(problems); ids != test.expected {
+			t.Errorf("%s: expected problems '%s' but got '%s'", test.name, test.expected, ids)
+		}
+		if len(links) != len(test.entries) {
+			t.Errorf("%s: expected %d links but got %d", test.name, len(test.entries), len(links))
+		}
+	}
+
+	_, problems := CheckTree(objectformat.SHA1, []byte("100644 truncated\x00abc"))
+	if ids := problemIDs(problems); ids != "badTree" {
+		t.Errorf("expected a truncated tree to be reported as badTree but got '%s'", ids)
+	}
+
+	links, _ := CheckTree(objectformat.SHA1, buildTree(testTreeEntry{"40000", "dir", testOID2}, testTreeEntry{"100644", "file", testOID1}, testTreeEntry{"160000", "sub", testOID1}))
+	expected := []Link{{testOID2, typeTree}, {testOID1, typeBlob}}
+	if len(links) != 2 || links[0] != expected[0] || links[1] != expected[1] {
+		t.Errorf("expected links %v but got %v", expected, links)
+	}
+}
+
+func TestCheckCommit(t *testing.T) {
+	ident := "Nathan Smith <nathan@neocortical.net> 1600000000 -0500"
+	tests := []struct {
+		name     string
+		data     string
+		expected string
+		links    int
+	}{
+		{"valid", "tree " + testOID1 + "\nparent " + testOID2 + "\nparent " + testOID1 + "\nauthor " + ident + "\ncommitter " + ident + "\n\nmessage\n", "", 3},
+		{"no message", "tree " + testOID1 + "\nauthor " + ident + "\ncommitter " + ident + "\n", "", 1},
+		{"missing tree", "parent " + testOID2 + "\nauthor " + ident + "\ncommitter " + ident + "\n\nmessage\n", "missingTree", 0},
+		{"bad parent", "tree " + testOID1 + "\nparent nope\nauthor " + ident + "\ncommitter " + ident + "\n\nmessage\n", "badParentSha1", 1},
+		{"missing committer", "tree " + testOID1 + "\nauthor " + ident + "\n\nmessage\n", "missingCommitter", 1},
+		{"bad author", "tree " + testOID1 + "\nauthor Nathan 1600000000 -0500\ncommitter " + ident + "\n\nmessage\n", "badAuthor", 1},
+		{"unterminated", "tree " + testOID1, "unterminatedHeader", 0},
+	}
+
+	for _, test := range tests {
+		links, problems := CheckCommit(objectformat.SHA1, []byte(test.data))
+		if ids := problemIDs(problems); ids != test.expected {
+			t.Errorf("%s: expected problems '%s' but got '%s'", test.name, test.expected, ids)
+		}
+		if len(links) != test.links {
+			t.Errorf("%s: expected %d links but got %d", test.name, test.links, len(links))
+		}
+	}
+}
+
+func TestCheckCommitSHA256(t *testing.T) {
+	ident := "Nathan Smith <nathan@neocortical.net> 1600000000 -0500"
+	tree := objectformat.SHA256.Sum([]byte("tree 0\x00"))
+
+	links, problems := CheckCommit(objectformat.SHA256, []byte("tree "+tree+"\nauthor "+ident+"\ncommitter "+ident+"\n"))
+	if len(problems) != 0 || len(links) != 1 || links[0].OID != tree {
+		t.Errorf("unexpected links %v and problems %v", links, problems)
+	}
+
+	_, problems = CheckCommit(objectformat.SHA256, []byte("tree "+testOID1+"\nauthor "+ident+"\ncommitter "+ident+"\n"))
+	if ids := problemIDs(problems); ids != "badTreeSha1" {
+		t.Errorf("expected badTreeSha1 for a SHA-1 ID but got '%s'", ids)
+	}
+}
+
+func TestCheckTag(t *testing.T) {
+	tagger := "tagger Nathan Smith <nathan@neocortical.net> 1600000000 -0500\n"
+	tests := []struct {
+		name     string
+		data     string
+		expected string
+	}{
+		{"valid", "object " + testOID1 + "\ntype commit\ntag v1\n" + tagger + "\nmessage\n", ""},
+		{"no tagger", "object " + testOID1 + "\ntype commit\ntag v1\n\nmessage\n", ""},
+		{"bad type", "object " + testOID1 + "\ntype widget\ntag v1\n" + tagger + "\nmessage\n", "badType"},
+		{"missing tag", "object " + testOID1 + "\ntype commit\n" + tagger + "\nmessage\n", "missingTagEntry"},
+		{"bad object", "object 1234\ntype commit\ntag v1\n" + tagger + "\nmessage\n", "badObjectSha1"},
+	}
+
+	for _, test := range tests {
+		_, problems := CheckTag(objectformat.SHA1, []byte(test.data))
+		if ids := problemIDs(problems); ids != test.expected {
+			t.Errorf("%s: expected problems '%s' but got '%s'", test.name, test.expected, ids)
+		}
+	}
+
+	links, _ := CheckTag(objectformat.SHA1, []byte("object "+testOID1+"\ntype tree\ntag v1\n\n"))
+	if len(links) != 1 || links[0] != (Link{testOID1, typeTree}) {
+		t.Errorf("expected a link to tree %s but got %v", testOID1, links)
+	}
+}
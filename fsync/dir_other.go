@@ -0,0 +1,10 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package fsync
+
+// Dir is a no-op where directories can't be synced; renames are durable once
+// the file system commits its metadata.
+func Dir(dir string) error {
+	return nil
+}
@@ -0,0 +1,22 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package fsync
+
+import "os"
+
+// Dir flushes a directory to stable storage, making the creation, renaming or
+// removal of its entries durable.
+func Dir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	err = d.Sync()
+	if closeErr := d.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
@@ -0,0 +1,91 @@
+// Package fsync decides which files must be flushed to stable storage before
+// they're committed, following git's core.fsync setting.
+package fsync
+
+import (
+	"os"
+	"strings"
+)
+
+// Component is a set of kinds of repository files.
+type Component uint
+
+const (
+	LooseObject Component = 1 << iota
+	Pack
+	PackMetadata
+	CommitGraph
+	Index
+	Reference
+
+	None            Component = 0
+	Objects                   = LooseObject | Pack
+	DerivedMetadata           = PackMetadata | CommitGraph
+	Committed                 = Objects | Reference
+	Added                     = Committed | Index
+	All                       = Added | DerivedMetadata
+
+	// Default syncs everything. git leaves loose objects, the index and refs
+	// out, so a power loss can leave them empty even though their renames
+	// survived; "-loose-object,-index,-reference" gets git's behaviour.
+	Default = All
+)
+
+var componentNames = map[string]Component{
+	"loose-object":     LooseObject,
+	"pack":             Pack,
+	"pack-metadata":    PackMetadata,
+	"commit-graph":     CommitGraph,
+	"index":            Index,
+	"reference":        Reference,
+	"objects":          Objects,
+	"derived-metadata": DerivedMetadata,
+	"committed":        Committed,
+	"added":            Added,
+	"all":              All,
+}
+
+// Parse parses a core.fsync value: a comma-separated list of components to
+// sync in addition to the defaults, "-component" to stop syncing one, and
+// "none" to start from nothing. Like git, it ignores components it doesn't
+// know, returning them for the caller to warn about.
+func Parse(value string) (result Component, unknown []string) {
+	current := Default
+	var positive, negative Component
+
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if name == "none" {
+			current = None
+			continue
+		}
+
+		negated := strings.HasPrefix(name, "-")
+		c, ok := componentNames[strings.TrimPrefix(name, "-")]
+		if !ok {
+			unknown = append(unknown, name)
+			continue
+		}
+
+		if negated {
+			negative |= c
+		} else {
+			positive |= c
+		}
+	}
+
+	return (current &^ negative) | positive, unknown
+}
+
+// Has reports whether all of other's components are in c.
+func (c Component) Has(other Component) bool {
+	return c&other == other
+}
+
+// File flushes a file's contents to stable storage.
+func File(f *os.File) error {
+	return f.Sync()
+}
@@ -0,0 +1,37 @@
+package fsync
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	var tests = []struct {
+		input    string
+		expected Component
+	}{
+		{"", Default},
+		{"none", None},
+		{"loose-object", Default | LooseObject},
+		{"none,committed", Committed},
+		{"index,-pack-metadata", (Default | Index) &^ PackMetadata},
+		{"none, index, reference", Index | Reference},
+		{"all", All},
+		{"-loose-object,-index,-reference", Pack | DerivedMetadata},
+	}
+
+	for _, test := range tests {
+		actual, unknown := Parse(test.input)
+		if len(unknown) > 0 {
+			t.Errorf("%s: expected no unknown components but got: %v", test.input, unknown)
+		}
+		if actual != test.expected {
+			t.Errorf("%s: expected %b but got %b", test.input, test.expected, actual)
+		}
+	}
+
+	actual, unknown := Parse("none,objects,bogus,-worse")
+	if actual != Objects || !reflect.DeepEqual(unknown, []string{"bogus", "-worse"}) {
+		t.Errorf("expected unknown components to be skipped but got %b, %v", actual, unknown)
+	}
+}
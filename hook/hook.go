@@ -0,0 +1,138 @@
+package hook
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/neocortical/got/repository"
+)
+
+// The hooks got runs: the commit hooks around making a commit, pre-push
+// before a push sends anything, post-checkout after clone checks out a
+// branch, and the receive hooks on the server side of a push.
+const (
+	PreCommit        = "pre-commit"
+	PrepareCommitMsg = "prepare-commit-msg"
+	CommitMsg        = "commit-msg"
+	PostCommit       = "post-commit"
+	PrePush          = "pre-push"
+	PostCheckout     = "post-checkout"
+	PreReceive       = "pre-receive"
+	Update           = "update"
+	PostReceive      = "post-receive"
+)
+
+const hooksDir = "hooks"
+
+// Options control how a hook runs.
+type Options struct {
+	Args []string
+	// Stdin is what the hook reads; without it the hook reads nothing.
+	Stdin io.Reader
+	// Env adds "name=value" variables to the hook's environment, after
+	// GIT_DIR.
+	Env []string
+	// Output receives what the hook writes to its standard output and
+	// error; without it the output is discarded.
+	Output io.Writer
+}
+
+// Dir returns the directory hooks are looked for in: core.hooksPath, which
+// is relative to the directory hooks run in, or else the repository's own
+// hooks directory.
+func Dir(repo *repository.Repo) (string, error) {
+	cfg, err := repo.Config()
+	if err != nil {
+		return "", err
+	}
+
+	dir, _ := cfg.Get("core.hookspath")
+	if dir == "" {
+		return filepath.Join(repo.Dir(), hooksDir), nil
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(runDir(repo), dir)
+	}
+
+	return dir, nil
+}
+
+// Find returns the path of the named hook, or "" if there is no executable
+// file by that name.
+func Find(repo *repository.Repo, name string) (string, error) {
+	filename, executable, err := find(repo, name)
+	if !executable {
+		filename = ""
+	}
+
+	return filename, err
+}
+
+func find(repo *repository.Repo, name string) (filename string, executable bool, err error) {
+	dir, err := Dir(repo)
+	if err != nil {
+		return "", false, err
+	}
+
+	filename = filepath.Join(dir, name)
+	info, err := os.Stat(filename)
+	if os.IsNotExist(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("error reading hook '%s': %w", name, err)
+	}
+
+	return filename, !info.IsDir() && info.Mode().Perm()&0111 != 0, nil
+}
+
+// Run runs the named hook, if there is one, in the workspace, or in the
+// git directory of a bare repository, with GIT_DIR set. A hook that isn't
+// executable is skipped with a hint, as git does. It's an error for the hook
+// to exit with a non-zero status, which is how hooks refuse what they're
+// asked about.
+func Run(repo *repository.Repo, name string, opts Options) error {
+	output := opts.Output
+	if output == nil {
+		output = ioutil.Discard
+	}
+
+	filename, executable, err := find(repo, name)
+	if err != nil || filename == "" {
+		return err
+	}
+	if !executable {
+		fmt.Fprintf(output, "hint: The '%s' hook was ignored because it's not set as executable.\n", filename)
+		return nil
+	}
+
+	gitDir, err := filepath.Abs(repo.Dir())
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(filename, opts.Args...)
+	cmd.Dir = runDir(repo)
+	cmd.Env = append(append(os.Environ(), "GIT_DIR="+gitDir), opts.Env...)
+	cmd.Stdin = opts.Stdin
+	cmd.Stdout = output
+	cmd.Stderr = output
+	if err = cmd.Run(); err != nil {
+		return fmt.Errorf("%s hook failed: %w", name, err)
+	}
+
+	return nil
+}
+
+// runDir returns the directory hooks run in.
+func runDir(repo *repository.Repo) string {
+	if repo.Bare() {
+		return repo.Dir()
+	}
+
+	return repo.WorkspaceDir()
+}
@@ -0,0 +1,94 @@
+package hook
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/neocortical/got/objectformat"
+	"github.com/neocortical/got/repository"
+)
+
+func initRepo(t *testing.T) (repo *repository.Repo, cleanup func()) {
+	dir, err := ioutil.TempDir("", "got_hook_test_*")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	if repo, err = repository.Init(dir, objectformat.Default); err != nil {
+		t.Fatalf("error creating repository: %v", err)
+	}
+
+	return repo, func() { os.RemoveAll(dir) }
+}
+
+func writeHook(t *testing.T, dir string, name string, script string, mode os.FileMode) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("error creating %s: %v", dir, err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script), mode); err != nil {
+		t.Fatalf("error writing hook %s: %v", name, err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	repo, cleanup := initRepo(t)
+	defer cleanup()
+
+	var output bytes.Buffer
+	opts := Options{
+		Args:   []string{"one", "two"},
+		Stdin:  strings.NewReader("input\n"),
+		Env:    []string{"GIT_INDEX_FILE=index"},
+		Output: &output,
+	}
+
+	// without the hook, nothing runs
+	if err := Run(repo, PreCommit, opts); err != nil || output.Len() > 0 {
+		t.Fatalf("expected nothing to run but got: %q (%v)", output.String(), err)
+	}
+
+	writeHook(t, filepath.Join(repo.Dir(), hooksDir), PreCommit, `echo "$@" "$(cat)" "$(pwd)"; echo "$GIT_DIR $GIT_INDEX_FILE" >&2`, 0755)
+	if err := Run(repo, PreCommit, opts); err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	expected := "one two input " + repo.WorkspaceDir() + "\n" + repo.Dir() + " index\n"
+	if output.String() != expected {
+		t.Errorf("expected %q but got: %q", expected, output.String())
+	}
+
+	writeHook(t, filepath.Join(repo.Dir(), hooksDir), CommitMsg, "exit 1", 0755)
+	if err := Run(repo, CommitMsg, Options{}); err == nil || !strings.Contains(err.Error(), "commit-msg hook failed") {
+		t.Errorf("expected the hook to fail but got: %v", err)
+	}
+
+	output.Reset()
+	writeHook(t, filepath.Join(repo.Dir(), hooksDir), PostCommit, "exit 1", 0644)
+	if err := Run(repo, PostCommit, Options{Output: &output}); err != nil || !strings.Contains(output.String(), "not set as executable") {
+		t.Errorf("expected the hook to be ignored with a hint but got: %q (%v)", output.String(), err)
+	}
+	if filename, _ := Find(repo, PostCommit); filename != "" {
+		t.Errorf("expected a hook that isn't executable not to be found but got: %s", filename)
+	}
+}
+
+func TestHooksPath(t *testing.T) {
+	repo, cleanup := initRepo(t)
+	defer cleanup()
+
+	cfg, _ := repo.Config()
+	if err := cfg.Set("core.hooksPath", "my-hooks"); err != nil {
+		t.Fatalf("error setting core.hooksPath: %v", err)
+	}
+	repo = repository.NewRepo(repo.WorkspaceDir())
+
+	writeHook(t, filepath.Join(repo.Dir(), hooksDir), PrePush, "exit 0", 0755)
+	writeHook(t, filepath.Join(repo.WorkspaceDir(), "my-hooks"), PrePush, "exit 0", 0755)
+
+	filename, err := Find(repo, PrePush)
+	if expected := filepath.Join(repo.WorkspaceDir(), "my-hooks", PrePush); err != nil || filename != expected {
+		t.Errorf("expected %s but got: %s (%v)", expected, filename, err)
+	}
+}
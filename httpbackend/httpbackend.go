@@ -0,0 +1,193 @@
+// Package httpbackend serves repositories over git's smart HTTP protocol, as
+// git http-backend does.
+package httpbackend
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/neocortical/got/pktline"
+	"github.com/neocortical/got/ref"
+	"github.com/neocortical/got/remote"
+	"github.com/neocortical/got/repository"
+)
+
+const (
+	uploadPack  = "git-upload-pack"
+	receivePack = "git-receive-pack"
+)
+
+// Handler serves the repositories under Root. A fetch or push of the
+// repository at Root/<repo> starts with a GET of
+// <repo>/info/refs?service=<service>, then POSTs requests to
+// <repo>/<service>.
+type Handler struct {
+	Root string
+	// ReceivePack allows pushes into repositories that don't set
+	// http.receivepack. Fetches are allowed unless a repository sets
+	// http.uploadpack to false.
+	ReceivePack bool
+	// Committer is recorded in the reflogs of the refs pushes update, at
+	// the time of the push.
+	Committer ref.Author
+	// ErrorLog logs errors serving requests that happen once the response
+	// has started. If nil, errors go to the log package's standard logger.
+	ErrorLog *log.Logger
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p := path.Clean("/" + r.URL.Path)
+
+	var repoPath, service string
+	advertise := false
+	switch {
+	case strings.HasSuffix(p, "/info/refs"):
+		repoPath, service, advertise = strings.TrimSuffix(p, "/info/refs"), r.URL.Query().Get("service"), true
+	case strings.HasSuffix(p, "/"+uploadPack):
+		repoPath, service = strings.TrimSuffix(p, "/"+uploadPack), uploadPack
+	case strings.HasSuffix(p, "/"+receivePack):
+		repoPath, service = strings.TrimSuffix(p, "/"+receivePack), receivePack
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	if advertise && r.Method != http.MethodGet && r.Method != http.MethodHead || !advertise && r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if service != uploadPack && service != receivePack {
+		http.Error(w, "only the smart HTTP protocol is supported", http.StatusForbidden)
+		return
+	}
+
+	repo, err := remote.OpenRepository(filepath.Join(h.Root, filepath.FromSlash(repoPath)), "")
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if status, err := h.checkEnabled(repo, service); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	v2 := remote.ProtocolV2Requested(r.Header.Get("Git-Protocol"))
+	if service == uploadPack && !v2 {
+		http.Error(w, "upload-pack only speaks protocol version 2", http.StatusForbidden)
+		return
+	}
+
+	if advertise {
+		h.advertise(w, repo, service, v2)
+	} else {
+		h.serveRPC(w, r, repo, service)
+	}
+}
+
+// checkEnabled decides whether a repository can be served by a service,
+// returning the HTTP status to refuse it with if not.
+func (h *Handler) checkEnabled(repo *repository.Repo, service string) (int, error) {
+	cfg, err := repo.Config()
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("error reading config: %w", err)
+	}
+
+	var enabled bool
+	if service == uploadPack {
+		enabled, err = cfg.GetBool("http.uploadpack", true)
+	} else {
+		enabled, err = cfg.GetBool("http.receivepack", h.ReceivePack)
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if !enabled {
+		return http.StatusForbidden, fmt.Errorf("%s is not enabled", service)
+	}
+
+	return 0, nil
+}
+
+// advertise answers the info/refs request that starts a fetch or push.
+func (h *Handler) advertise(w http.ResponseWriter, repo *repository.Repo, service string, v2 bool) {
+	setNoCache(w)
+	w.Header().Set("Content-Type", "application/x-"+service+"-advertisement")
+
+	// protocol version 2 responses start straight off with capabilities
+	if !v2 || service == receivePack {
+		pw := pktline.NewWriter(w)
+		pw.WriteString("# service=%s", service)
+		pw.Flush()
+	}
+
+	var err error
+	if service == uploadPack {
+		err = remote.UploadPack(repo, nil, w, remote.UploadPackOptions{AdvertiseRefs: true})
+	} else {
+		err = remote.ReceivePack(repo, nil, w, remote.ReceivePackOptions{AdvertiseRefs: true})
+	}
+	if err != nil {
+		h.logf("error advertising %s: %v", service, err)
+	}
+}
+
+// serveRPC answers a POST of a request to a service.
+func (h *Handler) serveRPC(w http.ResponseWriter, r *http.Request, repo *repository.Repo, service string) {
+	if contentType := r.Header.Get("Content-Type"); contentType != "application/x-"+service+"-request" {
+		http.Error(w, fmt.Sprintf("unexpected content type '%s'", contentType), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var body io.Reader = r.Body
+	switch r.Header.Get("Content-Encoding") {
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error reading gzipped request: %v", err), http.StatusBadRequest)
+			return
+		}
+		defer gz.Close()
+		body = gz
+	case "", "identity":
+	default:
+		http.Error(w, "unsupported content encoding", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	setNoCache(w)
+	w.Header().Set("Content-Type", "application/x-"+service+"-result")
+
+	var err error
+	if service == uploadPack {
+		err = remote.UploadPack(repo, body, w, remote.UploadPackOptions{StatelessRPC: true})
+	} else {
+		committer := h.Committer
+		committer.Time = time.Now()
+		err = remote.ReceivePack(repo, body, w, remote.ReceivePackOptions{Committer: committer, StatelessRPC: true})
+	}
+	if err != nil {
+		h.logf("error serving %s: %v", service, err)
+	}
+}
+
+func (h *Handler) logf(format string, args ...interface{}) {
+	if h.ErrorLog != nil {
+		h.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// setNoCache keeps proxies from caching responses, which change with every
+// push.
+func setNoCache(w http.ResponseWriter) {
+	w.Header().Set("Expires", "Fri, 01 Jan 1980 00:00:00 GMT")
+	w.Header().Set("Pragma", "no-cache")
+	w.Header().Set("Cache-Control", "no-cache, max-age=0, must-revalidate")
+}
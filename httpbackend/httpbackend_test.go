@@ -0,0 +1,237 @@
+package httpbackend
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/neocortical/got/blob"
+	"github.com/neocortical/got/object"
+	"github.com/neocortical/got/objectformat"
+	"github.com/neocortical/got/pktline"
+	"github.com/neocortical/got/ref"
+	"github.com/neocortical/got/repository"
+)
+
+var testAuthor = ref.Author{Name: "Nathan Smith", Email: "nathan@neocortical.net"}
+
+// setUpServer serves a bare repository, root/repo.git, with master pointing
+// at a blob.
+func setUpServer(t *testing.T) (h *Handler, srv *httptest.Server, repo *repository.Repo, oid string, cleanup func()) {
+	root, err := ioutil.TempDir("", "got_httpbackend_test_*")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+
+	repo, err = repository.InitBare(filepath.Join(root, "repo.git"), objectformat.SHA1)
+	if err != nil {
+		t.Fatalf("error initializing repository: %v", err)
+	}
+	if oid, err = repo.Database().Store(blob.New([]byte("hello\n"))); err != nil {
+		t.Fatalf("error storing blob: %v", err)
+	}
+	tx := repo.Refs().Transaction(testAuthor)
+	if err = tx.Create("refs/heads/master", oid, "test"); err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		t.Fatalf("error creating master: %v", err)
+	}
+
+	h = &Handler{Root: root, Committer: testAuthor}
+	srv = httptest.NewServer(h)
+
+	return h, srv, repo, oid, func() {
+		srv.Close()
+		os.RemoveAll(root)
+	}
+}
+
+func doRequest(t *testing.T, method string, url string, header map[string]string, body []byte) (*http.Response, []byte) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("error creating request: %v", err)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("error sending request: %v", err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("error reading response: %v", err)
+	}
+
+	return resp, data
+}
+
+func readLines(t *testing.T, data []byte) []string {
+	var lines []string
+	pr := pktline.NewReader(bytes.NewReader(data))
+	for {
+		line, kind, err := pr.ReadLine()
+		if err == io.EOF {
+			return lines
+		}
+		if err != nil {
+			t.Fatalf("error reading response: %v", err)
+		}
+		if kind != pktline.Data {
+			line = kind.String()
+		}
+		lines = append(lines, line)
+	}
+}
+
+func TestAdvertise(t *testing.T) {
+	_, srv, _, _, cleanup := setUpServer(t)
+	defer cleanup()
+
+	v2 := map[string]string{"Git-Protocol": "version=2"}
+	resp, body := doRequest(t, "GET", srv.URL+"/repo.git/info/refs?service=git-upload-pack", v2, nil)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/x-git-upload-pack-advertisement" {
+		t.Fatalf("unexpected response: %s %s", resp.Status, body)
+	}
+	if lines := readLines(t, body); lines[0] != "version 2" || lines[len(lines)-1] != "flush" {
+		t.Errorf("expected capabilities but got: %q", lines)
+	}
+
+	if resp, _ = doRequest(t, "GET", srv.URL+"/repo.git/info/refs?service=git-upload-pack", nil, nil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected protocol version 0 fetches to be refused but got: %s", resp.Status)
+	}
+
+	if resp, _ = doRequest(t, "GET", srv.URL+"/repo.git/info/refs?service=git-receive-pack", nil, nil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected pushes to be refused by default but got: %s", resp.Status)
+	}
+}
+
+func TestAdvertiseReceivePack(t *testing.T) {
+	h, srv, repo, oid, cleanup := setUpServer(t)
+	defer cleanup()
+
+	h.ReceivePack = true
+	resp, body := doRequest(t, "GET", srv.URL+"/repo.git/info/refs?service=git-receive-pack", nil, nil)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/x-git-receive-pack-advertisement" {
+		t.Fatalf("unexpected response: %s %s", resp.Status, body)
+	}
+	lines := readLines(t, body)
+	if len(lines) != 4 || lines[0] != "# service=git-receive-pack" || lines[1] != "flush" ||
+		!strings.HasPrefix(lines[2], oid+" refs/heads/master\x00report-status ") || lines[3] != "flush" {
+		t.Errorf("unexpected advertisement: %q", lines)
+	}
+
+	// the repository's own setting wins
+	cfg, err := repo.Config()
+	if err != nil {
+		t.Fatalf("error reading config: %v", err)
+	}
+	if err = cfg.Set("http.receivepack", "false"); err != nil {
+		t.Fatalf("error writing config: %v", err)
+	}
+	if resp, _ = doRequest(t, "GET", srv.URL+"/repo.git/info/refs?service=git-receive-pack", nil, nil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected pushes to be refused but got: %s", resp.Status)
+	}
+}
+
+func TestUploadPackRPC(t *testing.T) {
+	_, srv, _, oid, cleanup := setUpServer(t)
+	defer cleanup()
+
+	var request bytes.Buffer
+	pw := pktline.NewWriter(&request)
+	pw.WriteString("command=ls-refs")
+	pw.Delim()
+	pw.WriteString("ref-prefix refs/heads/")
+	pw.Flush()
+
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write(request.Bytes())
+	gz.Close()
+
+	for _, body := range []struct {
+		encoding string
+		data     []byte
+	}{
+		{"", request.Bytes()},
+		{"gzip", gzipped.Bytes()},
+	} {
+		header := map[string]string{
+			"Git-Protocol":     "version=2",
+			"Content-Type":     "application/x-git-upload-pack-request",
+			"Content-Encoding": body.encoding,
+		}
+		resp, data := doRequest(t, "POST", srv.URL+"/repo.git/git-upload-pack", header, body.data)
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/x-git-upload-pack-result" {
+			t.Fatalf("unexpected response: %s %s", resp.Status, data)
+		}
+		if lines := readLines(t, data); len(lines) != 2 || lines[0] != oid+" refs/heads/master" {
+			t.Errorf("expected master to be listed but got: %q", lines)
+		}
+	}
+
+	header := map[string]string{"Git-Protocol": "version=2", "Content-Type": "text/plain"}
+	if resp, _ := doRequest(t, "POST", srv.URL+"/repo.git/git-upload-pack", header, request.Bytes()); resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("expected an unsupported media type but got: %s", resp.Status)
+	}
+}
+
+func TestReceivePackRPC(t *testing.T) {
+	h, srv, repo, oid, cleanup := setUpServer(t)
+	defer cleanup()
+
+	h.ReceivePack = true
+	var request bytes.Buffer
+	pw := pktline.NewWriter(&request)
+	pw.WriteString("%s %s refs/heads/other\x00report-status", objectformat.SHA1.ZeroOID(), oid)
+	pw.Flush()
+	if err := object.WritePack(&request, objectformat.SHA1, repo.Database(), nil); err != nil {
+		t.Fatalf("error writing pack: %v", err)
+	}
+
+	header := map[string]string{"Content-Type": "application/x-git-receive-pack-request"}
+	resp, data := doRequest(t, "POST", srv.URL+"/repo.git/git-receive-pack", header, request.Bytes())
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/x-git-receive-pack-result" {
+		t.Fatalf("unexpected response: %s %s", resp.Status, data)
+	}
+	if lines := readLines(t, data); len(lines) != 3 || lines[0] != "unpack ok" || lines[1] != "ok refs/heads/other" {
+		t.Errorf("unexpected report: %q", lines)
+	}
+	if other, _ := repo.Refs().ReadRef("refs/heads/other"); other != oid {
+		t.Errorf("expected other to be %s but got: '%s'", oid, other)
+	}
+}
+
+func TestBadRequests(t *testing.T) {
+	_, srv, _, _, cleanup := setUpServer(t)
+	defer cleanup()
+
+	v2 := map[string]string{"Git-Protocol": "version=2"}
+	for _, test := range []struct {
+		method string
+		path   string
+		status int
+	}{
+		{"GET", "/repo.git/HEAD", http.StatusNotFound},
+		{"GET", "/missing.git/info/refs?service=git-upload-pack", http.StatusNotFound},
+		{"GET", "/../repo.git/info/refs?service=git-upload-pack", http.StatusOK},
+		{"GET", "/repo.git/info/refs", http.StatusForbidden},
+		{"POST", "/repo.git/info/refs?service=git-upload-pack", http.StatusMethodNotAllowed},
+		{"GET", "/repo.git/git-upload-pack", http.StatusMethodNotAllowed},
+	} {
+		if resp, _ := doRequest(t, test.method, srv.URL+test.path, v2, nil); resp.StatusCode != test.status {
+			t.Errorf("expected %s %s to respond %d but got: %s", test.method, test.path, test.status, resp.Status)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package ignore
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/neocortical/got/wildmatch"
+)
+
+const (
+	// Filename is the name of per-directory ignore files.
+	Filename = ".gitignore"
+	gitDir   = ".git"
+)
+
+type pattern struct {
+	base     string
+	glob     string
+	negated  bool
+	dirOnly  bool
+	anchored bool
+}
+
+// Matcher decides whether paths are ignored using gitignore rules. Patterns
+// added later take precedence over those added earlier.
+type Matcher struct {
+	patterns []pattern
+}
+
+func New() *Matcher {
+	return &Matcher{}
+}
+
+// AddPatterns adds the patterns in data, read from an ignore file in the
+// directory base (relative to the workspace root, "" for the root itself).
+func (m *Matcher) AddPatterns(base string, data []byte) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if p, ok := parsePattern(base, scanner.Text()); ok {
+			m.patterns = append(m.patterns, p)
+		}
+	}
+}
+
+// AddFile adds the patterns from an ignore file. Missing files are skipped.
+func (m *Matcher) AddFile(base, filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	m.AddPatterns(base, data)
+	return nil
+}
+
+// LoadStandard builds a matcher from the standard sources, in increasing order
+// of precedence: the user's excludes file (if any), .git/info/exclude and then
+// every .gitignore in the workspace.
+func LoadStandard(workspaceDir string, excludesFile string) (*Matcher, error) {
+	m := New()
+
+	if excludesFile != "" {
+		if err := m.AddFile("", expandHome(excludesFile)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := m.AddFile("", filepath.Join(workspaceDir, gitDir, "info", "exclude")); err != nil {
+		return nil, err
+	}
+
+	err := filepath.Walk(workspaceDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			if info.Name() == gitDir {
+				return filepath.SkipDir
+			}
+
+			base, _ := filepath.Rel(workspaceDir, p)
+			if base == "." {
+				base = ""
+			}
+			base = filepath.ToSlash(base)
+
+			// a directory that is ignored can't have its contents re-included
+			if base != "" && m.Ignored(base, true) {
+				return filepath.SkipDir
+			}
+
+			return m.AddFile(base, filepath.Join(p, Filename))
+		}
+
+		return nil
+	})
+
+	return m, err
+}
+
+func expandHome(filename string) string {
+	if strings.HasPrefix(filename, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, filename[2:])
+		}
+	}
+
+	return filename
+}
+
+func parsePattern(base, line string) (result pattern, ok bool) {
+	if line == "" || line[0] == '#' {
+		return result, false
+	}
+
+	// trailing spaces are ignored unless escaped
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+
+	if line[0] == '!' {
+		result.negated = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		result.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+
+	if strings.Contains(line, "/") {
+		result.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+
+	if line == "" {
+		return result, false
+	}
+
+	result.base = base
+	result.glob = line
+	return result, true
+}
+
+// Ignored reports whether a path (relative to the workspace root and using
+// forward slashes) is ignored, either directly or because one of its parent
+// directories is.
+func (m *Matcher) Ignored(p string, isDir bool) bool {
+	var dir string
+	for _, component := range strings.Split(path.Dir(p), "/") {
+		if component == "." {
+			break
+		}
+		dir = path.Join(dir, component)
+		if m.matches(dir, true) {
+			return true
+		}
+	}
+
+	return m.matches(p, isDir)
+}
+
+// matches reports whether the highest precedence pattern matching the path
+// excludes it.
+func (m *Matcher) matches(p string, isDir bool) bool {
+	for i := len(m.patterns) - 1; i >= 0; i-- {
+		pat := m.patterns[i]
+		if pat.dirOnly && !isDir {
+			continue
+		}
+
+		relative := p
+		if pat.base != "" {
+			if !strings.HasPrefix(p, pat.base+"/") {
+				continue
+			}
+			relative = p[len(pat.base)+1:]
+		}
+
+		var matched bool
+		if pat.anchored {
+			matched = wildmatch.Match(pat.glob, relative, wildmatch.PathName)
+		} else {
+			matched = wildmatch.Match(pat.glob, path.Base(relative), wildmatch.PathName)
+		}
+
+		if matched {
+			return !pat.negated
+		}
+	}
+
+	return false
+}
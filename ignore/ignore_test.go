@@ -0,0 +1,49 @@
+package ignore
+
+import "testing"
+
+func TestIgnored(t *testing.T) {
+	m := New()
+	m.AddPatterns("", []byte(`# comment
+*.o
+!keep.o
+/build
+logs/
+doc/**/*.pdf
+\#hash
+`))
+	m.AddPatterns("sub", []byte(`local.txt
+!*.o
+`))
+
+	var tests = []struct {
+		path     string
+		isDir    bool
+		expected bool
+	}{
+		{"main.o", false, true},
+		{"src/main.o", false, true},
+		{"keep.o", false, false},
+		{"build", true, true},
+		{"build/out.txt", false, true},
+		{"src/build", true, false},
+		{"logs", false, false},
+		{"logs", true, true},
+		{"a/logs/today.txt", false, true},
+		{"doc/a/b/manual.pdf", false, true},
+		{"doc/manual.pdf", false, true},
+		{"manual.pdf", false, false},
+		{"#hash", false, true},
+		{"sub/local.txt", false, true},
+		{"local.txt", false, false},
+		{"sub/main.o", false, false},
+		{"README.md", false, false},
+	}
+
+	for i, test := range tests {
+		actual := m.Ignored(test.path, test.isDir)
+		if actual != test.expected {
+			t.Errorf("test %d failed: Ignored(%s) expected %v but got %v", i, test.path, test.expected, actual)
+		}
+	}
+}
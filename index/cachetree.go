@@ -0,0 +1,209 @@
+package index
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/neocortical/got/objectformat"
+)
+
+var errInvalidCacheTree = errors.New("invalid cache-tree (TREE) extension")
+
+// CacheTree mirrors the TREE extension: a cache of the tree OIDs that the
+// index entries produce, one node per directory. A node with a negative entry
+// count has been invalidated and must be recomputed.
+type CacheTree struct {
+	name       string
+	entryCount int
+	oid        string
+	subtrees   []*CacheTree
+	dirty      bool
+}
+
+func parseCacheTree(data []byte, f *objectformat.Format) (result *CacheTree, err error) {
+	r := bytes.NewBuffer(data)
+
+	result, err = parseCacheTreeNode(r, f)
+	if err != nil {
+		return nil, err
+	}
+	if r.Len() > 0 {
+		return nil, errInvalidCacheTree
+	}
+
+	return
+}
+
+func parseCacheTreeNode(r *bytes.Buffer, f *objectformat.Format) (result *CacheTree, err error) {
+	name, err := r.ReadString('\x00')
+	if err != nil {
+		return nil, errInvalidCacheTree
+	}
+
+	counts, err := r.ReadString('\n')
+	if err != nil {
+		return nil, errInvalidCacheTree
+	}
+
+	fields := strings.Fields(counts)
+	if len(fields) != 2 {
+		return nil, errInvalidCacheTree
+	}
+
+	entryCount, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return nil, errInvalidCacheTree
+	}
+	subtreeCount, err := strconv.Atoi(fields[1])
+	if err != nil || subtreeCount < 0 {
+		return nil, errInvalidCacheTree
+	}
+
+	result = &CacheTree{
+		name:       name[:len(name)-1],
+		entryCount: entryCount,
+	}
+
+	if entryCount >= 0 {
+		oid := r.Next(f.Size())
+		if len(oid) != f.Size() {
+			return nil, errInvalidCacheTree
+		}
+		result.oid = hex.EncodeToString(oid)
+	}
+
+	for i := 0; i < subtreeCount; i++ {
+		subtree, err := parseCacheTreeNode(r, f)
+		if err != nil {
+			return nil, err
+		}
+		result.subtrees = append(result.subtrees, subtree)
+	}
+	result.sortSubtrees()
+
+	return result, nil
+}
+
+func (ct *CacheTree) serialize(buf *bytes.Buffer) {
+	buf.WriteString(ct.name)
+	buf.WriteByte('\x00')
+	buf.WriteString(fmt.Sprintf("%d %d\n", ct.entryCount, len(ct.subtrees)))
+	if ct.entryCount >= 0 {
+		oidBytes, _ := hex.DecodeString(ct.oid)
+		buf.Write(oidBytes)
+	}
+
+	for _, subtree := range ct.subtrees {
+		subtree.serialize(buf)
+	}
+}
+
+// sortSubtrees orders subtrees the way git does: shorter names first, then
+// bytewise.
+func (ct *CacheTree) sortSubtrees() {
+	sort.Slice(ct.subtrees, func(i, j int) bool {
+		a, b := ct.subtrees[i].name, ct.subtrees[j].name
+		if len(a) != len(b) {
+			return len(a) < len(b)
+		}
+		return a < b
+	})
+}
+
+// Valid reports whether the cached OID for this directory can be trusted.
+func (ct *CacheTree) Valid() bool {
+	return ct.entryCount >= 0
+}
+
+func (ct *CacheTree) OID() string {
+	return ct.oid
+}
+
+func (ct *CacheTree) EntryCount() int {
+	return ct.entryCount
+}
+
+// Subtree returns the node for the named child directory, adding an invalid
+// node if there isn't one yet.
+func (ct *CacheTree) Subtree(name string) *CacheTree {
+	if subtree := ct.findSubtree(name); subtree != nil {
+		return subtree
+	}
+
+	subtree := &CacheTree{name: name, entryCount: -1, dirty: true}
+	ct.subtrees = append(ct.subtrees, subtree)
+	ct.sortSubtrees()
+	ct.dirty = true
+
+	return subtree
+}
+
+func (ct *CacheTree) findSubtree(name string) *CacheTree {
+	for _, subtree := range ct.subtrees {
+		if subtree.name == name {
+			return subtree
+		}
+	}
+
+	return nil
+}
+
+// Update records the OID of the tree just built for this directory along with
+// the number of index entries beneath it. Cached subtrees for directories that
+// no longer exist are pruned.
+func (ct *CacheTree) Update(oid string, entryCount int, subtreeNames []string) {
+	names := map[string]bool{}
+	for _, name := range subtreeNames {
+		names[name] = true
+	}
+
+	var subtrees []*CacheTree
+	for _, subtree := range ct.subtrees {
+		if names[subtree.name] {
+			subtrees = append(subtrees, subtree)
+		}
+	}
+
+	ct.subtrees = subtrees
+	ct.oid = oid
+	ct.entryCount = entryCount
+	ct.dirty = true
+}
+
+// invalidate marks this node and the nodes for each of the given directories
+// (as returned by Entry.ParentDirectories) as needing to be rebuilt.
+func (ct *CacheTree) invalidate(dirs []string) {
+	node := ct
+	for i := 0; node != nil; i++ {
+		if node.entryCount >= 0 {
+			node.entryCount = -1
+			node.oid = ""
+			node.dirty = true
+		}
+
+		if i == len(dirs) {
+			break
+		}
+		node = node.findSubtree(filepath.Base(dirs[i]))
+	}
+}
+
+func (ct *CacheTree) isDirty() bool {
+	if ct.dirty {
+		return true
+	}
+
+	for _, subtree := range ct.subtrees {
+		if subtree.isDirty() {
+			return true
+		}
+	}
+
+	return false
+}
@@ -8,10 +8,11 @@ import (
 	"os"
 	"path"
 	"path/filepath"
-	"syscall"
 )
 
-type entryHeader struct {
+// entryStat is the part of an entry header before the object ID, whose length
+// depends on the repository's object format.
+type entryStat struct {
 	CtimeSec  uint32
 	CtimeNsec uint32
 	MtimeSec  uint32
@@ -22,21 +23,42 @@ type entryHeader struct {
 	UID       uint32
 	GID       uint32
 	Size      uint32
-	OID       [20]byte
-	Flags     uint16
 }
 
+type entryHeader struct {
+	entryStat
+	Flags uint16
+}
+
+const (
+	flagAssumeValid = 0x8000
+	flagExtended    = 0x4000
+	flagStageMask   = 0x3000
+	flagStageShift  = 12
+	flagNameMask    = 0x0fff
+
+	extFlagSkipWorktree = 0x4000
+	extFlagIntentToAdd  = 0x2000
+	extFlagsSupported   = extFlagSkipWorktree | extFlagIntentToAdd
+
+	// entryHeaderSize is the encoded size of an entry header with a SHA-1
+	// object ID, excluding extended flags.
+	entryHeaderSize = 62
+)
+
 type Entry struct {
-	header   entryHeader
-	name     string
-	pathname string
-	oid      string
+	header        entryHeader
+	extendedFlags uint16
+	name          string
+	pathname      string
+	oid           string
+
+	// fresh entries were hashed from the workspace during this session, so
+	// their stat data can't be racily clean with respect to the loaded index.
+	fresh bool
 }
 
 func NewEntry(pathname string, oid string, stat os.FileInfo) *Entry {
-	// TODO: system-dependent filestat info
-	statT := stat.Sys().(*syscall.Stat_t)
-
 	var mode = entryModeRegular
 	if stat.Mode().Perm()&0100 != 0 {
 		mode = entryModeExecutable
@@ -47,22 +69,14 @@ func NewEntry(pathname string, oid string, stat os.FileInfo) *Entry {
 		pathlength = maxPathSize
 	}
 
-	oidBytes, _ := hex.DecodeString(oid)
-
 	header := entryHeader{
-		CtimeSec:  uint32(statT.Ctimespec.Sec),
-		CtimeNsec: uint32(statT.Ctimespec.Nsec),
-		MtimeSec:  uint32(statT.Mtimespec.Sec),
-		MtimeNsec: uint32(statT.Mtimespec.Nsec),
-		Device:    uint32(statT.Dev),
-		INode:     uint32(statT.Ino),
-		Mode:      uint32(mode),
-		UID:       uint32(statT.Uid),
-		GID:       uint32(statT.Gid),
-		Size:      uint32(statT.Size),
-		Flags:     uint16(pathlength),
+		entryStat: entryStat{
+			Mode: uint32(mode),
+			Size: uint32(stat.Size()),
+		},
+		Flags: uint16(pathlength) & flagNameMask,
 	}
-	copy(header.OID[:], oidBytes)
+	fillStatFields(&header, stat)
 
 	return &Entry{
 		header:   header,
@@ -72,6 +86,30 @@ func NewEntry(pathname string, oid string, stat os.FileInfo) *Entry {
 	}
 }
 
+// NewTreeEntry returns an entry for a file read from a tree rather than the
+// workspace. It has no stat data, so it never looks up to date.
+func NewTreeEntry(pathname string, oid string, executable bool) *Entry {
+	var mode = entryModeRegular
+	if executable {
+		mode = entryModeExecutable
+	}
+
+	var pathlength = len(pathname)
+	if pathlength > maxPathSize {
+		pathlength = maxPathSize
+	}
+
+	return &Entry{
+		header: entryHeader{
+			entryStat: entryStat{Mode: uint32(mode)},
+			Flags:     uint16(pathlength) & flagNameMask,
+		},
+		name:     filepath.Base(pathname),
+		pathname: pathname,
+		oid:      oid,
+	}
+}
+
 func (e *Entry) ParentDirectories() (result []string) {
 	return parentDirectoriesForPath(e.pathname)
 }
@@ -98,13 +136,37 @@ func (e *Entry) Path() string {
 	return e.pathname
 }
 
-func (e *Entry) Encode() []byte {
+// encode serializes the entry for the given index version. Version 4 indexes
+// compress each pathname against the pathname of the previous entry.
+func (e *Entry) encode(version uint32, previousPath string) []byte {
 	buf := new(bytes.Buffer)
 
-	err := binary.Write(buf, binary.BigEndian, e.header)
+	header := e.header
+	if e.extendedFlags != 0 {
+		header.Flags |= flagExtended
+	} else {
+		header.Flags &^= flagExtended
+	}
+
+	err := binary.Write(buf, binary.BigEndian, header.entryStat)
 	if err != nil {
 		fmt.Println("error writing entry header:", err)
 	}
+	oidBytes, _ := hex.DecodeString(e.oid)
+	buf.Write(oidBytes)
+	binary.Write(buf, binary.BigEndian, header.Flags)
+
+	if e.extendedFlags != 0 {
+		binary.Write(buf, binary.BigEndian, e.extendedFlags)
+	}
+
+	if version == 4 {
+		common := commonPrefixLength(previousPath, e.pathname)
+		buf.Write(encodeVarint(uint64(len(previousPath) - common)))
+		buf.WriteString(e.pathname[common:])
+		buf.WriteByte('\x00')
+		return buf.Bytes()
+	}
 
 	var pathBytes = []byte(e.pathname)
 	pathBytes = append(pathBytes, '\x00')
@@ -117,6 +179,13 @@ func (e *Entry) Encode() []byte {
 	return buf.Bytes()
 }
 
+func commonPrefixLength(a, b string) (n int) {
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return
+}
+
 func (e *Entry) ModeString() string {
 	return fmt.Sprintf("%o", e.header.Mode)
 }
@@ -128,3 +197,37 @@ func (e *Entry) Name() string {
 func (e *Entry) OID() string {
 	return e.oid
 }
+
+// Stage returns the merge stage of the entry: 0 for a normal entry, or 1-3 for
+// the base, ours and theirs versions of an unmerged path.
+func (e *Entry) Stage() int {
+	return int(e.header.Flags&flagStageMask) >> flagStageShift
+}
+
+func (e *Entry) AssumeValid() bool {
+	return e.header.Flags&flagAssumeValid != 0
+}
+
+func (e *Entry) IntentToAdd() bool {
+	return e.extendedFlags&extFlagIntentToAdd != 0
+}
+
+func (e *Entry) SetIntentToAdd(val bool) {
+	e.setExtendedFlag(extFlagIntentToAdd, val)
+}
+
+func (e *Entry) SkipWorktree() bool {
+	return e.extendedFlags&extFlagSkipWorktree != 0
+}
+
+func (e *Entry) SetSkipWorktree(val bool) {
+	e.setExtendedFlag(extFlagSkipWorktree, val)
+}
+
+func (e *Entry) setExtendedFlag(flag uint16, val bool) {
+	if val {
+		e.extendedFlags |= flag
+	} else {
+		e.extendedFlags &^= flag
+	}
+}
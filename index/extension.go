@@ -0,0 +1,66 @@
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	extCacheTree   = "TREE"
+	extResolveUndo = "REUC"
+	extEndOfIndex  = "EOIE"
+	extEntryOffset = "IEOT"
+	extUntracked   = "UNTR"
+	extFSMonitor   = "FSMN"
+)
+
+// droppedExtensions describe the entry list itself (offsets, dirty bits,
+// untracked state) and become invalid as soon as we rewrite the entries, so
+// they are not carried over. Git recomputes them as needed.
+var droppedExtensions = map[string]bool{
+	extEndOfIndex:  true,
+	extEntryOffset: true,
+	extUntracked:   true,
+	extFSMonitor:   true,
+}
+
+// extension is an index extension that we don't interpret but must preserve.
+type extension struct {
+	signature string
+	data      []byte
+}
+
+func isOptionalExtension(signature string) bool {
+	return signature[0] >= 'A' && signature[0] <= 'Z'
+}
+
+func readExtension(r *bytes.Reader) (signature string, data []byte, err error) {
+	var header struct {
+		Signature [4]byte
+		Size      uint32
+	}
+	err = binary.Read(r, binary.BigEndian, &header)
+	if err != nil {
+		return "", nil, fmt.Errorf("error reading index extension header: %w", err)
+	}
+
+	if int64(header.Size) > int64(r.Len()) {
+		return "", nil, fmt.Errorf("index extension '%s' is truncated", header.Signature[:])
+	}
+
+	data = make([]byte, header.Size)
+	_, err = io.ReadFull(r, data)
+	if err != nil {
+		return "", nil, fmt.Errorf("error reading index extension '%s': %w", header.Signature[:], err)
+	}
+
+	return string(header.Signature[:]), data, nil
+}
+
+func writeExtension(buf *bytes.Buffer, signature string, data []byte) {
+	buf.WriteString(signature)
+	binary.Write(buf, binary.BigEndian, uint32(len(data)))
+	buf.Write(data)
+}
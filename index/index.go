@@ -2,8 +2,8 @@ package index
 
 import (
 	"bytes"
-	"crypto/sha1"
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -11,8 +11,10 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"time"
 
 	"github.com/neocortical/got/lock"
+	"github.com/neocortical/got/objectformat"
 )
 
 const (
@@ -20,6 +22,10 @@ const (
 	entryModeRegular    = 0100644
 	entryModeExecutable = 0100755
 	maxPathSize         = 0xfff
+
+	defaultVersion = 2
+	minVersion     = 2
+	maxVersion     = 4
 )
 
 var lockConflictErrTemplate = `%v
@@ -40,29 +46,70 @@ type Index interface {
 	LoadForUpdate() error
 	Load() error
 	Add(e *Entry)
+	Remove(path string)
 	WriteUpdates() error
 	Entries() []*Entry
+	AllEntries() []*Entry
 	Rollback()
 	IsTracked(path string) bool
 	FirstUntrackedPath(path string) string
-	IsMetadataModified(path string, info os.FileInfo) (statsModified, timesModified bool)
+	IsMetadataModified(path string, info os.FileInfo) (statsModified, contentUncertain bool)
+	SetStatOptions(opts StatOptions)
+	SetFsync(enabled bool)
+	SetObjectFormat(f *objectformat.Format)
 	GetEntry(path string) (e *Entry, exists bool)
+	Version() int
+	SetVersion(version int) error
+	Unmerged(path string) []*Entry
+	AddConflict(path string, stages [3]*Entry)
+	ResolveUndo() []*ResolveUndoEntry
+	CacheTree() *CacheTree
 }
 
 type index struct {
-	l         *lock.Lockfile
-	filename  string
-	entryMap  map[string]*Entry
-	parentMap map[string][]string
-	changed   bool
+	l           *lock.Lockfile
+	filename    string
+	version     uint32
+	entryMap    map[string]*Entry
+	parentMap   map[string][]string
+	unmerged    map[string][]*Entry
+	cacheTree   *CacheTree
+	resolveUndo map[string]*ResolveUndoEntry
+	extensions  []extension
+	changed     bool
+	timestamp   time.Time
+	statOptions StatOptions
+	fsync       bool
+	format      *objectformat.Format
 }
 
 func NewIndex(idxFilename string) Index {
-	return &index{filename: idxFilename, entryMap: map[string]*Entry{}, parentMap: map[string][]string{}}
+	return &index{
+		filename:    idxFilename,
+		version:     defaultVersion,
+		entryMap:    map[string]*Entry{},
+		parentMap:   map[string][]string{},
+		unmerged:    map[string][]*Entry{},
+		resolveUndo: map[string]*ResolveUndoEntry{},
+		statOptions: DefaultStatOptions(),
+		format:      objectformat.Default,
+	}
+}
+
+// SetFsync makes WriteUpdates flush the new index to stable storage.
+func (idx *index) SetFsync(enabled bool) {
+	idx.fsync = enabled
+}
+
+// SetObjectFormat sets the format of the object IDs in the index, which is
+// also the hash of its checksum.
+func (idx *index) SetObjectFormat(f *objectformat.Format) {
+	idx.format = f
 }
 
 func (idx *index) LoadForUpdate() (err error) {
 	l := lock.NewLockfile(idx.filename)
+	l.SetFsync(idx.fsync)
 	err = l.Acquire()
 	if lock.IsLockConflict(err) {
 		return fmt.Errorf(lockConflictErrTemplate, err)
@@ -87,12 +134,20 @@ func (idx *index) Load() (err error) {
 		return fmt.Errorf("error reading index file: %w", err)
 	}
 
+	info, err := os.Stat(idx.filename)
+	if err != nil {
+		return fmt.Errorf("error reading index file: %w", err)
+	}
+	idx.timestamp = info.ModTime()
+
 	// verify checksum
-	if len(fileData) <= 20 {
+	checksumLength := idx.format.Size()
+	if len(fileData) <= checksumLength {
 		return errors.New("invalid index file format")
 	}
-	if !verifySHAsMatch(generateSHA(fileData[:len(fileData)-20]), fileData[len(fileData)-20:]) {
-		return fmt.Errorf("Index file checksum mismatch (%x != %x)", generateSHA(fileData[:len(fileData)-20]), fileData[len(fileData)-20:])
+	body, checksum := fileData[:len(fileData)-checksumLength], fileData[len(fileData)-checksumLength:]
+	if !verifySHAsMatch(idx.generateSHA(body), checksum) {
+		return fmt.Errorf("Index file checksum mismatch (%x != %x)", idx.generateSHA(body), checksum)
 	}
 
 	f := bytes.NewBuffer(fileData)
@@ -106,32 +161,78 @@ func (idx *index) Load() (err error) {
 	if string(header.Signature[:]) != signature {
 		return fmt.Errorf("invalid file signature bytes: %v", header.Signature)
 	}
-	if header.Version != 2 {
+	if header.Version < minVersion || header.Version > maxVersion {
 		return fmt.Errorf("invalid index file version: %d", header.Version)
 	}
+	idx.version = header.Version
 
+	r := bytes.NewReader(f.Bytes()[:f.Len()-checksumLength])
+
+	var previousPath string
 	for i := 0; i < int(header.Entries); i++ {
-		var entry Entry
-		entry, err = readNextEntry(f)
+		var entry *Entry
+		entry, err = readEntry(r, header.Version, idx.format, previousPath)
 		if err != nil {
 			return
 		}
+		previousPath = entry.pathname
+
+		if entry.Stage() > 0 {
+			idx.unmerged[entry.pathname] = append(idx.unmerged[entry.pathname], entry)
+			continue
+		}
 
-		idx.entryMap[entry.pathname] = &entry
+		idx.entryMap[entry.pathname] = entry
 		for _, dir := range entry.ParentDirectories() {
 			idx.parentMap[dir] = append(idx.parentMap[dir], entry.pathname)
 		}
 	}
 
+	for r.Len() > 0 {
+		signature, data, err := readExtension(r)
+		if err != nil {
+			return err
+		}
+
+		err = idx.loadExtension(signature, data)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+func (idx *index) loadExtension(signature string, data []byte) (err error) {
+	switch signature {
+	case extCacheTree:
+		idx.cacheTree, err = parseCacheTree(data, idx.format)
+	case extResolveUndo:
+		idx.resolveUndo, err = parseResolveUndo(data, idx.format)
+	default:
+		if !isOptionalExtension(signature) {
+			return fmt.Errorf("index uses %s extension, which we do not understand", signature)
+		}
+		idx.extensions = append(idx.extensions, extension{signature, data})
+	}
+
+	return
+}
+
 func (i *index) Add(entry *Entry) {
-	if existing, exists := i.entryMap[entry.pathname]; exists && existing.oid == entry.oid {
+	entry.fresh = true
+
+	if existing, exists := i.entryMap[entry.pathname]; exists && existing.oid == entry.oid && existing.header.Mode == entry.header.Mode {
+		i.refresh(existing, entry)
 		return
 	}
 
 	i.removeConflicts(entry)
+	i.resolve(entry.pathname)
+
+	if i.cacheTree != nil {
+		i.cacheTree.invalidate(entry.ParentDirectories())
+	}
 
 	i.entryMap[entry.pathname] = entry
 	for _, dir := range entry.ParentDirectories() {
@@ -154,6 +255,61 @@ func (i *index) removeConflicts(entry *Entry) {
 	delete(i.parentMap, entry.pathname)
 }
 
+// Remove drops a path from the index, along with any unmerged stages.
+func (i *index) Remove(path string) {
+	_, tracked := i.entryMap[path]
+	_, unmerged := i.unmerged[path]
+	if !tracked && !unmerged {
+		return
+	}
+
+	delete(i.entryMap, path)
+	delete(i.unmerged, path)
+
+	for _, dir := range parentDirectoriesForPath(path) {
+		var remaining []string
+		for _, p := range i.parentMap[dir] {
+			if p != path {
+				remaining = append(remaining, p)
+			}
+		}
+
+		if len(remaining) == 0 {
+			delete(i.parentMap, dir)
+		} else {
+			i.parentMap[dir] = remaining
+		}
+	}
+
+	if i.cacheTree != nil {
+		i.cacheTree.invalidate(parentDirectoriesForPath(path))
+	}
+
+	i.changed = true
+}
+
+// resolve records the unmerged stages of a path in the resolve-undo list once
+// a stage 0 entry replaces them.
+func (i *index) resolve(path string) {
+	stages, unmerged := i.unmerged[path]
+	if !unmerged {
+		return
+	}
+
+	reuc := &ResolveUndoEntry{Path: path}
+	for _, e := range stages {
+		stage := e.Stage() - 1
+		reuc.Modes[stage] = e.header.Mode
+		reuc.OIDs[stage] = e.oid
+	}
+
+	if i.resolveUndo == nil {
+		i.resolveUndo = map[string]*ResolveUndoEntry{}
+	}
+	i.resolveUndo[path] = reuc
+	delete(i.unmerged, path)
+}
+
 func (i *index) Entries() (result []*Entry) {
 	var entrynames []string
 	for k := range i.entryMap {
@@ -168,25 +324,72 @@ func (i *index) Entries() (result []*Entry) {
 	return
 }
 
+// AllEntries returns every entry in index order, including unmerged ones,
+// which sort by path and then by stage.
+func (i *index) AllEntries() (result []*Entry) {
+	result = i.Entries()
+	for _, stages := range i.unmerged {
+		result = append(result, stages...)
+	}
+
+	sort.SliceStable(result, func(a, b int) bool {
+		if result[a].pathname != result[b].pathname {
+			return result[a].pathname < result[b].pathname
+		}
+		return result[a].Stage() < result[b].Stage()
+	})
+
+	return
+}
+
 func (i *index) WriteUpdates() (err error) {
-	if !i.changed {
+	if !i.changed && (i.cacheTree == nil || !i.cacheTree.isDirty()) {
 		if i.l != nil {
 			err = i.l.Rollback()
 		}
 		return
 	}
 
+	entries := i.AllEntries()
+	i.smudgeRacyEntries(entries)
+
+	version := i.version
+	if version < 3 {
+		for _, e := range entries {
+			if e.extendedFlags != 0 {
+				version = 3
+				break
+			}
+		}
+	}
+
 	buf := new(bytes.Buffer)
 
 	header := header{
 		Signature: [4]byte{'D', 'I', 'R', 'C'},
-		Version:   2,
-		Entries:   uint32(len(i.entryMap)),
+		Version:   version,
+		Entries:   uint32(len(entries)),
 	}
 	binary.Write(buf, binary.BigEndian, &header)
 
-	for _, e := range i.Entries() {
-		buf.Write(e.Encode())
+	var previousPath string
+	for _, e := range entries {
+		buf.Write(e.encode(version, previousPath))
+		previousPath = e.pathname
+	}
+
+	if i.cacheTree != nil {
+		data := new(bytes.Buffer)
+		i.cacheTree.serialize(data)
+		writeExtension(buf, extCacheTree, data.Bytes())
+	}
+	if len(i.resolveUndo) > 0 {
+		writeExtension(buf, extResolveUndo, serializeResolveUndo(i.resolveUndo))
+	}
+	for _, ext := range i.extensions {
+		if !droppedExtensions[ext.signature] {
+			writeExtension(buf, ext.signature, ext.data)
+		}
 	}
 
 	data := buf.Bytes()
@@ -195,7 +398,7 @@ func (i *index) WriteUpdates() (err error) {
 		return
 	}
 
-	err = i.l.Write(generateSHA(data))
+	err = i.l.Write(i.generateSHA(data))
 	if err != nil {
 		return
 	}
@@ -204,8 +407,8 @@ func (i *index) WriteUpdates() (err error) {
 	return
 }
 
-func generateSHA(data []byte) []byte {
-	hasher := sha1.New()
+func (i *index) generateSHA(data []byte) []byte {
+	hasher := i.format.New()
 	hasher.Write(data)
 	return hasher.Sum(nil)
 }
@@ -231,61 +434,78 @@ func readNextBytes(file *os.File, length int) ([]byte, error) {
 	return bytes, err
 }
 
-func readNextEntry(r io.Reader) (result Entry, err error) {
-	var header entryHeader
-	err = binary.Read(r, binary.BigEndian, &header)
+func readEntry(r *bytes.Reader, version uint32, f *objectformat.Format, previousPath string) (result *Entry, err error) {
+	result = &Entry{}
+
+	err = binary.Read(r, binary.BigEndian, &result.header.entryStat)
 	if err != nil {
-		return result, fmt.Errorf("error reading entry header: %w", err)
+		return nil, fmt.Errorf("error reading entry header: %w", err)
+	}
+	oid := make([]byte, f.Size())
+	if _, err = io.ReadFull(r, oid); err != nil {
+		return nil, fmt.Errorf("error reading entry header: %w", err)
+	}
+	result.oid = hex.EncodeToString(oid)
+	err = binary.Read(r, binary.BigEndian, &result.header.Flags)
+	if err != nil {
+		return nil, fmt.Errorf("error reading entry header: %w", err)
 	}
 
-	// fmt.Printf("ctime: %d, %d\nmtime: %d, %d\noid: %x\nflags: %d\n", header.CtimeSec, header.CtimeNsec, header.MtimeSec, header.MtimeNsec, header.OID, header.Flags)
+	headerSize := entryHeaderSize + f.Size() - objectformat.SHA1.Size()
+	if result.header.Flags&flagExtended != 0 {
+		if version < 3 {
+			return nil, fmt.Errorf("extended entry flags in version %d index", version)
+		}
 
-	if header.Flags < maxPathSize {
-		pathnameBytes := make([]byte, header.Flags)
-		_, err = r.Read(pathnameBytes)
+		err = binary.Read(r, binary.BigEndian, &result.extendedFlags)
 		if err != nil {
-			return result, fmt.Errorf("error reading %d pathname bytes: %w", header.Flags, err)
+			return nil, fmt.Errorf("error reading extended entry flags: %w", err)
 		}
-
-		result.pathname = string(pathnameBytes)
-		result.header = header
-		result.oid = fmt.Sprintf("%x", header.OID[:])
-
-		// advance past nulls
-
-		nullsToRead := ((8 - (63+header.Flags)%8) % 8) + 1
-		// fmt.Println("consuming nulls", nullsToRead)
-		if nullsToRead > 0 {
-			var nullReader = make([]byte, calculatePathnameNullsDoRead(header.Flags))
-			_, err = r.Read(nullReader)
-			if err != nil {
-				return result, fmt.Errorf("error consuming %d nulls: %w", nullsToRead, err)
-			}
+		if result.extendedFlags&^extFlagsSupported != 0 {
+			return nil, fmt.Errorf("unknown extended entry flags: %#x", result.extendedFlags)
 		}
+		headerSize += 2
+	}
 
-		return
+	var stripLength uint64
+	if version == 4 {
+		stripLength, err = decodeVarint(r)
+		if err != nil {
+			return nil, err
+		}
+		if stripLength > uint64(len(previousPath)) {
+			return nil, fmt.Errorf("malformed name field in index near '%s'", previousPath)
+		}
 	}
 
-	var path []byte
-	var pathBuf = make([]byte, 8)
+	var pathBytes []byte
 	for {
-		_, err = r.Read(pathBuf)
+		var c byte
+		c, err = r.ReadByte()
 		if err != nil {
-			return result, fmt.Errorf("error reading next 8 bytes of pathname: %w", err)
+			return nil, fmt.Errorf("error reading entry pathname: %w", err)
+		}
+		if c == '\x00' {
+			break
 		}
+		pathBytes = append(pathBytes, c)
+	}
 
-		if pathBuf[7] == 0x00 {
-			for i := range pathBuf {
-				if pathBuf[i] == 0x00 {
-					result.pathname = string(path)
-					return
-				}
-				path = append(path, pathBuf[i])
-			}
-		} else {
-			path = append(path, pathBuf...)
+	if version == 4 {
+		result.pathname = previousPath[:len(previousPath)-int(stripLength)] + string(pathBytes)
+	} else {
+		result.pathname = string(pathBytes)
+
+		// advance past the remaining padding nulls
+		nullsToRead := calculatePathnameNullsDoRead(uint16(len(pathBytes)+headerSize-entryHeaderSize)) - 1
+		if _, err = r.Seek(int64(nullsToRead), io.SeekCurrent); err != nil {
+			return nil, fmt.Errorf("error consuming %d nulls: %w", nullsToRead, err)
 		}
 	}
+
+	result.name = filepath.Base(result.pathname)
+
+	return
 }
 
 func calculatePathnameNullsDoRead(headerFlags uint16) int {
@@ -300,6 +520,9 @@ func (i *index) Rollback() {
 
 func (i *index) IsTracked(path string) (result bool) {
 	_, result = i.entryMap[path]
+	if !result {
+		_, result = i.unmerged[path]
+	}
 	return
 }
 
@@ -317,24 +540,70 @@ func (i *index) FirstUntrackedPath(path string) string {
 	return path
 }
 
-func (i *index) IsMetadataModified(path string, info os.FileInfo) (statsModified, timesModified bool) {
-	if !i.IsTracked(path) {
-		return false, false
+func (i *index) GetEntry(path string) (e *Entry, exists bool) {
+	e, exists = i.entryMap[path]
+	return
+}
+
+func (i *index) Version() int {
+	return int(i.version)
+}
+
+// SetVersion changes the format version the index will be written in.
+func (i *index) SetVersion(version int) error {
+	if version < minVersion || version > maxVersion {
+		return fmt.Errorf("index-version %d not in range: %d..%d", version, minVersion, maxVersion)
 	}
 
-	existingEntry := i.entryMap[path]
-	testEntry := NewEntry(path, "", info)
+	if uint32(version) != i.version {
+		i.version = uint32(version)
+		i.changed = true
+	}
 
-	statsModified = existingEntry.header.Mode != testEntry.header.Mode || existingEntry.header.Size != existingEntry.header.Size
-	timesModified = existingEntry.header.CtimeSec != testEntry.header.CtimeSec ||
-		existingEntry.header.CtimeNsec != testEntry.header.CtimeNsec ||
-		existingEntry.header.MtimeSec != testEntry.header.MtimeSec ||
-		existingEntry.header.MtimeNsec != testEntry.header.MtimeNsec
+	return nil
+}
 
-	return
+// Unmerged returns the conflicting stages recorded for a path, if any.
+func (i *index) Unmerged(path string) []*Entry {
+	return i.unmerged[path]
 }
 
-func (i *index) GetEntry(path string) (e *Entry, exists bool) {
-	e, exists = i.entryMap[path]
+// AddConflict replaces a path with unmerged entries for its base, ours and
+// theirs versions, any of which may be missing.
+func (i *index) AddConflict(path string, stages [3]*Entry) {
+	i.Remove(path)
+	for n, e := range stages {
+		if e != nil {
+			e.header.Flags = e.header.Flags&^flagStageMask | uint16(n+1)<<flagStageShift
+			i.unmerged[path] = append(i.unmerged[path], e)
+		}
+	}
+
+	if i.cacheTree != nil {
+		i.cacheTree.invalidate(parentDirectoriesForPath(path))
+	}
+	i.changed = true
+}
+
+func (i *index) ResolveUndo() (result []*ResolveUndoEntry) {
+	for _, e := range i.resolveUndo {
+		result = append(result, e)
+	}
+
+	sort.Slice(result, func(a, b int) bool {
+		return result[a].Path < result[b].Path
+	})
+
 	return
 }
+
+// CacheTree returns the root of the index's cache tree, starting an empty one
+// if the index doesn't have one yet. Nodes that are updated through it are
+// written out with the index.
+func (i *index) CacheTree() *CacheTree {
+	if i.cacheTree == nil {
+		i.cacheTree = &CacheTree{entryCount: -1, dirty: true}
+	}
+
+	return i.cacheTree
+}
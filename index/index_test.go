@@ -1,7 +1,14 @@
 package index
 
 import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/neocortical/got/objectformat"
 )
 
 func TestCalculatePathnameNullsDoRead(t *testing.T) {
@@ -78,3 +85,378 @@ func TestFileReplacesDirectory(t *testing.T) {
 		}
 	}
 }
+
+func TestVarintRoundTrip(t *testing.T) {
+	var tests = []struct {
+		value    uint64
+		expected []byte
+	}{
+		{0, []byte{0x00}},
+		{127, []byte{0x7f}},
+		{128, []byte{0x80, 0x00}},
+		{255, []byte{0x80, 0x7f}},
+		{16511, []byte{0xff, 0x7f}},
+		{16512, []byte{0x80, 0x80, 0x00}},
+	}
+
+	for i, test := range tests {
+		actual := encodeVarint(test.value)
+		if !bytes.Equal(actual, test.expected) {
+			t.Errorf("test %d failed: expected %x but got %x", i, test.expected, actual)
+		}
+
+		decoded, err := decodeVarint(bytes.NewReader(actual))
+		if err != nil {
+			t.Errorf("test %d failed: unexpected error: %v", i, err)
+		}
+		if decoded != test.value {
+			t.Errorf("test %d failed: expected %d but got %d", i, test.value, decoded)
+		}
+	}
+}
+
+func setUpTestIndex(t *testing.T) (idx *index, dir string) {
+	dir, err := ioutil.TempDir("", "got_test_index_*")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+
+	return NewIndex(filepath.Join(dir, "index")).(*index), dir
+}
+
+func addTestEntry(t *testing.T, idx Index, dir, pathname, oid string) *Entry {
+	filename := filepath.Join(dir, "stat_source")
+	err := ioutil.WriteFile(filename, []byte(pathname), 0644)
+	if err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatalf("error stat-ing file: %v", err)
+	}
+
+	e := NewEntry(pathname, oid, info)
+	idx.Add(e)
+	return e
+}
+
+func reloadTestIndex(t *testing.T, idx *index) *index {
+	reloaded := NewIndex(idx.filename).(*index)
+	err := reloaded.Load()
+	if err != nil {
+		t.Fatalf("error reloading index: %v", err)
+	}
+
+	return reloaded
+}
+
+func TestWriteAndReadIndexVersions(t *testing.T) {
+	paths := []string{"README.md", "a/b/deep.txt", "a/b/deeper.txt", "a/c.txt", strings.Repeat("x", 70)}
+
+	for _, version := range []int{2, 3, 4} {
+		idx, dir := setUpTestIndex(t)
+		defer os.RemoveAll(dir)
+
+		if err := idx.LoadForUpdate(); err != nil {
+			t.Fatalf("error loading index: %v", err)
+		}
+		if err := idx.SetVersion(version); err != nil {
+			t.Fatalf("error setting version: %v", err)
+		}
+
+		for _, p := range paths {
+			e := addTestEntry(t, idx, dir, p, "30f51a3fba5274d53522d0f19748456974647b4f")
+			if p == "a/c.txt" && version > 2 {
+				e.SetIntentToAdd(true)
+			}
+		}
+
+		if err := idx.WriteUpdates(); err != nil {
+			t.Fatalf("error writing index: %v", err)
+		}
+
+		reloaded := reloadTestIndex(t, idx)
+		if reloaded.Version() != version {
+			t.Errorf("expected version %d but got %d", version, reloaded.Version())
+		}
+
+		entries := reloaded.Entries()
+		if len(entries) != len(paths) {
+			t.Fatalf("v%d: expected %d entries but got %d", version, len(paths), len(entries))
+		}
+		for i, e := range entries {
+			if e.Path() != paths[i] {
+				t.Errorf("v%d: expected path %s but got %s", version, paths[i], e.Path())
+			}
+			if e.OID() != "30f51a3fba5274d53522d0f19748456974647b4f" {
+				t.Errorf("v%d: unexpected oid for %s: %s", version, e.Path(), e.OID())
+			}
+			if e.IntentToAdd() != (e.Path() == "a/c.txt" && version > 2) {
+				t.Errorf("v%d: unexpected intent-to-add flag for %s", version, e.Path())
+			}
+		}
+	}
+}
+
+func TestWriteAndReadSHA256Index(t *testing.T) {
+	oid := objectformat.SHA256.Sum([]byte("blob 0\x00"))
+	paths := []string{"README.md", "a/b.txt", strings.Repeat("y", 37)}
+
+	for _, version := range []int{2, 3, 4} {
+		idx, dir := setUpTestIndex(t)
+		defer os.RemoveAll(dir)
+		idx.SetObjectFormat(objectformat.SHA256)
+
+		if err := idx.LoadForUpdate(); err != nil {
+			t.Fatalf("error loading index: %v", err)
+		}
+		if err := idx.SetVersion(version); err != nil {
+			t.Fatalf("error setting version: %v", err)
+		}
+		for _, p := range paths {
+			e := addTestEntry(t, idx, dir, p, oid)
+			e.SetIntentToAdd(version > 2 && p == "a/b.txt")
+		}
+		if err := idx.WriteUpdates(); err != nil {
+			t.Fatalf("error writing index: %v", err)
+		}
+
+		reloaded := NewIndex(idx.filename).(*index)
+		reloaded.SetObjectFormat(objectformat.SHA256)
+		if err := reloaded.Load(); err != nil {
+			t.Fatalf("v%d: error reloading index: %v", version, err)
+		}
+		entries := reloaded.Entries()
+		if len(entries) != len(paths) {
+			t.Fatalf("v%d: expected %d entries but got %d", version, len(paths), len(entries))
+		}
+		for i, e := range entries {
+			if e.Path() != paths[i] || e.OID() != oid {
+				t.Errorf("v%d: unexpected entry %s %s", version, e.Path(), e.OID())
+			}
+		}
+
+		if err := NewIndex(idx.filename).Load(); err == nil {
+			t.Errorf("v%d: expected an error reading a SHA-256 index as SHA-1", version)
+		}
+	}
+}
+
+func TestAddConflict(t *testing.T) {
+	idx, dir := setUpTestIndex(t)
+	defer os.RemoveAll(dir)
+
+	if err := idx.LoadForUpdate(); err != nil {
+		t.Fatalf("error loading index: %v", err)
+	}
+	addTestEntry(t, idx, dir, "a.txt", "30f51a3fba5274d53522d0f19748456974647b4f")
+	addTestEntry(t, idx, dir, "b.txt", "30f51a3fba5274d53522d0f19748456974647b4f")
+	idx.AddConflict("a.txt", [3]*Entry{
+		NewTreeEntry("a.txt", "1111111111111111111111111111111111111111", false),
+		nil,
+		NewTreeEntry("a.txt", "3333333333333333333333333333333333333333", true),
+	})
+	if err := idx.WriteUpdates(); err != nil {
+		t.Fatalf("error writing index: %v", err)
+	}
+
+	reloaded := reloadTestIndex(t, idx)
+	if !reloaded.IsTracked("a.txt") {
+		t.Errorf("expected a.txt to be tracked while unmerged")
+	}
+	if _, exists := reloaded.GetEntry("a.txt"); exists {
+		t.Errorf("expected a.txt to have no stage 0 entry")
+	}
+	stages := reloaded.Unmerged("a.txt")
+	if len(stages) != 2 || stages[0].Stage() != 1 || stages[1].Stage() != 3 || stages[1].ModeString() != "100755" {
+		t.Fatalf("expected stages 1 and 3 of a.txt but got: %v", stages)
+	}
+
+	// adding the path again resolves the conflict
+	addTestEntry(t, reloaded, dir, "a.txt", "30f51a3fba5274d53522d0f19748456974647b4f")
+	if len(reloaded.Unmerged("a.txt")) != 0 || len(reloaded.ResolveUndo()) != 1 {
+		t.Errorf("expected a.txt to be resolved")
+	}
+}
+
+func TestExtendedFlagsUpgradeVersion2(t *testing.T) {
+	idx, dir := setUpTestIndex(t)
+	defer os.RemoveAll(dir)
+
+	if err := idx.LoadForUpdate(); err != nil {
+		t.Fatalf("error loading index: %v", err)
+	}
+	e := addTestEntry(t, idx, dir, "sparse.txt", "30f51a3fba5274d53522d0f19748456974647b4f")
+	e.SetSkipWorktree(true)
+
+	if err := idx.WriteUpdates(); err != nil {
+		t.Fatalf("error writing index: %v", err)
+	}
+
+	reloaded := reloadTestIndex(t, idx)
+	if reloaded.Version() != 3 {
+		t.Errorf("expected version 3 but got %d", reloaded.Version())
+	}
+	if e, _ := reloaded.GetEntry("sparse.txt"); e == nil || !e.SkipWorktree() {
+		t.Errorf("expected skip-worktree entry to survive a round trip")
+	}
+}
+
+func TestExtensionsRoundTrip(t *testing.T) {
+	idx, dir := setUpTestIndex(t)
+	defer os.RemoveAll(dir)
+
+	if err := idx.LoadForUpdate(); err != nil {
+		t.Fatalf("error loading index: %v", err)
+	}
+	addTestEntry(t, idx, dir, "file.txt", "30f51a3fba5274d53522d0f19748456974647b4f")
+
+	idx.cacheTree = &CacheTree{
+		entryCount: 1,
+		oid:        "953fd4f4fc4ba2aa6680ae2dc9464c1359c17b10",
+		subtrees:   []*CacheTree{{name: "dir", entryCount: -1}},
+	}
+	idx.resolveUndo["conflicted.txt"] = &ResolveUndoEntry{
+		Path:  "conflicted.txt",
+		Modes: [3]uint32{0, 0100644, 0100755},
+		OIDs:  [3]string{"", "30f51a3fba5274d53522d0f19748456974647b4f", "953fd4f4fc4ba2aa6680ae2dc9464c1359c17b10"},
+	}
+	idx.extensions = []extension{{"ZZZZ", []byte("opaque")}, {extEndOfIndex, []byte("stale")}}
+
+	if err := idx.WriteUpdates(); err != nil {
+		t.Fatalf("error writing index: %v", err)
+	}
+
+	reloaded := reloadTestIndex(t, idx)
+
+	ct := reloaded.cacheTree
+	if ct == nil || !ct.Valid() || ct.OID() != "953fd4f4fc4ba2aa6680ae2dc9464c1359c17b10" {
+		t.Fatalf("unexpected cache tree: %+v", ct)
+	}
+	if len(ct.subtrees) != 1 || ct.subtrees[0].name != "dir" || ct.subtrees[0].Valid() {
+		t.Errorf("unexpected cache subtrees: %+v", ct.subtrees)
+	}
+
+	reuc := reloaded.ResolveUndo()
+	if len(reuc) != 1 || *reuc[0] != *idx.resolveUndo["conflicted.txt"] {
+		t.Errorf("unexpected resolve-undo entries: %+v", reuc)
+	}
+
+	if len(reloaded.extensions) != 1 || reloaded.extensions[0].signature != "ZZZZ" || string(reloaded.extensions[0].data) != "opaque" {
+		t.Errorf("expected only the unknown optional extension to be preserved but got %+v", reloaded.extensions)
+	}
+}
+
+func TestRequiredUnknownExtensionIsAnError(t *testing.T) {
+	idx := NewIndex("").(*index)
+	err := idx.loadExtension("link", nil)
+	if err == nil {
+		t.Error("expected an error for a required extension")
+	}
+}
+
+func TestIsMetadataModified(t *testing.T) {
+	idx, dir := setUpTestIndex(t)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "file.txt")
+	if err := ioutil.WriteFile(filename, []byte("one"), 0644); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	info, _ := os.Stat(filename)
+	idx.Add(NewEntry("file.txt", "43dd47ea691c90a5fa7827892c70241913351963", info))
+
+	statsModified, contentUncertain := idx.IsMetadataModified("file.txt", info)
+	if statsModified || contentUncertain {
+		t.Errorf("expected an unchanged file but got %v, %v", statsModified, contentUncertain)
+	}
+
+	if err := ioutil.WriteFile(filename, []byte("three"), 0644); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	info, _ = os.Stat(filename)
+	statsModified, _ = idx.IsMetadataModified("file.txt", info)
+	if !statsModified {
+		t.Error("expected a size change to count as a modification")
+	}
+
+	e, _ := idx.GetEntry("file.txt")
+	e.header.Size = 0
+	statsModified, contentUncertain = idx.IsMetadataModified("file.txt", info)
+	if statsModified || !contentUncertain {
+		t.Errorf("expected a smudged entry to require a content check but got %v, %v", statsModified, contentUncertain)
+	}
+}
+
+func TestStatOptions(t *testing.T) {
+	idx, dir := setUpTestIndex(t)
+	defer os.RemoveAll(dir)
+
+	e := addTestEntry(t, idx, dir, "file.txt", "43dd47ea691c90a5fa7827892c70241913351963")
+	info, _ := os.Stat(filepath.Join(dir, "stat_source"))
+
+	e.header.CtimeSec--
+	if _, uncertain := idx.IsMetadataModified("file.txt", info); !uncertain {
+		t.Error("expected a ctime change to require a content check")
+	}
+
+	idx.SetStatOptions(StatOptions{TrustCtime: false})
+	if _, uncertain := idx.IsMetadataModified("file.txt", info); uncertain {
+		t.Error("expected ctime to be ignored when it isn't trusted")
+	}
+
+	e.header.INode++
+	e.header.MtimeNsec++
+	if _, uncertain := idx.IsMetadataModified("file.txt", info); !uncertain {
+		t.Error("expected inode and nanosecond changes to require a content check")
+	}
+
+	idx.SetStatOptions(StatOptions{TrustCtime: false, MinimalStat: true})
+	if _, uncertain := idx.IsMetadataModified("file.txt", info); uncertain {
+		t.Error("expected inode and nanosecond changes to be ignored with minimal stat checking")
+	}
+}
+
+func TestRacilyCleanEntries(t *testing.T) {
+	idx, dir := setUpTestIndex(t)
+	defer os.RemoveAll(dir)
+
+	if err := idx.LoadForUpdate(); err != nil {
+		t.Fatalf("error loading index: %v", err)
+	}
+	addTestEntry(t, idx, dir, "racy.txt", "43dd47ea691c90a5fa7827892c70241913351963")
+	if err := idx.WriteUpdates(); err != nil {
+		t.Fatalf("error writing index: %v", err)
+	}
+
+	// make the index look as if it was written in the same instant the file was modified
+	info, _ := os.Stat(filepath.Join(dir, "stat_source"))
+	if err := os.Chtimes(idx.filename, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatalf("error setting index mtime: %v", err)
+	}
+
+	reloaded := NewIndex(idx.filename).(*index)
+	if err := reloaded.LoadForUpdate(); err != nil {
+		t.Fatalf("error loading index: %v", err)
+	}
+
+	statsModified, contentUncertain := reloaded.IsMetadataModified("racy.txt", info)
+	if statsModified || !contentUncertain {
+		t.Errorf("expected a racily clean entry to require a content check but got %v, %v", statsModified, contentUncertain)
+	}
+
+	// rewriting the index must smudge the racy entry, since it won't look racy afterwards
+	addTestEntry(t, reloaded, dir, "other.txt", "64c5e5885a4b06010b3a0c20edb7900dd0311025")
+	if err := reloaded.WriteUpdates(); err != nil {
+		t.Fatalf("error writing index: %v", err)
+	}
+
+	smudged := reloadTestIndex(t, reloaded)
+	if e, _ := smudged.GetEntry("racy.txt"); e.header.Size != 0 {
+		t.Errorf("expected the racy entry to be smudged but its size is %d", e.header.Size)
+	}
+	if e, _ := smudged.GetEntry("other.txt"); e.header.Size == 0 {
+		t.Error("expected the freshly added entry not to be smudged")
+	}
+}
@@ -0,0 +1,93 @@
+package index
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/neocortical/got/objectformat"
+)
+
+var errInvalidResolveUndo = errors.New("invalid resolve-undo (REUC) extension")
+
+// ResolveUndoEntry records the unmerged stages of a path that has since been
+// resolved, so that the conflict can be recreated. Stages that did not exist
+// have a zero mode and an empty OID.
+type ResolveUndoEntry struct {
+	Path  string
+	Modes [3]uint32
+	OIDs  [3]string
+}
+
+func parseResolveUndo(data []byte, f *objectformat.Format) (result map[string]*ResolveUndoEntry, err error) {
+	result = map[string]*ResolveUndoEntry{}
+	r := bytes.NewBuffer(data)
+
+	for r.Len() > 0 {
+		var e ResolveUndoEntry
+
+		path, err := r.ReadString('\x00')
+		if err != nil {
+			return nil, errInvalidResolveUndo
+		}
+		e.Path = path[:len(path)-1]
+
+		for stage := range e.Modes {
+			modeString, err := r.ReadString('\x00')
+			if err != nil {
+				return nil, errInvalidResolveUndo
+			}
+			mode, err := strconv.ParseUint(modeString[:len(modeString)-1], 8, 32)
+			if err != nil {
+				return nil, errInvalidResolveUndo
+			}
+			e.Modes[stage] = uint32(mode)
+		}
+
+		for stage, mode := range e.Modes {
+			if mode == 0 {
+				continue
+			}
+			oid := r.Next(f.Size())
+			if len(oid) != f.Size() {
+				return nil, errInvalidResolveUndo
+			}
+			e.OIDs[stage] = hex.EncodeToString(oid)
+		}
+
+		result[e.Path] = &e
+	}
+
+	return result, nil
+}
+
+func serializeResolveUndo(entries map[string]*ResolveUndoEntry) []byte {
+	var paths []string
+	for path := range entries {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	buf := new(bytes.Buffer)
+	for _, path := range paths {
+		e := entries[path]
+		buf.WriteString(path)
+		buf.WriteByte('\x00')
+		for _, mode := range e.Modes {
+			buf.WriteString(fmt.Sprintf("%o", mode))
+			buf.WriteByte('\x00')
+		}
+		for stage, mode := range e.Modes {
+			if mode == 0 {
+				continue
+			}
+			oidBytes, _ := hex.DecodeString(e.OIDs[stage])
+			buf.Write(oidBytes)
+		}
+	}
+
+	return buf.Bytes()
+}
@@ -0,0 +1,126 @@
+package index
+
+import (
+	"os"
+)
+
+// StatOptions control which stat fields are trusted to detect modified files,
+// mirroring git's core.trustctime and core.checkStat settings.
+type StatOptions struct {
+	// TrustCtime compares inode change times. Disable it where ctime is
+	// updated by things other than content changes, such as backup tools.
+	TrustCtime bool
+	// MinimalStat only compares the whole-second part of the timestamps,
+	// the size and the mode, like core.checkStat=minimal.
+	MinimalStat bool
+}
+
+func DefaultStatOptions() StatOptions {
+	return StatOptions{TrustCtime: true}
+}
+
+func (i *index) SetStatOptions(opts StatOptions) {
+	i.statOptions = opts
+}
+
+func fillModTime(header *entryHeader, stat os.FileInfo) {
+	mtime := stat.ModTime()
+	header.MtimeSec = uint32(mtime.Unix())
+	header.MtimeNsec = uint32(mtime.Nanosecond())
+	header.CtimeSec = header.MtimeSec
+	header.CtimeNsec = header.MtimeNsec
+}
+
+// IsMetadataModified compares a tracked file's stat data to its index entry.
+// statsModified means the file has definitely changed (its mode or size
+// differ). contentUncertain means the stat data doesn't prove the file is
+// unchanged, so its content must be hashed and compared to the entry: the
+// timestamps or inode data differ, the entry was smudged, or the entry is
+// racily clean because the file was modified no earlier than the index was
+// written.
+func (i *index) IsMetadataModified(path string, info os.FileInfo) (statsModified, contentUncertain bool) {
+	existingEntry, tracked := i.entryMap[path]
+	if !tracked {
+		return false, false
+	}
+
+	existing := existingEntry.header
+	current := NewEntry(path, "", info).header
+	opts := i.statOptions
+
+	if existing.Mode != current.Mode {
+		statsModified = true
+	}
+
+	if existing.Size != current.Size {
+		// a size of zero means the entry was smudged, so only the content can tell
+		if existing.Size != 0 {
+			statsModified = true
+		} else {
+			contentUncertain = true
+		}
+	}
+
+	if existing.MtimeSec != current.MtimeSec || (!opts.MinimalStat && existing.MtimeNsec != current.MtimeNsec) {
+		contentUncertain = true
+	}
+
+	if opts.TrustCtime {
+		if existing.CtimeSec != current.CtimeSec || (!opts.MinimalStat && existing.CtimeNsec != current.CtimeNsec) {
+			contentUncertain = true
+		}
+	}
+
+	if !opts.MinimalStat {
+		if existing.INode != current.INode || existing.Device != current.Device ||
+			existing.UID != current.UID || existing.GID != current.GID {
+			contentUncertain = true
+		}
+	}
+
+	if !statsModified && !contentUncertain && i.isRacy(existingEntry) {
+		contentUncertain = true
+	}
+
+	return
+}
+
+// isRacy reports whether an entry's file was modified at or after the time the
+// index was written. Such a file could have changed again within the
+// timestamp granularity without its stat data changing.
+func (i *index) isRacy(e *Entry) bool {
+	if i.timestamp.IsZero() {
+		return false
+	}
+
+	sec, nsec := uint32(i.timestamp.Unix()), uint32(i.timestamp.Nanosecond())
+	if i.statOptions.MinimalStat {
+		nsec = 0
+	}
+
+	return e.header.MtimeSec > sec || (e.header.MtimeSec == sec && e.header.MtimeNsec >= nsec)
+}
+
+// smudgeRacyEntries zeroes the size of entries that were racily clean in the
+// index we loaded and weren't rehashed since. Once the index is rewritten with
+// a newer timestamp they would no longer look racy, so the zero size is what
+// forces the next comparison to check their content.
+func (i *index) smudgeRacyEntries(entries []*Entry) {
+	for _, e := range entries {
+		if !e.fresh && i.isRacy(e) {
+			e.header.Size = 0
+		}
+	}
+}
+
+// refresh updates the cached stat data of an entry whose content is unchanged.
+func (i *index) refresh(existing, updated *Entry) {
+	header := updated.header
+	header.Flags = existing.header.Flags
+	existing.fresh = true
+
+	if header != existing.header {
+		existing.header = header
+		i.changed = true
+	}
+}
@@ -0,0 +1,23 @@
+package index
+
+import (
+	"os"
+	"syscall"
+)
+
+func fillStatFields(header *entryHeader, stat os.FileInfo) {
+	statT, ok := stat.Sys().(*syscall.Stat_t)
+	if !ok {
+		fillModTime(header, stat)
+		return
+	}
+
+	header.CtimeSec = uint32(statT.Ctim.Sec)
+	header.CtimeNsec = uint32(statT.Ctim.Nsec)
+	header.MtimeSec = uint32(statT.Mtim.Sec)
+	header.MtimeNsec = uint32(statT.Mtim.Nsec)
+	header.Device = uint32(statT.Dev)
+	header.INode = uint32(statT.Ino)
+	header.UID = uint32(statT.Uid)
+	header.GID = uint32(statT.Gid)
+}
@@ -0,0 +1,10 @@
+//go:build !darwin && !linux
+// +build !darwin,!linux
+
+package index
+
+import "os"
+
+func fillStatFields(header *entryHeader, stat os.FileInfo) {
+	fillModTime(header, stat)
+}
@@ -0,0 +1,43 @@
+package index
+
+import "errors"
+
+var errInvalidVarint = errors.New("invalid varint in index file")
+
+// encodeVarint encodes an integer using the offset varint encoding git uses for
+// v4 pathname compression. Unlike a plain base-128 varint, each continuation
+// byte implicitly adds one, so every value has exactly one encoding.
+func encodeVarint(value uint64) []byte {
+	var buf [16]byte
+	pos := len(buf) - 1
+	buf[pos] = byte(value & 0x7f)
+	for value >>= 7; value != 0; value >>= 7 {
+		value--
+		pos--
+		buf[pos] = 0x80 | byte(value&0x7f)
+	}
+
+	return buf[pos:]
+}
+
+type byteReader interface {
+	ReadByte() (byte, error)
+}
+
+func decodeVarint(r byteReader) (value uint64, err error) {
+	c, err := r.ReadByte()
+	if err != nil {
+		return 0, errInvalidVarint
+	}
+
+	value = uint64(c & 0x7f)
+	for c&0x80 != 0 {
+		c, err = r.ReadByte()
+		if err != nil {
+			return 0, errInvalidVarint
+		}
+		value = ((value + 1) << 7) | uint64(c&0x7f)
+	}
+
+	return value, nil
+}
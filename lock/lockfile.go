@@ -3,20 +3,33 @@ package lock
 import (
 	"errors"
 	"fmt"
+	"math/rand"
 	"os"
 	"path/filepath"
+	"time"
+
+	"github.com/neocortical/got/faults"
+	"github.com/neocortical/got/fsync"
 )
 
+const lockSuffix = ".lock"
+
 var (
 	ErrLockNotHeld = errors.New("lockfile not held by this lock, acquire first")
 )
 
 type errLockConflict struct {
 	lockfile string
+	stale    *Info
 }
 
 func (elc *errLockConflict) Error() string {
-	return fmt.Sprintf("Unable to create '%s': File exists.", elc.lockfile)
+	msg := fmt.Sprintf("Unable to create '%s': File exists.", elc.lockfile)
+	if elc.stale != nil {
+		msg += fmt.Sprintf(" It appears to be stale: %s. Run 'got locks --prune' to remove it.", elc.stale.Reason)
+	}
+
+	return msg
 }
 
 func IsLockConflict(err error) bool {
@@ -24,33 +37,83 @@ func IsLockConflict(err error) bool {
 		return false
 	}
 
-	if _, ok := err.(*errLockConflict); ok {
-		return true
-	}
-
-	return false
+	var elc *errLockConflict
+	return errors.As(err, &elc)
 }
 
 type Lockfile struct {
-	path string
-	file *os.File
+	path  string
+	file  *os.File
+	fsync bool
 }
 
 func NewLockfile(path string) *Lockfile {
-	return &Lockfile{path, nil}
+	return &Lockfile{path: path}
+}
+
+// SetFsync makes Commit flush the new contents, and the rename that puts them
+// in place, to stable storage.
+func (lf *Lockfile) SetFsync(enabled bool) {
+	lf.fsync = enabled
 }
 
+// Acquire takes the lock, failing immediately if another process holds it.
 func (lf *Lockfile) Acquire() (err error) {
-	lockfile, err := filepath.Abs(fmt.Sprintf("%s.lock", lf.path))
+	return lf.AcquireTimeout(0)
+}
+
+// AcquireTimeout takes the lock, retrying with randomized quadratic backoff
+// for up to timeout while another process holds it. A negative timeout
+// retries forever.
+func (lf *Lockfile) AcquireTimeout(timeout time.Duration) (err error) {
+	deadline := time.Now().Add(timeout)
+
+	for attempt := 1; ; attempt++ {
+		err = lf.tryAcquire()
+		if !IsLockConflict(err) || timeout == 0 {
+			return err
+		}
+
+		remaining := time.Until(deadline)
+		if timeout > 0 && remaining <= 0 {
+			return err
+		}
+
+		// back off by attempt² milliseconds, +/- 25% so that competing
+		// processes don't retry in lockstep
+		wait := time.Duration(attempt*attempt) * time.Millisecond
+		wait = wait * time.Duration(750+rand.Intn(500)) / 1000
+		if timeout > 0 && wait > remaining {
+			wait = remaining
+		}
+		time.Sleep(wait)
+	}
+}
+
+func (lf *Lockfile) tryAcquire() (err error) {
+	lockfile, err := filepath.Abs(lf.path + lockSuffix)
 	if err != nil {
 		return
 	}
 
 	lf.file, err = os.OpenFile(lockfile, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
 	if err != nil {
+		lf.file = nil
 		if os.IsExist(err) {
-			return &errLockConflict{lockfile}
+			conflict := &errLockConflict{lockfile: lockfile}
+			if info, err := Inspect(lockfile); err == nil && info.State == StateStale {
+				conflict.stale = &info
+			}
+			return conflict
 		}
+		return
+	}
+
+	register(lf)
+
+	if err = writeOwner(lockfile); err != nil {
+		lf.Rollback()
+		return fmt.Errorf("unable to record lock owner: %w", err)
 	}
 
 	return
@@ -62,33 +125,72 @@ func (lf *Lockfile) Write(data []byte) (err error) {
 	}
 
 	_, err = lf.file.Write(data)
+	faults.Point("lock.write")
 	return
 }
 
+// Commit puts the new contents in place of the locked file. If that fails,
+// the lock is rolled back rather than left behind, so the file isn't locked
+// until someone removes the lock by hand.
 func (lf *Lockfile) Commit() (err error) {
 	if lf.file == nil {
 		return ErrLockNotHeld
 	}
+	lockfile := lf.path + lockSuffix
 
-	err = lf.file.Close()
-	if err != nil {
+	if lf.fsync {
+		if err = fsync.File(lf.file); err != nil {
+			lf.Rollback()
+			return fmt.Errorf("unable to sync '%s': %w", lockfile, err)
+		}
+	}
+
+	if err = lf.file.Close(); err != nil {
+		lf.Rollback()
 		return
 	}
+	faults.Point("lock.commit")
+
+	// the owner goes first, as once the lock is renamed another process may
+	// take it and record itself
+	removeOwner(lockfile)
+	if err = os.Rename(lockfile, lf.path); err != nil {
+		lf.Rollback()
+		return
+	}
+	lf.release()
+	faults.Point("lock.rename")
+
+	if lf.fsync {
+		if err = fsync.Dir(filepath.Dir(lf.path)); err != nil {
+			return fmt.Errorf("unable to sync directory of '%s': %w", lf.path, err)
+		}
+	}
 
-	err = os.Rename(fmt.Sprintf("%s.lock", lf.path), lf.path)
 	return
 }
 
+// Rollback gives up the lock, leaving the locked file as it was. The lock is
+// removed even if closing it fails.
 func (lf *Lockfile) Rollback() (err error) {
 	if lf.file == nil {
 		return ErrLockNotHeld
 	}
+	defer lf.release()
 
-	err = lf.file.Close()
-	if err != nil {
-		return
+	// Commit may have closed the file already
+	if err = lf.file.Close(); errors.Is(err, os.ErrClosed) {
+		err = nil
 	}
 
-	err = os.Remove(fmt.Sprintf("%s.lock", lf.path))
+	removeOwner(lf.path + lockSuffix)
+	if removeErr := os.Remove(lf.path + lockSuffix); err == nil {
+		err = removeErr
+	}
 	return
 }
+
+func (lf *Lockfile) release() {
+	unregister(lf)
+	lf.file = nil
+}
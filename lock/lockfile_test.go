@@ -0,0 +1,259 @@
+package lock
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/neocortical/got/faults"
+)
+
+// deadPID is above any real pid_max, so no process can have it.
+const deadPID = 1 << 30
+
+func setUpTestLock(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "got_lock_test_*")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+
+	return path.Join(dir, "file"), func() { os.RemoveAll(dir) }
+}
+
+func TestAcquireRecordsOwner(t *testing.T) {
+	filename, cleanup := setUpTestLock(t)
+	defer cleanup()
+
+	lf := NewLockfile(filename)
+	if err := lf.Acquire(); err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+
+	info, err := Inspect(filename + lockSuffix)
+	if err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	if info.State != StateHeld || info.PID != os.Getpid() || info.Hostname != hostname() {
+		t.Errorf("unexpected lock info: %+v", info)
+	}
+
+	if err = NewLockfile(filename).Acquire(); !IsLockConflict(err) {
+		t.Errorf("expected a lock conflict but got: %v", err)
+	}
+
+	lf.Write([]byte("data"))
+	if err = lf.Commit(); err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	for _, leftover := range []string{filename + lockSuffix, filename + lockSuffix + OwnerSuffix} {
+		if _, err = os.Stat(leftover); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed", leftover)
+		}
+	}
+}
+
+func TestFailedCommitReleasesLock(t *testing.T) {
+	filename, cleanup := setUpTestLock(t)
+	defer cleanup()
+
+	// a directory with something in it can't be replaced by a rename
+	if err := os.MkdirAll(path.Join(filename, "child"), 0755); err != nil {
+		t.Fatalf("error creating directory: %v", err)
+	}
+
+	lf := NewLockfile(filename)
+	if err := lf.Acquire(); err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	lf.Write([]byte("data"))
+	if err := lf.Commit(); err == nil {
+		t.Fatal("expected the commit to fail")
+	}
+
+	for _, leftover := range []string{filename + lockSuffix, filename + lockSuffix + OwnerSuffix} {
+		if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed", leftover)
+		}
+	}
+	if err := lf.Rollback(); err != ErrLockNotHeld {
+		t.Errorf("expected the lock to be released but got: %v", err)
+	}
+	if err := NewLockfile(filename).Acquire(); err != nil {
+		t.Errorf("expected the lock to be free but got: %v", err)
+	}
+}
+
+func TestAcquireTimeoutWaitsForRelease(t *testing.T) {
+	filename, cleanup := setUpTestLock(t)
+	defer cleanup()
+
+	holder := NewLockfile(filename)
+	if err := holder.Acquire(); err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		holder.Rollback()
+	}()
+
+	lf := NewLockfile(filename)
+	if err := lf.AcquireTimeout(5 * time.Second); err != nil {
+		t.Fatalf("expected the lock once released but got: %v", err)
+	}
+	lf.Rollback()
+
+	holder = NewLockfile(filename)
+	holder.Acquire()
+	defer holder.Rollback()
+
+	start := time.Now()
+	err := NewLockfile(filename).AcquireTimeout(30 * time.Millisecond)
+	if !IsLockConflict(err) {
+		t.Errorf("expected a lock conflict but got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond || elapsed > time.Second {
+		t.Errorf("expected to give up after the timeout but took %v", elapsed)
+	}
+}
+
+func TestStaleLocks(t *testing.T) {
+	filename, cleanup := setUpTestLock(t)
+	defer cleanup()
+
+	lockfile := filename + lockSuffix
+	ioutil.WriteFile(lockfile, nil, 0644)
+
+	info, _ := Inspect(lockfile)
+	if info.State != StateUnknown {
+		t.Errorf("expected a lock without an owner to be unknown but got %v", info.State)
+	}
+	if err := RemoveStale(lockfile); err == nil {
+		t.Error("expected an error removing a lock that may be in use")
+	}
+
+	ioutil.WriteFile(lockfile+OwnerSuffix, []byte(fmt.Sprintf("pid %d\nhost elsewhere\n", deadPID)), 0644)
+	if info, _ = Inspect(lockfile); info.State != StateUnknown {
+		t.Errorf("expected a lock from another host to be unknown but got %v", info.State)
+	}
+
+	ioutil.WriteFile(lockfile+OwnerSuffix, []byte(fmt.Sprintf("pid %d\nhost %s\n", deadPID, hostname())), 0644)
+	if info, _ = Inspect(lockfile); info.State != StateStale {
+		t.Errorf("expected the lock to be stale but got %v (%s)", info.State, info.Reason)
+	}
+
+	err := NewLockfile(filename).Acquire()
+	if !IsLockConflict(err) {
+		t.Fatalf("expected a lock conflict but got: %v", err)
+	}
+	if elc := err.(*errLockConflict); elc.stale == nil {
+		t.Error("expected the conflict to point out the stale lock")
+	}
+
+	if err = RemoveStale(lockfile); err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	if _, err = os.Stat(lockfile); !os.IsNotExist(err) {
+		t.Error("expected the stale lock to be removed")
+	}
+}
+
+func TestRemoveStaleSparesNewLock(t *testing.T) {
+	filename, cleanup := setUpTestLock(t)
+	defer cleanup()
+	defer faults.SetHook(nil)
+
+	lockfile := filename + lockSuffix
+	ioutil.WriteFile(lockfile, nil, 0644)
+	ioutil.WriteFile(lockfile+OwnerSuffix, []byte(fmt.Sprintf("pid %d\nhost %s\n", deadPID, hostname())), 0644)
+
+	// another process removes the stale lock and takes the lock itself
+	// between it being inspected and removed
+	var live *Lockfile
+	faults.SetHook(func(point string) {
+		if point != "lock.remove-stale" {
+			return
+		}
+		os.Remove(lockfile)
+		live = NewLockfile(filename)
+		if err := live.Acquire(); err != nil {
+			t.Fatalf("expected no error but got: %v", err)
+		}
+	})
+
+	if err := RemoveStale(lockfile); err == nil {
+		t.Error("expected an error removing a lock taken again")
+	}
+	faults.SetHook(nil)
+
+	if info, err := Inspect(lockfile); err != nil || info.State != StateHeld {
+		t.Errorf("expected the new lock to be held but got: %+v (%v)", info, err)
+	}
+	if err := live.Commit(); err != nil {
+		t.Errorf("expected the new lock to commit but got: %v", err)
+	}
+}
+
+func TestRemoveStaleKeepsLockTakenAfterMove(t *testing.T) {
+	filename, cleanup := setUpTestLock(t)
+	defer cleanup()
+	defer faults.SetHook(nil)
+
+	lockfile := filename + lockSuffix
+	ioutil.WriteFile(lockfile, nil, 0644)
+	ioutil.WriteFile(lockfile+OwnerSuffix, []byte(fmt.Sprintf("pid %d\nhost %s\n", deadPID, hostname())), 0644)
+
+	// another process takes the lock as soon as the stale one is moved aside
+	var live *Lockfile
+	faults.SetHook(func(point string) {
+		if point != "lock.remove-stale-moved" {
+			return
+		}
+		live = NewLockfile(filename)
+		if err := live.Acquire(); err != nil {
+			t.Fatalf("expected no error but got: %v", err)
+		}
+	})
+
+	if err := RemoveStale(lockfile); err != nil {
+		t.Errorf("expected the stale lock to be removed but got: %v", err)
+	}
+	faults.SetHook(nil)
+
+	if info, err := Inspect(lockfile); err != nil || info.State != StateHeld {
+		t.Errorf("expected the new lock to be held but got: %+v (%v)", info, err)
+	}
+	live.Write([]byte("new"))
+	if err := live.Commit(); err != nil {
+		t.Fatalf("expected the new lock to commit but got: %v", err)
+	}
+	if data, _ := ioutil.ReadFile(filename); string(data) != "new" {
+		t.Errorf("expected the new lock's contents but got: %q", data)
+	}
+	if files, _ := ioutil.ReadDir(path.Dir(filename)); len(files) != 1 {
+		t.Errorf("expected only the locked file to be left but found %d files", len(files))
+	}
+}
+
+func TestRollbackAll(t *testing.T) {
+	filename, cleanup := setUpTestLock(t)
+	defer cleanup()
+
+	for i := 0; i < 3; i++ {
+		if err := NewLockfile(fmt.Sprintf("%s%d", filename, i)).Acquire(); err != nil {
+			t.Fatalf("expected no error but got: %v", err)
+		}
+	}
+
+	RollbackAll()
+
+	files, _ := ioutil.ReadDir(path.Dir(filename))
+	if len(files) != 0 {
+		t.Errorf("expected every lock to be released but found %d files", len(files))
+	}
+	if len(held.locks) != 0 {
+		t.Errorf("expected the registry to be empty but it has %d locks", len(held.locks))
+	}
+}
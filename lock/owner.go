@@ -0,0 +1,171 @@
+package lock
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/neocortical/got/faults"
+)
+
+// OwnerSuffix is appended to the name of a lock file to get the name of the
+// file recording who holds it. Lock files can't carry this themselves, since
+// their contents become the new version of the locked file. The ~ keeps the
+// owner file from looking like a ref.
+const OwnerSuffix = "~owner"
+
+// State says whether a lock is still held by a live process.
+type State int
+
+const (
+	// StateUnknown locks have no owner information, or belong to another
+	// host, so there's no way to tell whether they're still in use.
+	StateUnknown State = iota
+	StateHeld
+	StateStale
+)
+
+func (s State) String() string {
+	switch s {
+	case StateHeld:
+		return "held"
+	case StateStale:
+		return "stale"
+	}
+
+	return "unknown"
+}
+
+// Info describes an existing lock file.
+type Info struct {
+	Path     string
+	PID      int
+	Hostname string
+	State    State
+	Reason   string
+}
+
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+
+	return name
+}
+
+func writeOwner(lockfile string) error {
+	data := fmt.Sprintf("pid %d\nhost %s\n", os.Getpid(), hostname())
+	return ioutil.WriteFile(lockfile+OwnerSuffix, []byte(data), 0644)
+}
+
+func removeOwner(lockfile string) {
+	os.Remove(lockfile + OwnerSuffix)
+}
+
+// Inspect reports who holds a lock file and whether that process is still
+// running. Only locks taken on this host by a process that has exited are
+// reported as stale.
+func Inspect(lockfile string) (Info, error) {
+	if _, err := os.Stat(lockfile); err != nil {
+		return Info{Path: lockfile}, err
+	}
+
+	return inspectOwner(lockfile)
+}
+
+// inspectOwner reads the owner information of a lock file, whether or not the
+// lock file itself is still there.
+func inspectOwner(lockfile string) (result Info, err error) {
+	result.Path = lockfile
+	data, err := ioutil.ReadFile(lockfile + OwnerSuffix)
+	if os.IsNotExist(err) {
+		result.Reason = "no owner recorded"
+		return result, nil
+	}
+	if err != nil {
+		return
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		switch fields[0] {
+		case "pid":
+			result.PID, _ = strconv.Atoi(fields[1])
+		case "host":
+			result.Hostname = fields[1]
+		}
+	}
+
+	switch {
+	case result.PID <= 0 || result.Hostname == "":
+		result.Reason = "malformed owner information"
+	case result.Hostname != hostname():
+		result.Reason = fmt.Sprintf("held by process %d on %s", result.PID, result.Hostname)
+	default:
+		alive, known := processExists(result.PID)
+		switch {
+		case !known:
+			result.Reason = fmt.Sprintf("held by process %d, which can't be checked", result.PID)
+		case alive:
+			result.State = StateHeld
+			result.Reason = fmt.Sprintf("held by running process %d", result.PID)
+		default:
+			result.State = StateStale
+			result.Reason = fmt.Sprintf("process %d on %s is no longer running", result.PID, result.Hostname)
+		}
+	}
+
+	return result, nil
+}
+
+// RemoveStale deletes a lock file and its owner information, but only if the
+// lock is stale. The lock is moved aside before it's removed, and put back if
+// it turns out not to be the lock that was found stale. It's never put back
+// over a lock another process has taken in the meantime.
+func RemoveStale(lockfile string) error {
+	before, err := os.Stat(lockfile)
+	if err != nil {
+		return err
+	}
+	info, err := Inspect(lockfile)
+	if err != nil {
+		return err
+	}
+	if info.State != StateStale {
+		return fmt.Errorf("refusing to remove '%s': %s", lockfile, info.Reason)
+	}
+	faults.Point("lock.remove-stale")
+
+	private := fmt.Sprintf("%s~stale-%d", lockfile, os.Getpid())
+	if err = os.Rename(lockfile, private); err != nil {
+		return err
+	}
+	faults.Point("lock.remove-stale-moved")
+
+	// a lock taken since the inspection may reuse the inode of the stale one,
+	// but not its modification time
+	after, err := os.Stat(private)
+	if err != nil || !os.SameFile(before, after) || !before.ModTime().Equal(after.ModTime()) {
+		if err = os.Link(private, lockfile); err != nil {
+			os.Remove(private)
+			return fmt.Errorf("unable to restore '%s', which was taken again while being inspected: %w", lockfile, err)
+		}
+		os.Remove(private)
+		return fmt.Errorf("refusing to remove '%s': it was taken again while being inspected", lockfile)
+	}
+
+	// a lock taken since it was moved aside records its own owner
+	if _, err = os.Stat(lockfile); os.IsNotExist(err) {
+		if owner, err := inspectOwner(lockfile); err == nil && owner.PID == info.PID {
+			removeOwner(lockfile)
+		}
+	}
+
+	return os.Remove(private)
+}
@@ -0,0 +1,19 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package lock
+
+import "syscall"
+
+// processExists checks for a process by sending it the null signal.
+func processExists(pid int) (alive bool, known bool) {
+	err := syscall.Kill(pid, 0)
+	if err == nil || err == syscall.EPERM {
+		return true, true
+	}
+	if err == syscall.ESRCH {
+		return false, true
+	}
+
+	return false, false
+}
@@ -0,0 +1,60 @@
+package lock
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// held tracks every lock this process holds, so they can be released when the
+// process is interrupted.
+var held = struct {
+	sync.Mutex
+	locks map[*Lockfile]struct{}
+}{locks: map[*Lockfile]struct{}{}}
+
+func register(lf *Lockfile) {
+	held.Lock()
+	held.locks[lf] = struct{}{}
+	held.Unlock()
+}
+
+func unregister(lf *Lockfile) {
+	held.Lock()
+	delete(held.locks, lf)
+	held.Unlock()
+}
+
+// RollbackAll releases every lock held by this process, leaving the locked
+// files untouched. It's meant for paths that exit the process.
+func RollbackAll() {
+	held.Lock()
+	locks := make([]*Lockfile, 0, len(held.locks))
+	for lf := range held.locks {
+		locks = append(locks, lf)
+	}
+	held.Unlock()
+
+	for _, lf := range locks {
+		lf.Rollback()
+	}
+}
+
+// HandleSignals releases all held locks when the process receives SIGINT or
+// SIGTERM, then exits with the conventional 128+signal status.
+func HandleSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		sig := <-signals
+		RollbackAll()
+
+		status := 128 + int(syscall.SIGINT)
+		if s, ok := sig.(syscall.Signal); ok {
+			status = 128 + int(s)
+		}
+		os.Exit(status)
+	}()
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 
 	"github.com/neocortical/got/cmd"
+	"github.com/neocortical/got/lock"
 )
 
 const (
@@ -13,6 +14,9 @@ const (
 )
 
 func main() {
+	lock.HandleSignals()
+
+	cmd.SetStdin(os.Stdin)
 	cmd.SetStdout(os.Stdout)
 	cmd.SetStderr(os.Stderr)
 	cmd.Setenv(os.Getenv)
@@ -0,0 +1,102 @@
+package object
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// AlternatesFile lists, relative to an object database directory, other
+	// object directories to read objects from.
+	AlternatesFile = "info/alternates"
+
+	// maxAlternateDepth limits how far alternates of alternates are followed,
+	// as git does.
+	maxAlternateDepth = 5
+)
+
+// ReadAlternates returns the object directories listed in a database's
+// alternates file, as absolute paths. Relative entries are relative to the
+// database directory.
+func ReadAlternates(objectsDir string) (result []string, err error) {
+	data, err := ioutil.ReadFile(filepath.Join(objectsDir, AlternatesFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading alternates: %w", err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || entry[0] == '#' {
+			continue
+		}
+
+		if !filepath.IsAbs(entry) {
+			entry = filepath.Join(objectsDir, entry)
+		}
+		result = append(result, filepath.Clean(entry))
+	}
+
+	return result, scanner.Err()
+}
+
+// ResolveAlternates follows the alternates of each of dirs, and theirs in
+// turn, returning every object directory found in the order it's reached.
+// exclude is left out, so a database never reads through to itself.
+func ResolveAlternates(dirs []string, exclude string) (result []string, err error) {
+	seen := map[string]bool{filepath.Clean(exclude): true}
+
+	var visit func(dirs []string, depth int) error
+	visit = func(dirs []string, depth int) error {
+		for _, dir := range dirs {
+			dir = filepath.Clean(dir)
+			if seen[dir] {
+				continue
+			}
+			seen[dir] = true
+			result = append(result, dir)
+
+			if depth == maxAlternateDepth {
+				continue
+			}
+			nested, err := ReadAlternates(dir)
+			if err != nil {
+				return err
+			}
+			if err = visit(nested, depth+1); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	return result, visit(dirs, 1)
+}
+
+// WriteAlternates replaces a database's alternates file.
+func WriteAlternates(objectsDir string, dirs []string) error {
+	alternatesPath := filepath.Join(objectsDir, AlternatesFile)
+	if err := os.MkdirAll(filepath.Dir(alternatesPath), 0755); err != nil {
+		return fmt.Errorf("error creating %s: %w", filepath.Dir(alternatesPath), err)
+	}
+
+	var buf bytes.Buffer
+	for _, dir := range dirs {
+		buf.WriteString(dir + "\n")
+	}
+
+	if err := ioutil.WriteFile(alternatesPath, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("error writing alternates: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package object
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestResolveAlternates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "got_test_alternates_*")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	a, b, c := filepath.Join(dir, "a"), filepath.Join(dir, "b"), filepath.Join(dir, "c")
+
+	// a borrows from b (relatively, with a comment) and b from c and back
+	// from a, which must not loop
+	if err = WriteAlternates(a, []string{"# comment", "../b", ""}); err != nil {
+		t.Fatalf("error writing alternates: %v", err)
+	}
+	if err = WriteAlternates(b, []string{c, a}); err != nil {
+		t.Fatalf("error writing alternates: %v", err)
+	}
+
+	direct, err := ReadAlternates(a)
+	if err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	if !reflect.DeepEqual(direct, []string{b}) {
+		t.Errorf("expected [%s] but got: %v", b, direct)
+	}
+
+	resolved, err := ResolveAlternates(direct, a)
+	if err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	if !reflect.DeepEqual(resolved, []string{b, c}) {
+		t.Errorf("expected [%s %s] but got: %v", b, c, resolved)
+	}
+
+	none, err := ReadAlternates(c)
+	if err != nil || len(none) != 0 {
+		t.Errorf("expected no alternates but got: %v (%v)", none, err)
+	}
+}
+
+func TestReadThroughAlternates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "got_test_alternates_*")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	shared := NewDatabase(filepath.Join(dir, "shared"))
+	oid, err := shared.Store(&genericStorable{storableType: "blob", data: []byte("borrowed")})
+	if err != nil {
+		t.Fatalf("error storing object: %v", err)
+	}
+
+	own := NewDatabase(filepath.Join(dir, "own"))
+	db := NewChainedDatabase(own, shared)
+	if _, err = db.Read(oid); err != nil {
+		t.Errorf("expected to read a borrowed object but got: %v", err)
+	}
+	if _, err = db.Store(&genericStorable{storableType: "blob", data: []byte("borrowed")}); err != nil {
+		t.Fatalf("error storing object: %v", err)
+	}
+	if own.Has(oid) {
+		t.Error("expected a borrowed object not to be copied")
+	}
+}
@@ -0,0 +1,162 @@
+package object
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func testBackend(t *testing.T, name string, db Database) {
+	obj := &genericStorable{storableType: "blob", data: []byte("hello, world!")}
+	expectedOID := "30f51a3fba5274d53522d0f19748456974647b4f"
+
+	if db.Has(expectedOID) {
+		t.Errorf("%s: expected the object not to exist yet", name)
+	}
+	if _, err := db.Read(expectedOID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("%s: expected ErrNotFound but got: %v", name, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		oid, err := db.Store(obj)
+		if err != nil {
+			t.Fatalf("%s: expected no errors but got: %v", name, err)
+		}
+		if oid != expectedOID {
+			t.Errorf("%s: expected OID '%s' but got '%s'", name, expectedOID, oid)
+		}
+	}
+
+	read, err := db.Read(expectedOID)
+	if err != nil {
+		t.Fatalf("%s: expected no errors but got: %v", name, err)
+	}
+	if read.Type() != "blob" || string(read.Serialize()) != "hello, world!" {
+		t.Errorf("%s: unexpected object read back: %s '%s'", name, read.Type(), read.Serialize())
+	}
+	if !db.Has(expectedOID) {
+		t.Errorf("%s: expected the object to exist", name)
+	}
+
+	oids, err := db.OIDs()
+	if err != nil || len(oids) != 1 || oids[0] != expectedOID {
+		t.Errorf("%s: expected OIDs [%s] but got %v (%v)", name, expectedOID, oids, err)
+	}
+}
+
+func TestBackends(t *testing.T) {
+	dir, err := ioutil.TempDir("", "got_test_backends_*")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	testBackend(t, "loose", NewDatabase(filepath.Join(dir, "objects")))
+	testBackend(t, "memory", NewMemoryDatabase())
+	testBackend(t, "kv", NewKVDatabase(filepath.Join(dir, KVFilename)))
+	testBackend(t, "chained", NewChainedDatabase(NewMemoryDatabase(), NewMemoryDatabase()))
+
+	// a fresh handle on the store sees what was written through another
+	reopened := NewKVDatabase(filepath.Join(dir, KVFilename))
+	if !reopened.Has("30f51a3fba5274d53522d0f19748456974647b4f") {
+		t.Error("expected the object to be found in the reopened store")
+	}
+}
+
+func TestChainedDatabaseReadsThrough(t *testing.T) {
+	primary, shared := NewMemoryDatabase(), NewMemoryDatabase()
+	db := NewChainedDatabase(primary, shared)
+
+	borrowed := &genericStorable{storableType: "blob", data: []byte("shared")}
+	oid, _ := shared.Store(borrowed)
+
+	read, err := db.Read(oid)
+	if err != nil || string(read.Serialize()) != "shared" {
+		t.Errorf("expected to read the shared object but got '%v' (%v)", read, err)
+	}
+
+	if _, err = db.Store(borrowed); err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	if primary.Has(oid) {
+		t.Error("expected an object already in a shared store not to be copied into the primary")
+	}
+
+	own := &genericStorable{storableType: "blob", data: []byte("own")}
+	oid, _ = db.Store(own)
+	if !primary.Has(oid) || shared.Has(oid) {
+		t.Error("expected new objects to be written only to the primary")
+	}
+}
+
+func TestKVDatabaseIgnoresTornRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "got_test_kv_*")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, KVFilename)
+	db := NewKVDatabase(path)
+	first, _ := db.Store(&genericStorable{storableType: "blob", data: []byte("first")})
+	second, _ := db.Store(&genericStorable{storableType: "blob", data: []byte("second")})
+
+	// lose the end of the last record, as a crash mid-append would
+	stat, _ := os.Stat(path)
+	if err = os.Truncate(path, stat.Size()-3); err != nil {
+		t.Fatalf("error truncating store: %v", err)
+	}
+
+	db = NewKVDatabase(path)
+	if !db.Has(first) {
+		t.Error("expected records before the torn one to survive")
+	}
+	if db.Has(second) {
+		t.Error("expected the torn record to be ignored")
+	}
+
+	third, err := db.Store(&genericStorable{storableType: "blob", data: []byte("third")})
+	if err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+
+	db = NewKVDatabase(path)
+	for _, oid := range []string{first, third} {
+		if _, err := db.Read(oid); err != nil {
+			t.Errorf("expected %s to be readable after appending past the torn record but got: %v", oid, err)
+		}
+	}
+}
+
+func TestKVDatabaseConcurrentWriters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "got_test_kv_*")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, KVFilename)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// each writer has its own handle, as separate processes would
+			db := NewKVDatabase(path)
+			for j := 0; j < 10; j++ {
+				if _, err := db.Store(&genericStorable{storableType: "blob", data: []byte{byte(i), byte(j)}}); err != nil {
+					t.Errorf("expected no errors but got: %v", err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	oids, err := NewKVDatabase(path).OIDs()
+	if err != nil || len(oids) != 40 {
+		t.Errorf("expected 40 objects but got %d (%v)", len(oids), err)
+	}
+}
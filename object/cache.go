@@ -0,0 +1,196 @@
+package object
+
+import (
+	"container/list"
+	"sync"
+)
+
+const (
+	// DefaultCacheLimit is the number of bytes of objects a cache holds unless
+	// configured otherwise.
+	DefaultCacheLimit = 16 << 20
+
+	// cacheEntryOverhead approximates the memory an entry takes beyond its
+	// data: the ID, list element, map slot and object header.
+	cacheEntryOverhead = 160
+)
+
+// CacheStats counts a cache's lookups and describes its contents.
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Objects   int
+	Bytes     int64
+	Limit     int64
+}
+
+type cacheEntry struct {
+	oid     string
+	obj     Storable
+	decoded interface{}
+	size    int64
+}
+
+// CachedDatabase keeps the objects most recently read from a database in
+// memory, evicting the least recently used once their size passes a limit.
+// Read returns the raw objects; ReadDecoded keeps their parsed form along
+// with them, so trees and commits read again aren't parsed again either.
+// Objects are immutable, so entries never go stale; callers must not modify
+// the objects they read. It is safe for concurrent use.
+type CachedDatabase struct {
+	Database
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	stats   CacheStats
+}
+
+// NewCachedDatabase caches up to limit bytes of objects read from db. Objects
+// bigger than a quarter of the limit, usually large blobs, aren't cached so
+// that one of them can't flush out every tree and commit.
+func NewCachedDatabase(db Database, limit int64) *CachedDatabase {
+	return &CachedDatabase{
+		Database: db,
+		entries:  map[string]*list.Element{},
+		lru:      list.New(),
+		stats:    CacheStats{Limit: limit},
+	}
+}
+
+func (c *CachedDatabase) Read(oid string) (Storable, error) {
+	c.mu.Lock()
+	if e, ok := c.entries[oid]; ok {
+		c.lru.MoveToFront(e)
+		c.stats.Hits++
+		c.mu.Unlock()
+		return e.Value.(*cacheEntry).obj, nil
+	}
+	c.stats.Misses++
+	c.mu.Unlock()
+
+	// read without holding the lock, so a slow read doesn't hold up hits
+	obj, err := c.Database.Read(oid)
+	if err != nil {
+		return nil, err
+	}
+
+	c.add(oid, obj)
+	return obj, nil
+}
+
+func (c *CachedDatabase) Has(oid string) bool {
+	c.mu.Lock()
+	_, ok := c.entries[oid]
+	c.mu.Unlock()
+
+	return ok || c.Database.Has(oid)
+}
+
+// ReadDecoded reads an object and parses it with decode. When db is a
+// CachedDatabase the result is kept with the cached object and returned by
+// later calls without decoding again, so callers must not modify it.
+func ReadDecoded(db Database, oid string, decode func(Storable) (interface{}, error)) (interface{}, error) {
+	c, ok := db.(*CachedDatabase)
+	if !ok {
+		obj, err := db.Read(oid)
+		if err != nil {
+			return nil, err
+		}
+		return decode(obj)
+	}
+
+	c.mu.Lock()
+	if e, ok := c.entries[oid]; ok && e.Value.(*cacheEntry).decoded != nil {
+		c.lru.MoveToFront(e)
+		c.stats.Hits++
+		c.mu.Unlock()
+		return e.Value.(*cacheEntry).decoded, nil
+	}
+	c.mu.Unlock()
+
+	obj, err := c.Read(oid)
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := decode(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// the object may not have been cached, or been evicted since
+	if e, ok := c.entries[oid]; ok {
+		entry := e.Value.(*cacheEntry)
+		if entry.decoded == nil {
+			// a parsed object takes about as much memory again as its data
+			entry.decoded = decoded
+			entry.size += objectSize(obj)
+			c.stats.Bytes += objectSize(obj)
+			c.evict()
+		}
+	}
+	return decoded, nil
+}
+
+func (c *CachedDatabase) add(oid string, obj Storable) {
+	size := objectSize(obj) + cacheEntryOverhead
+	if size > c.stats.Limit/4 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// another reader may have cached it meanwhile
+	if _, ok := c.entries[oid]; ok {
+		return
+	}
+
+	c.entries[oid] = c.lru.PushFront(&cacheEntry{oid: oid, obj: obj, size: size})
+	c.stats.Bytes += size
+	c.stats.Objects++
+	c.evict()
+}
+
+// evict drops the least recently used entries until the cache is within its
+// limit. c.mu must be held.
+func (c *CachedDatabase) evict() {
+	for c.stats.Bytes > c.stats.Limit {
+		oldest := c.lru.Remove(c.lru.Back()).(*cacheEntry)
+		delete(c.entries, oldest.oid)
+		c.stats.Bytes -= oldest.size
+		c.stats.Objects--
+		c.stats.Evictions++
+	}
+}
+
+// Stats returns the cache's counters and current size.
+func (c *CachedDatabase) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.stats
+}
+
+// Reset empties the cache and zeroes its counters.
+func (c *CachedDatabase) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = map[string]*list.Element{}
+	c.lru.Init()
+	c.stats = CacheStats{Limit: c.stats.Limit}
+}
+
+// objectSize is the size of an object's data, taken from its header when it
+// was read rather than serializing it again.
+func objectSize(obj Storable) int64 {
+	if g, ok := obj.(*genericStorable); ok {
+		return int64(g.size)
+	}
+	return int64(len(obj.Serialize()))
+}
@@ -0,0 +1,224 @@
+package object
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCachedDatabase(t *testing.T) {
+	backend := NewMemoryDatabase()
+	var oids []string
+	for i := 0; i < 5; i++ {
+		oid, err := backend.Store(&genericStorable{storableType: "blob", data: []byte(fmt.Sprintf("object %d", i))})
+		if err != nil {
+			t.Fatalf("error storing object: %v", err)
+		}
+		oids = append(oids, oid)
+	}
+
+	// room for four small objects
+	db := NewCachedDatabase(backend, 4*(cacheEntryOverhead+8))
+
+	for _, oid := range oids[:4] {
+		db.Read(oid)
+	}
+	db.Read(oids[0])
+	if stats := db.Stats(); stats.Hits != 1 || stats.Misses != 4 || stats.Objects != 4 || stats.Evictions != 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	// oids[1] is now the least recently used
+	db.Read(oids[4])
+	stats := db.Stats()
+	if stats.Evictions != 1 || stats.Objects != 4 || stats.Bytes > stats.Limit {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	db.Read(oids[0])
+	if db.Stats().Hits != 2 {
+		t.Errorf("expected %s to stay cached", oids[0])
+	}
+	db.Read(oids[1])
+	if db.Stats().Misses != 6 {
+		t.Errorf("expected %s to have been evicted", oids[1])
+	}
+
+	read, err := db.Read(oids[2])
+	if err != nil || string(read.Serialize()) != "object 2" {
+		t.Errorf("unexpected object read back: '%s' (%v)", read.Serialize(), err)
+	}
+
+	big := &genericStorable{storableType: "blob", data: make([]byte, stats.Limit)}
+	bigOID, _ := db.Store(big)
+	db.Read(bigOID)
+	db.Read(bigOID)
+	if stats := db.Stats(); stats.Hits != 2 || stats.Misses != 9 || stats.Objects != 4 {
+		t.Errorf("expected an oversized object not to be cached: %+v", stats)
+	}
+
+	db.Reset()
+	if stats := db.Stats(); stats.Hits != 0 || stats.Objects != 0 || stats.Bytes != 0 {
+		t.Errorf("expected an empty cache but got: %+v", stats)
+	}
+}
+
+func TestReadDecoded(t *testing.T) {
+	backend := NewMemoryDatabase()
+	oid, _ := backend.Store(&genericStorable{storableType: "blob", data: []byte("object 0")})
+
+	decodes := 0
+	decode := func(obj Storable) (interface{}, error) {
+		decodes++
+		return strings.ToUpper(string(obj.Serialize())), nil
+	}
+
+	for _, db := range []Database{backend, NewCachedDatabase(backend, DefaultCacheLimit)} {
+		decodes = 0
+		for i := 0; i < 2; i++ {
+			decoded, err := ReadDecoded(db, oid, decode)
+			if err != nil || decoded != "OBJECT 0" {
+				t.Errorf("unexpected object decoded: '%v' (%v)", decoded, err)
+			}
+		}
+
+		expected := 2
+		if cached, ok := db.(*CachedDatabase); ok {
+			expected = 1
+			if stats := cached.Stats(); stats.Hits != 1 || stats.Misses != 1 || stats.Bytes != 2*8+cacheEntryOverhead {
+				t.Errorf("unexpected stats: %+v", stats)
+			}
+		}
+		if decodes != expected {
+			t.Errorf("expected %d decodes but got %d", expected, decodes)
+		}
+	}
+
+	failing := func(Storable) (interface{}, error) { return nil, fmt.Errorf("bad object") }
+	if _, err := ReadDecoded(NewCachedDatabase(backend, DefaultCacheLimit), oid, failing); err == nil {
+		t.Errorf("expected the decoding error")
+	}
+}
+
+func TestCachedDatabaseConcurrentReaders(t *testing.T) {
+	backend := NewMemoryDatabase()
+	var oids []string
+	for i := 0; i < 64; i++ {
+		oid, _ := backend.Store(&genericStorable{storableType: "blob", data: []byte(fmt.Sprintf("object %d", i))})
+		oids = append(oids, oid)
+	}
+
+	// small enough to keep evicting
+	db := NewCachedDatabase(backend, 16*(cacheEntryOverhead+16))
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				n := (i*7 + g) % len(oids)
+				obj, err := db.Read(oids[n])
+				if err != nil || string(obj.Serialize()) != fmt.Sprintf("object %d", n) {
+					t.Errorf("unexpected object %d: '%s' (%v)", n, obj.Serialize(), err)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	stats := db.Stats()
+	if stats.Hits+stats.Misses != 8000 || stats.Bytes > stats.Limit || stats.Objects != len(db.entries) {
+		t.Errorf("inconsistent stats: %+v", stats)
+	}
+}
+
+// writeHistory stores a chain of commits, each with a tree holding a file
+// and a subdirectory, and returns the last.
+func writeHistory(b *testing.B, db Database, n int) (head string) {
+	subtree, _ := db.Store(&genericStorable{storableType: "tree", data: []byte("100644 lib.go\x00" + strings.Repeat("\x01", 20))})
+	for i := 0; i < n; i++ {
+		blob, _ := db.Store(&genericStorable{storableType: "blob", data: []byte(fmt.Sprintf("version %d\n", i))})
+		tree, _ := db.Store(&genericStorable{storableType: "tree", data: []byte(
+			"100644 README\x00" + hexToRaw(blob) + "40000 lib\x00" + hexToRaw(subtree))})
+
+		text := "tree " + tree + "\n"
+		if head != "" {
+			text += "parent " + head + "\n"
+		}
+		text += fmt.Sprintf("author A U Thor <a@example.com> %d +0000\n\ncommit %d\n", 1500000000+i, i)
+
+		var err error
+		head, err = db.Store(&genericStorable{storableType: "commit", data: []byte(text)})
+		if err != nil {
+			b.Fatalf("error storing commit: %v", err)
+		}
+	}
+
+	return head
+}
+
+func hexToRaw(oid string) string {
+	var raw []byte
+	fmt.Sscanf(oid, "%x", &raw)
+	return string(raw)
+}
+
+// walkHistory reads every commit back from head, with its tree and
+// subtree, the way log and status-style walks do.
+func walkHistory(b *testing.B, db Database, head string) {
+	for oid := head; oid != ""; {
+		commit, err := db.Read(oid)
+		if err != nil {
+			b.Fatalf("error reading commit: %v", err)
+		}
+
+		oid = ""
+		for _, line := range strings.Split(string(commit.Serialize()), "\n") {
+			if strings.HasPrefix(line, "tree ") {
+				tree, err := db.Read(line[5:])
+				if err != nil {
+					b.Fatalf("error reading tree: %v", err)
+				}
+				data := tree.Serialize()
+				if _, err = db.Read(fmt.Sprintf("%x", data[len(data)-20:])); err != nil {
+					b.Fatalf("error reading subtree: %v", err)
+				}
+			} else if strings.HasPrefix(line, "parent ") {
+				oid = line[7:]
+			}
+		}
+	}
+}
+
+func BenchmarkHistoryWalk(b *testing.B) {
+	dir, err := ioutil.TempDir("", "got_bench_cache_*")
+	if err != nil {
+		b.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	loose := NewDatabase(dir)
+	head := writeHistory(b, loose, 500)
+
+	for _, bench := range []struct {
+		name string
+		db   Database
+	}{
+		{"uncached", loose},
+		{"cached", NewCachedDatabase(loose, DefaultCacheLimit)},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				walkHistory(b, bench.db, head)
+			}
+			if cached, ok := bench.db.(*CachedDatabase); ok {
+				stats := cached.Stats()
+				b.ReportMetric(float64(stats.Hits)/float64(stats.Hits+stats.Misses), "hit-ratio")
+			}
+		})
+	}
+}
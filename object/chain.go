@@ -0,0 +1,85 @@
+package object
+
+import (
+	"errors"
+
+	"github.com/neocortical/got/objectformat"
+)
+
+// chainedDatabase writes to its first database and reads through all of
+// them in order.
+type chainedDatabase struct {
+	dbs    []Database
+	format *objectformat.Format
+}
+
+// NewChainedDatabase returns a database that stores new objects in primary
+// and reads objects from primary or, failing that, from each of others in
+// turn. Objects already in any of the databases aren't stored again.
+func NewChainedDatabase(primary Database, others ...Database) Database {
+	return &chainedDatabase{
+		dbs:    append([]Database{primary}, others...),
+		format: objectformat.Default,
+	}
+}
+
+func (db *chainedDatabase) Store(s Storable) (string, error) {
+	oid := HashObject(db.format, s)
+	for _, d := range db.dbs[1:] {
+		if d.Has(oid) {
+			return oid, nil
+		}
+	}
+
+	return db.dbs[0].Store(s)
+}
+
+func (db *chainedDatabase) Read(oid string) (result Storable, err error) {
+	for _, d := range db.dbs {
+		result, err = d.Read(oid)
+		if !errors.Is(err, ErrNotFound) {
+			return result, err
+		}
+	}
+
+	return nil, err
+}
+
+func (db *chainedDatabase) Has(oid string) bool {
+	for _, d := range db.dbs {
+		if d.Has(oid) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (db *chainedDatabase) OIDs() ([]string, error) {
+	set := map[string]bool{}
+	for _, d := range db.dbs {
+		oids, err := d.OIDs()
+		if err != nil {
+			return nil, err
+		}
+		for _, oid := range oids {
+			set[oid] = true
+		}
+	}
+
+	return sortedKeys(set), nil
+}
+
+// SetFsync applies to the primary database, the only one written to.
+func (db *chainedDatabase) SetFsync(enabled bool) {
+	db.dbs[0].SetFsync(enabled)
+}
+
+// SetObjectFormat applies to every database, as they must all agree on how
+// objects are named.
+func (db *chainedDatabase) SetObjectFormat(f *objectformat.Format) {
+	db.format = f
+	for _, d := range db.dbs {
+		d.SetObjectFormat(f)
+	}
+}
@@ -1,16 +1,19 @@
 package object
 
 import (
-	"bufio"
 	"bytes"
 	"compress/zlib"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strconv"
+	"sync"
 
-	"github.com/neocortical/got/lock"
+	"github.com/neocortical/got/faults"
+	"github.com/neocortical/got/fsync"
+	"github.com/neocortical/got/objectformat"
 )
 
 type Storable interface {
@@ -18,43 +21,70 @@ type Storable interface {
 	Serialize() []byte
 }
 
+// Database stores objects by their IDs. Read returns an error wrapping
+// ErrNotFound for objects that aren't stored. Objects are named with SHA-1
+// unless SetObjectFormat chooses another format before the database is used.
 type Database interface {
 	Store(s Storable) (oid string, err error)
 	Read(oid string) (result Storable, err error)
+	Has(oid string) bool
+	OIDs() ([]string, error)
+	SetFsync(enabled bool)
+	SetObjectFormat(f *objectformat.Format)
 }
 
+// ErrNotFound is returned for objects that aren't in a database.
+var ErrNotFound = errors.New("object not found")
+
 type database struct {
-	dir string
+	dir    string
+	fsync  bool
+	format *objectformat.Format
+
+	// packs are opened as they're needed, perhaps by several goroutines
+	// storing objects at once
+	packsMu sync.Mutex
+	packs   map[string]*Pack
+}
+
+// SetFsync makes Store flush new objects, and the directory entries naming
+// them, to stable storage before returning.
+func (db *database) SetFsync(enabled bool) {
+	db.fsync = enabled
+}
+
+// SetObjectFormat sets the hash objects are named by.
+func (db *database) SetObjectFormat(f *objectformat.Format) {
+	db.format = f
 }
 
 func NewDatabase(dir string) Database {
 	return &database{
-		dir: dir,
+		dir:    dir,
+		format: objectformat.Default,
 	}
 }
 
 func (db *database) Store(s Storable) (oid string, err error) {
-	data := s.Serialize()
-	var buf bytes.Buffer
-	buf.WriteString(fmt.Sprintf("%s %d\x00", s.Type(), len(data)))
-	buf.Write(data)
-	objData := buf.Bytes()
+	objData := serializeObject(s)
 
-	oid = GenerateOID(objData)
+	oid = db.format.Sum(objData)
 
 	objectFilename := db.objectPath(oid)
 
 	// short circuit if object exists
-	if _, err = os.Stat(objectFilename); err == nil {
-		return
+	if db.Has(oid) {
+		return oid, nil
 	}
 
 	dir, _ := filepath.Split(objectFilename)
 
+	newDir := false
 	_, err = os.Stat(dir)
 	if err != nil {
 		if os.IsNotExist(err) {
 			err = os.MkdirAll(dir, os.ModeDir|0755)
+			newDir = true
 		}
 	}
 	if err != nil {
@@ -70,56 +100,179 @@ func (db *database) Store(s Storable) (oid string, err error) {
 
 	w.Close()
 
-	l := lock.NewLockfile(objectFilename)
-	err = l.Acquire()
+	// Write to a temporary file and rename it into place rather than taking a
+	// lock, so that concurrent writers of the same object don't conflict.
+	tmp, err := ioutil.TempFile(dir, "tmp_obj_")
 	if err != nil {
-		return oid, fmt.Errorf("Unable to lock object for writing: %w", err)
+		return oid, fmt.Errorf("Unable to create temporary object file: %w", err)
 	}
 
-	err = l.Write(buf2.Bytes())
+	_, err = tmp.Write(buf2.Bytes())
+	faults.Point("object.write")
+	if err == nil && db.fsync {
+		err = fsync.File(tmp)
+	}
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
 	if err != nil {
+		os.Remove(tmp.Name())
 		return oid, fmt.Errorf("Unable to write object: %w", err)
 	}
 
-	err = l.Commit()
+	err = os.Chmod(tmp.Name(), 0444)
+	if err == nil {
+		err = os.Rename(tmp.Name(), objectFilename)
+	}
 	if err != nil {
+		os.Remove(tmp.Name())
 		return oid, fmt.Errorf("Error committing object to database: %w", err)
 	}
+	faults.Point("object.rename")
+
+	if db.fsync {
+		err = fsync.Dir(dir)
+		if err == nil && newDir {
+			err = fsync.Dir(db.dir)
+		}
+		if err != nil {
+			return oid, fmt.Errorf("Error syncing object directory: %w", err)
+		}
+	}
 
 	return
 }
 
-func (db *database) Read(oid string) (_ Storable, err error) {
-	result := &genericStorable{}
-
-	f, err := os.Open(db.objectPath(oid))
+func (db *database) Read(oid string) (result Storable, err error) {
+	data, err := ioutil.ReadFile(db.objectPath(oid))
+	if os.IsNotExist(err) {
+		if p := db.findPacked(oid); p != nil {
+			return p.Read(oid)
+		}
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, oid)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	unzipper, err := zlib.NewReader(f)
+	return ParseLoose(data)
+}
+
+// Has reports whether an object is stored, loose or packed.
+func (db *database) Has(oid string) bool {
+	if _, err := os.Stat(db.objectPath(oid)); err == nil {
+		return true
+	}
+
+	return db.findPacked(oid) != nil
+}
+
+// OIDs returns the IDs of every loose and packed object, sorted.
+func (db *database) OIDs() (result []string, err error) {
+	seen := map[string]bool{}
+
+	fanouts, err := ioutil.ReadDir(db.dir)
 	if err != nil {
 		return nil, err
 	}
+	for _, fanout := range fanouts {
+		if !fanout.IsDir() || len(fanout.Name()) != 2 {
+			continue
+		}
 
-	buf := bufio.NewReader(unzipper)
+		files, err := ioutil.ReadDir(filepath.Join(db.dir, fanout.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			if oid := fanout.Name() + file.Name(); db.format.IsOID(oid) {
+				seen[oid] = true
+			}
+		}
+	}
 
-	objectType, err := buf.ReadString(0x20)
+	packs, err := OpenPacks(db.dir, db.format)
 	if err != nil {
 		return nil, err
 	}
-	result.storableType = objectType[:len(objectType)-1]
+	for _, p := range packs {
+		for _, oid := range p.OIDs() {
+			seen[oid] = true
+		}
+	}
+
+	return sortedKeys(seen), nil
+}
 
-	sizeString, err := buf.ReadString(0x00)
+// ParseLoose decompresses and parses a loose object file, checking that the
+// zlib stream is complete and that the size in the header is right.
+func ParseLoose(data []byte) (_ Storable, err error) {
+	unzipper, err := zlib.NewReader(bytes.NewReader(data))
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("error inflating object: %w", err)
 	}
 
-	result.size, err = strconv.Atoi(sizeString[:len(sizeString)-1])
+	raw, err := ioutil.ReadAll(unzipper)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("error inflating object: %w", err)
+	}
+
+	space := bytes.IndexByte(raw, ' ')
+	nul := bytes.IndexByte(raw, 0)
+	if space == -1 || nul < space {
+		return nil, errors.New("invalid object header")
+	}
+
+	size, err := strconv.Atoi(string(raw[space+1 : nul]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid object size: %w", err)
+	}
+
+	result := &genericStorable{
+		storableType: string(raw[:space]),
+		size:         size,
+		data:         raw[nul+1:],
+	}
+	if len(result.data) != size {
+		return nil, fmt.Errorf("object size %d doesn't match header size %d", len(result.data), size)
+	}
+
+	return result, nil
+}
+
+// findPacked returns the pack holding an object, or nil. Packs are opened as
+// they're first needed, so ones written since the last lookup are found too.
+func (db *database) findPacked(oid string) *Pack {
+	db.packsMu.Lock()
+	defer db.packsMu.Unlock()
+
+	for _, p := range db.packs {
+		if p.Contains(oid) {
+			return p
+		}
+	}
+
+	idxPaths, _ := filepath.Glob(filepath.Join(db.dir, PackDir, "pack-*.idx"))
+	for _, idxPath := range idxPaths {
+		if _, opened := db.packs[idxPath]; opened {
+			continue
+		}
+
+		p, err := OpenPack(idxPath, db.format)
+		if err != nil {
+			continue
+		}
+		if db.packs == nil {
+			db.packs = map[string]*Pack{}
+		}
+		db.packs[idxPath] = p
+
+		if p.Contains(oid) {
+			return p
+		}
 	}
 
-	result.data, err = ioutil.ReadAll(buf)
-	return result, err
+	return nil
 }
@@ -0,0 +1,265 @@
+package object
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/neocortical/got/faults"
+	"github.com/neocortical/got/fsync"
+	"github.com/neocortical/got/lock"
+	"github.com/neocortical/got/objectformat"
+)
+
+const (
+	// KVFilename is the default name of a key/value store in an object
+	// database directory.
+	KVFilename = "objects.kv"
+
+	kvSignature   = "GOTKV\x00\x00\x01"
+	kvLockTimeout = time.Second
+)
+
+// kvRecord locates an object's compressed data in the store.
+type kvRecord struct {
+	offset int64
+	length int64
+}
+
+// kvDatabase keeps every object in a single append-only file: a signature,
+// then for each object its binary ID, the length of its data, and its data
+// compressed just as a loose object file would be. Writers append under a
+// lock; a record torn by a crash is ignored and then overwritten by the next
+// write.
+type kvDatabase struct {
+	path   string
+	fsync  bool
+	format *objectformat.Format
+
+	mu    sync.Mutex
+	index map[string]kvRecord
+	// end is the offset the index has been built up to
+	end int64
+}
+
+// NewKVDatabase returns a database stored in the single file at path, which
+// is created when the first object is stored.
+func NewKVDatabase(path string) Database {
+	return &kvDatabase{
+		path:   path,
+		format: objectformat.Default,
+		index:  map[string]kvRecord{},
+	}
+}
+
+func (db *kvDatabase) SetFsync(enabled bool) {
+	db.fsync = enabled
+}
+
+func (db *kvDatabase) SetObjectFormat(f *objectformat.Format) {
+	db.format = f
+}
+
+// recordHeaderLen is the length of a record's object ID and data length.
+func (db *kvDatabase) recordHeaderLen() int64 {
+	return int64(db.format.Size()) + 4
+}
+
+// refresh indexes records appended since the last refresh, by this process
+// or any other. The caller must hold db.mu.
+func (db *kvDatabase) refresh() error {
+	f, err := os.Open(db.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := stat.Size()
+
+	if db.end == 0 {
+		if size < int64(len(kvSignature)) {
+			// created but never written
+			return nil
+		}
+		signature := make([]byte, len(kvSignature))
+		if _, err = f.ReadAt(signature, 0); err != nil {
+			return err
+		}
+		if string(signature) != kvSignature {
+			return fmt.Errorf("%s is not an object store", db.path)
+		}
+		db.end = int64(len(kvSignature))
+	}
+
+	headerLen := db.recordHeaderLen()
+	header := make([]byte, headerLen)
+	for db.end+headerLen <= size {
+		if _, err = f.ReadAt(header, db.end); err != nil {
+			return err
+		}
+
+		length := int64(binary.BigEndian.Uint32(header[db.format.Size():]))
+		if db.end+headerLen+length > size {
+			// torn by a crash mid-write
+			break
+		}
+
+		db.index[hex.EncodeToString(header[:db.format.Size()])] = kvRecord{offset: db.end + headerLen, length: length}
+		db.end += headerLen + length
+	}
+
+	return nil
+}
+
+func (db *kvDatabase) find(oid string) (kvRecord, bool, error) {
+	if record, exists := db.index[oid]; exists {
+		return record, true, nil
+	}
+
+	if err := db.refresh(); err != nil {
+		return kvRecord{}, false, err
+	}
+
+	record, exists := db.index[oid]
+	return record, exists, nil
+}
+
+func (db *kvDatabase) Store(s Storable) (oid string, err error) {
+	objData := serializeObject(s)
+	oid = db.format.Sum(objData)
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, exists, err := db.find(oid); err != nil || exists {
+		return oid, err
+	}
+
+	var compressed bytes.Buffer
+	w := zlib.NewWriter(&compressed)
+	w.Write(objData)
+	w.Close()
+
+	// the lock only serializes writers; the store is appended to in place
+	lf := lock.NewLockfile(db.path)
+	if err = lf.AcquireTimeout(kvLockTimeout); err != nil {
+		return oid, fmt.Errorf("could not lock object store: %w", err)
+	}
+	defer lf.Rollback()
+
+	// another process may have written the object while we waited
+	if _, exists, err := db.find(oid); err != nil || exists {
+		return oid, err
+	}
+
+	f, err := os.OpenFile(db.path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return oid, fmt.Errorf("unable to open object store: %w", err)
+	}
+
+	err = db.appendRecord(f, oid, compressed.Bytes())
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return oid, fmt.Errorf("unable to write object: %w", err)
+	}
+
+	return oid, nil
+}
+
+func (db *kvDatabase) appendRecord(f *os.File, oid string, data []byte) (err error) {
+	if db.end == 0 {
+		db.end = int64(len(kvSignature))
+		if _, err = f.WriteAt([]byte(kvSignature), 0); err != nil {
+			return err
+		}
+	}
+
+	// drop any torn record left by a crash
+	if err = f.Truncate(db.end); err != nil {
+		return err
+	}
+
+	headerLen := db.recordHeaderLen()
+	record := make([]byte, headerLen, headerLen+int64(len(data)))
+	hex.Decode(record[:db.format.Size()], []byte(oid))
+	binary.BigEndian.PutUint32(record[db.format.Size():], uint32(len(data)))
+	record = append(record, data...)
+
+	if _, err = f.WriteAt(record, db.end); err != nil {
+		return err
+	}
+	faults.Point("object.write")
+	if db.fsync {
+		if err = fsync.File(f); err != nil {
+			return err
+		}
+	}
+
+	db.index[oid] = kvRecord{offset: db.end + headerLen, length: int64(len(data))}
+	db.end += int64(len(record))
+	return nil
+}
+
+func (db *kvDatabase) Read(oid string) (Storable, error) {
+	db.mu.Lock()
+	record, exists, err := db.find(oid)
+	db.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, oid)
+	}
+
+	f, err := os.Open(db.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data := make([]byte, record.length)
+	if _, err = f.ReadAt(data, record.offset); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	return ParseLoose(data)
+}
+
+func (db *kvDatabase) Has(oid string) bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	_, exists, _ := db.find(oid)
+	return exists
+}
+
+func (db *kvDatabase) OIDs() ([]string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.refresh(); err != nil {
+		return nil, err
+	}
+
+	set := make(map[string]bool, len(db.index))
+	for oid := range db.index {
+		set[oid] = true
+	}
+
+	return sortedKeys(set), nil
+}
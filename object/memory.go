@@ -0,0 +1,76 @@
+package object
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/neocortical/got/objectformat"
+)
+
+type memoryDatabase struct {
+	mu      sync.RWMutex
+	format  *objectformat.Format
+	objects map[string]*genericStorable
+}
+
+// NewMemoryDatabase returns a database that keeps objects in memory, for tests
+// and tools that don't need them to outlive the process.
+func NewMemoryDatabase() Database {
+	return &memoryDatabase{
+		format:  objectformat.Default,
+		objects: map[string]*genericStorable{},
+	}
+}
+
+func (db *memoryDatabase) Store(s Storable) (oid string, err error) {
+	oid = HashObject(db.format, s)
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, exists := db.objects[oid]; !exists {
+		data := append([]byte(nil), s.Serialize()...)
+		db.objects[oid] = &genericStorable{storableType: s.Type(), size: len(data), data: data}
+	}
+
+	return oid, nil
+}
+
+func (db *memoryDatabase) Read(oid string) (Storable, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	obj, exists := db.objects[oid]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, oid)
+	}
+
+	return obj, nil
+}
+
+func (db *memoryDatabase) Has(oid string) bool {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	_, exists := db.objects[oid]
+	return exists
+}
+
+func (db *memoryDatabase) OIDs() ([]string, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	set := make(map[string]bool, len(db.objects))
+	for oid := range db.objects {
+		set[oid] = true
+	}
+
+	return sortedKeys(set), nil
+}
+
+// SetFsync does nothing, as memory is never durable.
+func (db *memoryDatabase) SetFsync(enabled bool) {}
+
+func (db *memoryDatabase) SetObjectFormat(f *objectformat.Format) {
+	db.format = f
+}
@@ -0,0 +1,482 @@
+package object
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/neocortical/got/objectformat"
+)
+
+const (
+	// PackDir is the directory under the object database holding packfiles.
+	PackDir = "pack"
+
+	packSignature = "PACK"
+	idxSignature  = "\377tOc"
+	idxVersion    = 2
+
+	// maxDeltaDepth guards against delta chains that loop back on themselves.
+	maxDeltaDepth = 10000
+)
+
+const (
+	packCommit   = 1
+	packTree     = 2
+	packBlob     = 3
+	packTag      = 4
+	packOfsDelta = 6
+	packRefDelta = 7
+)
+
+var packTypeNames = map[byte]string{
+	packCommit: "commit",
+	packTree:   "tree",
+	packBlob:   "blob",
+	packTag:    "tag",
+}
+
+// Pack is a packfile and its (version 2) index. Object IDs, and the
+// checksums of both files, use the hash of the repository's object format.
+type Pack struct {
+	idxPath  string
+	packPath string
+	format   *objectformat.Format
+
+	oids     []string
+	offsets  []int64
+	crcs     []uint32
+	checksum []byte
+
+	// ends holds the offset each entry's data ends at, built on demand
+	ends map[int64]int64
+}
+
+// OpenPacks opens every pack in an object database directory.
+func OpenPacks(objectsDir string, f *objectformat.Format) (result []*Pack, err error) {
+	idxPaths, err := filepath.Glob(filepath.Join(objectsDir, PackDir, "pack-*.idx"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, idxPath := range idxPaths {
+		p, err := OpenPack(idxPath, f)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, p)
+	}
+
+	return result, nil
+}
+
+// OpenPack reads a pack index. The packfile itself is only read as objects
+// are requested.
+func OpenPack(idxPath string, f *objectformat.Format) (*Pack, error) {
+	data, err := ioutil.ReadFile(idxPath)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Pack{
+		idxPath:  idxPath,
+		packPath: strings.TrimSuffix(idxPath, ".idx") + ".pack",
+		format:   f,
+	}
+	if err = p.parseIndex(data); err != nil {
+		return nil, fmt.Errorf("error reading pack index %s: %w", filepath.Base(idxPath), err)
+	}
+
+	return p, nil
+}
+
+func (p *Pack) parseIndex(data []byte) error {
+	const headerLength = 8 + 256*4
+	oidLength := p.format.Size()
+	checksumLength := p.format.Size()
+
+	if len(data) < headerLength+2*checksumLength || string(data[:4]) != idxSignature {
+		return errors.New("not a version 2 pack index")
+	}
+	if version := binary.BigEndian.Uint32(data[4:8]); version != idxVersion {
+		return fmt.Errorf("unsupported pack index version %d", version)
+	}
+
+	count := int(binary.BigEndian.Uint32(data[headerLength-4 : headerLength]))
+	oidsStart := headerLength
+	crcsStart := oidsStart + count*oidLength
+	offsetsStart := crcsStart + count*4
+	largeStart := offsetsStart + count*4
+	if len(data) < largeStart+2*checksumLength {
+		return errors.New("pack index is truncated")
+	}
+
+	p.oids = make([]string, count)
+	p.crcs = make([]uint32, count)
+	p.offsets = make([]int64, count)
+	for i := 0; i < count; i++ {
+		p.oids[i] = hex.EncodeToString(data[oidsStart+i*oidLength : oidsStart+(i+1)*oidLength])
+		p.crcs[i] = binary.BigEndian.Uint32(data[crcsStart+i*4:])
+
+		offset := binary.BigEndian.Uint32(data[offsetsStart+i*4:])
+		if offset&0x80000000 == 0 {
+			p.offsets[i] = int64(offset)
+			continue
+		}
+
+		// the offset is an index into the table of 64-bit offsets
+		large := largeStart + int(offset&0x7fffffff)*8
+		if large+8 > len(data)-2*checksumLength {
+			return errors.New("pack index has a bad large offset")
+		}
+		p.offsets[i] = int64(binary.BigEndian.Uint64(data[large:]))
+	}
+
+	p.checksum = data[len(data)-2*checksumLength : len(data)-checksumLength]
+	return nil
+}
+
+// Name returns the pack's file name.
+func (p *Pack) Name() string {
+	return filepath.Base(p.packPath)
+}
+
+// OIDs returns the IDs of the objects in the pack, in sorted order.
+func (p *Pack) OIDs() []string {
+	return p.oids
+}
+
+// Contains reports whether the pack holds an object.
+func (p *Pack) Contains(oid string) bool {
+	return p.find(oid) != -1
+}
+
+func (p *Pack) find(oid string) int {
+	i := sort.SearchStrings(p.oids, oid)
+	if i == len(p.oids) || p.oids[i] != oid {
+		return -1
+	}
+
+	return i
+}
+
+// Read reads an object from the pack, resolving deltas.
+func (p *Pack) Read(oid string) (Storable, error) {
+	i := p.find(oid)
+	if i == -1 {
+		return nil, fmt.Errorf("object %s is not in %s", oid, p.Name())
+	}
+
+	f, err := os.Open(p.packPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	objectType, data, err := p.readAt(f, p.offsets[i], 0)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s from %s: %w", oid, p.Name(), err)
+	}
+
+	return &genericStorable{storableType: objectType, size: len(data), data: data}, nil
+}
+
+func (p *Pack) readAt(f *os.File, offset int64, depth int) (objectType string, data []byte, err error) {
+	if depth > maxDeltaDepth {
+		return "", nil, errors.New("delta chain is too long")
+	}
+
+	r := bufio.NewReader(io.NewSectionReader(f, offset, 1<<62))
+	c, err := r.ReadByte()
+	if err != nil {
+		return "", nil, err
+	}
+
+	typeNum := (c >> 4) & 7
+	size := int64(c & 0x0f)
+	for shift := uint(4); c&0x80 != 0; shift += 7 {
+		if c, err = r.ReadByte(); err != nil {
+			return "", nil, err
+		}
+		size |= int64(c&0x7f) << shift
+	}
+
+	var baseOffset int64
+	switch typeNum {
+	case packCommit, packTree, packBlob, packTag:
+		data, err = inflate(r, size)
+		return packTypeNames[typeNum], data, err
+	case packOfsDelta:
+		var distance int64
+		distance, err = readOffsetDistance(r)
+		baseOffset = offset - distance
+		if err == nil && (distance <= 0 || baseOffset < 0) {
+			err = errors.New("bad delta base offset")
+		}
+	case packRefDelta:
+		oid := make([]byte, p.format.Size())
+		if _, err = io.ReadFull(r, oid); err != nil {
+			break
+		}
+		base := p.find(hex.EncodeToString(oid))
+		if base == -1 {
+			return "", nil, fmt.Errorf("delta base %x is not in the pack", oid)
+		}
+		baseOffset = p.offsets[base]
+	default:
+		return "", nil, fmt.Errorf("unknown object type %d", typeNum)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	delta, err := inflate(r, size)
+	if err != nil {
+		return "", nil, err
+	}
+
+	objectType, base, err := p.readAt(f, baseOffset, depth+1)
+	if err != nil {
+		return "", nil, err
+	}
+
+	data, err = applyDelta(base, delta)
+	return objectType, data, err
+}
+
+// readOffsetDistance reads the variable-length distance back to the base of
+// an offset delta.
+func readOffsetDistance(r io.ByteReader) (int64, error) {
+	c, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+
+	distance := int64(c & 0x7f)
+	for c&0x80 != 0 {
+		if c, err = r.ReadByte(); err != nil {
+			return 0, err
+		}
+		distance = ((distance + 1) << 7) | int64(c&0x7f)
+	}
+
+	return distance, nil
+}
+
+func inflate(r io.Reader, size int64) ([]byte, error) {
+	unzipper, err := zlib.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadAll(unzipper)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) != size {
+		return nil, fmt.Errorf("inflated size %d doesn't match header size %d", len(data), size)
+	}
+
+	return data, nil
+}
+
+// applyDelta rebuilds an object from its base and a delta of copy and insert
+// instructions.
+func applyDelta(base []byte, delta []byte) ([]byte, error) {
+	r := bytes.NewReader(delta)
+
+	baseSize, err := binary.ReadUvarint(r)
+	if err != nil || baseSize != uint64(len(base)) {
+		return nil, errors.New("delta base size doesn't match")
+	}
+	resultSize, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, errors.New("bad delta result size")
+	}
+
+	result := make([]byte, 0, resultSize)
+	for {
+		cmd, err := r.ReadByte()
+		if err == io.EOF {
+			break
+		}
+
+		switch {
+		case cmd&0x80 != 0:
+			var offset, size uint64
+			for i := uint(0); i < 7; i++ {
+				if cmd&(1<<i) == 0 {
+					continue
+				}
+				b, err := r.ReadByte()
+				if err != nil {
+					return nil, errors.New("truncated delta")
+				}
+				if i < 4 {
+					offset |= uint64(b) << (8 * i)
+				} else {
+					size |= uint64(b) << (8 * (i - 4))
+				}
+			}
+			if size == 0 {
+				size = 0x10000
+			}
+			if offset+size > uint64(len(base)) {
+				return nil, errors.New("delta copies past the end of its base")
+			}
+			result = append(result, base[offset:offset+size]...)
+		case cmd != 0:
+			start := len(result)
+			result = append(result, make([]byte, cmd)...)
+			if _, err := io.ReadFull(r, result[start:]); err != nil {
+				return nil, errors.New("truncated delta")
+			}
+		default:
+			return nil, errors.New("bad delta instruction")
+		}
+	}
+
+	if uint64(len(result)) != resultSize {
+		return nil, errors.New("delta result size doesn't match")
+	}
+
+	return result, nil
+}
+
+// Verify checks the checksums of the packfile and its index, and that they
+// belong together.
+func (p *Pack) Verify() error {
+	checksumLength := p.format.Size()
+
+	idxData, err := ioutil.ReadFile(p.idxPath)
+	if err != nil {
+		return err
+	}
+	idxBody := idxData[:len(idxData)-checksumLength]
+	hasher := p.format.New()
+	hasher.Write(idxBody)
+	if !bytes.Equal(hasher.Sum(nil), idxData[len(idxBody):]) {
+		return fmt.Errorf("%s: index checksum mismatch", filepath.Base(p.idxPath))
+	}
+
+	f, err := os.Open(p.packPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	bodyLength := stat.Size() - int64(checksumLength)
+	if bodyLength < 12 {
+		return fmt.Errorf("%s: packfile is truncated", p.Name())
+	}
+
+	header := make([]byte, 12)
+	if _, err = f.ReadAt(header, 0); err != nil {
+		return err
+	}
+	if string(header[:4]) != packSignature {
+		return fmt.Errorf("%s: not a packfile", p.Name())
+	}
+	if count := binary.BigEndian.Uint32(header[8:]); int(count) != len(p.oids) {
+		return fmt.Errorf("%s: packfile has %d objects but its index has %d", p.Name(), count, len(p.oids))
+	}
+
+	hasher = p.format.New()
+	if _, err = io.Copy(hasher, io.NewSectionReader(f, 0, bodyLength)); err != nil {
+		return err
+	}
+	trailer := make([]byte, checksumLength)
+	if _, err = f.ReadAt(trailer, bodyLength); err != nil {
+		return err
+	}
+	if !bytes.Equal(hasher.Sum(nil), trailer) {
+		return fmt.Errorf("%s: packfile checksum mismatch", p.Name())
+	}
+	if !bytes.Equal(trailer, p.checksum) {
+		return fmt.Errorf("%s: packfile doesn't match its index", p.Name())
+	}
+
+	return nil
+}
+
+// VerifyObject reads an object, checking the CRC of its packed data and that
+// its contents hash to its ID.
+func (p *Pack) VerifyObject(oid string) (Storable, error) {
+	i := p.find(oid)
+	if i == -1 {
+		return nil, fmt.Errorf("object %s is not in %s", oid, p.Name())
+	}
+
+	if err := p.findEnds(); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(p.packPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	raw := make([]byte, p.ends[p.offsets[i]]-p.offsets[i])
+	if _, err = f.ReadAt(raw, p.offsets[i]); err != nil {
+		return nil, fmt.Errorf("error reading %s from %s: %w", oid, p.Name(), err)
+	}
+	if crc32.ChecksumIEEE(raw) != p.crcs[i] {
+		return nil, fmt.Errorf("CRC mismatch for %s in %s", oid, p.Name())
+	}
+
+	obj, err := p.Read(oid)
+	if err != nil {
+		return nil, err
+	}
+	if actual := HashObject(p.format, obj); actual != oid {
+		return nil, fmt.Errorf("%s in %s hashes to %s", oid, p.Name(), actual)
+	}
+
+	return obj, nil
+}
+
+// findEnds works out where each entry's data ends: at the start of the next
+// entry, or at the trailing checksum.
+func (p *Pack) findEnds() error {
+	if p.ends != nil {
+		return nil
+	}
+
+	stat, err := os.Stat(p.packPath)
+	if err != nil {
+		return err
+	}
+
+	sorted := append([]int64(nil), p.offsets...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	p.ends = map[int64]int64{}
+	for i, offset := range sorted {
+		end := stat.Size() - int64(p.format.Size())
+		if i+1 < len(sorted) {
+			end = sorted[i+1]
+		}
+		if end < offset {
+			return fmt.Errorf("%s: bad object offset %d", p.Name(), offset)
+		}
+		p.ends[offset] = end
+	}
+
+	return nil
+}
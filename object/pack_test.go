@@ -0,0 +1,256 @@
+package object
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/neocortical/got/objectformat"
+)
+
+type testPackEntry struct {
+	typeNum byte
+	data    []byte
+	// baseIndex is the entry a delta applies to
+	baseIndex int
+	// baseOID names the base of a ref delta outside the pack
+	baseOID string
+	// oid is the ID of the object the entry produces
+	oid string
+}
+
+// writeTestPack writes a version 2 pack and index holding the given entries
+// into dir/pack, returning the index path.
+func writeTestPack(t *testing.T, dir string, f *objectformat.Format, entries []testPackEntry) string {
+	var pack bytes.Buffer
+	pack.WriteString(packSignature)
+	binary.Write(&pack, binary.BigEndian, uint32(2))
+	binary.Write(&pack, binary.BigEndian, uint32(len(entries)))
+
+	offsets := make([]int64, len(entries))
+	crcs := make([]uint32, len(entries))
+	for i, e := range entries {
+		offsets[i] = int64(pack.Len())
+		var raw bytes.Buffer
+
+		size := len(e.data)
+		c := e.typeNum<<4 | byte(size&0x0f)
+		size >>= 4
+		for size > 0 {
+			raw.WriteByte(c | 0x80)
+			c = byte(size & 0x7f)
+			size >>= 7
+		}
+		raw.WriteByte(c)
+
+		switch e.typeNum {
+		case packOfsDelta:
+			// single-byte distances are enough for these tests
+			raw.WriteByte(byte(offsets[i] - offsets[e.baseIndex]))
+		case packRefDelta:
+			base := e.baseOID
+			if base == "" {
+				base = entries[e.baseIndex].oid
+			}
+			oid, _ := hex.DecodeString(base)
+			raw.Write(oid)
+		}
+
+		w := zlib.NewWriter(&raw)
+		w.Write(e.data)
+		w.Close()
+
+		crcs[i] = crc32.ChecksumIEEE(raw.Bytes())
+		pack.Write(raw.Bytes())
+	}
+	packSum := f.New()
+	packSum.Write(pack.Bytes())
+	pack.Write(packSum.Sum(nil))
+
+	order := make([]int, len(entries))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return entries[order[i]].oid < entries[order[j]].oid })
+
+	var idx bytes.Buffer
+	idx.WriteString(idxSignature)
+	binary.Write(&idx, binary.BigEndian, uint32(idxVersion))
+	for b := 0; b < 256; b++ {
+		count := 0
+		for _, e := range entries {
+			first, _ := hex.DecodeString(e.oid[:2])
+			if int(first[0]) <= b {
+				count++
+			}
+		}
+		binary.Write(&idx, binary.BigEndian, uint32(count))
+	}
+	for _, i := range order {
+		oid, _ := hex.DecodeString(entries[i].oid)
+		idx.Write(oid)
+	}
+	for _, i := range order {
+		binary.Write(&idx, binary.BigEndian, crcs[i])
+	}
+	for _, i := range order {
+		binary.Write(&idx, binary.BigEndian, uint32(offsets[i]))
+	}
+	idx.Write(packSum.Sum(nil))
+	idxSum := f.New()
+	idxSum.Write(idx.Bytes())
+	idx.Write(idxSum.Sum(nil))
+
+	packDir := filepath.Join(dir, PackDir)
+	if err := os.MkdirAll(packDir, 0755); err != nil {
+		t.Fatalf("error creating pack dir: %v", err)
+	}
+	name := "pack-" + hex.EncodeToString(packSum.Sum(nil))
+	if err := ioutil.WriteFile(filepath.Join(packDir, name+".pack"), pack.Bytes(), 0444); err != nil {
+		t.Fatalf("error writing pack: %v", err)
+	}
+	idxPath := filepath.Join(packDir, name+".idx")
+	if err := ioutil.WriteFile(idxPath, idx.Bytes(), 0444); err != nil {
+		t.Fatalf("error writing pack index: %v", err)
+	}
+
+	return idxPath
+}
+
+func blobOID(f *objectformat.Format, content string) string {
+	return HashObject(f, &genericStorable{storableType: "blob", data: []byte(content)})
+}
+
+func testPackEntries(f *objectformat.Format) ([]testPackEntry, []string) {
+	contents := []string{"hello, world!\n", "hello, there!\n", "hello, there!\nmore\n"}
+
+	// "hello, " + "there!" + "\n"
+	delta1 := []byte{14, 14, 0x90, 7, 6}
+	delta1 = append(delta1, "there!"...)
+	delta1 = append(delta1, 0x91, 13, 1)
+
+	// all of the base + "more\n"
+	delta2 := []byte{14, 19, 0x90, 14, 5}
+	delta2 = append(delta2, "more\n"...)
+
+	return []testPackEntry{
+		{typeNum: packBlob, data: []byte(contents[0]), oid: blobOID(f, contents[0])},
+		{typeNum: packOfsDelta, data: delta1, baseIndex: 0, oid: blobOID(f, contents[1])},
+		{typeNum: packRefDelta, data: delta2, baseIndex: 1, oid: blobOID(f, contents[2])},
+	}, contents
+}
+
+func TestPackRead(t *testing.T) {
+	for _, f := range []*objectformat.Format{objectformat.SHA1, objectformat.SHA256} {
+		t.Run(f.String(), func(t *testing.T) {
+			testPackRead(t, f)
+		})
+	}
+}
+
+func testPackRead(t *testing.T, f *objectformat.Format) {
+	dir, err := ioutil.TempDir("", "got_test_pack_*")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	entries, contents := testPackEntries(f)
+	writeTestPack(t, dir, f, entries)
+
+	packs, err := OpenPacks(dir, f)
+	if err != nil || len(packs) != 1 {
+		t.Fatalf("expected one pack but got %d (%v)", len(packs), err)
+	}
+	p := packs[0]
+
+	if err = p.Verify(); err != nil {
+		t.Errorf("expected no errors verifying the pack but got: %v", err)
+	}
+
+	for i, e := range entries {
+		obj, err := p.VerifyObject(e.oid)
+		if err != nil {
+			t.Fatalf("expected no errors reading entry %d but got: %v", i, err)
+		}
+		if obj.Type() != "blob" || string(obj.Serialize()) != contents[i] {
+			t.Errorf("expected blob '%s' for entry %d but got %s '%s'", contents[i], i, obj.Type(), obj.Serialize())
+		}
+	}
+
+	// the database falls back to packs for objects that aren't loose
+	db := NewDatabase(dir)
+	db.SetObjectFormat(f)
+	obj, err := db.Read(entries[2].oid)
+	if err != nil || string(obj.Serialize()) != contents[2] {
+		t.Errorf("expected to read '%s' from the database but got '%s' (%v)", contents[2], obj.Serialize(), err)
+	}
+	if _, err = db.Store(obj); err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	if _, err = os.Stat(db.(*database).objectPath(entries[2].oid)); !os.IsNotExist(err) {
+		t.Error("expected storing a packed object not to write a loose copy")
+	}
+}
+
+func TestPackVerifyDetectsCorruption(t *testing.T) {
+	dir, err := ioutil.TempDir("", "got_test_pack_*")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	entries, _ := testPackEntries(objectformat.SHA1)
+	idxPath := writeTestPack(t, dir, objectformat.SHA1, entries)
+	packPath := strings.TrimSuffix(idxPath, ".idx") + ".pack"
+
+	// flip a bit in the middle of the first entry's compressed data
+	data, _ := ioutil.ReadFile(packPath)
+	data[16] ^= 0x01
+	os.Chmod(packPath, 0644)
+	ioutil.WriteFile(packPath, data, 0644)
+
+	p, err := OpenPack(idxPath, objectformat.SHA1)
+	if err != nil {
+		t.Fatalf("expected no errors opening the pack but got: %v", err)
+	}
+
+	err = p.Verify()
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("expected a checksum mismatch but got: %v", err)
+	}
+	_, err = p.VerifyObject(entries[0].oid)
+	if err == nil || !strings.Contains(err.Error(), "CRC mismatch") {
+		t.Errorf("expected a CRC mismatch but got: %v", err)
+	}
+}
+
+func TestParseLooseDetectsTruncation(t *testing.T) {
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	w.Write([]byte("blob 13\x00hello, world!"))
+	w.Close()
+
+	if _, err := ParseLoose(buf.Bytes()); err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	if _, err := ParseLoose(buf.Bytes()[:buf.Len()-6]); err == nil {
+		t.Error("expected an error for a truncated object")
+	}
+
+	buf.Reset()
+	w = zlib.NewWriter(&buf)
+	w.Write([]byte("blob 20\x00hello, world!"))
+	w.Close()
+	if _, err := ParseLoose(buf.Bytes()); err == nil {
+		t.Error("expected an error for an object shorter than its header says")
+	}
+}
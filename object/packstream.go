@@ -0,0 +1,260 @@
+package object
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/neocortical/got/objectformat"
+)
+
+var packTypeNumbers = map[string]byte{
+	"commit": packCommit,
+	"tree":   packTree,
+	"blob":   packBlob,
+	"tag":    packTag,
+}
+
+// WritePack writes a packfile holding the given objects of db to w, as sent
+// over the wire: whole objects, without deltas, and no index.
+func WritePack(w io.Writer, f *objectformat.Format, db Database, oids []string) error {
+	sum := f.New()
+	out := io.MultiWriter(w, sum)
+
+	var header bytes.Buffer
+	header.WriteString(packSignature)
+	binary.Write(&header, binary.BigEndian, uint32(2))
+	binary.Write(&header, binary.BigEndian, uint32(len(oids)))
+	if _, err := out.Write(header.Bytes()); err != nil {
+		return err
+	}
+
+	for _, oid := range oids {
+		obj, err := db.Read(oid)
+		if err != nil {
+			return fmt.Errorf("error reading object %s: %w", oid, err)
+		}
+		typeNum, ok := packTypeNumbers[obj.Type()]
+		if !ok {
+			return fmt.Errorf("can't pack object %s of type %s", oid, obj.Type())
+		}
+		data := obj.Serialize()
+
+		var entry bytes.Buffer
+		size := len(data)
+		c := typeNum<<4 | byte(size&0x0f)
+		for size >>= 4; size > 0; size >>= 7 {
+			entry.WriteByte(c | 0x80)
+			c = byte(size & 0x7f)
+		}
+		entry.WriteByte(c)
+
+		zipper := zlib.NewWriter(&entry)
+		zipper.Write(data)
+		if err = zipper.Close(); err != nil {
+			return err
+		}
+		if _, err = out.Write(entry.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	_, err := w.Write(sum.Sum(nil))
+	return err
+}
+
+// packStream reads a packfile as it arrives, keeping track of the offset and
+// the checksum of what has been read.
+type packStream struct {
+	r      *bufio.Reader
+	sum    hash.Hash
+	offset int64
+}
+
+func (ps *packStream) Read(p []byte) (int, error) {
+	n, err := ps.r.Read(p)
+	ps.sum.Write(p[:n])
+	ps.offset += int64(n)
+	return n, err
+}
+
+func (ps *packStream) ReadByte() (byte, error) {
+	c, err := ps.r.ReadByte()
+	if err == nil {
+		ps.sum.Write([]byte{c})
+		ps.offset++
+	}
+	return c, err
+}
+
+// unpackedObject is an entry of a packfile, as read from the stream.
+type unpackedObject struct {
+	objectType string
+	data       []byte
+	// for deltas, the base is either at baseOffset or named by baseOID
+	delta      bool
+	baseOffset int64
+	baseOID    string
+}
+
+// UnpackObjects reads a packfile from r and stores its objects in db,
+// returning their IDs. Deltas may be against objects elsewhere in the pack
+// or, for thin packs, objects db already has. Data following the packfile
+// may be read from r too.
+func UnpackObjects(r io.Reader, f *objectformat.Format, db Database) ([]string, error) {
+	ps := &packStream{r: bufio.NewReader(r), sum: f.New()}
+
+	var header [12]byte
+	if _, err := io.ReadFull(ps, header[:]); err != nil {
+		return nil, fmt.Errorf("error reading pack header: %w", err)
+	}
+	if string(header[:4]) != packSignature || binary.BigEndian.Uint32(header[4:8]) != 2 {
+		return nil, errors.New("not a version 2 packfile")
+	}
+	count := binary.BigEndian.Uint32(header[8:])
+
+	entries := make([]*unpackedObject, 0, count)
+	byOffset := map[int64]*unpackedObject{}
+	for i := uint32(0); i < count; i++ {
+		offset := ps.offset
+		entry, err := readPackEntry(ps, f, offset)
+		if err != nil {
+			return nil, fmt.Errorf("error reading pack entry %d: %w", i, err)
+		}
+		entries = append(entries, entry)
+		byOffset[offset] = entry
+	}
+
+	expected := ps.sum.Sum(nil)
+	checksum := make([]byte, f.Size())
+	if _, err := io.ReadFull(ps.r, checksum); err != nil {
+		return nil, fmt.Errorf("error reading pack checksum: %w", err)
+	}
+	if !bytes.Equal(checksum, expected) {
+		return nil, errors.New("pack checksum mismatch")
+	}
+
+	// resolve deltas in as many passes as it takes for their bases to be
+	// resolved first
+	byOID := map[string]*unpackedObject{}
+	var oids []string
+	resolve := func(entry *unpackedObject) error {
+		obj := &genericStorable{storableType: entry.objectType, size: len(entry.data), data: entry.data}
+		oid, err := db.Store(obj)
+		if err != nil {
+			return fmt.Errorf("error storing object: %w", err)
+		}
+		entry.delta = false
+		byOID[oid] = entry
+		oids = append(oids, oid)
+		return nil
+	}
+
+	pending := 0
+	for _, entry := range entries {
+		if entry.delta {
+			pending++
+		} else if err := resolve(entry); err != nil {
+			return nil, err
+		}
+	}
+	for pending > 0 {
+		progress := false
+		for _, entry := range entries {
+			if !entry.delta {
+				continue
+			}
+
+			var baseType string
+			var base []byte
+			if entry.baseOID == "" {
+				b := byOffset[entry.baseOffset]
+				if b == nil {
+					return nil, fmt.Errorf("delta base at offset %d is not in the pack", entry.baseOffset)
+				}
+				if b.delta {
+					continue
+				}
+				baseType, base = b.objectType, b.data
+			} else if b := byOID[entry.baseOID]; b != nil {
+				baseType, base = b.objectType, b.data
+			} else if db.Has(entry.baseOID) {
+				obj, err := db.Read(entry.baseOID)
+				if err != nil {
+					return nil, err
+				}
+				baseType, base = obj.Type(), obj.Serialize()
+			} else {
+				continue
+			}
+
+			data, err := applyDelta(base, entry.data)
+			if err != nil {
+				return nil, err
+			}
+			entry.objectType, entry.data = baseType, data
+			if err = resolve(entry); err != nil {
+				return nil, err
+			}
+			pending--
+			progress = true
+		}
+
+		if !progress {
+			return nil, fmt.Errorf("pack has %d unresolved deltas", pending)
+		}
+	}
+
+	return oids, nil
+}
+
+func readPackEntry(ps *packStream, f *objectformat.Format, offset int64) (*unpackedObject, error) {
+	c, err := ps.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	typeNum := (c >> 4) & 7
+	size := int64(c & 0x0f)
+	for shift := uint(4); c&0x80 != 0; shift += 7 {
+		if c, err = ps.ReadByte(); err != nil {
+			return nil, err
+		}
+		size |= int64(c&0x7f) << shift
+	}
+
+	entry := &unpackedObject{}
+	switch typeNum {
+	case packCommit, packTree, packBlob, packTag:
+		entry.objectType = packTypeNames[typeNum]
+	case packOfsDelta:
+		distance, err := readOffsetDistance(ps)
+		if err != nil {
+			return nil, err
+		}
+		if distance <= 0 || distance > offset {
+			return nil, errors.New("bad delta base offset")
+		}
+		entry.delta, entry.baseOffset = true, offset-distance
+	case packRefDelta:
+		oid := make([]byte, f.Size())
+		if _, err = io.ReadFull(ps, oid); err != nil {
+			return nil, err
+		}
+		entry.delta, entry.baseOID = true, hex.EncodeToString(oid)
+	default:
+		return nil, fmt.Errorf("unknown object type %d", typeNum)
+	}
+
+	if entry.data, err = inflate(ps, size); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
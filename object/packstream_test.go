@@ -0,0 +1,101 @@
+package object
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/neocortical/got/objectformat"
+)
+
+func TestWriteAndUnpack(t *testing.T) {
+	f := objectformat.SHA1
+	src := NewMemoryDatabase()
+	var oids []string
+	for _, content := range []string{"one\n", "two\n", strings.Repeat("three\n", 1000)} {
+		oid, err := src.Store(&genericStorable{storableType: "blob", data: []byte(content)})
+		if err != nil {
+			t.Fatalf("error storing blob: %v", err)
+		}
+		oids = append(oids, oid)
+	}
+
+	var pack bytes.Buffer
+	if err := WritePack(&pack, f, src, oids); err != nil {
+		t.Fatalf("expected no errors writing the pack but got: %v", err)
+	}
+
+	dst := NewMemoryDatabase()
+	unpacked, err := UnpackObjects(bytes.NewReader(pack.Bytes()), f, dst)
+	if err != nil {
+		t.Fatalf("expected no errors unpacking but got: %v", err)
+	}
+	if len(unpacked) != len(oids) {
+		t.Errorf("expected %d objects but got %d", len(oids), len(unpacked))
+	}
+	for _, oid := range oids {
+		if !dst.Has(oid) {
+			t.Errorf("expected %s to be unpacked", oid)
+		}
+	}
+
+	corrupt := append([]byte{}, pack.Bytes()...)
+	corrupt[len(corrupt)-1] ^= 0xff
+	if _, err = UnpackObjects(bytes.NewReader(corrupt), f, NewMemoryDatabase()); err == nil {
+		t.Error("expected a bad checksum to fail the unpack")
+	}
+}
+
+func TestUnpackDeltas(t *testing.T) {
+	for _, f := range []*objectformat.Format{objectformat.SHA1, objectformat.SHA256} {
+		t.Run(f.String(), func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "got_test_pack_*")
+			if err != nil {
+				t.Fatalf("error creating temp dir: %v", err)
+			}
+			defer os.RemoveAll(dir)
+
+			entries, contents := testPackEntries(f)
+			pack := readTestPack(t, dir, f, entries)
+
+			db := NewMemoryDatabase()
+			db.SetObjectFormat(f)
+			if _, err = UnpackObjects(bytes.NewReader(pack), f, db); err != nil {
+				t.Fatalf("expected no errors unpacking but got: %v", err)
+			}
+			for i, e := range entries {
+				obj, err := db.Read(e.oid)
+				if err != nil || string(obj.Serialize()) != contents[i] {
+					t.Errorf("expected entry %d to be '%s' but got: %v", i, contents[i], err)
+				}
+			}
+
+			// a thin pack leaves out the base of a ref delta
+			delta := entries[2]
+			delta.baseOID = entries[1].oid
+			thin := readTestPack(t, filepath.Join(dir, "thin"), f, []testPackEntry{entries[0], delta})
+			base := NewMemoryDatabase()
+			base.SetObjectFormat(f)
+			if _, err = UnpackObjects(bytes.NewReader(thin), f, base); err == nil {
+				t.Error("expected a missing base to fail the unpack")
+			}
+			base.Store(&genericStorable{storableType: "blob", data: []byte(contents[1])})
+			if _, err = UnpackObjects(bytes.NewReader(thin), f, base); err != nil {
+				t.Errorf("expected no errors unpacking a thin pack but got: %v", err)
+			}
+		})
+	}
+}
+
+// readTestPack writes a test pack into dir and returns its bytes.
+func readTestPack(t *testing.T, dir string, f *objectformat.Format, entries []testPackEntry) []byte {
+	idx := writeTestPack(t, dir, f, entries)
+	pack, err := ioutil.ReadFile(strings.TrimSuffix(idx, ".idx") + ".pack")
+	if err != nil {
+		t.Fatalf("error reading pack: %v", err)
+	}
+	return pack
+}
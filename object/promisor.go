@@ -0,0 +1,37 @@
+package object
+
+import (
+	"errors"
+	"fmt"
+)
+
+// promisorDatabase fetches objects it's missing when they're first read.
+type promisorDatabase struct {
+	Database
+	fetch func(oid string) error
+	// fetching stops objects read while fetching from being fetched too
+	fetching bool
+}
+
+// NewPromisorDatabase returns a database that reads from db and, for objects
+// db doesn't have, calls fetch to get them and reads them again. fetch may
+// do nothing for objects it has no way of getting.
+func NewPromisorDatabase(db Database, fetch func(oid string) error) Database {
+	return &promisorDatabase{Database: db, fetch: fetch}
+}
+
+func (db *promisorDatabase) Read(oid string) (Storable, error) {
+	result, err := db.Database.Read(oid)
+	if !errors.Is(err, ErrNotFound) || db.fetching {
+		return result, err
+	}
+
+	db.fetching = true
+	err = db.fetch(oid)
+	db.fetching = false
+	if err != nil {
+		return nil, fmt.Errorf("error fetching object %s: %w", oid, err)
+	}
+
+	return db.Database.Read(oid)
+}
@@ -0,0 +1,50 @@
+package object
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/neocortical/got/objectformat"
+)
+
+func TestPromisorDatabase(t *testing.T) {
+	remote := NewMemoryDatabase()
+	promised, _ := remote.Store(&genericStorable{storableType: "blob", data: []byte("promised")})
+	missing := HashObject(objectformat.SHA1, &genericStorable{storableType: "blob", data: []byte("missing")})
+
+	local := NewMemoryDatabase()
+	var fetched []string
+	var db Database
+	db = NewPromisorDatabase(local, func(oid string) error {
+		fetched = append(fetched, oid)
+		// reads while fetching don't fetch again
+		if _, err := db.Read(missing); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected a nested read to fail but got: %v", err)
+		}
+
+		obj, err := remote.Read(oid)
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		_, err = local.Store(obj)
+		return err
+	})
+
+	if db.Has(promised) {
+		t.Error("expected Has not to fetch")
+	}
+	obj, err := db.Read(promised)
+	if err != nil || string(obj.Serialize()) != "promised" {
+		t.Fatalf("expected the promised object to be fetched but got: %v", err)
+	}
+	if _, err = db.Read(promised); err != nil || len(fetched) != 1 {
+		t.Errorf("expected one fetch but got %d (%v)", len(fetched), err)
+	}
+
+	if _, err = db.Read(missing); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for an object nobody has but got: %v", err)
+	}
+}
@@ -1,9 +1,12 @@
 package object
 
 import (
-	"crypto/sha1"
+	"bytes"
 	"fmt"
 	"path"
+	"sort"
+
+	"github.com/neocortical/got/objectformat"
 )
 
 type genericStorable struct {
@@ -20,14 +23,30 @@ func (gs *genericStorable) Serialize() []byte {
 	return gs.data
 }
 
-// GenerateOID generates a SHA1 object ID for use in the database, index, refs, etc.
-func GenerateOID(data []byte) string {
-	hasher := sha1.New()
-	hasher.Write(data)
-	hash := hasher.Sum(nil)
-	return fmt.Sprintf("%x", hash)
+// HashObject returns the object ID a Storable would be stored under in a
+// database of the given format, without storing it.
+func HashObject(f *objectformat.Format, s Storable) string {
+	return f.Sum(serializeObject(s))
+}
+
+func serializeObject(s Storable) []byte {
+	data := s.Serialize()
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("%s %d\x00", s.Type(), len(data)))
+	buf.Write(data)
+	return buf.Bytes()
 }
 
 func (db *database) objectPath(oid string) string {
 	return path.Join(db.dir, oid[0:2], oid[2:])
 }
+
+func sortedKeys(set map[string]bool) []string {
+	result := make([]string, 0, len(set))
+	for key := range set {
+		result = append(result, key)
+	}
+	sort.Strings(result)
+
+	return result
+}
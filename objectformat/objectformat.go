@@ -0,0 +1,105 @@
+// Package objectformat describes the hash functions objects can be named by,
+// following git's extensions.objectFormat setting.
+package objectformat
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// Format is a hash function and the object IDs it produces.
+type Format struct {
+	name string
+	size int
+	new  func() hash.Hash
+}
+
+var (
+	SHA1   = &Format{name: "sha1", size: sha1.Size, new: sha1.New}
+	SHA256 = &Format{name: "sha256", size: sha256.Size, new: sha256.New}
+
+	// Default is the format of repositories that don't name one.
+	Default = SHA1
+
+	formats = []*Format{SHA1, SHA256}
+)
+
+// Parse returns the format with the given name, as used in
+// extensions.objectFormat and --object-format.
+func Parse(name string) (*Format, error) {
+	for _, f := range formats {
+		if strings.EqualFold(name, f.name) {
+			return f, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown object format '%s'", name)
+}
+
+func (f *Format) String() string {
+	return f.name
+}
+
+// Size returns the length of a raw object ID.
+func (f *Format) Size() int {
+	return f.size
+}
+
+// HexSize returns the length of an object ID in hex.
+func (f *Format) HexSize() int {
+	return 2 * f.size
+}
+
+// New returns a hash of the format, for checksumming files as well as naming
+// objects.
+func (f *Format) New() hash.Hash {
+	return f.new()
+}
+
+// Sum returns the hex object ID of data.
+func (f *Format) Sum(data []byte) string {
+	h := f.new()
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ZeroOID returns the all-zero ID that stands for no object.
+func (f *Format) ZeroOID() string {
+	return strings.Repeat("0", f.HexSize())
+}
+
+// IsOID reports whether s is a full object ID of the format, in lowercase
+// hex.
+func (f *Format) IsOID(s string) bool {
+	return len(s) == f.HexSize() && isLowerHex(s)
+}
+
+// IsOID reports whether s is a full object ID of any format.
+func IsOID(s string) bool {
+	for _, f := range formats {
+		if f.IsOID(s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsZeroOID reports whether s is the zero ID of any format.
+func IsZeroOID(s string) bool {
+	return IsOID(s) && strings.Trim(s, "0") == ""
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if (s[i] < '0' || s[i] > '9') && (s[i] < 'a' || s[i] > 'f') {
+			return false
+		}
+	}
+
+	return true
+}
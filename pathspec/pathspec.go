@@ -0,0 +1,361 @@
+package pathspec
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/neocortical/got/wildmatch"
+)
+
+// Magic is a set of pathspec magic signatures modifying how a pathspec matches.
+type Magic int
+
+const (
+	// Top makes the pathspec relative to the workspace root rather than the
+	// current directory.
+	Top Magic = 1 << iota
+	// Literal disables wildcards.
+	Literal
+	// Glob makes wildcards stop at slashes and enables "**", like gitignore
+	// patterns. Without it, '*' matches slashes too.
+	Glob
+	// ICase matches case insensitively.
+	ICase
+	// Exclude removes the paths the pathspec matches from the result.
+	Exclude
+)
+
+var magicNames = map[string]Magic{
+	"top":     Top,
+	"literal": Literal,
+	"glob":    Glob,
+	"icase":   ICase,
+	"exclude": Exclude,
+}
+
+// Options control how pathspecs are parsed. They mirror git's
+// GIT_*_PATHSPECS environment variables, along with where the pathspecs
+// were given.
+type Options struct {
+	// Root is the absolute path of the workspace. Absolute pathspecs inside
+	// it are made relative to it.
+	Root string
+	// Prefix is the current directory relative to the workspace root.
+	Prefix string
+	// Literal treats every pathspec literally, without wildcards or magic.
+	Literal bool
+	// Glob gives every pathspec glob magic by default.
+	Glob bool
+	// NoGlob gives every pathspec literal magic by default.
+	NoGlob bool
+	// ICase gives every pathspec icase magic.
+	ICase bool
+}
+
+type item struct {
+	original      string
+	match         string
+	magic         Magic
+	nowildcardLen int
+	// implicit items weren't given, and are never reported unmatched
+	implicit bool
+}
+
+// Pathspec is a parsed list of pathspecs that paths can be matched against.
+// An empty Pathspec matches everything.
+type Pathspec struct {
+	items []item
+	seen  []bool
+}
+
+// Parse parses pathspec arguments, including their magic signatures: either
+// the long form ":(magic,...)pattern" or the short forms ":!pattern" and
+// ":^pattern" (exclude) and ":/pattern" (top). Given only exclude
+// pathspecs, it selects the current directory to exclude from.
+func Parse(args []string, opts Options) (result *Pathspec, err error) {
+	result = &Pathspec{}
+
+	for _, arg := range args {
+		it, err := parseItem(arg, opts)
+		if err != nil {
+			return nil, err
+		}
+		result.items = append(result.items, it)
+	}
+
+	if len(result.items) > 0 && result.Empty() && opts.Prefix != "" {
+		result.items = append(result.items, item{
+			match:         opts.Prefix,
+			magic:         Literal,
+			nowildcardLen: len(opts.Prefix),
+			implicit:      true,
+		})
+	}
+	result.seen = make([]bool, len(result.items))
+
+	return result, nil
+}
+
+func parseItem(arg string, opts Options) (result item, err error) {
+	result.original = arg
+	pattern := arg
+
+	if opts.Literal {
+		result.magic = Literal
+	} else if strings.HasPrefix(arg, ":") {
+		result.magic, pattern, err = parseMagic(arg)
+		if err != nil {
+			return
+		}
+	}
+
+	if result.magic&(Literal|Glob) == Literal|Glob {
+		return result, fmt.Errorf("%s: 'literal' and 'glob' are incompatible", arg)
+	}
+	if result.magic&(Literal|Glob) == 0 {
+		if opts.Glob {
+			result.magic |= Glob
+		} else if opts.NoGlob {
+			result.magic |= Literal
+		}
+	}
+	if opts.ICase {
+		result.magic |= ICase
+	}
+
+	if opts.Root != "" && filepath.IsAbs(pattern) {
+		relative, err := filepath.Rel(opts.Root, pattern)
+		if err != nil || strings.HasPrefix(relative, "..") {
+			return result, fmt.Errorf("%s: '%s' is outside repository", arg, pattern)
+		}
+		pattern = filepath.ToSlash(relative)
+	} else if result.magic&Top == 0 && opts.Prefix != "" {
+		pattern = opts.Prefix + "/" + pattern
+	}
+
+	result.match, err = normalize(pattern)
+	if err != nil {
+		return result, fmt.Errorf("%s: %w", arg, err)
+	}
+
+	result.nowildcardLen = len(result.match)
+	if result.magic&Literal == 0 {
+		if i := strings.IndexAny(result.match, "*?[\\"); i != -1 {
+			result.nowildcardLen = i
+		}
+	}
+
+	return
+}
+
+func parseMagic(arg string) (magic Magic, pattern string, err error) {
+	if strings.HasPrefix(arg, ":(") {
+		end := strings.Index(arg, ")")
+		if end == -1 {
+			return 0, "", fmt.Errorf("missing ')' at the end of pathspec magic in '%s'", arg)
+		}
+
+		for _, name := range strings.Split(arg[2:end], ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			m, known := magicNames[name]
+			if !known {
+				return 0, "", fmt.Errorf("invalid pathspec magic '%s' in '%s'", name, arg)
+			}
+			magic |= m
+		}
+
+		return magic, arg[end+1:], nil
+	}
+
+	// short form: symbols until an alphanumeric, '*' or a second ':'
+	i := 1
+	for ; i < len(arg); i++ {
+		switch arg[i] {
+		case '!', '^':
+			magic |= Exclude
+			continue
+		case '/':
+			magic |= Top
+			continue
+		case ':':
+			i++
+		}
+		break
+	}
+
+	return magic, arg[i:], nil
+}
+
+// normalize cleans up a pathspec relative to the workspace root, keeping any
+// trailing slash. The empty string matches everything.
+func normalize(p string) (string, error) {
+	trailingSlash := strings.HasSuffix(p, "/") && p != "/"
+
+	cleaned := path.Clean(p)
+	if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("'%s' is outside repository", p)
+	}
+
+	p = strings.TrimPrefix(cleaned, "/")
+	if p == "." {
+		p = ""
+	}
+
+	if trailingSlash && p != "" {
+		p += "/"
+	}
+
+	return p, nil
+}
+
+// Empty reports whether the pathspec has no positive items and so matches
+// everything.
+func (ps *Pathspec) Empty() bool {
+	for _, it := range ps.items {
+		if it.magic&Exclude == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Match reports whether a path (relative to the workspace root, using forward
+// slashes) is selected: it matches at least one positive pathspec (or there
+// are none) and no exclude pathspec.
+func (ps *Pathspec) Match(p string, isDir bool) bool {
+	matched := ps.Empty()
+
+	for i, it := range ps.items {
+		if it.magic&Exclude != 0 {
+			continue
+		}
+		if it.matches(p, isDir) {
+			ps.seen[i] = true
+			matched = true
+		}
+	}
+
+	if !matched {
+		return false
+	}
+
+	for _, it := range ps.items {
+		if it.magic&Exclude != 0 && it.matches(p, isDir) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// MatchesWithin reports whether any path inside a directory could be selected,
+// so that walks through trees or the workspace can skip whole directories.
+func (ps *Pathspec) MatchesWithin(dir string) bool {
+	if ps.Empty() {
+		return true
+	}
+
+	for _, it := range ps.items {
+		if it.magic&Exclude != 0 {
+			continue
+		}
+
+		literal := it.match[:it.nowildcardLen]
+		d, l := dir+"/", literal
+		if it.magic&ICase != 0 {
+			d, l = strings.ToLower(d), strings.ToLower(l)
+		}
+
+		// the pathspec lies inside the directory, or the directory inside it
+		if strings.HasPrefix(l, d) || strings.HasPrefix(d, l) || it.matches(dir, true) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Roots returns the leading directories (or files) the positive pathspecs are
+// confined to, so that callers only need to walk those parts of the
+// workspace. A root of "" means the whole workspace.
+func (ps *Pathspec) Roots() (result []string) {
+	if ps.Empty() {
+		return []string{""}
+	}
+
+	seen := map[string]bool{}
+	for _, it := range ps.items {
+		if it.magic&Exclude != 0 {
+			continue
+		}
+
+		root := it.match[:it.nowildcardLen]
+		if it.nowildcardLen < len(it.match) || it.magic&ICase != 0 {
+			root = root[:strings.LastIndex(root, "/")+1]
+			if it.magic&ICase != 0 {
+				root = ""
+			}
+		}
+		root = strings.TrimSuffix(root, "/")
+
+		if !seen[root] {
+			seen[root] = true
+			result = append(result, root)
+		}
+	}
+
+	return
+}
+
+// Unmatched returns the positive pathspecs that haven't matched any path
+// passed to Match so far.
+func (ps *Pathspec) Unmatched() (result []string) {
+	for i, it := range ps.items {
+		if it.magic&Exclude == 0 && !it.implicit && !ps.seen[i] {
+			result = append(result, it.original)
+		}
+	}
+
+	return
+}
+
+func (it item) matches(name string, isDir bool) bool {
+	match := it.match
+	if match == "" {
+		return true
+	}
+
+	compare := name
+	if it.magic&ICase != 0 {
+		match, compare = strings.ToLower(match), strings.ToLower(compare)
+	}
+
+	literal := match[:it.nowildcardLen]
+	if it.nowildcardLen == len(match) {
+		if strings.HasSuffix(literal, "/") {
+			// "dir/" selects a directory and its contents only
+			return (isDir && compare+"/" == literal) || strings.HasPrefix(compare, literal)
+		}
+		return compare == literal || strings.HasPrefix(compare, literal+"/")
+	}
+
+	if compare == match {
+		return true
+	}
+	if !strings.HasPrefix(compare, literal) {
+		return false
+	}
+
+	var flags wildmatch.Flags
+	if it.magic&Glob != 0 {
+		flags |= wildmatch.PathName
+	}
+
+	return wildmatch.Match(match, compare, flags)
+}
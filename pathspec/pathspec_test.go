@@ -0,0 +1,138 @@
+package pathspec
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	var tests = []struct {
+		args     []string
+		opts     Options
+		path     string
+		isDir    bool
+		expected bool
+	}{
+		{nil, Options{}, "anything", false, true},
+		{[]string{"."}, Options{}, "a/b.txt", false, true},
+		{[]string{"a.txt"}, Options{}, "a.txt", false, true},
+		{[]string{"a.txt"}, Options{}, "b.txt", false, false},
+		{[]string{"dir"}, Options{}, "dir/file.txt", false, true},
+		{[]string{"dir"}, Options{}, "dirt/file.txt", false, false},
+		{[]string{"dir/"}, Options{}, "dir/file.txt", false, true},
+		{[]string{"dir/"}, Options{}, "dir", false, false},
+		{[]string{"dir/"}, Options{}, "dir", true, true},
+		{[]string{"./dir/../a.txt"}, Options{}, "a.txt", false, true},
+		{[]string{"*.c"}, Options{}, "src/main.c", false, true},
+		{[]string{"src/*.c"}, Options{}, "src/lib/util.c", false, true},
+		{[]string{":(glob)src/*.c"}, Options{}, "src/lib/util.c", false, false},
+		{[]string{":(glob)src/**/*.c"}, Options{}, "src/lib/util.c", false, true},
+		{[]string{":(literal)*.c"}, Options{}, "main.c", false, false},
+		{[]string{":(literal)*.c"}, Options{}, "*.c", false, true},
+		{[]string{"*.c"}, Options{Literal: true}, "main.c", false, false},
+		{[]string{"*.c"}, Options{NoGlob: true}, "main.c", false, false},
+		{[]string{"src/*.c"}, Options{Glob: true}, "src/lib/util.c", false, false},
+		{[]string{":(icase)README"}, Options{}, "readme", false, true},
+		{[]string{"README"}, Options{ICase: true}, "Readme", false, true},
+		{[]string{"README"}, Options{}, "readme", false, false},
+		{[]string{":!*.c"}, Options{}, "main.c", false, false},
+		{[]string{":!*.c"}, Options{}, "main.h", false, true},
+		{[]string{":^*.c"}, Options{}, "main.h", false, true},
+		{[]string{"src", ":(exclude)src/gen"}, Options{}, "src/gen/out.c", false, false},
+		{[]string{"src", ":(exclude)src/gen"}, Options{}, "src/main.c", false, true},
+		{[]string{"file.txt"}, Options{Prefix: "sub"}, "sub/file.txt", false, true},
+		{[]string{":/file.txt"}, Options{Prefix: "sub"}, "file.txt", false, true},
+		{[]string{":(top)file.txt"}, Options{Prefix: "sub"}, "file.txt", false, true},
+		{[]string{":!*.c"}, Options{Prefix: "sub"}, "sub/main.h", false, true},
+		{[]string{":!*.c"}, Options{Prefix: "sub"}, "main.h", false, false},
+		{[]string{":!*.c"}, Options{Prefix: "sub"}, "sub/main.c", false, false},
+		{[]string{"/work/dir/a.txt"}, Options{Root: "/work"}, "dir/a.txt", false, true},
+	}
+
+	for i, test := range tests {
+		ps, err := Parse(test.args, test.opts)
+		if err != nil {
+			t.Errorf("test %d failed: unexpected error: %v", i, err)
+			continue
+		}
+
+		actual := ps.Match(test.path, test.isDir)
+		if actual != test.expected {
+			t.Errorf("test %d failed: %v matching %s expected %v but got %v", i, test.args, test.path, test.expected, actual)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	var tests = [][]string{
+		{":(unknown)foo"},
+		{":(literal,glob)foo"},
+		{":(glob"},
+		{"../outside"},
+	}
+
+	for i, test := range tests {
+		_, err := Parse(test, Options{})
+		if err == nil {
+			t.Errorf("test %d failed: expected an error parsing %v", i, test)
+		}
+	}
+}
+
+func TestRootsAndUnmatched(t *testing.T) {
+	ps, err := Parse([]string{"a.txt", "src/*.c", "docs/", ":!docs/old", "*.md"}, Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expectedRoots := []string{"a.txt", "src", "docs", ""}
+	if roots := ps.Roots(); !reflect.DeepEqual(roots, expectedRoots) {
+		t.Errorf("expected roots %v but got %v", expectedRoots, roots)
+	}
+
+	ps.Match("src/main.c", false)
+	ps.Match("docs/index.txt", false)
+
+	expectedUnmatched := []string{"a.txt", "*.md"}
+	if unmatched := ps.Unmatched(); !reflect.DeepEqual(unmatched, expectedUnmatched) {
+		t.Errorf("expected unmatched %v but got %v", expectedUnmatched, unmatched)
+	}
+}
+
+func TestImplicitPrefixNotUnmatched(t *testing.T) {
+	ps, err := Parse([]string{":!*.c"}, Options{Prefix: "sub"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if ps.Empty() {
+		t.Errorf("expected excludes from a subdirectory to be limited to it")
+	}
+	if unmatched := ps.Unmatched(); len(unmatched) > 0 {
+		t.Errorf("expected no unmatched pathspecs but got %v", unmatched)
+	}
+}
+
+func TestMatchesWithin(t *testing.T) {
+	ps, err := Parse([]string{"src/lib/*.c"}, Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var tests = []struct {
+		dir      string
+		expected bool
+	}{
+		{"src", true},
+		{"src/lib", true},
+		{"src/lib/deep", true},
+		{"docs", false},
+		{"srcs", false},
+	}
+
+	for i, test := range tests {
+		if actual := ps.MatchesWithin(test.dir); actual != test.expected {
+			t.Errorf("test %d failed: MatchesWithin(%s) expected %v but got %v", i, test.dir, test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+// Package pktline reads and writes the pkt-line framing of git's wire
+// protocols, and the side-band channels multiplexed over it.
+package pktline
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MaxLength is the longest a pkt-line can be, length prefix included.
+	MaxLength = 65520
+	// MaxPayload is the most data a single pkt-line can carry.
+	MaxPayload = MaxLength - 4
+
+	lengthSize = 4
+	errPrefix  = "ERR "
+)
+
+// Kind distinguishes data lines from the special packets.
+type Kind int
+
+const (
+	Data Kind = iota
+	// Flush ends a message ("0000").
+	Flush
+	// Delim separates the sections of a message in protocol v2 ("0001").
+	Delim
+	// ResponseEnd ends a response in stateless protocol v2 ("0002").
+	ResponseEnd
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Flush:
+		return "flush"
+	case Delim:
+		return "delim"
+	case ResponseEnd:
+		return "response-end"
+	}
+	return "data"
+}
+
+// ErrTooLong is returned for data that doesn't fit in a pkt-line.
+var ErrTooLong = errors.New("pkt-line payload too long")
+
+// RemoteError is an error the other side reported, with an "ERR" line or on
+// the error side-band.
+type RemoteError struct {
+	Message string
+}
+
+func (e *RemoteError) Error() string {
+	return "remote error: " + e.Message
+}
+
+// Writer writes pkt-lines.
+type Writer struct {
+	w io.Writer
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// Write writes data as a single pkt-line.
+func (pw *Writer) Write(data []byte) error {
+	if len(data) > MaxPayload {
+		return ErrTooLong
+	}
+
+	buf := make([]byte, lengthSize+len(data))
+	copy(buf, fmt.Sprintf("%04x", len(buf)))
+	copy(buf[lengthSize:], data)
+	_, err := pw.w.Write(buf)
+	return err
+}
+
+// WriteString writes a pkt-line of text, formatted as by fmt.Sprintf. Text
+// lines end with a newline, which is added if missing.
+func (pw *Writer) WriteString(format string, args ...interface{}) error {
+	line := fmt.Sprintf(format, args...)
+	if len(line) == 0 || line[len(line)-1] != '\n' {
+		line += "\n"
+	}
+	return pw.Write([]byte(line))
+}
+
+// Flush writes a flush packet.
+func (pw *Writer) Flush() error {
+	_, err := io.WriteString(pw.w, "0000")
+	return err
+}
+
+// Delim writes a delimiter packet.
+func (pw *Writer) Delim() error {
+	_, err := io.WriteString(pw.w, "0001")
+	return err
+}
+
+// Error writes an "ERR" line, reporting a fatal error to the other side.
+func (pw *Writer) Error(message string) error {
+	return pw.WriteString("%s%s", errPrefix, message)
+}
+
+// ResponseEnd writes a response-end packet.
+func (pw *Writer) ResponseEnd() error {
+	_, err := io.WriteString(pw.w, "0002")
+	return err
+}
+
+// Reader reads pkt-lines.
+type Reader struct {
+	r   io.Reader
+	buf [MaxLength]byte
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r}
+}
+
+// Read reads the next packet. The data it returns is only valid until the
+// next call. "ERR" lines are returned as a RemoteError.
+func (pr *Reader) Read() (data []byte, kind Kind, err error) {
+	if _, err = io.ReadFull(pr.r, pr.buf[:lengthSize]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = errors.New("truncated pkt-line length")
+		}
+		return nil, Data, err
+	}
+
+	length, err := strconv.ParseUint(string(pr.buf[:lengthSize]), 16, 16)
+	if err != nil {
+		return nil, Data, fmt.Errorf("bad pkt-line length '%s'", pr.buf[:lengthSize])
+	}
+	switch {
+	case length == 0:
+		return nil, Flush, nil
+	case length == 1:
+		return nil, Delim, nil
+	case length == 2:
+		return nil, ResponseEnd, nil
+	case length < lengthSize || length > MaxLength:
+		return nil, Data, fmt.Errorf("bad pkt-line length %d", length)
+	}
+
+	data = pr.buf[lengthSize:length]
+	if _, err = io.ReadFull(pr.r, data); err != nil {
+		return nil, Data, fmt.Errorf("truncated pkt-line: %w", err)
+	}
+	if bytes.HasPrefix(data, []byte(errPrefix)) {
+		return nil, Data, &RemoteError{Message: strings.TrimSuffix(string(data[len(errPrefix):]), "\n")}
+	}
+
+	return data, Data, nil
+}
+
+// ReadLine reads the next packet, returning text lines without their
+// trailing newline.
+func (pr *Reader) ReadLine() (line string, kind Kind, err error) {
+	data, kind, err := pr.Read()
+	if err != nil || kind != Data {
+		return "", kind, err
+	}
+
+	if n := len(data); n > 0 && data[n-1] == '\n' {
+		data = data[:n-1]
+	}
+	return string(data), Data, nil
+}
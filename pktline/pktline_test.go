@@ -0,0 +1,97 @@
+package pktline
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	pw := NewWriter(&buf)
+	pw.WriteString("command=%s", "ls-refs")
+	pw.Delim()
+	pw.Write([]byte("raw"))
+	pw.Flush()
+	pw.ResponseEnd()
+	pw.Error("not our ref")
+
+	expected := "0014command=ls-refs\n00010007raw000000020014ERR not our ref\n"
+	if buf.String() != expected {
+		t.Errorf("expected '%s' but got '%s'", expected, buf.String())
+	}
+
+	pr := NewReader(&buf)
+	for _, e := range []struct {
+		line string
+		kind Kind
+	}{
+		{"command=ls-refs", Data},
+		{"", Delim},
+		{"raw", Data},
+		{"", Flush},
+		{"", ResponseEnd},
+	} {
+		line, kind, err := pr.ReadLine()
+		if err != nil || line != e.line || kind != e.kind {
+			t.Errorf("expected %s '%s' but got %s '%s' (%v)", e.kind, e.line, kind, line, err)
+		}
+	}
+
+	var remoteErr *RemoteError
+	if _, _, err := pr.ReadLine(); !errors.As(err, &remoteErr) || remoteErr.Message != "not our ref" {
+		t.Errorf("expected a remote error but got: %v", err)
+	}
+	if _, _, err := pr.ReadLine(); err != io.EOF {
+		t.Errorf("expected EOF but got: %v", err)
+	}
+}
+
+func TestBadPackets(t *testing.T) {
+	if err := NewWriter(ioutil.Discard).Write(make([]byte, MaxPayload+1)); err != ErrTooLong {
+		t.Errorf("expected ErrTooLong but got: %v", err)
+	}
+
+	for _, data := range []string{"00", "zzzz", "0003", "0009abc"} {
+		if _, _, err := NewReader(strings.NewReader(data)).Read(); err == nil || err == io.EOF {
+			t.Errorf("expected an error reading '%s' but got: %v", data, err)
+		}
+	}
+}
+
+func TestSideband(t *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789"), 2*MaxSidebandPayload/10+1)
+
+	var buf bytes.Buffer
+	pw := NewWriter(&buf)
+	io.WriteString(NewSidebandWriter(pw, BandProgress), "Counting objects\r")
+	if n, err := NewSidebandWriter(pw, BandData).Write(payload); err != nil || n != len(payload) {
+		t.Fatalf("expected to write %d bytes but wrote %d (%v)", len(payload), n, err)
+	}
+	io.WriteString(NewSidebandWriter(pw, BandProgress), "done.\n")
+	pw.Flush()
+	pw.WriteString("after")
+
+	var progress bytes.Buffer
+	pr := NewReader(&buf)
+	sr := NewSidebandReader(pr, &progress)
+	data, err := ioutil.ReadAll(sr)
+	if err != nil || !bytes.Equal(data, payload) {
+		t.Errorf("expected the payload back but got %d bytes (%v)", len(data), err)
+	}
+	if progress.String() != "Counting objects\rdone.\n" {
+		t.Errorf("unexpected progress: '%s'", progress.String())
+	}
+	if line, _, err := pr.ReadLine(); line != "after" {
+		t.Errorf("expected the sideband to stop at its flush but got '%s' (%v)", line, err)
+	}
+
+	buf.Reset()
+	io.WriteString(NewSidebandWriter(pw, BandError), "disk full\n")
+	if _, err = ioutil.ReadAll(NewSidebandReader(NewReader(&buf), &progress)); err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Errorf("expected the error band to fail the read but got: %v", err)
+	}
+}
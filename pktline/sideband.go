@@ -0,0 +1,111 @@
+package pktline
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Side-band channels.
+const (
+	BandData     = 1
+	BandProgress = 2
+	BandError    = 3
+
+	// MaxSidebandPayload is the most data a side-band-64k packet carries.
+	MaxSidebandPayload = MaxPayload - 1
+)
+
+// sidebandWriter writes to one side-band channel.
+type sidebandWriter struct {
+	w    *Writer
+	band byte
+}
+
+// NewSidebandWriter returns a writer that sends what's written to it on a
+// side-band channel, split into as many packets as it takes.
+func NewSidebandWriter(w *Writer, band byte) io.Writer {
+	return &sidebandWriter{w: w, band: band}
+}
+
+func (sw *sidebandWriter) Write(p []byte) (int, error) {
+	written := 0
+	buf := make([]byte, 0, MaxPayload)
+	for written < len(p) {
+		n := len(p) - written
+		if n > MaxSidebandPayload {
+			n = MaxSidebandPayload
+		}
+
+		buf = append(append(buf[:0], sw.band), p[written:written+n]...)
+		if err := sw.w.Write(buf); err != nil {
+			return written, err
+		}
+		written += n
+	}
+
+	return written, nil
+}
+
+// SidebandReader reads the data channel of a side-band stream, up to the
+// flush packet that ends it, passing progress messages on as they arrive.
+type SidebandReader struct {
+	r        *Reader
+	progress io.Writer
+	buf      []byte
+	done     bool
+}
+
+// NewSidebandReader returns a SidebandReader writing progress messages to
+// progress, which may be nil to discard them.
+func NewSidebandReader(r *Reader, progress io.Writer) *SidebandReader {
+	return &SidebandReader{r: r, progress: progress}
+}
+
+func (sr *SidebandReader) Read(p []byte) (int, error) {
+	for len(sr.buf) == 0 {
+		if sr.done {
+			return 0, io.EOF
+		}
+
+		data, kind, err := sr.r.Read()
+		switch {
+		case err != nil:
+			return 0, err
+		case kind == Flush:
+			sr.done = true
+			return 0, io.EOF
+		case kind != Data || len(data) == 0:
+			return 0, fmt.Errorf("unexpected %s packet in side-band stream", kind)
+		}
+
+		switch data[0] {
+		case BandData:
+			sr.buf = append(sr.buf[:0], data[1:]...)
+		case BandProgress:
+			if sr.progress != nil {
+				sr.progress.Write(data[1:])
+			}
+		case BandError:
+			return 0, &RemoteError{Message: strings.TrimSuffix(string(data[1:]), "\n")}
+		default:
+			return 0, fmt.Errorf("bad side-band channel %d", data[0])
+		}
+	}
+
+	n := copy(p, sr.buf)
+	sr.buf = sr.buf[n:]
+	return n, nil
+}
+
+// Drain reads the rest of the stream, up to its flush packet.
+func (sr *SidebandReader) Drain() error {
+	buf := make([]byte, 4096)
+	for {
+		if _, err := sr.Read(buf); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
@@ -14,7 +14,7 @@ import (
 // TypeCommit is the type returned by Commit objects.
 const TypeCommit = "commit"
 
-var authorRegexp = regexp.MustCompile(`^(.*) <(.*)> ([0-9]+) -([0-9]{2})([0-9]{2})$`)
+var authorRegexp = regexp.MustCompile(`^(.*) <(.*)> ([0-9]+) ([+-])([0-9]{2})([0-9]{2})$`)
 
 type Author struct {
 	Name  string
@@ -27,26 +27,47 @@ func (a Author) String() string {
 }
 
 type Commit struct {
-	Parent  string
-	TreeOID string
-	Author  Author
-	Message string
+	// Parents holds the first parent first, and more for a merge.
+	Parents   []string
+	TreeOID   string
+	Author    Author
+	Committer Author
+	Message   string
 }
 
+// NewCommit returns a commit with at most one parent, committed by its
+// author.
 func NewCommit(parent string, treeOID string, author Author, message string) Commit {
+	var parents []string
+	if parent != "" {
+		parents = append(parents, parent)
+	}
+
 	return Commit{
-		Parent:  parent,
-		TreeOID: treeOID,
-		Author:  author,
-		Message: message,
+		Parents:   parents,
+		TreeOID:   treeOID,
+		Author:    author,
+		Committer: author,
+		Message:   message,
 	}
 }
 
+// Parent returns the commit's first parent, or an empty string for a root
+// commit.
+func (c Commit) Parent() string {
+	if len(c.Parents) == 0 {
+		return ""
+	}
+
+	return c.Parents[0]
+}
+
 func DeserializeCommit(data []byte) (result Commit, err error) {
 	r := bufio.NewReader(bytes.NewBuffer(data))
 
 	var line string
 	var headers = map[string]string{}
+	var parents []string
 	for err == nil {
 		line, err = r.ReadString('\n')
 		if line == "\n" {
@@ -59,6 +80,10 @@ func DeserializeCommit(data []byte) (result Commit, err error) {
 			break
 		}
 
+		if line[:split] == "parent" {
+			parents = append(parents, strings.TrimSpace(line[split+1:]))
+			continue
+		}
 		headers[line[:split]] = strings.TrimSpace(line[split+1:])
 	}
 	if err != nil {
@@ -75,12 +100,23 @@ func DeserializeCommit(data []byte) (result Commit, err error) {
 	}
 	err = nil
 
-	author, err := parseAuthorString(headers["author"])
+	author, err := ParseAuthor(headers["author"])
+	if err != nil {
+		return result, err
+	}
+	committer, err := ParseAuthor(headers["committer"])
 	if err != nil {
 		return result, err
 	}
 
-	return NewCommit(headers["parent"], headers["tree"], author, message), nil
+	// Serialize ends the message with a newline of its own
+	return Commit{
+		Parents:   parents,
+		TreeOID:   headers["tree"],
+		Author:    author,
+		Committer: committer,
+		Message:   strings.TrimSuffix(message, "\n"),
+	}, nil
 }
 
 func (c Commit) Type() string {
@@ -88,19 +124,21 @@ func (c Commit) Type() string {
 }
 
 func (c Commit) Serialize() []byte {
-	var parentLine string
-	if c.Parent != "" {
-		parentLine = fmt.Sprintf("parent %s\n", c.Parent)
+	var parentLines string
+	for _, parent := range c.Parents {
+		parentLines += fmt.Sprintf("parent %s\n", parent)
 	}
 
-	data := fmt.Sprintf("tree %s\n%sauthor %s\ncommitter %s\n\n%s\n", c.TreeOID, parentLine, c.Author.String(), c.Author.String(), c.Message)
+	data := fmt.Sprintf("tree %s\n%sauthor %s\ncommitter %s\n\n%s\n", c.TreeOID, parentLines, c.Author.String(), c.Committer.String(), c.Message)
 
 	return []byte(data)
 }
 
-func parseAuthorString(input string) (result Author, err error) {
+// ParseAuthor parses an identity line such as the value of a commit's author
+// header: "Name <email> timestamp zone".
+func ParseAuthor(input string) (result Author, err error) {
 	m := authorRegexp.FindStringSubmatch(input)
-	if len(m) != 6 {
+	if len(m) != 7 {
 		return result, fmt.Errorf("invalid author format: '%s'", input)
 	}
 
@@ -108,9 +146,14 @@ func parseAuthorString(input string) (result Author, err error) {
 	result.Email = m[2]
 
 	tstamp, _ := strconv.ParseInt(m[3], 10, 64)
-	hoursOffset, _ := strconv.Atoi(m[4])
-	minsOffset, _ := strconv.Atoi(m[4])
+	hoursOffset, _ := strconv.Atoi(m[5])
+	minsOffset, _ := strconv.Atoi(m[6])
+
+	offset := hoursOffset*60*60 + minsOffset*60
+	if m[4] == "-" {
+		offset = -offset
+	}
 
-	result.Time = time.Unix(tstamp, 0).In(time.FixedZone("", -(hoursOffset*60*60 + minsOffset*60)))
+	result.Time = time.Unix(tstamp, 0).In(time.FixedZone("", offset))
 	return
 }
@@ -19,8 +19,8 @@ three lines`)
 	if actual.TreeOID != "0e3d6d78ab2bce1cfdcdc9c4f745f186c8b6daa7" {
 		t.Errorf("unexpected value for tree OID: %s", actual.TreeOID)
 	}
-	if actual.Parent != "bccd3e06dd549a5c27497f6a11243019ba2abb80" {
-		t.Errorf("unexpected value for parent OID: %s", actual.Parent)
+	if actual.Parent() != "bccd3e06dd549a5c27497f6a11243019ba2abb80" {
+		t.Errorf("unexpected value for parent OID: %s", actual.Parent())
 	}
 	if actual.Author.Name != "Nathan Smith" {
 		t.Errorf("unexpected value for author name: %s", actual.Author.Name)
@@ -35,3 +35,50 @@ three lines`)
 		t.Errorf("unexpected value for commit message: %s", actual.Message)
 	}
 }
+
+func TestParseAuthorTimezones(t *testing.T) {
+	var tests = []struct {
+		input    string
+		expected int
+	}{
+		{"A U Thor <author@example.com> 1609095922 -0800", -8 * 60 * 60},
+		{"A U Thor <author@example.com> 1609095922 +0530", 5*60*60 + 30*60},
+		{"A U Thor <author@example.com> 1609095922 +0000", 0},
+	}
+
+	for i, test := range tests {
+		actual, err := ParseAuthor(test.input)
+		if err != nil {
+			t.Fatalf("test %d failed: unexpected error: %v", i, err)
+		}
+		if _, offset := actual.Time.Zone(); offset != test.expected {
+			t.Errorf("test %d failed: expected offset %d but got %d", i, test.expected, offset)
+		}
+		if actual.String() != test.input {
+			t.Errorf("test %d failed: round trip produced '%s'", i, actual.String())
+		}
+	}
+}
+
+func TestCommitRoundTrip(t *testing.T) {
+	author, _ := ParseAuthor("A U Thor <author@example.com> 1609095922 -0800")
+	committer, _ := ParseAuthor("C O Mitter <committer@example.com> 1609099999 +0000")
+	commit := Commit{
+		Parents:   []string{"bccd3e06dd549a5c27497f6a11243019ba2abb80", "0e3d6d78ab2bce1cfdcdc9c4f745f186c8b6daa7"},
+		TreeOID:   "0e3d6d78ab2bce1cfdcdc9c4f745f186c8b6daa7",
+		Author:    author,
+		Committer: committer,
+		Message:   "a merge\n\nwith a body",
+	}
+
+	actual, err := DeserializeCommit(commit.Serialize())
+	if err != nil {
+		t.Fatalf("expected nil error but got: %v", err)
+	}
+	if string(actual.Serialize()) != string(commit.Serialize()) {
+		t.Errorf("round trip produced:\n%s", actual.Serialize())
+	}
+	if len(actual.Parents) != 2 || actual.Committer.Name != "C O Mitter" || actual.Message != commit.Message {
+		t.Errorf("unexpected commit: %+v", actual)
+	}
+}
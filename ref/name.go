@@ -0,0 +1,35 @@
+package ref
+
+import (
+	"regexp"
+	"strings"
+)
+
+var pseudoRefRegexp = regexp.MustCompile(`^[A-Z_]+$`)
+
+// ValidName reports whether name is acceptable as the name of a ref, following
+// the rules of git check-ref-format. Besides refs under refs/, only pseudo-refs
+// like HEAD and FETCH_HEAD are allowed.
+func ValidName(name string) bool {
+	if pseudoRefRegexp.MatchString(name) {
+		return true
+	}
+	if !strings.HasPrefix(name, refsDir+"/") || strings.HasSuffix(name, "/") ||
+		strings.HasSuffix(name, ".") || strings.Contains(name, "..") || strings.Contains(name, "@{") {
+		return false
+	}
+
+	for _, c := range name {
+		if c < 0x20 || c == 0x7f || strings.ContainsRune(" ~^:?*[\\", c) {
+			return false
+		}
+	}
+
+	for _, component := range strings.Split(name, "/") {
+		if component == "" || strings.HasPrefix(component, ".") || strings.HasSuffix(component, lockSuffix) {
+			return false
+		}
+	}
+
+	return true
+}
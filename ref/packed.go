@@ -0,0 +1,190 @@
+package ref
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/neocortical/got/lock"
+	"github.com/neocortical/got/objectformat"
+)
+
+const (
+	packedRefsFilename = "packed-refs"
+	packedRefsHeader   = "# pack-refs with: peeled fully-peeled sorted \n"
+	tagsPrefix         = "refs/tags/"
+)
+
+// Reference is a named pointer to an object. Peeled is the object an
+// annotated tag ultimately points to, when known; Target is the ref a
+// symbolic ref points to.
+type Reference struct {
+	Name   string
+	OID    string
+	Peeled string
+	Target string
+}
+
+// PeelFunc returns the object a tag ultimately points to, or "" if oid isn't
+// a tag.
+type PeelFunc func(oid string) (string, error)
+
+func (r *refs) packedRefsPath() string {
+	return path.Join(r.dir, packedRefsFilename)
+}
+
+// readPackedRefs parses the packed-refs file into a map from ref name.
+func (r *refs) readPackedRefs() (result map[string]Reference, err error) {
+	result = map[string]Reference{}
+
+	data, err := ioutil.ReadFile(r.packedRefsPath())
+	if os.IsNotExist(err) {
+		return result, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading packed-refs: %w", err)
+	}
+
+	var previous string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+			continue
+		case strings.HasPrefix(line, "^"):
+			ref, ok := result[previous]
+			if !ok {
+				return nil, fmt.Errorf("unexpected line in packed-refs: '%s'", line)
+			}
+			ref.Peeled = line[1:]
+			result[previous] = ref
+		default:
+			fields := strings.SplitN(line, " ", 2)
+			if len(fields) != 2 || !objectformat.IsOID(fields[0]) {
+				return nil, fmt.Errorf("unexpected line in packed-refs: '%s'", line)
+			}
+			result[fields[1]] = Reference{Name: fields[1], OID: fields[0]}
+			previous = fields[1]
+		}
+	}
+
+	return result, scanner.Err()
+}
+
+// writePackedRefs replaces the packed-refs file through a lock the caller
+// already holds.
+func writePackedRefs(lf *lock.Lockfile, packed map[string]Reference) error {
+	names := make([]string, 0, len(packed))
+	for name := range packed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	buf.WriteString(packedRefsHeader)
+	for _, name := range names {
+		ref := packed[name]
+		fmt.Fprintf(&buf, "%s %s\n", ref.OID, ref.Name)
+		if ref.Peeled != "" {
+			fmt.Fprintf(&buf, "^%s\n", ref.Peeled)
+		}
+	}
+
+	if err := lf.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("error writing packed-refs: %w", err)
+	}
+
+	return nil
+}
+
+// Pack moves loose refs into the packed-refs file and removes the loose
+// copies. Without all, only tags (and refs already packed) are packed, since
+// branches are expected to move.
+func (r *refs) Pack(all bool, prune bool, peel PeelFunc) (err error) {
+	lf := r.newLockfile(r.packedRefsPath())
+	if err = lf.AcquireTimeout(r.packedRefsTimeout); err != nil {
+		return fmt.Errorf("could not lock packed-refs: %w", err)
+	}
+
+	packed, err := r.readPackedRefs()
+	if err != nil {
+		lf.Rollback()
+		return err
+	}
+
+	loose, err := r.looseRefs("refs/")
+	if err != nil {
+		lf.Rollback()
+		return err
+	}
+
+	var pruned []Reference
+	for _, ref := range loose {
+		_, alreadyPacked := packed[ref.Name]
+		if ref.Target != "" || (!all && !alreadyPacked && !strings.HasPrefix(ref.Name, tagsPrefix)) {
+			continue
+		}
+
+		ref.Peeled, err = peel(ref.OID)
+		if err != nil {
+			lf.Rollback()
+			return fmt.Errorf("error peeling %s: %w", ref.Name, err)
+		}
+
+		packed[ref.Name] = ref
+		pruned = append(pruned, ref)
+	}
+
+	if err = writePackedRefs(lf, packed); err != nil {
+		lf.Rollback()
+		return err
+	}
+	if err = lf.Commit(); err != nil {
+		return fmt.Errorf("error committing packed-refs: %w", err)
+	}
+
+	if !prune {
+		return nil
+	}
+
+	for _, ref := range pruned {
+		r.pruneLooseRef(ref)
+	}
+
+	return nil
+}
+
+// pruneLooseRef deletes a loose ref that has been packed, unless it has
+// changed in the meantime. Failing to prune is harmless, as loose refs take
+// precedence over packed ones.
+func (r *refs) pruneLooseRef(ref Reference) {
+	lf := r.newLockfile(r.refPath(ref.Name))
+	if lf.Acquire() != nil {
+		return
+	}
+
+	current, err := r.readRefFile(ref.Name)
+	removed := err == nil && current == ref.OID && os.Remove(r.refPath(ref.Name)) == nil
+	lf.Rollback()
+
+	if removed {
+		removeEmptyDirs(r.dir, ref.Name)
+	}
+}
+
+// removeEmptyDirs removes the directories under root left empty by deleting a
+// ref (or its reflog), stopping at the top-level refs directories.
+func removeEmptyDirs(root string, name string) {
+	for dir := path.Dir(name); strings.Count(dir, "/") >= 2; dir = path.Dir(dir) {
+		if os.Remove(path.Join(root, filepath.FromSlash(dir))) != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package ref
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+const testOID3 = "43dd47ea691c90a5fa7827892c70241913351963"
+
+func writeTestPackedRefs(t *testing.T, dir string, data string) {
+	err := ioutil.WriteFile(path.Join(dir, packedRefsFilename), []byte(data), 0644)
+	if err != nil {
+		t.Fatalf("error writing packed-refs: %v", err)
+	}
+}
+
+func TestReadPackedRefs(t *testing.T) {
+	refs, dir := setUpTestRefs(t)
+	defer os.RemoveAll(dir)
+
+	writeTestPackedRefs(t, dir, packedRefsHeader+
+		testOID1+" refs/heads/master\n"+
+		testOID2+" refs/tags/v1\n"+
+		"^"+testOID3+"\n")
+
+	oid, err := refs.ReadHead()
+	if err != nil || oid != testOID1 {
+		t.Errorf("expected HEAD to resolve through packed-refs but got '%s', %v", oid, err)
+	}
+
+	// loose refs take precedence over packed ones
+	who := Author{Time: time.Now()}
+	if err = refs.UpdateRef("refs/heads/master", testOID2, who, "update"); err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	oid, _ = refs.ReadRef("refs/heads/master")
+	if oid != testOID2 {
+		t.Errorf("expected the loose value but got '%s'", oid)
+	}
+
+	list, err := refs.List("refs/")
+	if err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	expected := []Reference{
+		{Name: "refs/heads/master", OID: testOID2},
+		{Name: "refs/tags/v1", OID: testOID2, Peeled: testOID3},
+	}
+	if len(list) != len(expected) || list[0] != expected[0] || list[1] != expected[1] {
+		t.Errorf("expected %+v but got %+v", expected, list)
+	}
+}
+
+func TestPackRefs(t *testing.T) {
+	refs, dir := setUpTestRefs(t)
+	defer os.RemoveAll(dir)
+
+	who := Author{Time: time.Now()}
+	for name, oid := range map[string]string{"refs/heads/master": testOID1, "refs/heads/a/b": testOID1, "refs/tags/v1": testOID2} {
+		if err := refs.UpdateRef(name, oid, who, "create"); err != nil {
+			t.Fatalf("expected no error but got: %v", err)
+		}
+	}
+
+	peel := func(oid string) (string, error) {
+		if oid == testOID2 {
+			return testOID3, nil
+		}
+		return "", nil
+	}
+
+	if err := refs.Pack(false, true, peel); err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	data, _ := ioutil.ReadFile(path.Join(dir, packedRefsFilename))
+	expected := packedRefsHeader + testOID2 + " refs/tags/v1\n^" + testOID3 + "\n"
+	if string(data) != expected {
+		t.Errorf("expected packed-refs '%s' but got '%s'", expected, data)
+	}
+	if _, err := os.Stat(path.Join(dir, "refs/tags/v1")); !os.IsNotExist(err) {
+		t.Error("expected the loose tag to be pruned")
+	}
+
+	if err := refs.Pack(true, true, peel); err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	data, _ = ioutil.ReadFile(path.Join(dir, packedRefsFilename))
+	expected = packedRefsHeader +
+		testOID1 + " refs/heads/a/b\n" +
+		testOID1 + " refs/heads/master\n" +
+		testOID2 + " refs/tags/v1\n^" + testOID3 + "\n"
+	if string(data) != expected {
+		t.Errorf("expected packed-refs '%s' but got '%s'", expected, data)
+	}
+	if _, err := os.Stat(path.Join(dir, "refs/heads/a")); !os.IsNotExist(err) {
+		t.Error("expected empty ref directories to be removed")
+	}
+
+	oid, _ := refs.ReadHead()
+	if oid != testOID1 {
+		t.Errorf("expected HEAD to still resolve but got '%s'", oid)
+	}
+}
@@ -0,0 +1,209 @@
+package ref
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/neocortical/got/faults"
+	"github.com/neocortical/got/objectformat"
+)
+
+const (
+	logsDir = "logs"
+
+	// ZeroOID stands for a ref that doesn't exist, as the old value of a ref
+	// being created or the new value of one being deleted. The zero ID of any
+	// object format is accepted in its place.
+	ZeroOID = "0000000000000000000000000000000000000000"
+)
+
+// ReflogEntry records a single update of a ref.
+type ReflogEntry struct {
+	OldOID    string
+	NewOID    string
+	Committer Author
+	Message   string
+}
+
+func (e ReflogEntry) String() string {
+	oldOID, newOID := e.OldOID, e.NewOID
+	if oldOID == "" {
+		oldOID = ZeroOID
+	}
+	if newOID == "" {
+		newOID = ZeroOID
+	}
+
+	// the message must fit on a single line
+	message := strings.Join(strings.Fields(e.Message), " ")
+
+	return fmt.Sprintf("%s %s %s\t%s\n", oldOID, newOID, e.Committer.String(), message)
+}
+
+func parseReflogEntry(line string) (result ReflogEntry, err error) {
+	var message string
+	if tab := strings.Index(line, "\t"); tab != -1 {
+		line, message = line[:tab], line[tab+1:]
+	}
+
+	fields := strings.SplitN(line, " ", 3)
+	if len(fields) != 3 || !objectformat.IsOID(fields[0]) || !objectformat.IsOID(fields[1]) {
+		return result, fmt.Errorf("invalid reflog entry: '%s'", line)
+	}
+
+	result.Committer, err = ParseAuthor(fields[2])
+	if err != nil {
+		return result, fmt.Errorf("invalid reflog entry: %w", err)
+	}
+
+	result.OldOID, result.NewOID, result.Message = fields[0], fields[1], message
+	return
+}
+
+func (r *refs) reflogPath(name string) string {
+	return path.Join(r.dir, logsDir, filepath.FromSlash(name))
+}
+
+// shouldLog mirrors core.logAllRefUpdates=true: HEAD, branches, remote-tracking
+// refs and notes get a reflog automatically, others only if one already exists.
+func (r *refs) shouldLog(name string) bool {
+	if name == headFilename || r.HasReflog(name) {
+		return true
+	}
+
+	for _, prefix := range []string{HeadsPrefix, "refs/remotes/", "refs/notes/"} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *refs) HasReflog(name string) bool {
+	_, err := os.Stat(r.reflogPath(name))
+	return err == nil
+}
+
+func (r *refs) appendReflog(name string, entry ReflogEntry) (err error) {
+	if !r.shouldLog(name) {
+		return nil
+	}
+
+	logPath := r.reflogPath(name)
+	err = os.MkdirAll(path.Dir(logPath), 0755)
+	if err != nil {
+		return fmt.Errorf("unable to create directory for reflog of %s: %w", name, err)
+	}
+
+	if entry.OldOID == "" {
+		entry.OldOID = r.format.ZeroOID()
+	}
+	if entry.NewOID == "" {
+		entry.NewOID = r.format.ZeroOID()
+	}
+
+	f, err := os.OpenFile(logPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to append to reflog of %s: %w", name, err)
+	}
+
+	_, err = f.WriteString(entry.String())
+	if err == nil && r.fsync {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("unable to append to reflog of %s: %w", name, err)
+	}
+	faults.Point("reflog.append")
+
+	return nil
+}
+
+// Reflog returns the entries of a ref's reflog, oldest first.
+func (r *refs) Reflog(name string) (result []ReflogEntry, err error) {
+	data, err := ioutil.ReadFile(r.reflogPath(name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading reflog of %s: %w", name, err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if scanner.Text() == "" {
+			continue
+		}
+
+		entry, err := parseReflogEntry(scanner.Text())
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, entry)
+	}
+
+	return result, scanner.Err()
+}
+
+// WriteReflog replaces a ref's reflog, as needed to expire or delete entries.
+// Writing no entries empties the log but keeps it, like git does.
+func (r *refs) WriteReflog(name string, entries []ReflogEntry) (err error) {
+	lf := r.newLockfile(r.reflogPath(name))
+	if err = lf.AcquireTimeout(r.lockTimeout); err != nil {
+		return fmt.Errorf("could not lock reflog of %s: %w", name, err)
+	}
+
+	var buf bytes.Buffer
+	for _, e := range entries {
+		buf.WriteString(e.String())
+	}
+
+	if err = lf.Write(buf.Bytes()); err != nil {
+		lf.Rollback()
+		return fmt.Errorf("failed to write reflog of %s: %w", name, err)
+	}
+
+	return lf.Commit()
+}
+
+// ReflogNames returns the names of all refs that have a reflog, sorted.
+func (r *refs) ReflogNames() (result []string, err error) {
+	root := path.Join(r.dir, logsDir)
+	err = filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == root {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		name, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		if name = filepath.ToSlash(name); ValidName(name) {
+			result = append(result, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing reflogs: %w", err)
+	}
+
+	sort.Strings(result)
+	return result, nil
+}
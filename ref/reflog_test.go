@@ -0,0 +1,108 @@
+package ref
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+const (
+	testOID1 = "bccd3e06dd549a5c27497f6a11243019ba2abb80"
+	testOID2 = "0e3d6d78ab2bce1cfdcdc9c4f745f186c8b6daa7"
+)
+
+func setUpTestRefs(t *testing.T) (Refs, string) {
+	dir, err := ioutil.TempDir("", "got_refs_test_*")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+
+	err = ioutil.WriteFile(path.Join(dir, headFilename), []byte("ref: refs/heads/master\n"), 0644)
+	if err != nil {
+		t.Fatalf("error writing HEAD: %v", err)
+	}
+
+	return NewRefs(dir), dir
+}
+
+func TestReflogEntryRoundTrip(t *testing.T) {
+	line := "0000000000000000000000000000000000000000 bccd3e06dd549a5c27497f6a11243019ba2abb80 Nathan Smith <nathan@neocortical.net> 1609095922 -0800\tcommit (initial): first"
+
+	entry, err := parseReflogEntry(line)
+	if err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	if entry.OldOID != ZeroOID || entry.NewOID != testOID1 || entry.Message != "commit (initial): first" {
+		t.Errorf("unexpected entry: %+v", entry)
+	}
+	if entry.String() != line+"\n" {
+		t.Errorf("expected '%s' but got '%s'", line, entry.String())
+	}
+
+	if _, err = parseReflogEntry("garbage"); err == nil {
+		t.Error("expected an error parsing an invalid entry")
+	}
+}
+
+func TestUpdateHeadThroughSymref(t *testing.T) {
+	refs, dir := setUpTestRefs(t)
+	defer os.RemoveAll(dir)
+
+	oid, err := refs.ReadHead()
+	if err != nil || oid != "" {
+		t.Fatalf("expected an unborn HEAD but got '%s', %v", oid, err)
+	}
+
+	who := Author{Name: "Nathan Smith", Email: "nathan@neocortical.net", Time: time.Unix(1609095922, 0)}
+	if err = refs.UpdateHead(testOID1, who, "commit (initial): first"); err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	if err = refs.UpdateHead(testOID2, who, "commit: second\n\nbody"); err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+
+	target, _ := refs.ReadSymbolicRef(headFilename)
+	if target != "refs/heads/master" {
+		t.Errorf("expected HEAD to remain a symbolic ref but got '%s'", target)
+	}
+	oid, _ = refs.ReadRef("refs/heads/master")
+	if oid != testOID2 {
+		t.Errorf("expected master to be updated but got '%s'", oid)
+	}
+
+	for _, name := range []string{"HEAD", "refs/heads/master"} {
+		entries, err := refs.Reflog(name)
+		if err != nil {
+			t.Fatalf("expected no error reading reflog of %s but got: %v", name, err)
+		}
+		if len(entries) != 2 {
+			t.Fatalf("expected 2 entries in reflog of %s but got %d", name, len(entries))
+		}
+		if entries[0].OldOID != ZeroOID || entries[1].OldOID != testOID1 || entries[1].NewOID != testOID2 {
+			t.Errorf("unexpected entries in reflog of %s: %+v", name, entries)
+		}
+		if entries[1].Message != "commit: second body" {
+			t.Errorf("expected the message to be folded onto one line but got '%s'", entries[1].Message)
+		}
+	}
+
+	names, err := refs.ReflogNames()
+	if err != nil || len(names) != 2 || names[0] != "HEAD" || names[1] != "refs/heads/master" {
+		t.Errorf("unexpected reflog names %v, %v", names, err)
+	}
+}
+
+func TestOnlyAutocreateReflogsForLoggedNamespaces(t *testing.T) {
+	refs, dir := setUpTestRefs(t)
+	defer os.RemoveAll(dir)
+
+	err := refs.UpdateRef("refs/tags/v1", testOID1, Author{Time: time.Now()}, "tag")
+	if err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	if refs.HasReflog("refs/tags/v1") {
+		t.Error("expected no reflog to be created for a tag")
+	}
+}
@@ -1,36 +1,110 @@
 package ref
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/neocortical/got/lock"
+	"github.com/neocortical/got/objectformat"
 )
 
 const (
 	headFilename = "HEAD"
+	symrefPrefix = "ref: "
+	refsDir      = "refs"
+	lockSuffix   = ".lock"
+
+	// HeadsPrefix is the namespace branches live in.
+	HeadsPrefix = "refs/heads/"
+
+	// maxSymrefDepth guards against symbolic ref cycles.
+	maxSymrefDepth = 5
+
+	// DefaultLockTimeout and DefaultPackedRefsTimeout match git's defaults
+	// for core.filesRefLockTimeout and core.packedRefsTimeout.
+	DefaultLockTimeout       = 100 * time.Millisecond
+	DefaultPackedRefsTimeout = time.Second
+)
+
+var (
+	errSymrefTooDeep = errors.New("symbolic ref nesting is too deep")
 )
 
 type Refs interface {
 	ReadHead() (result string, err error)
-	UpdateHead(val string) error
+	UpdateHead(oid string, committer Author, message string) error
+	ReadRef(name string) (oid string, err error)
+	ReadSymbolicRef(name string) (target string, err error)
+	WriteSymbolicRef(name string, target string, committer Author, message string) error
+	UpdateRef(name string, oid string, committer Author, message string) error
+	Reflog(name string) ([]ReflogEntry, error)
+	WriteReflog(name string, entries []ReflogEntry) error
+	HasReflog(name string) bool
+	ReflogNames() ([]string, error)
+	List(prefix string) ([]Reference, error)
+	Pack(all bool, prune bool, peel PeelFunc) error
+	Transaction(committer Author) *Transaction
+	SetLockTimeouts(refTimeout, packedRefsTimeout time.Duration)
+	SetFsync(enabled bool)
+	SetObjectFormat(f *objectformat.Format)
 }
 
 type refs struct {
-	dir string
+	dir               string
+	lockTimeout       time.Duration
+	packedRefsTimeout time.Duration
+	fsync             bool
+	format            *objectformat.Format
 }
 
 func NewRefs(dir string) Refs {
-	return &refs{dir}
+	return &refs{
+		dir:               dir,
+		lockTimeout:       DefaultLockTimeout,
+		packedRefsTimeout: DefaultPackedRefsTimeout,
+		format:            objectformat.Default,
+	}
 }
 
-func (r *refs) ReadHead() (result string, err error) {
-	headPath := path.Join(r.dir, headFilename)
+// SetObjectFormat sets the format of the object IDs refs may hold, and of
+// the zero IDs written to reflogs.
+func (r *refs) SetObjectFormat(f *objectformat.Format) {
+	r.format = f
+}
+
+// SetLockTimeouts sets how long to wait for another process to release a
+// ref's lock, and the packed-refs lock. Negative timeouts wait forever.
+func (r *refs) SetLockTimeouts(refTimeout, packedRefsTimeout time.Duration) {
+	r.lockTimeout = refTimeout
+	r.packedRefsTimeout = packedRefsTimeout
+}
 
-	_, err = os.Stat(headPath)
+func (r *refs) refPath(name string) string {
+	return path.Join(r.dir, filepath.FromSlash(name))
+}
+
+// SetFsync makes ref, packed-refs and reflog updates durable before they're
+// reported as done.
+func (r *refs) SetFsync(enabled bool) {
+	r.fsync = enabled
+}
+
+func (r *refs) newLockfile(p string) *lock.Lockfile {
+	lf := lock.NewLockfile(p)
+	lf.SetFsync(r.fsync)
+	return lf
+}
+
+// readRefFile returns the raw content of a loose ref, or "" if it doesn't exist.
+func (r *refs) readRefFile(name string) (result string, err error) {
+	data, err := ioutil.ReadFile(r.refPath(name))
 	if os.IsNotExist(err) {
 		return "", nil
 	}
@@ -38,28 +112,231 @@ func (r *refs) ReadHead() (result string, err error) {
 		return
 	}
 
-	data, err := ioutil.ReadFile(headPath)
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (r *refs) ReadHead() (result string, err error) {
+	return r.ReadRef(headFilename)
+}
+
+// ReadRef returns the object ID a ref points to, following symbolic refs. It
+// returns an empty string for refs that don't exist, including a symbolic ref
+// to a branch that hasn't been born yet.
+func (r *refs) ReadRef(name string) (oid string, err error) {
+	name, err = r.resolveSymbolic(name)
 	if err != nil {
 		return
 	}
 
-	return strings.TrimSpace(string(data)), nil
+	oid, err = r.readRefFile(name)
+	if err != nil || oid != "" {
+		return
+	}
+
+	packed, err := r.readPackedRefs()
+	if err != nil {
+		return "", err
+	}
+
+	return packed[name].OID, nil
+}
+
+// ReadSymbolicRef returns the ref a symbolic ref points to, or "" if the ref
+// isn't symbolic.
+func (r *refs) ReadSymbolicRef(name string) (target string, err error) {
+	data, err := r.readRefFile(name)
+	if err != nil || !strings.HasPrefix(data, symrefPrefix) {
+		return "", err
+	}
+
+	return strings.TrimSpace(data[len(symrefPrefix):]), nil
+}
+
+// resolveSymbolic follows symbolic refs until it reaches a regular (or
+// missing) ref, and returns its name.
+func (r *refs) resolveSymbolic(name string) (string, error) {
+	for depth := 0; depth < maxSymrefDepth; depth++ {
+		target, err := r.ReadSymbolicRef(name)
+		if err != nil {
+			return "", err
+		}
+		if target == "" {
+			return name, nil
+		}
+		name = target
+	}
+
+	return "", errSymrefTooDeep
 }
 
-func (r *refs) UpdateHead(oid string) (err error) {
-	lf := lock.NewLockfile(path.Join(r.dir, "HEAD"))
+// UpdateHead points HEAD at a new commit. If HEAD is a symbolic ref, the branch
+// it refers to is updated instead, and the change is logged for both.
+func (r *refs) UpdateHead(oid string, committer Author, message string) (err error) {
+	return r.UpdateRef(headFilename, oid, committer, message)
+}
 
-	if err = lf.Acquire(); err != nil {
-		return fmt.Errorf("could not lock HEAD for writing: %w", err)
+// UpdateRef sets a ref (following symbolic refs) to a new object ID and
+// records the change in the reflogs of the ref and of any symbolic refs that
+// led to it.
+func (r *refs) UpdateRef(name string, oid string, committer Author, message string) (err error) {
+	t := r.Transaction(committer)
+	if err = t.Update(name, oid, "", message); err != nil {
+		return err
 	}
 
-	if err = lf.Write([]byte(fmt.Sprintf("%s\n", oid))); err != nil {
-		return fmt.Errorf("failed to write HEAD data: %w", err)
+	return t.Commit()
+}
+
+// WriteSymbolicRef points name at another ref. A non-empty message is
+// recorded in name's reflog if the object it resolves to changes.
+func (r *refs) WriteSymbolicRef(name string, target string, committer Author, message string) (err error) {
+	if !ValidName(name) || !ValidName(target) {
+		return fmt.Errorf("refusing to point '%s' at '%s'", name, target)
+	}
+
+	err = os.MkdirAll(path.Dir(r.refPath(name)), 0755)
+	if err != nil {
+		return fmt.Errorf("could not create directory for %s: %w", name, err)
+	}
+
+	lf := r.newLockfile(r.refPath(name))
+	if err = lf.AcquireTimeout(r.lockTimeout); err != nil {
+		return fmt.Errorf("could not lock %s for writing: %w", name, err)
+	}
+
+	oldOID, err := r.ReadRef(name)
+	if err != nil {
+		lf.Rollback()
+		return err
+	}
+	newOID, err := r.ReadRef(target)
+	if err != nil {
+		lf.Rollback()
+		return err
+	}
+
+	if err = lf.Write([]byte(fmt.Sprintf("%s%s\n", symrefPrefix, target))); err != nil {
+		lf.Rollback()
+		return fmt.Errorf("failed to write %s data: %w", name, err)
+	}
+
+	if message != "" && newOID != "" && oldOID != newOID {
+		entry := ReflogEntry{OldOID: oldOID, NewOID: newOID, Committer: committer, Message: message}
+		if err = r.appendReflog(name, entry); err != nil {
+			lf.Rollback()
+			return err
+		}
 	}
 
 	if err = lf.Commit(); err != nil {
-		return fmt.Errorf("failed to commit HEAD data: %w", err)
+		return fmt.Errorf("failed to commit %s data: %w", name, err)
+	}
+
+	return nil
+}
+
+// symrefChain returns the names of the refs visited when following name
+// through symbolic refs, ending with the regular (or missing) ref.
+func (r *refs) symrefChain(name string) ([]string, error) {
+	chain := []string{name}
+	for depth := 0; ; depth++ {
+		target, err := r.ReadSymbolicRef(chain[len(chain)-1])
+		if err != nil {
+			return nil, err
+		}
+		if target == "" {
+			return chain, nil
+		}
+		if depth == maxSymrefDepth {
+			return nil, errSymrefTooDeep
+		}
+		chain = append(chain, target)
+	}
+}
+
+// List returns the refs whose names start with prefix, loose and packed,
+// sorted by name. Symbolic refs are resolved to the object they point to.
+func (r *refs) List(prefix string) (result []Reference, err error) {
+	packed, err := r.readPackedRefs()
+	if err != nil {
+		return nil, err
+	}
+
+	loose, err := r.looseRefs(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := map[string]Reference{}
+	for name, ref := range packed {
+		if strings.HasPrefix(name, prefix) {
+			merged[name] = ref
+		}
+	}
+	for _, ref := range loose {
+		if ref.Target != "" {
+			ref.OID, err = r.ReadRef(ref.Target)
+			if err != nil || ref.OID == "" {
+				continue
+			}
+		}
+		merged[ref.Name] = ref
+	}
+
+	for _, ref := range merged {
+		result = append(result, ref)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+
+	return result, nil
+}
+
+// looseRefs returns the refs stored as files under the refs directory whose
+// names start with prefix. Symbolic refs are returned unresolved.
+func (r *refs) looseRefs(prefix string) (result []Reference, err error) {
+	root := r.refPath(refsDir)
+	err = filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == root {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(r.dir, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+
+		// this also skips lock files and their owner information
+		if !strings.HasPrefix(name, prefix) || !ValidName(name) {
+			return nil
+		}
+
+		data, err := r.readRefFile(name)
+		if err != nil {
+			return err
+		}
+
+		ref := Reference{Name: name}
+		if strings.HasPrefix(data, symrefPrefix) {
+			ref.Target = strings.TrimSpace(data[len(symrefPrefix):])
+		} else if r.format.IsOID(data) {
+			ref.OID = data
+		} else {
+			// not a ref; git ignores these too
+			return nil
+		}
+		result = append(result, ref)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing refs: %w", err)
 	}
 
-	return
+	return result, nil
 }
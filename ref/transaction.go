@@ -0,0 +1,471 @@
+package ref
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/neocortical/got/lock"
+	"github.com/neocortical/got/objectformat"
+)
+
+// ErrRefChanged is returned when a ref in a transaction doesn't have the value
+// the caller expected.
+var ErrRefChanged = errors.New("ref has changed")
+
+// RefUpdate describes one change in a ref transaction. An empty NewOID only
+// verifies the old value, and ZeroOID deletes the ref. An empty OldOID skips
+// the check, and ZeroOID requires that the ref doesn't exist yet. Symbolic
+// refs are followed unless NoDeref is set.
+type RefUpdate struct {
+	Name    string
+	NewOID  string
+	OldOID  string
+	Message string
+	NoDeref bool
+}
+
+type transactionState int
+
+const (
+	transactionOpen transactionState = iota
+	transactionPrepared
+	transactionClosed
+)
+
+type pendingUpdate struct {
+	RefUpdate
+
+	// target is the ref actually written, and logged are the refs whose
+	// reflogs record the update.
+	target  string
+	logged  []string
+	current string
+	lock    *lock.Lockfile
+	backup  backup
+}
+
+// backup is what a file held before a transaction changed it, so the change
+// can be undone. Nil data means the file didn't exist.
+type backup struct {
+	path string
+	data []byte
+}
+
+func readBackup(p string) (backup, error) {
+	data, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return backup{path: p}, nil
+	}
+
+	return backup{path: p, data: data}, err
+}
+
+// Transaction updates several refs atomically: every ref is locked and checked
+// against its expected old value, and its new contents written, before any of
+// them is changed. If any check fails none are, and if changing one fails the
+// ones already changed are put back.
+type Transaction struct {
+	refs         *refs
+	committer    Author
+	updates      []*pendingUpdate
+	packedLock   *lock.Lockfile
+	packedBackup backup
+	state        transactionState
+
+	// undo puts back each change made so far by Commit
+	undo []func() error
+}
+
+func (r *refs) Transaction(committer Author) *Transaction {
+	return &Transaction{refs: r, committer: committer}
+}
+
+// Add queues an update. It only takes effect when the transaction commits.
+func (t *Transaction) Add(u RefUpdate) error {
+	if t.state != transactionOpen {
+		return errors.New("ref transaction is no longer open")
+	}
+	if !ValidName(u.Name) {
+		return fmt.Errorf("refusing to update ref with bad name '%s'", u.Name)
+	}
+
+	// the zero ID of any format means the same thing
+	if objectformat.IsZeroOID(u.NewOID) {
+		u.NewOID = ZeroOID
+	} else if u.NewOID != "" && !t.refs.format.IsOID(u.NewOID) {
+		return fmt.Errorf("refusing to point '%s' at '%s', which is not a %s object ID", u.Name, u.NewOID, t.refs.format)
+	}
+	if objectformat.IsZeroOID(u.OldOID) {
+		u.OldOID = ZeroOID
+	}
+
+	t.updates = append(t.updates, &pendingUpdate{RefUpdate: u})
+	return nil
+}
+
+func (t *Transaction) Update(name, newOID, oldOID, message string) error {
+	return t.Add(RefUpdate{Name: name, NewOID: newOID, OldOID: oldOID, Message: message})
+}
+
+func (t *Transaction) Create(name, newOID, message string) error {
+	return t.Add(RefUpdate{Name: name, NewOID: newOID, OldOID: ZeroOID, Message: message})
+}
+
+func (t *Transaction) Delete(name, oldOID, message string) error {
+	return t.Add(RefUpdate{Name: name, NewOID: ZeroOID, OldOID: oldOID, Message: message})
+}
+
+func (t *Transaction) Verify(name, oldOID string) error {
+	return t.Add(RefUpdate{Name: name, OldOID: oldOID})
+}
+
+// Prepare locks every ref in the transaction, checks their old values and
+// writes their new ones to the locks. On failure all locks are released and
+// the transaction is closed.
+func (t *Transaction) Prepare() (err error) {
+	if t.state != transactionOpen {
+		return errors.New("ref transaction is no longer open")
+	}
+
+	err = t.prepare()
+	if err != nil {
+		t.Abort()
+		return err
+	}
+
+	t.state = transactionPrepared
+	return nil
+}
+
+func (t *Transaction) prepare() (err error) {
+	r := t.refs
+	seen := map[string]bool{}
+	for _, u := range t.updates {
+		u.logged = []string{u.Name}
+		if !u.NoDeref {
+			if u.logged, err = r.symrefChain(u.Name); err != nil {
+				return fmt.Errorf("cannot lock ref '%s': %w", u.Name, err)
+			}
+		}
+		u.target = u.logged[len(u.logged)-1]
+
+		if seen[u.target] {
+			return fmt.Errorf("multiple updates for ref '%s' not allowed", u.target)
+		}
+		seen[u.target] = true
+	}
+
+	// always lock in the same order so concurrent transactions can't deadlock
+	sort.Slice(t.updates, func(i, j int) bool { return t.updates[i].target < t.updates[j].target })
+
+	var packed map[string]Reference
+	for _, u := range t.updates {
+		if err = t.lockRef(u); err != nil {
+			return err
+		}
+
+		current, err := r.ReadRef(u.target)
+		if err != nil {
+			return fmt.Errorf("cannot lock ref '%s': %w", u.Name, err)
+		}
+		u.current = current
+
+		switch {
+		case u.OldOID == ZeroOID && current != "":
+			return fmt.Errorf("cannot lock ref '%s': reference already exists: %w", u.Name, ErrRefChanged)
+		case u.OldOID != "" && u.OldOID != ZeroOID && current == "":
+			return fmt.Errorf("cannot lock ref '%s': unable to resolve reference: %w", u.Name, ErrRefChanged)
+		case u.OldOID != "" && u.OldOID != ZeroOID && current != u.OldOID:
+			return fmt.Errorf("cannot lock ref '%s': is at %s but expected %s: %w", u.Name, current, u.OldOID, ErrRefChanged)
+		}
+
+		if u.NewOID == ZeroOID && t.packedLock == nil {
+			if packed == nil {
+				if packed, err = r.readPackedRefs(); err != nil {
+					return err
+				}
+			}
+			if _, ok := packed[u.target]; ok {
+				t.packedLock = r.newLockfile(r.packedRefsPath())
+				if err = t.packedLock.AcquireTimeout(r.packedRefsTimeout); err != nil {
+					t.packedLock = nil
+					return fmt.Errorf("could not lock packed-refs: %w", err)
+				}
+			}
+		}
+	}
+
+	return t.writeLocks()
+}
+
+// writeLocks writes the new contents of every locked file, keeping what they
+// held before so that Commit can undo its changes.
+func (t *Transaction) writeLocks() (err error) {
+	r := t.refs
+
+	// drop deleted refs from packed-refs, read again now that it's locked
+	if t.packedLock != nil {
+		if t.packedBackup, err = readBackup(r.packedRefsPath()); err != nil {
+			return fmt.Errorf("error reading packed-refs: %w", err)
+		}
+		packed, err := r.readPackedRefs()
+		if err != nil {
+			return err
+		}
+		for _, u := range t.updates {
+			if u.NewOID == ZeroOID {
+				delete(packed, u.target)
+			}
+		}
+		if err = writePackedRefs(t.packedLock, packed); err != nil {
+			return err
+		}
+	}
+
+	for _, u := range t.updates {
+		if u.lock == nil || u.NewOID == "" {
+			continue
+		}
+		if u.backup, err = readBackup(r.refPath(u.target)); err != nil {
+			return fmt.Errorf("cannot lock ref '%s': %w", u.Name, err)
+		}
+		if u.NewOID == ZeroOID {
+			continue
+		}
+		if err = u.lock.Write([]byte(fmt.Sprintf("%s\n", u.NewOID))); err != nil {
+			return fmt.Errorf("failed to write %s data: %w", u.target, err)
+		}
+	}
+
+	return nil
+}
+
+// lockRef takes the lock on an update's target, creating its directory when
+// the ref is being written and checking for directory/file name conflicts.
+func (t *Transaction) lockRef(u *pendingUpdate) (err error) {
+	r := t.refs
+	refPath := r.refPath(u.target)
+
+	if info, err := os.Stat(refPath); err == nil && info.IsDir() {
+		if u.NewOID == "" || u.NewOID == ZeroOID {
+			return nil
+		}
+		return fmt.Errorf("cannot lock ref '%s': there are refs under '%s/'", u.Name, u.target)
+	}
+
+	if u.NewOID != "" && u.NewOID != ZeroOID {
+		if err = t.checkNameConflicts(u); err != nil {
+			return err
+		}
+		if err = os.MkdirAll(path.Dir(refPath), 0755); err != nil {
+			return fmt.Errorf("cannot lock ref '%s': %w", u.Name, err)
+		}
+	} else if _, err := os.Stat(path.Dir(refPath)); err != nil {
+		// nothing loose to lock, and creating the directory could introduce
+		// a conflict with a later ref
+		return nil
+	}
+
+	u.lock = r.newLockfile(refPath)
+	if err = u.lock.AcquireTimeout(r.lockTimeout); err != nil {
+		u.lock = nil
+		return fmt.Errorf("cannot lock ref '%s': %w", u.Name, err)
+	}
+
+	return nil
+}
+
+// checkNameConflicts refuses to create a ref whose name is a directory of an
+// existing ref or has an existing ref as a directory, like refs/heads/a and
+// refs/heads/a/b.
+func (t *Transaction) checkNameConflicts(u *pendingUpdate) error {
+	existing, err := t.refs.List("")
+	if err != nil {
+		return err
+	}
+
+	for _, ref := range existing {
+		if strings.HasPrefix(ref.Name, u.target+"/") || strings.HasPrefix(u.target, ref.Name+"/") {
+			return fmt.Errorf("cannot lock ref '%s': '%s' exists", u.Name, ref.Name)
+		}
+	}
+
+	return nil
+}
+
+// Commit applies every update in the transaction, preparing it first if
+// necessary. Reflogs are appended, then packed-refs and the loose refs are
+// put in place; if any of that fails, what was already done is undone.
+func (t *Transaction) Commit() (err error) {
+	if t.state == transactionOpen {
+		if err = t.Prepare(); err != nil {
+			return err
+		}
+	}
+	if t.state != transactionPrepared {
+		return errors.New("ref transaction is no longer open")
+	}
+	defer t.Abort()
+
+	if err = t.commit(); err != nil {
+		if undoErr := t.rollback(); undoErr != nil {
+			return fmt.Errorf("%w; unable to undo the transaction: %v", err, undoErr)
+		}
+		return err
+	}
+
+	return t.removeDeletedReflogs()
+}
+
+func (t *Transaction) commit() (err error) {
+	for _, u := range t.updates {
+		if u.NewOID == "" || u.NewOID == ZeroOID {
+			continue
+		}
+		entry := ReflogEntry{OldOID: u.current, NewOID: u.NewOID, Committer: t.committer, Message: u.Message}
+		for _, logged := range u.logged {
+			if err = t.appendReflog(logged, entry); err != nil {
+				return err
+			}
+		}
+	}
+
+	// packed-refs goes first, so deleted refs can't reappear once their
+	// loose files are gone
+	if t.packedLock != nil {
+		err = t.packedLock.Commit()
+		t.packedLock = nil
+		if err != nil {
+			return fmt.Errorf("error committing packed-refs: %w", err)
+		}
+		t.onUndo(t.packedBackup)
+	}
+
+	for _, u := range t.updates {
+		if u.lock == nil || u.NewOID == "" {
+			continue
+		}
+		if err = t.commitRef(u); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// appendReflog appends an entry to a reflog, to be truncated back off if the
+// transaction fails.
+func (t *Transaction) appendReflog(name string, entry ReflogEntry) error {
+	logPath := t.refs.reflogPath(name)
+	info, err := os.Stat(logPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("unable to append to reflog of %s: %w", name, err)
+	}
+	t.undo = append(t.undo, func() error {
+		if info == nil {
+			err := os.Remove(logPath)
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		return os.Truncate(logPath, info.Size())
+	})
+
+	return t.refs.appendReflog(name, entry)
+}
+
+func (t *Transaction) commitRef(u *pendingUpdate) (err error) {
+	if u.NewOID == ZeroOID {
+		err = os.Remove(u.backup.path)
+		u.lock.Rollback()
+		u.lock = nil
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to delete %s: %w", u.target, err)
+		}
+	} else {
+		err = u.lock.Commit()
+		u.lock = nil
+		if err != nil {
+			return fmt.Errorf("failed to commit %s data: %w", u.target, err)
+		}
+	}
+	t.onUndo(u.backup)
+
+	return nil
+}
+
+// onUndo arranges for a file the transaction has replaced to be put back, under
+// a new lock since the transaction's own is gone.
+func (t *Transaction) onUndo(b backup) {
+	r := t.refs
+	t.undo = append(t.undo, func() (err error) {
+		lf := r.newLockfile(b.path)
+		if err = lf.AcquireTimeout(r.lockTimeout); err != nil {
+			return err
+		}
+		if b.data == nil {
+			err = os.Remove(b.path)
+			lf.Rollback()
+			return err
+		}
+		if err = lf.Write(b.data); err != nil {
+			lf.Rollback()
+			return err
+		}
+		return lf.Commit()
+	})
+}
+
+// rollback undoes the changes Commit has made, latest first. It carries on
+// past failures, returning the first.
+func (t *Transaction) rollback() (err error) {
+	for i := len(t.undo) - 1; i >= 0; i-- {
+		if undoErr := t.undo[i](); err == nil {
+			err = undoErr
+		}
+	}
+	t.undo = nil
+
+	return
+}
+
+// removeDeletedReflogs tidies up after deleted refs, once the transaction can
+// no longer fail.
+func (t *Transaction) removeDeletedReflogs() (err error) {
+	r := t.refs
+	for _, u := range t.updates {
+		if u.NewOID != ZeroOID {
+			continue
+		}
+		if removeErr := os.Remove(r.reflogPath(u.target)); removeErr != nil && !os.IsNotExist(removeErr) && err == nil {
+			err = fmt.Errorf("unable to delete reflog of %s: %w", u.target, removeErr)
+		}
+		removeEmptyDirs(r.dir, u.target)
+		removeEmptyDirs(path.Join(r.dir, logsDir), u.target)
+	}
+
+	return
+}
+
+// Abort releases every lock held by the transaction without changing any
+// refs. It's safe to call after Commit.
+func (t *Transaction) Abort() {
+	for _, u := range t.updates {
+		if u.lock != nil {
+			u.lock.Rollback()
+			u.lock = nil
+		}
+	}
+	if t.packedLock != nil {
+		t.packedLock.Rollback()
+		t.packedLock = nil
+	}
+
+	t.state = transactionClosed
+}
@@ -0,0 +1,226 @@
+package ref
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/neocortical/got/objectformat"
+)
+
+func TestTransactionCommitsAllUpdates(t *testing.T) {
+	refs, dir := setUpTestRefs(t)
+	defer os.RemoveAll(dir)
+
+	who := Author{Time: time.Now()}
+	if err := refs.UpdateRef("refs/heads/old", testOID1, who, "create"); err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+
+	tx := refs.Transaction(who)
+	tx.Create("refs/heads/new", testOID1, "branch: renamed from old")
+	tx.Delete("refs/heads/old", testOID1, "")
+	tx.Update("refs/heads/master", testOID2, ZeroOID, "push")
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+
+	for name, expected := range map[string]string{"refs/heads/new": testOID1, "refs/heads/old": "", "refs/heads/master": testOID2} {
+		oid, _ := refs.ReadRef(name)
+		if oid != expected {
+			t.Errorf("expected %s to be '%s' but got '%s'", name, expected, oid)
+		}
+	}
+	if refs.HasReflog("refs/heads/old") {
+		t.Error("expected the reflog of a deleted ref to be removed")
+	}
+	if err := tx.Commit(); err == nil {
+		t.Error("expected an error committing a closed transaction")
+	}
+}
+
+func TestTransactionRollsBackOnStaleValue(t *testing.T) {
+	refs, dir := setUpTestRefs(t)
+	defer os.RemoveAll(dir)
+
+	who := Author{Time: time.Now()}
+	if err := refs.UpdateRef("refs/heads/master", testOID1, who, "create"); err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+
+	tx := refs.Transaction(who)
+	tx.Update("refs/heads/a", testOID2, "", "")
+	tx.Update("refs/heads/master", testOID3, testOID2, "")
+	err := tx.Commit()
+	if !errors.Is(err, ErrRefChanged) {
+		t.Fatalf("expected ErrRefChanged but got: %v", err)
+	}
+
+	if oid, _ := refs.ReadRef("refs/heads/a"); oid != "" {
+		t.Errorf("expected no refs to be updated but refs/heads/a is '%s'", oid)
+	}
+	if oid, _ := refs.ReadRef("refs/heads/master"); oid != testOID1 {
+		t.Errorf("expected master to be unchanged but got '%s'", oid)
+	}
+	files, _ := ioutil.ReadDir(path.Join(dir, "refs/heads"))
+	for _, f := range files {
+		if f.Name() != "master" {
+			t.Errorf("expected locks to be released but found '%s'", f.Name())
+		}
+	}
+}
+
+func TestTransactionRejectsConflicts(t *testing.T) {
+	refs, dir := setUpTestRefs(t)
+	defer os.RemoveAll(dir)
+
+	who := Author{Time: time.Now()}
+	if err := refs.UpdateRef("refs/heads/a/b", testOID1, who, "create"); err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+
+	var tests = []struct {
+		updates []RefUpdate
+	}{
+		{[]RefUpdate{{Name: "refs/heads/a", NewOID: testOID1}}},
+		{[]RefUpdate{{Name: "refs/heads/a/b/c", NewOID: testOID1}}},
+		{[]RefUpdate{{Name: "refs/heads/a/b", NewOID: testOID1, OldOID: ZeroOID}}},
+		{[]RefUpdate{{Name: "HEAD", NewOID: testOID1}, {Name: "refs/heads/master", NewOID: testOID2}}},
+	}
+
+	for i, test := range tests {
+		tx := refs.Transaction(who)
+		for _, u := range test.updates {
+			if err := tx.Add(u); err != nil {
+				t.Fatalf("test %d: expected no error adding update but got: %v", i, err)
+			}
+		}
+		if err := tx.Commit(); err == nil {
+			t.Errorf("test %d: expected an error", i)
+		}
+	}
+
+	if err := refs.Transaction(who).Update("refs/heads/bad..name", testOID1, "", ""); err == nil {
+		t.Error("expected an error for an invalid ref name")
+	}
+}
+
+func TestTransactionDeletesPackedRefs(t *testing.T) {
+	refs, dir := setUpTestRefs(t)
+	defer os.RemoveAll(dir)
+
+	writeTestPackedRefs(t, dir, packedRefsHeader+
+		testOID1+" refs/heads/master\n"+
+		testOID2+" refs/tags/v1\n")
+
+	tx := refs.Transaction(Author{Time: time.Now()})
+	tx.Delete("refs/tags/v1", testOID2, "")
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+
+	if oid, _ := refs.ReadRef("refs/tags/v1"); oid != "" {
+		t.Errorf("expected the tag to be deleted but got '%s'", oid)
+	}
+	data, _ := ioutil.ReadFile(path.Join(dir, packedRefsFilename))
+	if string(data) != packedRefsHeader+testOID1+" refs/heads/master\n" {
+		t.Errorf("unexpected packed-refs contents: '%s'", data)
+	}
+}
+
+func TestTransactionUndoesPartialCommit(t *testing.T) {
+	refs, dir := setUpTestRefs(t)
+	defer os.RemoveAll(dir)
+
+	writeTestPackedRefs(t, dir, packedRefsHeader+testOID2+" refs/tags/v1\n")
+	who := Author{Time: time.Now()}
+	if err := refs.UpdateRef("refs/heads/a", testOID1, who, "create"); err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+
+	tx := refs.Transaction(who)
+	tx.Update("refs/heads/a", testOID2, testOID1, "")
+	tx.Create("refs/heads/b", testOID3, "")
+	tx.Delete("refs/tags/v1", testOID2, "")
+	if err := tx.Prepare(); err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+
+	// a directory with something in it can't be replaced by the lock of
+	// refs/heads/b, which is renamed after refs/heads/a
+	if err := os.MkdirAll(path.Join(dir, "refs/heads/b/x"), 0755); err != nil {
+		t.Fatalf("error creating directory: %v", err)
+	}
+	if err := tx.Commit(); err == nil {
+		t.Fatal("expected the commit to fail")
+	}
+
+	for name, expected := range map[string]string{"refs/heads/a": testOID1, "refs/tags/v1": testOID2} {
+		if oid, _ := refs.ReadRef(name); oid != expected {
+			t.Errorf("expected %s to be restored to '%s' but got '%s'", name, expected, oid)
+		}
+	}
+	if entries, _ := refs.Reflog("refs/heads/a"); len(entries) != 1 {
+		t.Errorf("expected the reflog of refs/heads/a to be restored but it has %d entries", len(entries))
+	}
+	if refs.HasReflog("refs/heads/b") {
+		t.Error("expected no reflog for refs/heads/b")
+	}
+	for _, leftover := range []string{"refs/heads/a.lock", "refs/heads/b.lock", "packed-refs.lock"} {
+		if _, err := os.Stat(path.Join(dir, leftover)); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed", leftover)
+		}
+	}
+}
+
+func TestValidName(t *testing.T) {
+	for _, name := range []string{"HEAD", "FETCH_HEAD", "refs/heads/master", "refs/heads/feature/x-1", "refs/tags/v1.0"} {
+		if !ValidName(name) {
+			t.Errorf("expected '%s' to be valid", name)
+		}
+	}
+	for _, name := range []string{"master", "refs/heads/", "refs//x", "refs/heads/a..b", "refs/heads/.hidden", "refs/heads/x.lock",
+		"refs/heads/a b", "refs/heads/a~1", "refs/heads/a^", "refs/heads/a:b", "refs/heads/a@{1}", "refs/heads/x."} {
+		if ValidName(name) {
+			t.Errorf("expected '%s' to be invalid", name)
+		}
+	}
+}
+
+func TestTransactionSHA256(t *testing.T) {
+	refs, dir := setUpTestRefs(t)
+	defer os.RemoveAll(dir)
+	refs.SetObjectFormat(objectformat.SHA256)
+
+	oid := objectformat.SHA256.Sum([]byte("commit"))
+	who := Author{Time: time.Now()}
+	if err := refs.UpdateRef("refs/heads/master", testOID1, who, "create"); err == nil {
+		t.Error("expected an error writing a SHA-1 ID to a SHA-256 repository")
+	}
+
+	tx := refs.Transaction(who)
+	tx.Create("refs/heads/master", oid, "create")
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	if current, _ := refs.ReadRef("refs/heads/master"); current != oid {
+		t.Errorf("expected '%s' but got '%s'", oid, current)
+	}
+
+	entries, err := refs.Reflog("refs/heads/master")
+	if err != nil || len(entries) != 1 || entries[0].OldOID != objectformat.SHA256.ZeroOID() {
+		t.Errorf("expected a reflog entry from the SHA-256 zero ID but got: %v (%v)", entries, err)
+	}
+
+	tx = refs.Transaction(who)
+	tx.Delete("refs/heads/master", oid, "")
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	if list, _ := refs.List("refs/"); len(list) != 0 {
+		t.Errorf("expected the ref to be deleted but got: %v", list)
+	}
+}
@@ -0,0 +1,142 @@
+// Package refspec parses the refspecs that map the refs of one repository
+// onto another when fetching and pushing.
+package refspec
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/neocortical/got/ref"
+)
+
+// Refspec maps refs of a remote repository onto local refs when fetching, or
+// local refs onto remote ones when pushing, as in
+// +refs/heads/*:refs/remotes/origin/*. With a '*' in both sides it's a
+// pattern, mapping whatever the '*' in Src matches into the '*' of Dst.
+type Refspec struct {
+	Src string
+	Dst string
+	// Force allows updates that aren't fast-forwards.
+	Force bool
+}
+
+// ParseFetch parses a fetch refspec. Without a destination, the refs it
+// matches are fetched without being stored anywhere.
+func ParseFetch(s string) (Refspec, error) {
+	return parse(s, true)
+}
+
+// ParsePush parses a push refspec. Without a destination, a ref is pushed to
+// the same name; with an empty source, the destination is deleted. A source
+// that isn't a pattern may be any revision.
+func ParsePush(s string) (Refspec, error) {
+	return parse(s, false)
+}
+
+func parse(s string, fetch bool) (result Refspec, err error) {
+	spec := s
+	if strings.HasPrefix(spec, "+") {
+		result.Force = true
+		spec = spec[1:]
+	}
+
+	if colon := strings.LastIndex(spec, ":"); colon != -1 {
+		result.Src, result.Dst = spec[:colon], spec[colon+1:]
+	} else {
+		result.Src = spec
+		if !fetch {
+			result.Dst = spec
+		}
+	}
+
+	srcStars, dstStars := strings.Count(result.Src, "*"), strings.Count(result.Dst, "*")
+	valid := srcStars <= 1 && dstStars <= 1 && (result.Dst == "" || srcStars == dstStars)
+	switch {
+	case fetch:
+		valid = valid && result.Src != "" && validRef(result.Src)
+	case result.Src == "":
+		// deletion
+		valid = valid && result.Dst != "" && !result.Force
+	case srcStars > 0:
+		valid = valid && validRef(result.Src)
+	}
+	if result.Dst != "" {
+		valid = valid && validRef(result.Dst)
+	}
+
+	if !valid {
+		return Refspec{}, fmt.Errorf("invalid refspec '%s'", s)
+	}
+
+	return result, nil
+}
+
+// validRef reports whether name is acceptable as a ref name or pattern,
+// allowing short names like master.
+func validRef(name string) bool {
+	name = strings.Replace(name, "*", "x", 1)
+	if !strings.HasPrefix(name, "refs/") {
+		name = "refs/" + name
+	}
+
+	return ref.ValidName(name)
+}
+
+func (r Refspec) String() string {
+	result := r.Src
+	if r.Dst != r.Src || r.Src == "" {
+		result += ":" + r.Dst
+	}
+	if r.Force {
+		result = "+" + result
+	}
+
+	return result
+}
+
+// IsPattern reports whether the refspec maps any number of refs.
+func (r Refspec) IsPattern() bool {
+	return strings.Contains(r.Src, "*")
+}
+
+// IsDelete reports whether a push refspec deletes its destination.
+func (r Refspec) IsDelete() bool {
+	return r.Src == ""
+}
+
+// Match reports whether the source side of the refspec matches a full ref
+// name.
+func (r Refspec) Match(name string) bool {
+	_, ok := matchPattern(r.Src, name)
+	return ok
+}
+
+// Map returns the ref a matching ref maps to, or false if the refspec doesn't
+// match it. The result is empty for a refspec without a destination.
+func (r Refspec) Map(name string) (string, bool) {
+	captured, ok := matchPattern(r.Src, name)
+	if !ok {
+		return "", false
+	}
+
+	return strings.Replace(r.Dst, "*", captured, 1), true
+}
+
+// Reverse returns the refspec mapping the destination back onto the source.
+func (r Refspec) Reverse() Refspec {
+	return Refspec{Src: r.Dst, Dst: r.Src, Force: r.Force}
+}
+
+func matchPattern(pattern string, name string) (captured string, ok bool) {
+	star := strings.Index(pattern, "*")
+	if star == -1 {
+		return "", pattern == name
+	}
+
+	prefix, suffix := pattern[:star], pattern[star+1:]
+	if len(name) < len(prefix)+len(suffix) || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+		return "", false
+	}
+
+	return name[len(prefix) : len(name)-len(suffix)], true
+}
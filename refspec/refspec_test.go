@@ -0,0 +1,86 @@
+package refspec
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	var tests = []struct {
+		spec     string
+		fetch    bool
+		expected Refspec
+		valid    bool
+	}{
+		{"+refs/heads/*:refs/remotes/origin/*", true, Refspec{"refs/heads/*", "refs/remotes/origin/*", true}, true},
+		{"refs/heads/master:refs/remotes/origin/master", true, Refspec{"refs/heads/master", "refs/remotes/origin/master", false}, true},
+		{"master", true, Refspec{"master", "", false}, true},
+		{"refs/heads/*", true, Refspec{"refs/heads/*", "", false}, true},
+		{"refs/heads/*:refs/remotes/origin/master", true, Refspec{}, false},
+		{"refs/heads/*/*:refs/remotes/*/*", true, Refspec{}, false},
+		{":refs/heads/master", true, Refspec{}, false},
+		{"refs/heads/a..b:refs/heads/c", true, Refspec{}, false},
+		{"master", false, Refspec{"master", "master", false}, true},
+		{"HEAD~1:refs/heads/topic", false, Refspec{"HEAD~1", "refs/heads/topic", false}, true},
+		{":refs/heads/topic", false, Refspec{"", "refs/heads/topic", false}, true},
+		{"+:refs/heads/topic", false, Refspec{}, false},
+		{":", false, Refspec{}, false},
+		{"refs/heads/*:refs/heads/*", false, Refspec{"refs/heads/*", "refs/heads/*", false}, true},
+	}
+
+	for _, test := range tests {
+		parse := ParsePush
+		if test.fetch {
+			parse = ParseFetch
+		}
+
+		result, err := parse(test.spec)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("expected '%s' to be invalid but got: %+v", test.spec, result)
+			}
+			continue
+		}
+		if err != nil || result != test.expected {
+			t.Errorf("expected '%s' to parse as %+v but got: %+v (%v)", test.spec, test.expected, result, err)
+		}
+	}
+}
+
+func TestMap(t *testing.T) {
+	var tests = []struct {
+		spec     string
+		name     string
+		expected string
+		matches  bool
+	}{
+		{"+refs/heads/*:refs/remotes/origin/*", "refs/heads/master", "refs/remotes/origin/master", true},
+		{"+refs/heads/*:refs/remotes/origin/*", "refs/heads/feature/x", "refs/remotes/origin/feature/x", true},
+		{"+refs/heads/*:refs/remotes/origin/*", "refs/tags/v1", "", false},
+		{"refs/heads/*-fix:refs/fixes/*", "refs/heads/bug-fix", "refs/fixes/bug", true},
+		{"refs/heads/*-fix:refs/fixes/*", "refs/heads/feature", "", false},
+		{"refs/heads/master:refs/remotes/origin/master", "refs/heads/master", "refs/remotes/origin/master", true},
+		{"refs/heads/master:refs/remotes/origin/master", "refs/heads/main", "", false},
+		{"refs/tags/*", "refs/tags/v1", "", true},
+	}
+
+	for _, test := range tests {
+		spec, err := ParseFetch(test.spec)
+		if err != nil {
+			t.Fatalf("error parsing '%s': %v", test.spec, err)
+		}
+
+		result, ok := spec.Map(test.name)
+		if ok != test.matches || result != test.expected {
+			t.Errorf("expected '%s' to map %s to '%s' (%v) but got: '%s' (%v)", test.spec, test.name, test.expected, test.matches, result, ok)
+		}
+		if spec.Match(test.name) != test.matches {
+			t.Errorf("expected '%s' matching %s to be %v", test.spec, test.name, test.matches)
+		}
+	}
+
+	reversed, _ := ParseFetch("+refs/heads/*:refs/remotes/origin/*")
+	if name, ok := reversed.Reverse().Map("refs/remotes/origin/topic"); !ok || name != "refs/heads/topic" {
+		t.Errorf("expected the reversed refspec to map back to refs/heads/topic but got: '%s'", name)
+	}
+	if s := reversed.String(); s != "+refs/heads/*:refs/remotes/origin/*" {
+		t.Errorf("unexpected string: '%s'", s)
+	}
+}
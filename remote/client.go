@@ -0,0 +1,732 @@
+package remote
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/neocortical/got/object"
+	"github.com/neocortical/got/objectformat"
+	"github.com/neocortical/got/pktline"
+	"github.com/neocortical/got/ref"
+	"github.com/neocortical/got/repository"
+)
+
+// haveBatch is how many commits the client offers the server in each round
+// of negotiation.
+const haveBatch = 32
+
+// service is upload-pack or receive-pack, running for a connection.
+type service struct {
+	r    io.Reader
+	w    io.WriteCloser
+	wait func() error
+}
+
+// startService runs a service command for the repository at path, the way
+// git does over file:// and ssh: through the shell, with the path quoted as
+// its last argument. It's a variable so that tests can serve in-process.
+var startService = func(command string, path string, env []string, stderr io.Writer) (*service, error) {
+	cmd := exec.Command("sh", "-c", command+" '"+strings.ReplaceAll(path, "'", `'\''`)+"'")
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stderr = stderr
+
+	w, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	r, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, fmt.Errorf("error starting %s: %w", command, err)
+	}
+
+	return &service{r: r, w: w, wait: cmd.Wait}, nil
+}
+
+// uploadPackConnection fetches from upload-pack with protocol version 2.
+type uploadPackConnection struct {
+	svc          *service
+	pr           *pktline.Reader
+	pw           *pktline.Writer
+	progress     io.Writer
+	format       *objectformat.Format
+	capabilities map[string]string
+}
+
+func openUploadPack(command string, url string, wd string, progress io.Writer) (*uploadPackConnection, error) {
+	if progress == nil {
+		progress = ioutil.Discard
+	}
+	svc, err := startService(command, LocalPath(url, wd), []string{"GIT_PROTOCOL=version=2"}, progress)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &uploadPackConnection{
+		svc:          svc,
+		pr:           pktline.NewReader(svc.r),
+		pw:           pktline.NewWriter(svc.w),
+		progress:     progress,
+		format:       objectformat.SHA1,
+		capabilities: map[string]string{},
+	}
+	if err = c.readCapabilities(); err != nil {
+		svc.w.Close()
+		svc.wait()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *uploadPackConnection) readCapabilities() error {
+	line, _, err := c.pr.ReadLine()
+	if err != nil {
+		return fmt.Errorf("error reading capabilities: %w", err)
+	}
+	if line != "version 2" {
+		return fmt.Errorf("the server doesn't speak protocol version 2: '%s'", line)
+	}
+
+	for {
+		line, kind, err := c.pr.ReadLine()
+		if err != nil {
+			return fmt.Errorf("error reading capabilities: %w", err)
+		}
+		if kind == pktline.Flush {
+			break
+		}
+		key, value := line, ""
+		if eq := strings.IndexByte(line, '='); eq != -1 {
+			key, value = line[:eq], line[eq+1:]
+		}
+		c.capabilities[key] = value
+	}
+
+	if name, ok := c.capabilities["object-format"]; ok {
+		if c.format, err = objectformat.Parse(name); err != nil {
+			return err
+		}
+	}
+	if _, ok := c.capabilities["fetch"]; !ok {
+		return errors.New("the server doesn't support fetch")
+	}
+
+	return nil
+}
+
+// sendRequest sends a command with its arguments.
+func (c *uploadPackConnection) sendRequest(command string, args []string) error {
+	lines := []string{"command=" + command, "agent=" + agent}
+	if _, ok := c.capabilities["object-format"]; ok {
+		lines = append(lines, "object-format="+c.format.String())
+	}
+	for _, line := range lines {
+		if err := c.pw.WriteString(line); err != nil {
+			return err
+		}
+	}
+	if err := c.pw.Delim(); err != nil {
+		return err
+	}
+	for _, arg := range args {
+		if err := c.pw.WriteString(arg); err != nil {
+			return err
+		}
+	}
+
+	return c.pw.Flush()
+}
+
+func (c *uploadPackConnection) objectFormat() *objectformat.Format {
+	return c.format
+}
+
+func (c *uploadPackConnection) refs() ([]advertisedRef, error) {
+	if err := c.sendRequest("ls-refs", []string{"symrefs", "peel"}); err != nil {
+		return nil, err
+	}
+
+	var result []advertisedRef
+	for {
+		line, kind, err := c.pr.ReadLine()
+		if err != nil {
+			return nil, fmt.Errorf("error reading refs: %w", err)
+		}
+		if kind == pktline.Flush {
+			return result, nil
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !c.format.IsOID(fields[0]) {
+			return nil, fmt.Errorf("bad ref line '%s'", line)
+		}
+		r := advertisedRef{OID: fields[0], Name: fields[1]}
+		for _, attr := range fields[2:] {
+			switch {
+			case strings.HasPrefix(attr, "symref-target:"):
+				r.Target = strings.TrimPrefix(attr, "symref-target:")
+			case strings.HasPrefix(attr, "peeled:"):
+				r.Peeled = strings.TrimPrefix(attr, "peeled:")
+			}
+		}
+		result = append(result, r)
+	}
+}
+
+func (c *uploadPackConnection) fetch(repo *repository.Repo, req fetchRequest) ([]string, error) {
+	if len(req.wants) == 0 {
+		return nil, nil
+	}
+
+	var base []string
+	if req.includeTags {
+		base = append(base, "include-tag")
+	}
+	base = append(base, "ofs-delta", "thin-pack")
+	for _, oid := range req.wants {
+		base = append(base, "want "+oid)
+	}
+
+	shallow, err := repo.Shallow()
+	if err != nil {
+		return nil, err
+	}
+	if len(shallow) > 0 {
+		if !c.fetchFeature("shallow") {
+			return nil, errors.New("the server doesn't support shallow clients")
+		}
+		for _, oid := range sortedOIDs(shallow) {
+			base = append(base, "shallow "+oid)
+		}
+	}
+	if req.filter != nil {
+		if c.fetchFeature("filter") {
+			base = append(base, "filter "+req.filter.String())
+		} else {
+			fmt.Fprintln(c.progress, "warning: filtering not recognized by server, ignoring")
+		}
+	}
+
+	n := &negotiator{}
+	if !req.lazy {
+		if n, err = newNegotiator(repo); err != nil {
+			return nil, err
+		}
+	}
+	for {
+		haves := n.next(haveBatch)
+		args := append(append([]string(nil), base...), n.commonHaves()...)
+		for _, oid := range haves {
+			args = append(args, "have "+oid)
+		}
+		if len(haves) == 0 {
+			args = append(args, "done")
+		}
+		if err = c.sendRequest("fetch", args); err != nil {
+			return nil, err
+		}
+		if len(haves) == 0 {
+			break
+		}
+
+		ready, err := c.readAcknowledgments(n)
+		if err != nil {
+			return nil, err
+		}
+		if ready {
+			break
+		}
+	}
+
+	line, _, err := c.pr.ReadLine()
+	if err != nil {
+		return nil, fmt.Errorf("error reading packfile: %w", err)
+	}
+	shallowInfo := line == "shallow-info"
+	if shallowInfo {
+		if err = c.readShallowInfo(shallow); err != nil {
+			return nil, err
+		}
+		if line, _, err = c.pr.ReadLine(); err != nil {
+			return nil, fmt.Errorf("error reading packfile: %w", err)
+		}
+	}
+	if line != "packfile" {
+		return nil, fmt.Errorf("expected packfile, got '%s'", line)
+	}
+
+	sr := pktline.NewSidebandReader(c.pr, &progressWriter{w: c.progress})
+	stored, err := object.UnpackObjects(sr, c.format, repo.Database())
+	if err != nil {
+		return nil, err
+	}
+	if err = sr.Drain(); err != nil {
+		return nil, err
+	}
+
+	// only once the pack is in, so that the parents of commits no longer
+	// shallow are there
+	if shallowInfo {
+		err = repo.SetShallow(sortedOIDs(shallow))
+	}
+
+	return stored, err
+}
+
+// fetchFeature reports whether the server's fetch command has a feature.
+func (c *uploadPackConnection) fetchFeature(name string) bool {
+	for _, feature := range strings.Fields(c.capabilities["fetch"]) {
+		if feature == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readShallowInfo reads the commits the server made shallow, or no longer
+// shallow, for the pack it sends, updating shallow to match.
+func (c *uploadPackConnection) readShallowInfo(shallow map[string]bool) error {
+	for {
+		line, kind, err := c.pr.ReadLine()
+		switch {
+		case err != nil:
+			return fmt.Errorf("error reading shallow-info: %w", err)
+		case kind == pktline.Delim:
+			return nil
+		case strings.HasPrefix(line, "shallow ") && c.format.IsOID(line[len("shallow "):]):
+			shallow[line[len("shallow "):]] = true
+		case strings.HasPrefix(line, "unshallow ") && c.format.IsOID(line[len("unshallow "):]):
+			delete(shallow, line[len("unshallow "):])
+		default:
+			return fmt.Errorf("unexpected shallow-info line '%s'", line)
+		}
+	}
+}
+
+// sortedOIDs lists a set of object IDs in order.
+func sortedOIDs(set map[string]bool) []string {
+	var result []string
+	for oid := range set {
+		result = append(result, oid)
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+// readAcknowledgments reads the server's answer to a round of haves,
+// reporting whether it's ready to send the pack.
+func (c *uploadPackConnection) readAcknowledgments(n *negotiator) (bool, error) {
+	line, _, err := c.pr.ReadLine()
+	if err != nil {
+		return false, fmt.Errorf("error reading acknowledgments: %w", err)
+	}
+	if line != "acknowledgments" {
+		return false, fmt.Errorf("expected acknowledgments, got '%s'", line)
+	}
+
+	for {
+		line, kind, err := c.pr.ReadLine()
+		switch {
+		case err != nil:
+			return false, fmt.Errorf("error reading acknowledgments: %w", err)
+		case kind == pktline.Flush:
+			return false, nil
+		case kind == pktline.Delim:
+			return true, nil
+		case strings.HasPrefix(line, "ACK "):
+			if err = n.ack(strings.TrimPrefix(line, "ACK ")); err != nil {
+				return false, err
+			}
+		case line == "NAK", line == "ready":
+		default:
+			return false, fmt.Errorf("unexpected acknowledgment '%s'", line)
+		}
+	}
+}
+
+func (c *uploadPackConnection) close() error {
+	c.pw.Flush()
+	c.svc.w.Close()
+	return c.svc.wait()
+}
+
+// progressWriter passes on a server's progress messages, marking each line
+// as theirs. Lines may end with a carriage return, to be overwritten.
+type progressWriter struct {
+	w       io.Writer
+	midLine bool
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	var buf bytes.Buffer
+	for _, c := range p {
+		if !pw.midLine {
+			buf.WriteString("remote: ")
+		}
+		buf.WriteByte(c)
+		pw.midLine = c != '\n' && c != '\r'
+	}
+	pw.w.Write(buf.Bytes())
+
+	return len(p), nil
+}
+
+// negotiator picks the commits a fetch offers as haves: the local refs and
+// their history, newest first, skipping what the server is known to have.
+type negotiator struct {
+	repo *repository.Repo
+	// shallow are the commits whose parents repo doesn't have
+	shallow map[string]bool
+	queue   []string
+	seen    map[string]bool
+	common  map[string]bool
+	// acked are the commits the server said it has
+	acked []string
+}
+
+func newNegotiator(repo *repository.Repo) (*negotiator, error) {
+	shallow, err := repo.Shallow()
+	if err != nil {
+		return nil, err
+	}
+	n := &negotiator{repo: repo, shallow: shallow, seen: map[string]bool{}, common: map[string]bool{}}
+
+	refs, err := repo.Refs().List("refs/")
+	if err != nil {
+		return nil, fmt.Errorf("error listing refs: %w", err)
+	}
+	if head, err := repo.Refs().ReadHead(); err == nil && head != "" {
+		refs = append(refs, ref.Reference{Name: headName, OID: head})
+	}
+	for _, r := range refs {
+		target, _, err := peelTags(repo, r.OID)
+		if err != nil {
+			// refs pointing at objects we lack can't be offered
+			continue
+		}
+		n.push(target)
+	}
+
+	return n, nil
+}
+
+func (n *negotiator) push(oid string) {
+	if !n.seen[oid] {
+		n.seen[oid] = true
+		n.queue = append(n.queue, oid)
+	}
+}
+
+// next returns up to count more commits to offer.
+func (n *negotiator) next(count int) []string {
+	var result []string
+	for len(result) < count && len(n.queue) > 0 {
+		oid := n.queue[0]
+		n.queue = n.queue[1:]
+		if n.common[oid] {
+			continue
+		}
+
+		objectType, links, err := readLinks(n.repo, oid)
+		if err != nil || objectType != ref.TypeCommit {
+			continue
+		}
+		result = append(result, oid)
+		for _, link := range links {
+			if link.Type == ref.TypeCommit && !n.shallow[oid] {
+				n.push(link.OID)
+			}
+		}
+	}
+
+	return result
+}
+
+// ack records that the server has a commit, and so all of its history.
+func (n *negotiator) ack(oid string) error {
+	if n.common[oid] {
+		return nil
+	}
+	n.acked = append(n.acked, oid)
+
+	queue := []string{oid}
+	for len(queue) > 0 {
+		oid := queue[0]
+		queue = queue[1:]
+		if n.common[oid] {
+			continue
+		}
+		n.common[oid] = true
+		if n.shallow[oid] {
+			continue
+		}
+
+		_, links, err := readLinks(n.repo, oid)
+		if err != nil {
+			return err
+		}
+		for _, link := range links {
+			if link.Type == ref.TypeCommit {
+				queue = append(queue, link.OID)
+			}
+		}
+	}
+
+	return nil
+}
+
+// commonHaves repeats the haves the server acknowledged, which each request
+// of the stateless protocol needs.
+func (n *negotiator) commonHaves() []string {
+	var result []string
+	for _, oid := range n.acked {
+		result = append(result, "have "+oid)
+	}
+
+	return result
+}
+
+// receivePackConnection pushes to receive-pack with protocol version 0.
+type receivePackConnection struct {
+	svc          *service
+	pr           *pktline.Reader
+	pw           *pktline.Writer
+	progress     io.Writer
+	format       *objectformat.Format
+	capabilities map[string]bool
+	advertised   []advertisedRef
+	pushed       bool
+}
+
+func openReceivePack(command string, url string, wd string, progress io.Writer) (*receivePackConnection, error) {
+	if progress == nil {
+		progress = ioutil.Discard
+	}
+	svc, err := startService(command, LocalPath(url, wd), nil, progress)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &receivePackConnection{
+		svc:          svc,
+		pr:           pktline.NewReader(svc.r),
+		pw:           pktline.NewWriter(svc.w),
+		progress:     progress,
+		format:       objectformat.SHA1,
+		capabilities: map[string]bool{},
+	}
+	if err = c.readAdvertisement(); err != nil {
+		svc.w.Close()
+		svc.wait()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *receivePackConnection) readAdvertisement() error {
+	for first := true; ; first = false {
+		line, kind, err := c.pr.ReadLine()
+		if err != nil {
+			return fmt.Errorf("error reading refs: %w", err)
+		}
+		if kind == pktline.Flush {
+			return nil
+		}
+		if first && line == "version 1" {
+			first = true
+			continue
+		}
+
+		if nul := strings.IndexByte(line, 0); nul != -1 {
+			for _, capability := range strings.Fields(line[nul+1:]) {
+				if strings.HasPrefix(capability, "object-format=") {
+					if c.format, err = objectformat.Parse(strings.TrimPrefix(capability, "object-format=")); err != nil {
+						return err
+					}
+				}
+				c.capabilities[capability] = true
+			}
+			line = line[:nul]
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return fmt.Errorf("bad ref line '%s'", line)
+		}
+		if fields[1] == "capabilities^{}" || strings.HasSuffix(fields[1], "^{}") || fields[1] == ".have" {
+			continue
+		}
+		c.advertised = append(c.advertised, advertisedRef{OID: fields[0], Name: fields[1]})
+	}
+}
+
+func (c *receivePackConnection) objectFormat() *objectformat.Format {
+	return c.format
+}
+
+func (c *receivePackConnection) refs() ([]advertisedRef, error) {
+	return c.advertised, nil
+}
+
+func (c *receivePackConnection) push(repo *repository.Repo, commands []command) ([]string, error) {
+	c.pushed = true
+
+	var requested []string
+	for _, capability := range []string{"report-status", "side-band-64k", "quiet", "object-format=" + c.format.String()} {
+		if c.capabilities[capability] {
+			requested = append(requested, capability)
+		}
+	}
+	requested = append(requested, "agent="+agent)
+
+	sendPack := false
+	for i, cmd := range commands {
+		oldOID, newOID := cmd.oldOID, cmd.newOID
+		if oldOID == "" {
+			oldOID = c.format.ZeroOID()
+		}
+		if newOID == "" {
+			newOID = c.format.ZeroOID()
+		} else {
+			sendPack = true
+		}
+
+		line := fmt.Sprintf("%s %s %s", oldOID, newOID, cmd.name)
+		if i == 0 {
+			line += "\x00" + strings.Join(requested, " ")
+		}
+		if err := c.pw.WriteString(line); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.pw.Flush(); err != nil {
+		return nil, err
+	}
+
+	if sendPack {
+		oids, err := c.missingObjects(repo, commands)
+		if err != nil {
+			return nil, err
+		}
+		if err = object.WritePack(c.svc.w, c.format, repo.Database(), oids); err != nil {
+			return nil, err
+		}
+	}
+
+	reasons := make([]string, len(commands))
+	if !c.capabilities["report-status"] {
+		return reasons, nil
+	}
+	return c.readReport(commands)
+}
+
+// missingObjects lists the objects the commands need that the receiving
+// repository doesn't have: everything not reachable from its refs.
+func (c *receivePackConnection) missingObjects(repo *repository.Repo, commands []command) ([]string, error) {
+	var theirs []string
+	for _, r := range c.advertised {
+		if repo.Database().Has(r.OID) {
+			theirs = append(theirs, r.OID)
+		}
+	}
+	known, err := MissingObjects(repo, theirs, func(string) bool { return false })
+	if err != nil {
+		return nil, err
+	}
+	has := map[string]bool{}
+	for _, oid := range known {
+		has[oid] = true
+	}
+
+	var tips []string
+	for _, cmd := range commands {
+		if cmd.newOID != "" {
+			tips = append(tips, cmd.newOID)
+		}
+	}
+
+	return MissingObjects(repo, tips, func(oid string) bool { return has[oid] })
+}
+
+// readReport reads what became of each command.
+func (c *receivePackConnection) readReport(commands []command) ([]string, error) {
+	pr := c.pr
+	if c.capabilities["side-band-64k"] {
+		sr := pktline.NewSidebandReader(c.pr, &progressWriter{w: c.progress})
+		pr = pktline.NewReader(sr)
+		// the sideband ends with a flush of its own
+		defer sr.Drain()
+	}
+
+	line, _, err := pr.ReadLine()
+	if err != nil {
+		return nil, fmt.Errorf("error reading push report: %w", err)
+	}
+	if !strings.HasPrefix(line, "unpack ") {
+		return nil, fmt.Errorf("bad push report '%s'", line)
+	}
+	unpackErr := ""
+	if status := strings.TrimPrefix(line, "unpack "); status != "ok" {
+		unpackErr = status
+	}
+
+	byName := map[string]string{}
+	for {
+		line, kind, err := pr.ReadLine()
+		if err != nil {
+			return nil, fmt.Errorf("error reading push report: %w", err)
+		}
+		if kind == pktline.Flush {
+			break
+		}
+
+		switch {
+		case strings.HasPrefix(line, "ok "):
+			byName[strings.TrimPrefix(line, "ok ")] = ""
+		case strings.HasPrefix(line, "ng "):
+			fields := strings.SplitN(strings.TrimPrefix(line, "ng "), " ", 2)
+			reason := "failed"
+			if len(fields) == 2 {
+				reason = fields[1]
+			}
+			byName[fields[0]] = reason
+		default:
+			return nil, fmt.Errorf("bad push report '%s'", line)
+		}
+	}
+	if unpackErr != "" {
+		return nil, fmt.Errorf("unpack failed: %s", unpackErr)
+	}
+
+	reasons := make([]string, len(commands))
+	for i, cmd := range commands {
+		reason, ok := byName[cmd.name]
+		if !ok {
+			reason = "no report from the server"
+		}
+		reasons[i] = reason
+	}
+
+	return reasons, nil
+}
+
+func (c *receivePackConnection) close() error {
+	if !c.pushed {
+		c.pw.Flush()
+	}
+	c.svc.w.Close()
+	return c.svc.wait()
+}
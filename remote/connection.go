@@ -0,0 +1,191 @@
+package remote
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/neocortical/got/objectformat"
+	"github.com/neocortical/got/ref"
+	"github.com/neocortical/got/repository"
+	"github.com/neocortical/got/revision"
+)
+
+// advertisedRef is a ref of the repository at the other end of a
+// connection.
+type advertisedRef struct {
+	Name string
+	OID  string
+	// Target is the ref a symbolic ref points at.
+	Target string
+	// Peeled is the object an annotated tag points at, past any tags.
+	Peeled string
+}
+
+// command is an update of a ref of the receiving repository in a push.
+// Empty OIDs stand for a ref that doesn't exist, before or after.
+type command struct {
+	name   string
+	oldOID string
+	newOID string
+}
+
+// fetchConnection reaches the repository a fetch copies from.
+type fetchConnection interface {
+	objectFormat() *objectformat.Format
+	// refs lists the remote's refs, and HEAD if it has a commit.
+	refs() ([]advertisedRef, error)
+	// fetch copies the objects a request needs into repo, returning those
+	// it stored.
+	fetch(repo *repository.Repo, req fetchRequest) ([]string, error)
+	close() error
+}
+
+// fetchRequest asks a fetchConnection for objects.
+type fetchRequest struct {
+	wants []string
+	// includeTags lets the remote send the tags pointing at what it sends
+	// too.
+	includeTags bool
+	// filter leaves objects out of what's sent, as for a partial clone.
+	filter *Filter
+	// lazy asks for the wants alone, without offering what repo has, as a
+	// partial clone does for objects it was promised.
+	lazy bool
+}
+
+// pushConnection reaches the repository a push updates.
+type pushConnection interface {
+	objectFormat() *objectformat.Format
+	// refs lists the remote's refs.
+	refs() ([]advertisedRef, error)
+	// push sends the objects the commands need from repo and runs them,
+	// returning for each the reason it was refused, or "".
+	push(repo *repository.Repo, commands []command) ([]string, error)
+	close() error
+}
+
+// localConnection reaches a repository on the local filesystem directly.
+type localConnection struct {
+	repo      *repository.Repo
+	committer ref.Author
+	// progress receives the output of the repository's receive hooks
+	progress io.Writer
+}
+
+func openLocal(url string, wd string, committer ref.Author) (*localConnection, error) {
+	repo, err := OpenRepository(url, wd)
+	if err != nil {
+		return nil, err
+	}
+
+	return &localConnection{repo: repo, committer: committer}, nil
+}
+
+func (c *localConnection) objectFormat() *objectformat.Format {
+	return c.repo.ObjectFormat()
+}
+
+func (c *localConnection) refs() ([]advertisedRef, error) {
+	return listRefs(c.repo, true)
+}
+
+func (c *localConnection) fetch(repo *repository.Repo, req fetchRequest) ([]string, error) {
+	missing, err := MissingObjects(c.repo, req.wants, repo.Database().Has)
+	if err != nil {
+		return nil, err
+	}
+	if req.filter != nil {
+		if missing, _, err = FilterObjects(c.repo, missing, req.wants, req.filter); err != nil {
+			return nil, err
+		}
+	}
+
+	return missing, CopyObjects(c.repo, repo, missing)
+}
+
+func (c *localConnection) push(repo *repository.Repo, commands []command) ([]string, error) {
+	var tips []string
+	for _, cmd := range commands {
+		if cmd.newOID != "" {
+			tips = append(tips, cmd.newOID)
+		}
+	}
+
+	missing, err := MissingObjects(repo, tips, c.repo.Database().Has)
+	if err != nil {
+		return nil, err
+	}
+	if err = CopyObjects(repo, c.repo, missing); err != nil {
+		return nil, err
+	}
+
+	return receiveCommands(c.repo, commands, c.committer, c.progress)
+}
+
+func (c *localConnection) close() error {
+	return nil
+}
+
+// listRefs lists a repository's refs to advertise them: HEAD, if it has a
+// commit, then every ref with annotated tags peeled.
+func listRefs(repo *repository.Repo, withHead bool) ([]advertisedRef, error) {
+	var result []advertisedRef
+
+	if withHead {
+		oid, err := repo.Refs().ReadHead()
+		if err != nil {
+			return nil, fmt.Errorf("error reading HEAD: %w", err)
+		}
+		if oid != "" {
+			target, err := repo.Refs().ReadSymbolicRef(headName)
+			if err != nil {
+				return nil, fmt.Errorf("error reading HEAD: %w", err)
+			}
+			result = append(result, advertisedRef{Name: headName, OID: oid, Target: target})
+		}
+	}
+
+	refs, err := repo.Refs().List("refs/")
+	if err != nil {
+		return nil, fmt.Errorf("error listing refs: %w", err)
+	}
+	for _, r := range refs {
+		adv := advertisedRef{Name: r.Name, OID: r.OID, Target: r.Target}
+		if strings.HasPrefix(r.Name, TagsPrefix) {
+			target, tags, err := peelTags(repo, r.OID)
+			if err != nil {
+				return nil, err
+			}
+			if len(tags) > 0 {
+				adv.Peeled = target
+			}
+		}
+		result = append(result, adv)
+	}
+
+	return result, nil
+}
+
+// findRef looks a ref up by its full name.
+func findRef(refs []advertisedRef, name string) (advertisedRef, bool) {
+	for _, r := range refs {
+		if r.Name == name {
+			return r, true
+		}
+	}
+
+	return advertisedRef{}, false
+}
+
+// expandRef finds the full name of an abbreviated ref among advertised refs,
+// the way revision.ExpandRef does among local ones.
+func expandRef(refs []advertisedRef, name string) string {
+	for _, candidate := range revision.RefCandidates(name) {
+		if _, ok := findRef(refs, candidate); ok {
+			return candidate
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,409 @@
+package remote
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/neocortical/got/ref"
+	"github.com/neocortical/got/refspec"
+	"github.com/neocortical/got/repository"
+)
+
+const (
+	headName      = "HEAD"
+	fetchHeadFile = "FETCH_HEAD"
+)
+
+// FetchOptions control a fetch.
+type FetchOptions struct {
+	// Refspecs replace the remote's fetch refspecs.
+	Refspecs []refspec.Refspec
+	// Force allows every update, as if each refspec started with '+'.
+	Force     bool
+	Committer ref.Author
+	// Message starts the reflog messages of the refs updated, as in
+	// "fetch origin".
+	Message string
+	// UploadPack is the command serving the fetch, in place of the remote's
+	// uploadpack setting. Without either, a repository on the local
+	// filesystem is read directly.
+	UploadPack string
+	// Progress receives the server's progress messages.
+	Progress io.Writer
+	// Filter leaves objects out of the fetch, to be fetched when they're
+	// needed. Without it, a promisor remote's filter is used.
+	Filter *Filter
+}
+
+// fetchedRef is a remote ref a fetch copies.
+type fetchedRef struct {
+	src      string
+	dst      string
+	oid      string
+	force    bool
+	forMerge bool
+}
+
+// Fetch copies the refs the refspecs match from a remote, along with the
+// objects they need, and lists them in FETCH_HEAD. Without refspecs, and
+// without any configured, it fetches the remote's HEAD into FETCH_HEAD alone.
+// Unless refspecs are given, tags pointing at what's fetched come too.
+// Updates that aren't fast-forwards are rejected unless forced; the rest are
+// made whether or not others are rejected.
+func Fetch(repo *repository.Repo, rem *Remote, wd string, opts FetchOptions) (_ []Update, err error) {
+	conn, err := openFetch(rem, wd, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := conn.close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("error closing connection: %w", closeErr)
+		}
+	}()
+	if conn.objectFormat() != repo.ObjectFormat() {
+		return nil, fmt.Errorf("mismatched object format: the remote uses %s, not %s", conn.objectFormat(), repo.ObjectFormat())
+	}
+
+	refs, err := conn.refs()
+	if err != nil {
+		return nil, err
+	}
+
+	explicit := len(opts.Refspecs) > 0
+	specs := opts.Refspecs
+	if !explicit {
+		specs = rem.Fetch
+	}
+
+	fetched, err := matchFetchRefspecs(refs, specs, explicit, opts.Force)
+	if err != nil {
+		return nil, err
+	}
+	if !explicit {
+		if err = markForMerge(repo, rem, fetched); err != nil {
+			return nil, err
+		}
+	}
+	if err = checkCurrentBranch(repo, fetched); err != nil {
+		return nil, err
+	}
+
+	filter, err := fetchFilter(rem, opts)
+	if err != nil {
+		return nil, err
+	}
+	followTags := !explicit && rem.Name != ""
+	req := fetchRequest{wants: missingWants(repo, fetched), includeTags: followTags, filter: filter}
+	stored, err := conn.fetch(repo, req)
+	if err != nil {
+		return nil, err
+	}
+	if followTags {
+		var tagged []fetchedRef
+		if fetched, tagged, err = addFollowedTags(repo, refs, fetched); err != nil {
+			return nil, err
+		}
+		req = fetchRequest{wants: missingWants(repo, tagged), filter: filter}
+		more, err := conn.fetch(repo, req)
+		if err != nil {
+			return nil, err
+		}
+		stored = append(stored, more...)
+	}
+	if filter != nil {
+		if err = recordPromised(repo, stored); err != nil {
+			return nil, err
+		}
+	}
+
+	var updates []Update
+	for _, f := range fetched {
+		u := Update{Src: f.src, Dst: f.dst, NewOID: f.oid, Status: StatusNew}
+		if f.dst != "" {
+			if err = updateFetchedRef(repo, &u, f.force, opts); err != nil {
+				return nil, err
+			}
+		}
+		updates = append(updates, u)
+	}
+
+	if err = writeFetchHead(repo, rem.URL, fetched); err != nil {
+		return nil, err
+	}
+
+	return updates, nil
+}
+
+// openFetch connects to the repository a fetch copies from.
+func openFetch(rem *Remote, wd string, opts FetchOptions) (fetchConnection, error) {
+	command := opts.UploadPack
+	if command == "" {
+		command = rem.UploadPack
+	}
+	if command != "" {
+		return openUploadPack(command, rem.URL, wd, opts.Progress)
+	}
+
+	return openLocal(rem.URL, wd, opts.Committer)
+}
+
+// fetchFilter returns the filter a fetch uses, if any.
+func fetchFilter(rem *Remote, opts FetchOptions) (*Filter, error) {
+	if opts.Filter != nil || !rem.Promisor || rem.PartialCloneFilter == "" {
+		return opts.Filter, nil
+	}
+
+	return ParseFilter(rem.PartialCloneFilter)
+}
+
+// fetchPromised fetches objects a partial clone was promised from its
+// promisor remote, without negotiating or filtering.
+func fetchPromised(repo *repository.Repo, name string, oids []string) (err error) {
+	cfg, err := repo.Config()
+	if err != nil {
+		return err
+	}
+	rem, err := Get(cfg, name)
+	if err != nil {
+		return err
+	}
+
+	conn, err := openFetch(rem, repo.WorkspaceDir(), FetchOptions{})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := conn.close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("error closing connection: %w", closeErr)
+		}
+	}()
+
+	_, err = conn.fetch(repo, fetchRequest{wants: oids, lazy: true})
+	return err
+}
+
+// missingWants lists the objects of fetched refs that repo doesn't have yet.
+func missingWants(repo *repository.Repo, fetched []fetchedRef) []string {
+	var result []string
+	wanted := map[string]bool{}
+	for _, f := range fetched {
+		if !wanted[f.oid] && !repo.Database().Has(f.oid) {
+			wanted[f.oid] = true
+			result = append(result, f.oid)
+		}
+	}
+
+	return result
+}
+
+// matchFetchRefspecs finds the remote refs the refspecs match. Refspecs
+// that aren't patterns may abbreviate the remote ref, and must match one.
+func matchFetchRefspecs(refs []advertisedRef, specs []refspec.Refspec, explicit bool, force bool) ([]fetchedRef, error) {
+	if len(specs) == 0 {
+		head, ok := findRef(refs, headName)
+		if !ok {
+			return nil, fmt.Errorf("couldn't find remote ref %s", headName)
+		}
+		return []fetchedRef{{src: headName, oid: head.OID, forMerge: true}}, nil
+	}
+
+	var result []fetchedRef
+	stored := map[string]bool{}
+	add := func(f fetchedRef) {
+		if f.dst == "" || !stored[f.dst] {
+			stored[f.dst] = true
+			result = append(result, f)
+		}
+	}
+
+	for _, spec := range specs {
+		if spec.IsPattern() {
+			for _, r := range refs {
+				if dst, ok := spec.Map(r.Name); ok {
+					add(fetchedRef{src: r.Name, dst: dst, oid: r.OID, force: spec.Force || force})
+				}
+			}
+			continue
+		}
+
+		r, ok := findRef(refs, expandRef(refs, spec.Src))
+		if !ok {
+			return nil, fmt.Errorf("couldn't find remote ref %s", spec.Src)
+		}
+
+		add(fetchedRef{src: r.Name, dst: expandLocalRef(spec.Dst, r.Name), oid: r.OID, force: spec.Force || force, forMerge: explicit})
+	}
+
+	return result, nil
+}
+
+// expandLocalRef turns an abbreviated destination into a full ref name: a
+// tag if the source is one, and otherwise a branch.
+func expandLocalRef(dst string, src string) string {
+	if dst == "" || strings.HasPrefix(dst, "refs/") || dst == headName {
+		return dst
+	}
+	if strings.HasPrefix(src, TagsPrefix) {
+		return TagsPrefix + dst
+	}
+
+	return ref.HeadsPrefix + dst
+}
+
+// markForMerge marks the remote branch the current branch follows, which is
+// what a pull merges.
+func markForMerge(repo *repository.Repo, rem *Remote, fetched []fetchedRef) error {
+	branch, err := repo.Refs().ReadSymbolicRef(headName)
+	if err != nil || !strings.HasPrefix(branch, ref.HeadsPrefix) {
+		return err
+	}
+
+	cfg, err := repo.Config()
+	if err != nil {
+		return err
+	}
+	key := "branch." + strings.TrimPrefix(branch, ref.HeadsPrefix)
+	if remoteName, _ := cfg.Get(key + ".remote"); remoteName != rem.Name {
+		return nil
+	}
+
+	merge, _ := cfg.Get(key + ".merge")
+	for i := range fetched {
+		fetched[i].forMerge = fetched[i].src == merge
+	}
+
+	return nil
+}
+
+// checkCurrentBranch refuses to fetch into the branch checked out, which
+// would leave the workspace out of step with it.
+func checkCurrentBranch(repo *repository.Repo, fetched []fetchedRef) error {
+	if repo.Bare() {
+		return nil
+	}
+
+	branch, err := repo.Refs().ReadSymbolicRef(headName)
+	if err != nil || branch == "" {
+		return err
+	}
+	for _, f := range fetched {
+		if f.dst == branch {
+			return fmt.Errorf("refusing to fetch into branch '%s' checked out at '%s'", branch, repo.WorkspaceDir())
+		}
+	}
+
+	return nil
+}
+
+// addFollowedTags adds the remote's tags that point at objects now here to
+// what's fetched, unless there's a local tag of the same name. It returns the
+// tags added too, whose tag objects may still need fetching.
+func addFollowedTags(repo *repository.Repo, refs []advertisedRef, fetched []fetchedRef) ([]fetchedRef, []fetchedRef, error) {
+	storing := map[string]bool{}
+	for _, f := range fetched {
+		storing[f.dst] = true
+	}
+
+	var tagged []fetchedRef
+	for _, r := range refs {
+		if !strings.HasPrefix(r.Name, TagsPrefix) || storing[r.Name] {
+			continue
+		}
+		if local, err := repo.Refs().ReadRef(r.Name); err != nil || local != "" {
+			continue
+		}
+
+		target := r.Peeled
+		if target == "" {
+			target = r.OID
+		}
+		if !repo.Database().Has(target) {
+			continue
+		}
+
+		tagged = append(tagged, fetchedRef{src: r.Name, dst: r.Name, oid: r.OID})
+	}
+
+	return append(fetched, tagged...), tagged, nil
+}
+
+// updateFetchedRef stores a fetched ref, if it's allowed to move.
+func updateFetchedRef(repo *repository.Repo, u *Update, force bool, opts FetchOptions) error {
+	var err error
+	if u.OldOID, err = repo.Refs().ReadRef(u.Dst); err != nil {
+		return fmt.Errorf("error reading %s: %w", u.Dst, err)
+	}
+
+	u.Status, u.Reason, err = classify(repo, u.Dst, u.OldOID, u.NewOID, force)
+	if err != nil || !u.OK() || u.Status == StatusUpToDate {
+		return err
+	}
+
+	var message string
+	switch u.Status {
+	case StatusNew:
+		message = "storing head"
+	case StatusFastForward:
+		message = "fast-forward"
+	case StatusForced:
+		message = "forced-update"
+	}
+	if opts.Message != "" {
+		message = opts.Message + ": " + message
+	}
+
+	oldOID := u.OldOID
+	if oldOID == "" {
+		oldOID = ref.ZeroOID
+	}
+	t := repo.Refs().Transaction(opts.Committer)
+	if err = t.Update(u.Dst, u.NewOID, oldOID, message); err == nil {
+		err = t.Commit()
+	}
+	if err != nil {
+		u.Status, u.Reason = StatusRejected, fmt.Sprintf("unable to update local ref: %v", err)
+	}
+
+	return nil
+}
+
+// writeFetchHead records what was fetched, the refs for merging first, the
+// way git does for pull.
+func writeFetchHead(repo *repository.Repo, url string, fetched []fetchedRef) error {
+	var buf strings.Builder
+	for _, forMerge := range []bool{true, false} {
+		for _, f := range fetched {
+			if f.forMerge != forMerge {
+				continue
+			}
+
+			marker := "not-for-merge"
+			if f.forMerge {
+				marker = ""
+			}
+
+			var description string
+			switch {
+			case f.src == headName:
+				description = url
+			case strings.HasPrefix(f.src, ref.HeadsPrefix):
+				description = fmt.Sprintf("branch '%s' of %s", strings.TrimPrefix(f.src, ref.HeadsPrefix), url)
+			case strings.HasPrefix(f.src, TagsPrefix):
+				description = fmt.Sprintf("tag '%s' of %s", strings.TrimPrefix(f.src, TagsPrefix), url)
+			default:
+				description = fmt.Sprintf("'%s' of %s", f.src, url)
+			}
+
+			fmt.Fprintf(&buf, "%s\t%s\t%s\n", f.oid, marker, description)
+		}
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(repo.Dir(), fetchHeadFile), []byte(buf.String()), 0644); err != nil {
+		return fmt.Errorf("error writing %s: %w", fetchHeadFile, err)
+	}
+
+	return nil
+}
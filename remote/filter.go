@@ -0,0 +1,96 @@
+package remote
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/neocortical/got/config"
+	"github.com/neocortical/got/repository"
+)
+
+const blobLimitPrefix = "blob:limit="
+
+// Filter picks the objects a partial clone leaves out: "blob:none" leaves
+// out every blob, and "blob:limit=<n>" those of at least n bytes, with an
+// optional k, m or g suffix. Objects asked for by ID are never left out.
+type Filter struct {
+	spec      string
+	blobLimit int
+}
+
+// ParseFilter parses a filter spec.
+func ParseFilter(spec string) (*Filter, error) {
+	switch {
+	case spec == "blob:none":
+		return &Filter{spec: spec}, nil
+	case strings.HasPrefix(spec, blobLimitPrefix):
+		limit, err := config.ParseInt(strings.TrimPrefix(spec, blobLimitPrefix))
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("invalid filter-spec '%s'", spec)
+		}
+		return &Filter{spec: spec, blobLimit: limit}, nil
+	}
+
+	return nil, fmt.Errorf("invalid filter-spec '%s'", spec)
+}
+
+func (f *Filter) String() string {
+	return f.spec
+}
+
+// omits reports whether the filter leaves out an object.
+func (f *Filter) omits(objectType string, size int) bool {
+	return objectType == typeBlob && size >= f.blobLimit
+}
+
+// FilterObjects splits objects read from repo into those the filter keeps,
+// and those it leaves out. wants are always kept.
+func FilterObjects(repo *repository.Repo, oids []string, wants []string, f *Filter) (kept []string, omitted []string, err error) {
+	wanted := map[string]bool{}
+	for _, oid := range wants {
+		wanted[oid] = true
+	}
+
+	for _, oid := range oids {
+		if wanted[oid] {
+			kept = append(kept, oid)
+			continue
+		}
+
+		obj, err := repo.Database().Read(oid)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error reading object %s: %w", oid, err)
+		}
+		if f.omits(obj.Type(), len(obj.Serialize())) {
+			omitted = append(omitted, oid)
+		} else {
+			kept = append(kept, oid)
+		}
+	}
+
+	return kept, omitted, nil
+}
+
+// recordPromised records as promisor objects those the objects a filtered
+// fetch stored refer to that were left out.
+func recordPromised(repo *repository.Repo, stored []string) error {
+	var promised []string
+	seen := map[string]bool{}
+	for _, oid := range stored {
+		objectType, links, err := readLinks(repo, oid)
+		if err != nil {
+			return err
+		}
+		if objectType != typeTree {
+			continue
+		}
+		for _, link := range links {
+			if !seen[link.OID] && !repo.Database().Has(link.OID) {
+				seen[link.OID] = true
+				promised = append(promised, link.OID)
+			}
+		}
+	}
+
+	return repo.AddPromisorObjects(promised)
+}
@@ -0,0 +1,87 @@
+package remote
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/neocortical/got/fsck"
+	"github.com/neocortical/got/repository"
+)
+
+func TestParseFilter(t *testing.T) {
+	for spec, expected := range map[string]int{"blob:none": 0, "blob:limit=10": 10, "blob:limit=1k": 1024} {
+		f, err := ParseFilter(spec)
+		if err != nil || f.blobLimit != expected || f.String() != spec {
+			t.Errorf("expected %s to limit blobs to %d but got: %+v (%v)", spec, expected, f, err)
+		}
+	}
+
+	for _, spec := range []string{"", "blob:limit=", "blob:limit=-1", "tree:0", "sparse:oid=HEAD"} {
+		if _, err := ParseFilter(spec); err == nil {
+			t.Errorf("expected an error for '%s'", spec)
+		}
+	}
+}
+
+func TestPartialFetch(t *testing.T) {
+	dir, cleanup := setUpRemotes(t)
+	defer cleanup()
+
+	srcDir := filepath.Join(dir, "src")
+	src := initTestRepo(t, srcDir, false)
+	commitOrDie(t, src, "master", "a small file")
+	head := commitOrDie(t, src, "master", "a file over the limit")
+	small := storeOrDie(t, src, "blob", []byte("a small file"))
+	large := storeOrDie(t, src, "blob", []byte("a file over the limit"))
+
+	for _, uploadPack := range []string{"", "got upload-pack"} {
+		dstDir := filepath.Join(dir, "dst"+uploadPack)
+		initTestRepo(t, dstDir, false)
+		cfg, _ := repository.NewRepo(dstDir).Config()
+		for key, value := range map[string]string{
+			"core.repositoryformatversion":     "1",
+			"extensions.partialclone":          "origin",
+			"remote.origin.url":                srcDir,
+			"remote.origin.fetch":              DefaultFetchRefspec("origin").String(),
+			"remote.origin.uploadpack":         uploadPack,
+			"remote.origin.promisor":           "true",
+			"remote.origin.partialclonefilter": "blob:limit=16",
+		} {
+			if err := cfg.Set(key, value); err != nil {
+				t.Fatalf("error setting %s: %v", key, err)
+			}
+		}
+
+		repo := repository.NewRepo(dstDir)
+		cfg, _ = repo.Config()
+		rem, err := Get(cfg, "origin")
+		if err != nil {
+			t.Fatalf("error reading remote: %v", err)
+		}
+		if _, err = Fetch(repo, rem, dir, FetchOptions{Committer: testAuthor}); err != nil {
+			t.Fatalf("expected no errors but got: %v", err)
+		}
+		if oid, _ := repo.Refs().ReadRef("refs/remotes/origin/master"); oid != head {
+			t.Errorf("expected origin/master to be %s but got: '%s'", head, oid)
+		}
+		if !repo.Database().Has(small) || repo.Database().Has(large) {
+			t.Errorf("expected only the blob over the limit to be left out")
+		}
+		if promised, _ := repo.PromisorObjects(); len(promised) != 1 || !promised[large] {
+			t.Errorf("expected %s to be promised but got: %v", large, promised)
+		}
+
+		report, err := fsck.Check(repo, fsck.Options{})
+		if err != nil || len(report.Missing) > 0 || repo.Database().Has(large) {
+			t.Errorf("expected fsck to skip the promised blob but got: %+v (%v)", report.Missing, err)
+		}
+
+		obj, err := repo.Database().Read(large)
+		if err != nil || string(obj.Serialize()) != "a file over the limit" {
+			t.Fatalf("expected the promised blob to be fetched but got: %v", err)
+		}
+		if !repository.NewRepo(dstDir).Database().Has(large) {
+			t.Error("expected the promised blob to be stored")
+		}
+	}
+}
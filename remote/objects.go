@@ -0,0 +1,149 @@
+package remote
+
+import (
+	"fmt"
+
+	"github.com/neocortical/got/fsck"
+	"github.com/neocortical/got/ref"
+	"github.com/neocortical/got/repository"
+)
+
+const (
+	typeTag  = "tag"
+	typeTree = "tree"
+	typeBlob = "blob"
+)
+
+// readLinks reads an object and returns its type and the objects it refers
+// to.
+func readLinks(repo *repository.Repo, oid string) (string, []fsck.Link, error) {
+	obj, err := repo.Database().Read(oid)
+	if err != nil {
+		return "", nil, fmt.Errorf("error reading object %s: %w", oid, err)
+	}
+
+	links, _ := fsck.CheckObject(repo.ObjectFormat(), obj.Type(), obj.Serialize())
+	return obj.Type(), links, nil
+}
+
+// peelTags follows tags to the object they point at, returning the chain of
+// tags along with it.
+func peelTags(repo *repository.Repo, oid string) (target string, tags []string, err error) {
+	for {
+		objectType, links, err := readLinks(repo, oid)
+		if err != nil {
+			return "", nil, err
+		}
+		if objectType != typeTag {
+			return oid, tags, nil
+		}
+		if len(links) == 0 {
+			return "", nil, fmt.Errorf("bad tag %s", oid)
+		}
+
+		tags = append(tags, oid)
+		oid = links[0].OID
+	}
+}
+
+// MissingObjects walks the objects reachable from tips in src and returns
+// those has reports missing. The walk doesn't go past objects has reports
+// present: a repository that has an object has everything it refers to.
+// Parents of src's shallow commits aren't followed.
+func MissingObjects(src *repository.Repo, tips []string, has func(oid string) bool) ([]string, error) {
+	shallow, err := src.Shallow()
+	if err != nil {
+		return nil, err
+	}
+
+	return walkObjects(src, tips, has, shallow)
+}
+
+// walkObjects is MissingObjects, not following the parents of the shallow
+// commits given.
+func walkObjects(src *repository.Repo, tips []string, has func(oid string) bool, shallow map[string]bool) ([]string, error) {
+	var result []string
+	seen := map[string]bool{}
+	queue := append([]string(nil), tips...)
+	for len(queue) > 0 {
+		oid := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		if seen[oid] {
+			continue
+		}
+		seen[oid] = true
+		if has(oid) {
+			continue
+		}
+		result = append(result, oid)
+
+		objectType, links, err := readLinks(src, oid)
+		if err != nil {
+			return nil, err
+		}
+		for _, link := range links {
+			if objectType == ref.TypeCommit && link.Type == ref.TypeCommit && shallow[oid] {
+				continue
+			}
+			if !seen[link.OID] {
+				queue = append(queue, link.OID)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// CopyObjects stores objects read from one repository in another.
+func CopyObjects(src *repository.Repo, dst *repository.Repo, oids []string) error {
+	for _, oid := range oids {
+		obj, err := src.Database().Read(oid)
+		if err != nil {
+			return fmt.Errorf("error reading object %s: %w", oid, err)
+		}
+		if _, err = dst.Database().Store(obj); err != nil {
+			return fmt.Errorf("error writing object %s: %w", oid, err)
+		}
+	}
+
+	return nil
+}
+
+// IsAncestor reports whether ancestor can be reached from commit through
+// its parents, which makes moving a ref from ancestor to commit a
+// fast-forward.
+func IsAncestor(repo *repository.Repo, ancestor string, commit string) (bool, error) {
+	shallow, err := repo.Shallow()
+	if err != nil {
+		return false, err
+	}
+
+	seen := map[string]bool{commit: true}
+	queue := []string{commit}
+	for len(queue) > 0 {
+		oid := queue[0]
+		queue = queue[1:]
+		if oid == ancestor {
+			return true, nil
+		}
+		if shallow[oid] {
+			continue
+		}
+
+		objectType, links, err := readLinks(repo, oid)
+		if err != nil {
+			return false, err
+		}
+		if objectType != ref.TypeCommit {
+			continue
+		}
+		for _, link := range links {
+			if link.Type == ref.TypeCommit && !seen[link.OID] {
+				seen[link.OID] = true
+				queue = append(queue, link.OID)
+			}
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,406 @@
+package remote
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/neocortical/got/objectformat"
+	"github.com/neocortical/got/ref"
+	"github.com/neocortical/got/refspec"
+	"github.com/neocortical/got/repository"
+)
+
+var testAuthor = ref.Author{Name: "Nathan Smith", Email: "nathan@neocortical.net"}
+
+type testObject struct {
+	objectType string
+	data       []byte
+}
+
+func (o testObject) Type() string {
+	return o.objectType
+}
+
+func (o testObject) Serialize() []byte {
+	return o.data
+}
+
+func storeOrDie(t *testing.T, repo *repository.Repo, objectType string, data []byte) string {
+	oid, err := repo.Database().Store(testObject{objectType: objectType, data: data})
+	if err != nil {
+		t.Fatalf("error storing %s: %v", objectType, err)
+	}
+	return oid
+}
+
+func initTestRepo(t *testing.T, dir string, bare bool) *repository.Repo {
+	var repo *repository.Repo
+	var err error
+	if bare {
+		repo, err = repository.InitBare(dir, objectformat.SHA1)
+	} else {
+		repo, err = repository.Init(dir, objectformat.SHA1)
+	}
+	if err != nil {
+		t.Fatalf("error initializing repository: %v", err)
+	}
+	return repo
+}
+
+// commitOrDie commits a single file onto a branch, returning the commit.
+func commitOrDie(t *testing.T, repo *repository.Repo, branch string, content string) string {
+	return commitAtOrDie(t, repo, branch, content, testAuthor.Time)
+}
+
+func commitAtOrDie(t *testing.T, repo *repository.Repo, branch string, content string, when time.Time) string {
+	name := ref.HeadsPrefix + branch
+	parent, err := repo.Refs().ReadRef(name)
+	if err != nil {
+		t.Fatalf("error reading %s: %v", name, err)
+	}
+
+	blob := storeOrDie(t, repo, "blob", []byte(content))
+	raw, _ := hex.DecodeString(blob)
+	tree := storeOrDie(t, repo, "tree", append([]byte("100644 file.txt\x00"), raw...))
+	author := testAuthor
+	author.Time = when
+	commit, err := repo.Database().Store(ref.NewCommit(parent, tree, author, content))
+	if err != nil {
+		t.Fatalf("error storing commit: %v", err)
+	}
+
+	tx := repo.Refs().Transaction(testAuthor)
+	if err = tx.Update(name, commit, "", "commit"); err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		t.Fatalf("error updating %s: %v", name, err)
+	}
+
+	return commit
+}
+
+func tagOrDie(t *testing.T, repo *repository.Repo, name string, target string) string {
+	data := fmt.Sprintf("object %s\ntype commit\ntag %s\ntagger Nathan Smith <nathan@neocortical.net> 1600000000 +0000\n\n%s\n", target, name, name)
+	oid := storeOrDie(t, repo, "tag", []byte(data))
+
+	tx := repo.Refs().Transaction(testAuthor)
+	err := tx.Create(TagsPrefix+name, oid, "tag")
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		t.Fatalf("error creating tag %s: %v", name, err)
+	}
+
+	return oid
+}
+
+func countObjects(t *testing.T, repo *repository.Repo) int {
+	oids, err := repo.Database().OIDs()
+	if err != nil {
+		t.Fatalf("error listing objects: %v", err)
+	}
+	return len(oids)
+}
+
+// serveInProcess runs the services a connection starts as goroutines
+// talking over pipes, recording their errors.
+func serveInProcess(t *testing.T) (restore func()) {
+	saved := startService
+	startService = func(command string, path string, env []string, stderr io.Writer) (*service, error) {
+		repo, err := OpenRepository(path, "")
+		if err != nil {
+			return nil, err
+		}
+
+		clientR, serverW := io.Pipe()
+		serverR, clientW := io.Pipe()
+		done := make(chan error, 1)
+		go func() {
+			var err error
+			switch command {
+			case "got upload-pack":
+				err = UploadPack(repo, serverR, serverW, UploadPackOptions{})
+			case "got receive-pack":
+				err = ReceivePack(repo, serverR, serverW, ReceivePackOptions{Committer: testAuthor})
+			default:
+				err = fmt.Errorf("unknown service %s", command)
+			}
+			serverW.CloseWithError(err)
+			ioutil.ReadAll(serverR)
+			done <- err
+		}()
+
+		return &service{r: clientR, w: clientW, wait: func() error { return <-done }}, nil
+	}
+
+	return func() { startService = saved }
+}
+
+func setUpRemotes(t *testing.T) (dir string, cleanup func()) {
+	dir, err := ioutil.TempDir("", "got_remote_test_*")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	restore := serveInProcess(t)
+
+	return dir, func() {
+		restore()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestFetchOverProtocol(t *testing.T) {
+	dir, cleanup := setUpRemotes(t)
+	defer cleanup()
+
+	src := initTestRepo(t, filepath.Join(dir, "src"), false)
+	first := commitOrDie(t, src, "master", "one")
+	second := commitOrDie(t, src, "master", "two")
+	tag := tagOrDie(t, src, "v1", first)
+
+	repo := initTestRepo(t, filepath.Join(dir, "dst"), false)
+	rem := &Remote{
+		Name:       "origin",
+		URL:        filepath.Join(dir, "src"),
+		Fetch:      []refspec.Refspec{DefaultFetchRefspec("origin")},
+		UploadPack: "got upload-pack",
+	}
+
+	var progress bytes.Buffer
+	updates, err := Fetch(repo, rem, dir, FetchOptions{Committer: testAuthor, Progress: &progress})
+	if err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	if len(updates) != 2 || updates[0].Dst != "refs/remotes/origin/master" || updates[1].Dst != "refs/tags/v1" {
+		t.Fatalf("unexpected updates: %+v", updates)
+	}
+	if oid, _ := repo.Refs().ReadRef("refs/remotes/origin/master"); oid != second {
+		t.Errorf("expected origin/master to be %s but got: '%s'", second, oid)
+	}
+	if oid, _ := repo.Refs().ReadRef("refs/tags/v1"); oid != tag {
+		t.Errorf("expected v1 to be %s but got: '%s'", tag, oid)
+	}
+	if n := countObjects(t, repo); n != 7 {
+		t.Errorf("expected 7 objects but got %d", n)
+	}
+	if !strings.HasPrefix(progress.String(), "remote: Enumerating objects: 7, done.\n") {
+		t.Errorf("unexpected progress: '%s'", progress.String())
+	}
+
+	// local history the server doesn't know takes a few rounds of
+	// negotiation to get past
+	for i := 0; i < 2*haveBatch; i++ {
+		commitOrDie(t, repo, "topic", fmt.Sprintf("local %d", i))
+	}
+	third := commitOrDie(t, src, "master", "three")
+	before := countObjects(t, repo)
+
+	if _, err = Fetch(repo, rem, dir, FetchOptions{Committer: testAuthor}); err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	if oid, _ := repo.Refs().ReadRef("refs/remotes/origin/master"); oid != third {
+		t.Errorf("expected origin/master to be %s but got: '%s'", third, oid)
+	}
+	// only the new commit, its tree and its blob
+	if n := countObjects(t, repo) - before; n != 3 {
+		t.Errorf("expected 3 new objects but got %d", n)
+	}
+}
+
+func TestPushOverProtocol(t *testing.T) {
+	dir, cleanup := setUpRemotes(t)
+	defer cleanup()
+
+	repo := initTestRepo(t, filepath.Join(dir, "repo"), false)
+	first := commitOrDie(t, repo, "master", "one")
+	second := commitOrDie(t, repo, "master", "two")
+	tag := tagOrDie(t, repo, "v1", first)
+
+	bare := initTestRepo(t, filepath.Join(dir, "bare.git"), true)
+	rem := &Remote{
+		Name:        "origin",
+		URL:         "bare.git",
+		Fetch:       []refspec.Refspec{DefaultFetchRefspec("origin")},
+		ReceivePack: "got receive-pack",
+	}
+	opts := PushOptions{Committer: testAuthor}
+	push := func(specs ...string) []Update {
+		opts.Refspecs = nil
+		for _, s := range specs {
+			spec, err := refspec.ParsePush(s)
+			if err != nil {
+				t.Fatalf("error parsing %s: %v", s, err)
+			}
+			opts.Refspecs = append(opts.Refspecs, spec)
+		}
+		updates, err := Push(repo, rem, dir, opts)
+		if err != nil {
+			t.Fatalf("expected no errors pushing %v but got: %v", specs, err)
+		}
+		return updates
+	}
+
+	updates := push("master", "v1", first+":refs/heads/old")
+	for _, u := range updates {
+		if u.Status != StatusNew {
+			t.Errorf("expected %s to be new but got: %+v", u.Dst, u)
+		}
+	}
+	for name, expected := range map[string]string{"refs/heads/master": second, "refs/tags/v1": tag, "refs/heads/old": first} {
+		if oid, _ := bare.Refs().ReadRef(name); oid != expected {
+			t.Errorf("expected remote %s to be %s but got: '%s'", name, expected, oid)
+		}
+	}
+	if n := countObjects(t, bare); n != 7 {
+		t.Errorf("expected 7 objects pushed but got %d", n)
+	}
+	if oid, _ := repo.Refs().ReadRef("refs/remotes/origin/master"); oid != second {
+		t.Errorf("expected origin/master to be %s but got: '%s'", second, oid)
+	}
+
+	third := commitOrDie(t, repo, "master", "three")
+	updates = push("master", ":refs/heads/missing")
+	if updates[0].Status != StatusFastForward || updates[1].Status != StatusRejected {
+		t.Errorf("unexpected updates: %+v", updates)
+	}
+	if n := countObjects(t, bare); n != 10 {
+		t.Errorf("expected 10 objects after pushing one commit but got %d", n)
+	}
+
+	updates = push(first + ":refs/heads/master")
+	if updates[0].Status != StatusRejected {
+		t.Errorf("expected a non-fast-forward to be rejected but got: %+v", updates[0])
+	}
+	if oid, _ := bare.Refs().ReadRef("refs/heads/master"); oid != third {
+		t.Errorf("expected remote master to stay at %s but got: '%s'", third, oid)
+	}
+
+	updates = push("+"+first+":refs/heads/master", ":old")
+	if updates[0].Status != StatusForced || updates[1].Status != StatusDeleted {
+		t.Errorf("unexpected updates: %+v", updates)
+	}
+	if oid, _ := bare.Refs().ReadRef("refs/heads/master"); oid != first {
+		t.Errorf("expected remote master to be %s but got: '%s'", first, oid)
+	}
+	if oid, _ := bare.Refs().ReadRef("refs/heads/old"); oid != "" {
+		t.Errorf("expected remote old to be deleted but got: '%s'", oid)
+	}
+
+	// a workspace refuses updates to its current branch, even unborn
+	initTestRepo(t, filepath.Join(dir, "work"), false)
+	rem.URL = "work"
+	updates = push("master", "master:other")
+	if updates[0].Status != StatusRemoteRejected || updates[0].Reason != "branch is currently checked out" {
+		t.Errorf("expected the push to be refused but got: %+v", updates[0])
+	}
+	if updates[1].Status != StatusNew {
+		t.Errorf("expected other to be new but got: %+v", updates[1])
+	}
+	work := repository.NewRepo(filepath.Join(dir, "work"))
+	if oid, _ := work.Refs().ReadRef("refs/heads/master"); oid != "" {
+		t.Errorf("expected master to stay unborn but got: '%s'", oid)
+	}
+	if oid, _ := work.Refs().ReadRef("refs/heads/other"); oid != third {
+		t.Errorf("expected other to be %s but got: '%s'", third, oid)
+	}
+}
+
+func writeHook(t *testing.T, repo *repository.Repo, name string, script string) {
+	dir := filepath.Join(repo.Dir(), "hooks")
+	os.MkdirAll(dir, 0755)
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatalf("error writing hook %s: %v", name, err)
+	}
+}
+
+func readFileOrEmpty(repo *repository.Repo, name string) string {
+	data, _ := ioutil.ReadFile(filepath.Join(repo.Dir(), name))
+	return string(data)
+}
+
+func TestPushHooks(t *testing.T) {
+	dir, cleanup := setUpRemotes(t)
+	defer cleanup()
+
+	repo := initTestRepo(t, filepath.Join(dir, "repo"), false)
+	first := commitOrDie(t, repo, "master", "one")
+	second := commitOrDie(t, repo, "master", "two")
+	writeHook(t, repo, "pre-push", `echo "$@" > "$GIT_DIR/pre-push.out"; cat >> "$GIT_DIR/pre-push.out"; test ! -e "$GIT_DIR/refuse"`)
+	zero := repo.ObjectFormat().ZeroOID()
+
+	for _, receivePack := range []string{"", "got receive-pack"} {
+		bareDir := filepath.Join(dir, "bare"+strings.ReplaceAll(receivePack, " ", "-")+".git")
+		bare := initTestRepo(t, bareDir, true)
+		writeHook(t, bare, "pre-receive", `cat > pre-receive.out; echo pre-receive says hi; test ! -e refuse`)
+		writeHook(t, bare, "update", `echo "$@" >> update.out; test "$1" != refs/heads/protected`)
+		writeHook(t, bare, "post-receive", `cat > post-receive.out`)
+
+		var progress bytes.Buffer
+		rem := &Remote{Name: "origin", URL: bareDir, ReceivePack: receivePack}
+		opts := PushOptions{Committer: testAuthor, Progress: &progress}
+		for _, s := range []string{"master", first + ":refs/heads/protected"} {
+			spec, _ := refspec.ParsePush(s)
+			opts.Refspecs = append(opts.Refspecs, spec)
+		}
+
+		// the pre-push hook stops the push, unless it's bypassed
+		ioutil.WriteFile(filepath.Join(repo.Dir(), "refuse"), nil, 0644)
+		if _, err := Push(repo, rem, dir, opts); err == nil || !strings.Contains(err.Error(), "pre-push") {
+			t.Errorf("%s: expected the pre-push hook to stop the push but got: %v", receivePack, err)
+		}
+		if oid, _ := bare.Refs().ReadRef("refs/heads/master"); oid != "" {
+			t.Errorf("%s: expected nothing to be pushed but got master at %s", receivePack, oid)
+		}
+		expected := "origin " + bareDir + "\nrefs/heads/master " + second + " refs/heads/master " + zero + "\n"
+		if out := readFileOrEmpty(repo, "pre-push.out"); !strings.HasPrefix(out, expected) || !strings.Contains(out, " "+first+" refs/heads/protected "+zero+"\n") {
+			t.Errorf("%s: unexpected pre-push input: %q", receivePack, out)
+		}
+
+		// the pre-receive hook refuses the whole push
+		opts.NoVerify = true
+		ioutil.WriteFile(filepath.Join(bareDir, "refuse"), nil, 0644)
+		updates, err := Push(repo, rem, dir, opts)
+		if err != nil {
+			t.Fatalf("%s: expected no errors but got: %v", receivePack, err)
+		}
+		for _, u := range updates {
+			if u.Status != StatusRemoteRejected || u.Reason != "pre-receive hook declined" {
+				t.Errorf("%s: expected %s to be declined but got: %+v", receivePack, u.Dst, u)
+			}
+		}
+		expected = zero + " " + second + " refs/heads/master\n" + zero + " " + first + " refs/heads/protected\n"
+		if out := readFileOrEmpty(bare, "pre-receive.out"); out != expected {
+			t.Errorf("%s: expected pre-receive to read %q but got: %q", receivePack, expected, out)
+		}
+		if !strings.Contains(progress.String(), "pre-receive says hi\n") {
+			t.Errorf("%s: expected the hook's output to be passed on but got: %q", receivePack, progress.String())
+		}
+
+		// and the update hook single updates
+		os.Remove(filepath.Join(bareDir, "refuse"))
+		if updates, err = Push(repo, rem, dir, opts); err != nil {
+			t.Fatalf("%s: expected no errors but got: %v", receivePack, err)
+		}
+		if updates[0].Status != StatusNew || updates[1].Status != StatusRemoteRejected || updates[1].Reason != "hook declined" {
+			t.Errorf("%s: expected only protected to be declined but got: %+v", receivePack, updates)
+		}
+		expected = "refs/heads/master " + zero + " " + second + "\nrefs/heads/protected " + zero + " " + first + "\n"
+		if out := readFileOrEmpty(bare, "update.out"); out != expected {
+			t.Errorf("%s: expected the update hook to run with %q but got: %q", receivePack, expected, out)
+		}
+		expected = zero + " " + second + " refs/heads/master\n"
+		if out := readFileOrEmpty(bare, "post-receive.out"); out != expected {
+			t.Errorf("%s: expected post-receive to read %q but got: %q", receivePack, expected, out)
+		}
+	}
+}
@@ -0,0 +1,353 @@
+package remote
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/neocortical/got/hook"
+	"github.com/neocortical/got/ref"
+	"github.com/neocortical/got/refspec"
+	"github.com/neocortical/got/repository"
+	"github.com/neocortical/got/revision"
+)
+
+// PushOptions control a push.
+type PushOptions struct {
+	// Refspecs replace the remote's push refspecs, and the default of
+	// pushing the current branch to the branch of the same name.
+	Refspecs []refspec.Refspec
+	// Force allows every update, as if each refspec started with '+'.
+	Force bool
+	// Leases force updates only while the remote refs are as expected.
+	Leases    []Lease
+	Committer ref.Author
+	// ReceivePack is the command serving the push, in place of the remote's
+	// receivepack setting. Without either, a repository on the local
+	// filesystem is written directly.
+	ReceivePack string
+	// Progress receives the server's progress messages, and the output of
+	// the pre-push hook.
+	Progress io.Writer
+	// NoVerify skips the pre-push hook.
+	NoVerify bool
+}
+
+// Lease is a --force-with-lease condition: a remote ref may be overwritten
+// as long as it still has the value the pusher last saw.
+type Lease struct {
+	// Ref is the remote ref the lease is for, possibly abbreviated, or empty
+	// for every ref pushed.
+	Ref string
+	// Expected is the value the ref must have, or empty if it mustn't
+	// exist. With UseTracking, it's the value of the ref's remote-tracking
+	// ref instead.
+	Expected    string
+	UseTracking bool
+}
+
+// pushedRef is a ref a push updates on the remote.
+type pushedRef struct {
+	src   string
+	dst   string
+	oid   string
+	force bool
+}
+
+// Push copies local refs to a remote, along with the objects the remote
+// lacks, and updates the remote-tracking refs of what it pushed. Updates that
+// aren't fast-forwards are rejected unless forced or leased; the rest are
+// made whether or not others are rejected. A repository with a workspace
+// refuses updates to the branch it has checked out.
+func Push(repo *repository.Repo, rem *Remote, wd string, opts PushOptions) (_ []Update, err error) {
+	conn, err := openPush(rem, wd, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := conn.close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("error closing connection: %w", closeErr)
+		}
+	}()
+	if conn.objectFormat() != repo.ObjectFormat() {
+		return nil, fmt.Errorf("mismatched object format: the remote uses %s, not %s", conn.objectFormat(), repo.ObjectFormat())
+	}
+
+	refs, err := conn.refs()
+	if err != nil {
+		return nil, err
+	}
+
+	specs := opts.Refspecs
+	if len(specs) == 0 {
+		specs = rem.Push
+	}
+	if len(specs) == 0 {
+		branch, err := repo.Refs().ReadSymbolicRef(headName)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(branch, ref.HeadsPrefix) {
+			return nil, fmt.Errorf("You are not currently on a branch.")
+		}
+		specs = []refspec.Refspec{{Src: branch, Dst: branch}}
+	}
+
+	pushed, err := matchPushRefspecs(repo, refs, specs, opts.Force)
+	if err != nil {
+		return nil, err
+	}
+
+	var updates []Update
+	for _, p := range pushed {
+		u := Update{Src: p.src, Dst: p.dst, NewOID: p.oid}
+		if r, ok := findRef(refs, p.dst); ok {
+			u.OldOID = r.OID
+		}
+
+		force := p.force
+		switch expected, leased, err := leaseFor(repo, rem, opts.Leases, p.dst); {
+		case err != nil:
+			return nil, err
+		case leased && expected != u.OldOID:
+			u.Status, u.Reason = StatusRejected, "stale info"
+		case leased:
+			force = true
+		}
+
+		switch {
+		case u.Status == StatusRejected:
+		case p.oid == "" && u.OldOID == "":
+			u.Status, u.Reason = StatusRejected, "remote ref does not exist"
+		case p.oid == "":
+			u.Status = StatusDeleted
+		default:
+			if u.Status, u.Reason, err = classify(repo, p.dst, u.OldOID, p.oid, force); err != nil {
+				return nil, err
+			}
+		}
+
+		updates = append(updates, u)
+	}
+
+	var commands []command
+	var sent []int
+	for i, u := range updates {
+		if u.OK() && u.Status != StatusUpToDate {
+			commands = append(commands, command{name: u.Dst, oldOID: u.OldOID, newOID: u.NewOID})
+			sent = append(sent, i)
+		}
+	}
+	if len(commands) == 0 {
+		return updates, nil
+	}
+	for i := range commands {
+		if updates[sent[i]].Status == StatusDeleted {
+			commands[i].newOID = ""
+		}
+	}
+
+	if !opts.NoVerify {
+		if err = runPrePush(repo, rem, commands, updates, sent, opts.Progress); err != nil {
+			return nil, err
+		}
+	}
+
+	reasons, err := conn.push(repo, commands)
+	if err != nil {
+		return nil, err
+	}
+	for i, reason := range reasons {
+		u := &updates[sent[i]]
+		if reason != "" {
+			u.Status, u.Reason = StatusRemoteRejected, reason
+			continue
+		}
+		if err = updateTrackingRef(repo, rem, *u, opts.Committer); err != nil {
+			return updates, err
+		}
+	}
+
+	return updates, nil
+}
+
+// runPrePush runs the pre-push hook with the remote's name and URL, and on
+// its input a line for each ref about to be pushed. The hook failing stops
+// the whole push.
+func runPrePush(repo *repository.Repo, rem *Remote, commands []command, updates []Update, sent []int, output io.Writer) error {
+	zero := repo.ObjectFormat().ZeroOID()
+	url := rem.URL
+	if rem.PushURL != "" {
+		url = rem.PushURL
+	}
+	name := rem.Name
+	if name == "" {
+		name = url
+	}
+
+	var stdin bytes.Buffer
+	for i, cmd := range commands {
+		src, newOID, oldOID := updates[sent[i]].Src, cmd.newOID, cmd.oldOID
+		if newOID == "" {
+			src, newOID = "(delete)", zero
+		}
+		if oldOID == "" {
+			oldOID = zero
+		}
+		fmt.Fprintf(&stdin, "%s %s %s %s\n", src, newOID, cmd.name, oldOID)
+	}
+
+	return hook.Run(repo, hook.PrePush, hook.Options{Args: []string{name, url}, Stdin: &stdin, Output: output})
+}
+
+// openPush connects to the repository a push updates.
+func openPush(rem *Remote, wd string, opts PushOptions) (pushConnection, error) {
+	url := rem.URL
+	if rem.PushURL != "" {
+		url = rem.PushURL
+	}
+
+	command := opts.ReceivePack
+	if command == "" {
+		command = rem.ReceivePack
+	}
+	if command != "" {
+		return openReceivePack(command, url, wd, opts.Progress)
+	}
+
+	conn, err := openLocal(url, wd, opts.Committer)
+	if err != nil {
+		return nil, err
+	}
+	conn.progress = opts.Progress
+
+	return conn, nil
+}
+
+// matchPushRefspecs finds the local refs or revisions the refspecs push, and
+// the remote refs they go to.
+func matchPushRefspecs(repo *repository.Repo, remoteRefs []advertisedRef, specs []refspec.Refspec, force bool) ([]pushedRef, error) {
+	var result []pushedRef
+
+	for _, spec := range specs {
+		if spec.IsPattern() {
+			refs, err := repo.Refs().List("refs/")
+			if err != nil {
+				return nil, fmt.Errorf("error listing refs: %w", err)
+			}
+			for _, r := range refs {
+				if name, ok := spec.Map(r.Name); ok {
+					result = append(result, pushedRef{src: r.Name, dst: name, oid: r.OID, force: spec.Force || force})
+				}
+			}
+			continue
+		}
+
+		var src, oid string
+		if !spec.IsDelete() {
+			var err error
+			if oid, err = revision.Resolve(repo, spec.Src); err != nil {
+				return nil, fmt.Errorf("src refspec %s does not match any", spec.Src)
+			}
+			src = revision.ExpandRef(repo, spec.Src)
+			if src == headName {
+				src, _ = repo.Refs().ReadSymbolicRef(headName)
+			}
+		}
+
+		name, err := expandRemoteRef(remoteRefs, spec.Dst, src)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, pushedRef{src: src, dst: name, oid: oid, force: spec.Force || force})
+	}
+
+	return result, nil
+}
+
+// expandRemoteRef turns a destination into the full name of a remote ref:
+// an existing one it abbreviates, or else one of the same kind as the
+// source.
+func expandRemoteRef(refs []advertisedRef, name string, src string) (string, error) {
+	if strings.HasPrefix(name, "refs/") {
+		return name, nil
+	}
+	if existing := expandRef(refs, name); strings.HasPrefix(existing, "refs/") {
+		return existing, nil
+	}
+
+	for _, prefix := range []string{ref.HeadsPrefix, TagsPrefix} {
+		if strings.HasPrefix(src, prefix) {
+			return prefix + name, nil
+		}
+	}
+
+	return "", fmt.Errorf("The destination you provided is not a full refname (i.e., starting with \"refs/\"): '%s'", name)
+}
+
+// leaseFor returns the value a lease expects a remote ref to have, if any
+// lease covers it.
+func leaseFor(repo *repository.Repo, rem *Remote, leases []Lease, name string) (expected string, leased bool, err error) {
+	for _, lease := range leases {
+		if lease.Ref != "" && lease.Ref != name && ref.HeadsPrefix+lease.Ref != name && TagsPrefix+lease.Ref != name {
+			continue
+		}
+
+		if !lease.UseTracking {
+			return lease.Expected, true, nil
+		}
+
+		// without a remote-tracking ref, the pusher has never seen the ref
+		tracking := trackingRef(rem, name)
+		if tracking == "" {
+			return "", true, nil
+		}
+		expected, err = repo.Refs().ReadRef(tracking)
+		return expected, true, err
+	}
+
+	return "", false, nil
+}
+
+// trackingRef returns the local ref a remote's fetch refspecs store a remote
+// ref in, if any.
+func trackingRef(rem *Remote, name string) string {
+	for _, spec := range rem.Fetch {
+		if dst, ok := spec.Map(name); ok && dst != "" {
+			return dst
+		}
+	}
+
+	return ""
+}
+
+// updateTrackingRef brings the remote-tracking ref of a pushed ref up to date,
+// as if it had been fetched.
+func updateTrackingRef(repo *repository.Repo, rem *Remote, u Update, committer ref.Author) error {
+	tracking := trackingRef(rem, u.Dst)
+	if tracking == "" {
+		return nil
+	}
+
+	current, err := repo.Refs().ReadRef(tracking)
+	if err != nil || (u.Status == StatusDeleted && current == "") {
+		return err
+	}
+
+	t := repo.Refs().Transaction(committer)
+	if u.Status == StatusDeleted {
+		err = t.Add(ref.RefUpdate{Name: tracking, NewOID: ref.ZeroOID, NoDeref: true})
+	} else {
+		err = t.Update(tracking, u.NewOID, "", "update by push")
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = t.Commit(); err != nil {
+		return fmt.Errorf("error updating %s: %w", tracking, err)
+	}
+
+	return nil
+}
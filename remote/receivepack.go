@@ -0,0 +1,276 @@
+package remote
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/neocortical/got/hook"
+	"github.com/neocortical/got/object"
+	"github.com/neocortical/got/objectformat"
+	"github.com/neocortical/got/pktline"
+	"github.com/neocortical/got/ref"
+	"github.com/neocortical/got/repository"
+)
+
+// ReceivePackOptions are the options of ReceivePack.
+type ReceivePackOptions struct {
+	// Committer is recorded in the reflogs of the refs a push updates.
+	Committer ref.Author
+	// AdvertiseRefs stops after advertising refs, as the first request of a
+	// push over HTTP does.
+	AdvertiseRefs bool
+	// StatelessRPC reads commands without advertising refs first, as the
+	// second request of a push over HTTP does.
+	StatelessRPC bool
+	// Stderr receives the output of the receive hooks when the client
+	// can't be sent it on a side band.
+	Stderr io.Writer
+}
+
+// ReceivePack serves a push into repo, reading from r and writing to w, as
+// git receive-pack does. Pushes use protocol version 0 whatever version the
+// client asks for, as git's do.
+func ReceivePack(repo *repository.Repo, r io.Reader, w io.Writer, opts ReceivePackOptions) error {
+	pr, pw := pktline.NewReader(r), pktline.NewWriter(w)
+	format := repo.ObjectFormat()
+
+	if !opts.StatelessRPC {
+		if err := advertiseReceivePack(repo, pw); err != nil {
+			return err
+		}
+	}
+	if opts.AdvertiseRefs {
+		return nil
+	}
+
+	commands, requested, err := readCommands(pr, format)
+	if err != nil || len(commands) == 0 {
+		return err
+	}
+
+	var unpackErr error
+	for _, cmd := range commands {
+		if cmd.newOID != "" {
+			_, unpackErr = object.UnpackObjects(r, format, repo.Database())
+			break
+		}
+	}
+
+	hookOutput := opts.Stderr
+	if requested["side-band-64k"] {
+		hookOutput = pktline.NewSidebandWriter(pw, pktline.BandProgress)
+	}
+
+	reasons := make([]string, len(commands))
+	if unpackErr != nil {
+		for i := range reasons {
+			reasons[i] = "unpacker error"
+		}
+	} else if reasons, err = receiveCommands(repo, commands, opts.Committer, hookOutput); err != nil {
+		return err
+	}
+
+	if !requested["report-status"] {
+		return unpackErr
+	}
+
+	var report bytes.Buffer
+	rw := pktline.NewWriter(&report)
+	if unpackErr != nil {
+		rw.WriteString("unpack %v", unpackErr)
+	} else {
+		rw.WriteString("unpack ok")
+	}
+	for i, cmd := range commands {
+		if reasons[i] == "" {
+			rw.WriteString("ok %s", cmd.name)
+		} else {
+			rw.WriteString("ng %s %s", cmd.name, reasons[i])
+		}
+	}
+	rw.Flush()
+
+	if !requested["side-band-64k"] {
+		_, err = w.Write(report.Bytes())
+		return err
+	}
+	if _, err = pktline.NewSidebandWriter(pw, pktline.BandData).Write(report.Bytes()); err != nil {
+		return err
+	}
+
+	return pw.Flush()
+}
+
+// advertiseReceivePack lists the refs a push can update, with the
+// capabilities of ReceivePack.
+func advertiseReceivePack(repo *repository.Repo, pw *pktline.Writer) error {
+	format := repo.ObjectFormat()
+
+	refs, err := listRefs(repo, false)
+	if err != nil {
+		pw.Error(err.Error())
+		return err
+	}
+	capabilities := fmt.Sprintf("report-status delete-refs side-band-64k quiet ofs-delta object-format=%s agent=%s", format, agent)
+	if len(refs) == 0 {
+		err = pw.WriteString("%s capabilities^{}\x00%s", format.ZeroOID(), capabilities)
+	}
+	for i, r := range refs {
+		if err != nil {
+			break
+		}
+		if i == 0 {
+			err = pw.WriteString("%s %s\x00%s", r.OID, r.Name, capabilities)
+		} else {
+			err = pw.WriteString("%s %s", r.OID, r.Name)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	return pw.Flush()
+}
+
+// readCommands reads the ref updates a push asks for, and the capabilities
+// the client asks for with the first.
+func readCommands(pr *pktline.Reader, format *objectformat.Format) ([]command, map[string]bool, error) {
+	var commands []command
+	requested := map[string]bool{}
+
+	for {
+		line, kind, err := pr.ReadLine()
+		if err == io.EOF && len(commands) == 0 {
+			// the client had nothing to push
+			return nil, nil, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if kind == pktline.Flush {
+			return commands, requested, nil
+		}
+
+		if nul := strings.IndexByte(line, 0); nul != -1 {
+			for _, capability := range strings.Fields(line[nul+1:]) {
+				requested[capability] = true
+			}
+			line = line[:nul]
+		}
+		if strings.HasPrefix(line, "shallow ") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 3 || !format.IsOID(fields[0]) || !format.IsOID(fields[1]) {
+			return nil, nil, fmt.Errorf("protocol error: expected old/new/ref, got '%s'", line)
+		}
+		cmd := command{name: fields[2], oldOID: fields[0], newOID: fields[1]}
+		if cmd.oldOID == format.ZeroOID() {
+			cmd.oldOID = ""
+		}
+		if cmd.newOID == format.ZeroOID() {
+			cmd.newOID = ""
+		}
+		commands = append(commands, cmd)
+	}
+}
+
+// receiveCommands makes the ref updates of a push, each only if the ref
+// still has the value the pusher saw. A repository with a workspace refuses
+// to move or delete the branch it has checked out. The pre-receive hook can
+// refuse the whole push and the update hook each update, and post-receive
+// hears about the updates made; hooks write their output to output. It
+// returns for each command the reason it was refused, or "".
+func receiveCommands(repo *repository.Repo, commands []command, committer ref.Author, output io.Writer) ([]string, error) {
+	head := ""
+	if !repo.Bare() {
+		var err error
+		if head, err = repo.Refs().ReadSymbolicRef(headName); err != nil {
+			return nil, fmt.Errorf("error reading HEAD: %w", err)
+		}
+	}
+
+	reasons := make([]string, len(commands))
+	opts := hook.Options{Stdin: commandLines(repo, commands, reasons), Output: output}
+	if err := hook.Run(repo, hook.PreReceive, opts); err != nil {
+		for i := range reasons {
+			reasons[i] = "pre-receive hook declined"
+		}
+		return reasons, nil
+	}
+
+	zero := repo.ObjectFormat().ZeroOID()
+	for i, cmd := range commands {
+		oldOID, newOID := cmd.oldOID, cmd.newOID
+		if oldOID == "" {
+			oldOID = zero
+		}
+		if newOID == "" {
+			newOID = zero
+		}
+
+		switch {
+		case !strings.HasPrefix(cmd.name, "refs/") || !ref.ValidName(cmd.name):
+			reasons[i] = "funny refname"
+		case cmd.name == head && cmd.newOID == "":
+			reasons[i] = "deletion of the current branch prohibited"
+		case cmd.name == head:
+			reasons[i] = "branch is currently checked out"
+		case cmd.newOID != "" && !repo.Database().Has(cmd.newOID):
+			reasons[i] = "missing necessary objects"
+		case hook.Run(repo, hook.Update, hook.Options{Args: []string{cmd.name, oldOID, newOID}, Output: output}) != nil:
+			reasons[i] = "hook declined"
+		}
+		if reasons[i] != "" {
+			continue
+		}
+
+		if oldOID == zero {
+			oldOID = ref.ZeroOID
+		}
+		if newOID == zero {
+			newOID = ref.ZeroOID
+		}
+		t := repo.Refs().Transaction(committer)
+		err := t.Update(cmd.name, newOID, oldOID, "push")
+		if err == nil {
+			err = t.Commit()
+		}
+		if err != nil {
+			reasons[i] = "failed to update ref"
+		}
+	}
+
+	// post-receive can't undo anything, so how it exits doesn't matter
+	opts.Stdin = commandLines(repo, commands, reasons)
+	hook.Run(repo, hook.PostReceive, opts)
+
+	return reasons, nil
+}
+
+// commandLines is what the pre-receive and post-receive hooks read: a line
+// for each command that hasn't been refused, with its old and new values and
+// ref name.
+func commandLines(repo *repository.Repo, commands []command, reasons []string) io.Reader {
+	zero := repo.ObjectFormat().ZeroOID()
+
+	var buf bytes.Buffer
+	for i, cmd := range commands {
+		if reasons[i] != "" {
+			continue
+		}
+		oldOID, newOID := cmd.oldOID, cmd.newOID
+		if oldOID == "" {
+			oldOID = zero
+		}
+		if newOID == "" {
+			newOID = zero
+		}
+		fmt.Fprintf(&buf, "%s %s %s\n", oldOID, newOID, cmd.name)
+	}
+
+	return &buf
+}
@@ -0,0 +1,381 @@
+// Package remote manages the remote repositories named in a repository's
+// config, and fetches from and pushes to them: directly, for repositories on
+// the local filesystem, or through git's upload-pack and receive-pack
+// protocols, which it also serves.
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/neocortical/got/config"
+	"github.com/neocortical/got/ref"
+	"github.com/neocortical/got/refspec"
+	"github.com/neocortical/got/repository"
+)
+
+const (
+	// RemotesPrefix is where remote-tracking refs live.
+	RemotesPrefix = "refs/remotes/"
+	// TagsPrefix is where tags live.
+	TagsPrefix = "refs/tags/"
+
+	fileScheme = "file://"
+)
+
+// ErrNoSuchRemote is returned for remotes that aren't in the config.
+var ErrNoSuchRemote = errors.New("no such remote")
+
+func init() {
+	// partial clones need remotes to fetch what they left out
+	repository.SetPromisorFetcher(fetchPromised)
+}
+
+// Remote is a repository named in the config.
+type Remote struct {
+	Name string
+	URL  string
+	// PushURL is where pushes go, if not to URL.
+	PushURL string
+	// Fetch says which remote refs a fetch updates, and which local refs it
+	// stores them in.
+	Fetch []refspec.Refspec
+	// Push says which refs a push without refspecs updates.
+	Push []refspec.Refspec
+	// UploadPack and ReceivePack are the commands serving fetches and
+	// pushes, if the repository isn't to be read and written directly.
+	UploadPack  string
+	ReceivePack string
+	// Promisor marks the remote a partial clone was made from, which
+	// provides the objects it left out, and PartialCloneFilter is the filter
+	// it fetches with.
+	Promisor           bool
+	PartialCloneFilter string
+}
+
+// Get reads a remote from the config.
+func Get(cfg *config.Config, name string) (*Remote, error) {
+	url, exists := cfg.Get("remote." + name + ".url")
+	if !exists {
+		return nil, fmt.Errorf("%w: '%s'", ErrNoSuchRemote, name)
+	}
+
+	result := &Remote{Name: name, URL: url}
+	result.PushURL, _ = cfg.Get("remote." + name + ".pushurl")
+	result.UploadPack, _ = cfg.Get("remote." + name + ".uploadpack")
+	result.ReceivePack, _ = cfg.Get("remote." + name + ".receivepack")
+	result.PartialCloneFilter, _ = cfg.Get("remote." + name + ".partialclonefilter")
+
+	var err error
+	if result.Promisor, err = cfg.GetBool("remote."+name+".promisor", false); err != nil {
+		return nil, fmt.Errorf("bad remote.%s.promisor: %w", name, err)
+	}
+
+	for _, value := range cfg.GetAll("remote." + name + ".fetch") {
+		spec, err := refspec.ParseFetch(value)
+		if err != nil {
+			return nil, fmt.Errorf("bad remote.%s.fetch: %w", name, err)
+		}
+		result.Fetch = append(result.Fetch, spec)
+	}
+	for _, value := range cfg.GetAll("remote." + name + ".push") {
+		spec, err := refspec.ParsePush(value)
+		if err != nil {
+			return nil, fmt.Errorf("bad remote.%s.push: %w", name, err)
+		}
+		result.Push = append(result.Push, spec)
+	}
+
+	return result, nil
+}
+
+// List returns the names of the remotes in the config.
+func List(cfg *config.Config) (result []string) {
+	for _, name := range cfg.Subsections("remote") {
+		if _, exists := cfg.Get("remote." + name + ".url"); exists {
+			result = append(result, name)
+		}
+	}
+
+	return
+}
+
+// ValidName reports whether name can name a remote: it must make valid
+// remote-tracking ref names.
+func ValidName(name string) bool {
+	return name != "" && ref.ValidName(RemotesPrefix+name+"/HEAD")
+}
+
+// DefaultFetchRefspec returns the refspec a new remote fetches with: every
+// branch, into remote-tracking refs named after the remote.
+func DefaultFetchRefspec(name string) refspec.Refspec {
+	return refspec.Refspec{Src: ref.HeadsPrefix + "*", Dst: RemotesPrefix + name + "/*", Force: true}
+}
+
+// Add adds a remote to the config, fetching every branch.
+func Add(cfg *config.Config, name string, url string) error {
+	if !ValidName(name) {
+		return fmt.Errorf("'%s' is not a valid remote name", name)
+	}
+	if _, err := Get(cfg, name); err == nil {
+		return fmt.Errorf("remote %s already exists.", name)
+	}
+
+	if err := cfg.Set("remote."+name+".url", url); err != nil {
+		return err
+	}
+
+	return cfg.Add("remote."+name+".fetch", DefaultFetchRefspec(name).String())
+}
+
+// Remove deletes a remote from the config, along with its remote-tracking
+// refs and the branch settings that follow it.
+func Remove(repo *repository.Repo, name string, committer ref.Author) error {
+	cfg, err := repo.Config()
+	if err != nil {
+		return err
+	}
+	rem, err := Get(cfg, name)
+	if err != nil {
+		return err
+	}
+
+	if err = forgetBranches(cfg, name, ""); err != nil {
+		return err
+	}
+	if err = cfg.RemoveSection("remote." + name); err != nil {
+		return err
+	}
+
+	refs, err := repo.Refs().List(RemotesPrefix)
+	if err != nil {
+		return fmt.Errorf("error listing refs: %w", err)
+	}
+
+	// leave alone any refs another remote also stores into
+	others := map[string]*Remote{}
+	for _, other := range List(cfg) {
+		if others[other], err = Get(cfg, other); err != nil {
+			return err
+		}
+	}
+
+	t := repo.Refs().Transaction(committer)
+	for _, r := range refs {
+		if r.Target != "" || !isTracking(rem, r.Name) {
+			continue
+		}
+		shared := false
+		for _, other := range others {
+			shared = shared || isTracking(other, r.Name)
+		}
+		if shared {
+			continue
+		}
+
+		if err = t.Add(ref.RefUpdate{Name: r.Name, NewOID: ref.ZeroOID, NoDeref: true}); err != nil {
+			t.Abort()
+			return err
+		}
+	}
+	if err = removeSymbolicTracking(repo, rem); err != nil {
+		t.Abort()
+		return err
+	}
+
+	return t.Commit()
+}
+
+// removeSymbolicTracking deletes the remote's HEAD, the symbolic ref naming
+// its default branch.
+func removeSymbolicTracking(repo *repository.Repo, rem *Remote) error {
+	name := RemotesPrefix + rem.Name + "/HEAD"
+	target, err := repo.Refs().ReadSymbolicRef(name)
+	if err != nil || target == "" {
+		return err
+	}
+
+	if err = os.Remove(filepath.Join(repo.Dir(), filepath.FromSlash(name))); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error deleting %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// isTracking reports whether a remote's fetch refspecs store into a ref.
+func isTracking(rem *Remote, name string) bool {
+	for _, spec := range rem.Fetch {
+		if spec.Dst != "" && spec.Reverse().Match(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// forgetBranches updates branch.<name>.remote settings naming a remote: to
+// newName, or if that's empty by unsetting them with their merge settings.
+func forgetBranches(cfg *config.Config, name string, newName string) error {
+	for _, branch := range cfg.Subsections("branch") {
+		key := "branch." + branch
+		if value, _ := cfg.Get(key + ".remote"); value != name {
+			continue
+		}
+
+		if newName != "" {
+			if err := cfg.Set(key+".remote", newName); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := cfg.Unset(key + ".remote"); err != nil {
+			return err
+		}
+		if err := cfg.Unset(key + ".merge"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Rename renames a remote, moving its remote-tracking refs and updating its
+// default fetch refspec and the branches that follow it.
+func Rename(repo *repository.Repo, oldName string, newName string, committer ref.Author) error {
+	cfg, err := repo.Config()
+	if err != nil {
+		return err
+	}
+	if !ValidName(newName) {
+		return fmt.Errorf("'%s' is not a valid remote name", newName)
+	}
+	rem, err := Get(cfg, oldName)
+	if err != nil {
+		return err
+	}
+	if _, err = Get(cfg, newName); err == nil {
+		return fmt.Errorf("remote %s already exists.", newName)
+	}
+
+	if err = cfg.RenameSection("remote."+oldName, "remote."+newName); err != nil {
+		return err
+	}
+
+	oldPrefix, newPrefix := RemotesPrefix+oldName+"/", RemotesPrefix+newName+"/"
+	var fetch []string
+	renamed := false
+	for _, spec := range rem.Fetch {
+		if strings.HasPrefix(spec.Dst, oldPrefix) {
+			spec.Dst = newPrefix + strings.TrimPrefix(spec.Dst, oldPrefix)
+			renamed = true
+		}
+		fetch = append(fetch, spec.String())
+	}
+	if renamed {
+		if err = cfg.Unset("remote." + newName + ".fetch"); err != nil {
+			return err
+		}
+		for _, spec := range fetch {
+			if err = cfg.Add("remote."+newName+".fetch", spec); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err = forgetBranches(cfg, oldName, newName); err != nil {
+		return err
+	}
+
+	return renameTrackingRefs(repo, oldPrefix, newPrefix, committer)
+}
+
+func renameTrackingRefs(repo *repository.Repo, oldPrefix string, newPrefix string, committer ref.Author) error {
+	refs, err := repo.Refs().List(oldPrefix)
+	if err != nil {
+		return fmt.Errorf("error listing refs: %w", err)
+	}
+
+	headName := oldPrefix + "HEAD"
+	headTarget, err := repo.Refs().ReadSymbolicRef(headName)
+	if err != nil {
+		return err
+	}
+	if headTarget != "" {
+		if err = os.Remove(filepath.Join(repo.Dir(), filepath.FromSlash(headName))); err != nil {
+			return fmt.Errorf("error deleting %s: %w", headName, err)
+		}
+	}
+
+	message := fmt.Sprintf("remote: renamed %s to %s", oldPrefix, newPrefix)
+	t := repo.Refs().Transaction(committer)
+	for _, r := range refs {
+		if r.Target != "" {
+			continue
+		}
+		newRef := newPrefix + strings.TrimPrefix(r.Name, oldPrefix)
+		if err = t.Add(ref.RefUpdate{Name: r.Name, NewOID: ref.ZeroOID, OldOID: r.OID, NoDeref: true}); err == nil {
+			err = t.Create(newRef, r.OID, message)
+		}
+		if err != nil {
+			t.Abort()
+			return err
+		}
+	}
+	if err = t.Commit(); err != nil {
+		return err
+	}
+
+	if strings.HasPrefix(headTarget, oldPrefix) {
+		headTarget = newPrefix + strings.TrimPrefix(headTarget, oldPrefix)
+		return repo.Refs().WriteSymbolicRef(newPrefix+"HEAD", headTarget, committer, "")
+	}
+
+	return nil
+}
+
+// FindGitDir returns the git directory of the repository at p, which may be
+// a workspace or a git directory itself.
+func FindGitDir(p string) (string, error) {
+	for _, dir := range []string{filepath.Join(p, repository.GitDir), p} {
+		if _, err := os.Stat(filepath.Join(dir, "HEAD")); err != nil {
+			continue
+		}
+		if stat, err := os.Stat(filepath.Join(dir, "objects")); err == nil && stat.IsDir() {
+			return dir, nil
+		}
+	}
+
+	return "", fmt.Errorf("'%s' does not appear to be a git repository", p)
+}
+
+// LocalPath returns the path a URL names on the local filesystem: the URL
+// itself, relative to wd if it isn't absolute, or the path of a file:// URL.
+func LocalPath(url string, wd string) string {
+	p := strings.TrimPrefix(url, fileScheme)
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(wd, p)
+	}
+
+	return p
+}
+
+// OpenRepository opens the repository a URL names.
+func OpenRepository(url string, wd string) (*repository.Repo, error) {
+	p := LocalPath(url, wd)
+	gitDir, err := FindGitDir(p)
+	if err != nil {
+		return nil, err
+	}
+	repo := repository.NewBareRepo(gitDir)
+	if gitDir != p {
+		repo = repository.NewRepo(p)
+	}
+	if err = repo.CheckFormat(); err != nil {
+		return nil, err
+	}
+
+	return repo, nil
+}
@@ -0,0 +1,172 @@
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/neocortical/got/ref"
+	"github.com/neocortical/got/repository"
+)
+
+// ShallowOptions limit the history a shallow clone or fetch copies.
+type ShallowOptions struct {
+	// Depth is how many commits of history to copy from each tip.
+	Depth int
+	// Since leaves out commits made before it.
+	Since time.Time
+}
+
+// Limited reports whether the options leave out any history.
+func (o ShallowOptions) Limited() bool {
+	return o.Depth > 0 || !o.Since.IsZero()
+}
+
+// HistoryObjects walks the objects reachable from tips within the limits of
+// opts and returns those has reports missing, along with the boundary of the
+// history walked: the commits whose parents were left out. Parents of repo's
+// own shallow commits aren't followed either, and those commits are on the
+// boundary too.
+func HistoryObjects(repo *repository.Repo, tips []string, opts ShallowOptions, has func(oid string) bool) (objects []string, boundary []string, err error) {
+	marks, boundary, err := shallowCommits(repo, tips, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	objects, err = MissingObjects(repo, tips, func(oid string) bool {
+		return has(oid) || marks[oid] == commitExcluded
+	})
+	return objects, boundary, err
+}
+
+// commits a shallow walk reaches are marked as in or out of its history
+const (
+	commitIncluded = iota + 1
+	commitExcluded
+)
+
+// shallowCommits walks the commit history of tips a generation at a time,
+// so that each commit is reached at its smallest depth, marking which
+// commits are in the history and which are left out. It returns the marks
+// and the boundary.
+func shallowCommits(repo *repository.Repo, tips []string, opts ShallowOptions) (map[string]int, []string, error) {
+	shallow, err := repo.Shallow()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	marks := map[string]int{}
+	parents := map[string][]string{}
+	var commits []string
+	for _, tip := range tips {
+		target, _, err := peelTags(repo, tip)
+		if err != nil {
+			return nil, nil, err
+		}
+		if objectType, _, err := readLinks(repo, target); err != nil || objectType != ref.TypeCommit || marks[target] != 0 {
+			continue
+		}
+		if ok, err := madeSince(repo, target, opts.Since); err != nil {
+			return nil, nil, err
+		} else if !ok {
+			return nil, nil, errors.New("no commits selected for shallow requests")
+		}
+		marks[target] = commitIncluded
+		commits = append(commits, target)
+	}
+
+	var candidates []string
+	for generation := 1; len(commits) > 0; generation++ {
+		var next []string
+		for _, oid := range commits {
+			_, links, err := readLinks(repo, oid)
+			if err != nil {
+				return nil, nil, err
+			}
+			for _, link := range links {
+				if link.Type == ref.TypeCommit {
+					parents[oid] = append(parents[oid], link.OID)
+				}
+			}
+			if len(parents[oid]) == 0 {
+				continue
+			}
+			if shallow[oid] || generation == opts.Depth {
+				for _, parent := range parents[oid] {
+					if marks[parent] == 0 {
+						marks[parent] = commitExcluded
+					}
+				}
+				candidates = append(candidates, oid)
+				continue
+			}
+
+			for _, parent := range parents[oid] {
+				if marks[parent] != 0 {
+					continue
+				}
+				ok, err := madeSince(repo, parent, opts.Since)
+				if err != nil {
+					return nil, nil, err
+				}
+				if !ok {
+					marks[parent] = commitExcluded
+					candidates = append(candidates, oid)
+					continue
+				}
+				marks[parent] = commitIncluded
+				next = append(next, parent)
+			}
+		}
+		commits = next
+	}
+
+	// a parent left out along one line of history may be in along another
+	var boundary []string
+	for _, oid := range candidates {
+		for _, parent := range parents[oid] {
+			if marks[parent] != commitIncluded || shallow[oid] {
+				boundary = append(boundary, oid)
+				break
+			}
+		}
+	}
+
+	return marks, boundary, nil
+}
+
+// madeSince reports whether a commit was made at or after since, which is
+// true of every commit if since is zero.
+func madeSince(repo *repository.Repo, oid string, since time.Time) (bool, error) {
+	if since.IsZero() {
+		return true, nil
+	}
+
+	t, err := commitTime(repo, oid)
+	if err != nil {
+		return false, err
+	}
+
+	return !t.Before(since), nil
+}
+
+// commitTime returns when a commit was made, from its committer header.
+func commitTime(repo *repository.Repo, oid string) (time.Time, error) {
+	obj, err := repo.Database().Read(oid)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error reading commit %s: %w", oid, err)
+	}
+
+	for _, line := range strings.Split(string(obj.Serialize()), "\n") {
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(line, "committer ") {
+			committer, err := ref.ParseAuthor(strings.TrimPrefix(line, "committer "))
+			return committer.Time, err
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("commit %s has no committer", oid)
+}
@@ -0,0 +1,168 @@
+package remote
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/neocortical/got/pktline"
+	"github.com/neocortical/got/refspec"
+)
+
+// fetchRaw sends upload-pack a single fetch request, as a stateless client
+// does, and returns the lines it answers with up to the pack.
+func fetchRaw(t *testing.T, dir string, args ...string) []string {
+	repo, err := OpenRepository(dir, "")
+	if err != nil {
+		t.Fatalf("error opening repository: %v", err)
+	}
+
+	var req, resp bytes.Buffer
+	pw := pktline.NewWriter(&req)
+	pw.WriteString("command=fetch")
+	pw.Delim()
+	for _, arg := range append(args, "no-progress", "done") {
+		pw.WriteString(arg)
+	}
+	pw.Flush()
+
+	if err = UploadPack(repo, &req, &resp, UploadPackOptions{StatelessRPC: true}); err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+
+	var lines []string
+	pr := pktline.NewReader(&resp)
+	for {
+		line, kind, err := pr.ReadLine()
+		if err != nil {
+			t.Fatalf("error reading response: %v", err)
+		}
+		if kind == pktline.Delim {
+			line = "<delim>"
+		}
+		if line == "packfile" {
+			return lines
+		}
+		lines = append(lines, line)
+	}
+}
+
+func TestHistoryObjects(t *testing.T) {
+	dir, cleanup := setUpRemotes(t)
+	defer cleanup()
+
+	repo := initTestRepo(t, filepath.Join(dir, "repo"), false)
+	day := time.Unix(1600000000, 0)
+	var commits []string
+	for i, content := range []string{"one", "two", "three", "four"} {
+		commits = append(commits, commitAtOrDie(t, repo, "master", content, day.AddDate(0, 0, i)))
+	}
+	tip := []string{commits[3]}
+	none := func(string) bool { return false }
+
+	objects, boundary, err := HistoryObjects(repo, tip, ShallowOptions{Depth: 2}, none)
+	if err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	// two commits, with a tree and a blob each
+	if len(objects) != 6 || !reflect.DeepEqual(boundary, []string{commits[2]}) {
+		t.Errorf("expected 6 objects and %s on the boundary but got %d and %v", commits[2], len(objects), boundary)
+	}
+
+	objects, boundary, err = HistoryObjects(repo, tip, ShallowOptions{Since: day.AddDate(0, 0, 1)}, none)
+	if err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	if len(objects) != 9 || !reflect.DeepEqual(boundary, []string{commits[1]}) {
+		t.Errorf("expected 9 objects and %s on the boundary but got %d and %v", commits[1], len(objects), boundary)
+	}
+
+	objects, boundary, err = HistoryObjects(repo, tip, ShallowOptions{}, none)
+	if err != nil || len(objects) != 12 || len(boundary) != 0 {
+		t.Errorf("expected the whole history but got %d objects and %v (%v)", len(objects), boundary, err)
+	}
+
+	if _, _, err = HistoryObjects(repo, tip, ShallowOptions{Since: day.AddDate(0, 0, 7)}, none); err == nil {
+		t.Error("expected an error for a tip older than --shallow-since")
+	}
+}
+
+func TestShallowFetch(t *testing.T) {
+	dir, cleanup := setUpRemotes(t)
+	defer cleanup()
+
+	srcDir := filepath.Join(dir, "src")
+	src := initTestRepo(t, srcDir, false)
+	var commits []string
+	for _, content := range []string{"one", "two", "three", "four"} {
+		commits = append(commits, commitOrDie(t, src, "master", content))
+	}
+
+	lines := fetchRaw(t, srcDir, "want "+commits[3], "deepen 2")
+	expected := []string{"shallow-info", "shallow " + commits[2], "<delim>"}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("expected %v but got: %v", expected, lines)
+	}
+
+	// a shallow clone of the last two commits fetches what's new on top
+	repo := initTestRepo(t, filepath.Join(dir, "dst"), false)
+	objects, boundary, err := HistoryObjects(src, commits[3:], ShallowOptions{Depth: 2}, repo.Database().Has)
+	if err == nil {
+		err = CopyObjects(src, repo, objects)
+	}
+	if err == nil {
+		err = repo.SetShallow(boundary)
+	}
+	if err != nil {
+		t.Fatalf("error making a shallow clone: %v", err)
+	}
+	tx := repo.Refs().Transaction(testAuthor)
+	tx.Create("refs/remotes/origin/master", commits[3], "clone")
+	if err = tx.Commit(); err != nil {
+		t.Fatalf("error creating origin/master: %v", err)
+	}
+
+	fifth := commitOrDie(t, src, "master", "five")
+	before := countObjects(t, repo)
+	rem := &Remote{
+		Name:       "origin",
+		URL:        srcDir,
+		Fetch:      []refspec.Refspec{DefaultFetchRefspec("origin")},
+		UploadPack: "got upload-pack",
+	}
+	if _, err = Fetch(repo, rem, dir, FetchOptions{Committer: testAuthor}); err != nil {
+		t.Fatalf("expected no errors but got: %v", err)
+	}
+	if oid, _ := repo.Refs().ReadRef("refs/remotes/origin/master"); oid != fifth {
+		t.Errorf("expected origin/master to be %s but got: '%s'", fifth, oid)
+	}
+	if n := countObjects(t, repo) - before; n != 3 {
+		t.Errorf("expected 3 new objects but got %d", n)
+	}
+	if shallow, _ := repo.Shallow(); len(shallow) != 1 || !shallow[commits[2]] {
+		t.Errorf("expected %s to stay shallow but got: %v", commits[2], shallow)
+	}
+
+	// deepening past the client's boundary unshallows it
+	lines = fetchRaw(t, srcDir, "want "+fifth, "have "+fifth, "shallow "+commits[2], "deepen 4")
+	expected = []string{"shallow-info", "shallow " + commits[1], "unshallow " + commits[2], "<delim>"}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("expected %v but got: %v", expected, lines)
+	}
+
+	// or deepening relative to it
+	lines = fetchRaw(t, srcDir, "want "+fifth, "shallow "+commits[2], "deepen 1", "deepen-relative")
+	expected = []string{"shallow-info", "shallow " + commits[1], "unshallow " + commits[2], "<delim>"}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("expected %v but got: %v", expected, lines)
+	}
+
+	// too shallow to reach it leaves it as it is
+	lines = fetchRaw(t, srcDir, "want "+fifth, "shallow "+commits[2], "deepen 2")
+	expected = []string{"shallow-info", "shallow " + commits[3], "<delim>"}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("expected %v but got: %v", expected, lines)
+	}
+}
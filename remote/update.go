@@ -0,0 +1,98 @@
+package remote
+
+import (
+	"strings"
+
+	"github.com/neocortical/got/ref"
+	"github.com/neocortical/got/repository"
+)
+
+// Status is the outcome of updating a ref in a fetch or push.
+type Status int
+
+const (
+	StatusUpToDate Status = iota
+	StatusNew
+	StatusFastForward
+	StatusForced
+	StatusDeleted
+	// StatusRejected updates were refused before anything was written.
+	StatusRejected
+	// StatusRemoteRejected updates were refused by the receiving repository.
+	StatusRemoteRejected
+)
+
+// Update is a ref that a fetch or push updated or tried to update.
+type Update struct {
+	// Src is the full name of the ref copied from, and Dst of the ref copied
+	// to. Dst is empty for refs fetched without being stored, and Src for
+	// refs deleted by a push.
+	Src string
+	Dst string
+
+	OldOID string
+	NewOID string
+	Status Status
+	// Reason says why an update was rejected, in git's words.
+	Reason string
+}
+
+// OK reports whether the update went through, or had nothing to do.
+func (u Update) OK() bool {
+	return u.Status != StatusRejected && u.Status != StatusRemoteRejected
+}
+
+// Kind describes the ref updated, as git's summaries do: "branch", "tag" or
+// "ref".
+func (u Update) Kind() string {
+	name := u.Dst
+	if name == "" {
+		name = u.Src
+	}
+
+	switch {
+	case strings.HasPrefix(name, ref.HeadsPrefix), strings.HasPrefix(name, RemotesPrefix):
+		return "branch"
+	case strings.HasPrefix(name, TagsPrefix):
+		return "tag"
+	}
+
+	return "ref"
+}
+
+// classify works out how a ref can move from old to new: whether it's new,
+// up to date or a fast-forward in repo, which must have both commits, or
+// else only if forced.
+func classify(repo *repository.Repo, name string, oldOID string, newOID string, force bool) (Status, string, error) {
+	switch {
+	case oldOID == newOID:
+		return StatusUpToDate, "", nil
+	case oldOID == "":
+		return StatusNew, "", nil
+	case strings.HasPrefix(name, TagsPrefix):
+		// tags aren't expected to move at all
+		if force {
+			return StatusForced, "", nil
+		}
+		return StatusRejected, "already exists", nil
+	}
+
+	if !repo.Database().Has(oldOID) {
+		if force {
+			return StatusForced, "", nil
+		}
+		return StatusRejected, "fetch first", nil
+	}
+
+	ff, err := IsAncestor(repo, oldOID, newOID)
+	switch {
+	case err != nil:
+		return 0, "", err
+	case ff:
+		return StatusFastForward, "", nil
+	case force:
+		return StatusForced, "", nil
+	}
+
+	return StatusRejected, "non-fast-forward", nil
+}
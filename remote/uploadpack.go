@@ -0,0 +1,483 @@
+package remote
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/neocortical/got/object"
+	"github.com/neocortical/got/pktline"
+	"github.com/neocortical/got/ref"
+	"github.com/neocortical/got/repository"
+)
+
+// agent identifies got to the other side of a connection.
+const agent = "got/1.0"
+
+// request is a protocol v2 command sent to upload-pack.
+type request struct {
+	command      string
+	capabilities []string
+	args         []string
+}
+
+// UploadPackOptions are the options of UploadPack.
+type UploadPackOptions struct {
+	// AdvertiseRefs stops after advertising capabilities, as the first
+	// request of a fetch over HTTP does.
+	AdvertiseRefs bool
+	// StatelessRPC serves requests without advertising capabilities first,
+	// as later requests of a fetch over HTTP do.
+	StatelessRPC bool
+}
+
+// UploadPack serves fetches from repo, reading from r and writing to w, as
+// git upload-pack does with protocol version 2. It advertises its
+// capabilities and serves commands until the client hangs up.
+func UploadPack(repo *repository.Repo, r io.Reader, w io.Writer, opts UploadPackOptions) error {
+	pr, pw := pktline.NewReader(r), pktline.NewWriter(w)
+
+	if !opts.StatelessRPC {
+		for _, line := range []string{"version 2", "agent=" + agent, "ls-refs", "fetch=shallow filter", "server-option", "object-format=" + repo.ObjectFormat().String()} {
+			if err := pw.WriteString(line); err != nil {
+				return err
+			}
+		}
+		if err := pw.Flush(); err != nil {
+			return err
+		}
+	}
+	if opts.AdvertiseRefs {
+		return nil
+	}
+
+	for {
+		req, err := readRequest(pr)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch req.command {
+		case "ls-refs":
+			err = serveLsRefs(repo, pw, req)
+		case "fetch":
+			err = serveFetch(repo, pw, req)
+		default:
+			err = fmt.Errorf("unknown command '%s'", req.command)
+		}
+		if err != nil {
+			pw.Error(err.Error())
+			return err
+		}
+	}
+}
+
+// readRequest reads a command, its capabilities and its arguments. It
+// returns io.EOF if the client hung up, or ended the session with a flush.
+func readRequest(pr *pktline.Reader) (*request, error) {
+	line, kind, err := pr.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	if kind == pktline.Flush {
+		return nil, io.EOF
+	}
+	if kind != pktline.Data || !strings.HasPrefix(line, "command=") {
+		return nil, fmt.Errorf("expected a command, got '%s'", line)
+	}
+
+	req := &request{command: strings.TrimPrefix(line, "command=")}
+	inArgs := false
+	for {
+		line, kind, err = pr.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case kind == pktline.Flush:
+			return req, nil
+		case kind == pktline.Delim && !inArgs:
+			inArgs = true
+		case kind != pktline.Data:
+			return nil, fmt.Errorf("unexpected %s packet", kind)
+		case inArgs:
+			req.args = append(req.args, line)
+		default:
+			req.capabilities = append(req.capabilities, line)
+		}
+	}
+}
+
+func serveLsRefs(repo *repository.Repo, pw *pktline.Writer, req *request) error {
+	var symrefs, peel bool
+	var prefixes []string
+	for _, arg := range req.args {
+		switch {
+		case arg == "symrefs":
+			symrefs = true
+		case arg == "peel":
+			peel = true
+		case strings.HasPrefix(arg, "ref-prefix "):
+			prefixes = append(prefixes, strings.TrimPrefix(arg, "ref-prefix "))
+		case arg == "unborn":
+			// not advertised, but sent by clients regardless
+		default:
+			return fmt.Errorf("unexpected line: '%s'", arg)
+		}
+	}
+
+	refs, err := listRefs(repo, true)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range refs {
+		matched := len(prefixes) == 0
+		for _, prefix := range prefixes {
+			matched = matched || strings.HasPrefix(r.Name, prefix)
+		}
+		if !matched {
+			continue
+		}
+
+		line := r.OID + " " + r.Name
+		if symrefs && r.Target != "" {
+			line += " symref-target:" + r.Target
+		}
+		if peel && r.Peeled != "" {
+			line += " peeled:" + r.Peeled
+		}
+		if err = pw.WriteString(line); err != nil {
+			return err
+		}
+	}
+
+	return pw.Flush()
+}
+
+// fetchArgs are the arguments of a fetch command.
+type fetchArgs struct {
+	wants []string
+	haves []string
+	// shallow are the commits whose parents the client doesn't have
+	shallow map[string]bool
+	deepen  ShallowOptions
+	// deepenRelative counts the depth from the client's shallow commits
+	// rather than from the wants
+	deepenRelative bool
+	filter         *Filter
+	done           bool
+	noProgress     bool
+	includeTag     bool
+}
+
+func parseFetchArgs(repo *repository.Repo, req *request) (*fetchArgs, error) {
+	format := repo.ObjectFormat()
+
+	result := &fetchArgs{shallow: map[string]bool{}}
+	for _, arg := range req.args {
+		var err error
+		switch {
+		case strings.HasPrefix(arg, "want "):
+			oid := strings.TrimPrefix(arg, "want ")
+			if !format.IsOID(oid) || !repo.Database().Has(oid) {
+				return nil, fmt.Errorf("upload-pack: not our ref %s", oid)
+			}
+			result.wants = append(result.wants, oid)
+		case strings.HasPrefix(arg, "have "):
+			if oid := strings.TrimPrefix(arg, "have "); format.IsOID(oid) && repo.Database().Has(oid) {
+				result.haves = append(result.haves, oid)
+			}
+		case strings.HasPrefix(arg, "shallow "):
+			oid := strings.TrimPrefix(arg, "shallow ")
+			if !format.IsOID(oid) {
+				return nil, fmt.Errorf("invalid shallow line: %s", arg)
+			}
+			result.shallow[oid] = true
+		case strings.HasPrefix(arg, "deepen "):
+			result.deepen.Depth, err = strconv.Atoi(strings.TrimPrefix(arg, "deepen "))
+			if err != nil || result.deepen.Depth <= 0 {
+				return nil, fmt.Errorf("invalid deepen: %s", arg)
+			}
+		case strings.HasPrefix(arg, "deepen-since "):
+			seconds, err := strconv.ParseInt(strings.TrimPrefix(arg, "deepen-since "), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid deepen-since: %s", arg)
+			}
+			result.deepen.Since = time.Unix(seconds, 0)
+		case arg == "deepen-relative":
+			result.deepenRelative = true
+		case strings.HasPrefix(arg, "filter "):
+			if result.filter, err = ParseFilter(strings.TrimPrefix(arg, "filter ")); err != nil {
+				return nil, err
+			}
+		case arg == "done":
+			result.done = true
+		case arg == "no-progress":
+			result.noProgress = true
+		case arg == "include-tag":
+			result.includeTag = true
+		case arg == "thin-pack", arg == "ofs-delta":
+			// whole objects suit every client
+		default:
+			return nil, fmt.Errorf("unexpected line: '%s'", arg)
+		}
+	}
+
+	return result, nil
+}
+
+func serveFetch(repo *repository.Repo, pw *pktline.Writer, req *request) error {
+	args, err := parseFetchArgs(repo, req)
+	if err != nil {
+		return err
+	}
+
+	if !args.done {
+		if err := pw.WriteString("acknowledgments"); err != nil {
+			return err
+		}
+		if len(args.haves) == 0 {
+			pw.WriteString("NAK")
+		}
+		for _, oid := range args.haves {
+			pw.WriteString("ACK %s", oid)
+		}
+
+		ready, err := readyToSend(repo, args.wants, args.haves)
+		if err != nil {
+			return err
+		}
+		if !ready {
+			return pw.Flush()
+		}
+		pw.WriteString("ready")
+		pw.Delim()
+	}
+
+	oids, shallowInfo, err := packObjects(repo, args)
+	if err != nil {
+		return err
+	}
+
+	if shallowInfo != nil {
+		pw.WriteString("shallow-info")
+		for _, line := range shallowInfo {
+			pw.WriteString(line)
+		}
+		pw.Delim()
+	}
+	if err = pw.WriteString("packfile"); err != nil {
+		return err
+	}
+	if !args.noProgress {
+		progress := pktline.NewSidebandWriter(pw, pktline.BandProgress)
+		fmt.Fprintf(progress, "Enumerating objects: %d, done.\n", len(oids))
+	}
+	if err = object.WritePack(pktline.NewSidebandWriter(pw, pktline.BandData), repo.ObjectFormat(), repo.Database(), oids); err != nil {
+		return err
+	}
+
+	return pw.Flush()
+}
+
+// readyToSend reports whether the common commits found so far are enough to
+// send a pack: whether every wanted commit has one among its ancestors.
+func readyToSend(repo *repository.Repo, wants []string, common []string) (bool, error) {
+	if len(common) == 0 {
+		return false, nil
+	}
+
+	for _, want := range wants {
+		target, _, err := peelTags(repo, want)
+		if err != nil {
+			return false, err
+		}
+		if objectType, _, err := readLinks(repo, target); err != nil || objectType != ref.TypeCommit {
+			// only commits have history to negotiate over
+			continue
+		}
+
+		found := false
+		for _, oid := range common {
+			ancestor, err := IsAncestor(repo, oid, target)
+			if err != nil {
+				return false, err
+			}
+			if ancestor {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// packObjects lists the objects a fetch sends: those the wants need that
+// aren't reachable from the common commits, leaving out those a filter
+// asks to. With includeTag, annotated tags pointing at objects sent go along
+// too. It also returns the lines of the shallow-info section, if the client
+// needs one: when it deepens its history, it's shallow itself, or we are.
+func packObjects(repo *repository.Repo, args *fetchArgs) ([]string, []string, error) {
+	shallow, err := repo.Shallow()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the client has everything reachable from what it has in common with
+	// us, short of the history either of us lacks
+	edge := map[string]bool{}
+	for oid := range shallow {
+		edge[oid] = true
+	}
+	for oid := range args.shallow {
+		edge[oid] = true
+	}
+	known, err := walkObjects(repo, args.haves, func(string) bool { return false }, edge)
+	if err != nil {
+		return nil, nil, err
+	}
+	has := map[string]bool{}
+	for _, oid := range known {
+		has[oid] = true
+	}
+
+	var shallowInfo []string
+	if args.deepen.Limited() || len(args.shallow) > 0 || len(shallow) > 0 {
+		shallowInfo = []string{}
+	}
+	tips := args.wants
+	if args.deepen.Limited() {
+		var unshallowed []string
+		if shallowInfo, unshallowed, err = deepen(repo, args, has); err != nil {
+			return nil, nil, err
+		}
+		tips = append(append([]string(nil), tips...), unshallowed...)
+	}
+
+	oids, err := MissingObjects(repo, tips, func(oid string) bool { return has[oid] })
+	if err != nil {
+		return nil, nil, err
+	}
+	if !args.deepen.Limited() && len(shallow) > 0 {
+		// the client can't go past our own shallow commits either
+		for _, oid := range oids {
+			if shallow[oid] && !args.shallow[oid] {
+				shallowInfo = append(shallowInfo, "shallow "+oid)
+			}
+		}
+	}
+	if args.filter != nil {
+		if oids, _, err = FilterObjects(repo, oids, args.wants, args.filter); err != nil {
+			return nil, nil, err
+		}
+	}
+	if !args.includeTag {
+		return oids, shallowInfo, nil
+	}
+
+	sending := map[string]bool{}
+	for _, oid := range oids {
+		sending[oid] = true
+	}
+	tags, err := repo.Refs().List(TagsPrefix)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error listing refs: %w", err)
+	}
+	for _, tag := range tags {
+		target, chain, err := peelTags(repo, tag.OID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !sending[target] {
+			continue
+		}
+		for _, oid := range chain {
+			if !sending[oid] && !has[oid] {
+				sending[oid] = true
+				oids = append(oids, oid)
+			}
+		}
+	}
+
+	return oids, shallowInfo, nil
+}
+
+// deepen limits the history sent for a shallow fetch, marking the commits
+// left out as ones the client has. It returns the shallow-info lines, and
+// the parents of the client's shallow commits that are shallow no longer,
+// which need sending too.
+func deepen(repo *repository.Repo, args *fetchArgs, has map[string]bool) (shallowInfo []string, parents []string, err error) {
+	tips, opts := args.wants, args.deepen
+	if args.deepenRelative {
+		// the client's shallow commits are the first generation, and the
+		// depth counts those past them
+		tips = nil
+		for _, oid := range sortedOIDs(args.shallow) {
+			if repo.Database().Has(oid) {
+				tips = append(tips, oid)
+			}
+		}
+		opts.Depth++
+	}
+
+	marks, boundary, err := shallowCommits(repo, tips, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	onBoundary := map[string]bool{}
+	for _, oid := range boundary {
+		onBoundary[oid] = true
+		if !args.shallow[oid] {
+			shallowInfo = append(shallowInfo, "shallow "+oid)
+		}
+	}
+	for _, oid := range sortedOIDs(args.shallow) {
+		if marks[oid] != commitIncluded || onBoundary[oid] {
+			continue
+		}
+		shallowInfo = append(shallowInfo, "unshallow "+oid)
+
+		_, links, err := readLinks(repo, oid)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, link := range links {
+			if link.Type == ref.TypeCommit {
+				parents = append(parents, link.OID)
+			}
+		}
+	}
+
+	for oid, mark := range marks {
+		if mark == commitExcluded {
+			has[oid] = true
+		}
+	}
+
+	return shallowInfo, parents, nil
+}
+
+// ProtocolV2Requested reports whether a client asked for protocol version 2
+// in params, a colon-separated list as in GIT_PROTOCOL or the Git-Protocol
+// header of HTTP requests.
+func ProtocolV2Requested(params string) bool {
+	for _, param := range strings.Split(params, ":") {
+		if param == "version=2" {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,32 @@
+package repository
+
+import (
+	"github.com/neocortical/got/object"
+	"github.com/neocortical/got/objectformat"
+)
+
+// failedDatabase stands in for an object database that couldn't be opened,
+// so that every operation reports why.
+type failedDatabase struct {
+	err error
+}
+
+func (db failedDatabase) Store(s object.Storable) (string, error) {
+	return "", db.err
+}
+
+func (db failedDatabase) Read(oid string) (object.Storable, error) {
+	return nil, db.err
+}
+
+func (db failedDatabase) Has(oid string) bool {
+	return false
+}
+
+func (db failedDatabase) OIDs() ([]string, error) {
+	return nil, db.err
+}
+
+func (db failedDatabase) SetFsync(enabled bool) {}
+
+func (db failedDatabase) SetObjectFormat(f *objectformat.Format) {}
@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"github.com/neocortical/got/config"
+)
+
+// PromisorFetcher fetches objects from the remote a partial clone left them
+// out of.
+type PromisorFetcher func(r *Repo, remote string, oids []string) error
+
+var promisorFetcher PromisorFetcher
+
+// SetPromisorFetcher sets how partial clones fetch the objects they left
+// out, which needs remotes this package knows nothing about.
+func SetPromisorFetcher(f PromisorFetcher) {
+	promisorFetcher = f
+}
+
+// PromisorRemote returns the remote a partial clone can fetch the objects
+// it left out from, named by extensions.partialClone, or an empty string for
+// a complete repository. Like the object format, it only counts in
+// repository format version 1.
+func PromisorRemote(cfg *config.Config) string {
+	if version, err := cfg.GetInt("core.repositoryformatversion", 0); err != nil || version != 1 {
+		return ""
+	}
+
+	name, _ := cfg.Get("extensions.partialclone")
+	return name
+}
+
+// PromisorObjects returns the objects a partial clone left out, which its
+// promisor remote can provide, from the promisor file.
+func (r *Repo) PromisorObjects() (map[string]bool, error) {
+	return r.readOIDFile(promisorFile)
+}
+
+// AddPromisorObjects records more objects a partial clone left out.
+func (r *Repo) AddPromisorObjects(oids []string) error {
+	if len(oids) == 0 {
+		return nil
+	}
+
+	promised, err := r.PromisorObjects()
+	if err != nil {
+		return err
+	}
+	for _, oid := range oids {
+		promised[oid] = true
+	}
+
+	all := make([]string, 0, len(promised))
+	for oid := range promised {
+		all = append(all, oid)
+	}
+
+	return r.writeOIDFile(promisorFile, all)
+}
+
+// fetchPromised fetches an object from the promisor remote, if it's one the
+// repository was promised.
+func (r *Repo) fetchPromised(remote string, oid string) error {
+	promised, err := r.PromisorObjects()
+	if err != nil || !promised[oid] {
+		return err
+	}
+
+	return promisorFetcher(r, remote, []string{oid})
+}
@@ -2,11 +2,19 @@ package repository
 
 import (
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/neocortical/got/config"
+	"github.com/neocortical/got/fsync"
 	"github.com/neocortical/got/index"
 	"github.com/neocortical/got/object"
+	"github.com/neocortical/got/objectformat"
 	"github.com/neocortical/got/ref"
 )
 
@@ -16,62 +24,457 @@ const (
 	indexFilename = "index"
 	databaseDir   = "objects"
 	refsDir       = "refs"
+	configFile    = "config"
+	headFile      = "HEAD"
+	shallowFile   = "shallow"
+	promisorFile  = "promisor"
+
+	// DefaultBranch is the branch HEAD points to in a new repository.
+	DefaultBranch = "master"
+
+	// EnvAlternateObjectDirectories lists more object directories to read
+	// objects from, separated like PATH.
+	EnvAlternateObjectDirectories = "GIT_ALTERNATE_OBJECT_DIRECTORIES"
+
+	storageLoose  = "loose"
+	storageKV     = "kv"
+	storageMemory = "memory"
 )
 
 type Repo struct {
 	workspaceDir string
+	gitDir       string
 	idx          index.Index
 	db           object.Database
+	ownDB        object.Database
+	cache        *object.CachedDatabase
 	refs         ref.Refs
+	config       *config.Config
+	// fsyncComponents is read from the config once, so that it's only
+	// warned about once
+	fsyncComponents *fsync.Component
+}
+
+var getenv = os.Getenv
+
+// SetGetenv replaces the function used to read environment variables.
+func SetGetenv(f func(string) string) {
+	getenv = f
+}
+
+var stderr io.Writer = os.Stderr
+
+// SetStderr replaces where warnings about the repository's settings go.
+func SetStderr(w io.Writer) {
+	stderr = w
 }
 
 func NewRepo(workspaceDir string) *Repo {
 	return &Repo{
 		workspaceDir: workspaceDir,
+		gitDir:       path.Join(workspaceDir, GitDir),
+	}
+}
+
+// NewBareRepo opens a repository without a workspace, whose git directory is
+// gitDir.
+func NewBareRepo(gitDir string) *Repo {
+	return &Repo{
+		gitDir: gitDir,
+	}
+}
+
+// Init creates a repository whose objects are named with the given format.
+// Formats other than SHA-1 are recorded in extensions.objectFormat, which
+// needs repository format version 1.
+func Init(workspaceDir string, format *objectformat.Format) (*Repo, error) {
+	result := NewRepo(workspaceDir)
+	if err := result.init(format); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// InitBare creates a repository without a workspace in gitDir.
+func InitBare(gitDir string, format *objectformat.Format) (*Repo, error) {
+	result := NewBareRepo(gitDir)
+	if err := result.init(format); err != nil {
+		return nil, err
+	}
+
+	cfg, err := result.Config()
+	if err != nil {
+		return nil, err
 	}
+	if err = cfg.Set("core.bare", "true"); err != nil {
+		return nil, err
+	}
+
+	return result, nil
 }
 
-func Init(workspaceDir string) (result *Repo, err error) {
-	gitDir := path.Join(workspaceDir, GitDir)
+func (r *Repo) init(format *objectformat.Format) (err error) {
+	gitDir := r.gitDir
 	err = os.MkdirAll(gitDir, 0755)
 	if err != nil {
-		return nil, fmt.Errorf("error creating '%s' directory: %w", GitDir, err)
+		return fmt.Errorf("error creating '%s' directory: %w", gitDir, err)
 	}
-	for _, subDir := range []string{databaseDir, refsDir} {
+	for _, subDir := range []string{databaseDir, refsDir, path.Join(refsDir, "heads"), path.Join(refsDir, "tags")} {
 		dir := path.Join(gitDir, subDir)
 		err = os.Mkdir(dir, 0755)
 		if err != nil {
-			return nil, fmt.Errorf("error creating '%s' directory: %v", subDir, err)
+			return fmt.Errorf("error creating '%s' directory: %v", subDir, err)
+		}
+	}
+
+	// HEAD starts out as a symbolic ref to a branch that doesn't exist yet
+	head := fmt.Sprintf("ref: %s%s\n", ref.HeadsPrefix, DefaultBranch)
+	err = ioutil.WriteFile(path.Join(gitDir, headFile), []byte(head), 0644)
+	if err != nil {
+		return fmt.Errorf("error creating HEAD: %w", err)
+	}
+
+	if format != objectformat.Default {
+		cfg, err := r.Config()
+		if err != nil {
+			return err
+		}
+		if err = cfg.Set("core.repositoryformatversion", "1"); err != nil {
+			return err
+		}
+		if err = cfg.Set("extensions.objectformat", format.String()); err != nil {
+			return err
 		}
 	}
 
-	return NewRepo(workspaceDir), nil
+	return nil
 }
 
 func (r *Repo) Dir() string {
-	return path.Join(r.workspaceDir, GitDir)
+	return r.gitDir
+}
+
+// WorkspaceDir returns the directory of the repository's working tree, or an
+// empty string for a bare repository.
+func (r *Repo) WorkspaceDir() string {
+	return r.workspaceDir
 }
 
+// Bare reports whether the repository has no workspace.
+func (r *Repo) Bare() bool {
+	return r.workspaceDir == ""
+}
+
+// Database returns the repository's object database: the backend named by
+// storage.objects (loose, the default, kv or memory), reading through to any
+// stores listed in storage.readThrough as "loose:<dir>" or "kv:<file>", and
+// then to the object directories named by the alternates file and
+// GIT_ALTERNATE_OBJECT_DIRECTORIES. Relative locations are relative to the
+// objects directory. Objects read are cached in memory up to
+// core.objectCacheLimit bytes (16m by default, 0 to disable). A partial
+// clone fetches the objects it was promised from its promisor remote when
+// they're first read.
 func (r *Repo) Database() object.Database {
 	if r.db == nil {
-		r.db = object.NewDatabase(path.Join(r.workspaceDir, GitDir, databaseDir))
+		r.ownDB, r.db = r.openDatabase()
+		r.db.SetFsync(r.FsyncComponents().Has(fsync.LooseObject))
+		r.db.SetObjectFormat(r.ObjectFormat())
+
+		if cfg, err := r.Config(); err == nil && promisorFetcher != nil {
+			if remote := PromisorRemote(cfg); remote != "" {
+				r.db = object.NewPromisorDatabase(r.db, func(oid string) error {
+					return r.fetchPromised(remote, oid)
+				})
+			}
+		}
+
+		if limit := r.objectCacheLimit(); limit > 0 {
+			r.cache = object.NewCachedDatabase(r.db, limit)
+			r.db = r.cache
+		}
 	}
 
 	return r.db
 }
 
+// ObjectCache returns the cache in front of the object database, or nil if
+// caching is disabled.
+func (r *Repo) ObjectCache() *object.CachedDatabase {
+	r.Database()
+	return r.cache
+}
+
+// objectCacheLimit reads core.objectCacheLimit, falling back to the default
+// if it's missing or malformed.
+func (r *Repo) objectCacheLimit() int64 {
+	cfg, err := r.Config()
+	if err != nil {
+		return object.DefaultCacheLimit
+	}
+
+	limit, err := cfg.GetInt("core.objectcachelimit", object.DefaultCacheLimit)
+	if err != nil {
+		return object.DefaultCacheLimit
+	}
+
+	return int64(limit)
+}
+
+// OwnDatabase returns the part of the object database that belongs to the
+// repository, without the stores it reads through to.
+func (r *Repo) OwnDatabase() object.Database {
+	r.Database()
+	return r.ownDB
+}
+
+// SetDatabase replaces the object database, for callers that manage object
+// storage themselves.
+func (r *Repo) SetDatabase(db object.Database) {
+	r.db, r.ownDB, r.cache = db, db, nil
+}
+
+// ObjectsDir returns the directory of the repository's object database.
+func (r *Repo) ObjectsDir() string {
+	return path.Join(r.gitDir, databaseDir)
+}
+
+func (r *Repo) openDatabase() (own object.Database, result object.Database) {
+	dir := r.ObjectsDir()
+
+	cfg, err := r.Config()
+	if err != nil {
+		cfg = &config.Config{}
+	}
+	if _, err = ConfigObjectFormat(cfg); err != nil {
+		own = failedDatabase{err}
+		return own, own
+	}
+
+	switch backend, _ := cfg.Get("storage.objects"); backend {
+	case "", storageLoose:
+		own = object.NewDatabase(dir)
+	case storageKV:
+		own = object.NewKVDatabase(path.Join(dir, object.KVFilename))
+	case storageMemory:
+		own = object.NewMemoryDatabase()
+	default:
+		own = failedDatabase{fmt.Errorf("unknown storage.objects backend '%s'", backend)}
+		return own, own
+	}
+
+	var others []object.Database
+	for _, store := range cfg.GetAll("storage.readthrough") {
+		backend, location := storageLoose, store
+		if colon := strings.Index(store, ":"); colon != -1 {
+			backend, location = store[:colon], store[colon+1:]
+		}
+		if !filepath.IsAbs(location) {
+			location = filepath.Join(dir, location)
+		}
+
+		switch backend {
+		case storageLoose:
+			others = append(others, object.NewDatabase(location))
+		case storageKV:
+			others = append(others, object.NewKVDatabase(location))
+		default:
+			failed := failedDatabase{fmt.Errorf("unknown storage.readThrough backend '%s'", backend)}
+			return failed, failed
+		}
+	}
+
+	alternates, err := r.Alternates()
+	if err != nil {
+		failed := failedDatabase{err}
+		return failed, failed
+	}
+	for _, alternate := range alternates {
+		others = append(others, object.NewDatabase(alternate))
+	}
+
+	if len(others) == 0 {
+		return own, own
+	}
+
+	return own, object.NewChainedDatabase(own, others...)
+}
+
+// Alternates returns the object directories the repository borrows objects
+// from, following the alternates of alternates.
+func (r *Repo) Alternates() ([]string, error) {
+	dir := r.ObjectsDir()
+
+	dirs, err := object.ReadAlternates(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, env := range filepath.SplitList(getenv(EnvAlternateObjectDirectories)) {
+		if env != "" {
+			dirs = append(dirs, env)
+		}
+	}
+
+	return object.ResolveAlternates(dirs, dir)
+}
+
+// IndexFile returns the path of the repository's index.
+func (r *Repo) IndexFile() string {
+	return path.Join(r.gitDir, indexFilename)
+}
+
 func (r *Repo) Index() index.Index {
 	if r.idx == nil {
-		r.idx = index.NewIndex(path.Join(r.workspaceDir, GitDir, indexFilename))
+		r.idx = index.NewIndex(r.IndexFile())
+		r.idx.SetFsync(r.FsyncComponents().Has(fsync.Index))
+		r.idx.SetObjectFormat(r.ObjectFormat())
 	}
 
 	return r.idx
 }
 
+// Refs returns the repository's refs, with lock timeouts taken from
+// core.filesRefLockTimeout and core.packedRefsTimeout (in milliseconds). An
+// unreadable config leaves git's defaults in place; commands that depend on
+// the config report the error themselves.
 func (r *Repo) Refs() ref.Refs {
 	if r.refs == nil {
-		r.refs = ref.NewRefs(path.Join(r.workspaceDir, GitDir))
+		r.refs = ref.NewRefs(r.gitDir)
+
+		if cfg, err := r.Config(); err == nil {
+			r.refs.SetLockTimeouts(
+				timeoutSetting(cfg, "core.filesreflocktimeout", ref.DefaultLockTimeout),
+				timeoutSetting(cfg, "core.packedrefstimeout", ref.DefaultPackedRefsTimeout))
+		}
+		r.refs.SetFsync(r.FsyncComponents().Has(fsync.Reference))
+		r.refs.SetObjectFormat(r.ObjectFormat())
 	}
 
 	return r.refs
 }
+
+// timeoutSetting reads a timeout given in milliseconds, falling back to def if
+// it's missing or malformed.
+func timeoutSetting(cfg *config.Config, key string, def time.Duration) time.Duration {
+	ms, err := cfg.GetInt(key, int(def/time.Millisecond))
+	if err != nil {
+		return def
+	}
+
+	return time.Duration(ms) * time.Millisecond
+}
+
+// FsyncComponents returns the kinds of files flushed to stable storage before
+// they're committed, from core.fsync and the older core.fsyncObjectFiles. Like
+// the lock timeouts, a missing or malformed setting means the defaults, which
+// unlike git's sync everything. Unknown components are ignored with a warning,
+// as git does.
+func (r *Repo) FsyncComponents() fsync.Component {
+	cfg, err := r.Config()
+	if err != nil {
+		return fsync.Default
+	}
+	if r.fsyncComponents != nil {
+		return *r.fsyncComponents
+	}
+
+	value, _ := cfg.Get("core.fsync")
+	result, unknown := fsync.Parse(value)
+	for _, name := range unknown {
+		fmt.Fprintf(stderr, "warning: ignoring unknown core.fsync component '%s'\n", name)
+	}
+
+	if objectFiles, err := cfg.GetBool("core.fsyncobjectfiles", false); err == nil && objectFiles {
+		result |= fsync.LooseObject
+	}
+
+	r.fsyncComponents = &result
+	return result
+}
+
+// ObjectFormat returns the hash the repository's objects are named by. Like
+// the other settings, an unreadable config means the default, so repositories
+// should pass CheckFormat before they're used.
+func (r *Repo) ObjectFormat() *objectformat.Format {
+	cfg, err := r.Config()
+	if err != nil {
+		return objectformat.Default
+	}
+
+	result, err := ConfigObjectFormat(cfg)
+	if err != nil {
+		return objectformat.Default
+	}
+
+	return result
+}
+
+// CheckFormat returns an error if the repository's config can't be read or
+// names an object format or repository format version got doesn't know, which
+// it would otherwise misread as SHA-1.
+func (r *Repo) CheckFormat() error {
+	cfg, err := r.Config()
+	if err != nil {
+		return err
+	}
+
+	_, err = ConfigObjectFormat(cfg)
+	return err
+}
+
+// ConfigObjectFormat reads the object format from a repository's config:
+// extensions.objectFormat, which only counts in repository format version 1.
+func ConfigObjectFormat(cfg *config.Config) (*objectformat.Format, error) {
+	version, err := cfg.GetInt("core.repositoryformatversion", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	switch version {
+	case 0:
+		return objectformat.Default, nil
+	case 1:
+		name, exists := cfg.Get("extensions.objectformat")
+		if !exists {
+			return objectformat.Default, nil
+		}
+		return objectformat.Parse(name)
+	}
+
+	return nil, fmt.Errorf("unknown repository format version %d", version)
+}
+
+func (r *Repo) Config() (result *config.Config, err error) {
+	if r.config == nil {
+		r.config, err = config.Load(path.Join(r.gitDir, configFile))
+	}
+
+	return r.config, err
+}
+
+// StatOptions returns the index stat comparison settings from the repository
+// configuration (core.trustctime and core.checkStat).
+func (r *Repo) StatOptions() (result index.StatOptions, err error) {
+	cfg, err := r.Config()
+	if err != nil {
+		return
+	}
+
+	result = index.DefaultStatOptions()
+	result.TrustCtime, err = cfg.GetBool("core.trustctime", true)
+	if err != nil {
+		return result, fmt.Errorf("bad config value for core.trustctime: %w", err)
+	}
+
+	checkStat, _ := cfg.Get("core.checkstat")
+	switch checkStat {
+	case "", "default":
+		result.MinimalStat = false
+	case "minimal":
+		result.MinimalStat = true
+	default:
+		return result, fmt.Errorf("invalid value for core.checkStat: '%s'", checkStat)
+	}
+
+	return
+}
@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/neocortical/got/fsync"
+	"github.com/neocortical/got/lock"
+)
+
+// Shallow returns the commits at the edge of a shallow repository's history,
+// whose parents it doesn't have, from the shallow file. Any other repository
+// has none.
+func (r *Repo) Shallow() (map[string]bool, error) {
+	return r.readOIDFile(shallowFile)
+}
+
+// SetShallow replaces the commits recorded as the edge of the repository's
+// history. An empty list removes the shallow file, making the repository
+// complete again.
+func (r *Repo) SetShallow(oids []string) error {
+	return r.writeOIDFile(shallowFile, oids)
+}
+
+// readOIDFile reads a file in the git directory listing object IDs, one per
+// line. A missing file lists none.
+func (r *Repo) readOIDFile(name string) (map[string]bool, error) {
+	data, err := ioutil.ReadFile(path.Join(r.gitDir, name))
+	if os.IsNotExist(err) {
+		return map[string]bool{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s file: %w", name, err)
+	}
+
+	format := r.ObjectFormat()
+	result := map[string]bool{}
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		if !format.IsOID(line) {
+			return nil, fmt.Errorf("bad %s line: '%s'", name, line)
+		}
+		result[line] = true
+	}
+
+	return result, nil
+}
+
+// writeOIDFile replaces a file listing object IDs, removing it if the list
+// is empty.
+func (r *Repo) writeOIDFile(name string, oids []string) error {
+	filename := path.Join(r.gitDir, name)
+
+	lf := lock.NewLockfile(filename)
+	lf.SetFsync(r.FsyncComponents().Has(fsync.Reference))
+	if err := lf.Acquire(); err != nil {
+		return fmt.Errorf("could not lock %s file: %w", name, err)
+	}
+
+	if len(oids) == 0 {
+		err := os.Remove(filename)
+		lf.Rollback()
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error removing %s file: %w", name, err)
+		}
+		return nil
+	}
+
+	sorted := append([]string(nil), oids...)
+	sort.Strings(sorted)
+	if err := lf.Write([]byte(strings.Join(sorted, "\n") + "\n")); err != nil {
+		lf.Rollback()
+		return fmt.Errorf("error writing %s file: %w", name, err)
+	}
+	if err := lf.Commit(); err != nil {
+		return fmt.Errorf("error writing %s file: %w", name, err)
+	}
+
+	return nil
+}
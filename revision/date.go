@@ -0,0 +1,76 @@
+package revision
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	relativeDateRegexp = regexp.MustCompile(`^([0-9]+)[. ]+(second|minute|hour|day|week|month|year)s?[. ]+ago$`)
+
+	absoluteDateLayouts = []string{
+		time.RFC3339,
+		"2006-01-02T15:04:05",
+		"2006-01-02 15:04:05 -0700",
+		"2006-01-02 15:04:05",
+		"2006-01-02 15:04",
+		"2006-01-02",
+	}
+)
+
+// ParseDate parses the subset of git's approxidate that reflogs need: "now",
+// "yesterday", relative dates like "2.weeks.ago" or "3 days ago", unix
+// timestamps written as "@1600000000", and ISO 8601 dates and times (taken as
+// local time unless they carry a zone).
+func ParseDate(s string, now time.Time) (time.Time, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+
+	switch s {
+	case "now":
+		return now, nil
+	case "yesterday":
+		return now.AddDate(0, 0, -1), nil
+	}
+
+	if strings.HasPrefix(s, "@") {
+		secs, err := strconv.ParseInt(s[1:], 10, 64)
+		if err == nil {
+			return time.Unix(secs, 0), nil
+		}
+	}
+
+	if m := relativeDateRegexp.FindStringSubmatch(s); m != nil {
+		n, err := strconv.Atoi(m[1])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid date '%s'", s)
+		}
+
+		switch m[2] {
+		case "second":
+			return now.Add(-time.Duration(n) * time.Second), nil
+		case "minute":
+			return now.Add(-time.Duration(n) * time.Minute), nil
+		case "hour":
+			return now.Add(-time.Duration(n) * time.Hour), nil
+		case "day":
+			return now.AddDate(0, 0, -n), nil
+		case "week":
+			return now.AddDate(0, 0, -7*n), nil
+		case "month":
+			return now.AddDate(0, -n, 0), nil
+		case "year":
+			return now.AddDate(-n, 0, 0), nil
+		}
+	}
+
+	for _, layout := range absoluteDateLayouts {
+		if t, err := time.ParseInLocation(layout, strings.ToUpper(s), now.Location()); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid date '%s'", s)
+}
@@ -0,0 +1,41 @@
+package revision
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDate(t *testing.T) {
+	now := time.Date(2020, 12, 27, 12, 0, 0, 0, time.UTC)
+
+	var tests = []struct {
+		input    string
+		expected time.Time
+	}{
+		{"now", now},
+		{"yesterday", now.AddDate(0, 0, -1)},
+		{"90.days.ago", now.AddDate(0, 0, -90)},
+		{"2 weeks ago", now.AddDate(0, 0, -14)},
+		{"1.hour.ago", now.Add(-time.Hour)},
+		{"3.months.ago", now.AddDate(0, -3, 0)},
+		{"@1609095922", time.Unix(1609095922, 0)},
+		{"2020-12-01", time.Date(2020, 12, 1, 0, 0, 0, 0, time.UTC)},
+		{"2020-12-01 10:30:00", time.Date(2020, 12, 1, 10, 30, 0, 0, time.UTC)},
+		{"2020-12-01T10:30:00-08:00", time.Date(2020, 12, 1, 18, 30, 0, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+		actual, err := ParseDate(test.input, now)
+		if err != nil {
+			t.Errorf("%s: expected no error but got: %v", test.input, err)
+			continue
+		}
+		if !actual.Equal(test.expected) {
+			t.Errorf("%s: expected %v but got %v", test.input, test.expected, actual)
+		}
+	}
+
+	if _, err := ParseDate("the day after tomorrow", now); err == nil {
+		t.Error("expected an error for an unsupported date")
+	}
+}
@@ -0,0 +1,325 @@
+package revision
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/neocortical/got/object"
+	"github.com/neocortical/got/objectformat"
+	"github.com/neocortical/got/ref"
+	"github.com/neocortical/got/repository"
+	"github.com/neocortical/got/tree"
+)
+
+const (
+	typeTree = "tree"
+	typeTag  = "tag"
+	head     = "HEAD"
+
+	// maxTagDepth guards against tag cycles.
+	maxTagDepth = 20
+)
+
+var (
+	pseudoRefRegexp = regexp.MustCompile(`^[A-Z_]+$`)
+
+	// ErrUnknownRevision is returned for revisions that don't name an object.
+	ErrUnknownRevision = errors.New("unknown revision")
+
+	refLookupOrder = []string{
+		"%s",
+		"refs/%s",
+		"refs/tags/%s",
+		"refs/heads/%s",
+		"refs/remotes/%s",
+		"refs/remotes/%s/HEAD",
+	}
+)
+
+// Resolve turns a revision into an object ID. Supported forms are HEAD (or @),
+// full object IDs, ref names, reflog selectors (<ref>@{<n>} and
+// <ref>@{<date>}), and any of those followed by ^{tree}, ^{commit} or ^{}.
+func Resolve(repo *repository.Repo, rev string) (oid string, err error) {
+	name, peel, hasPeel := rev, "", false
+	if open := strings.Index(rev, "^{"); open != -1 && strings.HasSuffix(rev, "}") {
+		name, peel, hasPeel = rev[:open], rev[open+2:len(rev)-1], true
+	}
+
+	if refName, selector, ok := SplitReflogSelector(name); ok {
+		oid, err = resolveReflogSelector(repo, refName, selector)
+		if err != nil {
+			return "", err
+		}
+	} else if repo.ObjectFormat().IsOID(name) {
+		oid = name
+	} else {
+		fullName := ExpandRef(repo, name)
+		if fullName == "" {
+			return "", fmt.Errorf("%w: '%s'", ErrUnknownRevision, rev)
+		}
+		oid, err = repo.Refs().ReadRef(fullName)
+		if err != nil {
+			return "", fmt.Errorf("error reading %s: %w", fullName, err)
+		}
+		if oid == "" {
+			return "", fmt.Errorf("%w: '%s'", ErrUnknownRevision, rev)
+		}
+	}
+
+	if !hasPeel {
+		return oid, nil
+	}
+
+	peeled, err := PeelTags(repo, oid)
+	if err != nil {
+		return "", err
+	}
+	if peeled != "" {
+		oid = peeled
+	}
+
+	switch peel {
+	case "":
+		return oid, nil
+	case typeTree:
+		return PeelToTree(repo, oid)
+	case ref.TypeCommit:
+		return peelToCommit(repo, oid)
+	}
+
+	return "", fmt.Errorf("%w: '%s'", ErrUnknownRevision, rev)
+}
+
+// ExpandRef turns a possibly abbreviated ref name into the full name of an
+// existing ref (or one with a reflog), using git's lookup order. It returns ""
+// if there is no such ref.
+func ExpandRef(repo *repository.Repo, name string) string {
+	if name == "" {
+		return ""
+	}
+	if name == "@" {
+		name = head
+	}
+
+	refs := repo.Refs()
+	for _, candidate := range RefCandidates(name) {
+		if oid, err := refs.ReadRef(candidate); (err == nil && oid != "") || refs.HasReflog(candidate) {
+			return candidate
+		}
+	}
+
+	return ""
+}
+
+// RefCandidates returns the full ref names an abbreviated name could stand
+// for, in git's lookup order.
+func RefCandidates(name string) []string {
+	var result []string
+	for i, format := range refLookupOrder {
+		// only pseudo-refs like HEAD live directly in the git directory
+		if i == 0 && !strings.HasPrefix(name, "refs/") && !pseudoRefRegexp.MatchString(name) {
+			continue
+		}
+		result = append(result, fmt.Sprintf(format, name))
+	}
+
+	return result
+}
+
+// ShortenRef returns the shortest abbreviation of a full ref name that still
+// expands to it, such as master for refs/heads/master.
+func ShortenRef(repo *repository.Repo, name string) string {
+	refs := repo.Refs()
+
+	// try the most specific abbreviations first
+	for i := len(refLookupOrder) - 1; i > 0; i-- {
+		prefix, suffix := splitLookupRule(refLookupOrder[i])
+		if len(name) <= len(prefix)+len(suffix) || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		short := name[len(prefix) : len(name)-len(suffix)]
+
+		// the abbreviation is ambiguous if a rule that's tried earlier
+		// expands it to a different existing ref
+		ambiguous := false
+		for j := 0; j < i && !ambiguous; j++ {
+			candidate := fmt.Sprintf(refLookupOrder[j], short)
+			if j == 0 && !pseudoRefRegexp.MatchString(short) {
+				continue
+			}
+			oid, err := refs.ReadRef(candidate)
+			ambiguous = err == nil && oid != ""
+		}
+		if !ambiguous {
+			return short
+		}
+	}
+
+	return name
+}
+
+func splitLookupRule(rule string) (prefix string, suffix string) {
+	verb := strings.Index(rule, "%s")
+	return rule[:verb], rule[verb+2:]
+}
+
+// SplitReflogSelector splits a revision like master@{2} into the ref name and
+// the text between the braces. An empty ref name means the current branch.
+func SplitReflogSelector(rev string) (name string, selector string, ok bool) {
+	open := strings.LastIndex(rev, "@{")
+	if open == -1 || !strings.HasSuffix(rev, "}") {
+		return "", "", false
+	}
+
+	return rev[:open], rev[open+2 : len(rev)-1], true
+}
+
+// ReflogRefName returns the full name of the ref whose reflog a selector
+// refers to. With no name that's the branch HEAD points to, or HEAD itself if
+// it's detached.
+func ReflogRefName(repo *repository.Repo, name string) (string, error) {
+	if name != "" {
+		fullName := ExpandRef(repo, name)
+		if fullName == "" {
+			return "", fmt.Errorf("%w: '%s'", ErrUnknownRevision, name)
+		}
+		return fullName, nil
+	}
+
+	target, err := repo.Refs().ReadSymbolicRef(head)
+	if err != nil {
+		return "", fmt.Errorf("error reading HEAD: %w", err)
+	}
+	if target == "" {
+		return head, nil
+	}
+
+	return target, nil
+}
+
+func resolveReflogSelector(repo *repository.Repo, name string, selector string) (string, error) {
+	refName, err := ReflogRefName(repo, name)
+	if err != nil {
+		return "", err
+	}
+
+	entries, err := repo.Refs().Reflog(refName)
+	if err != nil {
+		return "", err
+	}
+	if len(entries) == 0 {
+		return "", fmt.Errorf("%w: no reflog for '%s'", ErrUnknownRevision, refName)
+	}
+
+	if n, err := strconv.Atoi(selector); err == nil && n >= 0 {
+		if n >= len(entries) {
+			return "", fmt.Errorf("%w: log for '%s' only has %d entries", ErrUnknownRevision, refName, len(entries))
+		}
+		return entries[len(entries)-1-n].NewOID, nil
+	}
+
+	date, err := ParseDate(selector, time.Now())
+	if err != nil {
+		return "", fmt.Errorf("%w: '%s@{%s}'", ErrUnknownRevision, name, selector)
+	}
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		if !entries[i].Committer.Time.After(date) {
+			return entries[i].NewOID, nil
+		}
+	}
+
+	// the date predates the log, so the best answer is what the ref was
+	// before its first recorded update
+	oldest := entries[0]
+	if !objectformat.IsZeroOID(oldest.OldOID) {
+		return oldest.OldOID, nil
+	}
+
+	return oldest.NewOID, nil
+}
+
+// PeelTags follows annotated tags to the object they ultimately point to. It
+// returns "" if oid isn't a tag.
+func PeelTags(repo *repository.Repo, oid string) (result string, err error) {
+	for depth := 0; depth < maxTagDepth; depth++ {
+		obj, err := repo.Database().Read(oid)
+		if err != nil {
+			return "", fmt.Errorf("%w: '%s'", ErrUnknownRevision, oid)
+		}
+		if obj.Type() != typeTag {
+			return result, nil
+		}
+
+		var target string
+		for _, line := range strings.Split(string(obj.Serialize()), "\n") {
+			if line == "" {
+				break
+			}
+			if strings.HasPrefix(line, "object ") {
+				target = strings.TrimPrefix(line, "object ")
+				break
+			}
+		}
+		if !repo.ObjectFormat().IsOID(target) {
+			return "", fmt.Errorf("tag %s has no valid object header", oid)
+		}
+		oid, result = target, target
+	}
+
+	return "", errors.New("tags nested too deeply")
+}
+
+// PeelToTree returns the tree a tree-ish object (a tree or a commit) refers to.
+func PeelToTree(repo *repository.Repo, oid string) (string, error) {
+	obj, err := repo.Database().Read(oid)
+	if err != nil {
+		return "", fmt.Errorf("%w: '%s'", ErrUnknownRevision, oid)
+	}
+
+	switch obj.Type() {
+	case typeTree:
+		return oid, nil
+	case ref.TypeCommit:
+		commit, err := ref.DeserializeCommit(obj.Serialize())
+		if err != nil {
+			return "", fmt.Errorf("error parsing commit %s: %w", oid, err)
+		}
+		return commit.TreeOID, nil
+	}
+
+	return "", fmt.Errorf("object %s is a %s, not a tree", oid, obj.Type())
+}
+
+func peelToCommit(repo *repository.Repo, oid string) (string, error) {
+	obj, err := repo.Database().Read(oid)
+	if err != nil {
+		return "", fmt.Errorf("%w: '%s'", ErrUnknownRevision, oid)
+	}
+
+	if obj.Type() != ref.TypeCommit {
+		return "", fmt.Errorf("object %s is a %s, not a commit", oid, obj.Type())
+	}
+
+	return oid, nil
+}
+
+// ReadTree reads and parses a tree object. The tree may be shared with other
+// readers through the object cache, so it must not be modified.
+func ReadTree(repo *repository.Repo, oid string) (*tree.Tree, error) {
+	t, err := object.ReadDecoded(repo.Database(), oid, func(obj object.Storable) (interface{}, error) {
+		if obj.Type() != typeTree {
+			return nil, fmt.Errorf("object %s is a %s, not a tree", oid, obj.Type())
+		}
+		return tree.DeserializeTree(obj.Serialize(), repo.ObjectFormat())
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading tree %s: %w", oid, err)
+	}
+
+	return t.(*tree.Tree), nil
+}
@@ -12,6 +12,7 @@ import (
 	"strings"
 
 	"github.com/neocortical/got/index"
+	"github.com/neocortical/got/objectformat"
 )
 
 const (
@@ -61,7 +62,9 @@ func (t *Tree) Serialize() []byte {
 	return buf.Bytes()
 }
 
-func DeserializeTree(data []byte) (result *Tree, err error) {
+// DeserializeTree parses a tree whose entries hold binary object IDs of the
+// given format.
+func DeserializeTree(data []byte, f *objectformat.Format) (result *Tree, err error) {
 	result = &Tree{
 		entries: map[string]Node{},
 	}
@@ -70,7 +73,7 @@ func DeserializeTree(data []byte) (result *Tree, err error) {
 	var node Node
 
 	for err == nil {
-		node, err = deserializeNode(r)
+		node, err = deserializeNode(r, f)
 		if err == nil {
 			result.entries[node.Name()] = node
 		}
@@ -81,8 +84,11 @@ func DeserializeTree(data []byte) (result *Tree, err error) {
 	return
 }
 
-func deserializeNode(r *bufio.Reader) (result Node, err error) {
+func deserializeNode(r *bufio.Reader, f *objectformat.Format) (result Node, err error) {
 	header, err := r.ReadString('\x00')
+	if err == io.EOF && header != "" {
+		return result, errors.New("invalid tree node format")
+	}
 	if err != nil {
 		return
 	}
@@ -95,14 +101,14 @@ func deserializeNode(r *bufio.Reader) (result Node, err error) {
 
 	name := header[divider+1 : len(header)-1]
 
-	var oidBuf = make([]byte, 20)
-	n, err := r.Read(oidBuf)
+	var oidBuf = make([]byte, f.Size())
+	_, err = io.ReadFull(r, oidBuf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return result, errors.New("invalid tree node format")
+	}
 	if err != nil {
 		return
 	}
-	if n != 20 {
-		return result, errors.New("invalid tree node format")
-	}
 
 	oid := hex.EncodeToString(oidBuf)
 
@@ -162,20 +168,28 @@ func (t *Tree) AddEntry(parents []string, e *index.Entry) (err error) {
 	return
 }
 
+// Entries returns the tree's nodes in git's order, which sorts subtrees as if
+// their names ended in a slash.
 func (t Tree) Entries() (result []Node) {
-	var names []string
-	for k := range t.entries {
-		names = append(names, k)
+	for _, node := range t.entries {
+		result = append(result, node)
 	}
 
-	sort.Strings(names)
-	for _, name := range names {
-		result = append(result, t.entries[name])
-	}
+	sort.Slice(result, func(i, j int) bool {
+		return sortKey(result[i]) < sortKey(result[j])
+	})
 
 	return
 }
 
+func sortKey(node Node) string {
+	if node.ModeString() == dirModeString {
+		return node.Name() + "/"
+	}
+
+	return node.Name()
+}
+
 func (t *Tree) Traverse(store func(*Tree) (string, error)) (err error) {
 	for _, node := range t.Entries() {
 		switch n := node.(type) {
@@ -190,3 +204,46 @@ func (t *Tree) Traverse(store func(*Tree) (string, error)) (err error) {
 	t.oid, err = store(t)
 	return
 }
+
+// TraverseCached stores the tree like Traverse, except that subtrees the
+// index's cache tree already knows the OID of are not rebuilt. Newly stored
+// subtrees are recorded in the cache tree so the next traversal can skip them.
+func (t *Tree) TraverseCached(ct *index.CacheTree, store func(*Tree) (string, error)) (err error) {
+	if ct.Valid() {
+		t.oid = ct.OID()
+		return
+	}
+
+	var subtreeNames []string
+	for _, node := range t.Entries() {
+		switch n := node.(type) {
+		case *Tree:
+			err = n.TraverseCached(ct.Subtree(n.name), store)
+			if err != nil {
+				return
+			}
+			subtreeNames = append(subtreeNames, n.name)
+		}
+	}
+
+	t.oid, err = store(t)
+	if err != nil {
+		return
+	}
+
+	ct.Update(t.oid, t.entryCount(), subtreeNames)
+	return
+}
+
+// entryCount returns the number of index entries beneath the tree.
+func (t *Tree) entryCount() (result int) {
+	for _, node := range t.entries {
+		if subtree, ok := node.(*Tree); ok {
+			result += subtree.entryCount()
+		} else {
+			result++
+		}
+	}
+
+	return
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/hex"
 	"fmt"
 	"testing"
+
+	"github.com/neocortical/got/objectformat"
 )
 
 func TestDeserializeTree(t *testing.T) {
@@ -12,7 +14,7 @@ func TestDeserializeTree(t *testing.T) {
 		t.Fatalf("error creating test data from hex string: %v", err)
 	}
 
-	actual, err := DeserializeTree(data)
+	actual, err := DeserializeTree(data, objectformat.SHA1)
 	if err != nil {
 		t.Errorf("expected nil error but got: %v", err)
 	}
@@ -68,3 +70,42 @@ func TestDeserializeTree(t *testing.T) {
 		}
 	}
 }
+
+func TestEntriesSortSubtreesWithTrailingSlash(t *testing.T) {
+	tr := &Tree{entries: map[string]Node{
+		"foo":     &Tree{name: "foo", entries: map[string]Node{}},
+		"foo.txt": stubNode{name: "foo.txt", mode: "100644"},
+		"foo-bar": stubNode{name: "foo-bar", mode: "100644"},
+		"foo0":    stubNode{name: "foo0", mode: "100644"},
+	}}
+
+	var names []string
+	for _, node := range tr.Entries() {
+		names = append(names, node.Name())
+	}
+
+	expected := "[foo-bar foo.txt foo foo0]"
+	if fmt.Sprint(names) != expected {
+		t.Errorf("expected entries in order %s but got: %v", expected, names)
+	}
+}
+
+func TestDeserializeTreeSHA256(t *testing.T) {
+	oid := objectformat.SHA256.Sum([]byte("blob 0\x00"))
+	tr := &Tree{entries: map[string]Node{
+		"a.txt": stubNode{name: "a.txt", mode: "100644", oid: oid},
+		"dir":   &Tree{name: "dir", oid: oid, entries: map[string]Node{}},
+	}}
+
+	actual, err := DeserializeTree(tr.Serialize(), objectformat.SHA256)
+	if err != nil {
+		t.Fatalf("expected nil error but got: %v", err)
+	}
+	if len(actual.entries) != 2 || actual.entries["a.txt"].OID() != oid || actual.entries["dir"].OID() != oid {
+		t.Errorf("unexpected entries: %v", actual.entries)
+	}
+
+	if _, err = DeserializeTree(tr.Serialize(), objectformat.SHA1); err == nil {
+		t.Error("expected an error reading SHA-256 IDs as SHA-1")
+	}
+}
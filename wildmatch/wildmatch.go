@@ -0,0 +1,203 @@
+package wildmatch
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Flags alter how patterns are matched.
+type Flags int
+
+const (
+	// PathName makes wildcards stop at slashes, so that '*' and '?' only match
+	// within a single path component. A "**" component then matches any
+	// number of directories.
+	PathName Flags = 1 << iota
+	// CaseFold matches letters case insensitively.
+	CaseFold
+)
+
+// Match reports whether text matches a glob pattern using git's wildmatch
+// rules: '*', '?', '[...]' classes (with '!' or '^' negation, ranges and
+// [:name:] character classes) and backslash escapes.
+func Match(pattern, text string, flags Flags) bool {
+	if flags&CaseFold != 0 {
+		pattern = strings.ToLower(pattern)
+		text = strings.ToLower(text)
+	}
+
+	return match(pattern, text, flags, true)
+}
+
+// HasWildcards reports whether a pattern contains any glob special characters.
+func HasWildcards(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[\\")
+}
+
+func match(p, t string, flags Flags, atSegmentStart bool) bool {
+	pathName := flags&PathName != 0
+
+	for len(p) > 0 {
+		c := p[0]
+		switch c {
+		case '\\':
+			if len(p) < 2 || len(t) == 0 || t[0] != p[1] {
+				return false
+			}
+			p, t = p[2:], t[1:]
+
+		case '?':
+			if len(t) == 0 || (pathName && t[0] == '/') {
+				return false
+			}
+			p, t = p[1:], t[1:]
+
+		case '*':
+			if pathName && strings.HasPrefix(p, "**") && atSegmentStart && (len(p) == 2 || p[2] == '/') {
+				if len(p) == 2 {
+					return true
+				}
+
+				// "**/" matches zero or more leading directories
+				rest := p[3:]
+				for {
+					if match(rest, t, flags, true) {
+						return true
+					}
+					slash := strings.IndexByte(t, '/')
+					if slash == -1 {
+						return false
+					}
+					t = t[slash+1:]
+				}
+			}
+
+			for len(p) > 0 && p[0] == '*' {
+				p = p[1:]
+			}
+			if len(p) == 0 {
+				return !pathName || strings.IndexByte(t, '/') == -1
+			}
+
+			for i := 0; i <= len(t); i++ {
+				if match(p, t[i:], flags, false) {
+					return true
+				}
+				if i < len(t) && pathName && t[i] == '/' {
+					return false
+				}
+			}
+			return false
+
+		case '[':
+			if len(t) == 0 || (pathName && t[0] == '/') {
+				return false
+			}
+			matched, length, ok := matchClass(p, rune(t[0]))
+			if !ok {
+				// an unterminated class matches a literal '['
+				if t[0] != '[' {
+					return false
+				}
+				p, t = p[1:], t[1:]
+				break
+			}
+			if !matched {
+				return false
+			}
+			p, t = p[length:], t[1:]
+
+		default:
+			if len(t) == 0 || t[0] != c {
+				return false
+			}
+			p, t = p[1:], t[1:]
+		}
+
+		atSegmentStart = len(p) > 0 && c == '/'
+	}
+
+	return len(t) == 0
+}
+
+// matchClass matches a single character against the bracket expression at the
+// start of p, returning the length of the expression.
+func matchClass(p string, c rune) (matched bool, length int, ok bool) {
+	i := 1
+	negated := false
+	if i < len(p) && (p[i] == '!' || p[i] == '^') {
+		negated = true
+		i++
+	}
+
+	for first := true; i < len(p); first = false {
+		if p[i] == ']' && !first {
+			return matched != negated, i + 1, true
+		}
+
+		if strings.HasPrefix(p[i:], "[:") {
+			end := strings.Index(p[i+2:], ":]")
+			if end != -1 {
+				if matchNamedClass(p[i+2:i+2+end], c) {
+					matched = true
+				}
+				i += end + 4
+				continue
+			}
+		}
+
+		lo := rune(p[i])
+		if lo == '\\' && i+1 < len(p) {
+			i++
+			lo = rune(p[i])
+		}
+		i++
+
+		hi := lo
+		if i+1 < len(p) && p[i] == '-' && p[i+1] != ']' {
+			hi = rune(p[i+1])
+			i += 2
+			if hi == '\\' && i < len(p) {
+				hi = rune(p[i])
+				i++
+			}
+		}
+
+		if c >= lo && c <= hi {
+			matched = true
+		}
+	}
+
+	return false, 0, false
+}
+
+func matchNamedClass(name string, c rune) bool {
+	switch name {
+	case "alnum":
+		return unicode.IsLetter(c) || unicode.IsDigit(c)
+	case "alpha":
+		return unicode.IsLetter(c)
+	case "blank":
+		return c == ' ' || c == '\t'
+	case "cntrl":
+		return unicode.IsControl(c)
+	case "digit":
+		return unicode.IsDigit(c)
+	case "graph":
+		return unicode.IsGraphic(c) && c != ' '
+	case "lower":
+		return unicode.IsLower(c)
+	case "print":
+		return unicode.IsPrint(c)
+	case "punct":
+		return unicode.IsPunct(c) || unicode.IsSymbol(c)
+	case "space":
+		return unicode.IsSpace(c)
+	case "upper":
+		return unicode.IsUpper(c)
+	case "xdigit":
+		return strings.ContainsRune("0123456789abcdefABCDEF", c)
+	}
+
+	return false
+}
@@ -0,0 +1,55 @@
+package wildmatch
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	var tests = []struct {
+		pattern  string
+		text     string
+		flags    Flags
+		expected bool
+	}{
+		{"foo", "foo", 0, true},
+		{"foo", "bar", 0, false},
+		{"", "", 0, true},
+		{"???", "foo", 0, true},
+		{"??", "foo", 0, false},
+		{"*", "foo", 0, true},
+		{"f*", "foo", 0, true},
+		{"*f", "foo", 0, false},
+		{"*foo*", "foo", 0, true},
+		{"*ob*a*r*", "foobar", 0, true},
+		{"*ab", "aaaaaaabababab", 0, true},
+		{"foo\\*", "foo*", 0, true},
+		{"foo\\*bar", "foobar", 0, false},
+		{"[ab]", "a", 0, true},
+		{"[!ab]", "a", 0, false},
+		{"[^ab]", "c", 0, true},
+		{"[a-c]x", "bx", 0, true},
+		{"[]]", "]", 0, true},
+		{"[[:digit:]]*", "9lives", 0, true},
+		{"[[:upper:]]", "a", 0, false},
+		{"[", "[", 0, true},
+		{"*.c", "dir/file.c", 0, true},
+		{"*.c", "dir/file.c", PathName, false},
+		{"dir/*.c", "dir/file.c", PathName, true},
+		{"dir/?", "dir//", PathName, false},
+		{"**/foo", "foo", PathName, true},
+		{"**/foo", "a/b/foo", PathName, true},
+		{"a/**/b", "a/b", PathName, true},
+		{"a/**/b", "a/x/y/b", PathName, true},
+		{"a/**", "a/x/y", PathName, true},
+		{"a/**", "b/x", PathName, false},
+		{"a**b", "a/b", PathName, false},
+		{"a**b", "axxb", PathName, true},
+		{"FOO", "foo", CaseFold, true},
+		{"[A-Z]", "q", CaseFold, true},
+	}
+
+	for i, test := range tests {
+		actual := Match(test.pattern, test.text, test.flags)
+		if actual != test.expected {
+			t.Errorf("test %d failed: Match(%q, %q) expected %v but got %v", i, test.pattern, test.text, test.expected, actual)
+		}
+	}
+}
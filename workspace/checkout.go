@@ -0,0 +1,139 @@
+package workspace
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/neocortical/got/index"
+	"github.com/neocortical/got/object"
+	"github.com/neocortical/got/objectformat"
+	"github.com/neocortical/got/tree"
+)
+
+const (
+	modeTree       = "40000"
+	modeExecutable = "100755"
+	modeGitlink    = "160000"
+)
+
+// Checkout writes the files of a tree into the workspace at dir, adding an
+// entry for each to idx, which the caller must have loaded for update. f is
+// the format of the database's object IDs. Symbolic links are written as
+// plain files holding their targets, and submodules as empty directories.
+func Checkout(db object.Database, f *objectformat.Format, treeOID string, dir string, idx index.Index) error {
+	return checkoutTree(db, f, treeOID, dir, "", idx)
+}
+
+func checkoutTree(db object.Database, f *objectformat.Format, treeOID string, dir string, prefix string, idx index.Index) error {
+	obj, err := db.Read(treeOID)
+	if err != nil {
+		return fmt.Errorf("error reading tree %s: %w", treeOID, err)
+	}
+	t, err := tree.DeserializeTree(obj.Serialize(), f)
+	if err != nil {
+		return fmt.Errorf("error parsing tree %s: %w", treeOID, err)
+	}
+
+	for _, node := range t.Entries() {
+		name := path.Join(prefix, node.Name())
+		filename := filepath.Join(dir, filepath.FromSlash(name))
+
+		switch node.ModeString() {
+		case modeTree:
+			if err = os.MkdirAll(filename, 0755); err != nil {
+				return fmt.Errorf("error creating directory '%s': %w", name, err)
+			}
+			err = checkoutTree(db, f, node.OID(), dir, name, idx)
+		case modeGitlink:
+			err = os.MkdirAll(filename, 0755)
+		default:
+			err = checkoutFile(db, node, filename, name, idx)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CheckoutFile writes the blob of a tree entry into the workspace at dir as
+// the file name, making way for it by creating its parent directories and
+// removing any file or directory in its place, and adds an entry for it to
+// idx.
+func CheckoutFile(db object.Database, node tree.Node, dir string, name string, idx index.Index) error {
+	filename := filepath.Join(dir, filepath.FromSlash(name))
+
+	parent := dir
+	for _, part := range strings.Split(path.Dir(name), "/") {
+		if part == "." {
+			break
+		}
+		parent = filepath.Join(parent, part)
+		if info, err := os.Lstat(parent); err == nil && !info.IsDir() {
+			if err = os.Remove(parent); err != nil {
+				return fmt.Errorf("error removing '%s': %w", parent, err)
+			}
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return fmt.Errorf("error creating directory for '%s': %w", name, err)
+	}
+	if info, err := os.Lstat(filename); err == nil && info.IsDir() {
+		if err = os.RemoveAll(filename); err != nil {
+			return fmt.Errorf("error removing '%s': %w", name, err)
+		}
+	}
+
+	return checkoutFile(db, node, filename, name, idx)
+}
+
+// RemoveFile deletes the file name from the workspace at dir, if it's there,
+// along with any of its parent directories it leaves empty.
+func RemoveFile(dir string, name string) error {
+	filename := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error removing '%s': %w", name, err)
+	}
+
+	for parent := filepath.Dir(filename); parent != filepath.Clean(dir); parent = filepath.Dir(parent) {
+		if os.Remove(parent) != nil {
+			break
+		}
+	}
+
+	return nil
+}
+
+func checkoutFile(db object.Database, node tree.Node, filename string, name string, idx index.Index) error {
+	blob, err := db.Read(node.OID())
+	if err != nil {
+		return fmt.Errorf("error reading blob %s for '%s': %w", node.OID(), name, err)
+	}
+
+	perm := os.FileMode(0644)
+	if node.ModeString() == modeExecutable {
+		perm = 0755
+	}
+
+	if err = ioutil.WriteFile(filename, blob.Serialize(), perm); err != nil {
+		return fmt.Errorf("error writing '%s': %w", name, err)
+	}
+
+	// the umask may have masked the executable bits
+	if err = os.Chmod(filename, perm); err != nil {
+		return fmt.Errorf("error setting mode of '%s': %w", name, err)
+	}
+
+	stat, err := os.Stat(filename)
+	if err != nil {
+		return fmt.Errorf("error reading '%s': %w", name, err)
+	}
+
+	idx.Add(index.NewEntry(name, node.OID(), stat))
+	return nil
+}
@@ -0,0 +1,128 @@
+package workspace
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"sync"
+
+	"github.com/neocortical/got/blob"
+	"github.com/neocortical/got/object"
+)
+
+// File is a workspace file to be hashed.
+type File struct {
+	Path string
+	Info os.FileInfo
+}
+
+// HashResult is the outcome of reading, hashing and storing a single File.
+type HashResult struct {
+	File
+	OID string
+	Err error
+}
+
+// hashWindowPerJob is how many files each worker may be ahead of the results
+// handed out.
+const hashWindowPerJob = 4
+
+// DefaultJobs is the number of hashing workers used when none is configured.
+func DefaultJobs() int {
+	return runtime.NumCPU()
+}
+
+// HashFiles reads each file, stores it as a blob and hands the result to fn.
+// Up to jobs files are read and hashed concurrently, and no more than a few
+// per worker ahead of the results fn has taken, but fn is always called
+// from the calling goroutine and in the same order as files, so callers can
+// mutate the index without locking and produce deterministic output. If fn
+// returns an error, hashing stops and that error is returned.
+func HashFiles(db object.Database, files []File, jobs int, fn func(HashResult) error) (err error) {
+	if jobs < 1 {
+		jobs = DefaultJobs()
+	}
+	if jobs > len(files) {
+		jobs = len(files)
+	}
+
+	if jobs <= 1 {
+		for _, f := range files {
+			if err = fn(hashFile(db, f)); err != nil {
+				return
+			}
+		}
+		return
+	}
+
+	// results wait in a ring of slots, so workers only run so far ahead of
+	// fn however many files there are
+	window := jobs * hashWindowPerJob
+	results := make([]chan HashResult, window)
+	for i := range results {
+		results[i] = make(chan HashResult, 1)
+	}
+	slots := make(chan struct{}, window)
+
+	work := make(chan int)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	defer func() {
+		close(done)
+		wg.Wait()
+	}()
+
+	go func() {
+		defer close(work)
+		for i := range files {
+			select {
+			case slots <- struct{}{}:
+			case <-done:
+				return
+			}
+			select {
+			case work <- i:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for w := 0; w < jobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				results[i%window] <- hashFile(db, files[i])
+			}
+		}()
+	}
+
+	for i := range files {
+		result := <-results[i%window]
+		<-slots
+		if err = fn(result); err != nil {
+			return
+		}
+	}
+
+	return nil
+}
+
+func hashFile(db object.Database, f File) (result HashResult) {
+	result.File = f
+
+	data, err := ioutil.ReadFile(f.Path)
+	if err != nil {
+		result.Err = fmt.Errorf("error reading file '%s': %w", f.Path, err)
+		return
+	}
+
+	result.OID, err = db.Store(blob.New(data))
+	if err != nil {
+		result.Err = fmt.Errorf("error storing blob '%s': %w", f.Path, err)
+	}
+
+	return
+}
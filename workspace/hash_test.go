@@ -0,0 +1,258 @@
+package workspace
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/neocortical/got/blob"
+	"github.com/neocortical/got/object"
+	"github.com/neocortical/got/objectformat"
+)
+
+func setUpTestFiles(tb testing.TB, count, size int) (dir string, files []File, db object.Database) {
+	dir, err := ioutil.TempDir("", "got_test_workspace_*")
+	if err != nil {
+		tb.Fatalf("error creating temp dir: %v", err)
+	}
+
+	data := make([]byte, size)
+	for i := 0; i < count; i++ {
+		filename := filepath.Join(dir, fmt.Sprintf("dir%02d", i%10), fmt.Sprintf("file%05d.txt", i))
+		copy(data, fmt.Sprintf("file number %d", i))
+
+		if err = os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			tb.Fatalf("error creating dir: %v", err)
+		}
+		if err = ioutil.WriteFile(filename, data, 0644); err != nil {
+			tb.Fatalf("error writing file: %v", err)
+		}
+	}
+
+	files, err = ListFiles(dir)
+	if err != nil {
+		tb.Fatalf("error listing files: %v", err)
+	}
+
+	return dir, files, object.NewDatabase(filepath.Join(dir, ".git", "objects"))
+}
+
+func TestHashFilesPreservesOrder(t *testing.T) {
+	dir, files, db := setUpTestFiles(t, 200, 64)
+	defer os.RemoveAll(dir)
+
+	if len(files) != 200 {
+		t.Fatalf("expected 200 files but got %d", len(files))
+	}
+
+	var serial []string
+	err := HashFiles(db, files, 1, func(r HashResult) error {
+		serial = append(serial, r.Path+" "+r.OID)
+		return r.Err
+	})
+	if err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+
+	var parallel []string
+	err = HashFiles(db, files, 8, func(r HashResult) error {
+		parallel = append(parallel, r.Path+" "+r.OID)
+		return r.Err
+	})
+	if err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+
+	if len(serial) != len(parallel) {
+		t.Fatalf("expected %d results but got %d", len(serial), len(parallel))
+	}
+	for i := range serial {
+		if serial[i] != parallel[i] {
+			t.Errorf("result %d differs: expected '%s' but got '%s'", i, serial[i], parallel[i])
+		}
+	}
+}
+
+// writeTestPack writes a pack holding one blob, and its index, into an object
+// database directory, as a clone would leave it.
+func writeTestPack(t *testing.T, objectsDir string) {
+	f := objectformat.Default
+	mem := object.NewMemoryDatabase()
+	oid, _ := mem.Store(blob.New([]byte("packed")))
+
+	var pack bytes.Buffer
+	if err := object.WritePack(&pack, f, mem, []string{oid}); err != nil {
+		t.Fatalf("error writing pack: %v", err)
+	}
+	packSum := pack.Bytes()[pack.Len()-f.Size():]
+
+	// a version 2 index: fanout table, then the ID, CRC and offset of the
+	// one object, then the checksums
+	raw, _ := hex.DecodeString(oid)
+	var idx bytes.Buffer
+	idx.WriteString("\377tOc")
+	binary.Write(&idx, binary.BigEndian, uint32(2))
+	for i := 0; i < 256; i++ {
+		count := uint32(0)
+		if i >= int(raw[0]) {
+			count = 1
+		}
+		binary.Write(&idx, binary.BigEndian, count)
+	}
+	idx.Write(raw)
+	binary.Write(&idx, binary.BigEndian, uint32(0))
+	binary.Write(&idx, binary.BigEndian, uint32(12))
+	idx.Write(packSum)
+	idx.Write(make([]byte, f.Size()))
+
+	name := filepath.Join(objectsDir, object.PackDir, "pack-"+hex.EncodeToString(packSum))
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		t.Fatalf("error creating pack dir: %v", err)
+	}
+	ioutil.WriteFile(name+".pack", pack.Bytes(), 0644)
+	ioutil.WriteFile(name+".idx", idx.Bytes(), 0644)
+}
+
+// TestHashFilesWithPacks hashes files concurrently into a database whose
+// packs are opened while the objects are stored. Run with -race.
+func TestHashFilesWithPacks(t *testing.T) {
+	// the workers must really run at once for the race detector to see them
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(8))
+
+	dir, files, _ := setUpTestFiles(t, 200, 64)
+	defer os.RemoveAll(dir)
+
+	objectsDir := filepath.Join(dir, ".git", "objects")
+	writeTestPack(t, objectsDir)
+	db := object.NewDatabase(objectsDir)
+
+	err := HashFiles(db, files, 8, func(r HashResult) error {
+		return r.Err
+	})
+	if err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	if !db.Has(object.HashObject(objectformat.Default, blob.New([]byte("packed")))) {
+		t.Error("expected the packed blob to be found")
+	}
+}
+
+func TestHashFilesStopsOnError(t *testing.T) {
+	dir, files, db := setUpTestFiles(t, 50, 64)
+	defer os.RemoveAll(dir)
+
+	stop := errors.New("stop")
+	var calls int
+	err := HashFiles(db, files, 4, func(r HashResult) error {
+		calls++
+		if calls == 10 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Errorf("expected the callback's error but got: %v", err)
+	}
+	if calls != 10 {
+		t.Errorf("expected hashing to stop after 10 results but got %d", calls)
+	}
+}
+
+// countingDatabase counts the objects stored in it.
+type countingDatabase struct {
+	object.Database
+	stored int32
+}
+
+func (db *countingDatabase) Store(s object.Storable) (string, error) {
+	atomic.AddInt32(&db.stored, 1)
+	return db.Database.Store(s)
+}
+
+func TestHashFilesBoundsWorkAhead(t *testing.T) {
+	dir, files, _ := setUpTestFiles(t, 200, 64)
+	defer os.RemoveAll(dir)
+
+	db := &countingDatabase{Database: object.NewMemoryDatabase()}
+	jobs := 4
+	var ahead int32
+	err := HashFiles(db, files, jobs, func(r HashResult) error {
+		if r.Path == files[0].Path {
+			// give the workers time to run as far ahead as they can
+			time.Sleep(50 * time.Millisecond)
+			ahead = atomic.LoadInt32(&db.stored)
+		}
+		return r.Err
+	})
+	if err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	if limit := int32(jobs*hashWindowPerJob + 1); ahead > limit {
+		t.Errorf("expected at most %d files hashed ahead but got %d", limit, ahead)
+	}
+	if db.stored != int32(len(files)) {
+		t.Errorf("expected %d files to be hashed but got %d", len(files), db.stored)
+	}
+}
+
+func TestHashFilesReportsUnreadableFiles(t *testing.T) {
+	dir, files, db := setUpTestFiles(t, 5, 64)
+	defer os.RemoveAll(dir)
+
+	os.Remove(files[2].Path)
+
+	var failed []string
+	err := HashFiles(db, files, 4, func(r HashResult) error {
+		if r.Err != nil {
+			failed = append(failed, r.Path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("expected no error but got: %v", err)
+	}
+	if len(failed) != 1 || failed[0] != files[2].Path {
+		t.Errorf("expected only %s to fail but got %v", files[2].Path, failed)
+	}
+}
+
+func benchmarkHashFiles(b *testing.B, jobs int) {
+	dir, files, db := setUpTestFiles(b, 1000, 16*1024)
+	defer os.RemoveAll(dir)
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		err := HashFiles(db, files, jobs, func(r HashResult) error {
+			return r.Err
+		})
+		if err != nil {
+			b.Fatalf("expected no error but got: %v", err)
+		}
+	}
+}
+
+func BenchmarkHashFiles1(b *testing.B) { benchmarkHashFiles(b, 1) }
+func BenchmarkHashFiles2(b *testing.B) { benchmarkHashFiles(b, 2) }
+func BenchmarkHashFiles4(b *testing.B) { benchmarkHashFiles(b, 4) }
+func BenchmarkHashFiles8(b *testing.B) { benchmarkHashFiles(b, 8) }
+
+func BenchmarkListFiles(b *testing.B) {
+	dir, _, _ := setUpTestFiles(b, 1000, 16)
+	defer os.RemoveAll(dir)
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if _, err := ListFiles(dir); err != nil {
+			b.Fatalf("expected no error but got: %v", err)
+		}
+	}
+}
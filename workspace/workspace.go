@@ -0,0 +1,31 @@
+package workspace
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// ignoredDir is the repository directory, which is never part of the workspace.
+const ignoredDir = ".git"
+
+// ListFiles returns every file at or beneath path, in lexical order, skipping
+// the repository directory.
+func ListFiles(path string) (result []File, err error) {
+	err = filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			if info.Name() == ignoredDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		result = append(result, File{Path: p, Info: info})
+		return nil
+	})
+
+	return
+}